@@ -0,0 +1,159 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package base58 implements Base58Check encoding used by Tezos
+package base58
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var decodeMap [256]int8
+
+func init() {
+	for i := range decodeMap {
+		decodeMap[i] = -1
+	}
+	for i, c := range alphabet {
+		decodeMap[c] = int8(i)
+	}
+}
+
+// Errors
+var (
+	ErrInvalidCharacter = errors.New("base58: invalid character")
+	ErrChecksum         = errors.New("base58: invalid checksum")
+	ErrShortData        = errors.New("base58: data is too short")
+	ErrPrefix           = errors.New("base58: unexpected prefix")
+)
+
+var bigRadix = big.NewInt(58)
+
+// IsAlphabet returns true if the string consists of Base58 characters only
+func IsAlphabet(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if decodeMap[s[i]] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode encodes raw bytes
+func Encode(data []byte) string {
+	var x big.Int
+	x.SetBytes(data)
+
+	var (
+		res []byte
+		mod big.Int
+	)
+	for x.Sign() > 0 {
+		x.QuoRem(&x, bigRadix, &mod)
+		res = append(res, alphabet[mod.Int64()])
+	}
+
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		res = append(res, alphabet[0])
+	}
+
+	// Reverse
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+
+	return string(res)
+}
+
+// Decode decodes Base58 string to raw bytes
+func Decode(s string) ([]byte, error) {
+	var x big.Int
+	for i := 0; i < len(s); i++ {
+		v := decodeMap[s[i]]
+		if v < 0 {
+			return nil, ErrInvalidCharacter
+		}
+		x.Mul(&x, bigRadix)
+		x.Add(&x, big.NewInt(int64(v)))
+	}
+
+	var zeros int
+	for zeros < len(s) && s[zeros] == alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), x.Bytes()...), nil
+}
+
+func checksum(data []byte) []byte {
+	h := sha256.Sum256(data)
+	h = sha256.Sum256(h[:])
+	return h[:4]
+}
+
+// CheckEncode encodes the payload with the prefix and appends a checksum
+func CheckEncode(prefix, payload []byte) string {
+	buf := make([]byte, 0, len(prefix)+len(payload)+4)
+	buf = append(buf, prefix...)
+	buf = append(buf, payload...)
+	buf = append(buf, checksum(buf)...)
+	return Encode(buf)
+}
+
+// CheckDecode decodes Base58Check string and verifies the checksum. The result includes a prefix
+func CheckDecode(s string) ([]byte, error) {
+	buf, err := Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) < 4 {
+		return nil, ErrShortData
+	}
+
+	data, sum := buf[:len(buf)-4], buf[len(buf)-4:]
+	if !bytes.Equal(checksum(data), sum) {
+		return nil, ErrChecksum
+	}
+
+	return data, nil
+}
+
+// DecodePrefixed decodes Base58Check string and strips the expected prefix
+func DecodePrefixed(s string, prefix []byte) ([]byte, error) {
+	data, err := CheckDecode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, prefix) {
+		return nil, ErrPrefix
+	}
+
+	return data[len(prefix):], nil
+}
@@ -0,0 +1,199 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package base58
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Prefix describes a known Tezos Base58Check value type
+type Prefix struct {
+	Name       string
+	Tag        string // Human readable prefix of the encoded string
+	Bytes      []byte
+	PayloadLen int
+}
+
+// Known prefixes
+var (
+	PrefixBlockHash                       = &Prefix{"block hash", "B", []byte{1, 52}, 32}
+	PrefixOperationHash                   = &Prefix{"operation hash", "o", []byte{5, 116}, 32}
+	PrefixOperationListHash               = &Prefix{"operation list hash", "Lo", []byte{133, 233}, 32}
+	PrefixOperationListListHash           = &Prefix{"operation list list hash", "LLo", []byte{29, 159, 109}, 32}
+	PrefixProtocolHash                    = &Prefix{"protocol hash", "P", []byte{2, 170}, 32}
+	PrefixContextHash                     = &Prefix{"context hash", "Co", []byte{79, 199}, 32}
+	PrefixBlockMetadataHash               = &Prefix{"block metadata hash", "bm", []byte{234, 249}, 32}
+	PrefixOperationMetadataHash           = &Prefix{"operation metadata hash", "r", []byte{5, 183}, 32}
+	PrefixOperationMetadataListHash       = &Prefix{"operation metadata list hash", "Lr", []byte{134, 39}, 32}
+	PrefixOperationMetadataListListHash   = &Prefix{"operation metadata list list hash", "LLr", []byte{29, 159, 182}, 32}
+	PrefixEd25519PublicKeyHash            = &Prefix{"Ed25519 public key hash", "tz1", []byte{6, 161, 159}, 20}
+	PrefixSecp256k1PublicKeyHash          = &Prefix{"Secp256k1 public key hash", "tz2", []byte{6, 161, 161}, 20}
+	PrefixP256PublicKeyHash               = &Prefix{"P-256 public key hash", "tz3", []byte{6, 161, 164}, 20}
+	PrefixBLS12381PublicKeyHash           = &Prefix{"BLS12-381 public key hash", "tz4", []byte{6, 161, 166}, 20}
+	PrefixContractHash                    = &Prefix{"originated contract", "KT1", []byte{2, 90, 121}, 20}
+	PrefixTxRollupAddress                 = &Prefix{"transaction rollup address", "txr1", []byte{1, 128, 120, 31}, 20}
+	PrefixSmartRollupAddress              = &Prefix{"smart rollup address", "sr1", []byte{6, 124, 117}, 20}
+	PrefixSmartRollupCommitmentHash       = &Prefix{"smart rollup commitment hash", "src1", []byte{17, 165, 134, 138}, 32}
+	PrefixSmartRollupStateHash            = &Prefix{"smart rollup state hash", "srs1", []byte{17, 165, 235, 240}, 32}
+	PrefixCryptoboxPublicKeyHash          = &Prefix{"cryptobox public key hash", "id", []byte{153, 103}, 16}
+	PrefixEd25519Seed                     = &Prefix{"Ed25519 secret key seed", "edsk", []byte{13, 15, 58, 7}, 32}
+	PrefixEd25519SecretKey                = &Prefix{"Ed25519 secret key", "edsk", []byte{43, 246, 78, 7}, 64}
+	PrefixSecp256k1SecretKey              = &Prefix{"Secp256k1 secret key", "spsk", []byte{17, 162, 224, 201}, 32}
+	PrefixP256SecretKey                   = &Prefix{"P-256 secret key", "p2sk", []byte{16, 81, 238, 189}, 32}
+	PrefixBLS12381SecretKey               = &Prefix{"BLS12-381 secret key", "BLsk", []byte{3, 150, 192, 40}, 32}
+	PrefixEd25519EncryptedSeed            = &Prefix{"Ed25519 encrypted seed", "edesk", []byte{7, 90, 60, 179, 41}, 56}
+	PrefixSecp256k1EncryptedSecretKey     = &Prefix{"Secp256k1 encrypted secret key", "spesk", []byte{9, 237, 241, 174, 150}, 56}
+	PrefixP256EncryptedSecretKey          = &Prefix{"P-256 encrypted secret key", "p2esk", []byte{9, 48, 57, 115, 171}, 56}
+	PrefixBLS12381EncryptedSecretKey      = &Prefix{"BLS12-381 encrypted secret key", "BLesk", []byte{2, 5, 30, 53, 25}, 56}
+	PrefixEd25519PublicKey                = &Prefix{"Ed25519 public key", "edpk", []byte{13, 15, 37, 217}, 32}
+	PrefixSecp256k1PublicKey              = &Prefix{"Secp256k1 public key", "sppk", []byte{3, 254, 226, 86}, 33}
+	PrefixP256PublicKey                   = &Prefix{"P-256 public key", "p2pk", []byte{3, 178, 139, 127}, 33}
+	PrefixBLS12381PublicKey               = &Prefix{"BLS12-381 public key", "BLpk", []byte{6, 149, 135, 204}, 48}
+	PrefixEd25519Signature                = &Prefix{"Ed25519 signature", "edsig", []byte{9, 245, 205, 134, 18}, 64}
+	PrefixSecp256k1Signature              = &Prefix{"Secp256k1 signature", "spsig1", []byte{13, 115, 101, 19, 63}, 64}
+	PrefixP256Signature                   = &Prefix{"P-256 signature", "p2sig", []byte{54, 240, 44, 52}, 64}
+	PrefixGenericSignature                = &Prefix{"generic signature", "sig", []byte{4, 130, 43}, 64}
+	PrefixBLS12381Signature               = &Prefix{"BLS12-381 signature", "BLsig", []byte{40, 171, 64, 207}, 96}
+	PrefixChainID                         = &Prefix{"chain id", "Net", []byte{87, 82, 0}, 4}
+	PrefixScriptExprHash                  = &Prefix{"script expression hash", "expr", []byte{13, 44, 64, 27}, 32}
+	PrefixNonceHash                       = &Prefix{"nonce hash", "nce", []byte{69, 220, 169}, 32}
+	PrefixBlockPayloadHash                = &Prefix{"block payload hash", "vh", []byte{1, 106, 242}, 32}
+	PrefixDALCommitment                   = &Prefix{"DAL slot commitment", "sh", []byte{2, 116, 180}, 48}
+	PrefixSecp256k1Element                = &Prefix{"Secp256k1 element", "GSp", []byte{5, 92, 0}, 33}
+	PrefixEd25519BlindedPublicKeyHash     = &Prefix{"Ed25519 blinded public key hash", "btz1", []byte{1, 2, 49, 223}, 20}
+	PrefixSaplingSpendingKey              = &Prefix{"Sapling spending key", "sask", []byte{11, 237, 20, 92}, 169}
+	PrefixSaplingAddress                  = &Prefix{"Sapling address", "zet1", []byte{18, 71, 40, 223}, 43}
+	PrefixSmartRollupInboxHash            = &Prefix{"smart rollup inbox hash", "srib1", []byte{3, 255, 138, 145, 110}, 32}
+	PrefixSmartRollupMerkelizedPayload    = &Prefix{"smart rollup merkelized payload hashes hash", "srib2", []byte{3, 255, 138, 145, 140}, 32}
+	PrefixSmartRollupRevealHash           = &Prefix{"smart rollup reveal hash", "scrrh1", []byte{230, 206, 128, 200, 196}, 32}
+	PrefixSmartRollupCommitmentHashLegacy = &Prefix{"smart rollup commitment hash (legacy)", "scc1", []byte{17, 144, 21, 100}, 32}
+	PrefixSmartRollupStateHashLegacy      = &Prefix{"smart rollup state hash (legacy)", "scs1", []byte{17, 144, 122, 202}, 32}
+)
+
+// Prefixes contains all known prefixes
+var Prefixes = []*Prefix{
+	PrefixBlockHash,
+	PrefixOperationHash,
+	PrefixOperationListHash,
+	PrefixOperationListListHash,
+	PrefixProtocolHash,
+	PrefixContextHash,
+	PrefixBlockMetadataHash,
+	PrefixOperationMetadataHash,
+	PrefixOperationMetadataListHash,
+	PrefixOperationMetadataListListHash,
+	PrefixEd25519PublicKeyHash,
+	PrefixSecp256k1PublicKeyHash,
+	PrefixP256PublicKeyHash,
+	PrefixBLS12381PublicKeyHash,
+	PrefixContractHash,
+	PrefixTxRollupAddress,
+	PrefixSmartRollupAddress,
+	PrefixSmartRollupCommitmentHash,
+	PrefixSmartRollupStateHash,
+	PrefixCryptoboxPublicKeyHash,
+	PrefixEd25519Seed,
+	PrefixEd25519SecretKey,
+	PrefixSecp256k1SecretKey,
+	PrefixP256SecretKey,
+	PrefixBLS12381SecretKey,
+	PrefixEd25519EncryptedSeed,
+	PrefixSecp256k1EncryptedSecretKey,
+	PrefixP256EncryptedSecretKey,
+	PrefixBLS12381EncryptedSecretKey,
+	PrefixEd25519PublicKey,
+	PrefixSecp256k1PublicKey,
+	PrefixP256PublicKey,
+	PrefixBLS12381PublicKey,
+	PrefixEd25519Signature,
+	PrefixSecp256k1Signature,
+	PrefixP256Signature,
+	PrefixGenericSignature,
+	PrefixBLS12381Signature,
+	PrefixChainID,
+	PrefixScriptExprHash,
+	PrefixNonceHash,
+	PrefixBlockPayloadHash,
+	PrefixDALCommitment,
+	PrefixSecp256k1Element,
+	PrefixEd25519BlindedPublicKeyHash,
+	PrefixSaplingSpendingKey,
+	PrefixSaplingAddress,
+	PrefixSmartRollupInboxHash,
+	PrefixSmartRollupMerkelizedPayload,
+	PrefixSmartRollupRevealHash,
+	PrefixSmartRollupCommitmentHashLegacy,
+	PrefixSmartRollupStateHashLegacy,
+}
+
+// Lookup returns a prefix matching the decoded (checksum stripped) data
+func Lookup(data []byte) *Prefix {
+	for _, p := range Prefixes {
+		if len(data) == len(p.Bytes)+p.PayloadLen && bytes.HasPrefix(data, p.Bytes) {
+			return p
+		}
+	}
+	return nil
+}
+
+// LookupTag returns a prefix by its human readable tag and payload length
+func LookupTag(tag string, payloadLen int) *Prefix {
+	for _, p := range Prefixes {
+		if p.Tag == tag && p.PayloadLen == payloadLen {
+			return p
+		}
+	}
+	return nil
+}
+
+// Encode encodes the payload using the prefix
+func (p *Prefix) Encode(payload []byte) (string, error) {
+	if len(payload) != p.PayloadLen {
+		return "", fmt.Errorf("base58: %s payload must be %d bytes long, got %d", p.Name, p.PayloadLen, len(payload))
+	}
+	return CheckEncode(p.Bytes, payload), nil
+}
+
+// Decode decodes Base58Check string expecting the prefix
+func (p *Prefix) Decode(s string) ([]byte, error) {
+	payload, err := DecodePrefixed(s, p.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != p.PayloadLen {
+		return nil, fmt.Errorf("base58: %s payload must be %d bytes long, got %d", p.Name, p.PayloadLen, len(payload))
+	}
+	return payload, nil
+}
+
+// DecodeAny decodes Base58Check string and identifies its type
+func DecodeAny(s string) (*Prefix, []byte, error) {
+	data, err := CheckDecode(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := Lookup(data)
+	if p == nil {
+		return nil, data, fmt.Errorf("base58: unknown prefix")
+	}
+	return p, data[len(p.Bytes):], nil
+}
@@ -0,0 +1,88 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package base58
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPrefixTags(t *testing.T) {
+	// Every payload of the right length must encode to a string starting with the tag
+	for _, p := range Prefixes {
+		for _, b := range []byte{0, 0xff} {
+			s, err := p.Encode(bytes.Repeat([]byte{b}, p.PayloadLen))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(s, p.Tag) {
+				t.Errorf("%s: %s doesn't start with %s", p.Name, s, p.Tag)
+			}
+			if q, _, err := DecodeAny(s); err != nil || q != p {
+				t.Errorf("%s: %s decoded as %v (%v)", p.Name, s, q, err)
+			}
+		}
+		if q := LookupTag(p.Tag, p.PayloadLen); q != p {
+			t.Errorf("%s: tag lookup returned %v", p.Name, q)
+		}
+	}
+}
+
+func TestDecodeAny(t *testing.T) {
+	tests := []struct {
+		src     string
+		prefix  *Prefix
+		payload string
+	}{
+		{"NetXdQprcVkpaWU", PrefixChainID, "7a06a770"},
+		{"BLockGenesisGenesisGenesisGenesisGenesisf79b5d1CoW2", PrefixBlockHash, "8fcf233671b6a04fcf679d2a381c2544ea6c1ea29ba6157776ed8424c7ccd00b"},
+		{"tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx", PrefixEd25519PublicKeyHash, "02298c03ed7d454a101eb7022bc95f7e5f41ac78"},
+		{"KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn", PrefixContractHash, "a3d0f58d8964bd1b37fb0a0c197b38cf46608d49"},
+		{"edpkuBknW28nW72KG6RoHtYW7p12T6GKc7nAbwYX5m8Wd9sDVC9yav", PrefixEd25519PublicKey, "4798d2cc98473d7e250c898885718afd2e4efbcb1a1595ab9730761ed830de0f"},
+	}
+
+	for _, tt := range tests {
+		p, payload, err := DecodeAny(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if p != tt.prefix {
+			t.Errorf("%s: got %s, want %s", tt.src, p.Name, tt.prefix.Name)
+		}
+		if s := hex.EncodeToString(payload); s != tt.payload {
+			t.Errorf("%s: got %s, want %s", tt.src, s, tt.payload)
+		}
+		if s, _ := tt.prefix.Encode(payload); s != tt.src {
+			t.Errorf("%s: encoded as %s", tt.src, s)
+		}
+	}
+
+	// Bad checksum
+	if _, _, err := DecodeAny("tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSy"); err == nil {
+		t.Error("checksum error expected")
+	}
+	if _, err := PrefixContractHash.Decode("tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"); err == nil {
+		t.Error("prefix mismatch error expected")
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Balance threshold events
+const (
+	balanceBelow   = "below"
+	balanceAbove   = "above"
+	balanceInRange = "in_range"
+)
+
+type balanceWatchOptions struct {
+	below     string
+	above     string
+	format    string
+	sinkURLs  []string
+	windowOpt windowOptions
+}
+
+// balanceEvent is emitted when the balance crosses a threshold. Amounts are in mutez
+type balanceEvent struct {
+	Address   string    `json:"address"`
+	Event     string    `json:"event"`
+	Balance   string    `json:"balance"`
+	Threshold string    `json:"threshold,omitempty"`
+	Block     string    `json:"block"`
+	Level     int       `json:"level"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewAccountCommand returns new `account' command
+func NewAccountCommand(rootCtx *RootContext) *cobra.Command {
+	var watch balanceWatchOptions
+
+	accountCmd := &cobra.Command{
+		Use:   "account",
+		Short: "Inspect accounts",
+	}
+
+	watchCmd := &cobra.Command{
+		Use:   "watch <address|alias>",
+		Short: "Watch the account's balance and report when it crosses thresholds",
+		Long: `Watch the account's balance and report when it crosses thresholds. The balance is checked at every new head.
+An event is emitted when the balance drops below --below, rises above --above or gets back in between, as well as on start if the balance is already out of the range.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := rootCtx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			return rootCtx.watchBalance(address, &watch)
+		},
+	}
+
+	f := watchCmd.Flags()
+	f.StringVar(&watch.below, "below", "", "Lower balance threshold in tez")
+	f.StringVar(&watch.above, "above", "", "Upper balance threshold in tez")
+	f.StringVarP(&watch.format, "output-encoding", "o", "text", "Output encoding: one of [text, json]")
+	f.StringSliceVar(&watch.sinkURLs, "sink", nil, "Also publish events to the message broker or webhook, e.g. https://host/path (may be repeated)")
+	addWindowFlags(f, &watch.windowOpt, "balance checks of blocks produced")
+
+	accountCmd.AddCommand(watchCmd)
+	accountCmd.AddCommand(newFrozenCommand(rootCtx))
+	accountCmd.AddCommand(newFinalizeUnstakeCommand(rootCtx))
+
+	return accountCmd
+}
+
+// contractBalance returns the contract's balance at the block, cross-checked with --verify
+func (c *RootContext) contractBalance(blockID, address string) (*big.Int, error) {
+	var balance tezos.BigInt
+	if err := c.getVerifiedRPC(blockID, "/context/contracts/"+address+"/balance", &balance); err != nil {
+		return nil, err
+	}
+	return &balance.Int, nil
+}
+
+func (c *RootContext) watchBalance(address string, opt *balanceWatchOptions) error {
+	var below, above *big.Int
+	var err error
+	if opt.below != "" {
+		if below, err = utils.ParseTez(opt.below); err != nil {
+			return err
+		}
+	}
+	if opt.above != "" {
+		if above, err = utils.ParseTez(opt.above); err != nil {
+			return err
+		}
+	}
+	if below == nil && above == nil {
+		return errors.New("At least one of --below and --above is required")
+	}
+	if below != nil && above != nil && below.Cmp(above) > 0 {
+		return errors.New("--below threshold is greater than --above")
+	}
+	if opt.format != "text" && opt.format != "json" {
+		return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+	}
+	window, err := opt.windowOpt.window()
+	if err != nil {
+		return err
+	}
+
+	var sinks *eventSinks
+	if len(opt.sinkURLs) != 0 {
+		if sinks, err = c.newEventSinks(opt.sinkURLs, "{{.Address}}", nil); err != nil {
+			return err
+		}
+		defer sinks.close()
+	}
+
+	zone := func(balance *big.Int) (string, *big.Int) {
+		switch {
+		case below != nil && balance.Cmp(below) < 0:
+			return balanceBelow, below
+		case above != nil && balance.Cmp(above) > 0:
+			return balanceAbove, above
+		}
+		return balanceInRange, nil
+	}
+
+	log.WithFields(log.Fields{
+		"address": address,
+		"below":   opt.below,
+		"above":   opt.above,
+	}).Info("Watching balance")
+
+	var monErr error
+	ch := make(chan *tezos.BlockInfo, 10)
+	go func() {
+		monErr = c.monitorHeads(ch)
+		close(ch)
+	}()
+
+	// Zone of the previous balance, empty before the first block
+	var last string
+	for bi := range ch {
+		if window.ended(bi.Timestamp) {
+			return nil
+		}
+		if !window.contains(bi.Timestamp) {
+			continue
+		}
+		balance, err := c.contractBalance(bi.Hash, address)
+		if err != nil {
+			if err == context.Canceled {
+				break
+			}
+			return err
+		}
+
+		z, threshold := zone(balance)
+		log.WithFields(log.Fields{
+			"level":   bi.Level,
+			"balance": balance,
+			"zone":    z,
+		}).Debug("Balance checked")
+
+		if z == last || last == "" && z == balanceInRange {
+			last = z
+			continue
+		}
+		last = z
+
+		ev := balanceEvent{
+			Address:   address,
+			Event:     z,
+			Balance:   balance.String(),
+			Block:     bi.Hash,
+			Level:     bi.Level,
+			Timestamp: bi.Timestamp,
+		}
+		if threshold != nil {
+			ev.Threshold = threshold.String()
+		}
+
+		if err := c.writeBalanceEvent(os.Stdout, &ev, balance, threshold, opt.format); err != nil {
+			return err
+		}
+		if sinks != nil {
+			if err := sinks.publish(&ev, &ev); err != nil {
+				return err
+			}
+		}
+	}
+
+	if monErr != nil && monErr != context.Canceled {
+		return monErr
+	}
+	return nil
+}
+
+func (c *RootContext) writeBalanceEvent(w io.Writer, ev *balanceEvent, balance, threshold *big.Int, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(ev)
+	}
+
+	var msg string
+	switch ev.Event {
+	case balanceBelow:
+		msg = c.colorizer.Red(fmt.Sprintf("below %s", c.amountFormat.Format(threshold))).String()
+	case balanceAbove:
+		msg = c.colorizer.Yellow(fmt.Sprintf("above %s", c.amountFormat.Format(threshold))).String()
+	default:
+		msg = c.colorizer.Green("back in range").String()
+	}
+	_, err := fmt.Fprintf(w, "%s %d %s %s %s\n", c.localTime(ev.Timestamp).Format("2006-01-02 15:04:05"), ev.Level, ev.Address, c.amountFormat.Format(balance), msg)
+	return err
+}
@@ -0,0 +1,138 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/blake2b"
+)
+
+// NewActivateCommand returns new `activate' command
+func NewActivateCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		timeout time.Duration
+		dryRun  bool
+	)
+
+	activateCmd := &cobra.Command{
+		Use:         "activate <pkh> <activation-code>",
+		Short:       "Activate a fundraiser account",
+		Annotations: map[string]string{headAnnotation: ""},
+		Long: `Activate a fundraiser account and wait for the operation inclusion.
+The activation code is the hex encoded secret from the fundraiser wallet.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.activateAccount(args[0], args[1], timeout, dryRun)
+		},
+	}
+
+	activateCmd.Flags().DurationVar(&timeout, "timeout", defaultInclusionTimeout, "Time to wait for the operation inclusion")
+	activateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Check the commitment without injecting the operation")
+
+	return activateCmd
+}
+
+// blindedPublicKeyHash returns the key of the fundraiser commitment
+func blindedPublicKeyHash(pkh string, secret []byte) (string, error) {
+	payload, err := base58.PrefixEd25519PublicKeyHash.Decode(pkh)
+	if err != nil {
+		return "", err
+	}
+	h, err := blake2b.New(20, secret)
+	if err != nil {
+		return "", err
+	}
+	h.Write(payload)
+	return base58.PrefixEd25519BlindedPublicKeyHash.Encode(h.Sum(nil))
+}
+
+func (c *RootContext) activateAccount(pkh, code string, timeout time.Duration, dryRun bool) error {
+	if err := c.supportsKind(protocol.KindActivateAccount); err != nil {
+		return err
+	}
+	if p, _, err := base58.DecodeAny(pkh); err != nil || p != base58.PrefixEd25519PublicKeyHash {
+		return fmt.Errorf("Fundraiser accounts are tz1 addresses: `%s'", pkh)
+	}
+	secret, err := hex.DecodeString(code)
+	if err != nil || len(secret) != forge.ActivationSecretSize {
+		return fmt.Errorf("Invalid activation code: `%s'", code)
+	}
+
+	blinded, err := blindedPublicKeyHash(pkh, secret)
+	if err != nil {
+		return err
+	}
+
+	// Raw context access may be disabled on public nodes so only the missing commitment is fatal
+	var amount tezos.BigInt
+	ok, err := c.getOptionalRPC(c.blockPath("head")+"/context/raw/json/commitments/"+blinded, &amount)
+	switch {
+	case err != nil:
+		log.WithField("error", err).Warn("Can't check the commitment")
+	case !ok:
+		return fmt.Errorf("No commitment for %s: the activation code is wrong or the account is already activated", pkh)
+	default:
+		log.WithFields(log.Fields{
+			"address": pkh,
+			"amount":  c.amountFormat.Format(&amount.Int),
+		}).Info("Commitment found")
+	}
+	if dryRun {
+		return nil
+	}
+
+	var branch string
+	if err := c.getRPC(c.blockPath("head")+"/hash", &branch); err != nil {
+		return err
+	}
+
+	// Anonymous operations are not signed
+	forged, err := forge.Encode(branch, []forge.Operation{&forge.ActivateAccount{PublicKeyHash: pkh, Secret: secret}})
+	if err != nil {
+		return err
+	}
+	hash, err := c.injectOperation(forged)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+
+	if _, err := c.waitForOperation(hash, timeout); err != nil {
+		return err
+	}
+
+	balance, err := c.contractBalance("head", pkh)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Balance: %s\n", c.amountFormat.Format(balance))
+
+	return nil
+}
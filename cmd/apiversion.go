@@ -0,0 +1,165 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// latestAPIVersion is the highest output compatibility version, see --api-version. Version 0 is the default
+// format which follows the client library and may change between releases
+const latestAPIVersion = 1
+
+func apiVersionName(v int) string {
+	if v == 0 {
+		return "latest"
+	}
+	return fmt.Sprintf("v%d", v)
+}
+
+// blockV1 is the block representation frozen at API version 1. Fields may be added but never removed or changed
+type blockV1 struct {
+	Protocol   string           `json:"protocol" yaml:"protocol"`
+	ChainID    string           `json:"chain_id" yaml:"chain_id"`
+	Hash       string           `json:"hash" yaml:"hash"`
+	Header     blockHeaderV1    `json:"header" yaml:"header"`
+	Metadata   blockMetadataV1  `json:"metadata" yaml:"metadata"`
+	Operations [][]*operationV1 `json:"operations" yaml:"operations"`
+}
+
+type blockHeaderV1 struct {
+	Level          int       `json:"level" yaml:"level"`
+	Proto          int       `json:"proto" yaml:"proto"`
+	Predecessor    string    `json:"predecessor" yaml:"predecessor"`
+	Timestamp      time.Time `json:"timestamp" yaml:"timestamp"`
+	ValidationPass int       `json:"validation_pass" yaml:"validation_pass"`
+	OperationsHash string    `json:"operations_hash" yaml:"operations_hash"`
+	// Hex encoded as returned by the node
+	Fitness   []string `json:"fitness" yaml:"fitness,flow"`
+	Context   string   `json:"context" yaml:"context"`
+	Signature string   `json:"signature" yaml:"signature"`
+}
+
+type blockLevelV1 struct {
+	Level         int `json:"level" yaml:"level"`
+	LevelPosition int `json:"level_position" yaml:"level_position"`
+	Cycle         int `json:"cycle" yaml:"cycle"`
+	CyclePosition int `json:"cycle_position" yaml:"cycle_position"`
+}
+
+type blockMetadataV1 struct {
+	Protocol         string       `json:"protocol" yaml:"protocol"`
+	NextProtocol     string       `json:"next_protocol" yaml:"next_protocol"`
+	Baker            string       `json:"baker" yaml:"baker"`
+	LevelInfo        blockLevelV1 `json:"level_info" yaml:"level_info"`
+	MaxOperationsTTL int          `json:"max_operations_ttl" yaml:"max_operations_ttl"`
+	// Decimal string as returned by the node
+	ConsumedGas string `json:"consumed_gas" yaml:"consumed_gas"`
+}
+
+// operationV1 is the operation representation frozen at API version 1. Contents are passed through
+// as returned by the node
+type operationV1 struct {
+	Protocol  string                   `json:"protocol" yaml:"protocol"`
+	ChainID   string                   `json:"chain_id" yaml:"chain_id"`
+	Hash      string                   `json:"hash" yaml:"hash"`
+	Branch    string                   `json:"branch" yaml:"branch"`
+	Contents  []map[string]interface{} `json:"contents" yaml:"contents"`
+	Signature string                   `json:"signature" yaml:"signature"`
+}
+
+func newOperationV1(raw map[string]interface{}) *operationV1 {
+	str := func(k string) string {
+		s, _ := raw[k].(string)
+		return s
+	}
+	op := operationV1{
+		Protocol:  str("protocol"),
+		ChainID:   str("chain_id"),
+		Hash:      str("hash"),
+		Branch:    str("branch"),
+		Signature: str("signature"),
+		Contents:  []map[string]interface{}{},
+	}
+	contents, _ := raw["contents"].([]interface{})
+	for _, c := range contents {
+		if m, ok := c.(map[string]interface{}); ok {
+			op.Contents = append(op.Contents, m)
+		}
+	}
+	return &op
+}
+
+func newBlockV1(b *xblock) *blockV1 {
+	v := blockV1{
+		Protocol: b.Protocol,
+		ChainID:  b.ChainID,
+		Hash:     b.Hash,
+		Header: blockHeaderV1{
+			Level:          b.Header.Level,
+			Proto:          b.Header.Proto,
+			Predecessor:    b.Header.Predecessor,
+			Timestamp:      b.Header.Timestamp,
+			ValidationPass: b.Header.ValidationPass,
+			OperationsHash: b.Header.OperationsHash,
+			Fitness:        make([]string, len(b.Header.Fitness)),
+			Context:        b.Header.Context,
+			Signature:      b.Header.Signature,
+		},
+		Metadata: blockMetadataV1{
+			Protocol:     b.Metadata.Protocol,
+			NextProtocol: b.Metadata.NextProtocol,
+			Baker:        b.Metadata.Baker,
+			LevelInfo: blockLevelV1{
+				Level:         b.Metadata.Level.Level,
+				LevelPosition: b.Metadata.Level.LevelPosition,
+				Cycle:         b.Metadata.Level.Cycle,
+				CyclePosition: b.Metadata.Level.CyclePosition,
+			},
+			MaxOperationsTTL: b.Metadata.MaxOperationsTTL,
+			ConsumedGas:      "0",
+		},
+		Operations: make([][]*operationV1, len(b.rawOperations)),
+	}
+	for i, f := range b.Header.Fitness {
+		v.Header.Fitness[i] = hex.EncodeToString(f)
+	}
+	if b.Metadata.ConsumedGas != nil {
+		v.Metadata.ConsumedGas = b.Metadata.ConsumedGas.String()
+	}
+	for i, ol := range b.rawOperations {
+		v.Operations[i] = make([]*operationV1, len(ol))
+		for j, o := range ol {
+			v.Operations[i][j] = newOperationV1(o)
+		}
+	}
+	return &v
+}
+
+// output returns the block representation for machine-readable encodings
+func (b *xblock) output() interface{} {
+	if b.apiVersion >= 1 {
+		return newBlockV1(b)
+	}
+	return b
+}
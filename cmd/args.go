@@ -0,0 +1,128 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+)
+
+// Maximum number of blocks a single range may expand to
+const maxRangeLen = 1000000
+
+// expandBlockArgs expands comma separated lists and level ranges like `100..150', `head~100..head/10'
+// or `@2023-03-01..@2023-04-01' into individual block queries
+func (c *RootContext) expandBlockArgs(args []string) ([]string, error) {
+	var res []string
+	for _, arg := range args {
+		for _, item := range strings.Split(arg, ",") {
+			if item == "" {
+				continue
+			}
+
+			i := strings.Index(item, "..")
+			if i < 0 {
+				res = append(res, item)
+				continue
+			}
+
+			from, to := item[:i], item[i+2:]
+			step := 1
+			if j := strings.LastIndexByte(to, '/'); j >= 0 {
+				v, err := strconv.Atoi(to[j+1:])
+				if err != nil || v <= 0 {
+					return nil, fmt.Errorf("Invalid range step: `%s'", item)
+				}
+				to, step = to[:j], v
+			}
+
+			if from == "" {
+				from = "1"
+			}
+			if to == "" {
+				to = "head"
+			}
+
+			fromLevel, err := c.resolveLevel(from)
+			if err != nil {
+				return nil, err
+			}
+			toLevel, err := c.resolveLevel(to)
+			if err != nil {
+				return nil, err
+			}
+
+			n := toLevel - fromLevel
+			if n < 0 {
+				n = -n
+			}
+			if n/step >= maxRangeLen {
+				return nil, fmt.Errorf("Range is too long: `%s'", item)
+			}
+
+			if fromLevel <= toLevel {
+				for l := fromLevel; l <= toLevel; l += step {
+					res = append(res, strconv.Itoa(l))
+				}
+			} else {
+				for l := fromLevel; l >= toLevel; l -= step {
+					res = append(res, strconv.Itoa(l))
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// resolveLevel returns the level of the block query
+func (c *RootContext) resolveLevel(query string) (int, error) {
+	if strings.HasPrefix(query, "@") {
+		t, err := utils.ParseTime(query[1:])
+		if err != nil {
+			return 0, err
+		}
+		return c.blockLevelAt(t)
+	}
+
+	id, offset, err := parseBlockQuery(query)
+	if err != nil {
+		return 0, err
+	}
+
+	if id == "" || id[0] >= '0' && id[0] <= '9' {
+		var level int
+		if id != "" {
+			if level, err = strconv.Atoi(id); err != nil {
+				return 0, err
+			}
+		}
+		return level + offset, nil
+	}
+
+	bt, err := c.getBlockTime(id)
+	if err != nil {
+		return 0, err
+	}
+	return bt.Level + offset, nil
+}
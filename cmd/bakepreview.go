@@ -0,0 +1,339 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/forge"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Size limit of the manager operations pass of a block
+const managerPassMaxSize = 512 * 1024
+
+// Classes of pending operations a baker picks from
+var bakeableMempoolClasses = []string{"validated", "applied"}
+
+// Reasons of excluding a pending operation from the block
+const (
+	excludedBelowMinimalFee = "below_minimal_fee"
+	excludedSameManager     = "same_manager"
+	excludedBlockFull       = "block_full"
+)
+
+var previewColumns = []utils.TableColumn{
+	{Header: "#", Width: 4, Align: utils.AlignRight},
+	{Header: "OPERATION", Width: 51, MinWidth: 12},
+	{Header: "SOURCE", Width: 36, MinWidth: 12},
+	{Header: "FEE", Width: 12, Align: utils.AlignRight},
+	{Header: "GAS LIMIT", Width: 10, Align: utils.AlignRight},
+	{Header: "SIZE", Width: 6, Align: utils.AlignRight},
+}
+
+type bakePreviewOptions struct {
+	delegate string
+	maxRound int32
+	limit    int
+	format   string
+}
+
+// previewOperation is a pending manager operation group. The size is unknown if some of the contents can't be forged locally
+type previewOperation struct {
+	Hash     string   `json:"hash" yaml:"hash"`
+	Source   string   `json:"source" yaml:"source"`
+	Contents int      `json:"contents" yaml:"contents"`
+	FeeMutez *big.Int `json:"fee_mutez" yaml:"fee_mutez"`
+	GasLimit *big.Int `json:"gas_limit" yaml:"gas_limit"`
+	Size     int      `json:"size,omitempty" yaml:"size,omitempty"`
+
+	weight   float64
+	excluded string
+}
+
+// bakePreview is the hypothetical block built from the mempool at the delegate's next baking slot
+type bakePreview struct {
+	Delegate       string              `json:"delegate" yaml:"delegate"`
+	Level          int32               `json:"level" yaml:"level"`
+	Round          int32               `json:"round" yaml:"round"`
+	Pending        int                 `json:"pending" yaml:"pending"`
+	Included       int                 `json:"included" yaml:"included"`
+	Excluded       map[string]int      `json:"excluded" yaml:"excluded"`
+	FeesMutez      *big.Int            `json:"fees_mutez" yaml:"fees_mutez"`
+	GasLimit       *big.Int            `json:"gas_limit" yaml:"gas_limit"`
+	GasUtilization float64             `json:"gas_utilization" yaml:"gas_utilization"`
+	Size           int                 `json:"size" yaml:"size"`
+	Operations     []*previewOperation `json:"operations" yaml:"operations"`
+}
+
+func newBakePreviewCommand(rootCtx *RootContext) *cobra.Command {
+	var opt bakePreviewOptions
+
+	previewCmd := &cobra.Command{
+		Use:   "bake-preview",
+		Short: "Preview the block the delegate would bake at the next level",
+		Long: `Check that the delegate has a baking right at the next level, pull the pending manager operations from the mempool
+and select them like a baker would: operations below the minimal fee are dropped, only one operation per manager is
+kept and the rest are ordered by fee over their share of the block gas or size limit and included while they fit.
+Gas is accounted by the operations' gas limits. Nothing is baked or injected.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opt.format != "text" && rootCtx.encoderFunc(opt.format) == nil {
+				return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+			}
+			address, err := rootCtx.resolveAddress(opt.delegate)
+			if err != nil {
+				return err
+			}
+			p, err := rootCtx.bakePreview(address, opt.maxRound)
+			if err != nil {
+				return err
+			}
+			if opt.format != "text" {
+				return rootCtx.encoderFunc(opt.format)(os.Stdout).Encode(p)
+			}
+			return rootCtx.writeBakePreview(os.Stdout, p, opt.limit)
+		},
+	}
+
+	f := previewCmd.Flags()
+	f.StringVar(&opt.delegate, "delegate", "", "Delegate address or alias")
+	f.Int32Var(&opt.maxRound, "max-round", 4, "Highest round to look for the delegate's baking right")
+	f.IntVar(&opt.limit, "limit", 20, "Number of included operations to show, 0 for all")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	previewCmd.MarkFlagRequired("delegate")
+
+	return previewCmd
+}
+
+// pendingManagerOperations returns the manager operation groups the baker can include
+func (c *RootContext) pendingManagerOperations() ([]*previewOperation, error) {
+	var pending map[string]json.RawMessage
+	if err := c.getRPC("/chains/"+c.chainID+"/mempool/pending_operations", &pending); err != nil {
+		return nil, err
+	}
+
+	var ops []*previewOperation
+	for _, class := range bakeableMempoolClasses {
+		raw, ok := pending[class]
+		if !ok {
+			continue
+		}
+		var list []*feeOperation
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+		for _, op := range list {
+			p := previewOperation{
+				Hash:     op.Hash,
+				FeeMutez: new(big.Int),
+				GasLimit: new(big.Int),
+				Size:     branchSize + signatureSize,
+			}
+			for _, el := range op.Contents {
+				if el.Fee == nil || el.GasLimit == nil {
+					// Not a manager operation
+					p.Contents = 0
+					break
+				}
+				p.Source = el.Source
+				p.Contents++
+				p.FeeMutez.Add(p.FeeMutez, &el.Fee.Int)
+				p.GasLimit.Add(p.GasLimit, &el.GasLimit.Int)
+
+				if p.Size == 0 {
+					continue
+				}
+				fo := el.forgeOperation()
+				if fo == nil {
+					p.Size = 0
+					continue
+				}
+				n, err := forge.Size(fo)
+				if err != nil {
+					log.WithError(err).WithField("operation", op.Hash).Debug("Can't forge the operation")
+					p.Size = 0
+					continue
+				}
+				p.Size += n
+			}
+			if p.Contents != 0 {
+				ops = append(ops, &p)
+			}
+		}
+	}
+	return ops, nil
+}
+
+func (c *RootContext) bakePreview(delegate string, maxRound int32) (*bakePreview, error) {
+	var head struct {
+		Hash  string `json:"hash"`
+		Level int32  `json:"level"`
+	}
+	if err := c.getRPC(c.blockPath("head")+"/header", &head); err != nil {
+		return nil, err
+	}
+
+	q := url.Values{
+		"level":     []string{strconv.FormatInt(int64(head.Level+1), 10)},
+		"delegate":  []string{delegate},
+		"max_round": []string{strconv.FormatInt(int64(maxRound), 10)},
+	}
+	var rights []*bakingRight
+	if err := c.getRPC(c.blockPath(head.Hash)+"/helpers/baking_rights?"+q.Encode(), &rights); err != nil {
+		return nil, err
+	}
+	if len(rights) == 0 {
+		return nil, fmt.Errorf("%s has no baking right at level %d up to round %d", delegate, head.Level+1, maxRound)
+	}
+
+	var constants managerConstants
+	if err := c.getRPC(c.blockPath(head.Hash)+"/context/constants", &constants); err != nil {
+		return nil, err
+	}
+	blockGas := &constants.HardGasLimitPerBlock.Int
+	if blockGas.Sign() == 0 {
+		return nil, fmt.Errorf("Block gas limit is unknown")
+	}
+
+	ops, err := c.pendingManagerOperations()
+	if err != nil {
+		return nil, err
+	}
+
+	p := bakePreview{
+		Delegate: delegate,
+		Level:    rights[0].Level,
+		Round:    rights[0].Round,
+		Pending:  len(ops),
+		Excluded: map[string]int{
+			excludedBelowMinimalFee: 0,
+			excludedSameManager:     0,
+			excludedBlockFull:       0,
+		},
+		FeesMutez:  new(big.Int),
+		GasLimit:   new(big.Int),
+		Operations: []*previewOperation{},
+	}
+
+	// Operations of unknown size are weighted by gas only
+	var candidates []*previewOperation
+	for _, op := range ops {
+		size := op.Size
+		if size == 0 {
+			size = branchSize + signatureSize
+		}
+		if op.FeeMutez.Cmp(minimalFee(op.GasLimit, size)) < 0 {
+			op.excluded = excludedBelowMinimalFee
+			continue
+		}
+		share := bigRatio(op.GasLimit, blockGas)
+		if s := float64(op.Size) / managerPassMaxSize; s > share {
+			share = s
+		}
+		if share == 0 {
+			share = 1 / float64(managerPassMaxSize)
+		}
+		fee, _ := new(big.Float).SetInt(op.FeeMutez).Float64()
+		op.weight = fee / share
+		candidates = append(candidates, op)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].weight > candidates[j].weight })
+
+	managers := make(map[string]struct{})
+	var gas big.Int
+	for _, op := range candidates {
+		if _, ok := managers[op.Source]; ok {
+			op.excluded = excludedSameManager
+			continue
+		}
+		gas.Add(p.GasLimit, op.GasLimit)
+		if gas.Cmp(blockGas) > 0 || p.Size+op.Size > managerPassMaxSize {
+			op.excluded = excludedBlockFull
+			continue
+		}
+		managers[op.Source] = struct{}{}
+		p.GasLimit.Set(&gas)
+		p.FeesMutez.Add(p.FeesMutez, op.FeeMutez)
+		p.Size += op.Size
+		p.Operations = append(p.Operations, op)
+	}
+	for _, op := range ops {
+		if op.excluded != "" {
+			p.Excluded[op.excluded]++
+		}
+	}
+	p.Included = len(p.Operations)
+	p.GasUtilization = bigRatio(p.GasLimit, blockGas)
+
+	log.WithFields(log.Fields{
+		"level":    p.Level,
+		"round":    p.Round,
+		"pending":  p.Pending,
+		"included": p.Included,
+	}).Debug("Block previewed")
+
+	return &p, nil
+}
+
+func (c *RootContext) writeBakePreview(w io.Writer, p *bakePreview, limit int) error {
+	_, err := fmt.Fprintf(w, "Delegate:         %s\nLevel:            %d (round %d)\nPending:          %d manager operations\nIncluded:         %d\n",
+		p.Delegate, p.Level, p.Round, p.Pending, p.Included)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Excluded:         %d below minimal fee, %d same manager, %d block full\nExpected fees:    %s\nGas limit:        %s (%.1f%% of the block)\nSize:             %d bytes\n",
+		p.Excluded[excludedBelowMinimalFee], p.Excluded[excludedSameManager], p.Excluded[excludedBlockFull],
+		c.colorizer.Green(c.amountFormat.Format(p.FeesMutez)), p.GasLimit, p.GasUtilization*100, p.Size)
+	if err != nil || len(p.Operations) == 0 {
+		return err
+	}
+
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	table := utils.NewTable(w, previewColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for i, op := range p.Operations {
+		if limit > 0 && i == limit {
+			break
+		}
+		size := "?"
+		if op.Size != 0 {
+			size = strconv.Itoa(op.Size)
+		}
+		if err := table.WriteRow(strconv.Itoa(i+1), op.Hash, op.Source, c.amountFormat.Format(op.FeeMutez), op.GasLimit.String(), size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Default time to wait for the operation inclusion
+const defaultInclusionTimeout = 5 * time.Minute
+
+// Older protocols use preserved_cycles for the delay before the stake is taken into account
+type bakerConstants struct {
+	stakeConstants
+	PreservedCycles      *int `json:"preserved_cycles"`
+	ConsensusRightsDelay *int `json:"consensus_rights_delay"`
+}
+
+// rightsDelay returns the number of cycles between the stake snapshot and the rights it gives
+func (b *bakerConstants) rightsDelay() int {
+	switch {
+	case b.ConsensusRightsDelay != nil:
+		return *b.ConsensusRightsDelay
+	case b.PreservedCycles != nil:
+		return *b.PreservedCycles
+	default:
+		return 0
+	}
+}
+
+func (b *bakerConstants) minimalStake() *big.Int {
+	switch {
+	case b.MinimalStake != nil:
+		return &b.MinimalStake.Int
+	case b.TokensPerRoll != nil:
+		return &b.TokensPerRoll.Int
+	default:
+		return nil
+	}
+}
+
+type blockProtocols struct {
+	Protocol     string `json:"protocol"`
+	NextProtocol string `json:"next_protocol"`
+}
+
+type bakerRegisterOptions struct {
+	key          string
+	consensusKey string
+	timeout      time.Duration
+	inject       injectOptions
+}
+
+// NewBakerCommand returns new `baker' command
+func NewBakerCommand(rootCtx *RootContext) *cobra.Command {
+	bakerCmd := &cobra.Command{
+		Use:   "baker",
+		Short: "Manage a baker (delegate)",
+	}
+
+	bakerCmd.AddCommand(newBakerRegisterCommand(rootCtx))
+	bakerCmd.AddCommand(newConsensusKeyCommand(rootCtx))
+	bakerCmd.AddCommand(newDepositsCommand(rootCtx))
+	bakerCmd.AddCommand(newSetDepositsLimitCommand(rootCtx))
+	bakerCmd.AddCommand(newSetStakingParamsCommand(rootCtx))
+	bakerCmd.AddCommand(newBakerScoreCommand(rootCtx))
+
+	return bakerCmd
+}
+
+func newBakerRegisterCommand(ctx *RootContext) *cobra.Command {
+	var opt bakerRegisterOptions
+
+	registerCmd := &cobra.Command{
+		Use:   "register",
+		Short: "Register the key as a delegate",
+		Long: `Register the key as a delegate. The public key is revealed if needed, then the account is delegated to itself
+and optionally the consensus key is set. After the inclusion the registration is verified against the chain
+and the remaining steps before the baker receives rights are printed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.registerBaker(&opt)
+		},
+	}
+
+	f := registerCmd.Flags()
+	f.StringVar(&opt.key, "key", "", "Baker key: keystore alias or address")
+	f.StringVar(&opt.consensusKey, "consensus-key", "", "Consensus key: keystore alias or public key")
+	f.DurationVar(&opt.timeout, "timeout", defaultInclusionTimeout, "Time to wait for the operation inclusion")
+	registerCmd.MarkFlagRequired("key")
+	addInjectFlags(registerCmd, &opt.inject)
+
+	return registerCmd
+}
+
+// nextProtocol returns the protocol the next operations will be applied by. It's asked at the head whatever --block is,
+// the commands using it inject operations and don't accept --block
+func (c *RootContext) nextProtocol() (*protocol.Protocol, string, error) {
+	var p blockProtocols
+	if err := c.getRPC(c.blockPath("head")+"/protocols", &p); err != nil {
+		return nil, "", err
+	}
+	return protocol.Lookup(p.NextProtocol), p.NextProtocol, nil
+}
+
+// supportsKind returns an error if the current protocol is known to not support the operation kind.
+// Unknown protocols are assumed to be newer ones
+func (c *RootContext) supportsKind(kind string) error {
+	p, hash, err := c.nextProtocol()
+	if err != nil {
+		return err
+	}
+	if p != nil && !p.Supports(kind) {
+		return fmt.Errorf("Protocol %s doesn't support %s operations", p.Name, kind)
+	}
+	if p == nil {
+		log.WithField("protocol", hash).Debug("Unknown protocol")
+	}
+	return nil
+}
+
+func (c *RootContext) registerBaker(opt *bakerRegisterOptions) error {
+	acc, err := c.account(opt.key)
+	if err != nil {
+		return err
+	}
+
+	registered, err := c.isDelegate(acc.Address)
+	if err != nil {
+		return err
+	}
+
+	var ops []forge.ManagerOperation
+	if registered {
+		log.WithField("address", acc.Address).Info("Already registered as a delegate")
+	} else {
+		ops = append(ops, &forge.Delegation{Delegate: acc.Address})
+	}
+
+	if opt.consensusKey != "" {
+		if err := c.supportsKind(protocol.KindUpdateConsensusKey); err != nil {
+			return err
+		}
+		pk, err := c.resolvePublicKey(opt.consensusKey)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, &forge.UpdateConsensusKey{PublicKey: pk})
+	}
+
+	if len(ops) != 0 {
+		hash, err := c.sendOperations(acc, ops, &opt.inject)
+		if err != nil {
+			return err
+		}
+		if opt.inject.dryRun {
+			return nil
+		}
+		fmt.Println(hash)
+
+		if _, err := c.waitForOperation(hash, opt.timeout); err != nil {
+			return err
+		}
+
+		if registered, err = c.isDelegate(acc.Address); err != nil {
+			return err
+		}
+		if !registered {
+			return fmt.Errorf("%s is not registered as a delegate after the operation inclusion", acc.Address)
+		}
+	}
+
+	return c.printBakerStatus(acc.Address, opt.consensusKey != "")
+}
+
+// activationCycle returns the current cycle and the first cycle affected by changes made in it
+// such as registration or consensus key update
+func (c *RootContext) activationCycle() (int, int, *bakerConstants, error) {
+	block := c.blockPath(c.blockID)
+
+	var level currentLevel
+	if err := c.getRPC(block+"/helpers/current_level", &level); err != nil {
+		return 0, 0, nil, err
+	}
+
+	var constants bakerConstants
+	if err := c.getRPC(block+"/context/constants", &constants); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return level.Cycle, level.Cycle + constants.rightsDelay() + 1, &constants, nil
+}
+
+// printBakerStatus prints what remains before the delegate receives rights
+func (c *RootContext) printBakerStatus(address string, consensusKeyUpdated bool) error {
+	cycle, fromCycle, constants, err := c.activationCycle()
+	if err != nil {
+		return err
+	}
+
+	var balance tezos.BigInt
+	if err := c.getRPC(c.blockPath(c.blockID)+"/context/delegates/"+address+"/staking_balance", &balance); err != nil {
+		return err
+	}
+
+	fmt.Printf("Delegate:        %s\n", address)
+	fmt.Printf("Staking balance: %s\n", c.amountFormat.Format(&balance.Int))
+	if minStake := constants.minimalStake(); minStake != nil {
+		fmt.Printf("Minimal stake:   %s\n", c.amountFormat.Format(minStake))
+		if balance.Cmp(minStake) < 0 {
+			fmt.Printf("Staking balance is below the minimal stake, no rights will be given until it's topped up by %s\n",
+				c.amountFormat.Format(new(big.Int).Sub(minStake, &balance.Int)))
+		}
+	}
+	fmt.Printf("Current cycle:   %d\n", cycle)
+	fmt.Printf("Rights expected from cycle %d (%d cycles to wait)\n", fromCycle, fromCycle-cycle)
+	if consensusKeyUpdated {
+		fmt.Printf("Consensus key becomes active from cycle %d\n", fromCycle)
+	}
+
+	return nil
+}
@@ -0,0 +1,476 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/michelson"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Columns of the batch estimate table
+var batchEstimateColumns = []utils.TableColumn{
+	{Header: "ITEM", Width: 5, Align: utils.AlignRight},
+	{Header: "SOURCE", Width: 36, MinWidth: 13},
+	{Header: "KIND", Width: 12},
+	{Header: "DESTINATION", Width: 36, MinWidth: 13},
+	{Header: "FEE", Width: 14, Align: utils.AlignRight},
+	{Header: "GAS", Width: 8, Align: utils.AlignRight},
+	{Header: "STORAGE", Width: 8, Align: utils.AlignRight},
+	{Header: "BURN", Width: 14, Align: utils.AlignRight},
+	{Header: "STATUS", Width: 30, MinWidth: 6},
+}
+
+// batchItem is an entry of the batch manifest
+type batchItem struct {
+	From string `yaml:"from"`
+	// Defaults to transaction
+	Kind string `yaml:"kind"`
+	To   string `yaml:"to"`
+	// Amount in tez
+	Amount     string `yaml:"amount"`
+	Entrypoint string `yaml:"entrypoint"`
+	// Michelson or Micheline JSON
+	Parameters string `yaml:"parameters"`
+	// Empty delegate withdraws the delegation
+	Delegate string `yaml:"delegate"`
+}
+
+// batchManifest is a list of manager operations to be signed together, possibly by several sources
+type batchManifest struct {
+	Operations []*batchItem `yaml:"operations"`
+}
+
+type batchEstimateItem struct {
+	// Position in the manifest starting from 1, zero for reveals added by the client
+	Item         int        `json:"item" yaml:"item"`
+	Source       string     `json:"source" yaml:"source"`
+	Kind         string     `json:"kind" yaml:"kind"`
+	Destination  string     `json:"destination,omitempty" yaml:"destination,omitempty"`
+	FeeMutez     *big.Int   `json:"fee_mutez,omitempty" yaml:"fee_mutez,omitempty"`
+	Fee          *big.Float `json:"fee,omitempty" yaml:"fee,omitempty"`
+	GasLimit     *big.Int   `json:"gas_limit,omitempty" yaml:"gas_limit,omitempty"`
+	StorageLimit *big.Int   `json:"storage_limit,omitempty" yaml:"storage_limit,omitempty"`
+	BurnMutez    *big.Int   `json:"burn_mutez,omitempty" yaml:"burn_mutez,omitempty"`
+	Burn         *big.Float `json:"burn,omitempty" yaml:"burn,omitempty"`
+	Error        string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type batchEstimate struct {
+	Items          []*batchEstimateItem `json:"items" yaml:"items"`
+	Failed         int                  `json:"failed" yaml:"failed"`
+	TotalFeeMutez  *big.Int             `json:"total_fee_mutez" yaml:"total_fee_mutez"`
+	TotalFee       *big.Float           `json:"total_fee" yaml:"total_fee"`
+	TotalGas       *big.Int             `json:"total_gas" yaml:"total_gas"`
+	TotalStorage   *big.Int             `json:"total_storage" yaml:"total_storage"`
+	TotalBurnMutez *big.Int             `json:"total_burn_mutez" yaml:"total_burn_mutez"`
+	TotalBurn      *big.Float           `json:"total_burn" yaml:"total_burn"`
+}
+
+// NewBatchCommand returns new `batch' command
+func NewBatchCommand(rootCtx *RootContext) *cobra.Command {
+	var outputFormat string
+
+	batchCmd := &cobra.Command{
+		Use:         "batch",
+		Short:       "Work with batch manifests",
+		Annotations: map[string]string{headAnnotation: ""},
+		Long: `Work with batch manifests. A manifest is a YAML or JSON document listing manager operations under the operations key:
+
+operations:
+  - from: treasury          # keystore alias or address
+    to: tz1...
+    amount: 12.5            # tez
+  - from: treasury
+    to: KT1...
+    entrypoint: transfer
+    parameters: Pair "tz1..." 10
+  - from: ops
+    kind: delegation        # transaction by default
+    delegate: tz1...`,
+	}
+
+	estimateCmd := &cobra.Command{
+		Use:   "estimate <manifest|-|@file>",
+		Short: "Simulate the manifest and report the cost of every item",
+		Long: `Simulate every operation of the manifest and report per-item and total fees, gas, storage and burn. Items that would fail are flagged and the rest is simulated without them.
+Operations of each source are simulated as a single batch like they would be signed. No keys are unlocked, public keys of unrevealed sources are taken from the keystore.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEncoder utils.NewEncoderFunc
+			if outputFormat != "text" {
+				if newEncoder = rootCtx.encoderFunc(outputFormat); newEncoder == nil || utils.IsBinary(outputFormat) {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+			}
+
+			src := args[0]
+			if src != "-" && !strings.HasPrefix(src, "@") {
+				src = "@" + src
+			}
+			data, err := utils.ReadInput(src)
+			if err != nil {
+				return err
+			}
+			manifest, err := parseBatchManifest(data)
+			if err != nil {
+				return err
+			}
+
+			est, err := rootCtx.estimateBatch(manifest)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case newEncoder == nil:
+				err = rootCtx.writeBatchEstimate(os.Stdout, est)
+			case utils.IsReport(outputFormat):
+				// Tables hold the items only
+				enc := newEncoder(os.Stdout)
+				err = enc.Encode(est.Items)
+				closeEncoder(enc, &err)
+			default:
+				err = newEncoder(os.Stdout).Encode(est)
+			}
+			if err != nil {
+				return err
+			}
+
+			if est.Failed != 0 {
+				return fmt.Errorf("%d of %d items would fail", est.Failed, len(manifest.Operations))
+			}
+			return nil
+		},
+	}
+
+	estimateCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json, markdown, html]")
+
+	batchCmd.AddCommand(estimateCmd)
+
+	return batchCmd
+}
+
+// parseBatchManifest decodes the manifest rejecting unknown keys to catch typos
+func parseBatchManifest(data []byte) (*batchManifest, error) {
+	var m batchManifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(m.Operations) == 0 {
+		return nil, errors.New("Batch manifest has no operations")
+	}
+	return &m, nil
+}
+
+// batchOperation returns the manager operation described by the manifest item
+func (c *RootContext) batchOperation(it *batchItem) (forge.ManagerOperation, error) {
+	kind := strings.ToLower(it.Kind)
+	if k, ok := kindAliases[kind]; ok {
+		kind = k
+	}
+
+	switch kind {
+	case "", protocol.KindTransaction:
+		if it.To == "" {
+			return nil, errors.New("Transaction has no destination")
+		}
+		to, err := c.resolveAddress(it.To)
+		if err != nil {
+			return nil, err
+		}
+		op := forge.Transaction{
+			Amount:      new(big.Int),
+			Destination: to,
+			Entrypoint:  it.Entrypoint,
+		}
+		if it.Amount != "" {
+			if op.Amount, err = utils.ParseTez(it.Amount); err != nil {
+				return nil, err
+			}
+		}
+		if it.Parameters != "" {
+			if op.Parameters, err = michelson.ParseAny(it.Parameters); err != nil {
+				return nil, err
+			}
+		}
+		return &op, nil
+
+	case protocol.KindDelegation:
+		var op forge.Delegation
+		if it.Delegate != "" {
+			delegate, err := c.resolveAddress(it.Delegate)
+			if err != nil {
+				return nil, err
+			}
+			op.Delegate = delegate
+		}
+		return &op, nil
+	}
+	return nil, fmt.Errorf("Unsupported operation kind: `%s'", it.Kind)
+}
+
+// batchSource is a manifest source along with its items in the manifest order
+type batchSource struct {
+	address   string
+	publicKey string
+	items     []int
+	ops       []forge.ManagerOperation
+}
+
+func (c *RootContext) estimateBatch(m *batchManifest) (*batchEstimate, error) {
+	var (
+		sources []*batchSource
+		index   = make(map[string]*batchSource)
+	)
+	for i, it := range m.Operations {
+		if it.From == "" {
+			return nil, fmt.Errorf("Item %d: no source", i+1)
+		}
+		address, err := c.resolveAddress(it.From)
+		if err != nil {
+			return nil, fmt.Errorf("Item %d: %v", i+1, err)
+		}
+		op, err := c.batchOperation(it)
+		if err != nil {
+			return nil, fmt.Errorf("Item %d: %v", i+1, err)
+		}
+
+		src, ok := index[address]
+		if !ok {
+			src = &batchSource{address: address}
+			// Only needed if the source isn't revealed
+			src.publicKey, _ = c.resolvePublicKey(it.From)
+			index[address] = src
+			sources = append(sources, src)
+		}
+		src.items = append(src.items, i+1)
+		src.ops = append(src.ops, op)
+	}
+
+	items := make(map[int]*batchEstimateItem, len(m.Operations))
+	var reveals []*batchEstimateItem
+	for _, src := range sources {
+		res, err := c.estimateSource(src)
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range res {
+			if it.Item == 0 {
+				reveals = append(reveals, it)
+			} else {
+				items[it.Item] = it
+			}
+		}
+	}
+
+	est := batchEstimate{
+		Items:          reveals,
+		TotalFeeMutez:  new(big.Int),
+		TotalGas:       new(big.Int),
+		TotalStorage:   new(big.Int),
+		TotalBurnMutez: new(big.Int),
+	}
+	for i := range m.Operations {
+		est.Items = append(est.Items, items[i+1])
+	}
+	for _, it := range est.Items {
+		if it.Error != "" {
+			est.Failed++
+			continue
+		}
+		est.TotalFeeMutez.Add(est.TotalFeeMutez, it.FeeMutez)
+		est.TotalGas.Add(est.TotalGas, it.GasLimit)
+		est.TotalStorage.Add(est.TotalStorage, it.StorageLimit)
+		est.TotalBurnMutez.Add(est.TotalBurnMutez, it.BurnMutez)
+	}
+	est.TotalFee = c.amountFormat.Tez(est.TotalFeeMutez)
+	est.TotalBurn = c.amountFormat.Tez(est.TotalBurnMutez)
+
+	return &est, nil
+}
+
+// contentError returns errors of the simulated content and its internal operations, empty if it was applied
+func contentError(r *simulatedContent) string {
+	result := r.Metadata.OperationResult
+	if len(result.Errors) != 0 {
+		return result.Status + ": " + result.errorIDs()
+	}
+	for _, ir := range r.Metadata.InternalOperationResults {
+		if ir.Result != nil && len(ir.Result.Errors) != 0 {
+			return "internal " + ir.Kind + " " + ir.Result.Status + ": " + ir.Result.errorIDs()
+		}
+	}
+	if result.Status != "applied" {
+		return result.Status
+	}
+	return ""
+}
+
+// isRejected returns true if the node refused to simulate the operation at all, usually because it's malformed
+func isRejected(err error) bool {
+	st, ok := err.(tezos.HTTPStatus)
+	return ok && (st.StatusCode() == http.StatusBadRequest || st.StatusCode() == http.StatusInternalServerError)
+}
+
+func newBatchEstimateItem(item int, source string, op forge.ManagerOperation) *batchEstimateItem {
+	it := batchEstimateItem{Item: item, Source: source, Kind: op.Kind()}
+	switch op := op.(type) {
+	case *forge.Transaction:
+		it.Destination = op.Destination
+	case *forge.Delegation:
+		it.Destination = op.Delegate
+	}
+	return &it
+}
+
+// estimateSource simulates operations of the source as a single batch. The failed item is dropped and the rest
+// is simulated again because the protocol skips everything after the failure
+func (c *RootContext) estimateSource(src *batchSource) ([]*batchEstimateItem, error) {
+	var res []*batchEstimateItem
+	items, ops := src.items, src.ops
+	failAll := func(reason string) []*batchEstimateItem {
+		for i, op := range ops {
+			it := newBatchEstimateItem(items[i], src.address, op)
+			it.Error = reason
+			res = append(res, it)
+		}
+		return res
+	}
+
+	for len(ops) != 0 {
+		b, err := c.prepareBatch(src.address, src.publicKey, ops)
+		if err == errNotRevealed {
+			return failAll(err.Error()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Reveal prepended by prepareBatch
+		revealed := len(b.ops) - len(ops)
+
+		results, err := c.runOperation(b.branch, b.ops)
+		if err != nil {
+			if isRejected(err) {
+				return failAll(err.Error()), nil
+			}
+			return nil, err
+		}
+
+		failed := -1
+		for i, r := range results {
+			if r.Metadata.OperationResult.Status == "failed" {
+				failed = i
+				break
+			}
+		}
+
+		if failed < 0 {
+			if err := b.applyEstimate(results, nil, 1); err != nil {
+				return nil, err
+			}
+			for i, op := range b.ops {
+				var item int
+				if i >= revealed {
+					item = items[i-revealed]
+				}
+				it := newBatchEstimateItem(item, src.address, op)
+				if it.Error = contentError(results[i]); it.Error == "" {
+					m := op.ManagerFields()
+					it.FeeMutez = m.Fee
+					it.Fee = c.amountFormat.Tez(m.Fee)
+					it.GasLimit = m.GasLimit
+					it.StorageLimit = m.StorageLimit
+					it.BurnMutez = new(big.Int).Mul(m.StorageLimit, &b.constants.CostPerByte.Int)
+					it.Burn = c.amountFormat.Tez(it.BurnMutez)
+				}
+				res = append(res, it)
+			}
+			return res, nil
+		}
+
+		if failed < revealed {
+			return failAll("reveal " + contentError(results[failed])), nil
+		}
+
+		i := failed - revealed
+		it := newBatchEstimateItem(items[i], src.address, ops[i])
+		it.Error = contentError(results[failed])
+		res = append(res, it)
+		log.WithFields(log.Fields{
+			"source": src.address,
+			"item":   items[i],
+			"error":  it.Error,
+		}).Debug("Item would fail, simulating the rest without it")
+
+		items = append(append([]int(nil), items[:i]...), items[i+1:]...)
+		ops = append(append([]forge.ManagerOperation(nil), ops[:i]...), ops[i+1:]...)
+	}
+	return res, nil
+}
+
+func (c *RootContext) writeBatchEstimate(w io.Writer, est *batchEstimate) error {
+	table := utils.NewTable(w, batchEstimateColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	var n int
+	for _, it := range est.Items {
+		item := "-"
+		if it.Item != 0 {
+			item = strconv.Itoa(it.Item)
+			n++
+		}
+		var err error
+		if it.Error != "" {
+			err = table.WriteRow(item, it.Source, it.Kind, it.Destination, "", "", "", "", c.colorizer.Red(it.Error).String())
+		} else {
+			err = table.WriteRow(item, it.Source, it.Kind, it.Destination,
+				c.amountFormat.Format(it.FeeMutez), it.GasLimit.String(), it.StorageLimit.String(), c.amountFormat.Format(it.BurnMutez), "ok")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	total := new(big.Int).Add(est.TotalFeeMutez, est.TotalBurnMutez)
+	_, err := fmt.Fprintf(w, "\nItems:         %d (%d would fail)\nTotal fee:     %s\nTotal gas:     %s\nTotal storage: %s bytes\nTotal burn:    %s\nTotal cost:    %s\n",
+		n, est.Failed, c.amountFormat.Format(est.TotalFeeMutez), est.TotalGas, est.TotalStorage,
+		c.amountFormat.Format(est.TotalBurnMutez), c.amountFormat.Format(total))
+	return err
+}
@@ -0,0 +1,304 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the RPC benchmark table
+var benchColumns = []utils.TableColumn{
+	{Header: "URL", Width: 36, MinWidth: 13},
+	{Header: "RPC", Width: 7},
+	{Header: "OK", Width: 5, Align: utils.AlignRight},
+	{Header: "ERRORS", Width: 6, Align: utils.AlignRight},
+	{Header: "ERR%", Width: 5, Align: utils.AlignRight},
+	{Header: "P50", Width: 8, Align: utils.AlignRight},
+	{Header: "P90", Width: 8, Align: utils.AlignRight},
+	{Header: "P99", Width: 8, Align: utils.AlignRight},
+	{Header: "MAX", Width: 8, Align: utils.AlignRight},
+}
+
+type benchOptions struct {
+	urls        []string
+	requests    int
+	concurrency int
+	timeout     time.Duration
+	contract    string
+	format      string
+}
+
+// benchRPC is a benchmarked RPC. The path may depend on the request number so caches along the way are bypassed
+type benchRPC struct {
+	name string
+	path func(i int) string
+}
+
+// benchResult holds latencies of one RPC against one end-point. Durations are in milliseconds
+type benchResult struct {
+	URL       string  `json:"url" yaml:"url"`
+	RPC       string  `json:"rpc" yaml:"rpc"`
+	OK        int     `json:"ok" yaml:"ok"`
+	Errors    int     `json:"errors" yaml:"errors"`
+	ErrorRate float64 `json:"error_rate" yaml:"error_rate"`
+	P50       float64 `json:"p50_ms" yaml:"p50_ms"`
+	P90       float64 `json:"p90_ms" yaml:"p90_ms"`
+	P99       float64 `json:"p99_ms" yaml:"p99_ms"`
+	Max       float64 `json:"max_ms" yaml:"max_ms"`
+	// Last error message, if any
+	LastError string `json:"last_error,omitempty" yaml:"last_error,omitempty"`
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.999999) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// NewBenchCommand returns new `bench' command
+func NewBenchCommand(rootCtx *RootContext) *cobra.Command {
+	var opt benchOptions
+
+	benchCmd := &cobra.Command{
+		Use:         "bench",
+		Short:       "Benchmarks",
+		Annotations: map[string]string{headAnnotation: ""},
+	}
+
+	rpcCmd := &cobra.Command{
+		Use:   "rpc",
+		Short: "Compare RPC latency of several end-points",
+		Long: `Send the same requests to every end-point concurrently and print latency percentiles and error rates.
+Benchmarked RPCs are the head header, full blocks below the head (a different one for every request so caches don't help)
+and, with --contract, the contract's storage. Responses are read in full but not cached.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.benchRPC(&opt)
+		},
+	}
+
+	f := rpcCmd.Flags()
+	f.StringSliceVar(&opt.urls, "urls", nil, "End-point URLs to compare (default is --url)")
+	f.IntVar(&opt.requests, "requests", 100, "Number of requests per RPC and end-point")
+	f.IntVar(&opt.concurrency, "concurrency", 4, "Number of requests in flight per end-point")
+	f.DurationVar(&opt.timeout, "timeout", 10*time.Second, "Request timeout, timed out requests are counted as errors")
+	f.StringVar(&opt.contract, "contract", "", "Also benchmark the storage of the contract (address or alias)")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+
+	benchCmd.AddCommand(rpcCmd)
+
+	return benchCmd
+}
+
+func (c *RootContext) benchRPC(opt *benchOptions) error {
+	if opt.requests <= 0 || opt.concurrency <= 0 {
+		return errors.New("--requests and --concurrency must be positive")
+	}
+	var newEncoder utils.NewEncoderFunc
+	if opt.format != "text" {
+		if newEncoder = c.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+
+	urls := opt.urls
+	if len(urls) == 0 {
+		urls = []string{c.tezosURL}
+	}
+
+	rpcs := []*benchRPC{
+		{name: "head", path: func(int) string { return "/chains/" + c.chainID + "/blocks/head/header" }},
+		{name: "block", path: func(i int) string {
+			return "/chains/" + c.chainID + "/blocks/head~" + strconv.Itoa(i%100+1)
+		}},
+	}
+	if opt.contract != "" {
+		addr, err := c.resolveAddress(opt.contract)
+		if err != nil {
+			return err
+		}
+		rpcs = append(rpcs, &benchRPC{name: "storage", path: func(int) string {
+			return "/chains/" + c.chainID + "/blocks/head/context/contracts/" + addr + "/storage"
+		}})
+	}
+
+	results := make([][]*benchResult, len(urls))
+	var (
+		wg      sync.WaitGroup
+		errMtx  sync.Mutex
+		initErr error
+	)
+	for i, u := range urls {
+		client, err := tezos.NewRPCClient(&http.Client{}, u)
+		if err != nil {
+			return fmt.Errorf("Failed to initilize tezos RPC client: %v", err)
+		}
+		log.WithField("url", u).Info("Benchmarking")
+
+		wg.Add(1)
+		go func(i int, u string, client *tezos.RPCClient) {
+			defer wg.Done()
+			for _, r := range rpcs {
+				res, err := c.benchEndpoint(client, u, r, opt)
+				if err != nil {
+					errMtx.Lock()
+					if initErr == nil {
+						initErr = err
+					}
+					errMtx.Unlock()
+					return
+				}
+				results[i] = append(results[i], res)
+			}
+		}(i, u, client)
+	}
+	wg.Wait()
+	if initErr != nil {
+		return initErr
+	}
+
+	var list []*benchResult
+	for _, r := range results {
+		list = append(list, r...)
+	}
+
+	if newEncoder != nil {
+		return newEncoder(os.Stdout).Encode(list)
+	}
+
+	table := utils.NewTable(os.Stdout, benchColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, r := range list {
+		ms := func(v float64) string {
+			if r.OK == 0 {
+				return "--"
+			}
+			return strconv.FormatFloat(v, 'f', 1, 64)
+		}
+		errRate := strconv.FormatFloat(r.ErrorRate*100, 'f', 1, 64)
+		if err := table.WriteRow(r.URL, r.RPC, strconv.Itoa(r.OK), strconv.Itoa(r.Errors), errRate, ms(r.P50), ms(r.P90), ms(r.P99), ms(r.Max)); err != nil {
+			return err
+		}
+	}
+	for _, r := range list {
+		if r.LastError != "" {
+			log.WithFields(log.Fields{"url": r.URL, "rpc": r.RPC}).Warn(r.LastError)
+		}
+	}
+	return nil
+}
+
+// benchEndpoint sends opt.requests requests of the RPC keeping opt.concurrency of them in flight
+func (c *RootContext) benchEndpoint(client *tezos.RPCClient, u string, r *benchRPC, opt *benchOptions) (*benchResult, error) {
+	var (
+		mtx       sync.Mutex
+		latencies []time.Duration
+		errCount  int
+		lastErr   error
+		wg        sync.WaitGroup
+	)
+	jobs := make(chan int)
+	for w := 0; w < opt.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				ctx, cancel := context.WithTimeout(c.context, opt.timeout)
+				start := time.Now()
+				req, err := client.NewRequest(ctx, http.MethodGet, r.path(i), nil)
+				if err == nil {
+					var resp json.RawMessage
+					err = client.Do(req, &resp)
+				}
+				d := time.Since(start)
+				cancel()
+
+				mtx.Lock()
+				if err != nil {
+					errCount++
+					lastErr = err
+				} else {
+					latencies = append(latencies, d)
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+
+loop:
+	for i := 0; i < opt.requests; i++ {
+		select {
+		case jobs <- i:
+		case <-c.context.Done():
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := c.context.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res := benchResult{
+		URL:       u,
+		RPC:       r.name,
+		OK:        len(latencies),
+		Errors:    errCount,
+		ErrorRate: float64(errCount) / float64(len(latencies)+errCount),
+		P50:       milliseconds(percentile(latencies, 0.5)),
+		P90:       milliseconds(percentile(latencies, 0.9)),
+		P99:       milliseconds(percentile(latencies, 0.99)),
+	}
+	if len(latencies) != 0 {
+		res.Max = milliseconds(latencies[len(latencies)-1])
+	}
+	if lastErr != nil {
+		res.LastError = lastErr.Error()
+	}
+	return &res, nil
+}
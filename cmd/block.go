@@ -21,84 +21,56 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"math/big"
 	"os"
 	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	tezos "github.com/ecadlabs/go-tezos"
 	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/filter"
+	"github.com/ecadlabs/tez/protocol"
+	"github.com/mattn/go-isatty"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 const blockTemplateSrc = `{{range . -}}
-Block:        {{.Hash | au.BgGreen}}
-Predecessor:  {{.Header.Predecessor | au.Blue}}
-Successor:    {{with .Successor}}{{.Hash}}{{else}}--{{end}}
-Timestamp:    {{.Header.Timestamp}}
+Block:        {{.Hash | fit 14 | au.BgGreen}}
+Predecessor:  {{.Header.Predecessor | fit 14 | au.Blue}}
+Successor:    {{with .Successor}}{{.Hash | fit 14}}{{else}}--{{end}}
+Timestamp:    {{when .Header.Timestamp}}
 Level:        {{.Header.Level}}
 Cycle:        {{.Metadata.Level.Cycle}}
 Priority:     {{.Header.Priority}}
 Solvetime:    {{.Metadata.MaxOperationsTTL}}
-Baker:        {{.Metadata.Baker}}
+Baker:        {{.Metadata.Baker | fit 14}}
 Consumed Gas: {{.Metadata.ConsumedGas}}
-Volume:       {{printf "%.6f ꜩ" .Volume | au.Green}}
-Fees:         {{printf "%.6f ꜩ" .Fees}}
+Volume:       {{amount .VolumeMutez | au.Green}}
+Fees:         {{amount .FeesMutez}}
 Operations:   {{.OperationsNum}}
 
 {{end -}}
 `
 
-const (
-	opEndorsement               = "endorsement"
-	opSeedNonceRevelation       = "seed_nonce_revelation"
-	opDoubleEndorsementEvidence = "double_endorsement_evidence"
-	opDoubleBakingEvidence      = "double_baking_evidence"
-	opActivateAccount           = "activate_account"
-	opProposals                 = "proposals"
-	opBallot                    = "ballot"
-	opReveal                    = "reveal"
-	opTransaction               = "transaction"
-	opOrigination               = "origination"
-	opDelegation                = "delegation"
-)
-
-// TODO: not all of these operation are supported by the client library
-var knownKinds = map[string]string{
-	"endorsement":                 opEndorsement,
-	"end":                         opEndorsement,
-	"seed_nonce_revelation":       opSeedNonceRevelation,
-	"double_endorsement_evidence": opDoubleEndorsementEvidence,
-	"double_baking_evidence":      opDoubleBakingEvidence,
-	"activate_account":            opActivateAccount,
-	"act":                         opActivateAccount,
-	"proposals":                   opProposals,
-	"prop":                        opProposals,
-	"ballot":                      opBallot,
-	"bal":                         opBallot,
-	"reveal":                      opReveal,
-	"rev":                         opReveal,
-	"transaction":                 opTransaction,
-	"tx":                          opTransaction,
-	"origination":                 opOrigination,
-	"orig":                        opOrigination,
-	"delegation":                  opDelegation,
-	"del":                         opDelegation,
-}
-
-var operationTitles = map[string]string{
-	opEndorsement:               "Endorsement",
-	opSeedNonceRevelation:       "Nonce",
-	opDoubleEndorsementEvidence: "Double Endorsement Evidence",
-	opDoubleBakingEvidence:      "Double Baking Evidence",
-	opActivateAccount:           "Activation",
-	opProposals:                 "Proposals",
-	opBallot:                    "Ballot",
-	opReveal:                    "Reveal",
-	opTransaction:               "Transaction",
-	opOrigination:               "Origination",
-	opDelegation:                "Delegation",
+// Short aliases for the --kind flag. Full kind names known to the protocol registry are accepted as is
+var kindAliases = map[string]string{
+	"end":  protocol.KindEndorsement,
+	"act":  protocol.KindActivateAccount,
+	"prop": protocol.KindProposals,
+	"bal":  protocol.KindBallot,
+	"rev":  protocol.KindReveal,
+	"tx":   protocol.KindTransaction,
+	"orig": protocol.KindOrigination,
+	"del":  protocol.KindDelegation,
+	"att":  protocol.KindAttestation,
 }
 
 // BlockCommandContext represents `block' command context shared with its children
@@ -108,15 +80,91 @@ type BlockCommandContext struct {
 	templateFuncMap template.FuncMap
 	userTemplate    *template.Template
 	watch           bool
+	at              string
+	filterSrc       string
+	aliases         []string
+	execSrc         string
+	execConcurrency int
+	execRate        int
+	statePath       string
+	sinkURLs        []string
+	sinkKey         string
+	outputFile      string
+	output          io.Writer
+	// Compression of --output-file, empty means guessing by the file extension
+	compress   string
+	compressor utils.CompressWriter
+	outputFd   *os.File
+	tabular    bool
+	// CSV is only produced by aggregated outputs, see `block operations --group-by'
+	csv bool
+	// Markdown and HTML tables, unlike Parquet they can hold any flat value
+	report bool
+	wide   bool
+	slots  bool
+	// Graphviz graph of operation flows, only produced by `block operations'
+	dot bool
+	// Maximum width of tabular text output, 0 means no limit
+	maxWidth int
+	// Paths the JSON and YAML output is projected to, see utils.NewFieldsEncoder
+	fields []string
+	// Block filters, see --baker and --protocol
+	baker          string
+	bakerAddress   string
+	protocolFilter string
+	stats          bool
+	// Bounds of block timestamps, see --since and --until
+	windowOpt windowOptions
+	window    *timeWindow
 }
 
 type xblock struct {
 	*tezos.Block `yaml:",inline"`
-	Successor    *tezos.Block `json:"-" yaml:"-"`
+	Successor    *tezos.Block       `json:"-" yaml:"-"`
+	ProtocolInfo *protocol.Protocol `json:"-" yaml:"-"`
+	// Undecoded operations fetched when the block contains kinds not supported by the client library
+	// or when a stable output format is requested, see --api-version
+	rawOperations [][]map[string]interface{}
+	apiVersion    int
+	// Rounds amounts in tez of the encoded output, see --precision
+	amountFormat *utils.AmountFormat
+}
+
+// isGeneric returns true if the operation element can't be rendered using its decoded representation
+func (b *xblock) isGeneric(el tezos.OperationElem) bool {
+	if _, ok := el.(*tezos.GenericOperationElem); ok {
+		return true
+	}
+	return b.ProtocolInfo != nil && !b.ProtocolInfo.Supports(el.OperationElemKind())
+}
+
+// rawOperation returns undecoded operation by its position in the block
+func (b *xblock) rawOperation(pass, index int) map[string]interface{} {
+	if pass < len(b.rawOperations) && index < len(b.rawOperations[pass]) {
+		return b.rawOperations[pass][index]
+	}
+	return nil
+}
+
+// rawContent returns undecoded operation element
+func (b *xblock) rawContent(pass, index, content int) map[string]interface{} {
+	op := b.rawOperation(pass, index)
+	if op == nil {
+		return nil
+	}
+	contents, _ := op["contents"].([]interface{})
+	if content < len(contents) {
+		c, _ := contents[content].(map[string]interface{})
+		return c
+	}
+	return nil
 }
 
+// Amounts are summed up in mutez and converted to tez for convenience
 type xblockInfo struct {
 	*xblock
+	VolumeMutez   *big.Int
+	FeesMutez     *big.Int
 	Volume        *big.Float
 	Fees          *big.Float
 	OperationsNum int
@@ -150,172 +198,484 @@ func NewBlockCommand(rootCtx *RootContext) *cobra.Command {
 				}
 			}
 
-			ctx.newEncoder = utils.GetEncoderFunc(outputFormat)
-			ctx.templateFuncMap = template.FuncMap{"au": func() interface{} { return ctx.colorizer }}
-
-			if userTemplate != "" {
-				tpl, err := template.New("user").Funcs(ctx.templateFuncMap).Parse(userTemplate)
-				if err != nil {
-					return nil
-				}
-				ctx.userTemplate = tpl
-			}
+			return ctx.init(outputFormat, userTemplate)
+		},
 
-			return nil
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			defer ctx.closeOutput(&err)
+			return ctx.showBlocks(args)
 		},
+	}
 
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 {
-				args = []string{"head"}
-			}
+	// Just an alias
+	headerCmd := &cobra.Command{
+		Use:   "header",
+		Short: "Block header summary",
+		RunE:  blockCmd.RunE,
+	}
 
-			var enc utils.Encoder
-			if ctx.newEncoder != nil {
-				enc = ctx.newEncoder(os.Stdout)
-			}
+	blockCmd.PersistentFlags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, csv, yaml, json, markdown, html, dot, msgpack, cbor, parquet]")
+	blockCmd.PersistentFlags().StringVar(&ctx.outputFile, "output-file", "", "Write the output to the file instead of stdout")
+	blockCmd.PersistentFlags().StringVar(&ctx.compress, "compress", "", "Compress --output-file: one of [none, gzip, zstd] (default is guessed by the .gz or .zst file extension)")
+	blockCmd.PersistentFlags().BoolVar(&ctx.wide, "wide", false, "Don't truncate hashes and addresses to fit the terminal width")
+	blockCmd.PersistentFlags().StringVar(&userTemplate, "output-fmt", "", "Output format (Go template)")
+	blockCmd.PersistentFlags().StringSliceVar(&ctx.fields, "fields", nil, "Only output the given fields of JSON and YAML objects, e.g. hash,header.level,metadata.baker")
+	blockCmd.PersistentFlags().BoolVar(&ctx.watch, "watch", false, "Ignore provided IDs and watch for new head blocks in a chain")
+	blockCmd.PersistentFlags().StringVar(&ctx.at, "at", "", "Select the last block produced at or before the given time (same as `@<time>' argument)")
+	addWindowFlags(blockCmd.PersistentFlags(), &ctx.windowOpt, "blocks produced")
+	blockCmd.PersistentFlags().StringVar(&ctx.filterSrc, "filter", "", "Only output events matching the expression, e.g. 'kind == \"transaction\" && amount > 1000'")
+	blockCmd.PersistentFlags().StringVar(&ctx.execSrc, "exec", "", "Run the command (Go template) for each matching event in watch mode, e.g. 'notify.sh {{.Hash}}'")
+	blockCmd.PersistentFlags().IntVar(&ctx.execConcurrency, "exec-concurrency", 1, "Maximum number of --exec commands running at once")
+	blockCmd.PersistentFlags().IntVar(&ctx.execRate, "exec-rate", 0, "Maximum number of --exec commands started per minute, excess events are dropped (0 means no limit)")
+	blockCmd.PersistentFlags().StringVar(&ctx.statePath, "resume-from-state", "", "Checkpoint the last processed block to the file and backfill blocks missed since the previous run in watch mode, e.g. ~/.tez/state.json")
+	blockCmd.PersistentFlags().StringSliceVar(&ctx.sinkURLs, "sink", nil, "Publish every event as JSON to the message broker or webhook, e.g. kafka://broker:9092/topic, nats://host:4222/subject or https://host/path (may be repeated)")
+	blockCmd.PersistentFlags().StringVar(&ctx.sinkKey, "sink-key", "", "Message key (Go template) for --sink, default is the block hash for blocks and the source address for operations")
+	blockCmd.PersistentFlags().StringSliceVar(&ctx.aliases, "alias", nil, "Address alias for use in --filter as alias(\"name\"), given as name=address")
+	blockCmd.Flags().BoolVar(&ctx.slots, "slots", false, "Show the map of consensus slots attested by the block's operations")
+	blockCmd.Flags().StringVar(&ctx.baker, "baker", "", "Only output blocks baked by the delegate (address or keystore alias), missed slots are logged in watch mode")
+	blockCmd.Flags().StringVar(&ctx.protocolFilter, "protocol", "", "Only output blocks of the protocol (hash or its prefix)")
+	blockCmd.Flags().BoolVar(&ctx.stats, "stats", false, "Print the number of blocks seen, shown and baked by --baker on exit in watch mode")
+	blockCmd.AddCommand(headerCmd)
 
-			// Standard template
-			tpl, err := template.New("block").Funcs(ctx.templateFuncMap).Parse(blockTemplateSrc)
-			if err != nil {
-				return err
+	blockCmd.AddCommand(newBlockOperationsCommand(&ctx))
+
+	return blockCmd
+}
+
+func (c *BlockCommandContext) init(outputFormat, userTemplate string) error {
+	c.newEncoder = c.encoderFunc(outputFormat)
+	c.tabular = utils.IsTabular(outputFormat)
+	c.csv = strings.ToLower(outputFormat) == "csv"
+	c.report = utils.IsReport(outputFormat)
+	c.dot = strings.ToLower(outputFormat) == "dot"
+	if len(c.fields) != 0 {
+		switch strings.ToLower(outputFormat) {
+		case "json", "yaml":
+			newEncoder := c.newEncoder
+			c.newEncoder = func(w io.Writer) utils.Encoder {
+				return utils.NewFieldsEncoder(newEncoder(w), c.fields)
+			}
+		default:
+			return errors.New("--fields is only supported by json and yaml encodings")
+		}
+	}
+	c.output = os.Stdout
+	if c.outputFile != "" {
+		compress := c.compress
+		if compress == "" {
+			compress = utils.CompressionFromExt(c.outputFile)
+		}
+		fd, err := os.Create(c.outputFile)
+		if err != nil {
+			return err
+		}
+		if c.compressor, err = utils.NewCompressWriter(fd, compress); err != nil {
+			fd.Close()
+			os.Remove(c.outputFile)
+			return err
+		}
+		c.output, c.outputFd = fd, fd
+		if c.compressor != nil {
+			c.output = c.compressor
+		}
+	} else if c.compress != "" {
+		return errors.New("--compress requires --output-file")
+	} else if utils.IsBinary(outputFormat) && isatty.IsTerminal(os.Stdout.Fd()) {
+		return fmt.Errorf("Refusing to write %s output to a terminal, use --output-file", outputFormat)
+	} else if !c.wide {
+		c.maxWidth = utils.TerminalWidth(os.Stdout)
+	}
+	var err error
+	if c.window, err = c.windowOpt.window(); err != nil {
+		return err
+	}
+	c.templateFuncMap = template.FuncMap{
+		"au":     func() interface{} { return c.colorizer },
+		"amount": c.amountFormat.Format,
+		"time":   func(t time.Time) string { return c.utcTime(t).Format("2006-01-02 15:04:05 MST") },
+		"ago":    func(t time.Time) string { return utils.FormatAgo(t, time.Now()) },
+		// Relative time unless --wide is given
+		"when": func(t time.Time) string {
+			if c.wide {
+				return c.utcTime(t).Format("2006-01-02 15:04:05 MST")
+			}
+			return utils.FormatAgo(t, time.Now())
+		},
+		// Ellipsize the value to fit the rest of the line after the indent
+		"fit": func(indent int, s string) string {
+			if c.maxWidth == 0 || indent+len(s) <= c.maxWidth {
+				return s
 			}
+			return utils.Ellipsize(s, c.maxWidth-indent)
+		},
+	}
 
-			if ctx.watch {
-				var monErr error
-				ch := make(chan *tezos.BlockInfo, 10)
-				go func() {
-					monErr = ctx.monitorHeads(ch)
-					close(ch)
-				}()
-
-				var (
-					tplErr error
-					tplCh  chan *xblockInfo
-					tplSem chan struct{}
-				)
-
-				if enc == nil && ctx.userTemplate == nil {
-					tplCh = make(chan *xblockInfo, 10)
-					tplSem = make(chan struct{})
-
-					// Run template engine in background
-					go func() {
-						tplErr = tpl.Execute(os.Stdout, tplCh)
-						close(tplSem)
-					}()
-				}
+	if userTemplate != "" {
+		tpl, err := template.New("user").Funcs(c.templateFuncMap).Parse(userTemplate)
+		if err != nil {
+			return err
+		}
+		c.userTemplate = tpl
+	}
 
-				var (
-					lastLevel          int
-					firstBlockReceived bool
-				)
-				for bi := range ch {
-					if firstBlockReceived && bi.Level <= lastLevel {
-						continue
-					}
-					firstBlockReceived = true
-					lastLevel = bi.Level
-
-					block, err := ctx.getBlock(bi.Hash, false)
-					if err != nil {
-						if err != context.Canceled {
-							return err
-						}
-						return nil
-					}
+	return nil
+}
 
-					if enc != nil {
-						if err := enc.Encode(block); err != nil {
-							return err
-						}
-						continue
-					}
+// eventFilter compiles the --filter expression against the given set of fields
+func (c *BlockCommandContext) eventFilter(env filter.Env) (*filter.Expr, error) {
+	if c.filterSrc == "" {
+		return nil, nil
+	}
+	return newEventFilter(c.filterSrc, c.aliases, env)
+}
 
-					info := getBlockInfo(block)
-					if ctx.userTemplate != nil {
-						if err := ctx.userTemplate.Execute(os.Stdout, info); err != nil {
-							return err
-						}
-						continue
-					}
-					// Send to the template
-					tplCh <- info
-				}
+// execHook returns the --exec hook if any
+func (c *BlockCommandContext) execHook() (*execHook, error) {
+	if c.execSrc == "" {
+		return nil, nil
+	}
+	if !c.watch {
+		return nil, errors.New("--exec requires --watch")
+	}
+	return newExecHook(c.context, c.execSrc, c.templateFuncMap, c.execConcurrency, c.execRate)
+}
 
-				if tplCh != nil {
-					close(tplCh)
-					<-tplSem
-					if tplErr != nil {
-						return tplErr
-					}
+// eventSinks connects to --sink destinations if any
+func (c *BlockCommandContext) eventSinks(defaultKey string) (*eventSinks, error) {
+	if len(c.sinkURLs) == 0 {
+		return nil, nil
+	}
+	key := c.sinkKey
+	if key == "" {
+		key = defaultKey
+	}
+	return c.newEventSinks(c.sinkURLs, key, c.templateFuncMap)
+}
+
+// flushOutput writes out the compressed data buffered so far, see --compress
+func (c *BlockCommandContext) flushOutput() error {
+	if c.compressor != nil {
+		return c.compressor.Flush()
+	}
+	return nil
+}
+
+// closeOutput finishes the compressed stream and closes --output-file keeping the first error
+func (c *BlockCommandContext) closeOutput(err *error) {
+	if c.compressor != nil {
+		if e := c.compressor.Close(); *err == nil {
+			*err = e
+		}
+	}
+	if c.outputFd != nil {
+		if e := c.outputFd.Close(); *err == nil {
+			*err = e
+		}
+	}
+}
+
+// closeEncoder finalizes encoders which buffer their output (e.g. Parquet) keeping the first error
+func closeEncoder(enc utils.Encoder, err *error) {
+	if cl, ok := enc.(io.Closer); ok {
+		if e := cl.Close(); *err == nil {
+			*err = e
+		}
+	}
+}
+
+// blockArgs returns block queries to process
+func (c *BlockCommandContext) blockArgs(args []string) ([]string, error) {
+	if c.at != "" {
+		args = append(args, "@"+c.at)
+	}
+	// Without IDs --since selects the blocks produced since then
+	if len(args) == 0 && c.window != nil && !c.window.since.IsZero() && !c.watch {
+		args = []string{c.window.blockRange("head")}
+	}
+	if len(args) == 0 {
+		args = []string{"head"}
+	}
+	return c.expandBlockArgs(args)
+}
+
+func (c *BlockCommandContext) showBlocks(args []string) (err error) {
+	args, err = c.blockArgs(args)
+	if err != nil {
+		return err
+	}
+	if c.csv {
+		return errCSVGroupOnly
+	}
+	if c.dot {
+		return errDOTOperationsOnly
+	}
+	if c.slots && (c.newEncoder != nil || c.userTemplate != nil) {
+		return errors.New("--slots is only supported by the standard text output")
+	}
+	if c.stats && !c.watch {
+		return errors.New("--stats requires --watch")
+	}
+	if err := c.resolveBlockFilters(); err != nil {
+		return err
+	}
+
+	var enc utils.Encoder
+	if c.newEncoder != nil {
+		enc = c.newEncoder(c.output)
+		defer closeEncoder(enc, &err)
+	}
+
+	expr, err := c.eventFilter(blockEnv(nil))
+	if err != nil {
+		return err
+	}
+
+	hook, err := c.execHook()
+	if err != nil {
+		return err
+	}
+
+	sinks, err := c.eventSinks(blockSinkKey)
+	if err != nil {
+		return err
+	}
+	if sinks != nil {
+		defer sinks.close()
+	}
+
+	// Standard template
+	tpl, err := template.New("block").Funcs(c.templateFuncMap).Parse(blockTemplateSrc)
+	if err != nil {
+		return err
+	}
+
+	if c.watch {
+		if hook != nil {
+			defer hook.wait()
+		}
+
+		state, err := c.watchState()
+		if err != nil {
+			return err
+		}
+
+		var monErr error
+		ch := make(chan *tezos.BlockInfo, 10)
+		go func() {
+			monErr = c.monitorHeadsFrom(state.last(), ch)
+			close(ch)
+		}()
+
+		var (
+			tplErr error
+			tplCh  chan *xblockInfo
+			tplSem chan struct{}
+		)
+
+		if enc == nil && c.userTemplate == nil && !c.slots {
+			tplCh = make(chan *xblockInfo, 10)
+			tplSem = make(chan struct{})
+
+			// Run template engine in background
+			go func() {
+				tplErr = tpl.Execute(c.output, tplCh)
+				close(tplSem)
+			}()
+		}
+
+		session := blockSession{start: time.Now()}
+		if c.stats {
+			defer session.write(os.Stderr, c.bakerAddress)
+		}
+
+		process := func(block *xblock) error {
+			if !c.countBlock(&session, block) {
+				return nil
+			}
+			info := getBlockInfo(block)
+			if expr != nil {
+				ok, err := expr.Match(blockEnv(info))
+				if err != nil || !ok {
+					return err
 				}
+			}
+			session.shown++
 
-				if monErr != nil && monErr != context.Canceled {
-					return monErr
+			if hook != nil {
+				if err := hook.run(info); err != nil {
+					return err
 				}
-				return nil
 			}
 
-			// Get all at once
-			blocks := make([]*xblock, len(args))
-			for i, blockID := range args {
-				block, err := ctx.getBlock(blockID, enc == nil)
-				if err != nil {
+			if sinks != nil {
+				if err := sinks.publish(info, block.output()); err != nil {
 					return err
 				}
-				blocks[i] = block
 			}
 
 			if enc != nil {
-				// Encode as a slice
-				return enc.Encode(blocks)
+				if c.tabular {
+					return enc.Encode(newBlockRow(info))
+				}
+				return enc.Encode(block.output())
+			}
+
+			if c.userTemplate != nil {
+				return c.userTemplate.Execute(c.output, info)
 			}
+			if c.slots {
+				return c.writeBlockWithSlots(tpl, block, info)
+			}
+			// Send to the template
+			tplCh <- info
+			return nil
+		}
 
-			info := make([]*xblockInfo, len(blocks))
-			for i, b := range blocks {
-				info[i] = getBlockInfo(b)
+		var ended bool
+		lastLevel, firstBlockReceived := state.Level, state.Hash != ""
+		for bi := range ch {
+			if firstBlockReceived && bi.Level <= lastLevel {
+				continue
 			}
+			firstBlockReceived = true
+			lastLevel = bi.Level
 
-			if ctx.userTemplate != nil {
-				for _, bi := range info {
-					if err := ctx.userTemplate.Execute(os.Stdout, bi); err != nil {
-						return err
-					}
+			block, err := c.getBlock(bi.Hash, false)
+			if err != nil {
+				if err != context.Canceled {
+					return err
 				}
 				return nil
 			}
+			if c.window.ended(block.Header.Timestamp) {
+				ended = true
+				break
+			}
 
-			// Standard template expects a slice or a channel
-			return tpl.Execute(os.Stdout, info)
-		},
+			if err := process(block); err != nil {
+				return err
+			}
+			if err := c.flushOutput(); err != nil {
+				return err
+			}
+			if err := state.save(bi); err != nil {
+				return err
+			}
+		}
+
+		if tplCh != nil {
+			close(tplCh)
+			<-tplSem
+			if tplErr != nil {
+				return tplErr
+			}
+		}
+
+		if !ended && monErr != nil && monErr != context.Canceled {
+			return monErr
+		}
+		return nil
 	}
 
-	// Just an alias
-	headerCmd := &cobra.Command{
-		Use:   "header",
-		Short: "Block header summary",
-		RunE:  blockCmd.RunE,
+	// Get all at once
+	blocks := make([]*xblock, len(args))
+	for i, blockID := range args {
+		block, err := c.getBlock(blockID, enc == nil)
+		if err != nil {
+			return err
+		}
+		blocks[i] = block
 	}
 
-	blockCmd.PersistentFlags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
-	blockCmd.PersistentFlags().StringVar(&userTemplate, "output-fmt", "", "Output format (Go template)")
-	blockCmd.PersistentFlags().BoolVar(&ctx.watch, "watch", false, "Ignore provided IDs and watch for new head blocks in a chain")
-	blockCmd.AddCommand(headerCmd)
+	info := make([]*xblockInfo, 0, len(blocks))
+	selected := make([]*xblock, 0, len(blocks))
+	for _, b := range blocks {
+		if !c.matchBlock(b) {
+			continue
+		}
+		bi := getBlockInfo(b)
+		if expr != nil {
+			ok, err := expr.Match(blockEnv(bi))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if sinks != nil {
+			if err := sinks.publish(bi, b.output()); err != nil {
+				return err
+			}
+		}
+		info = append(info, bi)
+		selected = append(selected, b)
+	}
 
-	blockCmd.AddCommand(newBlockOperationsCommand(&ctx))
+	if enc != nil {
+		if c.tabular {
+			rows := make([]*blockRow, len(info))
+			for i, bi := range info {
+				rows[i] = newBlockRow(bi)
+			}
+			return enc.Encode(rows)
+		}
+		// Encode as a slice
+		out := make([]interface{}, len(selected))
+		for i, b := range selected {
+			out[i] = b.output()
+		}
+		return enc.Encode(out)
+	}
 
-	return blockCmd
+	if c.userTemplate != nil {
+		for _, bi := range info {
+			if err := c.userTemplate.Execute(c.output, bi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if c.slots {
+		for i, bi := range info {
+			if err := c.writeBlockWithSlots(tpl, selected[i], bi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Standard template expects a slice or a channel
+	return tpl.Execute(c.output, info)
 }
 
-func (c *BlockCommandContext) getBlock(query string, getSuccessor bool) (*xblock, error) {
+// writeBlockWithSlots renders the block using the standard template followed by its consensus slot map
+func (c *BlockCommandContext) writeBlockWithSlots(tpl *template.Template, block *xblock, info *xblockInfo) error {
+	m, err := c.getSlotMap(block)
+	if err != nil {
+		return err
+	}
+	// Put the map before the blank line separating blocks
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, []*xblockInfo{info}); err != nil {
+		return err
+	}
+	if _, err := c.output.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))); err != nil {
+		return err
+	}
+	return c.writeSlotMap(c.output, m)
+}
+
+// parseBlockQuery splits block query like `head~2', `head-2', `BL...+1' or `1000' into the block ID and the level offset
+func parseBlockQuery(query string) (id string, offset int, err error) {
 	var i int
 	for i < len(query) && (query[i] >= '0' && query[i] <= '9' || query[i] >= 'a' && query[i] <= 'z' || query[i] >= 'A' && query[i] <= 'Z') {
 		i++
 	}
 
-	id := query[:i]
+	id = query[:i]
 
-	var offset int
 	if i < len(query) {
 		// parse the offset
 		sign := 1
@@ -330,7 +690,7 @@ func (c *BlockCommandContext) getBlock(query string, getSuccessor bool) (*xblock
 		if i < len(query) {
 			v, err := strconv.ParseInt(query[i:], 10, 32)
 			if err != nil {
-				return nil, err
+				return "", 0, err
 			}
 			offset = int(v)
 		}
@@ -338,10 +698,29 @@ func (c *BlockCommandContext) getBlock(query string, getSuccessor bool) (*xblock
 		offset *= sign
 	}
 
-	var (
-		block *tezos.Block
-		err   error
-	)
+	return id, offset, nil
+}
+
+func (c *BlockCommandContext) getBlock(query string, getSuccessor bool) (*xblock, error) {
+	if len(query) != 0 && query[0] == '@' {
+		// Time based lookup
+		t, err := utils.ParseTime(query[1:])
+		if err != nil {
+			return nil, err
+		}
+		level, err := c.blockLevelAt(t)
+		if err != nil {
+			return nil, err
+		}
+		query = strconv.Itoa(level)
+	}
+
+	id, offset, err := parseBlockQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var block *tezos.Block
 
 	if len(id) == 0 || (id[0] >= '0' && id[0] <= '9') {
 		// parse level
@@ -373,8 +752,33 @@ func (c *BlockCommandContext) getBlock(query string, getSuccessor bool) (*xblock
 		}
 	}
 
+	if err := c.verifyBlock(block); err != nil {
+		return nil, err
+	}
+
 	xb := xblock{
-		Block: block,
+		Block:        block,
+		ProtocolInfo: protocol.Lookup(block.Protocol),
+		apiVersion:   c.apiVersion,
+		amountFormat: c.amountFormat,
+	}
+
+	if xb.ProtocolInfo == nil {
+		log.WithField("protocol", block.Protocol).Debug("Unknown protocol, operations will be rendered generically")
+	}
+
+	var generic bool
+	for _, ol := range block.Operations {
+		for _, o := range ol {
+			for _, el := range o.Contents {
+				generic = generic || xb.isGeneric(el)
+			}
+		}
+	}
+	if generic || xb.apiVersion >= 1 {
+		if err := c.getRPC(c.blockPath(block.Hash)+"/operations", &xb.rawOperations); err != nil {
+			return nil, err
+		}
 	}
 
 	if getSuccessor {
@@ -384,47 +788,44 @@ func (c *BlockCommandContext) getBlock(query string, getSuccessor bool) (*xblock
 	return &xb, nil
 }
 
-func (c *BlockCommandContext) monitorHeads(results chan<- *tezos.BlockInfo) (err error) {
-	// Some endpoints closes connection
-	for err == nil {
-		err = c.service.MonitorHeads(c.context, c.chainID, results)
-	}
-	return
-}
-
 func getBlockInfo(b *xblock) *xblockInfo {
 	bi := xblockInfo{
-		xblock: b,
-		Volume: big.NewFloat(0),
-		Fees:   big.NewFloat(0),
+		xblock:      b,
+		VolumeMutez: big.NewInt(0),
+		FeesMutez:   big.NewInt(0),
 	}
 
-	for _, ol := range b.Operations {
-		for _, o := range ol {
+	for i, ol := range b.Operations {
+		for j, o := range ol {
 			bi.OperationsNum += len(o.Contents)
 
-			for _, c := range o.Contents {
+			for k, c := range o.Contents {
+				if b.isGeneric(c) {
+					var oi opInfo
+					fillGenericOpInfo(&oi, b.rawContent(i, j, k))
+					if oi.FeeMutez != nil {
+						bi.FeesMutez.Add(bi.FeesMutez, oi.FeeMutez)
+					}
+					continue
+				}
+
 				if el, ok := c.(tezos.OperationWithFee); ok {
-					var fee big.Float
 					if f := el.OperationFee(); f != nil {
-						fee.SetInt(f)
-						bi.Fees.Add(bi.Fees, &fee)
+						bi.FeesMutez.Add(bi.FeesMutez, f)
 					}
 				}
 
 				if el, ok := c.(*tezos.TransactionOperationElem); ok {
-					var amount big.Float
 					if el.Amount != nil {
-						amount.SetInt(&el.Amount.Int)
-						bi.Volume.Add(bi.Volume, &amount)
+						bi.VolumeMutez.Add(bi.VolumeMutez, &el.Amount.Int)
 					}
 				}
 			}
 		}
 	}
 
-	bi.Volume.Mul(bi.Volume, big.NewFloat(1e-6))
-	bi.Fees.Mul(bi.Fees, big.NewFloat(1e-6))
+	bi.Volume = b.amountFormat.Tez(bi.VolumeMutez)
+	bi.Fees = b.amountFormat.Tez(bi.FeesMutez)
 
 	return &bi
 }
@@ -0,0 +1,155 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bakingRight is an element of the baking rights list
+type bakingRight struct {
+	Level    int32  `json:"level"`
+	Delegate string `json:"delegate"`
+	Round    int32  `json:"round"`
+}
+
+// blockSession counts blocks processed in watch mode, see --stats
+type blockSession struct {
+	start  time.Time
+	seen   int
+	shown  int
+	baked  int
+	missed int
+}
+
+func (s *blockSession) write(w io.Writer, baker string) error {
+	_, err := fmt.Fprintf(w, "Session: %d blocks in %v, %d shown", s.seen, time.Since(s.start).Round(time.Second), s.shown)
+	if err == nil && baker != "" {
+		_, err = fmt.Fprintf(w, ", %d baked by %s, %d missed slots", s.baked, baker, s.missed)
+	}
+	if err == nil {
+		_, err = fmt.Fprintln(w)
+	}
+	return err
+}
+
+// blockRound returns the round of a Tenderbake block which is the last element of its fitness
+func blockRound(b *xblock) int32 {
+	if len(b.Header.Fitness) == 0 {
+		return 0
+	}
+	var v int32
+	for _, x := range b.Header.Fitness[len(b.Header.Fitness)-1] {
+		v = v<<8 | int32(x)
+	}
+	return v
+}
+
+// resolveBlockFilters resolves the --baker alias
+func (c *BlockCommandContext) resolveBlockFilters() error {
+	if c.baker == "" {
+		return nil
+	}
+	addr, err := c.resolveAddress(c.baker)
+	if err != nil {
+		return err
+	}
+	c.bakerAddress = addr
+	return nil
+}
+
+// matchBlock returns true if the block passes --protocol, --baker, --since and --until filters
+func (c *BlockCommandContext) matchBlock(b *xblock) bool {
+	if !c.window.contains(b.Header.Timestamp) {
+		return false
+	}
+	if c.protocolFilter != "" && !strings.HasPrefix(b.Protocol, c.protocolFilter) {
+		return false
+	}
+	return c.bakerAddress == "" || b.Metadata.Baker == c.bakerAddress
+}
+
+// missedRound returns the baker's earliest round at the block level if it's earlier than the round the block was baked at
+func (c *BlockCommandContext) missedRound(b *xblock) (round int32, missed bool, err error) {
+	round = blockRound(b)
+	if round == 0 {
+		return 0, false, nil
+	}
+	q := url.Values{
+		"level":     []string{strconv.FormatInt(int64(b.Header.Level), 10)},
+		"delegate":  []string{c.bakerAddress},
+		"max_round": []string{strconv.FormatInt(int64(round-1), 10)},
+	}
+	var rights []*bakingRight
+	if err := c.getRPC(c.blockPath(b.Hash)+"/helpers/baking_rights?"+q.Encode(), &rights); err != nil {
+		return 0, false, err
+	}
+	if len(rights) == 0 {
+		return 0, false, nil
+	}
+	return rights[0].Round, true, nil
+}
+
+// countBlock updates the session counters and reports whether the block should be shown. In watch mode
+// blocks baked by someone else at a round the baker had the right to are logged as missed slots
+func (c *BlockCommandContext) countBlock(s *blockSession, b *xblock) bool {
+	s.seen++
+	if !c.window.contains(b.Header.Timestamp) {
+		return false
+	}
+	if c.protocolFilter != "" && !strings.HasPrefix(b.Protocol, c.protocolFilter) {
+		return false
+	}
+	if c.bakerAddress == "" {
+		return true
+	}
+	if b.Metadata.Baker == c.bakerAddress {
+		s.baked++
+		return true
+	}
+
+	round, missed, err := c.missedRound(b)
+	if err != nil {
+		log.WithError(err).WithField("block_level", b.Header.Level).Warn("Can't get baking rights")
+		return false
+	}
+	if missed {
+		s.missed++
+		log.WithFields(log.Fields{
+			"block_level": b.Header.Level,
+			"round":       round,
+			"baked_by":    b.Metadata.Baker,
+		}).Warn("Baking slot missed")
+	} else {
+		log.WithFields(log.Fields{
+			"block_level": b.Header.Level,
+			"baked_by":    b.Metadata.Baker,
+		}).Debug("Block baked by another delegate")
+	}
+	return false
+}
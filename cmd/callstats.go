@@ -0,0 +1,333 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/protocol"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the per-entrypoint table of `contract stats'
+var entrypointStatsColumns = []utils.TableColumn{
+	{Header: "ENTRYPOINT", Width: 24, MinWidth: 12},
+	{Header: "CALLS", Width: 8, Align: utils.AlignRight},
+	{Header: "INTERNAL", Width: 8, Align: utils.AlignRight},
+	{Header: "FAILED", Width: 8, Align: utils.AlignRight},
+	{Header: "FAILURE RATE", Width: 12, Align: utils.AlignRight},
+	{Header: "AVG GAS", Width: 10, Align: utils.AlignRight},
+}
+
+// Columns of the top callers table of `contract stats'
+var callerStatsColumns = []utils.TableColumn{
+	{Header: "RANK", Width: 4, Align: utils.AlignRight},
+	{Header: "CALLER", Width: 36, MinWidth: 12},
+	{Header: "CALLS", Width: 8, Align: utils.AlignRight},
+	{Header: "FAILED", Width: 8, Align: utils.AlignRight},
+}
+
+type callStatsOptions struct {
+	last   int
+	top    int
+	format string
+	crawl  crawlOptions
+}
+
+// entrypointStats aggregates calls of a single entrypoint. The average gas is taken over applied calls
+type entrypointStats struct {
+	Entrypoint  string  `json:"entrypoint" yaml:"entrypoint"`
+	Calls       int     `json:"calls" yaml:"calls"`
+	Internal    int     `json:"internal" yaml:"internal"`
+	Failed      int     `json:"failed" yaml:"failed"`
+	FailureRate float64 `json:"failure_rate" yaml:"failure_rate"`
+	AverageGas  float64 `json:"average_gas" yaml:"average_gas"`
+	applied     int
+	gas         big.Int
+}
+
+type callerStats struct {
+	Rank    int    `json:"rank" yaml:"rank"`
+	Address string `json:"address" yaml:"address"`
+	Calls   int    `json:"calls" yaml:"calls"`
+	Failed  int    `json:"failed" yaml:"failed"`
+}
+
+type contractCallStats struct {
+	Contract    string             `json:"contract" yaml:"contract"`
+	FirstLevel  int                `json:"first_level" yaml:"first_level"`
+	LastLevel   int                `json:"last_level" yaml:"last_level"`
+	Calls       int                `json:"calls" yaml:"calls"`
+	Failed      int                `json:"failed" yaml:"failed"`
+	FailureRate float64            `json:"failure_rate" yaml:"failure_rate"`
+	Entrypoints []*entrypointStats `json:"entrypoints" yaml:"entrypoints"`
+	TopCallers  []*callerStats     `json:"top_callers" yaml:"top_callers"`
+}
+
+// contractCall holds the fields of transactions, internal ones included, needed to aggregate calls
+type contractCall struct {
+	Kind        string `json:"kind"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Parameters  *struct {
+		Entrypoint string `json:"entrypoint"`
+	} `json:"parameters"`
+	Result   *operationResult `json:"result"`
+	Metadata *struct {
+		OperationResult          *operationResult `json:"operation_result"`
+		InternalOperationResults []*contractCall  `json:"internal_operation_results"`
+	} `json:"metadata"`
+}
+
+func (t *contractCall) entrypoint() string {
+	if t.Parameters == nil || t.Parameters.Entrypoint == "" {
+		return "default"
+	}
+	return t.Parameters.Entrypoint
+}
+
+func newContractStatsCommand(rootCtx *RootContext) *cobra.Command {
+	var opt callStatsOptions
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "stats <KT1...|alias>",
+		Short: "Per-entrypoint call statistics",
+		Long: `Aggregate calls of the contract per entrypoint over the last N blocks up to the head (or --block): the number of calls,
+internal calls made by other contracts, failures and the average gas of applied calls, along with the top callers.
+Calls are failed if they aren't applied, i.e. failed, backtracked or skipped.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEnc utils.NewEncoderFunc
+			if opt.format != "text" {
+				if newEnc = rootCtx.encoderFunc(opt.format); newEnc == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+					return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+				}
+			}
+			address, err := ctx.contractAddress(args[0])
+			if err != nil {
+				return err
+			}
+			levels, err := ctx.windowArgs(opt.last)
+			if err != nil {
+				return err
+			}
+			s, err := ctx.contractCallStats(address, levels, &opt)
+			if err != nil {
+				return err
+			}
+			if newEnc != nil {
+				return newEnc(os.Stdout).Encode(s)
+			}
+			return writeContractCallStats(os.Stdout, s)
+		},
+	}
+
+	f := cmd.Flags()
+	f.IntVar(&opt.last, "last", 1000, "Number of blocks up to the head (or --block) to scan")
+	f.IntVar(&opt.top, "top", 10, "Number of top callers to show (0 means all)")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	addCrawlFlags(cmd, &opt.crawl, 8, "blocks")
+
+	return cmd
+}
+
+// contractCallStats crawls manager operations of the blocks collecting calls of the contract
+func (c *RootContext) contractCallStats(address string, levels []string, opt *callStatsOptions) (*contractCallStats, error) {
+	if err := c.primeCache(); err != nil {
+		return nil, err
+	}
+
+	fetch := func(ctx context.Context, query string) (interface{}, error) {
+		var ops []*struct {
+			Contents []*contractCall `json:"contents"`
+		}
+		if err := c.getRPC(fmt.Sprintf("%s/operations/%d", c.blockPath(query), managerPass), &ops); err != nil {
+			return nil, crawlError(err)
+		}
+		var calls []*contractCall
+		for _, op := range ops {
+			for _, el := range op.Contents {
+				if el.Metadata == nil {
+					continue
+				}
+				el.Result = el.Metadata.OperationResult
+				calls = append(calls, el)
+				calls = append(calls, el.Metadata.InternalOperationResults...)
+			}
+		}
+		return calls, nil
+	}
+
+	s := contractCallStats{
+		Contract:   address,
+		FirstLevel: math.MaxInt32,
+	}
+	entrypoints := make(map[string]*entrypointStats)
+	callers := make(map[string]*callerStats)
+	err := c.newCrawler(&opt.crawl, "blocks").Run(c.context, levels, fetch, func(i int, query string, v interface{}) error {
+		level, err := strconv.Atoi(query)
+		if err != nil {
+			return err
+		}
+		if level < s.FirstLevel {
+			s.FirstLevel = level
+		}
+		if level > s.LastLevel {
+			s.LastLevel = level
+		}
+
+		for _, t := range v.([]*contractCall) {
+			if t.Kind != protocol.KindTransaction || t.Destination != address || t.Result == nil {
+				continue
+			}
+			name := t.entrypoint()
+			e, ok := entrypoints[name]
+			if !ok {
+				e = &entrypointStats{Entrypoint: name}
+				entrypoints[name] = e
+			}
+			cs, ok := callers[t.Source]
+			if !ok {
+				cs = &callerStats{Address: t.Source}
+				callers[t.Source] = cs
+			}
+
+			s.Calls++
+			e.Calls++
+			cs.Calls++
+			if t.Metadata == nil {
+				e.Internal++
+			}
+			if t.Result.Status != "applied" {
+				s.Failed++
+				e.Failed++
+				cs.Failed++
+				continue
+			}
+			e.applied++
+			e.gas.Add(&e.gas, t.Result.gas())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.Calls == 0 {
+		s.FirstLevel = s.LastLevel
+	}
+
+	s.FailureRate = failureRate(s.Failed, s.Calls)
+	s.Entrypoints = make([]*entrypointStats, 0, len(entrypoints))
+	for _, e := range entrypoints {
+		e.FailureRate = failureRate(e.Failed, e.Calls)
+		if e.applied != 0 {
+			gas, _ := new(big.Float).SetInt(&e.gas).Float64()
+			e.AverageGas = gas / float64(e.applied)
+		}
+		s.Entrypoints = append(s.Entrypoints, e)
+	}
+	sort.Slice(s.Entrypoints, func(i, j int) bool {
+		a, b := s.Entrypoints[i], s.Entrypoints[j]
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		return a.Entrypoint < b.Entrypoint
+	})
+
+	s.TopCallers = make([]*callerStats, 0, len(callers))
+	for _, cs := range callers {
+		s.TopCallers = append(s.TopCallers, cs)
+	}
+	sort.Slice(s.TopCallers, func(i, j int) bool {
+		a, b := s.TopCallers[i], s.TopCallers[j]
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		return a.Address < b.Address
+	})
+	if opt.top > 0 && len(s.TopCallers) > opt.top {
+		s.TopCallers = s.TopCallers[:opt.top]
+	}
+	for i, cs := range s.TopCallers {
+		cs.Rank = i + 1
+	}
+	return &s, nil
+}
+
+func failureRate(failed, calls int) float64 {
+	if calls == 0 {
+		return 0
+	}
+	return float64(failed) / float64(calls)
+}
+
+func writeContractCallStats(w io.Writer, s *contractCallStats) error {
+	_, err := fmt.Fprintf(w, "Contract:  %s\nLevels:    %d..%d\nCalls:     %d (%d failed, %.1f%%)\n\n",
+		s.Contract, s.FirstLevel, s.LastLevel, s.Calls, s.Failed, s.FailureRate*100)
+	if err != nil || s.Calls == 0 {
+		return err
+	}
+
+	width := utils.TerminalWidth(os.Stdout)
+	table := utils.NewTable(w, entrypointStatsColumns, width)
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, e := range s.Entrypoints {
+		err := table.WriteRow(
+			e.Entrypoint,
+			strconv.Itoa(e.Calls),
+			strconv.Itoa(e.Internal),
+			strconv.Itoa(e.Failed),
+			fmt.Sprintf("%.1f%%", e.FailureRate*100),
+			fmt.Sprintf("%.0f", e.AverageGas),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	table = utils.NewTable(w, callerStatsColumns, width)
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, cs := range s.TopCallers {
+		if err := table.WriteRow(strconv.Itoa(cs.Rank), cs.Address, strconv.Itoa(cs.Calls), strconv.Itoa(cs.Failed)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Capacity rules
+const (
+	// Adaptive issuance: delegated tez are limited by a multiple of the baker's own staked tez
+	capacityRuleStaking = "staking"
+	// Jakarta to Oxford: the stake is limited by a multiple of the baker's full balance or its deposits limit
+	capacityRuleDeposits = "frozen_deposits"
+)
+
+type capacityOptions struct {
+	watch  bool
+	below  string
+	format string
+}
+
+// delegateCapacity is the delegation capacity of the delegate. Remaining amounts are negative if the delegate is over-delegated
+type delegateCapacity struct {
+	Delegate            string   `json:"delegate" yaml:"delegate"`
+	Block               string   `json:"block" yaml:"block"`
+	Rule                string   `json:"rule" yaml:"rule"`
+	StakingBalanceMutez *big.Int `json:"staking_balance_mutez" yaml:"staking_balance_mutez"`
+	// Own staked tez or the full balance capped by the deposits limit
+	BaseMutez             *big.Int `json:"base_mutez" yaml:"base_mutez"`
+	CapacityMutez         *big.Int `json:"capacity_mutez" yaml:"capacity_mutez"`
+	UsedMutez             *big.Int `json:"used_mutez" yaml:"used_mutez"`
+	RemainingMutez        *big.Int `json:"remaining_mutez" yaml:"remaining_mutez"`
+	OverDelegated         bool     `json:"over_delegated" yaml:"over_delegated"`
+	ExternalStakedMutez   *big.Int `json:"external_staked_mutez,omitempty" yaml:"external_staked_mutez,omitempty"`
+	StakingCapacityMutez  *big.Int `json:"staking_capacity_mutez,omitempty" yaml:"staking_capacity_mutez,omitempty"`
+	RemainingStakingMutez *big.Int `json:"remaining_staking_mutez,omitempty" yaml:"remaining_staking_mutez,omitempty"`
+}
+
+// capacityEvent is emitted when the remaining capacity crosses the threshold. Amounts are in mutez
+type capacityEvent struct {
+	Delegate  string    `json:"delegate"`
+	Event     string    `json:"event"`
+	Remaining string    `json:"remaining"`
+	Threshold string    `json:"threshold"`
+	Block     string    `json:"block"`
+	Level     int       `json:"level"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Only the fields of the current protocol's rules are present
+type capacityConstants struct {
+	FrozenDepositsPercentage       *int64 `json:"frozen_deposits_percentage"`
+	LimitOfDelegationOverBaking    *int64 `json:"limit_of_delegation_over_baking"`
+	GlobalLimitOfStakingOverBaking *int64 `json:"global_limit_of_staking_over_baking"`
+}
+
+func newDelegateCapacityCommand(ctx *RootContext) *cobra.Command {
+	var opt capacityOptions
+
+	capacityCmd := &cobra.Command{
+		Use:   "capacity <delegate>",
+		Short: "Remaining delegation capacity of a delegate",
+		Long: `Compute the remaining delegation capacity under the current protocol's rules. With adaptive issuance delegated tez
+are limited by a multiple of the baker's own staked tez and external stake by its staking parameters, earlier protocols
+limit the staking balance by a multiple of the baker's full balance or its deposits limit. The excess doesn't give rights.
+With --watch the capacity is checked at every new head and an event is emitted when it drops below --below or gets back.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := ctx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			if opt.watch {
+				return ctx.watchCapacity(address, &opt)
+			}
+
+			d, err := ctx.delegateCapacity(ctx.blockID, address)
+			if err != nil {
+				return err
+			}
+			if d.OverDelegated {
+				log.WithFields(log.Fields{
+					"delegate": address,
+					"excess":   new(big.Int).Neg(d.RemainingMutez),
+				}).Warn("Delegate is over-delegated")
+			}
+
+			if opt.format != "text" {
+				newEnc := ctx.encoderFunc(opt.format)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+				}
+				return newEnc(os.Stdout).Encode(d)
+			}
+			ctx.printDelegateCapacity(d)
+			return nil
+		},
+	}
+
+	f := capacityCmd.Flags()
+	f.BoolVar(&opt.watch, "watch", false, "Watch for new blocks and report when the remaining capacity drops below the threshold")
+	f.StringVar(&opt.below, "below", "0", "Remaining capacity threshold in tez used with --watch")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json], only text and json with --watch")
+
+	return capacityCmd
+}
+
+// firstOptionalRPC fetches the first of the entries existing in the protocol
+func (c *RootContext) firstOptionalRPC(v interface{}, paths ...string) (bool, error) {
+	for _, p := range paths {
+		if ok, err := c.getOptionalRPC(p, v); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (c *RootContext) delegateCapacity(blockID, address string) (*delegateCapacity, error) {
+	prefix := c.blockPath(blockID) + "/context/delegates/" + address
+
+	var balance tezos.BigInt
+	ok, err := c.getOptionalRPC(prefix+"/staking_balance", &balance)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is not a registered delegate", address)
+	}
+
+	var constants capacityConstants
+	if err := c.getRPC(c.blockPath(blockID)+"/context/constants", &constants); err != nil {
+		return nil, err
+	}
+
+	d := delegateCapacity{
+		Delegate:            address,
+		Block:               blockID,
+		StakingBalanceMutez: &balance.Int,
+	}
+
+	switch {
+	case constants.LimitOfDelegationOverBaking != nil:
+		d.Rule = capacityRuleStaking
+
+		var own, total tezos.BigInt
+		if ok, err = c.firstOptionalRPC(&own, prefix+"/own_staked", c.blockPath(blockID)+"/context/contracts/"+address+"/staked_balance"); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("Can't get the staked balance of %s", address)
+		}
+		if ok, err = c.firstOptionalRPC(&total, prefix+"/total_staked", prefix+"/current_frozen_deposits"); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("Can't get the total staked balance of %s", address)
+		}
+
+		d.BaseMutez = &own.Int
+		d.CapacityMutez = new(big.Int).Mul(&own.Int, big.NewInt(*constants.LimitOfDelegationOverBaking))
+		d.UsedMutez = new(big.Int).Sub(&balance.Int, &total.Int)
+		d.ExternalStakedMutez = new(big.Int).Sub(&total.Int, &own.Int)
+
+		// The baker's limit is capped by the global one
+		limit := int64(0)
+		var params stakingParameters
+		if ok, err = c.getOptionalRPC(prefix+"/active_staking_parameters", &params); err != nil {
+			return nil, err
+		}
+		if ok {
+			limit = params.LimitOfStakingOverBakingMillionth
+		}
+		if g := constants.GlobalLimitOfStakingOverBaking; g != nil && limit > *g*stakingLimitScale {
+			limit = *g * stakingLimitScale
+		}
+		d.StakingCapacityMutez = new(big.Int).Quo(new(big.Int).Mul(&own.Int, big.NewInt(limit)), big.NewInt(stakingLimitScale))
+		d.RemainingStakingMutez = new(big.Int).Sub(d.StakingCapacityMutez, d.ExternalStakedMutez)
+
+	case constants.FrozenDepositsPercentage != nil && *constants.FrozenDepositsPercentage > 0:
+		d.Rule = capacityRuleDeposits
+
+		var full tezos.BigInt
+		if ok, err = c.firstOptionalRPC(&full, prefix+"/full_balance", prefix+"/balance"); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("Can't get the full balance of %s", address)
+		}
+		d.BaseMutez = &full.Int
+
+		var limit *tezos.BigInt
+		if _, err = c.getOptionalRPC(prefix+"/frozen_deposits_limit", &limit); err != nil {
+			return nil, err
+		}
+		if limit != nil && limit.Cmp(d.BaseMutez) < 0 {
+			d.BaseMutez = &limit.Int
+		}
+		d.CapacityMutez = new(big.Int).Quo(new(big.Int).Mul(d.BaseMutez, big.NewInt(100)), big.NewInt(*constants.FrozenDepositsPercentage))
+		d.UsedMutez = &balance.Int
+
+	default:
+		return nil, errors.New("Delegation capacity is not defined by the protocol")
+	}
+
+	d.RemainingMutez = new(big.Int).Sub(d.CapacityMutez, d.UsedMutez)
+	d.OverDelegated = d.RemainingMutez.Sign() < 0
+	return &d, nil
+}
+
+func (c *RootContext) printDelegateCapacity(d *delegateCapacity) {
+	fmt.Printf("Delegate:           %s\n", d.Delegate)
+	fmt.Printf("Staking balance:    %s\n", c.amountFormat.Format(d.StakingBalanceMutez))
+	if d.Rule == capacityRuleStaking {
+		fmt.Printf("Own staked:         %s\n", c.amountFormat.Format(d.BaseMutez))
+		fmt.Printf("Delegated:          %s\n", c.amountFormat.Format(d.UsedMutez))
+		fmt.Printf("Delegation limit:   %s\n", c.amountFormat.Format(d.CapacityMutez))
+	} else {
+		fmt.Printf("Deposits base:      %s\n", c.amountFormat.Format(d.BaseMutez))
+		fmt.Printf("Capacity:           %s\n", c.amountFormat.Format(d.CapacityMutez))
+	}
+	if d.OverDelegated {
+		fmt.Printf("Over-delegated by:  %s\n", c.colorizer.Red(c.amountFormat.Format(new(big.Int).Neg(d.RemainingMutez))))
+	} else {
+		fmt.Printf("Remaining:          %s\n", c.colorizer.Green(c.amountFormat.Format(d.RemainingMutez)))
+	}
+	if d.StakingCapacityMutez != nil {
+		fmt.Printf("External staked:    %s\n", c.amountFormat.Format(d.ExternalStakedMutez))
+		fmt.Printf("Staking limit:      %s\n", c.amountFormat.Format(d.StakingCapacityMutez))
+		fmt.Printf("Remaining staking:  %s\n", c.amountFormat.Format(d.RemainingStakingMutez))
+	}
+}
+
+func (c *RootContext) watchCapacity(address string, opt *capacityOptions) error {
+	threshold, err := utils.ParseTez(opt.below)
+	if err != nil {
+		return err
+	}
+	if opt.format != "text" && opt.format != "json" {
+		return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+	}
+
+	log.WithFields(log.Fields{
+		"delegate": address,
+		"below":    opt.below,
+	}).Info("Watching delegation capacity")
+
+	var monErr error
+	ch := make(chan *tezos.BlockInfo, 10)
+	go func() {
+		monErr = c.monitorHeads(ch)
+		close(ch)
+	}()
+
+	// Zone of the previous check, empty before the first block
+	var last string
+	for bi := range ch {
+		d, err := c.delegateCapacity(bi.Hash, address)
+		if err != nil {
+			if err == context.Canceled {
+				break
+			}
+			return err
+		}
+
+		z := balanceInRange
+		if d.RemainingMutez.Cmp(threshold) < 0 {
+			z = balanceBelow
+		}
+		log.WithFields(log.Fields{
+			"block_level": bi.Level,
+			"remaining":   d.RemainingMutez,
+			"zone":        z,
+		}).Debug("Capacity checked")
+
+		if z == last || last == "" && z == balanceInRange {
+			last = z
+			continue
+		}
+		last = z
+
+		ev := capacityEvent{
+			Delegate:  address,
+			Event:     z,
+			Remaining: d.RemainingMutez.String(),
+			Threshold: threshold.String(),
+			Block:     bi.Hash,
+			Level:     bi.Level,
+			Timestamp: bi.Timestamp,
+		}
+		if err := c.writeCapacityEvent(os.Stdout, &ev, d.RemainingMutez, threshold, opt.format); err != nil {
+			return err
+		}
+	}
+
+	if monErr != nil && monErr != context.Canceled {
+		return monErr
+	}
+	return nil
+}
+
+func (c *RootContext) writeCapacityEvent(w io.Writer, ev *capacityEvent, remaining, threshold *big.Int, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(ev)
+	}
+
+	var msg string
+	if ev.Event == balanceBelow {
+		msg = c.colorizer.Red(fmt.Sprintf("below %s", c.amountFormat.Format(threshold))).String()
+	} else {
+		msg = c.colorizer.Green("back in range").String()
+	}
+	_, err := fmt.Fprintf(w, "%s %d %s remaining %s %s\n", c.localTime(ev.Timestamp).Format("2006-01-02 15:04:05"), ev.Level, ev.Delegate, c.amountFormat.Format(remaining), msg)
+	return err
+}
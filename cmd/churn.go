@@ -0,0 +1,293 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the delegator churn table
+var churnColumns = []utils.TableColumn{
+	{Header: "CYCLE", Width: 6, Align: utils.AlignRight},
+	{Header: "DELEGATORS", Width: 10, Align: utils.AlignRight},
+	{Header: "GAINED", Width: 6, Align: utils.AlignRight},
+	{Header: "GAINED BALANCE", Width: 20, Align: utils.AlignRight},
+	{Header: "LOST", Width: 6, Align: utils.AlignRight},
+	{Header: "LOST BALANCE", Width: 20, Align: utils.AlignRight},
+}
+
+type churnOptions struct {
+	cycles int
+	format string
+	crawl  crawlOptions
+}
+
+type churnDelegator struct {
+	Address      string     `json:"address" yaml:"address"`
+	BalanceMutez *big.Int   `json:"balance_mutez" yaml:"balance_mutez"`
+	Balance      *big.Float `json:"balance" yaml:"balance"`
+}
+
+// cycleChurn compares delegators at the end of the cycle with the ones at the end of the previous cycle
+type cycleChurn struct {
+	Cycle              int               `json:"cycle" yaml:"cycle"`
+	Level              int               `json:"level" yaml:"level"`
+	Delegators         int               `json:"delegators" yaml:"delegators"`
+	Gained             []*churnDelegator `json:"gained" yaml:"gained"`
+	Lost               []*churnDelegator `json:"lost" yaml:"lost"`
+	GainedBalanceMutez *big.Int          `json:"gained_balance_mutez" yaml:"gained_balance_mutez"`
+	LostBalanceMutez   *big.Int          `json:"lost_balance_mutez" yaml:"lost_balance_mutez"`
+}
+
+type delegatorChurn struct {
+	Delegate string        `json:"delegate" yaml:"delegate"`
+	Cycles   []*cycleChurn `json:"cycles" yaml:"cycles"`
+}
+
+func newDelegateChurnCommand(ctx *RootContext) *cobra.Command {
+	var opt churnOptions
+
+	churnCmd := &cobra.Command{
+		Use:   "churn <delegate>",
+		Short: "Delegators gained and lost per cycle",
+		Long: `Compare the delegator lists of consecutive cycle snapshots, taken at the last block of each completed cycle.
+Gained delegators are listed with their balance at the end of the cycle, lost ones with their balance at the end of
+the previous cycle. Historical context requires an archive node.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			delegate, err := ctx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			return ctx.showDelegatorChurn(delegate, &opt)
+		},
+	}
+
+	f := churnCmd.Flags()
+	f.IntVar(&opt.cycles, "cycles", 5, "Number of completed cycles")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	addCrawlFlags(churnCmd, &opt.crawl, 8, "balances")
+
+	return churnCmd
+}
+
+// delegators returns the contracts delegated to the delegate at the block excluding its own one
+func (c *RootContext) delegators(blockID, delegate string) (map[string]struct{}, error) {
+	var list []string
+	err := c.getRPC(c.blockPath(blockID)+"/context/delegates/"+delegate+"/delegated_contracts", &list)
+	if isNotFound(err) {
+		// Not registered yet
+		return map[string]struct{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]struct{}, len(list))
+	for _, a := range list {
+		if a != delegate {
+			res[a] = struct{}{}
+		}
+	}
+	return res, nil
+}
+
+func setDifference(a, b map[string]struct{}) []*churnDelegator {
+	var res []*churnDelegator
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			res = append(res, &churnDelegator{Address: k})
+		}
+	}
+	return res
+}
+
+func (c *RootContext) delegatorChurn(delegate string, opt *churnOptions) (*delegatorChurn, error) {
+	if opt.cycles <= 0 {
+		return nil, fmt.Errorf("Invalid number of cycles: %d", opt.cycles)
+	}
+
+	var cur currentLevel
+	if err := c.getRPC(c.blockPath(c.blockID)+"/helpers/current_level", &cur); err != nil {
+		return nil, err
+	}
+	// One more snapshot to compare the first cycle with
+	first := cur.Cycle - opt.cycles - 1
+	if first < 0 {
+		first = 0
+	}
+
+	var (
+		res       = delegatorChurn{Delegate: delegate}
+		prev      map[string]struct{}
+		prevLevel int
+		queries   []string
+		accounts  = make(map[string]*churnDelegator)
+	)
+	for cycle := first; cycle < cur.Cycle; cycle++ {
+		var lv cycleLevels
+		if err := c.getRPC(fmt.Sprintf("%s/helpers/levels_in_current_cycle?offset=%d", c.blockPath(c.blockID), cycle-cur.Cycle), &lv); err != nil {
+			return nil, err
+		}
+		list, err := c.delegators(strconv.Itoa(lv.Last), delegate)
+		if err != nil {
+			return nil, err
+		}
+
+		if prev != nil {
+			ch := cycleChurn{
+				Cycle:              cycle,
+				Level:              lv.Last,
+				Delegators:         len(list),
+				Gained:             setDifference(list, prev),
+				Lost:               setDifference(prev, list),
+				GainedBalanceMutez: new(big.Int),
+				LostBalanceMutez:   new(big.Int),
+			}
+			for _, d := range ch.Gained {
+				q := fmt.Sprintf("%d/%s", lv.Last, d.Address)
+				queries, accounts[q] = append(queries, q), d
+			}
+			for _, d := range ch.Lost {
+				q := fmt.Sprintf("%d/%s", prevLevel, d.Address)
+				queries, accounts[q] = append(queries, q), d
+			}
+			res.Cycles = append(res.Cycles, &ch)
+		}
+		prev, prevLevel = list, lv.Last
+
+		log.WithFields(log.Fields{
+			"cycle":      cycle,
+			"delegators": len(list),
+		}).Debug("Delegators collected")
+	}
+
+	// Balances are fetched concurrently, query is block/address
+	fetch := func(ctx context.Context, query string) (interface{}, error) {
+		i := strings.IndexByte(query, '/')
+		balance, err := c.contractBalance(query[:i], query[i+1:])
+		if err != nil {
+			return nil, crawlError(err)
+		}
+		return balance, nil
+	}
+	err := c.newCrawler(&opt.crawl, "balances").Run(c.context, queries, fetch, func(i int, query string, v interface{}) error {
+		d := accounts[query]
+		d.BalanceMutez = v.(*big.Int)
+		d.Balance = c.amountFormat.Tez(d.BalanceMutez)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Largest first
+	byBalance := func(list []*churnDelegator) {
+		sort.Slice(list, func(i, j int) bool {
+			if r := list[i].BalanceMutez.Cmp(list[j].BalanceMutez); r != 0 {
+				return r > 0
+			}
+			return list[i].Address < list[j].Address
+		})
+	}
+	for _, ch := range res.Cycles {
+		for _, d := range ch.Gained {
+			ch.GainedBalanceMutez.Add(ch.GainedBalanceMutez, d.BalanceMutez)
+		}
+		for _, d := range ch.Lost {
+			ch.LostBalanceMutez.Add(ch.LostBalanceMutez, d.BalanceMutez)
+		}
+		byBalance(ch.Gained)
+		byBalance(ch.Lost)
+	}
+	return &res, nil
+}
+
+func (c *RootContext) showDelegatorChurn(delegate string, opt *churnOptions) error {
+	var newEncoder utils.NewEncoderFunc
+	if opt.format != "text" {
+		if newEncoder = c.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+
+	res, err := c.delegatorChurn(delegate, opt)
+	if err != nil {
+		return err
+	}
+	if newEncoder != nil {
+		return newEncoder(os.Stdout).Encode(res)
+	}
+	return c.writeDelegatorChurn(os.Stdout, res)
+}
+
+func (c *RootContext) writeDelegatorChurn(w io.Writer, res *delegatorChurn) error {
+	if _, err := fmt.Fprintf(w, "Delegate: %s\n\n", res.Delegate); err != nil {
+		return err
+	}
+	table := utils.NewTable(w, churnColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, ch := range res.Cycles {
+		err := table.WriteRow(
+			strconv.Itoa(ch.Cycle),
+			strconv.Itoa(ch.Delegators),
+			strconv.Itoa(len(ch.Gained)),
+			c.amountFormat.Format(ch.GainedBalanceMutez),
+			strconv.Itoa(len(ch.Lost)),
+			c.amountFormat.Format(ch.LostBalanceMutez),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ch := range res.Cycles {
+		if len(ch.Gained)+len(ch.Lost) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\nCycle %d:\n", ch.Cycle); err != nil {
+			return err
+		}
+		for _, d := range ch.Gained {
+			if _, err := fmt.Fprintf(w, "  + %-36s %s\n", d.Address, c.amountFormat.Format(d.BalanceMutez)); err != nil {
+				return err
+			}
+		}
+		for _, d := range ch.Lost {
+			if _, err := fmt.Fprintf(w, "  - %-36s %s\n", d.Address, c.amountFormat.Format(d.BalanceMutez)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
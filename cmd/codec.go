@@ -0,0 +1,185 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/blake2b"
+)
+
+type codecInfo struct {
+	Type    string `json:"type" yaml:"type"`
+	Prefix  string `json:"prefix" yaml:"prefix"`
+	Payload string `json:"payload" yaml:"payload"`
+}
+
+// Public key types mapped to the corresponding hash types
+var publicKeyHashPrefixes = map[*base58.Prefix]*base58.Prefix{
+	base58.PrefixEd25519PublicKey:   base58.PrefixEd25519PublicKeyHash,
+	base58.PrefixSecp256k1PublicKey: base58.PrefixSecp256k1PublicKeyHash,
+	base58.PrefixP256PublicKey:      base58.PrefixP256PublicKeyHash,
+	base58.PrefixBLS12381PublicKey:  base58.PrefixBLS12381PublicKeyHash,
+}
+
+// NewCodecCommand returns new `codec' command
+func NewCodecCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		outputFormat string
+		prefixTag    string
+	)
+
+	codecCmd := &cobra.Command{
+		Use:   "codec",
+		Short: "Base58Check encoding tools",
+	}
+
+	decodeCmd := &cobra.Command{
+		Use:   "decode <base58>",
+		Short: "Decode Base58Check encoded value and identify its type",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, payload, err := base58.DecodeAny(args[0])
+			if err != nil {
+				return err
+			}
+
+			info := codecInfo{
+				Type:    p.Name,
+				Prefix:  p.Tag,
+				Payload: hex.EncodeToString(payload),
+			}
+
+			if outputFormat == "text" {
+				fmt.Printf("Type:    %s\nPrefix:  %s\nPayload: %s\n", info.Type, info.Prefix, info.Payload)
+				return nil
+			}
+
+			newEnc := rootCtx.encoderFunc(outputFormat)
+			if newEnc == nil {
+				return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+			}
+			return newEnc(os.Stdout).Encode(&info)
+		},
+	}
+
+	encodeCmd := &cobra.Command{
+		Use:   "encode <hex>",
+		Short: "Encode raw payload using Base58Check with the given prefix",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if prefixTag == "" {
+				return errors.New("Prefix is required")
+			}
+
+			payload, err := hex.DecodeString(strings.TrimPrefix(args[0], "0x"))
+			if err != nil {
+				return err
+			}
+
+			p := base58.LookupTag(prefixTag, len(payload))
+			if p == nil {
+				return fmt.Errorf("Unknown prefix or wrong payload length: `%s'", prefixTag)
+			}
+
+			s, err := p.Encode(payload)
+			if err != nil {
+				return err
+			}
+			fmt.Println(s)
+			return nil
+		},
+	}
+
+	validateCmd := &cobra.Command{
+		Use:   "validate <base58>...",
+		Short: "Validate Base58Check checksums and prefixes",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var failed int
+			for _, s := range args {
+				p, _, err := base58.DecodeAny(s)
+				if err != nil {
+					fmt.Printf("%s: %v\n", s, err)
+					failed++
+					continue
+				}
+				fmt.Printf("%s: valid %s\n", s, p.Name)
+			}
+
+			if failed != 0 {
+				return fmt.Errorf("%d of %d values are invalid", failed, len(args))
+			}
+			return nil
+		},
+	}
+
+	addressCmd := &cobra.Command{
+		Use:   "address <public key>",
+		Short: "Derive implicit account address from a public key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr, err := publicKeyAddress(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Println(addr)
+			return nil
+		},
+	}
+
+	decodeCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json, msgpack, cbor]")
+	encodeCmd.Flags().StringVarP(&prefixTag, "prefix", "p", "", "Value type prefix, e.g. tz1, KT1, edpk, B, o")
+
+	codecCmd.AddCommand(decodeCmd)
+	codecCmd.AddCommand(encodeCmd)
+	codecCmd.AddCommand(validateCmd)
+	codecCmd.AddCommand(addressCmd)
+
+	return codecCmd
+}
+
+// publicKeyAddress returns the public key hash (tz1, tz2, tz3 or tz4 address)
+func publicKeyAddress(pk string) (string, error) {
+	p, payload, err := base58.DecodeAny(pk)
+	if err != nil {
+		return "", err
+	}
+
+	hp, ok := publicKeyHashPrefixes[p]
+	if !ok {
+		return "", fmt.Errorf("Not a public key: `%s'", pk)
+	}
+
+	h, err := blake2b.New(20, nil)
+	if err != nil {
+		return "", err
+	}
+	h.Write(payload)
+
+	return hp.Encode(h.Sum(nil))
+}
@@ -0,0 +1,500 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/internal/atomicfile"
+	"github.com/ecadlabs/tez/keyring"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Replacement for secret values in `config view'
+const secretMask = "********"
+
+// Profile is a named set of connection settings
+type Profile struct {
+	URL      string `yaml:"url,omitempty"`
+	Chain    string `yaml:"chain,omitempty"`
+	Network  string `yaml:"network,omitempty"`
+	ChainID  string `yaml:"chain_id,omitempty"`
+	Keystore string `yaml:"keystore,omitempty"`
+	// tezos-client base directory, see --client-dir
+	ClientDir string `yaml:"client_dir,omitempty"`
+	// Contract address book, see --contracts-file
+	ContractsFile string `yaml:"contracts_file,omitempty"`
+	// Injection journal, see `tez injections'
+	InjectionJournal string `yaml:"injection_journal,omitempty"`
+	// Baker keys' high watermarks, see `tez key set-baker'
+	HighWatermarks string `yaml:"high_watermarks,omitempty"`
+	// Reorg log, see `tez reorgs'
+	RecordReorgs bool   `yaml:"record_reorgs,omitempty"`
+	ReorgLog     string `yaml:"reorg_log,omitempty"`
+	// RPC credentials, see rpc.Auth
+	RPCUser        string `yaml:"rpc_user,omitempty"`
+	RPCPassword    string `yaml:"rpc_password,omitempty"`
+	RPCBearerToken string `yaml:"rpc_bearer_token,omitempty"`
+	// RPC requests per second and burst, see rpc.RateLimiter
+	RPCRate  float64 `yaml:"rpc_rate,omitempty"`
+	RPCBurst int     `yaml:"rpc_burst,omitempty"`
+	// Amount formatting, see --precision and --rounding. Zero precision is a valid setting
+	Precision *int   `yaml:"precision,omitempty"`
+	Rounding  string `yaml:"rounding,omitempty"`
+	// Time zone of displayed timestamps, see --timezone
+	Timezone string `yaml:"timezone,omitempty"`
+	// Output compatibility version, see --api-version
+	APIVersion int `yaml:"api_version,omitempty"`
+}
+
+// Config is the configuration file contents
+type Config struct {
+	DefaultProfile string              `yaml:"default_profile,omitempty"`
+	Profiles       map[string]*Profile `yaml:"profiles,omitempty"`
+}
+
+// parseConfig decodes the configuration rejecting unknown keys to catch typos
+func parseConfig(data []byte) (*Config, error) {
+	var conf Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&conf); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// loadConfig reads the configuration file. Missing file is treated as an empty configuration
+func loadConfig(path string) (*Config, error) {
+	path, err := utils.ExpandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+	conf, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return conf, nil
+}
+
+// profile returns the named profile or the default one if the name is empty
+func (c *Config) profile(name string) (*Profile, error) {
+	if name == "" {
+		if name = c.DefaultProfile; name == "" {
+			return nil, nil
+		}
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown profile: `%s'", name)
+	}
+	return p, nil
+}
+
+// appendLine appends the line to the file and syncs it. Single line appends are atomic so concurrent clients don't interleave
+func appendLine(path string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(append(line, '\n')); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// configFile is a raw view of the configuration used to edit arbitrary keys
+type configFile struct {
+	path   string
+	values map[string]interface{}
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	path, err := utils.ExpandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	f := configFile{path: path, values: make(map[string]interface{})}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &f, nil
+		}
+		return nil, err
+	}
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if v != nil {
+		var ok bool
+		if f.values, ok = stringKeys(v).(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s: not a mapping", path)
+		}
+	}
+	return &f, nil
+}
+
+// stringKeys converts generic YAML mappings to map[string]interface{}
+func stringKeys(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[fmt.Sprint(k)] = stringKeys(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = stringKeys(e)
+		}
+		return x
+	case []interface{}:
+		for i, e := range x {
+			x[i] = stringKeys(e)
+		}
+		return x
+	default:
+		return v
+	}
+}
+
+// set assigns the value to the dot separated key creating intermediate maps
+func (f *configFile) set(key string, value interface{}) error {
+	path := strings.Split(key, ".")
+	m := f.values
+	for i, p := range path[:len(path)-1] {
+		next, ok := m[p]
+		if !ok {
+			nm := make(map[string]interface{})
+			m[p] = nm
+			m = nm
+			continue
+		}
+		if m, ok = next.(map[string]interface{}); !ok {
+			return fmt.Errorf("`%s' is not a section", strings.Join(path[:i+1], "."))
+		}
+	}
+	m[path[len(path)-1]] = value
+	return nil
+}
+
+// unset removes the key and returns false if it doesn't exist
+func (f *configFile) unset(key string) bool {
+	path := strings.Split(key, ".")
+	m := f.values
+	for _, p := range path[:len(path)-1] {
+		var ok bool
+		if m, ok = m[p].(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	if _, ok := m[path[len(path)-1]]; !ok {
+		return false
+	}
+	delete(m, path[len(path)-1])
+	return true
+}
+
+// save validates the values against the configuration schema and writes the file
+func (f *configFile) save() error {
+	data, err := yaml.Marshal(f.values)
+	if err != nil {
+		return err
+	}
+	if _, err := parseConfig(data); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(f.path, data, 0600)
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"token", "password", "secret"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSecrets replaces values of secret looking keys in place
+func maskSecrets(v interface{}) {
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, x := range m {
+			if isSecretKey(k) {
+				// References to the keyring aren't secret
+				if s, ok := x.(string); !ok || !strings.HasPrefix(s, keyring.RefPrefix) {
+					m[k] = secretMask
+				}
+			} else {
+				maskSecrets(x)
+			}
+		}
+	}
+}
+
+// NewConfigCommand returns new `config' command
+func NewConfigCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		showSecrets bool
+		force       bool
+	)
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the configuration file",
+		// Doesn't need the RPC connection and must work with a broken configuration
+		Annotations: map[string]string{offlineAnnotation: ""},
+	}
+
+	viewCmd := &cobra.Command{
+		Use:   "view",
+		Short: "Print the configuration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := readConfigFile(rootCtx.configPath)
+			if err != nil {
+				return err
+			}
+			if !showSecrets {
+				maskSecrets(f.values)
+			}
+			return yaml.NewEncoder(os.Stdout).Encode(f.values)
+		},
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set the value, e.g. `set profiles.main.url https://...'",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := readConfigFile(rootCtx.configPath)
+			if err != nil {
+				return err
+			}
+			var value interface{}
+			if err := yaml.Unmarshal([]byte(args[1]), &value); err != nil {
+				return err
+			}
+			if err := f.set(args[0], value); err != nil {
+				return err
+			}
+			return f.save()
+		},
+	}
+
+	unsetCmd := &cobra.Command{
+		Use:   "unset <key>",
+		Short: "Remove the value",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := readConfigFile(rootCtx.configPath)
+			if err != nil {
+				return err
+			}
+			if !f.unset(args[0]) {
+				return fmt.Errorf("Unknown key: `%s'", args[0])
+			}
+			return f.save()
+		},
+	}
+
+	editCmd := &cobra.Command{
+		Use:   "edit",
+		Short: "Open the configuration in $EDITOR",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return editConfig(rootCtx.configPath)
+		},
+	}
+
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create the configuration interactively",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return initConfig(rootCtx.configPath, force)
+		},
+	}
+
+	viewCmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Don't mask secret values")
+	initCmd.Flags().BoolVar(&force, "force", false, "Overwrite existing configuration")
+
+	configCmd.AddCommand(viewCmd)
+	configCmd.AddCommand(setCmd)
+	configCmd.AddCommand(unsetCmd)
+	configCmd.AddCommand(editCmd)
+	configCmd.AddCommand(initCmd)
+
+	return configCmd
+}
+
+// editConfig edits a temporary copy of the file and replaces the original one only if the result is valid
+func editConfig(path string) error {
+	path, err := utils.ExpandHome(path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile("", "tez-config-*.yaml")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err := ioutil.WriteFile(tmp.Name(), data, 0600); err != nil {
+		return err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	argv := append(strings.Fields(editor), tmp.Name())
+	c := exec.Command(argv[0], argv[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return err
+	}
+
+	edited, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if _, err := parseConfig(edited); err != nil {
+		return fmt.Errorf("%v (your changes are kept in %s)", err, tmp.Name())
+	}
+	os.Remove(tmp.Name())
+	return atomicfile.WriteFile(path, edited, 0600)
+}
+
+type prompter struct {
+	r *bufio.Reader
+}
+
+// ask prints the question and returns the answer or the default value if it's empty
+func (p *prompter) ask(question, def string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	line, err := p.r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func initConfig(path string, force bool) error {
+	path, err := utils.ExpandHome(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	}
+
+	p := prompter{r: bufio.NewReader(os.Stdin)}
+
+	name, err := p.ask("Profile name", "default")
+	if err != nil {
+		return err
+	}
+	network, err := p.ask("Network ("+strings.Join(networkNames(), ", ")+")", "mainnet")
+	if err != nil {
+		return err
+	}
+	net, ok := networks[network]
+	if !ok {
+		return fmt.Errorf("Unknown network: `%s'", network)
+	}
+
+	profile := Profile{Network: network}
+	if net.URL == "" {
+		if profile.URL, err = p.ask("RPC end-point URL", "http://localhost:8732"); err != nil {
+			return err
+		}
+		if profile.ChainID, err = p.ask("Expected chain ID (empty to skip the check)", ""); err != nil {
+			return err
+		}
+	} else {
+		url, err := p.ask("RPC end-point URL", net.URL)
+		if err != nil {
+			return err
+		}
+		if url != net.URL {
+			profile.URL = url
+		}
+	}
+	if profile.Keystore, err = p.ask("Keystore", "~/.tez/keys.json"); err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("Profile name is required")
+	}
+
+	conf := Config{
+		DefaultProfile: name,
+		Profiles:       map[string]*Profile{name: &profile},
+	}
+	data, err := yaml.Marshal(&conf)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Configuration written to %s\n", path)
+	return nil
+}
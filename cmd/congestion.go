@@ -0,0 +1,335 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Congestion events
+const (
+	congestionCongested = "congested"
+	congestionCleared   = "cleared"
+)
+
+// Mempool classes of operations waiting for inclusion. Older nodes report validated operations as applied
+var mempoolPendingClasses = []string{"validated", "applied", "branch_delayed", "unprocessed"}
+
+type congestionOptions struct {
+	watch        bool
+	window       int
+	gasThreshold float64
+	mempoolDepth int
+	format       string
+	sinkURLs     []string
+}
+
+// congestionStatus is the chain load at the block. Gas utilization is the average over the window
+type congestionStatus struct {
+	Block          string    `json:"block"`
+	Level          int       `json:"level"`
+	Timestamp      time.Time `json:"timestamp"`
+	BlockGas       float64   `json:"block_gas_utilization"`
+	GasUtilization float64   `json:"gas_utilization"`
+	MempoolDepth   int       `json:"mempool_depth"`
+	Congested      bool      `json:"congested"`
+}
+
+// congestionEvent is emitted when the chain gets congested or back to normal
+type congestionEvent struct {
+	Event string `json:"event"`
+	*congestionStatus
+	GasThreshold     float64 `json:"gas_threshold"`
+	MempoolThreshold int     `json:"mempool_threshold"`
+}
+
+func newCongestionCommand(ctx *RootContext) *cobra.Command {
+	var opt congestionOptions
+
+	congestionCmd := &cobra.Command{
+		Use:   "congestion",
+		Short: "Block gas utilization and mempool depth",
+		Long: `Show the gas used by recent blocks relative to the block gas limit and the number of operations waiting in the mempool.
+The chain is considered congested if the average utilization over --window blocks reaches --gas-threshold or the mempool
+depth reaches --mempool-threshold. With --watch both are checked at every new head and an event is emitted, along with
+a warning, when the chain gets congested or back to normal, e.g. to pause automated withdrawals.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.showCongestion(&opt)
+		},
+	}
+
+	f := congestionCmd.Flags()
+	f.BoolVar(&opt.watch, "watch", false, "Watch for new blocks and report changes of the congestion state")
+	f.IntVar(&opt.window, "window", 5, "Number of blocks the gas utilization is averaged over")
+	f.Float64Var(&opt.gasThreshold, "gas-threshold", 0.8, "Average block gas utilization considered congested, from 0 to 1")
+	f.IntVar(&opt.mempoolDepth, "mempool-threshold", 1000, "Number of pending mempool operations considered congested (0 disables the check)")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, json]")
+	f.StringSliceVar(&opt.sinkURLs, "sink", nil, "Also publish events to the message broker or webhook, e.g. https://host/path (may be repeated)")
+
+	return congestionCmd
+}
+
+// blockGas returns the gas consumed by manager operations of the block
+func (c *RootContext) blockGas(blockID string) (*big.Int, error) {
+	var ops []*feeOperation
+	if err := c.getRPC(fmt.Sprintf("%s/operations/%d", c.blockPath(blockID), managerPass), &ops); err != nil {
+		return nil, err
+	}
+	gas := new(big.Int)
+	for _, op := range ops {
+		for _, el := range op.Contents {
+			if r := el.Metadata.OperationResult; r != nil {
+				gas.Add(gas, r.gas())
+			}
+			for _, ir := range el.Metadata.InternalOperationResults {
+				if ir.Result != nil {
+					gas.Add(gas, ir.Result.gas())
+				}
+			}
+		}
+	}
+	return gas, nil
+}
+
+// mempoolDepth returns the number of operations waiting for inclusion
+func (c *RootContext) mempoolDepth() (int, error) {
+	var pending map[string]json.RawMessage
+	if err := c.getRPC("/chains/"+c.chainID+"/mempool/pending_operations", &pending); err != nil {
+		return 0, err
+	}
+	var n int
+	for _, class := range mempoolPendingClasses {
+		var list []json.RawMessage
+		if raw, ok := pending[class]; ok {
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return 0, err
+			}
+		}
+		n += len(list)
+	}
+	return n, nil
+}
+
+// congestionMonitor averages gas utilization over the window of recent blocks
+type congestionMonitor struct {
+	ctx      *RootContext
+	opt      *congestionOptions
+	gasLimit *big.Int
+	window   []float64
+}
+
+func (m *congestionMonitor) check(bi *tezos.BlockInfo) (*congestionStatus, error) {
+	gas, err := m.ctx.blockGas(bi.Hash)
+	if err != nil {
+		return nil, err
+	}
+	depth, err := m.ctx.mempoolDepth()
+	if err != nil {
+		return nil, err
+	}
+
+	s := congestionStatus{
+		Block:        bi.Hash,
+		Level:        bi.Level,
+		Timestamp:    bi.Timestamp,
+		BlockGas:     bigRatio(gas, m.gasLimit),
+		MempoolDepth: depth,
+	}
+	if m.window = append(m.window, s.BlockGas); len(m.window) > m.opt.window {
+		m.window = m.window[len(m.window)-m.opt.window:]
+	}
+	var sum float64
+	for _, v := range m.window {
+		sum += v
+	}
+	s.GasUtilization = sum / float64(len(m.window))
+	s.Congested = s.GasUtilization >= m.opt.gasThreshold || m.opt.mempoolDepth > 0 && depth >= m.opt.mempoolDepth
+	return &s, nil
+}
+
+func (c *RootContext) showCongestion(opt *congestionOptions) error {
+	if opt.window < 1 {
+		return fmt.Errorf("Invalid window: %d", opt.window)
+	}
+	if opt.gasThreshold <= 0 || opt.gasThreshold > 1 {
+		return fmt.Errorf("Invalid gas threshold: %g", opt.gasThreshold)
+	}
+	if opt.format != "text" && opt.format != "json" {
+		return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+	}
+	if len(opt.sinkURLs) != 0 && !opt.watch {
+		return errors.New("--sink requires --watch")
+	}
+
+	var constants managerConstants
+	if err := c.getRPC(c.blockPath("head")+"/context/constants", &constants); err != nil {
+		return err
+	}
+	if constants.HardGasLimitPerBlock.Sign() == 0 {
+		return errors.New("Block gas limit is unknown")
+	}
+	m := congestionMonitor{
+		ctx:      c,
+		opt:      opt,
+		gasLimit: &constants.HardGasLimitPerBlock.Int,
+	}
+
+	if !opt.watch {
+		// Fill the window with the blocks preceding the head
+		var head tezos.BlockInfo
+		if err := c.getRPC(c.blockPath("head")+"/header", &head); err != nil {
+			return err
+		}
+		var s *congestionStatus
+		for level := head.Level - opt.window + 1; level <= head.Level; level++ {
+			if level < 1 {
+				continue
+			}
+			bi := &head
+			if level != head.Level {
+				bi = new(tezos.BlockInfo)
+				if err := c.getRPC(c.blockPath(strconv.Itoa(level))+"/header", bi); err != nil {
+					return err
+				}
+			}
+			var err error
+			if s, err = m.check(bi); err != nil {
+				return err
+			}
+		}
+		if opt.format == "json" {
+			return json.NewEncoder(os.Stdout).Encode(s)
+		}
+		return c.writeCongestionStatus(os.Stdout, s, opt)
+	}
+
+	var sinks *eventSinks
+	if len(opt.sinkURLs) != 0 {
+		var err error
+		if sinks, err = c.newEventSinks(opt.sinkURLs, "{{.Event}}", nil); err != nil {
+			return err
+		}
+		defer sinks.close()
+	}
+
+	log.WithFields(log.Fields{
+		"gas_threshold":     opt.gasThreshold,
+		"mempool_threshold": opt.mempoolDepth,
+		"window":            opt.window,
+	}).Info("Watching congestion")
+
+	var monErr error
+	ch := make(chan *tezos.BlockInfo, 10)
+	go func() {
+		monErr = c.monitorHeads(ch)
+		close(ch)
+	}()
+
+	// State of the previous block, nil before the first one
+	var last *bool
+	for bi := range ch {
+		s, err := m.check(bi)
+		if err != nil {
+			if err == context.Canceled {
+				break
+			}
+			return err
+		}
+		log.WithFields(log.Fields{
+			"block_level": s.Level,
+			"block_gas":   fmt.Sprintf("%.1f%%", s.BlockGas*100),
+			"average_gas": fmt.Sprintf("%.1f%%", s.GasUtilization*100),
+			"mempool":     s.MempoolDepth,
+		}).Debug("Congestion checked")
+
+		if last != nil && *last == s.Congested || last == nil && !s.Congested {
+			last = &s.Congested
+			continue
+		}
+		last = &s.Congested
+
+		ev := congestionEvent{
+			Event:            congestionCleared,
+			congestionStatus: s,
+			GasThreshold:     opt.gasThreshold,
+			MempoolThreshold: opt.mempoolDepth,
+		}
+		if s.Congested {
+			ev.Event = congestionCongested
+			log.WithFields(log.Fields{
+				"block_level": s.Level,
+				"average_gas": fmt.Sprintf("%.1f%%", s.GasUtilization*100),
+				"mempool":     s.MempoolDepth,
+			}).Warn("Chain is congested")
+		}
+
+		if opt.format == "json" {
+			err = json.NewEncoder(os.Stdout).Encode(&ev)
+		} else {
+			err = c.writeCongestionEvent(os.Stdout, &ev)
+		}
+		if err != nil {
+			return err
+		}
+		if sinks != nil {
+			if err := sinks.publish(&ev, &ev); err != nil {
+				return err
+			}
+		}
+	}
+
+	if monErr != nil && monErr != context.Canceled {
+		return monErr
+	}
+	return nil
+}
+
+func (c *RootContext) writeCongestionStatus(w io.Writer, s *congestionStatus, opt *congestionOptions) error {
+	status := c.colorizer.Green("normal").String()
+	if s.Congested {
+		status = c.colorizer.Red("congested").String()
+	}
+	_, err := fmt.Fprintf(w, "Level:            %d\nGas utilization:  %.1f%% (last block %.1f%%, threshold %.1f%%)\nMempool depth:    %d (threshold %d)\nStatus:           %s\n",
+		s.Level, s.GasUtilization*100, s.BlockGas*100, opt.gasThreshold*100, s.MempoolDepth, opt.mempoolDepth, status)
+	return err
+}
+
+func (c *RootContext) writeCongestionEvent(w io.Writer, ev *congestionEvent) error {
+	msg := c.colorizer.Green("back to normal").String()
+	if ev.Event == congestionCongested {
+		msg = c.colorizer.Red("congested").String()
+	}
+	_, err := fmt.Fprintf(w, "%s %d gas %.1f%% mempool %d %s\n", c.localTime(ev.Timestamp).Format("2006-01-02 15:04:05"), ev.Level, ev.GasUtilization*100, ev.MempoolDepth, msg)
+	return err
+}
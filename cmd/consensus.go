@@ -0,0 +1,191 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type consensusKey struct {
+	PublicKeyHash string `json:"pkh" yaml:"pkh"`
+	PublicKey     string `json:"pk,omitempty" yaml:"pk,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Early protocols return the key hash only
+func (k *consensusKey) UnmarshalJSON(data []byte) error {
+	var pkh string
+	if err := json.Unmarshal(data, &pkh); err == nil {
+		k.PublicKeyHash = pkh
+		return nil
+	}
+	type key consensusKey
+	return json.Unmarshal(data, (*key)(k))
+}
+
+type pendingConsensusKey struct {
+	Cycle         int    `json:"cycle" yaml:"cycle"`
+	PublicKeyHash string `json:"pkh" yaml:"pkh"`
+	PublicKey     string `json:"pk,omitempty" yaml:"pk,omitempty"`
+}
+
+type delegateConsensusKeys struct {
+	Delegate string                 `json:"delegate" yaml:"delegate"`
+	Active   *consensusKey          `json:"active" yaml:"active"`
+	Pendings []*pendingConsensusKey `json:"pendings,omitempty" yaml:"pendings,omitempty"`
+}
+
+func newConsensusKeyCommand(ctx *RootContext) *cobra.Command {
+	var (
+		outputFormat string
+		key          string
+		opt          injectOptions
+	)
+
+	consensusKeyCmd := &cobra.Command{
+		Use:   "consensus-key",
+		Short: "Show or rotate the baker's consensus key",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show <baker>",
+		Short: "Show active and pending consensus keys",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := ctx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			keys, err := ctx.getConsensusKeys(address)
+			if err != nil {
+				return err
+			}
+
+			if outputFormat != "text" {
+				newEnc := ctx.encoderFunc(outputFormat)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+				return newEnc(os.Stdout).Encode(keys)
+			}
+
+			fmt.Printf("Delegate:   %s\n", keys.Delegate)
+			if keys.Active != nil {
+				fmt.Printf("Active key: %s\n", keys.Active.PublicKeyHash)
+				if keys.Active.PublicKey != "" {
+					fmt.Printf("            %s\n", keys.Active.PublicKey)
+				}
+			}
+			for _, p := range keys.Pendings {
+				fmt.Printf("Pending:    %s from cycle %d\n", p.PublicKeyHash, p.Cycle)
+			}
+			return nil
+		},
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set <consensus key>",
+		Short: "Set new consensus key",
+		Long: `Set new consensus key given as a keystore alias or a public key.
+The new key becomes active only after a delay of several cycles, until then blocks and attestations must be signed with the current one.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.setConsensusKey(key, args[0], &opt)
+		},
+	}
+
+	showCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	setCmd.Flags().StringVar(&key, "key", "", "Baker key: keystore alias or address")
+	setCmd.MarkFlagRequired("key")
+	addInjectFlags(setCmd, &opt)
+
+	consensusKeyCmd.AddCommand(showCmd)
+	consensusKeyCmd.AddCommand(setCmd)
+
+	return consensusKeyCmd
+}
+
+func (c *RootContext) getConsensusKeys(address string) (*delegateConsensusKeys, error) {
+	if err := c.supportsKind(protocol.KindUpdateConsensusKey); err != nil {
+		return nil, err
+	}
+
+	res := delegateConsensusKeys{Delegate: address}
+	err := c.getRPC(c.blockPath(c.blockID)+"/context/delegates/"+address+"/consensus_key", &res)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("%s is not a registered delegate", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *RootContext) setConsensusKey(bakerKey, consensusKey string, opt *injectOptions) error {
+	acc, err := c.account(bakerKey)
+	if err != nil {
+		return err
+	}
+	pk, err := c.resolvePublicKey(consensusKey)
+	if err != nil {
+		return err
+	}
+
+	current, err := c.getConsensusKeys(acc.Address)
+	if err != nil {
+		return err
+	}
+	for _, p := range current.Pendings {
+		if p.PublicKey == pk {
+			return fmt.Errorf("The key is already pending activation at cycle %d", p.Cycle)
+		}
+	}
+	if current.Active != nil && current.Active.PublicKey == pk && len(current.Pendings) == 0 {
+		return fmt.Errorf("The key is already the active consensus key of %s", acc.Address)
+	}
+
+	_, fromCycle, _, err := c.activationCycle()
+	if err != nil {
+		return err
+	}
+
+	hash, err := c.sendOperations(acc, []forge.ManagerOperation{&forge.UpdateConsensusKey{PublicKey: pk}}, opt)
+	if err != nil {
+		return err
+	}
+	if opt.dryRun {
+		return nil
+	}
+	fmt.Println(hash)
+
+	log.WithFields(log.Fields{
+		"delegate": acc.Address,
+		"cycle":    fromCycle,
+	}).Warn("New consensus key becomes active from the cycle, keep signing with the current one until then")
+
+	return nil
+}
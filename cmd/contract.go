@@ -0,0 +1,173 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/michelson"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// contractScript is the originated contract's code and current storage
+type contractScript struct {
+	Code    *michelson.Node `json:"code"`
+	Storage *michelson.Node `json:"storage"`
+}
+
+// NewContractCommand returns new `contract' command
+func NewContractCommand(rootCtx *RootContext) *cobra.Command {
+	contractCmd := &cobra.Command{
+		Use:   "contract",
+		Short: "Smart contract deployment and inspection",
+	}
+
+	contractCmd.AddCommand(newContractCodeCommand(rootCtx))
+	contractCmd.AddCommand(newContractViewCommand(rootCtx))
+	contractCmd.AddCommand(newContractOriginateCommand(rootCtx))
+	contractCmd.AddCommand(newContractListCommand(rootCtx))
+	contractCmd.AddCommand(newContractHashCommand(rootCtx))
+	contractCmd.AddCommand(newContractFindSimilarCommand(rootCtx))
+	contractCmd.AddCommand(newContractStatsCommand(rootCtx))
+	contractCmd.AddCommand(newContractStorageDiffCommand(rootCtx))
+
+	return contractCmd
+}
+
+func newContractCodeCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		format   string
+		saveDir  string
+		width    int
+		noMacros bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "code <KT1...|alias>",
+		Short: "Print the contract's code",
+		Long: `Print the contract's code as formatted Michelson source or Micheline JSON.
+Expansions of common macros (FAIL, ASSERT_*, CMPxx, IFxx, IFCMPxx, CxR) are collapsed unless --no-macros is given.
+With --save the code and the storage are written to <address>.tz and <address>.storage.tz (.json with --format json) in the directory instead.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "michelson" && format != "json" {
+				return fmt.Errorf("Unknown format: `%s'", format)
+			}
+			address, err := rootCtx.contractAddress(args[0])
+			if err != nil {
+				return err
+			}
+			script, err := rootCtx.contractScript(address)
+			if err != nil {
+				return err
+			}
+
+			code, storage := script.Code, script.Storage
+			if format == "michelson" && !noMacros {
+				code = michelson.CollapseMacros(code)
+			}
+			render := func(n *michelson.Node) ([]byte, error) {
+				if format == "json" {
+					return json.MarshalIndent(n, "", "  ")
+				}
+				return []byte(n.Format(width)), nil
+			}
+
+			codeText, err := render(code)
+			if err != nil {
+				return err
+			}
+			if saveDir == "" {
+				fmt.Println(string(codeText))
+				return nil
+			}
+
+			storageText, err := render(storage)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(saveDir, 0755); err != nil {
+				return err
+			}
+			ext := ".tz"
+			if format == "json" {
+				ext = ".json"
+			}
+			for _, f := range []struct {
+				name string
+				data []byte
+			}{
+				{address + ext, codeText},
+				{address + ".storage" + ext, storageText},
+			} {
+				path := filepath.Join(saveDir, f.name)
+				if err := ioutil.WriteFile(path, append(f.data, '\n'), 0644); err != nil {
+					return err
+				}
+				log.WithField("file", path).Info("Saved")
+			}
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&format, "format", "michelson", "Code format: one of [michelson, json]")
+	f.StringVar(&saveDir, "save", "", "Write the code and the storage to files in the directory")
+	f.IntVar(&width, "width", 80, "Maximum line width of Michelson source")
+	f.BoolVar(&noMacros, "no-macros", false, "Print macros expanded the way they are stored on chain")
+
+	return cmd
+}
+
+// contractAddress resolves the contract alias and validates the originated contract address
+func (c *RootContext) contractAddress(s string) (string, error) {
+	address, err := c.resolveAddress(s)
+	if err != nil {
+		return "", err
+	}
+	if p, _, err := base58.DecodeAny(address); err != nil || p != base58.PrefixContractHash {
+		return "", fmt.Errorf("Invalid contract address: `%s'", s)
+	}
+	return address, nil
+}
+
+// contractScript returns the contract's code and storage at the selected block
+func (c *RootContext) contractScript(address string) (*contractScript, error) {
+	return c.contractScriptAt(address, c.blockID)
+}
+
+// contractScriptAt returns the contract's code and storage at the block
+func (c *RootContext) contractScriptAt(address, blockID string) (*contractScript, error) {
+	var script contractScript
+	if err := c.getRPC(c.blockPath(blockID)+"/context/contracts/"+address+"/script", &script); err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("Contract %s is not found", address)
+		}
+		return nil, err
+	}
+	return &script, nil
+}
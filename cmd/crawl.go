@@ -0,0 +1,116 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/crawler"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Interval between progress messages of long crawls
+const crawlProgressInterval = 10 * time.Second
+
+type crawlOptions struct {
+	concurrency int
+	retries     int
+}
+
+func addCrawlFlags(c *cobra.Command, opt *crawlOptions, concurrency int, what string) {
+	f := c.Flags()
+	f.IntVar(&opt.concurrency, "concurrency", concurrency, fmt.Sprintf("Number of %s fetched concurrently", what))
+	f.IntVar(&opt.retries, "retries", crawler.DefaultRetries, "Number of retries of a failed fetch")
+}
+
+// newCrawler returns a crawler which logs its progress every crawlProgressInterval. Short crawls are silent
+func (c *RootContext) newCrawler(opt *crawlOptions, what string) *crawler.Crawler {
+	retries := opt.retries
+	if retries == 0 {
+		retries = -1
+	}
+
+	var (
+		last   = time.Now()
+		logged bool
+	)
+	return &crawler.Crawler{
+		Concurrency: opt.concurrency,
+		Retries:     retries,
+		Progress: func(p *crawler.Progress) {
+			if time.Since(last) < crawlProgressInterval && (p.Done != p.Total || !logged) {
+				return
+			}
+			last, logged = time.Now(), true
+			log.WithFields(log.Fields{
+				what:      fmt.Sprintf("%d/%d", p.Done, p.Total),
+				"skipped": p.Skipped,
+				"retries": p.Retries,
+				"eta":     p.ETA().Round(time.Second),
+			}).Info("Crawling")
+		},
+	}
+}
+
+// primeCache makes the RPC cache aware of the head level. Otherwise blocks addressed by a level aren't known to be final
+// and aren't cached, while they should be for an interrupted crawl to resume cheaply with --rpc-cache-dir
+func (c *RootContext) primeCache() error {
+	if c.cache == nil || c.cache.Head() != 0 {
+		return nil
+	}
+	_, err := c.getBlockTime("head")
+	return err
+}
+
+// crawlError marks client errors as permanent. Rate limiting is handled by the transport so
+// a 4xx response won't change on retry
+func crawlError(err error) error {
+	if st, ok := err.(tezos.HTTPStatus); ok && st.StatusCode() >= 400 && st.StatusCode() < 500 && st.StatusCode() != http.StatusTooManyRequests {
+		return crawler.Permanent(err)
+	}
+	return err
+}
+
+// fetchBlock is a crawler.FetchFunc for block queries
+func (c *BlockCommandContext) fetchBlock(ctx context.Context, query string) (interface{}, error) {
+	block, err := c.getBlock(query, false)
+	if err != nil {
+		return nil, crawlError(err)
+	}
+	return block, nil
+}
+
+// scanBlocks fetches blocks concurrently passing each of them to fn. Calls to fn are serialized
+func (c *BlockCommandContext) scanBlocks(args []string, opt *crawlOptions, fn func(block *xblock)) error {
+	if err := c.primeCache(); err != nil {
+		return err
+	}
+	return c.newCrawler(opt, "blocks").Run(c.context, args, c.fetchBlock, func(i int, query string, v interface{}) error {
+		fn(v.(*xblock))
+		log.WithField("block", query).Debug("Block scanned")
+		return nil
+	})
+}
@@ -0,0 +1,387 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"text/template"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/protocol"
+	"github.com/spf13/cobra"
+)
+
+const dalParamsTemplate = `Enabled:               {{.FeatureEnable}}
+Incentives:            {{.IncentivesEnable}}
+Number of slots:       {{.NumberOfSlots}}
+Attestation lag:       {{.AttestationLag}} blocks
+Attestation threshold: {{.AttestationThreshold}}%
+Redundancy factor:     {{.RedundancyFactor}}
+Number of shards:      {{.NumberOfShards}}
+Slot size:             {{.SlotSize}} bytes
+Page size:             {{.PageSize}} bytes
+`
+
+const dalSlotsTemplate = `{{range .}}{{.Level}} slot {{.Index}} {{.Commitment | au.Bold}} {{.Publisher | au.Yellow}}{{if .Status}} {{.Status}}{{end}}
+{{end}}`
+
+const dalCoverageTemplate = `Level {{.Level}}: {{.AttestedPublished}} of {{len .Published}} slots published at level {{.PublishedLevel}} attested, {{len .Attested}} of {{.NumberOfSlots}} slots total
+{{- range .Published}}
+  slot {{.Index}} {{.Commitment}} {{.Publisher | au.Yellow}} {{if .Attested}}{{"attested" | au.Green}}{{else}}{{"not attested" | au.Red}}{{end}}
+{{- end}}
+`
+
+type dalParameters struct {
+	FeatureEnable        bool `json:"feature_enable" yaml:"feature_enable"`
+	IncentivesEnable     bool `json:"incentives_enable" yaml:"incentives_enable"`
+	NumberOfSlots        int  `json:"number_of_slots" yaml:"number_of_slots"`
+	AttestationLag       int  `json:"attestation_lag" yaml:"attestation_lag"`
+	AttestationThreshold int  `json:"attestation_threshold" yaml:"attestation_threshold"`
+	RedundancyFactor     int  `json:"redundancy_factor" yaml:"redundancy_factor"`
+	PageSize             int  `json:"page_size" yaml:"page_size"`
+	SlotSize             int  `json:"slot_size" yaml:"slot_size"`
+	NumberOfShards       int  `json:"number_of_shards" yaml:"number_of_shards"`
+}
+
+// Slot header published in a block
+type dalSlotInfo struct {
+	Level      int    `json:"level" yaml:"level"`
+	Index      int    `json:"index" yaml:"index"`
+	Commitment string `json:"commitment" yaml:"commitment"`
+	Publisher  string `json:"publisher" yaml:"publisher"`
+	Hash       string `json:"hash" yaml:"hash"`
+	Status     string `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+type dalSlotAttestation struct {
+	*dalSlotInfo `yaml:",inline"`
+	Attested     bool `json:"attested" yaml:"attested"`
+}
+
+// Attestation of slots published attestation_lag blocks before the block
+type dalCoverage struct {
+	Level             int                   `json:"level" yaml:"level"`
+	PublishedLevel    int                   `json:"published_level" yaml:"published_level"`
+	NumberOfSlots     int                   `json:"number_of_slots" yaml:"number_of_slots"`
+	Attested          []int                 `json:"attested" yaml:"attested"`
+	AttestedPublished int                   `json:"attested_published" yaml:"attested_published"`
+	Published         []*dalSlotAttestation `json:"published" yaml:"published"`
+}
+
+type rawDALOpContents struct {
+	Kind       string `json:"kind"`
+	Source     string `json:"source"`
+	SlotHeader struct {
+		SlotIndex  *int   `json:"slot_index"`
+		Index      int    `json:"index"` // dal_publish_slot_header
+		Commitment string `json:"commitment"`
+	} `json:"slot_header"`
+	Metadata struct {
+		OperationResult struct {
+			Status string `json:"status"`
+		} `json:"operation_result"`
+	} `json:"metadata"`
+}
+
+// DALCommandContext represents `dal' command context
+type DALCommandContext struct {
+	*RootContext
+	watch      bool
+	windowOpt  windowOptions
+	newEncoder utils.NewEncoderFunc
+	funcMap    template.FuncMap
+}
+
+// NewDALCommand returns new `dal' command
+func NewDALCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		outputFormat string
+		dalCmd       *cobra.Command // Forward declaration, see PersistentPreRunE below
+	)
+
+	ctx := DALCommandContext{
+		RootContext: rootCtx,
+	}
+
+	dalCmd = &cobra.Command{
+		Use:   "dal",
+		Short: "Data availability layer inspection",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if p := dalCmd.Parent(); p != nil {
+				if pr := p.PersistentPreRunE; pr != nil {
+					if err := pr(cmd, args); err != nil {
+						return err
+					}
+				}
+			}
+
+			if outputFormat != "text" {
+				if ctx.newEncoder = rootCtx.encoderFunc(outputFormat); ctx.newEncoder == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+			}
+			ctx.funcMap = template.FuncMap{
+				"au": func() interface{} { return ctx.colorizer },
+			}
+			return nil
+		},
+	}
+
+	paramsCmd := &cobra.Command{
+		Use:   "params",
+		Short: "Show DAL parameters",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			params, err := ctx.getDALParameters(ctx.blockID)
+			if err != nil {
+				return err
+			}
+			return ctx.print(dalParamsTemplate, params)
+		},
+	}
+
+	slotsCmd := &cobra.Command{
+		Use:   "slots [block]",
+		Short: "Show slot headers published in a block",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			blockID := ctx.blockID
+			if len(args) != 0 {
+				blockID = args[0]
+			}
+			return ctx.forEachBlock(blockID, func(blockID string) error {
+				slots, err := ctx.getPublishedSlots(blockID)
+				if err != nil {
+					return err
+				}
+				if len(slots) == 0 && ctx.watch {
+					return nil
+				}
+				return ctx.print(dalSlotsTemplate, slots)
+			})
+		},
+	}
+
+	attestationsCmd := &cobra.Command{
+		Use:   "attestations [block]",
+		Short: "Show which of the previously published slots are attested in a block",
+		Long: `Show which of the previously published slots are attested in a block.
+Slots published at level L are attested by the block at level L + attestation_lag.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			blockID := ctx.blockID
+			if len(args) != 0 {
+				blockID = args[0]
+			}
+			return ctx.forEachBlock(blockID, func(blockID string) error {
+				cov, err := ctx.getDALCoverage(blockID)
+				if err != nil {
+					return err
+				}
+				return ctx.print(dalCoverageTemplate, cov)
+			})
+		},
+	}
+
+	dalCmd.PersistentFlags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json, msgpack, cbor]")
+	slotsCmd.Flags().BoolVar(&ctx.watch, "watch", false, "Watch for new blocks in a chain")
+	attestationsCmd.Flags().BoolVar(&ctx.watch, "watch", false, "Watch for new blocks in a chain")
+	addWindowFlags(slotsCmd.Flags(), &ctx.windowOpt, "blocks produced in watch mode")
+	addWindowFlags(attestationsCmd.Flags(), &ctx.windowOpt, "blocks produced in watch mode")
+
+	dalCmd.AddCommand(paramsCmd)
+	dalCmd.AddCommand(slotsCmd)
+	dalCmd.AddCommand(attestationsCmd)
+
+	return dalCmd
+}
+
+func (c *DALCommandContext) print(tpl string, data interface{}) error {
+	if c.newEncoder != nil {
+		return c.newEncoder(os.Stdout).Encode(data)
+	}
+
+	t, err := template.New("dal").Funcs(c.funcMap).Parse(tpl)
+	if err != nil {
+		return err
+	}
+	return t.Execute(os.Stdout, data)
+}
+
+// forEachBlock calls show for the block or, in watch mode, for every new head
+func (c *DALCommandContext) forEachBlock(blockID string, show func(blockID string) error) error {
+	window, err := c.windowOpt.window()
+	if err != nil {
+		return err
+	}
+	if !c.watch {
+		if window != nil {
+			return errors.New("--since and --until require --watch")
+		}
+		return show(blockID)
+	}
+
+	var monErr error
+	ch := make(chan *tezos.BlockInfo, 10)
+	go func() {
+		monErr = c.monitorHeads(ch)
+		close(ch)
+	}()
+
+	for bi := range ch {
+		if window.ended(bi.Timestamp) {
+			return nil
+		}
+		if !window.contains(bi.Timestamp) {
+			continue
+		}
+		if err := show(bi.Hash); err != nil {
+			return err
+		}
+	}
+
+	if monErr != nil && monErr != context.Canceled {
+		return monErr
+	}
+	return nil
+}
+
+func (c *DALCommandContext) getDALParameters(blockID string) (*dalParameters, error) {
+	var constants struct {
+		DAL *dalParameters `json:"dal_parametric"`
+	}
+	if err := c.getRPC(c.blockPath(blockID)+"/context/constants", &constants); err != nil {
+		return nil, err
+	}
+	if constants.DAL == nil {
+		return nil, fmt.Errorf("Protocol doesn't support %s operations", protocol.KindDALPublishCommitment)
+	}
+	return constants.DAL, nil
+}
+
+// getPublishedSlots returns slot headers published in the block
+func (c *DALCommandContext) getPublishedSlots(blockID string) ([]*dalSlotInfo, error) {
+	var header struct {
+		Level int `json:"level"`
+	}
+	if err := c.getRPC(c.blockPath(blockID)+"/header", &header); err != nil {
+		return nil, err
+	}
+
+	var passes [][]*rawOperation
+	if err := c.getRPC(c.blockPath(blockID)+"/operations", &passes); err != nil {
+		return nil, err
+	}
+
+	res := []*dalSlotInfo{}
+	for _, pass := range passes {
+		for _, op := range pass {
+			for _, raw := range op.Contents {
+				var el rawDALOpContents
+				if err := json.Unmarshal(raw, &el); err != nil {
+					return nil, err
+				}
+				if el.Kind != protocol.KindDALPublishCommitment && el.Kind != protocol.KindDALPublishSlotHeader {
+					continue
+				}
+
+				index := el.SlotHeader.Index
+				if el.SlotHeader.SlotIndex != nil {
+					index = *el.SlotHeader.SlotIndex
+				}
+				res = append(res, &dalSlotInfo{
+					Level:      header.Level,
+					Index:      index,
+					Commitment: el.SlotHeader.Commitment,
+					Publisher:  el.Source,
+					Hash:       op.Hash,
+					Status:     el.Metadata.OperationResult.Status,
+				})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// getDALCoverage matches the block's DAL attestation bitset against slots published attestation_lag blocks before
+func (c *DALCommandContext) getDALCoverage(blockID string) (*dalCoverage, error) {
+	params, err := c.getDALParameters(blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata struct {
+		LevelInfo struct {
+			Level int `json:"level"`
+		} `json:"level_info"`
+		DALAttestation string `json:"dal_attestation"`
+	}
+	if err := c.getRPC(c.blockPath(blockID)+"/metadata", &metadata); err != nil {
+		return nil, err
+	}
+
+	// Arbitrary precision bitset of attested slot indices
+	var bits big.Int
+	if metadata.DALAttestation != "" {
+		if _, ok := bits.SetString(metadata.DALAttestation, 10); !ok {
+			return nil, fmt.Errorf("Invalid DAL attestation bitset: `%s'", metadata.DALAttestation)
+		}
+	}
+
+	cov := dalCoverage{
+		Level:          metadata.LevelInfo.Level,
+		PublishedLevel: metadata.LevelInfo.Level - params.AttestationLag,
+		NumberOfSlots:  params.NumberOfSlots,
+		Attested:       []int{},
+		Published:      []*dalSlotAttestation{},
+	}
+	for i := 0; i < bits.BitLen(); i++ {
+		if bits.Bit(i) != 0 {
+			cov.Attested = append(cov.Attested, i)
+		}
+	}
+
+	if cov.PublishedLevel <= 0 {
+		return &cov, nil
+	}
+	published, err := c.getPublishedSlots(fmt.Sprintf("%d", cov.PublishedLevel))
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range published {
+		if s.Status != "" && s.Status != "applied" {
+			continue
+		}
+		a := dalSlotAttestation{dalSlotInfo: s, Attested: bits.Bit(s.Index) != 0}
+		if a.Attested {
+			cov.AttestedPublished++
+		}
+		cov.Published = append(cov.Published, &a)
+	}
+	return &cov, nil
+}
@@ -0,0 +1,204 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var errNotConfirmed = errors.New("Consensus operations signed by hand can get the key slashed, pass --i-know-what-i-am-doing to proceed")
+
+// attestedHeader holds the fields of the attested block header
+type attestedHeader struct {
+	Level       int32    `json:"level"`
+	Predecessor string   `json:"predecessor"`
+	PayloadHash string   `json:"payload_hash"`
+	Fitness     []string `json:"fitness"`
+}
+
+// round returns the block round which is the last element of the Tenderbake fitness
+func (h *attestedHeader) round() (int32, error) {
+	if len(h.Fitness) == 0 {
+		return 0, fmt.Errorf("Block at level %d has no fitness", h.Level)
+	}
+	v, err := strconv.ParseUint(h.Fitness[len(h.Fitness)-1], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Can't get the round of the block at level %d: %v", h.Level, err)
+	}
+	return int32(v), nil
+}
+
+// NewDebugCommand returns new `debug' command
+func NewDebugCommand(rootCtx *RootContext) *cobra.Command {
+	debugCmd := &cobra.Command{
+		Use:         "debug",
+		Short:       "Low level tools for protocol development on test networks",
+		Annotations: map[string]string{headAnnotation: ""},
+	}
+
+	debugCmd.AddCommand(newAttestCommand(rootCtx))
+	debugCmd.AddCommand(newBakePreviewCommand(rootCtx))
+
+	return debugCmd
+}
+
+func newAttestCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		level       int32
+		slot        int
+		round       int32
+		payloadHash string
+		from        string
+		pre         bool
+		dryRun      bool
+		confirmed   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "attest",
+		Short: "Forge, sign and inject an attestation",
+		Long: `Forge, sign and inject a Tenderbake attestation (or preattestation with --pre) of the block at the given level.
+The slot defaults to the first one of the key in the committee, the round and the payload hash are taken from the block.
+Overriding them allows producing conflicting operations to test slashing and consensus edge cases,
+so the high watermarks are neither checked nor updated.
+Refused on mainnet.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !confirmed {
+				return errNotConfirmed
+			}
+			acc, err := rootCtx.account(from)
+			if err != nil {
+				return err
+			}
+
+			var chainID string
+			if err := rootCtx.getRPC("/chains/"+rootCtx.chainID+"/chain_id", &chainID); err != nil {
+				return err
+			}
+			if chainID == networks["mainnet"].ChainID {
+				return errors.New("Consensus operations can't be injected by hand on mainnet")
+			}
+
+			var header attestedHeader
+			if err := rootCtx.getRPC(rootCtx.blockPath(strconv.Itoa(int(level)))+"/header", &header); err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("round") {
+				if round, err = header.round(); err != nil {
+					return err
+				}
+			}
+			if payloadHash == "" {
+				payloadHash = header.PayloadHash
+			}
+			if !cmd.Flags().Changed("slot") {
+				if slot, err = rootCtx.firstSlot(level, acc.Address); err != nil {
+					return err
+				}
+			}
+			if slot < 0 || slot > 0xffff {
+				return fmt.Errorf("Slot is out of range: %d", slot)
+			}
+
+			op := &forge.Attestation{
+				Preattestation:   pre,
+				Slot:             uint16(slot),
+				Level:            level,
+				Round:            round,
+				BlockPayloadHash: payloadHash,
+			}
+			forged, err := forge.Encode(header.Predecessor, []forge.Operation{op})
+			if err != nil {
+				return err
+			}
+
+			watermark := byte(keys.WatermarkAttestation)
+			if pre {
+				watermark = keys.WatermarkPreattestation
+			}
+			// Conflicting operations are the point here, the high watermarks would refuse them
+			sig, err := acc.signBytesUnchecked(keys.LookupWatermark(watermark), chainID, forged)
+			if err != nil {
+				return err
+			}
+			signed := append(forged, sig.Bytes...)
+
+			log.WithFields(log.Fields{
+				"kind":         op.Kind(),
+				"block_level":  op.Level,
+				"slot":         op.Slot,
+				"round":        op.Round,
+				"payload_hash": op.BlockPayloadHash,
+				"branch":       header.Predecessor,
+			}).Info("Consensus operation signed")
+
+			if dryRun {
+				fmt.Println(hex.EncodeToString(signed))
+				return nil
+			}
+			hash, err := rootCtx.injectOperation(signed)
+			if err != nil {
+				return err
+			}
+			fmt.Println(hash)
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.Int32Var(&level, "level", 0, "Level of the attested block")
+	f.IntVar(&slot, "slot", 0, "Committee slot (default: first slot of the key)")
+	f.Int32Var(&round, "round", 0, "Consensus round (default: round of the block)")
+	f.StringVar(&payloadHash, "payload-hash", "", "Block payload hash (default: payload hash of the block)")
+	f.StringVar(&from, "key", "", "Signing key: keystore alias or address of the delegate or its consensus key")
+	f.BoolVar(&pre, "pre", false, "Produce a preattestation")
+	f.BoolVar(&dryRun, "dry-run", false, "Print the signed operation bytes instead of injecting them")
+	f.BoolVar(&confirmed, "i-know-what-i-am-doing", false, "Confirm signing consensus operations by hand")
+	cmd.MarkFlagRequired("level")
+	cmd.MarkFlagRequired("key")
+
+	return cmd
+}
+
+// firstSlot returns the first committee slot of the delegate or its consensus key at the level
+func (c *RootContext) firstSlot(level int32, address string) (int, error) {
+	var rights []*consensusRights
+	if err := c.getRPC(fmt.Sprintf("%s/helpers/attestation_rights?level=%d", c.blockPath("head"), level), &rights); err != nil {
+		return 0, err
+	}
+	for _, r := range rights {
+		for _, d := range r.Delegates {
+			if d.Delegate == address || d.ConsensusKey == address {
+				return d.FirstSlot, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%s has no attestation rights at level %d", address, level)
+}
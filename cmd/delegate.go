@@ -0,0 +1,159 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ecadlabs/tez/forge"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewDelegateCommand returns new `delegate' command
+func NewDelegateCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		from string
+		opt  injectOptions
+	)
+
+	delegateCmd := &cobra.Command{
+		Use:   "delegate",
+		Short: "Manage delegation of an account",
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set <delegate>",
+		Short: "Delegate the account to a baker",
+		Long: `Delegate the account to a baker. The delegate can be given as an address or a keystore alias.
+Delegating the account to itself registers it as a delegate (baker).`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			acc, err := rootCtx.account(from)
+			if err != nil {
+				return err
+			}
+			delegate, err := rootCtx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+
+			current, err := rootCtx.contractDelegate(acc.Address)
+			if err != nil {
+				return err
+			}
+			if current == delegate {
+				return fmt.Errorf("%s is already delegated to %s", acc.Address, delegate)
+			}
+
+			if delegate == acc.Address {
+				log.WithField("address", acc.Address).Info("Self-delegation, the account will be registered as a delegate")
+			} else {
+				ok, err := rootCtx.isDelegate(delegate)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("%s is not a registered delegate", delegate)
+				}
+			}
+
+			return rootCtx.sendAndPrint(acc, []forge.ManagerOperation{&forge.Delegation{Delegate: delegate}}, &opt)
+		},
+	}
+
+	withdrawCmd := &cobra.Command{
+		Use:   "withdraw",
+		Short: "Clear the account's delegate",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			acc, err := rootCtx.account(from)
+			if err != nil {
+				return err
+			}
+
+			current, err := rootCtx.contractDelegate(acc.Address)
+			if err != nil {
+				return err
+			}
+			if current == "" {
+				return fmt.Errorf("%s has no delegate", acc.Address)
+			}
+			if current == acc.Address {
+				return fmt.Errorf("%s is a registered delegate and can't withdraw its self-delegation", acc.Address)
+			}
+
+			return rootCtx.sendAndPrint(acc, []forge.ManagerOperation{&forge.Delegation{}}, &opt)
+		},
+	}
+
+	for _, c := range []*cobra.Command{setCmd, withdrawCmd} {
+		c.Flags().StringVar(&from, "from", "", "Source account: keystore alias or address")
+		c.MarkFlagRequired("from")
+		addInjectFlags(c, &opt)
+	}
+
+	delegateCmd.AddCommand(setCmd)
+	delegateCmd.AddCommand(withdrawCmd)
+	delegateCmd.AddCommand(newDelegateChurnCommand(rootCtx))
+	delegateCmd.AddCommand(newDelegateCapacityCommand(rootCtx))
+	delegateCmd.AddCommand(newDelegateSlashesCommand(rootCtx))
+
+	return delegateCmd
+}
+
+// contractDelegate returns the current delegate of the contract or an empty string
+func (c *RootContext) contractDelegate(address string) (string, error) {
+	var delegate string
+	err := c.getRPC(c.blockPath("head")+"/context/contracts/"+address+"/delegate", &delegate)
+	if isNotFound(err) {
+		return "", nil
+	}
+	return delegate, err
+}
+
+// isDelegate returns true if the address is a registered delegate
+func (c *RootContext) isDelegate(address string) (bool, error) {
+	var deactivated bool
+	err := c.getRPC(c.blockPath("head")+"/context/delegates/"+address+"/deactivated", &deactivated)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if deactivated {
+		log.WithField("delegate", address).Warn("Delegate is deactivated")
+	}
+	return true, nil
+}
+
+// sendAndPrint sends the operations and prints the resulting hash
+func (c *RootContext) sendAndPrint(acc *account, ops []forge.ManagerOperation, opt *injectOptions) error {
+	hash, err := c.sendOperations(acc, ops, opt)
+	if err != nil {
+		return err
+	}
+	if hash != "" {
+		fmt.Println(hash)
+	}
+	return nil
+}
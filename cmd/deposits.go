@@ -0,0 +1,312 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/michelson"
+	"github.com/ecadlabs/tez/protocol"
+	"github.com/spf13/cobra"
+)
+
+// Staking parameters are fixed point values
+const (
+	stakingLimitScale = 1e6
+	stakingEdgeScale  = 1e9
+)
+
+type stakingParameters struct {
+	LimitOfStakingOverBakingMillionth int64 `json:"limit_of_staking_over_baking_millionth" yaml:"limit_of_staking_over_baking_millionth"`
+	EdgeOfBakingOverStakingBillionth  int64 `json:"edge_of_baking_over_staking_billionth" yaml:"edge_of_baking_over_staking_billionth"`
+}
+
+func (p *stakingParameters) String() string {
+	return fmt.Sprintf("limit of staking over baking %g, edge of baking over staking %g%%",
+		float64(p.LimitOfStakingOverBakingMillionth)/stakingLimitScale,
+		float64(p.EdgeOfBakingOverStakingBillionth)/stakingEdgeScale*100)
+}
+
+type pendingStakingParameters struct {
+	Cycle      int                `json:"cycle" yaml:"cycle"`
+	Parameters *stakingParameters `json:"parameters" yaml:"parameters"`
+}
+
+type bakerDeposits struct {
+	Delegate                 string                      `json:"delegate" yaml:"delegate"`
+	StakingBalanceMutez      *big.Int                    `json:"staking_balance_mutez" yaml:"staking_balance_mutez"`
+	FrozenDepositsMutez      *big.Int                    `json:"frozen_deposits_mutez" yaml:"frozen_deposits_mutez"`
+	FrozenDepositsLimitMutez *big.Int                    `json:"frozen_deposits_limit_mutez,omitempty" yaml:"frozen_deposits_limit_mutez,omitempty"`
+	CoveredStakeMutez        *big.Int                    `json:"covered_stake_mutez,omitempty" yaml:"covered_stake_mutez,omitempty"`
+	OverDelegationMutez      *big.Int                    `json:"over_delegation_mutez,omitempty" yaml:"over_delegation_mutez,omitempty"`
+	StakingParameters        *stakingParameters          `json:"staking_parameters,omitempty" yaml:"staking_parameters,omitempty"`
+	PendingStakingParameters []*pendingStakingParameters `json:"pending_staking_parameters,omitempty" yaml:"pending_staking_parameters,omitempty"`
+}
+
+// Present in protocols with the deposits limit only
+type depositsConstants struct {
+	FrozenDepositsPercentage *int64 `json:"frozen_deposits_percentage"`
+}
+
+func newDepositsCommand(ctx *RootContext) *cobra.Command {
+	var outputFormat string
+
+	depositsCmd := &cobra.Command{
+		Use:   "deposits <baker>",
+		Short: "Show frozen deposits against the limit and staking parameters",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := ctx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			d, err := ctx.getBakerDeposits(address)
+			if err != nil {
+				return err
+			}
+
+			if outputFormat != "text" {
+				newEnc := ctx.encoderFunc(outputFormat)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+				return newEnc(os.Stdout).Encode(d)
+			}
+			ctx.printBakerDeposits(d)
+			return nil
+		},
+	}
+
+	depositsCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+
+	return depositsCmd
+}
+
+func newSetDepositsLimitCommand(ctx *RootContext) *cobra.Command {
+	var (
+		key string
+		opt injectOptions
+	)
+
+	setCmd := &cobra.Command{
+		Use:   "set-deposits-limit <amount|none>",
+		Short: "Limit the baker's frozen deposits",
+		Long: `Limit the baker's frozen deposits to the amount in tez, none removes the limit.
+The stake exceeding the part covered by the limited deposits doesn't give rights (over-delegation).`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := ctx.supportsKind(protocol.KindSetDepositsLimit); err != nil {
+				return err
+			}
+			acc, err := ctx.account(key)
+			if err != nil {
+				return err
+			}
+
+			var limit *big.Int
+			if args[0] != "none" {
+				if limit, err = utils.ParseTez(args[0]); err != nil {
+					return err
+				}
+			}
+			return ctx.sendAndPrint(acc, []forge.ManagerOperation{&forge.SetDepositsLimit{Limit: limit}}, &opt)
+		},
+	}
+
+	setCmd.Flags().StringVar(&key, "key", "", "Baker key: keystore alias or address")
+	setCmd.MarkFlagRequired("key")
+	addInjectFlags(setCmd, &opt)
+
+	return setCmd
+}
+
+func newSetStakingParamsCommand(ctx *RootContext) *cobra.Command {
+	var (
+		key   string
+		limit float64
+		edge  float64
+		opt   injectOptions
+	)
+
+	setCmd := &cobra.Command{
+		Use:   "set-staking-params",
+		Short: "Set parameters for external stakers",
+		Long: `Set parameters for external stakers. The limit is the maximum ratio of the external stake to the baker's own one,
+the edge is the fraction of the stakers' rewards kept by the baker. New parameters take effect after the activation delay.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if limit < 0 {
+				return fmt.Errorf("Invalid limit of staking over baking: %g", limit)
+			}
+			if edge < 0 || edge > 1 {
+				return fmt.Errorf("Invalid edge of baking over staking: %g", edge)
+			}
+
+			acc, err := ctx.account(key)
+			if err != nil {
+				return err
+			}
+			if err := ctx.requireDelegate(acc.Address); err != nil {
+				return err
+			}
+
+			value := michelson.NewPrim("Pair",
+				michelson.NewInt(big.NewInt(int64(math.Round(limit*stakingLimitScale)))),
+				michelson.NewPrim("Pair",
+					michelson.NewInt(big.NewInt(int64(math.Round(edge*stakingEdgeScale)))),
+					michelson.NewPrim("Unit")))
+
+			op := forge.Transaction{
+				Amount:      big.NewInt(0),
+				Destination: acc.Address,
+				Entrypoint:  "set_delegate_parameters",
+				Parameters:  value,
+			}
+			return ctx.sendAndPrint(acc, []forge.ManagerOperation{&op}, &opt)
+		},
+	}
+
+	f := setCmd.Flags()
+	f.StringVar(&key, "key", "", "Baker key: keystore alias or address")
+	f.Float64Var(&limit, "limit-of-staking-over-baking", 0, "Maximum ratio of the external stake to the baker's own stake")
+	f.Float64Var(&edge, "edge-of-baking-over-staking", 0, "Fraction of the stakers' rewards taken by the baker, from 0 to 1")
+	setCmd.MarkFlagRequired("key")
+	setCmd.MarkFlagRequired("limit-of-staking-over-baking")
+	setCmd.MarkFlagRequired("edge-of-baking-over-staking")
+	addInjectFlags(setCmd, &opt)
+
+	return setCmd
+}
+
+// requireDelegate returns an error if the address is not a registered delegate
+func (c *RootContext) requireDelegate(address string) error {
+	ok, err := c.isDelegate(address)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is not a registered delegate", address)
+	}
+	return nil
+}
+
+// getOptionalRPC is like getRPC but returns false if the entry doesn't exist in the protocol or the context
+func (c *RootContext) getOptionalRPC(path string, v interface{}) (bool, error) {
+	err := c.getRPC(path, v)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *RootContext) getBakerDeposits(address string) (*bakerDeposits, error) {
+	prefix := c.blockPath(c.blockID) + "/context/delegates/" + address
+
+	var balance tezos.BigInt
+	ok, err := c.getOptionalRPC(prefix+"/staking_balance", &balance)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is not a registered delegate", address)
+	}
+	d := bakerDeposits{
+		Delegate:            address,
+		StakingBalanceMutez: &balance.Int,
+	}
+
+	// Since Jakarta frozen_deposits is the amount at the beginning of the cycle
+	var frozen tezos.BigInt
+	if ok, err = c.getOptionalRPC(prefix+"/current_frozen_deposits", &frozen); err != nil {
+		return nil, err
+	}
+	if !ok {
+		if ok, err = c.getOptionalRPC(prefix+"/frozen_deposits", &frozen); err != nil {
+			return nil, err
+		}
+	}
+	if ok {
+		d.FrozenDepositsMutez = &frozen.Int
+	}
+
+	var limit *tezos.BigInt
+	if _, err = c.getOptionalRPC(prefix+"/frozen_deposits_limit", &limit); err != nil {
+		return nil, err
+	}
+	if limit != nil {
+		d.FrozenDepositsLimitMutez = &limit.Int
+
+		var constants depositsConstants
+		if err := c.getRPC(c.blockPath(c.blockID)+"/context/constants", &constants); err != nil {
+			return nil, err
+		}
+		if p := constants.FrozenDepositsPercentage; p != nil && *p > 0 {
+			d.CoveredStakeMutez = new(big.Int).Quo(new(big.Int).Mul(&limit.Int, big.NewInt(100)), big.NewInt(*p))
+			if balance.Cmp(d.CoveredStakeMutez) > 0 {
+				d.OverDelegationMutez = new(big.Int).Sub(&balance.Int, d.CoveredStakeMutez)
+			}
+		}
+	}
+
+	var params stakingParameters
+	if ok, err = c.getOptionalRPC(prefix+"/active_staking_parameters", &params); err != nil {
+		return nil, err
+	}
+	if ok {
+		d.StakingParameters = &params
+		if _, err = c.getOptionalRPC(prefix+"/pending_staking_parameters", &d.PendingStakingParameters); err != nil {
+			return nil, err
+		}
+	}
+
+	return &d, nil
+}
+
+func (c *RootContext) printBakerDeposits(d *bakerDeposits) {
+	fmt.Printf("Delegate:           %s\n", d.Delegate)
+	fmt.Printf("Staking balance:    %s\n", c.amountFormat.Format(d.StakingBalanceMutez))
+	if d.FrozenDepositsMutez != nil {
+		fmt.Printf("Frozen deposits:    %s\n", c.amountFormat.Format(d.FrozenDepositsMutez))
+	}
+	if d.FrozenDepositsLimitMutez != nil {
+		fmt.Printf("Deposits limit:     %s\n", c.amountFormat.Format(d.FrozenDepositsLimitMutez))
+	} else {
+		fmt.Printf("Deposits limit:     none\n")
+	}
+	if d.CoveredStakeMutez != nil {
+		fmt.Printf("Covered stake:      %s\n", c.amountFormat.Format(d.CoveredStakeMutez))
+	}
+	if d.OverDelegationMutez != nil {
+		fmt.Printf("Over-delegated by:  %s\n", c.amountFormat.Format(d.OverDelegationMutez))
+	}
+	if d.StakingParameters != nil {
+		fmt.Printf("Staking parameters: %s\n", d.StakingParameters)
+	}
+	for _, p := range d.PendingStakingParameters {
+		fmt.Printf("From cycle %-8d %s\n", p.Cycle, p.Parameters)
+	}
+}
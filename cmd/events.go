@@ -0,0 +1,345 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/michelson"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the text output of `events'
+var eventColumns = []utils.TableColumn{
+	{Header: "LEVEL", Width: 9, Align: utils.AlignRight},
+	{Header: "CONTRACT", Width: 36, MinWidth: 12},
+	{Header: "TAG", Width: 16},
+	{Header: "PAYLOAD", Width: 40},
+}
+
+type eventsOptions struct {
+	contracts []string
+	tags      []string
+	watch     bool
+	last      int
+	format    string
+	crawl     crawlOptions
+	windowOpt windowOptions
+}
+
+// contractEvent is an event emitted by a contract with its payload decoded according to the declared type
+type contractEvent struct {
+	Level     int             `json:"level" yaml:"level"`
+	Block     string          `json:"block" yaml:"block"`
+	Operation string          `json:"operation" yaml:"operation"`
+	Contract  string          `json:"contract" yaml:"contract"`
+	Tag       string          `json:"tag,omitempty" yaml:"tag,omitempty"`
+	Nonce     int             `json:"nonce" yaml:"nonce"`
+	Type      *michelson.Node `json:"type" yaml:"type"`
+	Payload   interface{}     `json:"payload" yaml:"payload"`
+}
+
+// eventOperation is the part of a manager operation holding emitted events
+type eventOperation struct {
+	Hash     string `json:"hash"`
+	Contents []struct {
+		Metadata struct {
+			InternalOperationResults []*internalEvent `json:"internal_operation_results"`
+		} `json:"metadata"`
+	} `json:"contents"`
+}
+
+type internalEvent struct {
+	Kind    string          `json:"kind"`
+	Source  string          `json:"source"`
+	Nonce   int             `json:"nonce"`
+	Type    *michelson.Node `json:"type"`
+	Tag     string          `json:"tag"`
+	Payload *michelson.Node `json:"payload"`
+	Result  *struct {
+		Status string `json:"status"`
+	} `json:"result"`
+}
+
+// NewEventsCommand returns new `events' command
+func NewEventsCommand(rootCtx *RootContext) *cobra.Command {
+	var opt eventsOptions
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	eventsCmd := &cobra.Command{
+		Use:   "events",
+		Short: "Show contract events",
+		Long: `Show events emitted by contracts (internal event operations) in the last N blocks up to the head (or --block),
+or stream them as new blocks arrive with --watch. With --since the blocks produced since then (up to --until) are scanned
+instead of the last N, and a watch ends at the first block produced after --until. Payloads are decoded using the declared event type: pairs with
+field annotations become objects, numbers are decimal strings and bytes are hex encoded.
+JSON output is a stream of events, one per line.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.showEvents(&opt)
+		},
+	}
+
+	f := eventsCmd.Flags()
+	f.StringSliceVar(&opt.contracts, "contract", nil, "Only show events of the contract (address or alias, may be repeated)")
+	f.StringSliceVar(&opt.tags, "tag", nil, "Only show events with the tag (may be repeated)")
+	f.BoolVar(&opt.watch, "watch", false, "Watch for new blocks and stream their events")
+	f.IntVar(&opt.last, "last", 100, "Number of blocks up to the head (or --block) to scan without --watch or --since")
+	addWindowFlags(f, &opt.windowOpt, "events of blocks produced")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, json]")
+	addCrawlFlags(eventsCmd, &opt.crawl, 8, "blocks")
+
+	return eventsCmd
+}
+
+// eventFilter matches events by the contract and the tag
+type eventFilter struct {
+	contracts map[string]bool
+	tags      map[string]bool
+}
+
+func (f *eventFilter) match(ev *internalEvent) bool {
+	return ev.Kind == "event" && ev.Result != nil && ev.Result.Status == "applied" &&
+		(f.contracts == nil || f.contracts[ev.Source]) &&
+		(f.tags == nil || f.tags[ev.Tag])
+}
+
+func (c *BlockCommandContext) newEventFilter(opt *eventsOptions) (*eventFilter, error) {
+	var f eventFilter
+	if len(opt.contracts) != 0 {
+		f.contracts = make(map[string]bool, len(opt.contracts))
+		for _, s := range opt.contracts {
+			address, err := c.contractAddress(s)
+			if err != nil {
+				return nil, err
+			}
+			f.contracts[address] = true
+		}
+	}
+	if len(opt.tags) != 0 {
+		f.tags = make(map[string]bool, len(opt.tags))
+		for _, t := range opt.tags {
+			f.tags[t] = true
+		}
+	}
+	return &f, nil
+}
+
+// blockEvents returns matching events of the block in the order of emission
+func (c *RootContext) blockEvents(hash string, level int, f *eventFilter) ([]*contractEvent, error) {
+	var ops []*eventOperation
+	if err := c.getRPC(fmt.Sprintf("%s/operations/%d", c.blockPath(hash), managerPass), &ops); err != nil {
+		return nil, err
+	}
+
+	var events []*contractEvent
+	for _, op := range ops {
+		for _, el := range op.Contents {
+			for _, ev := range el.Metadata.InternalOperationResults {
+				if !f.match(ev) {
+					continue
+				}
+				// Both are omitted for unit events
+				if ev.Type == nil {
+					ev.Type = michelson.NewPrim("unit")
+				}
+				if ev.Payload == nil {
+					ev.Payload = michelson.NewPrim("Unit")
+				}
+				e := contractEvent{
+					Level:     level,
+					Block:     hash,
+					Operation: op.Hash,
+					Contract:  ev.Source,
+					Tag:       ev.Tag,
+					Nonce:     ev.Nonce,
+					Type:      ev.Type,
+				}
+				payload, err := michelson.Decode(ev.Payload, ev.Type)
+				if err != nil {
+					// Keep the raw value rather than losing the event
+					log.WithError(err).WithField("operation", op.Hash).Warn("Can't decode the event payload")
+					payload = ev.Payload
+				}
+				e.Payload = payload
+				events = append(events, &e)
+			}
+		}
+	}
+	return events, nil
+}
+
+func (c *BlockCommandContext) showEvents(opt *eventsOptions) error {
+	if opt.format != "text" && opt.format != "json" {
+		return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+	}
+	f, err := c.newEventFilter(opt)
+	if err != nil {
+		return err
+	}
+
+	window, err := opt.windowOpt.window()
+	if err != nil {
+		return err
+	}
+
+	write := c.eventWriter(opt.format)
+	if !opt.watch {
+		return c.scanEvents(f, opt, window, write)
+	}
+
+	log.WithFields(log.Fields{
+		"contracts": opt.contracts,
+		"tags":      opt.tags,
+	}).Info("Watching contract events")
+
+	var monErr error
+	ch := make(chan *tezos.BlockInfo, 10)
+	go func() {
+		monErr = c.monitorHeads(ch)
+		close(ch)
+	}()
+
+	for bi := range ch {
+		if window.ended(bi.Timestamp) {
+			return nil
+		}
+		if !window.contains(bi.Timestamp) {
+			continue
+		}
+		events, err := c.blockEvents(bi.Hash, bi.Level, f)
+		if err != nil {
+			if err == context.Canceled {
+				break
+			}
+			return err
+		}
+		log.WithFields(log.Fields{
+			"block_level": bi.Level,
+			"events":      len(events),
+		}).Debug("Block scanned")
+		for _, e := range events {
+			if err := write(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	if monErr != nil && monErr != context.Canceled {
+		return monErr
+	}
+	return nil
+}
+
+// scanEvents writes events of the last blocks, or of the blocks produced in the window, in the chain order
+func (c *BlockCommandContext) scanEvents(f *eventFilter, opt *eventsOptions, window *timeWindow, write func(*contractEvent) error) error {
+	var (
+		levels []string
+		err    error
+	)
+	if window != nil && !window.since.IsZero() {
+		levels, err = c.expandBlockArgs([]string{window.blockRange(c.blockID)})
+	} else {
+		levels, err = c.windowArgs(opt.last)
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.primeCache(); err != nil {
+		return err
+	}
+
+	fetch := func(ctx context.Context, query string) (interface{}, error) {
+		var header struct {
+			Hash      string    `json:"hash"`
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if err := c.getRPC(c.blockPath(query)+"/header", &header); err != nil {
+			return nil, crawlError(err)
+		}
+		if !window.contains(header.Timestamp) {
+			return []*contractEvent(nil), nil
+		}
+		level, _ := strconv.Atoi(query)
+		events, err := c.blockEvents(header.Hash, level, f)
+		if err != nil {
+			return nil, crawlError(err)
+		}
+		return events, nil
+	}
+
+	var events []*contractEvent
+	err = c.newCrawler(&opt.crawl, "blocks").Run(c.context, levels, fetch, func(i int, query string, v interface{}) error {
+		events = append(events, v.([]*contractEvent)...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Level < events[j].Level })
+	for _, e := range events {
+		if err := write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventWriter returns a function writing events one by one so they can be streamed
+func (c *BlockCommandContext) eventWriter(format string) func(*contractEvent) error {
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		return func(e *contractEvent) error { return enc.Encode(e) }
+	}
+
+	table := utils.NewTable(os.Stdout, eventColumns, utils.TerminalWidth(os.Stdout))
+	var header bool
+	return func(e *contractEvent) error {
+		if !header {
+			if err := table.WriteHeader(); err != nil {
+				return err
+			}
+			header = true
+		}
+		payload, err := json.Marshal(e.Payload)
+		if err != nil {
+			return err
+		}
+		tag := e.Tag
+		if tag == "" {
+			tag = "-"
+		}
+		return table.WriteRow(strconv.Itoa(e.Level), e.Contract, tag, string(payload))
+	}
+}
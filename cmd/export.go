@@ -0,0 +1,300 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/crawler"
+	"github.com/ecadlabs/tez/objstore"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type objectStoreOptions struct {
+	bucket     string
+	endpoint   string
+	region     string
+	prefix     string
+	pathStyle  bool
+	from       int
+	to         int
+	crawl      crawlOptions
+	checkpoint string
+	overwrite  bool
+	format     string
+	compress   string
+	windowOpt  windowOptions
+}
+
+// NewExportCommand returns new `export' command
+func NewExportCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		opt       objectStoreOptions
+		exportCmd *cobra.Command // Forward declaration, see PersistentPreRunE below
+	)
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Bulk export of chain data",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if p := exportCmd.Parent(); p != nil {
+				if pr := p.PersistentPreRunE; pr != nil {
+					return pr(cmd, args)
+				}
+			}
+			return nil
+		},
+	}
+
+	objectStoreCmd := &cobra.Command{
+		Use:   "objectstore",
+		Short: "Export blocks as gzipped JSON or Parquet objects to S3-compatible storage",
+		Long: `Export blocks as gzipped JSON or Parquet objects to S3-compatible storage (AWS S3, Google Cloud Storage through its XML API, MinIO etc).
+With Parquet format each object holds the block's operations, one row per operation.
+Credentials are taken from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables, which may be keyring:<name> references to stored secrets.
+Objects already present in the bucket are skipped so an interrupted export can be resumed by running the same command again.
+With --checkpoint exported levels are also recorded in a local file and skipped without querying the bucket.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.exportObjects(&opt)
+		},
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	f := objectStoreCmd.Flags()
+	f.StringVar(&opt.bucket, "bucket", "", "Bucket name")
+	f.StringVar(&opt.endpoint, "endpoint", objstore.DefaultEndpoint, "Storage end-point URL, e.g. https://storage.googleapis.com for GCS")
+	f.StringVar(&opt.region, "region", region, "Storage region")
+	f.StringVar(&opt.prefix, "prefix", "blocks/", "Object key prefix")
+	f.BoolVar(&opt.pathStyle, "path-style", false, "Use path-style bucket addressing (required by some S3-compatible servers)")
+	f.IntVar(&opt.from, "from", 1, "First block level")
+	f.IntVar(&opt.to, "to", -1, "Last block level (head by default)")
+	f.StringVar(&opt.format, "format", "json", "Object format: one of [json, parquet]")
+	f.StringVar(&opt.compress, "compress", utils.CompressGzip, "Compression of JSON objects: one of [none, gzip, zstd]")
+	f.BoolVar(&opt.overwrite, "overwrite", false, "Replace existing objects instead of skipping them")
+	f.StringVar(&opt.checkpoint, "checkpoint", "", "File recording exported levels to resume an interrupted export from")
+	addWindowFlags(f, &opt.windowOpt, "blocks produced")
+	addCrawlFlags(objectStoreCmd, &opt.crawl, 4, "blocks")
+
+	exportCmd.AddCommand(objectStoreCmd)
+
+	return exportCmd
+}
+
+// objectKey returns the object name for the block level. Levels are zero padded so keys sort in chain order
+func objectKey(prefix string, level int, ext string) string {
+	return fmt.Sprintf("%s%010d%s", prefix, level, ext)
+}
+
+func compressedJSON(v interface{}, algorithm string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := utils.NewCompressWriter(&buf, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if zw == nil {
+		err = json.NewEncoder(&buf).Encode(v)
+		return buf.Bytes(), err
+	}
+	if err := json.NewEncoder(zw).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Content types of compressed JSON objects
+var jsonContentTypes = map[string]string{
+	utils.CompressNone: "application/json",
+	utils.CompressGzip: "application/gzip",
+	utils.CompressZstd: "application/zstd",
+}
+
+func parquetOps(block *xblock) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := utils.GetEncoderFunc("parquet")(&buf)
+	err := enc.Encode(newOpRows(getBlockOperations(getBlockInfo(block), nil)))
+	closeEncoder(enc, &err)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *BlockCommandContext) exportObjects(opt *objectStoreOptions) error {
+	if opt.bucket == "" {
+		return errors.New("Bucket name is required")
+	}
+
+	var (
+		ext, contentType string
+		marshal          func(block *xblock) ([]byte, error)
+	)
+	switch opt.format {
+	case "json":
+		var ok bool
+		if contentType, ok = jsonContentTypes[opt.compress]; !ok {
+			return fmt.Errorf("Unknown compression algorithm: `%s'", opt.compress)
+		}
+		ext = ".json" + utils.CompressionExt(opt.compress)
+		marshal = func(block *xblock) ([]byte, error) { return compressedJSON(block.output(), opt.compress) }
+	case "parquet":
+		if opt.compress != utils.CompressGzip {
+			return errors.New("--compress only applies to JSON objects, Parquet pages are always compressed")
+		}
+		ext, contentType = ".parquet", "application/vnd.apache.parquet"
+		marshal = parquetOps
+	default:
+		return fmt.Errorf("Unknown object format: `%s'", opt.format)
+	}
+
+	cred, err := objstore.EnvCredentials()
+	if err != nil {
+		return err
+	}
+	for _, v := range []*string{&cred.AccessKeyID, &cred.SecretAccessKey, &cred.SessionToken} {
+		if *v, err = c.resolveSecret(*v); err != nil {
+			return err
+		}
+	}
+
+	bucket := objstore.Bucket{
+		Endpoint:    opt.endpoint,
+		Region:      opt.region,
+		Name:        opt.bucket,
+		Credentials: cred,
+		PathStyle:   opt.pathStyle,
+	}
+
+	window, err := opt.windowOpt.window()
+	if err != nil {
+		return err
+	}
+
+	from, to := opt.from, opt.to
+	if to < 0 {
+		head, err := c.service.GetBlock(c.context, c.chainID, "head")
+		if err != nil {
+			return err
+		}
+		to = head.Header.Level
+	}
+	if from, to, err = c.windowLevels(window, from, to); err != nil {
+		return err
+	}
+	if from > to {
+		return fmt.Errorf("Invalid level range: %d..%d", from, to)
+	}
+
+	var checkpoint *crawler.Checkpoint
+	if opt.checkpoint != "" {
+		path, err := utils.ExpandHome(opt.checkpoint)
+		if err != nil {
+			return err
+		}
+		if checkpoint, err = crawler.OpenCheckpoint(path); err != nil {
+			return err
+		}
+		defer checkpoint.Close()
+	}
+
+	if err := c.primeCache(); err != nil {
+		return err
+	}
+
+	var exported, skipped int
+
+	// The result tells if the object was written
+	export := func(ctx context.Context, query string) (interface{}, error) {
+		level, _ := strconv.Atoi(query)
+		key := objectKey(opt.prefix, level, ext)
+		if !opt.overwrite {
+			ok, err := bucket.Exists(ctx, key)
+			if err != nil || ok {
+				return false, err
+			}
+		}
+
+		block, err := c.getBlock(query, false)
+		if err != nil {
+			return nil, crawlError(err)
+		}
+		if !window.contains(block.Header.Timestamp) {
+			return false, nil
+		}
+
+		data, err := marshal(block)
+		if err != nil {
+			return nil, crawler.Permanent(err)
+		}
+
+		if err := bucket.Put(ctx, key, data, contentType); err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{"level": level, "key": key, "size": len(data)}).Debug("Block exported")
+		return true, nil
+	}
+
+	levels := make([]string, 0, to-from+1)
+	for level := from; level <= to; level++ {
+		levels = append(levels, strconv.Itoa(level))
+	}
+
+	cr := c.newCrawler(&opt.crawl, "blocks")
+	cr.Checkpoint = checkpoint
+	err = cr.Run(c.context, levels, export, func(i int, query string, v interface{}) error {
+		if v.(bool) {
+			exported++
+		} else {
+			skipped++
+		}
+		return nil
+	})
+
+	fields := log.Fields{
+		"exported": exported,
+		"skipped":  skipped,
+	}
+	if checkpoint != nil {
+		fields["checkpoint"] = checkpoint.Len()
+	}
+	log.WithFields(fields).Info("Export finished")
+
+	return err
+}
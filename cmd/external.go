@@ -0,0 +1,305 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+type externalOptions struct {
+	signedBytes    string
+	jsonFile       string
+	signature      string
+	idempotencyKey string
+	skipChecks     bool
+	autoRebranch   bool
+	wait           bool
+	timeout        time.Duration
+}
+
+// NewInjectCommand returns new `inject' command
+func NewInjectCommand(rootCtx *RootContext) *cobra.Command {
+	var opt externalOptions
+
+	injectCmd := &cobra.Command{
+		Use:         "inject",
+		Short:       "Inject externally built operations",
+		Annotations: map[string]string{headAnnotation: ""},
+	}
+
+	operationCmd := &cobra.Command{
+		Use:   "operation",
+		Short: "Inject an operation signed elsewhere",
+		Long: `Inject an operation built and signed by another tool (a wallet, an SDK or an HSM ceremony).
+Either pass the signed bytes with --signed-bytes, or the unsigned operation JSON ({"branch", "contents"}) with --json
+and its signature with --signature. The JSON is forged by the node and the signature is checked against the source's
+revealed public key. Before the injection the branch age and counters are checked and the operation is preapplied
+unless --skip-checks is given. With --auto-rebranch an operation whose branch has expired meanwhile is re-forged with
+a fresh branch and the new signature is prompted for. Injections are recorded in the injection journal like the ones made by this tool.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.injectExternal(&opt)
+		},
+	}
+
+	f := operationCmd.Flags()
+	f.StringVar(&opt.signedBytes, "signed-bytes", "", "Hex encoded signed operation, - to read it from stdin or @path to read it from a file")
+	f.StringVar(&opt.jsonFile, "json", "", "Unsigned operation JSON, - to read it from stdin or @path to read it from a file")
+	f.StringVar(&opt.signature, "signature", "", "Signature of the operation given with --json (default is its signature field)")
+	f.StringVar(&opt.idempotencyKey, "idempotency-key", "", "Skip the injection if an operation with the same key is already in the injection journal (default is the operation hash)")
+	f.BoolVar(&opt.skipChecks, "skip-checks", false, "Don't check the branch age and counters and don't preapply the operation before the injection")
+	f.BoolVar(&opt.autoRebranch, "auto-rebranch", false, "Re-forge the operation with a fresh branch and prompt for the new signature if the branch is too old")
+	f.BoolVar(&opt.wait, "wait", false, "Wait for the operation inclusion")
+	f.DurationVar(&opt.timeout, "timeout", defaultInclusionTimeout, "Time to wait for the operation inclusion")
+
+	injectCmd.AddCommand(operationCmd)
+
+	return injectCmd
+}
+
+func (c *RootContext) injectExternal(opt *externalOptions) error {
+	if (opt.signedBytes == "") == (opt.jsonFile == "") {
+		return errors.New("Exactly one of --signed-bytes and --json is required")
+	}
+	if opt.autoRebranch && opt.skipChecks {
+		return errors.New("--auto-rebranch relies on the checks disabled by --skip-checks")
+	}
+	if err := c.verifyChainID(); err != nil {
+		return err
+	}
+
+	var (
+		entry  journalEntry
+		signed []byte
+		op     *signedOperation
+	)
+	if opt.signedBytes != "" {
+		if opt.signature != "" {
+			return errors.New("--signature is used with --json only")
+		}
+		src, err := utils.ReadInputString(opt.signedBytes)
+		if err != nil {
+			return err
+		}
+		if signed, err = hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(src), "0x")); err != nil {
+			return fmt.Errorf("Invalid signed operation bytes: %v", err)
+		}
+		if !opt.skipChecks {
+			if op, err = c.parseOperation(signed); err != nil {
+				return err
+			}
+			if err := operationSummary(op.Contents, &entry); err != nil {
+				return err
+			}
+		}
+	} else {
+		var err error
+		if signed, op, err = c.forgeExternal(opt, &entry); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; !opt.skipChecks; attempt++ {
+		err := c.checkOperation(op)
+		if _, ok := err.(*branchExpiredError); ok && opt.autoRebranch && attempt < maxRebranchAttempts {
+			log.WithError(err).Warn("Re-forging the operation with a fresh branch")
+			if signed, err = c.rebranchExternal(op, entry.Source); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+
+	var chainID string
+	if err := c.getRPC("/chains/"+c.chainID+"/chain_id", &chainID); err != nil {
+		return err
+	}
+	j, err := c.journal()
+	if err != nil {
+		return err
+	}
+	opHash, err := operationHash(signed)
+	if err != nil {
+		return err
+	}
+
+	entry.ChainID = chainID
+	entry.Key = opt.idempotencyKey
+	if entry.Key == "" {
+		entry.Key = "operation:" + opHash
+	}
+
+	hash := opHash
+	if e := j.lookup(entry.Key, chainID); e != nil {
+		hash = skipInjected(e)
+	} else {
+		if hash, err = c.injectRecorded(j, &entry, signed); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"hash":   hash,
+			"source": entry.Source,
+		}).Info("Operation injected")
+	}
+	fmt.Println(hash)
+
+	if opt.wait {
+		if _, err := c.waitForOperation(hash, opt.timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// operationSummary fills the journal entry's source and kinds from the operation contents
+func operationSummary(contents []json.RawMessage, entry *journalEntry) error {
+	for _, raw := range contents {
+		var el struct {
+			Kind   string `json:"kind"`
+			Source string `json:"source"`
+		}
+		if err := json.Unmarshal(raw, &el); err != nil {
+			return err
+		}
+		entry.Kinds = append(entry.Kinds, el.Kind)
+		if entry.Source == "" {
+			entry.Source = el.Source
+		}
+	}
+	return nil
+}
+
+// forgeExternal forges the operation JSON using the node, checks the signature and returns the signed bytes
+func (c *RootContext) forgeExternal(opt *externalOptions, entry *journalEntry) ([]byte, *signedOperation, error) {
+	buf, err := utils.ReadInput(opt.jsonFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var op signedOperation
+	if err := json.Unmarshal(buf, &op); err != nil {
+		return nil, nil, fmt.Errorf("Invalid operation JSON: %v", err)
+	}
+	if op.Branch == "" || len(op.Contents) == 0 {
+		return nil, nil, errors.New("Operation JSON must contain the branch and contents")
+	}
+
+	if opt.signature != "" {
+		op.Signature = opt.signature
+	}
+	if op.Signature == "" {
+		return nil, nil, errors.New("Signature is required: use --signature")
+	}
+	if err := operationSummary(op.Contents, entry); err != nil {
+		return nil, nil, err
+	}
+
+	signed, err := c.forgeSigned(&op, entry.Source)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signed, &op, nil
+}
+
+// forgeSigned forges the operation using the node, checks its signature against the source's public key
+// and returns the signed bytes
+func (c *RootContext) forgeSigned(op *signedOperation, source string) ([]byte, error) {
+	sig, err := keys.ParseSignature(op.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	req := signedOperation{Branch: op.Branch, Contents: op.Contents}
+	var forgedHex string
+	if err := c.postRPC(c.blockPath("head")+"/helpers/forge/operations", &req, &forgedHex); err != nil {
+		return nil, explainRPCError(err)
+	}
+	forged, err := hex.DecodeString(forgedHex)
+	if err != nil {
+		return nil, err
+	}
+
+	if source != "" {
+		var pk string
+		ok, err := c.getOptionalRPC(c.blockPath("head")+"/context/contracts/"+source+"/manager_key", &pk)
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok || pk == "":
+			log.WithField("source", source).Warn("Source is not revealed, can't check the signature")
+		default:
+			pub, err := keys.ParsePublicKey(pk)
+			if err != nil {
+				log.WithError(err).WithField("source", source).Warn("Can't check the signature")
+			} else if !keys.Verify(pub, keys.WatermarkGenericOperation, forged, sig) {
+				return nil, fmt.Errorf("Signature doesn't match the operation forged by the node and the public key of %s", source)
+			}
+		}
+	}
+
+	return append(forged, sig.Bytes...), nil
+}
+
+// rebranchExternal replaces the operation's branch with the current head and prompts for the signature of the new bytes.
+// All other fields are kept
+func (c *RootContext) rebranchExternal(op *signedOperation, source string) ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("Can't prompt for the new signature in a non-interactive session")
+	}
+	if err := c.getRPC(c.blockPath("head")+"/hash", &op.Branch); err != nil {
+		return nil, err
+	}
+
+	req := signedOperation{Branch: op.Branch, Contents: op.Contents}
+	var forgedHex string
+	if err := c.postRPC(c.blockPath("head")+"/helpers/forge/operations", &req, &forgedHex); err != nil {
+		return nil, explainRPCError(err)
+	}
+	fmt.Fprintf(os.Stderr, "Operation re-forged with branch %s, sign these bytes with the generic operation watermark (0x%02x):\n%s\n",
+		op.Branch, keys.WatermarkGenericOperation, forgedHex)
+
+	fmt.Fprint(os.Stderr, "Signature: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if op.Signature = strings.TrimSpace(line); op.Signature == "" {
+		return nil, errors.New("Signature is required")
+	}
+	return c.forgeSigned(op, source)
+}
@@ -0,0 +1,222 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// FaucetTokenEnv is the environment variable holding the captcha token
+const FaucetTokenEnv = "TEZ_FAUCET_TOKEN"
+
+type faucetInfo struct {
+	FaucetAddress     string `json:"faucetAddress"`
+	CaptchaEnabled    bool   `json:"captchaEnabled"`
+	ChallengesEnabled bool   `json:"challengesEnabled"`
+	MinTez            int    `json:"minTez"`
+	MaxTez            int    `json:"maxTez"`
+}
+
+// Either the next challenge or the transaction hash is returned
+type faucetResponse struct {
+	Challenge        string `json:"challenge"`
+	ChallengeCounter int    `json:"challengeCounter"`
+	ChallengesNeeded int    `json:"challengesNeeded"`
+	Difficulty       int    `json:"difficulty"`
+	TxHash           string `json:"txHash"`
+	Message          string `json:"message"`
+}
+
+type faucetClient struct {
+	url     string
+	context context.Context
+}
+
+func (f *faucetClient) do(method, path string, body, v interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(f.url, "/")+path, &buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(f.context)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		var msg faucetResponse
+		if json.NewDecoder(res.Body).Decode(&msg) == nil && msg.Message != "" {
+			return fmt.Errorf("Faucet: %s", msg.Message)
+		}
+		return fmt.Errorf("Faucet: %s", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// solveChallenge finds the nonce giving the SHA-256 hash with the required number of leading zero hex digits
+func solveChallenge(ctx context.Context, challenge string, difficulty int) (string, int, error) {
+	prefix := strings.Repeat("0", difficulty)
+	for nonce := 0; ; nonce++ {
+		if nonce%100000 == 0 && ctx.Err() != nil {
+			return "", 0, ctx.Err()
+		}
+		sum := sha256.Sum256([]byte(challenge + ":" + strconv.Itoa(nonce)))
+		if s := hex.EncodeToString(sum[:]); strings.HasPrefix(s, prefix) {
+			return s, nonce, nil
+		}
+	}
+}
+
+// NewFaucetCommand returns new `faucet' command
+func NewFaucetCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		faucetURL string
+		amount    int
+		token     string
+		timeout   time.Duration
+	)
+
+	faucetCmd := &cobra.Command{
+		Use:         "faucet <address>",
+		Short:       "Request test tokens from a testnet faucet",
+		Annotations: map[string]string{headAnnotation: ""},
+		Long: `Request test tokens from a testnet faucet and wait until the balance is credited.
+The address can be a keystore alias. Proof of work challenges are solved locally, if the faucet requires a captcha
+pass its token with --captcha-token or ` + FaucetTokenEnv + `. The faucet is selected by --network.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := rootCtx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			if faucetURL == "" {
+				if faucetURL = networks[rootCtx.network].FaucetURL; faucetURL == "" {
+					return fmt.Errorf("No faucet is known for network `%s', use --faucet-url", rootCtx.network)
+				}
+			}
+			if token == "" {
+				token = os.Getenv(FaucetTokenEnv)
+			}
+			return rootCtx.requestFaucet(&faucetClient{url: faucetURL, context: rootCtx.context}, address, amount, token, timeout)
+		},
+	}
+
+	f := faucetCmd.Flags()
+	f.StringVar(&faucetURL, "faucet-url", "", "Faucet back-end URL (overrides --network)")
+	f.IntVar(&amount, "amount", 0, "Amount in tez (default is the faucet's maximum)")
+	f.StringVar(&token, "captcha-token", "", "Captcha token for faucets which require it")
+	f.DurationVar(&timeout, "timeout", defaultInclusionTimeout, "Time to wait for the balance to be credited")
+
+	return faucetCmd
+}
+
+func (c *RootContext) requestFaucet(fc *faucetClient, address string, amount int, token string, timeout time.Duration) error {
+	var info faucetInfo
+	if err := fc.do(http.MethodGet, "/info", nil, &info); err != nil {
+		return err
+	}
+	if info.CaptchaEnabled && token == "" {
+		return errors.New("The faucet requires a captcha token, solve the captcha in a browser and pass the token with --captcha-token")
+	}
+	if amount == 0 {
+		amount = info.MaxTez
+	}
+	if amount < info.MinTez || info.MaxTez != 0 && amount > info.MaxTez {
+		return fmt.Errorf("Amount must be between %d and %d tez", info.MinTez, info.MaxTez)
+	}
+
+	before, err := c.contractBalance("head", address)
+	if err != nil {
+		return err
+	}
+
+	req := map[string]interface{}{
+		"address":      address,
+		"amount":       amount,
+		"captchaToken": token,
+	}
+	var res faucetResponse
+	if err := fc.do(http.MethodPost, "/challenge", req, &res); err != nil {
+		return err
+	}
+	for res.TxHash == "" {
+		if res.Challenge == "" {
+			return errors.New("Faucet: neither a challenge nor a transaction hash returned")
+		}
+		log.WithFields(log.Fields{
+			"challenge":  res.ChallengeCounter,
+			"total":      res.ChallengesNeeded,
+			"difficulty": res.Difficulty,
+		}).Info("Solving faucet challenge")
+
+		solution, nonce, err := solveChallenge(c.context, res.Challenge, res.Difficulty)
+		if err != nil {
+			return err
+		}
+		req["solution"] = solution
+		req["nonce"] = nonce
+		res = faucetResponse{}
+		if err := fc.do(http.MethodPost, "/verify", req, &res); err != nil {
+			return err
+		}
+	}
+	fmt.Println(res.TxHash)
+
+	if _, err := c.waitForOperation(res.TxHash, timeout); err != nil {
+		return err
+	}
+
+	after, err := c.contractBalance("head", address)
+	if err != nil {
+		return err
+	}
+	if after.Cmp(before) <= 0 {
+		return fmt.Errorf("The balance of %s wasn't credited", address)
+	}
+	fmt.Printf("Balance: %s\n", c.amountFormat.Format(after))
+
+	return nil
+}
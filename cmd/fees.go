@@ -0,0 +1,402 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/michelson"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Number of recent blocks used by --fee auto:<priority>
+const feeMarketWindow = 20
+
+// Manager operations validation pass
+const managerPass = 3
+
+// Inclusion priorities and percentiles of the fee ratio they target
+var feePriorities = map[string]float64{
+	"slow":   0.25,
+	"normal": 0.5,
+	"fast":   0.9,
+}
+
+// Columns of the fee percentiles table
+var feeColumns = []utils.TableColumn{
+	{Header: "METRIC", Width: 24},
+	{Header: "P10", Width: 9, Align: utils.AlignRight},
+	{Header: "P25", Width: 9, Align: utils.AlignRight},
+	{Header: "P50", Width: 9, Align: utils.AlignRight},
+	{Header: "P75", Width: 9, Align: utils.AlignRight},
+	{Header: "P90", Width: 9, Align: utils.AlignRight},
+}
+
+type feesOptions struct {
+	last   int
+	crawl  crawlOptions
+	format string
+}
+
+type feePercentiles struct {
+	P10 float64 `json:"p10" yaml:"p10"`
+	P25 float64 `json:"p25" yaml:"p25"`
+	P50 float64 `json:"p50" yaml:"p50"`
+	P75 float64 `json:"p75" yaml:"p75"`
+	P90 float64 `json:"p90" yaml:"p90"`
+}
+
+// Suggested fees are multiples of the minimal fee accepted by the mempool
+type suggestedFees struct {
+	Slow   float64 `json:"slow" yaml:"slow"`
+	Normal float64 `json:"normal" yaml:"normal"`
+	Fast   float64 `json:"fast" yaml:"fast"`
+}
+
+type feeMarket struct {
+	FirstLevel int `json:"first_level" yaml:"first_level"`
+	LastLevel  int `json:"last_level" yaml:"last_level"`
+	Operations int `json:"operations" yaml:"operations"`
+	// Operations containing only kinds the client can forge to get their size
+	SizedOperations int `json:"sized_operations" yaml:"sized_operations"`
+	// Mutez per gas unit and per byte
+	FeePerGas  *feePercentiles `json:"fee_per_gas" yaml:"fee_per_gas"`
+	FeePerByte *feePercentiles `json:"fee_per_byte" yaml:"fee_per_byte"`
+	// Fee over the minimal fee
+	FeeRatio  *feePercentiles `json:"fee_ratio" yaml:"fee_ratio"`
+	Suggested *suggestedFees  `json:"suggested_ratio" yaml:"suggested_ratio"`
+	// Sorted fee ratios
+	ratios []float64
+}
+
+// feeContent holds the fields of manager operation contents the client can forge
+type feeContent struct {
+	simulatedContent
+	Source       string        `json:"source"`
+	Fee          *tezos.BigInt `json:"fee"`
+	Counter      *tezos.BigInt `json:"counter"`
+	GasLimit     *tezos.BigInt `json:"gas_limit"`
+	StorageLimit *tezos.BigInt `json:"storage_limit"`
+	Amount       *tezos.BigInt `json:"amount"`
+	Destination  string        `json:"destination"`
+	Parameters   *struct {
+		Entrypoint string          `json:"entrypoint"`
+		Value      *michelson.Node `json:"value"`
+	} `json:"parameters"`
+	PublicKey string `json:"public_key"`
+	Delegate  string `json:"delegate"`
+}
+
+type feeOperation struct {
+	Hash     string        `json:"hash"`
+	Contents []*feeContent `json:"contents"`
+}
+
+// forgeOperation returns nil if the kind can't be forged by the client
+func (f *feeContent) forgeOperation() forge.Operation {
+	if f.Fee == nil || f.Counter == nil || f.GasLimit == nil || f.StorageLimit == nil {
+		return nil
+	}
+	m := forge.Manager{
+		Source:       f.Source,
+		Fee:          &f.Fee.Int,
+		Counter:      &f.Counter.Int,
+		GasLimit:     &f.GasLimit.Int,
+		StorageLimit: &f.StorageLimit.Int,
+	}
+	switch f.Kind {
+	case protocol.KindReveal:
+		return &forge.Reveal{Manager: m, PublicKey: f.PublicKey}
+	case protocol.KindDelegation:
+		return &forge.Delegation{Manager: m, Delegate: f.Delegate}
+	case protocol.KindTransaction:
+		if f.Amount == nil {
+			return nil
+		}
+		t := forge.Transaction{Manager: m, Amount: &f.Amount.Int, Destination: f.Destination}
+		if f.Parameters != nil {
+			t.Entrypoint, t.Parameters = f.Parameters.Entrypoint, f.Parameters.Value
+		}
+		return &t
+	}
+	return nil
+}
+
+// minimalFee returns the minimal fee accepted by the mempool for the gas and size
+func minimalFee(gas *big.Int, size int) *big.Int {
+	var fee, tmp big.Int
+	fee.Mul(gas, big.NewInt(minimalNanotezPerGas))
+	fee.Add(&fee, tmp.SetInt64(int64(size)*minimalNanotezPerByte))
+	fee.Add(&fee, tmp.SetInt64(minimalFeeMutez*1000+999))
+	return fee.Quo(&fee, big.NewInt(1000))
+}
+
+func bigRatio(x, y *big.Int) float64 {
+	if y.Sign() == 0 {
+		return 0
+	}
+	var a, b big.Float
+	a.SetInt(x)
+	b.SetInt(y)
+	f, _ := a.Quo(&a, &b).Float64()
+	return f
+}
+
+// floatPercentile returns the nearest rank percentile of the sorted values
+func floatPercentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.999999) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func newFeePercentiles(values []float64) *feePercentiles {
+	sort.Float64s(values)
+	return &feePercentiles{
+		P10: floatPercentile(values, 0.1),
+		P25: floatPercentile(values, 0.25),
+		P50: floatPercentile(values, 0.5),
+		P75: floatPercentile(values, 0.75),
+		P90: floatPercentile(values, 0.9),
+	}
+}
+
+func newFeesCommand(ctx *BlockCommandContext) *cobra.Command {
+	var opt feesOptions
+
+	feesCmd := &cobra.Command{
+		Use:   "fees",
+		Short: "Fee market over recent blocks",
+		Long: `Percentiles of fee per gas unit, fee per byte and the fee over the minimal one accepted by the mempool for manager
+operations included in the last N blocks. The size is known for operations containing only reveals, transactions and
+delegations. Suggested fees for slow, normal and fast inclusion are multiples of the minimal fee taken at the 25th,
+50th and 90th percentiles of the ratio; operation commands use them with --fee auto:slow|normal|fast.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEncoder utils.NewEncoderFunc
+			if opt.format != "text" {
+				if newEncoder = ctx.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+					return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+				}
+			}
+
+			args, err := ctx.windowArgs(opt.last)
+			if err != nil {
+				return err
+			}
+			m, err := ctx.feeMarket(args, &opt.crawl)
+			if err != nil {
+				return err
+			}
+			if newEncoder != nil {
+				return newEncoder(os.Stdout).Encode(m)
+			}
+			return writeFeeMarket(os.Stdout, m)
+		},
+	}
+
+	f := feesCmd.Flags()
+	f.IntVar(&opt.last, "last", 100, "Number of blocks up to the head (or --block) to scan")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	addCrawlFlags(feesCmd, &opt.crawl, 8, "blocks")
+
+	return feesCmd
+}
+
+// feeMarket collects fee percentiles of manager operations included in the blocks
+func (c *RootContext) feeMarket(levels []string, opt *crawlOptions) (*feeMarket, error) {
+	if err := c.primeCache(); err != nil {
+		return nil, err
+	}
+
+	fetch := func(ctx context.Context, query string) (interface{}, error) {
+		var ops []*feeOperation
+		if err := c.getRPC(fmt.Sprintf("%s/operations/%d", c.blockPath(query), managerPass), &ops); err != nil {
+			return nil, crawlError(err)
+		}
+		return ops, nil
+	}
+
+	m := feeMarket{
+		FirstLevel: math.MaxInt32,
+	}
+	var perGas, perByte, ratio []float64
+	err := c.newCrawler(opt, "blocks").Run(c.context, levels, fetch, func(i int, query string, v interface{}) error {
+		level, err := strconv.Atoi(query)
+		if err != nil {
+			return err
+		}
+		if level < m.FirstLevel {
+			m.FirstLevel = level
+		}
+		if level > m.LastLevel {
+			m.LastLevel = level
+		}
+
+		for _, op := range v.([]*feeOperation) {
+			var (
+				fee, gas = new(big.Int), new(big.Int)
+				size     = branchSize + signatureSize
+				sized    = true
+			)
+			for _, el := range op.Contents {
+				if el.Fee != nil {
+					fee.Add(fee, &el.Fee.Int)
+				}
+				if r := el.Metadata.OperationResult; r != nil {
+					gas.Add(gas, r.gas())
+				}
+				for _, ir := range el.Metadata.InternalOperationResults {
+					if ir.Result != nil {
+						gas.Add(gas, ir.Result.gas())
+					}
+				}
+
+				fo := el.forgeOperation()
+				if fo == nil {
+					sized = false
+					continue
+				}
+				n, err := forge.Size(fo)
+				if err != nil {
+					log.WithError(err).WithField("operation", op.Hash).Debug("Can't forge the operation")
+					sized = false
+					continue
+				}
+				size += n
+			}
+
+			m.Operations++
+			if gas.Sign() != 0 {
+				perGas = append(perGas, bigRatio(fee, gas))
+			}
+			if sized {
+				m.SizedOperations++
+				perByte = append(perByte, bigRatio(fee, big.NewInt(int64(size))))
+				ratio = append(ratio, bigRatio(fee, minimalFee(gas, size)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m.Operations == 0 {
+		m.FirstLevel = 0
+	}
+
+	m.FeePerGas = newFeePercentiles(perGas)
+	m.FeePerByte = newFeePercentiles(perByte)
+	m.FeeRatio = newFeePercentiles(ratio)
+	m.ratios = ratio
+	m.Suggested = &suggestedFees{
+		Slow:   suggestedRatio(ratio, feePriorities["slow"]),
+		Normal: suggestedRatio(ratio, feePriorities["normal"]),
+		Fast:   suggestedRatio(ratio, feePriorities["fast"]),
+	}
+	return &m, nil
+}
+
+// suggestedRatio never goes below the minimal fee
+func suggestedRatio(sorted []float64, p float64) float64 {
+	r := floatPercentile(sorted, p)
+	if r < 1 {
+		return 1
+	}
+	// Round up to a permille so the fee doesn't end up just below the observed one
+	return math.Ceil(r*1000) / 1000
+}
+
+// feeRatio returns the multiple of the minimal fee for the inclusion priority based on recent blocks
+func (c *RootContext) feeRatio(priority string) (float64, error) {
+	p, ok := feePriorities[priority]
+	if !ok {
+		return 0, fmt.Errorf("Unknown fee priority: `%s'", priority)
+	}
+	bc := BlockCommandContext{RootContext: c}
+	args, err := bc.windowArgs(feeMarketWindow)
+	if err != nil {
+		return 0, err
+	}
+	m, err := c.feeMarket(args, &crawlOptions{concurrency: 8})
+	if err != nil {
+		return 0, err
+	}
+	return suggestedRatio(m.ratios, p), nil
+}
+
+// scaleFee multiplies the fee by the ratio rounding up
+func scaleFee(fee *big.Int, ratio float64) *big.Int {
+	if ratio <= 1 {
+		return fee
+	}
+	v := new(big.Int).Mul(fee, big.NewInt(int64(math.Ceil(ratio*1000))))
+	v.Add(v, big.NewInt(999))
+	return v.Quo(v, big.NewInt(1000))
+}
+
+func writeFeeMarket(w io.Writer, m *feeMarket) error {
+	_, err := fmt.Fprintf(w, "Levels:      %d..%d\nOperations:  %d (%d with known size)\n\n", m.FirstLevel, m.LastLevel, m.Operations, m.SizedOperations)
+	if err != nil {
+		return err
+	}
+
+	table := utils.NewTable(w, feeColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, r := range []struct {
+		name string
+		p    *feePercentiles
+		f    string
+	}{
+		{"Fee per gas, mutez", m.FeePerGas, "%.4f"},
+		{"Fee per byte, mutez", m.FeePerByte, "%.4f"},
+		{"Fee over minimal", m.FeeRatio, "%.3f"},
+	} {
+		row := []string{r.name}
+		for _, v := range []float64{r.p.P10, r.p.P25, r.p.P50, r.p.P75, r.p.P90} {
+			row = append(row, fmt.Sprintf(r.f, v))
+		}
+		if err := table.WriteRow(row...); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "\nSuggested fee: slow x%.3f, normal x%.3f, fast x%.3f of the minimal fee\n", m.Suggested.Slow, m.Suggested.Normal, m.Suggested.Fast)
+	return err
+}
@@ -0,0 +1,164 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ecadlabs/tez/filter"
+)
+
+// newEventFilter compiles the --filter expression. Aliases are given as `name=address' pairs and are available
+// through alias() function. All identifiers must be known to env
+func newEventFilter(src string, aliases []string, env filter.Env) (*filter.Expr, error) {
+	names := make(map[string]string, len(aliases))
+	for _, a := range aliases {
+		i := strings.IndexByte(a, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid alias: `%s' (name=address expected)", a)
+		}
+		names[a[:i]] = a[i+1:]
+	}
+
+	funcs := map[string]filter.Func{
+		"alias": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("alias() takes exactly one argument")
+			}
+			name, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("alias() expects a string")
+			}
+			addr, ok := names[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown alias: `%s'", name)
+			}
+			return addr, nil
+		},
+	}
+
+	expr, err := filter.Compile(src, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range expr.Identifiers() {
+		if _, ok := env[id]; !ok {
+			vars := make([]string, 0, len(env))
+			for k := range env {
+				vars = append(vars, k)
+			}
+			sort.Strings(vars)
+			return nil, fmt.Errorf("Unknown filter field: `%s' (one of [%s] expected)", id, strings.Join(vars, ", "))
+		}
+	}
+
+	return expr, nil
+}
+
+// blockEnv exposes block fields to the filter expression
+func blockEnv(b *xblockInfo) filter.Env {
+	env := filter.Env{
+		"hash":        nil,
+		"level":       nil,
+		"cycle":       nil,
+		"predecessor": nil,
+		"timestamp":   nil,
+		"protocol":    nil,
+		"baker":       nil,
+		"volume":      nil,
+		"fees":        nil,
+		"operations":  nil,
+	}
+	if b == nil {
+		return env
+	}
+
+	env["hash"] = b.Hash
+	env["level"] = b.Header.Level
+	env["cycle"] = b.Metadata.Level.Cycle
+	env["predecessor"] = b.Header.Predecessor
+	env["timestamp"] = b.Header.Timestamp
+	env["protocol"] = b.Protocol
+	env["baker"] = b.Metadata.Baker
+	env["volume"] = b.Volume
+	env["fees"] = b.Fees
+	env["operations"] = b.OperationsNum
+
+	return env
+}
+
+// opEnv exposes operation fields to the filter expression. Amounts are in tez
+func opEnv(op *opInfo) filter.Env {
+	env := filter.Env{
+		"kind":         nil,
+		"hash":         nil,
+		"source":       nil,
+		"destination":  nil,
+		"amount":       nil,
+		"amount_mutez": nil,
+		"fee":          nil,
+		"fee_mutez":    nil,
+		"counter":      nil,
+		"level":        nil,
+		"block":        nil,
+	}
+	if op == nil {
+		return env
+	}
+
+	env["kind"] = op.Kind
+	env["hash"] = op.Hash
+	env["source"] = op.Source
+	env["destination"] = op.Destination
+	env["amount"] = op.Amount
+	env["amount_mutez"] = op.AmountMutez
+	env["fee"] = op.Fee
+	env["fee_mutez"] = op.FeeMutez
+	env["counter"] = op.Counter
+	if op.Block != nil {
+		env["level"] = op.Block.Header.Level
+		env["block"] = op.Block.Hash
+	}
+
+	return env
+}
+
+// filterOperations returns operations matching the expression
+func filterOperations(expr *filter.Expr, ops []*opInfo) ([]*opInfo, error) {
+	if expr == nil {
+		return ops, nil
+	}
+
+	res := make([]*opInfo, 0, len(ops))
+	for _, op := range ops {
+		ok, err := expr.Match(opEnv(op))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res = append(res, op)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,266 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Shortest accepted hash prefix including the leading `B' or `o'
+const minHashPrefix = 8
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Kinds of objects found by a hash prefix
+const (
+	matchBlock     = "block"
+	matchOperation = "operation"
+)
+
+type hashMatch struct {
+	Kind  string
+	Hash  string
+	Block string
+	Level int
+}
+
+type findOptions struct {
+	depth  int
+	format string
+}
+
+// NewFindCommand returns new `find' command
+func NewFindCommand(rootCtx *RootContext) *cobra.Command {
+	var opt findOptions
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	findCmd := &cobra.Command{
+		Use:   "find <prefix>",
+		Short: "Find a block or an operation by an abbreviated hash",
+		Long: fmt.Sprintf(`Find a block or an operation by an abbreviated hash (at least %d characters) and show it.
+The persistent RPC cache (--rpc-cache-dir) is searched first, then the last --depth blocks of the chain up to --block.
+Block prefixes start with `+"`B'"+` and operation prefixes with `+"`o'.", minHashPrefix),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := ctx.init(opt.format, ""); err != nil {
+				return err
+			}
+			if ctx.csv || ctx.tabular {
+				return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+			}
+			return ctx.find(args[0], &opt)
+		},
+	}
+
+	f := findCmd.Flags()
+	f.IntVar(&opt.depth, "depth", 120, "Number of recent blocks to search if the prefix isn't found in the cache")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	f.BoolVar(&ctx.wide, "wide", false, "Don't truncate hashes and addresses to fit the terminal width")
+
+	return findCmd
+}
+
+func validHashPrefix(prefix string) error {
+	if len(prefix) < minHashPrefix {
+		return fmt.Errorf("Hash prefix `%s' is too short, at least %d characters are required", prefix, minHashPrefix)
+	}
+	if prefix[0] != 'B' && prefix[0] != 'o' {
+		return fmt.Errorf("`%s' is neither a block nor an operation hash prefix", prefix)
+	}
+	for _, c := range prefix {
+		if !strings.ContainsRune(base58Alphabet, c) {
+			return fmt.Errorf("Invalid character in hash prefix `%s': %q", prefix, c)
+		}
+	}
+	return nil
+}
+
+// locate resolves the hash prefix searching the cache first and the last depth blocks then
+func (c *BlockCommandContext) locate(prefix string, depth int) (*hashMatch, error) {
+	if err := validHashPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	matches := make(map[string]*hashMatch)
+	if err := c.findInCache(prefix, matches); err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		if err := c.findInChain(prefix, depth, matches); err != nil {
+			return nil, err
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("No block or operation matches `%s'", prefix)
+	case 1:
+	default:
+		list := make([]*hashMatch, 0, len(matches))
+		for _, m := range matches {
+			list = append(list, m)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Hash < list[j].Hash })
+		for _, m := range list {
+			log.WithFields(log.Fields{
+				"kind":  m.Kind,
+				"level": m.Level,
+			}).Warn(m.Hash)
+		}
+		return nil, fmt.Errorf("Ambiguous hash prefix `%s': %d candidates", prefix, len(matches))
+	}
+
+	var m *hashMatch
+	for _, v := range matches {
+		m = v
+	}
+	return m, nil
+}
+
+func (c *BlockCommandContext) find(prefix string, opt *findOptions) error {
+	m, err := c.locate(prefix, opt.depth)
+	if err != nil {
+		return err
+	}
+	if m.Kind == matchBlock {
+		return c.showBlocks([]string{m.Hash})
+	}
+	return c.showOperation(m.Block, m.Hash)
+}
+
+// findInCache looks for the prefix in blocks stored in the persistent RPC cache
+func (c *BlockCommandContext) findInCache(prefix string, matches map[string]*hashMatch) error {
+	if c.cache == nil {
+		return nil
+	}
+
+	return c.cache.Walk(func(body []byte) bool {
+		var b struct {
+			Hash   string `json:"hash"`
+			Header struct {
+				Level int `json:"level"`
+			} `json:"header"`
+			Operations [][]struct {
+				Hash string `json:"hash"`
+			} `json:"operations"`
+		}
+		if json.Unmarshal(body, &b) != nil || !strings.HasPrefix(b.Hash, "B") {
+			return true
+		}
+		if strings.HasPrefix(b.Hash, prefix) {
+			matches[b.Hash] = &hashMatch{Kind: matchBlock, Hash: b.Hash, Block: b.Hash, Level: b.Header.Level}
+		}
+		for _, ol := range b.Operations {
+			for _, o := range ol {
+				if strings.HasPrefix(o.Hash, prefix) {
+					matches[o.Hash] = &hashMatch{Kind: matchOperation, Hash: o.Hash, Block: b.Hash, Level: b.Header.Level}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// findInChain looks for the prefix in the last depth blocks of the chain ending at --block
+func (c *BlockCommandContext) findInChain(prefix string, depth int, matches map[string]*hashMatch) error {
+	if depth < 1 {
+		return nil
+	}
+
+	var head struct {
+		Hash  string `json:"hash"`
+		Level int    `json:"level"`
+	}
+	if err := c.getRPC(c.blockPath(c.blockID)+"/header", &head); err != nil {
+		return err
+	}
+
+	var lists [][]string
+	if err := c.getRPC(fmt.Sprintf("/chains/%s/blocks?head=%s&length=%d", c.chainID, head.Hash, depth), &lists); err != nil {
+		return err
+	}
+	if len(lists) == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"prefix": prefix,
+		"blocks": len(lists[0]),
+	}).Debug("Searching recent blocks")
+
+	for i, hash := range lists[0] {
+		level := head.Level - i
+		if prefix[0] == 'B' {
+			if strings.HasPrefix(hash, prefix) {
+				matches[hash] = &hashMatch{Kind: matchBlock, Hash: hash, Block: hash, Level: level}
+			}
+			continue
+		}
+
+		var passes [][]string
+		if err := c.getRPC(c.blockPath(hash)+"/operation_hashes", &passes); err != nil {
+			return err
+		}
+		for _, p := range passes {
+			for _, h := range p {
+				if strings.HasPrefix(h, prefix) {
+					matches[h] = &hashMatch{Kind: matchOperation, Hash: h, Block: hash, Level: level}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// showOperation outputs all elements of the operation like `block operations' does
+func (c *BlockCommandContext) showOperation(blockHash, opHash string) error {
+	block, err := c.getBlock(blockHash, false)
+	if err != nil {
+		return err
+	}
+
+	if c.newEncoder != nil {
+		return c.newEncoder(c.output).Encode(getRawBlockOperations(block, nil, map[string]struct{}{opHash: {}}))
+	}
+
+	var ops []*opInfo
+	for _, op := range getBlockOperations(getBlockInfo(block), nil) {
+		if op.Hash == opHash {
+			ops = append(ops, op)
+		}
+	}
+
+	table := utils.NewTable(c.output, operationsColumns, c.maxWidth)
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	return c.writeOperations(table, ops)
+}
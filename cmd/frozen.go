@@ -0,0 +1,318 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/michelson"
+	"github.com/spf13/cobra"
+)
+
+// Number of cycles during which a denunciation can be included, fixed since Paris
+const defaultMaxSlashingPeriod = 2
+
+type unstakeRequest struct {
+	// Cycle the unstake was requested at
+	Cycle       int        `json:"cycle" yaml:"cycle"`
+	Delegate    string     `json:"delegate" yaml:"delegate"`
+	AmountMutez *big.Int   `json:"amount_mutez" yaml:"amount_mutez"`
+	Amount      *big.Float `json:"amount" yaml:"amount"`
+	Finalizable bool       `json:"finalizable" yaml:"finalizable"`
+	UnlockCycle int        `json:"unlock_cycle" yaml:"unlock_cycle"`
+	UnlockLevel int        `json:"unlock_level" yaml:"unlock_level"`
+	// Estimated from the minimal block delay
+	UnlockTime time.Time `json:"unlock_time" yaml:"unlock_time"`
+}
+
+type unstakedDeposit struct {
+	Cycle        int        `json:"cycle" yaml:"cycle"`
+	DepositMutez *big.Int   `json:"deposit_mutez" yaml:"deposit_mutez"`
+	Deposit      *big.Float `json:"deposit" yaml:"deposit"`
+}
+
+type frozenBalance struct {
+	Address                  string             `json:"address" yaml:"address"`
+	Cycle                    int                `json:"cycle" yaml:"cycle"`
+	Level                    int                `json:"level" yaml:"level"`
+	FrozenDepositsMutez      *big.Int           `json:"frozen_deposits_mutez,omitempty" yaml:"frozen_deposits_mutez,omitempty"`
+	StakedMutez              *big.Int           `json:"staked_mutez" yaml:"staked_mutez"`
+	UnstakedFrozenMutez      *big.Int           `json:"unstaked_frozen_mutez" yaml:"unstaked_frozen_mutez"`
+	UnstakedFinalizableMutez *big.Int           `json:"unstaked_finalizable_mutez" yaml:"unstaked_finalizable_mutez"`
+	UnstakeRequests          []*unstakeRequest  `json:"unstake_requests" yaml:"unstake_requests"`
+	UnstakedDeposits         []*unstakedDeposit `json:"unstaked_deposits,omitempty" yaml:"unstaked_deposits,omitempty"`
+}
+
+type unstakeRequests struct {
+	Finalizable []*struct {
+		Delegate string       `json:"delegate"`
+		Cycle    int          `json:"cycle"`
+		Amount   tezos.BigInt `json:"amount"`
+	} `json:"finalizable"`
+	Unfinalizable struct {
+		Delegate string `json:"delegate"`
+		Requests []*struct {
+			Cycle  int          `json:"cycle"`
+			Amount tezos.BigInt `json:"amount"`
+		} `json:"requests"`
+	} `json:"unfinalizable"`
+}
+
+// Constants defining the unstake delay. Newer protocols have it explicitly
+type unstakeConstants struct {
+	bakerConstants
+	UnstakeFinalizationDelay *int `json:"unstake_finalization_delay"`
+	MaxSlashingPeriod        *int `json:"max_slashing_period"`
+	BlocksPerCycle           int  `json:"blocks_per_cycle"`
+	MinimalBlockDelay        int  `json:"minimal_block_delay,string"`
+}
+
+// unstakeDelay returns the number of cycles after which unstaked tokens can be finalized
+func (u *unstakeConstants) unstakeDelay() int {
+	if u.UnstakeFinalizationDelay != nil {
+		return *u.UnstakeFinalizationDelay
+	}
+	slashing := defaultMaxSlashingPeriod
+	if u.MaxSlashingPeriod != nil {
+		slashing = *u.MaxSlashingPeriod
+	}
+	return u.rightsDelay() + slashing
+}
+
+func newFrozenCommand(ctx *RootContext) *cobra.Command {
+	var outputFormat string
+
+	frozenCmd := &cobra.Command{
+		Use:   "frozen <address|alias>",
+		Short: "Show staked and unstaked balances and when they unlock",
+		Long: `Show frozen deposits of a delegate, staked balance and unstaked balances pending finalization.
+Unstake requests unlock after the protocol's delay, their unlock time is estimated from the minimal block delay.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := ctx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			f, err := ctx.getFrozenBalance(address)
+			if err != nil {
+				return err
+			}
+
+			if outputFormat != "text" {
+				newEnc := ctx.encoderFunc(outputFormat)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+				return newEnc(os.Stdout).Encode(f)
+			}
+			ctx.printFrozenBalance(f)
+			return nil
+		},
+	}
+
+	frozenCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+
+	return frozenCmd
+}
+
+func newFinalizeUnstakeCommand(ctx *RootContext) *cobra.Command {
+	var (
+		from string
+		opt  injectOptions
+	)
+
+	finalizeCmd := &cobra.Command{
+		Use:   "finalize-unstake",
+		Short: "Move finalizable unstaked tokens back to the spendable balance",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			acc, err := ctx.account(from)
+			if err != nil {
+				return err
+			}
+
+			var finalizable tezos.BigInt
+			ok, err := ctx.getOptionalRPC(ctx.blockPath("head")+"/context/contracts/"+acc.Address+"/unstaked_finalizable_balance", &finalizable)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errors.New("Protocol doesn't support staking")
+			}
+			if finalizable.Sign() == 0 {
+				return fmt.Errorf("%s has no finalizable unstaked tokens", acc.Address)
+			}
+
+			op := forge.Transaction{
+				Amount:      big.NewInt(0),
+				Destination: acc.Address,
+				Entrypoint:  "finalize_unstake",
+				Parameters:  michelson.NewPrim("Unit"),
+			}
+			return ctx.sendAndPrint(acc, []forge.ManagerOperation{&op}, &opt)
+		},
+	}
+
+	finalizeCmd.Flags().StringVar(&from, "from", "", "Staker account: keystore alias or address")
+	finalizeCmd.MarkFlagRequired("from")
+	addInjectFlags(finalizeCmd, &opt)
+
+	return finalizeCmd
+}
+
+func (c *RootContext) getFrozenBalance(address string) (*frozenBalance, error) {
+	block := c.blockPath(c.blockID)
+	contract := block + "/context/contracts/" + address
+
+	f := frozenBalance{Address: address}
+	for _, v := range []struct {
+		path string
+		dst  **big.Int
+	}{
+		{"/staked_balance", &f.StakedMutez},
+		{"/unstaked_frozen_balance", &f.UnstakedFrozenMutez},
+		{"/unstaked_finalizable_balance", &f.UnstakedFinalizableMutez},
+	} {
+		var balance tezos.BigInt
+		ok, err := c.getOptionalRPC(contract+v.path, &balance)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.New("Protocol doesn't support staking")
+		}
+		*v.dst = &balance.Int
+	}
+
+	delegate := block + "/context/delegates/" + address
+	var frozen tezos.BigInt
+	ok, err := c.getOptionalRPC(delegate+"/current_frozen_deposits", &frozen)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		f.FrozenDepositsMutez = &frozen.Int
+
+		var deposits []*struct {
+			Cycle   int          `json:"cycle"`
+			Deposit tezos.BigInt `json:"deposit"`
+		}
+		if _, err := c.getOptionalRPC(delegate+"/unstaked_frozen_deposits", &deposits); err != nil {
+			return nil, err
+		}
+		for _, d := range deposits {
+			if d.Deposit.Sign() != 0 {
+				f.UnstakedDeposits = append(f.UnstakedDeposits, &unstakedDeposit{
+					Cycle:        d.Cycle,
+					DepositMutez: &d.Deposit.Int,
+					Deposit:      c.amountFormat.Tez(&d.Deposit.Int),
+				})
+			}
+		}
+	}
+
+	var requests *unstakeRequests
+	if _, err := c.getOptionalRPC(contract+"/unstake_requests", &requests); err != nil {
+		return nil, err
+	}
+
+	var head struct {
+		Level     int       `json:"level"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if err := c.getRPC(block+"/header", &head); err != nil {
+		return nil, err
+	}
+	var cur currentLevel
+	if err := c.getRPC(block+"/helpers/current_level", &cur); err != nil {
+		return nil, err
+	}
+	var lv cycleLevels
+	if err := c.getRPC(block+"/helpers/levels_in_current_cycle", &lv); err != nil {
+		return nil, err
+	}
+	var constants unstakeConstants
+	if err := c.getRPC(block+"/context/constants", &constants); err != nil {
+		return nil, err
+	}
+	f.Cycle, f.Level = cur.Cycle, head.Level
+
+	f.UnstakeRequests = []*unstakeRequest{}
+	add := func(delegate string, cycle int, amount *big.Int, finalizable bool) {
+		r := unstakeRequest{
+			Cycle:       cycle,
+			Delegate:    delegate,
+			AmountMutez: amount,
+			Amount:      c.amountFormat.Tez(amount),
+			Finalizable: finalizable,
+			UnlockCycle: cycle + constants.unstakeDelay(),
+		}
+		r.UnlockLevel = lv.First + (r.UnlockCycle-cur.Cycle)*constants.BlocksPerCycle
+		r.UnlockTime = head.Timestamp.Add(time.Duration(r.UnlockLevel-head.Level) * time.Duration(constants.MinimalBlockDelay) * time.Second)
+		f.UnstakeRequests = append(f.UnstakeRequests, &r)
+	}
+	if requests != nil {
+		for _, r := range requests.Finalizable {
+			add(r.Delegate, r.Cycle, &r.Amount.Int, true)
+		}
+		for _, r := range requests.Unfinalizable.Requests {
+			add(requests.Unfinalizable.Delegate, r.Cycle, &r.Amount.Int, false)
+		}
+	}
+
+	return &f, nil
+}
+
+func (c *RootContext) printFrozenBalance(f *frozenBalance) {
+	fmt.Printf("Address:               %s\n", f.Address)
+	fmt.Printf("Cycle:                 %d (level %d)\n", f.Cycle, f.Level)
+	if f.FrozenDepositsMutez != nil {
+		fmt.Printf("Frozen deposits:       %s\n", c.amountFormat.Format(f.FrozenDepositsMutez))
+	}
+	fmt.Printf("Staked:                %s\n", c.amountFormat.Format(f.StakedMutez))
+	fmt.Printf("Unstaked, frozen:      %s\n", c.amountFormat.Format(f.UnstakedFrozenMutez))
+	fmt.Printf("Unstaked, finalizable: %s\n", c.amountFormat.Format(f.UnstakedFinalizableMutez))
+
+	if len(f.UnstakeRequests) != 0 {
+		fmt.Printf("\nUnstake requests:\n")
+		for _, r := range f.UnstakeRequests {
+			if r.Finalizable {
+				fmt.Printf("  cycle %-6d %20s  %s\n", r.Cycle, c.amountFormat.Format(r.AmountMutez), c.colorizer.Green("finalizable"))
+				continue
+			}
+			fmt.Printf("  cycle %-6d %20s  unlocks at cycle %d, level %d, ~%s\n", r.Cycle, c.amountFormat.Format(r.AmountMutez),
+				r.UnlockCycle, r.UnlockLevel, c.localTime(r.UnlockTime).Format("2006-01-02 15:04"))
+		}
+	}
+
+	if len(f.UnstakedDeposits) != 0 {
+		fmt.Printf("\nUnstaked deposits of the delegate's stakers:\n")
+		for _, d := range f.UnstakedDeposits {
+			fmt.Printf("  cycle %-6d %20s\n", d.Cycle, c.amountFormat.Format(d.DepositMutez))
+		}
+	}
+}
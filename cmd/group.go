@@ -0,0 +1,185 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+)
+
+// Operation grouping keys
+const (
+	groupBySource      = "source"
+	groupByDestination = "destination"
+	groupByKind        = "kind"
+	groupByBaker       = "baker"
+)
+
+var errCSVGroupOnly = errors.New("CSV output is only supported by `block operations --group-by'")
+
+// Columns of the grouped operations table
+var opGroupColumns = []utils.TableColumn{
+	{Header: "GROUP", Width: 36, MinWidth: 13},
+	{Header: "COUNT", Width: 8, Align: utils.AlignRight},
+	{Header: "AMOUNT", Width: 22, Align: utils.AlignRight},
+	{Header: "FEE", Width: 16, Align: utils.AlignRight},
+}
+
+type opGroup struct {
+	Group       string     `json:"group" yaml:"group"`
+	Count       int        `json:"count" yaml:"count"`
+	AmountMutez *big.Int   `json:"amount_mutez" yaml:"amount_mutez"`
+	FeeMutez    *big.Int   `json:"fee_mutez" yaml:"fee_mutez"`
+	Amount      *big.Float `json:"amount" yaml:"amount"`
+	Fee         *big.Float `json:"fee" yaml:"fee"`
+}
+
+// opGroupKey returns the function extracting the grouping key from the operation
+func opGroupKey(by string) (func(op *opInfo) string, error) {
+	switch by {
+	case groupBySource:
+		return func(op *opInfo) string { return op.Source }, nil
+	case groupByDestination:
+		return func(op *opInfo) string { return op.Destination }, nil
+	case groupByKind:
+		return func(op *opInfo) string { return op.Kind }, nil
+	case groupByBaker:
+		return func(op *opInfo) string {
+			if op.Block == nil {
+				return ""
+			}
+			return op.Block.Metadata.Baker
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown grouping key: `%s'", by)
+	}
+}
+
+// groupOperations aggregates operations by the key. Operations with an empty key (e.g. destination of a reveal) are left out.
+// Groups are sorted by the total amount, then by the count in descending order
+func groupOperations(ops []*opInfo, key func(op *opInfo) string, af *utils.AmountFormat) []*opGroup {
+	index := make(map[string]*opGroup)
+	for _, op := range ops {
+		k := key(op)
+		if k == "" {
+			continue
+		}
+		g, ok := index[k]
+		if !ok {
+			g = &opGroup{
+				Group:       k,
+				AmountMutez: big.NewInt(0),
+				FeeMutez:    big.NewInt(0),
+			}
+			index[k] = g
+		}
+		g.Count++
+		if op.AmountMutez != nil {
+			g.AmountMutez.Add(g.AmountMutez, op.AmountMutez)
+		}
+		if op.FeeMutez != nil {
+			g.FeeMutez.Add(g.FeeMutez, op.FeeMutez)
+		}
+	}
+
+	res := make([]*opGroup, 0, len(index))
+	for _, g := range index {
+		g.Amount = af.Tez(g.AmountMutez)
+		g.Fee = af.Tez(g.FeeMutez)
+		res = append(res, g)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if c := res[i].AmountMutez.Cmp(res[j].AmountMutez); c != 0 {
+			return c > 0
+		}
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Group < res[j].Group
+	})
+
+	return res
+}
+
+// writeOperationGroups outputs groups using the encoder if not nil or the selected text format
+func (c *BlockCommandContext) writeOperationGroups(enc utils.Encoder, groups []*opGroup) error {
+	switch {
+	case c.csv:
+		return writeOperationGroupsCSV(c.output, groups)
+
+	case enc != nil:
+		return enc.Encode(groups)
+
+	case c.userTemplate != nil:
+		for _, g := range groups {
+			if err := c.userTemplate.Execute(c.output, g); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	table := utils.NewTable(c.output, opGroupColumns, c.maxWidth)
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		err := table.WriteRow(
+			g.Group,
+			strconv.Itoa(g.Count),
+			c.amountFormat.Format(g.AmountMutez),
+			c.amountFormat.Format(g.FeeMutez),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSV holds exact amounts in mutez so it can be imported without precision loss
+func writeOperationGroupsCSV(w io.Writer, groups []*opGroup) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"group", "count", "amount_mutez", "fee_mutez"}); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		err := cw.Write([]string{
+			g.Group,
+			strconv.Itoa(g.Count),
+			g.AmountMutez.String(),
+			g.FeeMutez.String(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,293 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Account index placeholder of the discovery path template
+const pathIndexPlaceholder = "*"
+
+// Default account discovery path, same as used by Tezos hardware and browser wallets
+const defaultDiscoveryPath = "m/44'/1729'/*'/0'"
+
+// Columns of the discovered accounts table
+var discoveryColumns = []utils.TableColumn{
+	{Header: "INDEX", Width: 5, Align: utils.AlignRight},
+	{Header: "PATH", Width: 20, MinWidth: 8},
+	{Header: "ADDRESS", Width: 36, MinWidth: 13},
+	{Header: "BALANCE", Width: 20, Align: utils.AlignRight},
+	{Header: "REVEALED", Width: 8},
+}
+
+type mnemonicOptions struct {
+	keyType    string
+	passphrase string
+}
+
+type discoverOptions struct {
+	mnemonic     mnemonicOptions
+	path         string
+	start        int
+	gap          int
+	importPrefix string
+	unencrypted  bool
+	format       string
+}
+
+// discoveredAccount is an account derived from the mnemonic. Balance is in mutez
+type discoveredAccount struct {
+	Index    int    `json:"index" yaml:"index"`
+	Path     string `json:"path" yaml:"path"`
+	Address  string `json:"address" yaml:"address"`
+	Balance  string `json:"balance" yaml:"balance"`
+	Revealed bool   `json:"revealed" yaml:"revealed"`
+	Alias    string `json:"alias,omitempty" yaml:"alias,omitempty"`
+
+	key keys.PrivateKey
+}
+
+func (a *discoveredAccount) used() bool {
+	return a.Revealed || a.Balance != "0"
+}
+
+func addMnemonicFlags(cmd *cobra.Command, opt *mnemonicOptions) {
+	cmd.Flags().StringVar(&opt.passphrase, "mnemonic-passphrase", "", "BIP39 passphrase given inline, as - (read from stdin) or @path")
+}
+
+// seed returns the BIP39 seed of the mnemonic
+func (o *mnemonicOptions) seed(mnemonic string) ([]byte, error) {
+	var pass []byte
+	if o.passphrase != "" {
+		s, err := utils.ReadInputString(o.passphrase)
+		if err != nil {
+			return nil, err
+		}
+		pass = []byte(s)
+	}
+	return keys.MnemonicSeed(mnemonic, pass)
+}
+
+// importMnemonic derives the key at the path from the mnemonic. The normalized path is returned along with the key
+func importMnemonic(mnemonic, path string, opt *mnemonicOptions) (keys.PrivateKey, string, error) {
+	p, err := keys.ParseDerivationPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	seed, err := opt.seed(mnemonic)
+	if err != nil {
+		return nil, "", err
+	}
+	k, err := keys.DeriveKey(opt.keyType, seed, p)
+	if err != nil {
+		return nil, "", err
+	}
+	log.WithField("path", p.String()).Info("Key derived from the mnemonic")
+	return k, p.String(), nil
+}
+
+func newKeyDiscoverCommand(ctx *RootContext) *cobra.Command {
+	var opt discoverOptions
+
+	discoverCmd := &cobra.Command{
+		Use:   "discover <mnemonic|-|@file>",
+		Short: "Find used accounts derived from a mnemonic",
+		Long: `Derive accounts from the mnemonic by substituting the index into the --path template and check them against the chain.
+An account is used if it has a balance or a revealed public key. The scan stops after --gap unused accounts in a row.
+Use --import to add the used accounts to the keystore as <prefix><index>.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, err := utils.ReadInputString(args[0])
+			if err != nil {
+				return err
+			}
+			return ctx.discoverAccounts(src, &opt)
+		},
+	}
+
+	f := discoverCmd.Flags()
+	f.StringVar(&opt.mnemonic.keyType, "type", keys.TypeEd25519, "Key type: one of [ed25519, p256]")
+	addMnemonicFlags(discoverCmd, &opt.mnemonic)
+	f.StringVar(&opt.path, "path", defaultDiscoveryPath, "Derivation path template, "+pathIndexPlaceholder+" is replaced by the account index")
+	f.IntVar(&opt.start, "start", 0, "First account index")
+	f.IntVar(&opt.gap, "gap", 20, "Number of consecutive unused accounts after which the scan stops")
+	f.StringVar(&opt.importPrefix, "import", "", "Add used accounts to the keystore with the alias prefix")
+	f.BoolVar(&opt.unencrypted, "unencrypted", false, "Store the secret keys without a passphrase")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+
+	return discoverCmd
+}
+
+func (c *RootContext) discoverAccount(seed []byte, index int, opt *discoverOptions) (*discoveredAccount, error) {
+	path, err := keys.ParseDerivationPath(strings.Replace(opt.path, pathIndexPlaceholder, strconv.Itoa(index), 1))
+	if err != nil {
+		return nil, err
+	}
+	k, err := keys.DeriveKey(opt.mnemonic.keyType, seed, path)
+	if err != nil {
+		return nil, err
+	}
+
+	acc := discoveredAccount{
+		Index:   index,
+		Path:    path.String(),
+		Address: k.Public().Hash(),
+		Balance: "0",
+		key:     k,
+	}
+	prefix := c.blockPath(c.blockID) + "/context/contracts/" + acc.Address
+	var balance tezos.BigInt
+	ok, err := c.getOptionalRPC(prefix+"/balance", &balance)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		acc.Balance = balance.String()
+	}
+	var pk string
+	if ok, err = c.getOptionalRPC(prefix+"/manager_key", &pk); err != nil {
+		return nil, err
+	}
+	acc.Revealed = ok && pk != ""
+	return &acc, nil
+}
+
+func (c *RootContext) discoverAccounts(mnemonic string, opt *discoverOptions) error {
+	if strings.Count(opt.path, pathIndexPlaceholder) != 1 {
+		return fmt.Errorf("Derivation path template must contain one %s: `%s'", pathIndexPlaceholder, opt.path)
+	}
+	if opt.gap < 1 || opt.start < 0 {
+		return errors.New("Invalid account range")
+	}
+	var newEnc utils.NewEncoderFunc
+	if opt.format != "text" {
+		if newEnc = c.encoderFunc(opt.format); newEnc == nil {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+
+	seed, err := opt.mnemonic.seed(mnemonic)
+	if err != nil {
+		return err
+	}
+
+	var found []*discoveredAccount
+	for i, unused := opt.start, 0; unused < opt.gap; i++ {
+		acc, err := c.discoverAccount(seed, i, opt)
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"path":    acc.Path,
+			"address": acc.Address,
+		}).Debug("Account checked")
+		if acc.used() {
+			found = append(found, acc)
+			unused = 0
+		} else {
+			unused++
+		}
+	}
+	log.WithField("count", len(found)).Info("Discovery finished")
+
+	if opt.importPrefix != "" && len(found) != 0 {
+		if err := c.importDiscovered(mnemonic, found, opt); err != nil {
+			return err
+		}
+	}
+
+	if newEnc != nil {
+		if found == nil {
+			found = []*discoveredAccount{}
+		}
+		return newEnc(os.Stdout).Encode(found)
+	}
+
+	table := utils.NewTable(os.Stdout, discoveryColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, acc := range found {
+		balance, _ := new(big.Int).SetString(acc.Balance, 10)
+		revealed := "no"
+		if acc.Revealed {
+			revealed = "yes"
+		}
+		if err := table.WriteRow(strconv.Itoa(acc.Index), acc.Path, acc.Address, c.amountFormat.Format(balance), revealed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importDiscovered adds the accounts to the keystore asking for a single passphrase
+func (c *RootContext) importDiscovered(mnemonic string, accounts []*discoveredAccount, opt *discoverOptions) error {
+	store, err := c.keyStore()
+	if err != nil {
+		return err
+	}
+
+	var pass []byte
+	if !opt.unencrypted {
+		if pass, err = utils.ReadPassphrase("Enter new passphrase for the discovered keys: ", true); err != nil {
+			return err
+		}
+	}
+
+	for _, acc := range accounts {
+		if e := store.Lookup(acc.Address); e != nil {
+			log.WithFields(log.Fields{
+				"alias":   e.Alias,
+				"address": acc.Address,
+			}).Info("Key is already in the keystore")
+			acc.Alias = e.Alias
+			continue
+		}
+		e, err := keys.NewEntry(opt.importPrefix+strconv.Itoa(acc.Index), acc.key, pass)
+		if err != nil {
+			return err
+		}
+		if err := e.SetMnemonic(mnemonic, acc.Path, pass); err != nil {
+			return err
+		}
+		if err := store.Add(e); err != nil {
+			return err
+		}
+		acc.Alias = e.Alias
+		log.WithFields(log.Fields{
+			"alias":   e.Alias,
+			"address": e.Address,
+		}).Info("Key added")
+	}
+	return store.Save()
+}
@@ -0,0 +1,128 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/spf13/cobra"
+)
+
+// NewHeadCommand returns new `head' command which is a shortcut for `block head'
+func NewHeadCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		outputFormat string
+		userTemplate string
+		headCmd      *cobra.Command // Forward declaration, see PersistentPreRunE below
+	)
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	headCmd = &cobra.Command{
+		Use:   "head",
+		Short: "Head block summary (same as `block head')",
+		Args:  cobra.NoArgs,
+
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if p := headCmd.Parent(); p != nil {
+				if pr := p.PersistentPreRunE; pr != nil {
+					if err := pr(cmd, args); err != nil {
+						return err
+					}
+				}
+			}
+
+			return ctx.init(outputFormat, userTemplate)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.showBlocks([]string{"head"})
+		},
+	}
+
+	hashCmd := &cobra.Command{
+		Use:   "hash",
+		Short: "Print head block hash only",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.printHead(func(bi *tezos.BlockInfo) interface{} { return bi.Hash })
+		},
+	}
+
+	levelCmd := &cobra.Command{
+		Use:   "level",
+		Short: "Print head block level only",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.printHead(func(bi *tezos.BlockInfo) interface{} { return bi.Level })
+		},
+	}
+
+	headCmd.PersistentFlags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json, markdown, html, msgpack, cbor, parquet]")
+	headCmd.PersistentFlags().StringVar(&userTemplate, "output-fmt", "", "Output format (Go template)")
+	headCmd.PersistentFlags().BoolVar(&ctx.watch, "watch", false, "Watch for new head blocks in a chain")
+	headCmd.AddCommand(hashCmd)
+	headCmd.AddCommand(levelCmd)
+
+	return headCmd
+}
+
+// printHead prints a single raw value for each head block suitable for shell scripting
+func (c *BlockCommandContext) printHead(value func(bi *tezos.BlockInfo) interface{}) error {
+	if !c.watch {
+		block, err := c.service.GetBlock(c.context, c.chainID, "head")
+		if err != nil {
+			return err
+		}
+		fmt.Println(value(&tezos.BlockInfo{Hash: block.Hash, Level: block.Header.Level}))
+		return nil
+	}
+
+	var monErr error
+	ch := make(chan *tezos.BlockInfo, 10)
+	go func() {
+		monErr = c.monitorHeads(ch)
+		close(ch)
+	}()
+
+	var (
+		lastLevel          int
+		firstBlockReceived bool
+	)
+	for bi := range ch {
+		if firstBlockReceived && bi.Level <= lastLevel {
+			continue
+		}
+		firstBlockReceived = true
+		lastLevel = bi.Level
+
+		fmt.Println(value(bi))
+	}
+
+	if monErr != nil && monErr != context.Canceled {
+		return monErr
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/internal/atomicfile"
+	"github.com/ecadlabs/tez/internal/filelock"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/blake2b"
+)
+
+// highWatermark is the last block or consensus operation signed by a baker key at the chain
+type highWatermark struct {
+	Level int32 `json:"level"`
+	Round int32 `json:"round"`
+	// Blake2b digest of the signed payload, the same payload is signed again with the same signature
+	Digest    string `json:"digest"`
+	Signature string `json:"signature"`
+}
+
+// highWatermarks are kept by chain ID, baker address and watermark name like the octez signer does
+type highWatermarks map[string]map[string]map[string]*highWatermark
+
+// Serializes the read-modify-write cycles of the high watermarks file within the process before the file lock is taken
+var highWatermarkMu sync.Mutex
+
+func loadHighWatermarks(path string) (highWatermarks, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(highWatermarks), nil
+		}
+		return nil, err
+	}
+	hw := make(highWatermarks)
+	if err := json.Unmarshal(data, &hw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return hw, nil
+}
+
+func (h highWatermarks) get(chainID, address, name string) *highWatermark {
+	return h[chainID][address][name]
+}
+
+func (h highWatermarks) set(chainID, address, name string, w *highWatermark) {
+	if h[chainID] == nil {
+		h[chainID] = make(map[string]map[string]*highWatermark)
+	}
+	if h[chainID][address] == nil {
+		h[chainID][address] = make(map[string]*highWatermark)
+	}
+	h[chainID][address][name] = w
+}
+
+// signAboveHighWatermark signs the block or consensus payload only if its level and round are above the last signed ones.
+// The new high watermark is written to the file before the signature is returned. The file stays locked from the check
+// to the write, so other processes sharing it (signer serve, sign bytes) never sign at the same level and round
+func (a *account) signAboveHighWatermark(key keys.PrivateKey, w *keys.Watermark, chainID string, data []byte) (*keys.Signature, error) {
+	level, round, err := w.LevelRound(data)
+	if err != nil {
+		return nil, err
+	}
+	sum := blake2b.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	path, err := utils.ExpandHome(a.highWatermarksPath)
+	if err != nil {
+		return nil, err
+	}
+
+	highWatermarkMu.Lock()
+	defer highWatermarkMu.Unlock()
+
+	l, err := filelock.Acquire(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	hw, err := loadHighWatermarks(path)
+	if err != nil {
+		return nil, err
+	}
+	if last := hw.get(chainID, a.Address, w.Name); last != nil {
+		switch {
+		case level > last.Level || level == last.Level && round > last.Round:
+		case level == last.Level && round == last.Round && digest == last.Digest:
+			return keys.ParseSignature(last.Signature)
+		default:
+			log.WithFields(log.Fields{
+				"key":         a.Alias,
+				"watermark":   w.Name,
+				"block_level": level,
+				"round":       round,
+				"last_level":  last.Level,
+				"last_round":  last.Round,
+			}).Error("Double signing prevented")
+			return nil, fmt.Errorf("Refusing to sign %s at level %d round %d with `%s': the high watermark is level %d round %d", w.Name, level, round, a.Alias, last.Level, last.Round)
+		}
+	}
+
+	sig, err := keys.SignWatermarked(key, w, chainID, data)
+	if err != nil {
+		return nil, err
+	}
+	hw.set(chainID, a.Address, w.Name, &highWatermark{
+		Level:     level,
+		Round:     round,
+		Digest:    digest,
+		Signature: sig.String(),
+	})
+	buf, err := json.MarshalIndent(hw, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := atomicfile.WriteFile(path, append(buf, '\n'), 0600); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func newKeySetBakerCommand(rootCtx *RootContext) *cobra.Command {
+	var unset bool
+
+	cmd := &cobra.Command{
+		Use:   "set-baker <alias>",
+		Short: "Flag a key as a baker key",
+		Long: `Flag the key as a baker key. Baker keys refuse to sign blocks and consensus operations by hand (see sign bytes)
+and every block or consensus operation they sign is checked against the local high watermarks (--high-watermarks):
+a second payload at the same or a lower level and round is refused to prevent double signing.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := rootCtx.keyStore()
+			if err != nil {
+				return err
+			}
+			e := store.Lookup(args[0])
+			if e == nil {
+				return fmt.Errorf("Unknown key: `%s'", args[0])
+			}
+			if store.IsClient(e) {
+				return fmt.Errorf("`%s' belongs to the tezos-client wallet, import it to the keystore first", e.Alias)
+			}
+			e.Baker = !unset
+			if err := store.Save(); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"alias": e.Alias,
+				"baker": e.Baker,
+			}).Info("Key updated")
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&unset, "unset", false, "Remove the flag")
+
+	return cmd
+}
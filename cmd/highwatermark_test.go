@@ -0,0 +1,103 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ecadlabs/tez/keys"
+)
+
+// TestHighWatermarkProcess signs an attestation in a child process started by TestHighWatermarkRace
+func TestHighWatermarkProcess(t *testing.T) {
+	path := os.Getenv("TEZ_TEST_HIGH_WATERMARKS")
+	if path == "" {
+		t.Skip("child process only")
+	}
+	k, err := keys.ParsePrivateKey(os.Getenv("TEZ_TEST_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := keys.NewEntry("baker", k, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Baker = true
+	acc := account{Entry: e, highWatermarksPath: path, unlocked: k}
+
+	payload, _ := strconv.Atoi(os.Getenv("TEZ_TEST_PAYLOAD"))
+	w := keys.LookupWatermark(keys.WatermarkAttestation)
+	if _, err := acc.signBytes(w, testChainID, testAttestation(10, 0, byte(payload))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startHighWatermarkProcess starts a child process signing an attestation at level 10 round 0 with the payload hash byte
+func startHighWatermarkProcess(t *testing.T, path string, k keys.PrivateKey, payload byte) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHighWatermarkProcess$")
+	cmd.Env = append(os.Environ(),
+		"TEZ_TEST_HIGH_WATERMARKS="+path,
+		"TEZ_TEST_KEY="+k.String(),
+		"TEZ_TEST_PAYLOAD="+strconv.Itoa(int(payload)),
+	)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+// waitHighWatermarkProcess returns true if the child process signed the attestation
+func waitHighWatermarkProcess(t *testing.T, cmd *exec.Cmd) bool {
+	err := cmd.Wait()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestHighWatermarkRace(t *testing.T) {
+	k, err := keys.GeneratePrivateKey(keys.TypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testTempDir(t), "high_watermarks.json")
+
+	// Processes sharing the file race to sign conflicting attestations at the same level and round
+	const n = 4
+	cmds := make([]*exec.Cmd, n)
+	for i := range cmds {
+		cmds[i] = startHighWatermarkProcess(t, path, k, byte(i+1))
+	}
+
+	var signed int
+	for _, cmd := range cmds {
+		if waitHighWatermarkProcess(t, cmd) {
+			signed++
+		}
+	}
+	if signed != 1 {
+		t.Errorf("%d conflicting attestations signed, want 1", signed)
+	}
+}
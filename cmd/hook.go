@@ -0,0 +1,154 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Window used by the rate limiter
+const execRateWindow = time.Minute
+
+// execHook runs a templated shell command for every event
+type execHook struct {
+	ctx  context.Context
+	tpl  *template.Template
+	sem  chan struct{}
+	rate int // Maximum number of commands started within the window, 0 means no limit
+	// Start times of the commands within the window
+	started []time.Time
+	wg      sync.WaitGroup
+}
+
+// shellQuote quotes the string for use as a single shell argument
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func newExecHook(ctx context.Context, src string, funcs template.FuncMap, concurrency, rate int) (*execHook, error) {
+	if concurrency < 1 {
+		return nil, errors.New("Concurrency must be positive")
+	}
+	if rate < 0 {
+		return nil, errors.New("Rate limit must not be negative")
+	}
+
+	tpl, err := template.New("exec").Funcs(funcs).Funcs(template.FuncMap{"quote": shellQuote}).Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return &execHook{
+		ctx:  ctx,
+		tpl:  tpl,
+		sem:  make(chan struct{}, concurrency),
+		rate: rate,
+	}, nil
+}
+
+// allow returns false if the rate limit is exceeded
+func (h *execHook) allow(now time.Time) bool {
+	if h.rate == 0 {
+		return true
+	}
+
+	i := 0
+	for i < len(h.started) && now.Sub(h.started[i]) >= execRateWindow {
+		i++
+	}
+	h.started = h.started[i:]
+
+	if len(h.started) >= h.rate {
+		return false
+	}
+	h.started = append(h.started, now)
+	return true
+}
+
+// run starts the command for the event. It blocks while the concurrency limit is reached.
+// Events exceeding the rate limit are dropped
+func (h *execHook) run(data interface{}) error {
+	var b strings.Builder
+	if err := h.tpl.Execute(&b, data); err != nil {
+		return err
+	}
+	command := b.String()
+
+	if !h.allow(time.Now()) {
+		log.WithField("command", command).Warn("Exec rate limit exceeded, event dropped")
+		return nil
+	}
+
+	select {
+	case h.sem <- struct{}{}:
+	case <-h.ctx.Done():
+		return h.ctx.Err()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(h.ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(h.ctx, "sh", "-c", command)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	h.wg.Add(1)
+	go func() {
+		defer func() {
+			<-h.sem
+			h.wg.Done()
+		}()
+
+		start := time.Now()
+		err := cmd.Run()
+		l := log.WithFields(log.Fields{
+			"command": command,
+			"time":    time.Since(start),
+		})
+		if err != nil {
+			l.WithError(err).Error("Exec command failed")
+			return
+		}
+		l.Debug("Exec command finished")
+	}()
+
+	return nil
+}
+
+// wait waits for all running commands to finish
+func (h *execHook) wait() {
+	h.wg.Wait()
+}
@@ -0,0 +1,634 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/keys"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Default minimal fees accepted by the node's mempool
+const (
+	minimalFeeMutez       = 100
+	minimalNanotezPerGas  = 100
+	minimalNanotezPerByte = 1000
+)
+
+// Extra gas added to the simulated value to absorb small context changes between simulation and inclusion
+const gasReserve = 100
+
+// Bytes of the operation paid by the first content
+const (
+	branchSize    = 32
+	signatureSize = 64
+)
+
+// Columns of the dry run estimate table
+var estimateColumns = []utils.TableColumn{
+	{Header: "KIND", Width: 20},
+	{Header: "FEE", Width: 14, Align: utils.AlignRight},
+	{Header: "GAS LIMIT", Width: 10, Align: utils.AlignRight},
+	{Header: "STORAGE LIMIT", Width: 13, Align: utils.AlignRight},
+}
+
+type injectOptions struct {
+	fee            string
+	dryRun         bool
+	idempotencyKey string
+	skipChecks     bool
+	autoRebranch   bool
+}
+
+func addInjectFlags(cmd *cobra.Command, opt *injectOptions) {
+	if cmd.Annotations == nil {
+		cmd.Annotations = make(map[string]string)
+	}
+	cmd.Annotations[headAnnotation] = ""
+	cmd.Flags().StringVar(&opt.fee, "fee", "auto", "Fee per operation in tez, auto to estimate it from the simulated gas and size or auto:slow|normal|fast to add a premium seen in recent blocks (see stats fees)")
+	cmd.Flags().BoolVar(&opt.dryRun, "dry-run", false, "Simulate and print the estimated limits without signing and injecting")
+	cmd.Flags().StringVar(&opt.idempotencyKey, "idempotency-key", "", "Skip the injection if an operation with the same key is already in the injection journal (default is the hash of the forged bytes, which changes with the branch and the counter when the operation is forged again)")
+	cmd.Flags().BoolVar(&opt.skipChecks, "skip-checks", false, "Don't check the branch age and counters and don't preapply the signed operation before the injection")
+	cmd.Flags().BoolVar(&opt.autoRebranch, "auto-rebranch", false, "Re-forge the operation with a fresh branch and sign it again if the branch got too old while signing")
+}
+
+type operationError struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+}
+
+type operationResult struct {
+	Status                       string            `json:"status"`
+	ConsumedGas                  string            `json:"consumed_gas"`
+	ConsumedMilligas             string            `json:"consumed_milligas"`
+	PaidStorageSizeDiff          string            `json:"paid_storage_size_diff"`
+	AllocatedDestinationContract bool              `json:"allocated_destination_contract"`
+	OriginatedContracts          []string          `json:"originated_contracts"`
+	Errors                       []*operationError `json:"errors"`
+	BalanceUpdates               []*balanceUpdate  `json:"balance_updates"`
+}
+
+type balanceUpdate struct {
+	Kind     string       `json:"kind"`
+	Contract string       `json:"contract"`
+	Category string       `json:"category"`
+	Change   tezos.BigInt `json:"change"`
+	// Owner of frozen deposits, e.g. {"baker_own_stake": "tz1..."}
+	Staker map[string]interface{} `json:"staker"`
+	// Frozen balance owner before staking was introduced
+	Delegate string `json:"delegate"`
+	// Set for slashing applied at the end of the cycle
+	DelayedOperationHash string `json:"delayed_operation_hash"`
+}
+
+// gas returns consumed gas rounded up
+func (r *operationResult) gas() *big.Int {
+	if v, ok := new(big.Int).SetString(r.ConsumedMilligas, 10); ok {
+		v.Add(v, big.NewInt(999))
+		return v.Quo(v, big.NewInt(1000))
+	}
+	if v, ok := new(big.Int).SetString(r.ConsumedGas, 10); ok {
+		return v
+	}
+	return new(big.Int)
+}
+
+func (r *operationResult) storage(originationSize int64) *big.Int {
+	v, ok := new(big.Int).SetString(r.PaidStorageSizeDiff, 10)
+	if !ok {
+		v = new(big.Int)
+	}
+	n := int64(len(r.OriginatedContracts))
+	if r.AllocatedDestinationContract {
+		n++
+	}
+	return v.Add(v, big.NewInt(n*originationSize))
+}
+
+func (r *operationResult) errorIDs() string {
+	ids := make([]string, len(r.Errors))
+	for i, e := range r.Errors {
+		ids[i] = e.ID
+	}
+	return describeErrorIDs(ids)
+}
+
+type simulatedContent struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		OperationResult          *operationResult `json:"operation_result"`
+		InternalOperationResults []struct {
+			Kind   string           `json:"kind"`
+			Result *operationResult `json:"result"`
+		} `json:"internal_operation_results"`
+	} `json:"metadata"`
+}
+
+type managerConstants struct {
+	HardGasLimitPerOperation     tezos.BigInt `json:"hard_gas_limit_per_operation"`
+	HardGasLimitPerBlock         tezos.BigInt `json:"hard_gas_limit_per_block"`
+	HardStorageLimitPerOperation tezos.BigInt `json:"hard_storage_limit_per_operation"`
+	OriginationSize              int64        `json:"origination_size"`
+	CostPerByte                  tezos.BigInt `json:"cost_per_byte"`
+}
+
+var errNotRevealed = errors.New("Source is not revealed and its public key is unknown")
+
+// managerBatch holds manager operations of a single source along with the data used to simulate and forge them
+type managerBatch struct {
+	branch    string
+	constants managerConstants
+	ops       []forge.ManagerOperation
+}
+
+// estimateFee returns the minimal fee for the operation content. Bytes shared by the whole operation are paid by the first one
+func estimateFee(op forge.Operation, gas *big.Int, extraBytes int) (*big.Int, error) {
+	size, err := forge.Size(op)
+	if err != nil {
+		return nil, err
+	}
+	var fee, tmp big.Int
+	fee.Mul(gas, big.NewInt(minimalNanotezPerGas))
+	fee.Add(&fee, tmp.SetInt64(int64(size+extraBytes)*minimalNanotezPerByte))
+	fee.Add(&fee, tmp.SetInt64(minimalFeeMutez*1000+999))
+	return fee.Quo(&fee, big.NewInt(1000)), nil
+}
+
+// runOperation runs the operation against the head context without checking the signature and returns results of all contents
+func (c *RootContext) runOperation(branch string, ops []forge.ManagerOperation) ([]*simulatedContent, error) {
+	return c.runContents("head", branch, ops, len(ops))
+}
+
+// runContents is like runOperation but runs n contents given in any JSON encodable form against the block's context
+func (c *RootContext) runContents(blockID, branch string, contents interface{}, n int) ([]*simulatedContent, error) {
+	var chainID string
+	if err := c.getRPC("/chains/"+c.chainID+"/chain_id", &chainID); err != nil {
+		return nil, err
+	}
+
+	sig, err := base58.PrefixGenericSignature.Encode(make([]byte, signatureSize))
+	if err != nil {
+		return nil, err
+	}
+
+	req := map[string]interface{}{
+		"operation": map[string]interface{}{
+			"branch":    branch,
+			"contents":  contents,
+			"signature": sig,
+		},
+		"chain_id": chainID,
+	}
+
+	var res struct {
+		Contents []*simulatedContent `json:"contents"`
+	}
+	if err := c.postRPC(c.blockPath(blockID)+"/helpers/scripts/run_operation", req, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Contents) != n {
+		return nil, fmt.Errorf("Simulation returned %d results for %d operations", len(res.Contents), n)
+	}
+	for _, r := range res.Contents {
+		if r.Metadata.OperationResult == nil {
+			return nil, fmt.Errorf("%s: no operation result", r.Kind)
+		}
+	}
+	return res.Contents, nil
+}
+
+// simulateOperations is like runOperation but fails unless all contents are applied
+func (c *RootContext) simulateOperations(branch string, ops []forge.ManagerOperation) ([]*simulatedContent, error) {
+	contents, err := c.runOperation(branch, ops)
+	if err != nil {
+		return nil, err
+	}
+
+	// The failed content carries the errors while the rest of the batch is backtracked or skipped
+	var failed string
+	for _, r := range contents {
+		result := r.Metadata.OperationResult
+		if len(result.Errors) != 0 {
+			return nil, fmt.Errorf("%s simulation %s: %s", r.Kind, result.Status, result.errorIDs())
+		}
+		for _, ir := range r.Metadata.InternalOperationResults {
+			if ir.Result != nil && len(ir.Result.Errors) != 0 {
+				return nil, fmt.Errorf("%s internal %s simulation %s: %s", r.Kind, ir.Kind, ir.Result.Status, ir.Result.errorIDs())
+			}
+		}
+		if result.Status != "applied" && failed == "" {
+			failed = r.Kind + " simulation " + result.Status
+		}
+	}
+	if failed != "" {
+		return nil, errors.New(failed)
+	}
+	return contents, nil
+}
+
+// prepareBatch fills manager fields of the source's operations with next counters and maximal limits suitable for simulation.
+// Reveal is prepended if the source's public key is not known to the chain yet
+func (c *RootContext) prepareBatch(source, publicKey string, ops []forge.ManagerOperation) (*managerBatch, error) {
+	head := c.blockPath("head")
+
+	var managerKey *string
+	if err := c.getRPC(head+"/context/contracts/"+source+"/manager_key", &managerKey); err != nil {
+		return nil, err
+	}
+	if managerKey == nil {
+		if publicKey == "" {
+			return nil, errNotRevealed
+		}
+		log.WithField("address", source).Info("Public key is not revealed yet, adding reveal operation")
+		ops = append([]forge.ManagerOperation{&forge.Reveal{PublicKey: publicKey}}, ops...)
+	}
+
+	var counter tezos.BigInt
+	if err := c.getRPC(head+"/context/contracts/"+source+"/counter", &counter); err != nil {
+		return nil, err
+	}
+
+	b := managerBatch{ops: ops}
+	if err := c.getRPC(head+"/context/constants", &b.constants); err != nil {
+		return nil, err
+	}
+
+	gasLimit := new(big.Int).Quo(&b.constants.HardGasLimitPerBlock.Int, big.NewInt(int64(len(ops))))
+	if gasLimit.Cmp(&b.constants.HardGasLimitPerOperation.Int) > 0 {
+		gasLimit = &b.constants.HardGasLimitPerOperation.Int
+	}
+
+	for i, op := range ops {
+		m := op.ManagerFields()
+		m.Source = source
+		m.Counter = new(big.Int).Add(&counter.Int, big.NewInt(int64(i+1)))
+		m.Fee = big.NewInt(0)
+		m.GasLimit = gasLimit
+		m.StorageLimit = &b.constants.HardStorageLimitPerOperation.Int
+	}
+
+	if err := c.getRPC(head+"/hash", &b.branch); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// applyEstimate sets limits of the batch operations to the simulated values and fees to the minimal ones accepted by the mempool
+// multiplied by feeRatio unless userFee is given
+func (b *managerBatch) applyEstimate(results []*simulatedContent, userFee *big.Int, feeRatio float64) error {
+	for i, op := range b.ops {
+		m := op.ManagerFields()
+		res := results[i].Metadata.OperationResult
+		gas := res.gas()
+		storage := res.storage(b.constants.OriginationSize)
+		for _, ir := range results[i].Metadata.InternalOperationResults {
+			if ir.Result != nil {
+				gas.Add(gas, ir.Result.gas())
+				storage.Add(storage, ir.Result.storage(b.constants.OriginationSize))
+			}
+		}
+		m.GasLimit = gas.Add(gas, big.NewInt(gasReserve))
+		m.StorageLimit = storage
+
+		if userFee != nil && op.Kind() != protocol.KindReveal {
+			m.Fee = userFee
+			continue
+		}
+
+		var extra int
+		if i == 0 {
+			extra = branchSize + signatureSize
+		}
+		// Fee affects the size so repeat until it settles
+		for j := 0; j < 3; j++ {
+			fee, err := estimateFee(op, m.GasLimit, extra)
+			if err != nil {
+				return err
+			}
+			if fee = scaleFee(fee, feeRatio); fee.Cmp(m.Fee) == 0 {
+				break
+			}
+			m.Fee = fee
+		}
+	}
+	return nil
+}
+
+// sendOperations fills manager fields, estimates limits and fees using simulation, then signs and injects the operation.
+// Reveal is prepended if the source's public key is not known to the chain yet. Returns the operation hash
+func (c *RootContext) sendOperations(acc *account, ops []forge.ManagerOperation, opt *injectOptions) (string, error) {
+	if acc.WatchOnly() && !opt.dryRun {
+		return "", fmt.Errorf("`%s' is a watch-only key and can't sign, use --dry-run to simulate the operation", acc.Alias)
+	}
+	if opt.autoRebranch && opt.skipChecks {
+		return "", errors.New("--auto-rebranch relies on the checks disabled by --skip-checks")
+	}
+
+	var (
+		userFee  *big.Int
+		feeRatio float64 = 1
+	)
+	switch {
+	case opt.fee == "auto":
+	case strings.HasPrefix(opt.fee, "auto:"):
+		var err error
+		if feeRatio, err = c.feeRatio(strings.TrimPrefix(opt.fee, "auto:")); err != nil {
+			return "", err
+		}
+		log.WithField("ratio", feeRatio).Info("Fee is a multiple of the minimal one seen in recent blocks")
+	default:
+		var err error
+		if userFee, err = utils.ParseTez(opt.fee); err != nil {
+			return "", err
+		}
+	}
+
+	var (
+		j       *journal
+		chainID string
+	)
+	if !opt.dryRun {
+		if err := c.verifyChainID(); err != nil {
+			return "", err
+		}
+		var err error
+		if j, err = c.journal(); err != nil {
+			return "", err
+		}
+		if err := c.getRPC("/chains/"+c.chainID+"/chain_id", &chainID); err != nil {
+			return "", err
+		}
+		if opt.idempotencyKey != "" {
+			if e := j.lookup(opt.idempotencyKey, chainID); e != nil {
+				return skipInjected(e), nil
+			}
+		}
+	}
+
+	b, err := c.prepareBatch(acc.Address, acc.PublicKey, ops)
+	if err == errNotRevealed {
+		return "", fmt.Errorf("`%s' is not revealed and its public key is unknown", acc.Alias)
+	}
+	if err != nil {
+		return "", err
+	}
+	ops, branch := b.ops, b.branch
+
+	results, err := c.simulateOperations(branch, ops)
+	if err != nil {
+		return "", err
+	}
+	if err := b.applyEstimate(results, userFee, feeRatio); err != nil {
+		return "", err
+	}
+
+	if opt.dryRun {
+		return "", c.printEstimate(ops)
+	}
+
+	contents := make([]forge.Operation, len(ops))
+	for i, op := range ops {
+		contents[i] = op
+	}
+
+	var (
+		jkey   string
+		signed []byte
+	)
+	for attempt := 1; ; attempt++ {
+		forged, err := forge.Encode(branch, contents)
+		if err != nil {
+			return "", err
+		}
+
+		jkey = opt.idempotencyKey
+		if jkey == "" {
+			jkey = forgedKey(forged)
+			if e := j.lookup(jkey, chainID); e != nil {
+				return skipInjected(e), nil
+			}
+		}
+
+		// Unlocked on every attempt as signing is the step which takes time
+		sig, err := acc.signBytes(keys.LookupWatermark(keys.WatermarkGenericOperation), chainID, forged)
+		if err != nil {
+			return "", err
+		}
+		signed = append(forged, sig.Bytes...)
+
+		if opt.skipChecks {
+			break
+		}
+		op, err := newSignedOperation(branch, ops, sig)
+		if err != nil {
+			return "", err
+		}
+		err = c.checkOperation(op)
+		if _, ok := err.(*branchExpiredError); ok && opt.autoRebranch && attempt < maxRebranchAttempts {
+			log.WithError(err).Warn("Re-forging the operation with a fresh branch")
+			if err := c.getRPC(c.blockPath("head")+"/hash", &branch); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	entry := journalEntry{
+		Key:     jkey,
+		ChainID: chainID,
+		Source:  acc.Address,
+	}
+	for _, op := range ops {
+		entry.Kinds = append(entry.Kinds, op.Kind())
+	}
+	hash, err := c.injectRecorded(j, &entry, signed)
+	if err != nil {
+		return "", err
+	}
+
+	log.WithFields(log.Fields{
+		"hash":   hash,
+		"source": acc.Address,
+		"branch": branch,
+	}).Info("Operation injected")
+
+	return hash, nil
+}
+
+// skipInjected logs the journal entry preventing the injection and returns its operation hash
+func skipInjected(e *journalEntry) string {
+	log.WithFields(log.Fields{
+		"key":      e.Key,
+		"hash":     e.Hash,
+		"status":   e.Status,
+		"recorded": e.Time,
+	}).Warn("Operation is already in the injection journal, skipping the injection")
+	return e.Hash
+}
+
+// injectRecorded injects signed operation bytes keeping track of the attempt in the injection journal
+func (c *RootContext) injectRecorded(j *journal, entry *journalEntry, signed []byte) (string, error) {
+	// Recorded before the injection so a crash in between doesn't lead to sending the operation again
+	var err error
+	entry.Status = injectionPending
+	if entry.Hash, err = operationHash(signed); err != nil {
+		return "", err
+	}
+	if b, err := j.claim(entry); err != nil {
+		return "", err
+	} else if b != nil {
+		// Another process got there since the journal was loaded
+		return skipInjected(b), nil
+	}
+
+	hash, err := c.injectOperation(signed)
+	if err != nil {
+		// Only a definite rejection allows retrying, the operation may have reached the node otherwise
+		if _, ok := err.(tezos.HTTPStatus); ok {
+			entry.Status, entry.Error = injectionFailed, err.Error()
+			if err := j.record(entry); err != nil {
+				log.WithError(err).Error("Can't update the injection journal")
+			}
+		}
+		return "", explainRPCError(err)
+	}
+
+	entry.Status, entry.Hash = injectionInjected, hash
+	if err := j.record(entry); err != nil {
+		log.WithError(err).Error("Can't update the injection journal")
+	}
+	return hash, nil
+}
+
+// injectOperation injects signed operation bytes and returns the operation hash
+func (c *RootContext) injectOperation(signed []byte) (string, error) {
+	if err := c.verifyChainID(); err != nil {
+		return "", err
+	}
+	var hash string
+	if err := c.postRPC("/injection/operation?chain="+c.chainID, hex.EncodeToString(signed), &hash); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func (c *RootContext) printEstimate(ops []forge.ManagerOperation) error {
+	table := utils.NewTable(os.Stdout, estimateColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		m := op.ManagerFields()
+		err := table.WriteRow(
+			op.Kind(),
+			c.amountFormat.Format(m.Fee),
+			m.GasLimit.String(),
+			m.StorageLimit.String(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForOperation blocks until the operation is included into a block or the timeout expires
+func (c *RootContext) waitForOperation(hash string, timeout time.Duration) (*tezos.BlockInfo, error) {
+	ctx, cancel := context.WithTimeout(c.context, timeout)
+	defer cancel()
+
+	wc := *c
+	wc.context = ctx
+
+	var monErr error
+	ch := make(chan *tezos.BlockInfo, 10)
+	go func() {
+		monErr = wc.monitorHeads(ch)
+		close(ch)
+	}()
+
+	log.WithField("hash", hash).Info("Waiting for inclusion")
+
+	var (
+		included *tezos.BlockInfo
+		err      error
+	)
+	for bi := range ch {
+		if included != nil || err != nil {
+			// Drain
+			continue
+		}
+		var passes [][]string
+		if err = wc.getRPC(wc.blockPath(bi.Hash)+"/operation_hashes", &passes); err != nil {
+			cancel()
+			continue
+		}
+		if containsOperation(passes, hash) {
+			included = bi
+			cancel()
+		}
+	}
+
+	switch {
+	case included != nil:
+		log.WithFields(log.Fields{
+			"hash":  hash,
+			"block": included.Hash,
+			"level": included.Level,
+		}).Info("Operation included")
+		return included, nil
+	case err != nil:
+		return nil, err
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("Operation %s wasn't included within %v", hash, timeout)
+	default:
+		return nil, monErr
+	}
+}
+
+func containsOperation(passes [][]string, hash string) bool {
+	for _, pass := range passes {
+		for _, h := range pass {
+			if h == hash {
+				return true
+			}
+		}
+	}
+	return false
+}
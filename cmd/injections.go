@@ -0,0 +1,362 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/internal/filelock"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Injection statuses recorded in the journal
+const (
+	// The operation is about to be injected. The entry stays in this state if the client crashes in between
+	injectionPending  = "pending"
+	injectionInjected = "injected"
+	injectionFailed   = "failed"
+)
+
+// Columns of the injection list table
+var injectionColumns = []utils.TableColumn{
+	{Header: "TIME", Width: 20},
+	{Header: "KEY", Width: 24, MinWidth: 8},
+	{Header: "HASH", Width: 51, MinWidth: 13},
+	{Header: "SOURCE", Width: 36, MinWidth: 13},
+	{Header: "STATUS", Width: 8},
+	{Header: "INCLUSION", Width: 24, MinWidth: 9},
+}
+
+// journalEntry is a record of the injection journal. Records are appended, the last one with the same key and chain ID wins
+type journalEntry struct {
+	Key     string    `json:"key" yaml:"key"`
+	ChainID string    `json:"chain_id" yaml:"chain_id"`
+	Hash    string    `json:"hash" yaml:"hash"`
+	Source  string    `json:"source" yaml:"source"`
+	Kinds   []string  `json:"kinds" yaml:"kinds"`
+	Status  string    `json:"status" yaml:"status"`
+	Error   string    `json:"error,omitempty" yaml:"error,omitempty"`
+	Time    time.Time `json:"time" yaml:"time"`
+	// Filled by `injections list'
+	Level         int `json:"level,omitempty" yaml:"level,omitempty"`
+	Confirmations int `json:"confirmations,omitempty" yaml:"confirmations,omitempty"`
+}
+
+// journal is the local log of injected operations used to avoid sending the same operation twice
+type journal struct {
+	path    string
+	entries map[string]*journalEntry
+	order   []*journalEntry
+}
+
+func journalKey(key, chainID string) string {
+	return chainID + "/" + key
+}
+
+// forgedKey returns the default idempotency key derived from the forged bytes. The bytes include the branch and the counter,
+// so it only catches exact replays and an operation forged again by a retry gets a new key
+func forgedKey(forged []byte) string {
+	sum := blake2b.Sum256(forged)
+	return "forged:" + hex.EncodeToString(sum[:])
+}
+
+// operationHash returns the hash of the signed operation bytes as the node computes it
+func operationHash(signed []byte) (string, error) {
+	sum := blake2b.Sum256(signed)
+	return base58.PrefixOperationHash.Encode(sum[:])
+}
+
+// journal loads the injection journal. Missing file is treated as an empty journal
+func (c *RootContext) journal() (*journal, error) {
+	path, err := utils.ExpandHome(c.journalPath)
+	if err != nil {
+		return nil, err
+	}
+	j := journal{path: path}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// load reads the journal file replacing the entries read so far
+func (j *journal) load() error {
+	j.entries, j.order = make(map[string]*journalEntry), nil
+	fd, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fd.Close()
+
+	s := bufio.NewScanner(fd)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			// Last line may be torn by a crash
+			log.WithError(err).WithField("line", n).Warn("Skipping corrupted injection journal record")
+			continue
+		}
+		j.set(&e)
+	}
+	return s.Err()
+}
+
+// lock takes the journal lock shared with other processes
+func (j *journal) lock() (*filelock.Lock, error) {
+	return filelock.Acquire(j.path + ".lock")
+}
+
+func (j *journal) set(e *journalEntry) {
+	k := journalKey(e.Key, e.ChainID)
+	if _, ok := j.entries[k]; !ok {
+		j.order = append(j.order, e)
+	} else {
+		for i, o := range j.order {
+			if journalKey(o.Key, o.ChainID) == k {
+				j.order[i] = e
+				break
+			}
+		}
+	}
+	j.entries[k] = e
+}
+
+// lookup returns the entry blocking another injection with the key, i.e. a pending or injected one
+func (j *journal) lookup(key, chainID string) *journalEntry {
+	e, ok := j.entries[journalKey(key, chainID)]
+	if !ok || e.Status == injectionFailed {
+		return nil
+	}
+	return e
+}
+
+// claim records the pending entry unless another entry blocks its key, in which case that one is returned.
+// The journal is read again under the lock, so concurrent injections with the same key can't both get through
+func (j *journal) claim(e *journalEntry) (*journalEntry, error) {
+	l, err := j.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	if b := j.lookup(e.Key, e.ChainID); b != nil {
+		return b, nil
+	}
+	return nil, j.write(e)
+}
+
+// record appends the entry to the journal file
+func (j *journal) record(e *journalEntry) error {
+	l, err := j.lock()
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	return j.write(e)
+}
+
+func (j *journal) write(e *journalEntry) error {
+	e.Time = time.Now().UTC()
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := appendLine(j.path, buf); err != nil {
+		return err
+	}
+
+	j.set(e)
+	return nil
+}
+
+// NewInjectionsCommand returns new `injections' command
+func NewInjectionsCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		depth        int
+		allChains    bool
+		outputFormat string
+		wide         bool
+		windowOpt    windowOptions
+	)
+
+	injectionsCmd := &cobra.Command{
+		Use:         "injections",
+		Short:       "Inspect the local injection journal",
+		Annotations: map[string]string{headAnnotation: ""},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List past injections and their inclusion status",
+		Long: `List operations recorded in the injection journal (--injection-journal) on the node's chain.
+Injected operations are looked up in the last --depth blocks to show the inclusion level and the number of confirmations.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEncoder utils.NewEncoderFunc
+			if outputFormat != "text" {
+				if newEncoder = rootCtx.encoderFunc(outputFormat); newEncoder == nil || utils.IsBinary(outputFormat) || utils.IsTabular(outputFormat) {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+			}
+
+			window, err := windowOpt.window()
+			if err != nil {
+				return err
+			}
+
+			j, err := rootCtx.journal()
+			if err != nil {
+				return err
+			}
+
+			var chainID string
+			if err := rootCtx.getRPC("/chains/"+rootCtx.chainID+"/chain_id", &chainID); err != nil {
+				return err
+			}
+
+			list := make([]*journalEntry, 0, len(j.order))
+			for _, e := range j.order {
+				if (allChains || e.ChainID == chainID) && window.contains(e.Time) {
+					list = append(list, e)
+				}
+			}
+			sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+
+			head, err := rootCtx.findInclusions(list, chainID, depth)
+			if err != nil {
+				return err
+			}
+
+			if newEncoder != nil {
+				return newEncoder(os.Stdout).Encode(list)
+			}
+			return rootCtx.writeInjections(os.Stdout, list, head, depth, wide)
+		},
+	}
+
+	listCmd.Flags().IntVar(&depth, "depth", 120, "Number of recent blocks to look for injected operations in, 0 disables the lookup")
+	listCmd.Flags().BoolVar(&allChains, "all-chains", false, "List injections on all chains, not only the node's one")
+	listCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	listCmd.Flags().BoolVar(&wide, "wide", false, "Show absolute times and don't truncate table columns to fit the terminal")
+	addWindowFlags(listCmd.Flags(), &windowOpt, "injections")
+
+	injectionsCmd.AddCommand(listCmd)
+
+	return injectionsCmd
+}
+
+// findInclusions looks for injected operations of the chain in the last depth blocks and fills their levels.
+// Returns the head level
+func (c *RootContext) findInclusions(list []*journalEntry, chainID string, depth int) (int, error) {
+	wanted := make(map[string][]*journalEntry)
+	for _, e := range list {
+		if e.ChainID == chainID && e.Hash != "" && e.Status != injectionFailed {
+			wanted[e.Hash] = append(wanted[e.Hash], e)
+		}
+	}
+	if len(wanted) == 0 || depth < 1 {
+		return 0, nil
+	}
+
+	var head struct {
+		Hash  string `json:"hash"`
+		Level int    `json:"level"`
+	}
+	if err := c.getRPC(c.blockPath("head")+"/header", &head); err != nil {
+		return 0, err
+	}
+
+	var lists [][]string
+	if err := c.getRPC(fmt.Sprintf("/chains/%s/blocks?head=%s&length=%d", c.chainID, head.Hash, depth), &lists); err != nil {
+		return 0, err
+	}
+	if len(lists) == 0 {
+		return head.Level, nil
+	}
+
+	found := 0
+	for i, hash := range lists[0] {
+		var passes [][]string
+		if err := c.getRPC(c.blockPath(hash)+"/operation_hashes", &passes); err != nil {
+			return 0, err
+		}
+		for _, p := range passes {
+			for _, h := range p {
+				if entries, ok := wanted[h]; ok && entries[0].Level == 0 {
+					for _, e := range entries {
+						e.Level = head.Level - i
+						e.Confirmations = i
+					}
+					found++
+				}
+			}
+		}
+		if found == len(wanted) {
+			break
+		}
+	}
+	return head.Level, nil
+}
+
+func (c *RootContext) writeInjections(w io.Writer, list []*journalEntry, head, depth int, wide bool) error {
+	table := utils.NewTable(w, injectionColumns, listWidth(wide))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, e := range list {
+		var inclusion string
+		switch {
+		case e.Level != 0:
+			inclusion = fmt.Sprintf("%d (%d conf.)", e.Level, e.Confirmations)
+		case e.Status == injectionFailed:
+			inclusion = e.Error
+		case head != 0:
+			inclusion = fmt.Sprintf("not in last %d blocks", depth)
+		}
+		err := table.WriteRow(c.listTime(e.Time, wide), e.Key, e.Hash, e.Source, e.Status, inclusion)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
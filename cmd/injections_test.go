@@ -0,0 +1,74 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalClaim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := RootContext{journalPath: filepath.Join(dir, "injections.jsonl")}
+
+	// Two invocations loading the journal before either records anything
+	a, err := c.journal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.journal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := journalEntry{Key: "payout-42", ChainID: "NetXdQprcVkpaWU", Hash: "oo1", Status: injectionPending}
+	if blocking, err := a.claim(&first); err != nil || blocking != nil {
+		t.Fatalf("claim() = %v, %v", blocking, err)
+	}
+	second := journalEntry{Key: "payout-42", ChainID: "NetXdQprcVkpaWU", Hash: "oo2", Status: injectionPending}
+	blocking, err := b.claim(&second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocking == nil || blocking.Hash != "oo1" {
+		t.Fatalf("second claim isn't blocked by the first one: %+v", blocking)
+	}
+
+	// Failed injections can be retried
+	first.Status = injectionFailed
+	if err := a.record(&first); err != nil {
+		t.Fatal(err)
+	}
+	if blocking, err := b.claim(&second); err != nil || blocking != nil {
+		t.Fatalf("claim() after a failure = %v, %v", blocking, err)
+	}
+	// Other chains don't share keys
+	other := journalEntry{Key: "payout-42", ChainID: "NetXnHfVqm9iesp", Status: injectionPending}
+	if blocking, err := a.claim(&other); err != nil || blocking != nil {
+		t.Fatalf("claim() on another chain = %v, %v", blocking, err)
+	}
+}
@@ -0,0 +1,520 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/keyring"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the key list table
+var keyColumns = []utils.TableColumn{
+	{Header: "ALIAS", Width: 16, MinWidth: 8},
+	{Header: "ADDRESS", Width: 36, MinWidth: 13},
+	{Header: "ENCRYPTED", Width: 10},
+}
+
+// Source of aliases read from the tezos-client wallet
+const keySourceClient = "client"
+
+// Public part of the keystore entry
+type keyInfo struct {
+	Alias     string `json:"alias" yaml:"alias"`
+	Address   string `json:"address" yaml:"address"`
+	PublicKey string `json:"public_key,omitempty" yaml:"public_key,omitempty"`
+	Encrypted bool   `json:"encrypted" yaml:"encrypted"`
+	WatchOnly bool   `json:"watch_only,omitempty" yaml:"watch_only,omitempty"`
+	Baker     bool   `json:"baker,omitempty" yaml:"baker,omitempty"`
+	// Set to "client" for aliases of the tezos-client wallet
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+}
+
+func newKeyInfo(e *keys.Entry) *keyInfo {
+	return &keyInfo{
+		Alias:     e.Alias,
+		Address:   e.Address,
+		PublicKey: e.PublicKey,
+		Encrypted: e.Encrypted(),
+		WatchOnly: e.WatchOnly(),
+		Baker:     e.Baker,
+	}
+}
+
+// account is a key which may be used as an operation source. The private key is unlocked only when it's needed for signing
+type account struct {
+	*keys.Entry
+	secrets keyring.Store
+	// See signAboveHighWatermark
+	highWatermarksPath string
+	// Set by long running commands which unlock the key once
+	unlocked keys.PrivateKey
+}
+
+// passphraseSecret returns the keyring name of the key's passphrase
+func passphraseSecret(alias string) string {
+	return "key/" + alias
+}
+
+func (a *account) privateKey() (keys.PrivateKey, error) {
+	return a.Entry.PrivateKey(func() ([]byte, error) {
+		if a.secrets != nil {
+			pass, err := a.secrets.Get(passphraseSecret(a.Alias))
+			if err == nil {
+				return []byte(pass), nil
+			}
+			if err != keyring.ErrNotFound {
+				log.WithError(err).Warn("Can't read the passphrase from the keyring")
+			}
+		}
+		return utils.ReadPassphrase(fmt.Sprintf("Enter passphrase for `%s': ", a.Alias), false)
+	})
+}
+
+// NewKeyCommand returns new `key' command
+func NewKeyCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		keyType      string
+		unencrypted  bool
+		outputFormat string
+		mnemonicOpt  mnemonicOptions
+		path         string
+	)
+
+	keyCmd := &cobra.Command{
+		Use:   "key",
+		Short: "Manage keys in the local keystore",
+	}
+
+	genCmd := &cobra.Command{
+		Use:   "gen <alias>",
+		Short: "Generate new key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			k, err := keys.GeneratePrivateKey(keyType)
+			if err != nil {
+				return err
+			}
+			return rootCtx.addKey(args[0], k, unencrypted)
+		},
+	}
+
+	importCmd := &cobra.Command{
+		Use:   "import <alias> <secret key|mnemonic|-|@file>",
+		Short: "Import a secret key",
+		Long: `Import a plain or encrypted (edesk, p2esk, BLesk) secret key, a key exported in encrypted-json format or derive one
+from a BIP39 mnemonic using SLIP-10 and --path.
+Use - to read the key from stdin or @path to read it from a file so it doesn't end up in the shell history.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, err := utils.ReadInputString(args[1])
+			if err != nil {
+				return err
+			}
+
+			isJSON := strings.HasPrefix(src, "{")
+			if keys.IsMnemonic(src) && !isJSON {
+				mnemonicOpt.keyType = keyType
+				k, p, err := importMnemonic(src, path, &mnemonicOpt)
+				if err != nil {
+					return err
+				}
+				return rootCtx.addMnemonicKey(args[0], k, src, p, unencrypted)
+			}
+
+			var k keys.PrivateKey
+			if isJSON {
+				k, err = importKeyJSON(src)
+			} else if keys.IsEncrypted(src) {
+				var pass []byte
+				if pass, err = utils.ReadPassphrase("Enter passphrase: ", false); err != nil {
+					return err
+				}
+				k, err = keys.DecryptPrivateKey(src, pass)
+			} else {
+				k, err = keys.ParsePrivateKey(src)
+			}
+			if err != nil {
+				return err
+			}
+			return rootCtx.addKey(args[0], k, unencrypted)
+		},
+	}
+
+	addWatchCmd := &cobra.Command{
+		Use:   "add-watch <alias> <address|public key>",
+		Short: "Add a watch-only key",
+		Long: `Add an address or a public key without the secret key. Watch-only keys can be used by name in read-only commands,
+as destinations and as sources of --dry-run simulations but can't sign. Give the public key to simulate operations of unrevealed accounts.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.addWatchKey(args[0], args[1])
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := rootCtx.keyStore()
+			if err != nil {
+				return err
+			}
+
+			if outputFormat != "text" {
+				newEnc := rootCtx.encoderFunc(outputFormat)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+				list := make([]*keyInfo, 0, len(store.Entries)+len(store.Client))
+				for _, e := range store.Entries {
+					list = append(list, newKeyInfo(e))
+				}
+				for _, e := range store.Client {
+					info := newKeyInfo(e)
+					info.Source = keySourceClient
+					list = append(list, info)
+				}
+				return newEnc(os.Stdout).Encode(list)
+			}
+
+			table := utils.NewTable(os.Stdout, keyColumns, utils.TerminalWidth(os.Stdout))
+			if err := table.WriteHeader(); err != nil {
+				return err
+			}
+			for _, e := range append(store.Entries, store.Client...) {
+				alias := e.Alias
+				if store.IsClient(e) {
+					alias += " (" + keySourceClient + ")"
+				}
+				enc := "no"
+				switch {
+				case e.WatchOnly():
+					enc = "watch-only"
+				case e.Encrypted():
+					enc = "yes"
+				}
+				if err := table.WriteRow(alias, e.Address, enc); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show <alias|address>",
+		Short: "Show key details",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			acc, err := rootCtx.account(args[0])
+			if err != nil {
+				return err
+			}
+
+			if outputFormat != "text" {
+				newEnc := rootCtx.encoderFunc(outputFormat)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+				return newEnc(os.Stdout).Encode(newKeyInfo(acc.Entry))
+			}
+
+			fmt.Printf("Alias:      %s\nAddress:    %s\nPublic key: %s\nEncrypted:  %t\n", acc.Alias, acc.Address, acc.PublicKey, acc.Encrypted())
+			if acc.WatchOnly() {
+				fmt.Println("Watch-only: can't sign")
+			}
+			if acc.Baker {
+				fmt.Println("Baker:      consensus signing is guarded by high watermarks")
+			}
+			return nil
+		},
+	}
+
+	genCmd.Flags().StringVar(&keyType, "type", keys.TypeEd25519, "Key type: one of [ed25519, p256, bls]")
+	importCmd.Flags().StringVar(&keyType, "type", keys.TypeEd25519, "Type of the key derived from a mnemonic: one of [ed25519, p256]")
+	importCmd.Flags().StringVar(&path, "path", keys.DefaultDerivationPath, "Derivation path of the key derived from a mnemonic")
+	addMnemonicFlags(importCmd, &mnemonicOpt)
+	for _, c := range []*cobra.Command{genCmd, importCmd} {
+		c.Flags().BoolVar(&unencrypted, "unencrypted", false, "Store the secret key without a passphrase")
+	}
+	for _, c := range []*cobra.Command{listCmd, showCmd} {
+		c.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	}
+
+	keyCmd.AddCommand(genCmd)
+	keyCmd.AddCommand(importCmd)
+	keyCmd.AddCommand(addWatchCmd)
+	keyCmd.AddCommand(newKeyExportCommand(rootCtx))
+	keyCmd.AddCommand(newKeyImportClientCommand(rootCtx))
+	keyCmd.AddCommand(listCmd)
+	keyCmd.AddCommand(showCmd)
+	keyCmd.AddCommand(newKeyVanityCommand(rootCtx))
+	keyCmd.AddCommand(newKeyConvertCommand(rootCtx))
+	keyCmd.AddCommand(newKeyDiscoverCommand(rootCtx))
+	keyCmd.AddCommand(newKeySetBakerCommand(rootCtx))
+
+	return keyCmd
+}
+
+func (c *RootContext) keyStore() (*keys.Store, error) {
+	path, err := utils.ExpandHome(c.keystorePath)
+	if err != nil {
+		return nil, err
+	}
+	store, err := keys.LoadStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if c.clientDir != "" {
+		w, err := c.clientWallet()
+		if err != nil {
+			return nil, err
+		}
+		for _, err := range store.AttachClient(w) {
+			log.WithError(err).Debug("Skipping tezos-client alias")
+		}
+	}
+	return store, nil
+}
+
+// clientWallet reads the wallet from the tezos-client base directory
+func (c *RootContext) clientWallet() (*keys.ClientWallet, error) {
+	dir, err := utils.ExpandHome(c.clientDir)
+	if err != nil {
+		return nil, err
+	}
+	return keys.LoadClientWallet(dir)
+}
+
+// saveClientEntry adds the new entry to the tezos-client wallet if --client-write is set
+func (c *RootContext) saveClientEntry(e *keys.Entry) error {
+	if !c.clientWrite {
+		return nil
+	}
+	if c.clientDir == "" {
+		return errors.New("--client-write requires --client-dir")
+	}
+	w, err := c.clientWallet()
+	if err != nil {
+		return err
+	}
+	if err := w.AddEntry(e); err != nil {
+		return err
+	}
+	dir, err := utils.ExpandHome(c.clientDir)
+	if err != nil {
+		return err
+	}
+	if err := w.Save(dir); err != nil {
+		return err
+	}
+	log.WithField("alias", e.Alias).Info("Key added to the tezos-client wallet")
+	return nil
+}
+
+func (c *RootContext) addKey(alias string, k keys.PrivateKey, unencrypted bool) error {
+	return c.storeKey(alias, k, unencrypted, nil)
+}
+
+// addMnemonicKey adds the key derived from the mnemonic keeping the mnemonic for export
+func (c *RootContext) addMnemonicKey(alias string, k keys.PrivateKey, mnemonic, path string, unencrypted bool) error {
+	return c.storeKey(alias, k, unencrypted, func(e *keys.Entry, pass []byte) error {
+		return e.SetMnemonic(mnemonic, path, pass)
+	})
+}
+
+// storeKey adds the key to the keystore. The optional setup function is called with the new entry and the passphrase before saving
+func (c *RootContext) storeKey(alias string, k keys.PrivateKey, unencrypted bool, setup func(e *keys.Entry, pass []byte) error) error {
+	store, err := c.keyStore()
+	if err != nil {
+		return err
+	}
+
+	var pass []byte
+	if !unencrypted {
+		if pass, err = utils.ReadPassphrase(fmt.Sprintf("Enter new passphrase for `%s': ", alias), true); err != nil {
+			return err
+		}
+	}
+
+	e, err := keys.NewEntry(alias, k, pass)
+	if err != nil {
+		return err
+	}
+	if setup != nil {
+		if err := setup(e, pass); err != nil {
+			return err
+		}
+	}
+	if err := store.Add(e); err != nil {
+		return err
+	}
+	if err := store.Save(); err != nil {
+		return err
+	}
+	if err := c.saveClientEntry(e); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"alias":   e.Alias,
+		"address": e.Address,
+	}).Info("Key added")
+	fmt.Println(e.Address)
+	return nil
+}
+
+func (c *RootContext) addWatchKey(alias, src string) error {
+	store, err := c.keyStore()
+	if err != nil {
+		return err
+	}
+
+	var (
+		address string
+		pub     keys.PublicKey
+	)
+	switch {
+	case isAddress(src):
+		address = src
+	default:
+		if pub, err = keys.ParsePublicKey(src); err != nil {
+			return fmt.Errorf("Invalid address or public key: `%s'", src)
+		}
+		address = pub.Hash()
+	}
+	if e := store.Lookup(address); e != nil {
+		return fmt.Errorf("%s is already in the keystore as `%s'", address, e.Alias)
+	}
+
+	e, err := keys.NewWatchEntry(alias, address, pub)
+	if err != nil {
+		return err
+	}
+	if err := store.Add(e); err != nil {
+		return err
+	}
+	if err := store.Save(); err != nil {
+		return err
+	}
+	if err := c.saveClientEntry(e); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"alias":   e.Alias,
+		"address": e.Address,
+	}).Info("Watch-only key added")
+	fmt.Println(e.Address)
+	return nil
+}
+
+// account returns the keystore entry by its alias or address
+func (c *RootContext) account(name string) (*account, error) {
+	store, err := c.keyStore()
+	if err != nil {
+		return nil, err
+	}
+	e := store.Lookup(name)
+	if e == nil {
+		return nil, fmt.Errorf("Unknown key: `%s'", name)
+	}
+	secrets, err := c.secretStore()
+	if err != nil {
+		return nil, err
+	}
+	return &account{Entry: e, secrets: secrets, highWatermarksPath: c.highWatermarksPath}, nil
+}
+
+// resolveAddress returns the address of the keystore entry or the argument itself if it's a valid address
+func (c *RootContext) resolveAddress(name string) (string, error) {
+	store, err := c.keyStore()
+	if err != nil {
+		return "", err
+	}
+	if e := store.Lookup(name); e != nil {
+		return e.Address, nil
+	}
+	book, err := c.contractBook()
+	if err != nil {
+		return "", err
+	}
+	if e := book.lookup(name); e != nil {
+		return e.Address, nil
+	}
+	if c.clientDir != "" {
+		w, err := c.clientWallet()
+		if err != nil {
+			return "", err
+		}
+		if addr, ok := w.Contracts[name]; ok {
+			return addr, nil
+		}
+	}
+
+	if isAddress(name) {
+		return name, nil
+	}
+	return "", fmt.Errorf("Unknown alias or invalid address: `%s'", name)
+}
+
+// isAddress returns true if the argument is a valid implicit or originated account address
+func isAddress(s string) bool {
+	p, _, err := base58.DecodeAny(s)
+	if err != nil {
+		return false
+	}
+	switch p {
+	case base58.PrefixEd25519PublicKeyHash, base58.PrefixSecp256k1PublicKeyHash, base58.PrefixP256PublicKeyHash,
+		base58.PrefixBLS12381PublicKeyHash, base58.PrefixContractHash:
+		return true
+	}
+	return false
+}
+
+// resolvePublicKey returns the public key of the keystore entry or the argument itself if it's a valid public key
+func (c *RootContext) resolvePublicKey(name string) (string, error) {
+	store, err := c.keyStore()
+	if err != nil {
+		return "", err
+	}
+	if e := store.Lookup(name); e != nil {
+		if e.PublicKey == "" {
+			return "", fmt.Errorf("Public key of `%s' is unknown", name)
+		}
+		return e.PublicKey, nil
+	}
+
+	if _, err := keys.ParsePublicKey(name); err != nil {
+		return "", fmt.Errorf("Unknown alias or invalid public key: `%s'", name)
+	}
+	return name, nil
+}
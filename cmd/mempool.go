@@ -0,0 +1,344 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ecadlabs/tez/forge"
+	"github.com/spf13/cobra"
+)
+
+// Meaning of the mempool validation classes
+var mempoolClassDescriptions = map[string]string{
+	"validated":      "the operation is valid and waits for inclusion",
+	"applied":        "the operation is valid and waits for inclusion",
+	"branch_delayed": "the operation can't be applied on the current head but may become valid later",
+	"branch_refused": "the operation is invalid on the current branch but may become valid after a reorganization",
+	"refused":        "the operation is invalid and will never be included",
+	"outdated":       "the operation's branch is too old and it will never be included",
+	"unprocessed":    "the operation is not validated yet",
+}
+
+// All classes of the pending operations RPC in the order of precedence
+var mempoolClasses = []string{"validated", "applied", "branch_delayed", "branch_refused", "refused", "outdated", "unprocessed"}
+
+// mempoolOperation is an entry of the pending operations RPC
+type mempoolOperation struct {
+	Hash     string            `json:"hash"`
+	Branch   string            `json:"branch"`
+	Contents []json.RawMessage `json:"contents"`
+	Error    []json.RawMessage `json:"error"`
+}
+
+// decodeMempoolOperation accepts both objects and [hash, operation] pairs used by older nodes
+func decodeMempoolOperation(raw json.RawMessage) (*mempoolOperation, error) {
+	var op mempoolOperation
+	if len(raw) != 0 && raw[0] == '[' {
+		var pair []json.RawMessage
+		if err := json.Unmarshal(raw, &pair); err != nil {
+			return nil, err
+		}
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("Unexpected pending operation: %s", raw)
+		}
+		if err := json.Unmarshal(pair[1], &op); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(pair[0], &op.Hash); err != nil {
+			return nil, err
+		}
+		return &op, nil
+	}
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// pendingOperations returns the mempool operations by class
+func (c *RootContext) pendingOperations() (map[string][]*mempoolOperation, error) {
+	var pending map[string][]json.RawMessage
+	if err := c.getRPC("/chains/"+c.chainID+"/mempool/pending_operations", &pending); err != nil {
+		return nil, err
+	}
+	res := make(map[string][]*mempoolOperation, len(pending))
+	for class, list := range pending {
+		ops := make([]*mempoolOperation, len(list))
+		for i, raw := range list {
+			op, err := decodeMempoolOperation(raw)
+			if err != nil {
+				return nil, err
+			}
+			ops[i] = op
+		}
+		res[class] = ops
+	}
+	return res, nil
+}
+
+// NewMempoolCommand returns new `mempool' command
+func NewMempoolCommand(rootCtx *RootContext) *cobra.Command {
+	mempoolCmd := &cobra.Command{
+		Use:         "mempool",
+		Short:       "Inspect pending operations",
+		Annotations: map[string]string{headAnnotation: ""},
+	}
+
+	mempoolCmd.AddCommand(newMempoolExplainCommand(rootCtx))
+	mempoolCmd.AddCommand(newMempoolStatsCommand(rootCtx))
+
+	return mempoolCmd
+}
+
+// mempoolError is a node error attached to a pending operation
+type mempoolError struct {
+	ID      string                 `json:"id" yaml:"id"`
+	Kind    string                 `json:"kind" yaml:"kind"`
+	Hint    string                 `json:"hint,omitempty" yaml:"hint,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty" yaml:"details,omitempty"`
+}
+
+// mempoolExplanation is the validation status of a pending operation along with the suggested fixes
+type mempoolExplanation struct {
+	Hash        string          `json:"hash" yaml:"hash"`
+	Class       string          `json:"class" yaml:"class"`
+	Description string          `json:"description" yaml:"description"`
+	Branch      string          `json:"branch" yaml:"branch"`
+	Errors      []*mempoolError `json:"errors" yaml:"errors"`
+	Suggestions []string        `json:"suggestions" yaml:"suggestions"`
+}
+
+func newMempoolExplainCommand(rootCtx *RootContext) *cobra.Command {
+	var format string
+
+	explainCmd := &cobra.Command{
+		Use:   "explain <operation hash>",
+		Short: "Explain why a pending operation is delayed or refused",
+		Long: `Look up the operation in the mempool and report its validation class along with the node's errors decoded into
+human-readable explanations. The branch, the counters and the fee of the operation are also checked against the
+head to suggest a fix: bump the fee, re-forge with the current counter or refresh the branch.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && rootCtx.encoderFunc(format) == nil {
+				return fmt.Errorf("Unknown output encoding: `%s'", format)
+			}
+			e, err := rootCtx.explainPendingOperation(args[0])
+			if err != nil {
+				return err
+			}
+			if format != "text" {
+				return rootCtx.encoderFunc(format)(os.Stdout).Encode(e)
+			}
+			return rootCtx.writeMempoolExplanation(os.Stdout, e)
+		},
+	}
+
+	explainCmd.Flags().StringVarP(&format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+
+	return explainCmd
+}
+
+func (c *RootContext) explainPendingOperation(hash string) (*mempoolExplanation, error) {
+	pending, err := c.pendingOperations()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		op    *mempoolOperation
+		class string
+	)
+	for _, cl := range mempoolClasses {
+		for _, o := range pending[cl] {
+			if o.Hash == hash {
+				op, class = o, cl
+				break
+			}
+		}
+		if op != nil {
+			break
+		}
+	}
+	if op == nil {
+		return nil, fmt.Errorf("Operation %s is not in the mempool: it was either included, see `tez receipt', or dropped by the node", hash)
+	}
+
+	e := mempoolExplanation{
+		Hash:        hash,
+		Class:       class,
+		Description: mempoolClassDescriptions[class],
+		Branch:      op.Branch,
+		Errors:      []*mempoolError{},
+		Suggestions: []string{},
+	}
+
+	seen := make(map[string]bool)
+	suggest := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			e.Suggestions = append(e.Suggestions, s)
+		}
+	}
+
+	for _, raw := range op.Error {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, err
+		}
+		me := mempoolError{Details: make(map[string]interface{})}
+		for k, v := range fields {
+			switch k {
+			case "id":
+				me.ID, _ = v.(string)
+			case "kind":
+				me.Kind, _ = v.(string)
+			default:
+				me.Details[k] = v
+			}
+		}
+		if me.Hint = operationErrorHint(me.ID); me.Hint != "" {
+			suggest(strings.ToUpper(me.Hint[:1]) + me.Hint[1:])
+		}
+		e.Errors = append(e.Errors, &me)
+	}
+
+	// Check the operation against the head
+	if err := c.checkBranch(op.Branch); err != nil {
+		if !isOperationCheckError(err) {
+			return nil, err
+		}
+		suggest(err.Error())
+	}
+	if err := c.checkCounters(op.Contents); err != nil {
+		if !isOperationCheckError(err) {
+			return nil, err
+		}
+		suggest(err.Error())
+	}
+	if msg := checkOperationFee(op.Contents); msg != "" {
+		suggest(msg)
+	}
+
+	return &e, nil
+}
+
+func isOperationCheckError(err error) bool {
+	switch err.(type) {
+	case operationCheckError, *branchExpiredError:
+		return true
+	}
+	return false
+}
+
+// checkOperationFee compares the fee of the manager operations with the mempool minimum
+func checkOperationFee(contents []json.RawMessage) string {
+	var (
+		fee, gas = new(big.Int), new(big.Int)
+		size     = branchSize + signatureSize
+	)
+	for _, raw := range contents {
+		var el feeContent
+		if err := json.Unmarshal(raw, &el); err != nil {
+			return ""
+		}
+		fo := el.forgeOperation()
+		if fo == nil {
+			return ""
+		}
+		n, err := forge.Size(fo)
+		if err != nil {
+			return ""
+		}
+		size += n
+		fee.Add(fee, &el.Fee.Int)
+		gas.Add(gas, &el.GasLimit.Int)
+	}
+	if len(contents) == 0 {
+		return ""
+	}
+	if min := minimalFee(gas, size); fee.Cmp(min) < 0 {
+		return fmt.Sprintf("Fee %s mutez is below the minimal %s mutez for %s gas and %d bytes: bump the fee", fee, min, gas, size)
+	}
+	return ""
+}
+
+func (c *RootContext) writeMempoolExplanation(w io.Writer, e *mempoolExplanation) error {
+	class := e.Class
+	switch class {
+	case "validated", "applied":
+		class = c.colorizer.Green(class).String()
+	case "branch_delayed", "unprocessed":
+		class = c.colorizer.Yellow(class).String()
+	default:
+		class = c.colorizer.Red(class).String()
+	}
+	if _, err := fmt.Fprintf(w, "Operation:  %s\nBranch:     %s\nClass:      %s: %s\n", e.Hash, e.Branch, class, e.Description); err != nil {
+		return err
+	}
+
+	if len(e.Errors) != 0 {
+		if _, err := fmt.Fprintln(w, "\nErrors:"); err != nil {
+			return err
+		}
+	}
+	for _, me := range e.Errors {
+		line := fmt.Sprintf("  %s (%s)", me.ID, me.Kind)
+		if me.Hint != "" {
+			line += ": " + me.Hint
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(me.Details))
+		for k := range me.Details {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, err := json.Marshal(me.Details[k])
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "    %s: %s\n", k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(e.Suggestions) != 0 {
+		if _, err := fmt.Fprintln(w, "\nSuggested fixes:"); err != nil {
+			return err
+		}
+	}
+	for _, s := range e.Suggestions {
+		if _, err := fmt.Fprintf(w, "  - %s\n", s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
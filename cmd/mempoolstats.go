@@ -0,0 +1,292 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/mattn/go-isatty"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Classes of operations which may still be included
+var includableMempoolClasses = map[string]bool{
+	"validated":      true,
+	"applied":        true,
+	"branch_delayed": true,
+	"unprocessed":    true,
+}
+
+// Upper bounds of the branch age buckets in blocks
+var mempoolAgeBuckets = []int{2, 10, 30, 120}
+
+var countColumns = []utils.TableColumn{
+	{Header: "", Width: 24},
+	{Header: "COUNT", Width: 8, Align: utils.AlignRight},
+}
+
+type mempoolStatsOptions struct {
+	watch     bool
+	interval  time.Duration
+	format    string
+	windowOpt windowOptions
+}
+
+// mempoolCount is a number of pending operations in a group
+type mempoolCount struct {
+	Name  string `json:"name" yaml:"name"`
+	Count int    `json:"count" yaml:"count"`
+}
+
+// mempoolStats is a snapshot of the mempool. The age of an operation is the number of blocks since its branch
+type mempoolStats struct {
+	Timestamp time.Time       `json:"timestamp" yaml:"timestamp"`
+	HeadLevel int             `json:"head_level" yaml:"head_level"`
+	Total     int             `json:"total" yaml:"total"`
+	FeesMutez *big.Int        `json:"fees_mutez" yaml:"fees_mutez"`
+	Classes   []*mempoolCount `json:"classes" yaml:"classes"`
+	Kinds     []*mempoolCount `json:"kinds" yaml:"kinds"`
+	Ages      []*mempoolCount `json:"ages" yaml:"ages"`
+}
+
+func newMempoolStatsCommand(rootCtx *RootContext) *cobra.Command {
+	var opt mempoolStatsOptions
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Summarize pending operations",
+		Long: `Count pending operations by validation class and by kind, sum the fees of the operations which may still be
+included and show how many blocks old their branches are. With --watch the summary is refreshed every --interval,
+in place when the output is a terminal.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opt.format != "text" && rootCtx.encoderFunc(opt.format) == nil {
+				return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+			}
+			if opt.watch && opt.format != "text" && opt.format != "json" {
+				return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+			}
+			return rootCtx.mempoolStats(&opt)
+		},
+	}
+
+	f := statsCmd.Flags()
+	f.BoolVar(&opt.watch, "watch", false, "Refresh the summary periodically")
+	f.DurationVar(&opt.interval, "interval", 2*time.Second, "Refresh interval used with --watch")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json], only text and json with --watch")
+	addWindowFlags(f, &opt.windowOpt, "summaries taken in watch mode")
+
+	return statsCmd
+}
+
+func (c *RootContext) mempoolStats(opt *mempoolStatsOptions) error {
+	window, err := opt.windowOpt.window()
+	if err != nil {
+		return err
+	}
+	if window != nil && !opt.watch {
+		return errors.New("--since and --until require --watch")
+	}
+
+	// Levels of the branches seen so far
+	branches := make(map[string]int)
+	inPlace := opt.watch && opt.format == "text" && isatty.IsTerminal(os.Stdout.Fd())
+
+	ticker := time.NewTicker(opt.interval)
+	defer ticker.Stop()
+	for {
+		s, err := c.collectMempoolStats(branches)
+		if err != nil {
+			return err
+		}
+		if window.ended(s.Timestamp) {
+			return nil
+		}
+
+		switch {
+		case !window.contains(s.Timestamp):
+			// Not yet --since
+		case opt.format == "json" && opt.watch:
+			err = json.NewEncoder(os.Stdout).Encode(s)
+		case opt.format != "text":
+			err = c.encoderFunc(opt.format)(os.Stdout).Encode(s)
+		default:
+			if inPlace {
+				// Move the cursor home and clear the screen
+				fmt.Print("\x1b[H\x1b[2J")
+			}
+			err = c.writeMempoolStats(os.Stdout, s)
+		}
+		if err != nil || !opt.watch {
+			return err
+		}
+		if !inPlace && opt.format == "text" && window.contains(s.Timestamp) {
+			fmt.Println()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.context.Done():
+			return nil
+		}
+	}
+}
+
+func (c *RootContext) collectMempoolStats(branches map[string]int) (*mempoolStats, error) {
+	var head struct {
+		Level int `json:"level"`
+	}
+	if err := c.getRPC(c.blockPath("head")+"/header", &head); err != nil {
+		return nil, err
+	}
+	pending, err := c.pendingOperations()
+	if err != nil {
+		return nil, err
+	}
+
+	s := mempoolStats{
+		Timestamp: time.Now(),
+		HeadLevel: head.Level,
+		FeesMutez: new(big.Int),
+		Classes:   []*mempoolCount{},
+		Kinds:     []*mempoolCount{},
+		Ages:      make([]*mempoolCount, len(mempoolAgeBuckets)+2),
+	}
+	lo := 0
+	for i, hi := range mempoolAgeBuckets {
+		s.Ages[i] = &mempoolCount{Name: fmt.Sprintf("%d-%d blocks", lo, hi)}
+		lo = hi + 1
+	}
+	s.Ages[len(mempoolAgeBuckets)] = &mempoolCount{Name: fmt.Sprintf("over %d blocks", lo-1)}
+	s.Ages[len(mempoolAgeBuckets)+1] = &mempoolCount{Name: "unknown branch"}
+
+	kinds := make(map[string]int)
+	for _, class := range mempoolClasses {
+		ops := pending[class]
+		if len(ops) == 0 {
+			continue
+		}
+		s.Classes = append(s.Classes, &mempoolCount{Name: class, Count: len(ops)})
+		s.Total += len(ops)
+
+		for _, op := range ops {
+			kind := "unknown"
+			for _, raw := range op.Contents {
+				var el feeContent
+				if err := json.Unmarshal(raw, &el); err != nil {
+					return nil, err
+				}
+				// A batch is counted by the first content following the reveal
+				if kind == "unknown" || kind == "reveal" {
+					kind = el.Kind
+				}
+				if includableMempoolClasses[class] && el.Fee != nil {
+					s.FeesMutez.Add(s.FeesMutez, &el.Fee.Int)
+				}
+			}
+			kinds[kind]++
+
+			level, ok := branches[op.Branch]
+			if !ok && op.Branch != "" {
+				var header struct {
+					Level int `json:"level"`
+				}
+				err := c.getRPC(c.blockPath(op.Branch)+"/header", &header)
+				switch {
+				case err == nil:
+					level = header.Level
+				case isNotFound(err):
+					level = -1
+				default:
+					return nil, err
+				}
+				branches[op.Branch] = level
+			}
+			if op.Branch == "" || level < 0 {
+				s.Ages[len(s.Ages)-1].Count++
+				continue
+			}
+			age := head.Level - level
+			i := sort.SearchInts(mempoolAgeBuckets, age)
+			s.Ages[i].Count++
+		}
+	}
+
+	for k, n := range kinds {
+		s.Kinds = append(s.Kinds, &mempoolCount{Name: k, Count: n})
+	}
+	sort.Slice(s.Kinds, func(i, j int) bool {
+		if s.Kinds[i].Count != s.Kinds[j].Count {
+			return s.Kinds[i].Count > s.Kinds[j].Count
+		}
+		return s.Kinds[i].Name < s.Kinds[j].Name
+	})
+
+	log.WithFields(log.Fields{
+		"head_level": s.HeadLevel,
+		"total":      s.Total,
+	}).Debug("Mempool stats collected")
+
+	return &s, nil
+}
+
+func (c *RootContext) writeMempoolStats(w io.Writer, s *mempoolStats) error {
+	_, err := fmt.Fprintf(w, "%s  head %d  pending %d  fees %s\n", c.localTime(s.Timestamp).Format("2006-01-02 15:04:05"), s.HeadLevel, s.Total, c.amountFormat.Format(s.FeesMutez))
+	if err != nil {
+		return err
+	}
+
+	width := utils.TerminalWidth(os.Stdout)
+	for _, t := range []struct {
+		header string
+		rows   []*mempoolCount
+	}{
+		{"CLASS", s.Classes},
+		{"KIND", s.Kinds},
+		{"BRANCH AGE", s.Ages},
+	} {
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+		columns := append([]utils.TableColumn(nil), countColumns...)
+		columns[0].Header = t.header
+		table := utils.NewTable(w, columns, width)
+		if err := table.WriteHeader(); err != nil {
+			return err
+		}
+		for _, r := range t.rows {
+			if err := table.WriteRow(r.Name, strconv.Itoa(r.Count)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
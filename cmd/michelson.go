@@ -0,0 +1,189 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/michelson"
+	"github.com/spf13/cobra"
+)
+
+// NewMichelsonCommand returns new `michelson' command
+func NewMichelsonCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		typeSrc       string
+		valueSrc      string
+		outputFormat  string
+		inputEncoding string
+	)
+
+	michelsonCmd := &cobra.Command{
+		Use:   "michelson",
+		Short: "Michelson data encoding tools",
+	}
+
+	packCmd := &cobra.Command{
+		Use:   "pack",
+		Short: "Serialize Michelson value the same way PACK instruction does",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			packed, err := packValue(typeSrc, valueSrc, inputEncoding)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("0x" + hex.EncodeToString(packed))
+			return nil
+		},
+	}
+
+	hashCmd := &cobra.Command{
+		Use:   "hash-expr",
+		Short: "Calculate script expression hash (exprXXX) of Michelson value, i.e. a big map key hash",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			packed, err := packValue(typeSrc, valueSrc, inputEncoding)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(michelson.ExprHash(packed))
+			return nil
+		},
+	}
+
+	unpackCmd := &cobra.Command{
+		Use:   "unpack <hex|-|@file>",
+		Short: "Deserialize PACK output",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, err := utils.ReadInputString(args[0])
+			if err != nil {
+				return err
+			}
+			data, err := hex.DecodeString(strings.TrimPrefix(src, "0x"))
+			if err != nil {
+				return err
+			}
+
+			var value *michelson.Node
+			if typeSrc != "" {
+				typ, err := parseMichelsonInput(typeSrc)
+				if err != nil {
+					return err
+				}
+				value, err = michelson.UnpackTyped(data, typ)
+				if err != nil {
+					return err
+				}
+			} else {
+				if value, err = michelson.Unpack(data); err != nil {
+					return err
+				}
+			}
+
+			if outputFormat == "text" {
+				fmt.Println(value)
+				return nil
+			}
+			switch outputFormat {
+			case "json", "msgpack", "cbor":
+				return rootCtx.encoderFunc(outputFormat)(os.Stdout).Encode(value)
+			}
+			return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+		},
+	}
+
+	michelsonCmd.PersistentFlags().StringVarP(&typeSrc, "type", "t", "", "Michelson type (text or Micheline JSON); - reads it from stdin and @path from the file")
+	for _, c := range []*cobra.Command{packCmd, hashCmd} {
+		c.Flags().StringVarP(&valueSrc, "value", "v", "", "Michelson value (text or Micheline JSON); - reads it from stdin and @path from the file")
+		c.Flags().StringVar(&inputEncoding, "input-encoding", "text", "Value encoding: one of [text, json, msgpack, cbor]. Binary encoded Micheline is read from stdin unless --value is given")
+	}
+	unpackCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, json, msgpack, cbor]")
+
+	michelsonCmd.AddCommand(packCmd)
+	michelsonCmd.AddCommand(unpackCmd)
+	michelsonCmd.AddCommand(hashCmd)
+
+	return michelsonCmd
+}
+
+// parseMichelsonInput parses Michelson expression given inline, as `-' or `@file'
+func parseMichelsonInput(src string) (*michelson.Node, error) {
+	text, err := utils.ReadInputString(src)
+	if err != nil {
+		return nil, err
+	}
+	return michelson.ParseAny(text)
+}
+
+func packValue(typeSrc, valueSrc, inputEncoding string) ([]byte, error) {
+	var (
+		value *michelson.Node
+		err   error
+	)
+
+	switch inputEncoding {
+	case "text", "json":
+		if valueSrc == "" {
+			return nil, errors.New("Value is required")
+		}
+		if value, err = parseMichelsonInput(valueSrc); err != nil {
+			return nil, err
+		}
+
+	case "msgpack", "cbor":
+		if valueSrc == "" {
+			valueSrc = "-"
+		}
+		data, err := utils.ReadInput(valueSrc)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := utils.DecodeToJSON(inputEncoding, data)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = michelson.ParseAny(string(buf)); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown input encoding: `%s'", inputEncoding)
+	}
+
+	if typeSrc == "" {
+		// Untyped value is packed as is
+		return michelson.Pack(value)
+	}
+
+	typ, err := parseMichelsonInput(typeSrc)
+	if err != nil {
+		return nil, err
+	}
+	return michelson.PackTyped(value, typ)
+}
@@ -0,0 +1,174 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"strconv"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Number of levels to remember seen block hashes for
+const monitorDedupDepth = 128
+
+// NewMonitorCommand returns new `monitor' command
+func NewMonitorCommand(rootCtx *RootContext) *cobra.Command {
+	monitorCmd := &cobra.Command{
+		Use:         "monitor",
+		Short:       "Monitor the chain load",
+		Annotations: map[string]string{headAnnotation: ""},
+	}
+
+	monitorCmd.AddCommand(newCongestionCommand(rootCtx))
+
+	return monitorCmd
+}
+
+// headsMonitor keeps track of delivered heads across reconnections
+type headsMonitor struct {
+	ctx  *RootContext
+	last *tezos.BlockInfo
+	seen map[string]int // hash -> level
+	// Delivered chain by level, used to find orphaned blocks on reorgs
+	chain   map[int]*tezos.BlockInfo
+	chainID string
+}
+
+// monitorHeads streams new heads to results. Duplicates are dropped and skipped levels
+// (e.g. produced during reconnection) are fetched explicitly
+func (c *RootContext) monitorHeads(results chan<- *tezos.BlockInfo) error {
+	return c.monitorHeadsFrom(nil, results)
+}
+
+// monitorHeadsFrom is like monitorHeads but also backfills blocks produced after the last one
+func (c *RootContext) monitorHeadsFrom(last *tezos.BlockInfo, results chan<- *tezos.BlockInfo) error {
+	m := headsMonitor{
+		ctx:   c,
+		last:  last,
+		seen:  make(map[string]int),
+		chain: make(map[int]*tezos.BlockInfo),
+	}
+	if last != nil {
+		m.chain[last.Level] = last
+	}
+
+	// Some endpoints closes connection
+	for {
+		ctx, cancel := context.WithCancel(c.context)
+		ch := make(chan *tezos.BlockInfo, 10)
+		var err error
+		go func() {
+			err = c.service.MonitorHeads(ctx, c.chainID, ch)
+			close(ch)
+		}()
+
+		for bi := range ch {
+			if e := m.push(bi, results); e != nil {
+				// Stop the stream and drain
+				cancel()
+				for range ch {
+				}
+				return e
+			}
+		}
+		cancel()
+
+		if err != nil {
+			return err
+		}
+		log.Debug("Heads monitor connection closed, reconnecting")
+	}
+}
+
+func (m *headsMonitor) push(bi *tezos.BlockInfo, results chan<- *tezos.BlockInfo) error {
+	if _, ok := m.seen[bi.Hash]; ok {
+		return nil
+	}
+
+	if m.last != nil && bi.Level > m.last.Level+1 {
+		log.WithFields(log.Fields{
+			"from": m.last.Level + 1,
+			"to":   bi.Level - 1,
+		}).Debug("Heads monitor gap detected")
+
+		for level := m.last.Level + 1; level < bi.Level; level++ {
+			block, err := m.ctx.service.GetBlock(m.ctx.context, m.ctx.chainID, strconv.Itoa(level))
+			if err != nil {
+				return err
+			}
+
+			err = m.emit(&tezos.BlockInfo{
+				Hash:        block.Hash,
+				Level:       block.Header.Level,
+				Predecessor: block.Header.Predecessor,
+				Timestamp:   block.Header.Timestamp,
+			}, results)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return m.emit(bi, results)
+}
+
+// emit sends the block to the consumer. Returns the context error if the consumer has gone
+func (m *headsMonitor) emit(bi *tezos.BlockInfo, results chan<- *tezos.BlockInfo) error {
+	if _, ok := m.seen[bi.Hash]; ok {
+		return nil
+	}
+
+	if m.last != nil && bi.Predecessor != m.last.Hash {
+		m.reorg(bi)
+	}
+
+	m.seen[bi.Hash] = bi.Level
+	m.last = bi
+	m.chain[bi.Level] = bi
+
+	if m.ctx.cache != nil {
+		m.ctx.cache.SetHead(bi.Level)
+	}
+
+	// Forget old hashes
+	if len(m.seen) > 2*monitorDedupDepth {
+		for h, l := range m.seen {
+			if l < bi.Level-monitorDedupDepth {
+				delete(m.seen, h)
+			}
+		}
+		for l := range m.chain {
+			if l < bi.Level-monitorDedupDepth {
+				delete(m.chain, l)
+			}
+		}
+	}
+
+	select {
+	case results <- bi:
+		return nil
+	case <-m.ctx.context.Done():
+		return m.ctx.context.Err()
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	log "github.com/sirupsen/logrus"
+)
+
+// NetworkCustom means the end-point is given explicitly and the chain is not checked
+const NetworkCustom = "custom"
+
+type network struct {
+	URL       string
+	ChainID   string
+	FaucetURL string
+	// Block explorer used for links to blocks and operations
+	ExplorerURL string
+	// Default RPC request rate used with the preset URL
+	RateLimit float64
+}
+
+// Requests per second allowed to public end-points unless set explicitly
+const publicRateLimit = 20
+
+// Built-in network presets
+var networks = map[string]*network{
+	"mainnet": {
+		URL:         "https://mainnet.api.tez.ie/",
+		ChainID:     "NetXdQprcVkpaWU",
+		ExplorerURL: "https://tzkt.io",
+		RateLimit:   publicRateLimit,
+	},
+	"ghostnet": {
+		URL:         "https://rpc.ghostnet.teztnets.com/",
+		ChainID:     "NetXnHfVqm9iesp",
+		FaucetURL:   "https://faucet.ghostnet.teztnets.com",
+		ExplorerURL: "https://ghostnet.tzkt.io",
+		RateLimit:   publicRateLimit,
+	},
+	"nairobinet": {
+		URL:         "https://rpc.nairobinet.teztnets.com/",
+		ChainID:     "NetXyuzvDo2Ugzb",
+		FaucetURL:   "https://faucet.nairobinet.teztnets.com",
+		ExplorerURL: "https://nairobinet.tzkt.io",
+		RateLimit:   publicRateLimit,
+	},
+	NetworkCustom: {},
+}
+
+func networkNames() []string {
+	names := make([]string, 0, len(networks))
+	for n := range networks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// chainCheck is the outcome of the chain ID verification, shared by the copies of the root context
+type chainCheck struct {
+	mu       sync.Mutex
+	verified bool
+	err      error
+}
+
+// verifyChainID checks the node's chain ID against the expected one once per invocation
+func (c *RootContext) verifyChainID() error {
+	c.chainCheck.mu.Lock()
+	defer c.chainCheck.mu.Unlock()
+
+	if c.expectedChainID == "" || c.chainCheck.verified {
+		return nil
+	}
+	if c.chainCheck.err != nil {
+		return c.chainCheck.err
+	}
+
+	// Bypass the guard below, it calls back here
+	client, err := tezos.NewRPCClient(&http.Client{Transport: c.nodeTransport}, c.tezosURL)
+	if err != nil {
+		return err
+	}
+	req, err := client.NewRequest(c.context, http.MethodGet, "/chains/"+c.chainID+"/chain_id", nil)
+	if err != nil {
+		return err
+	}
+	var id string
+	if err := client.Do(req, &id); err != nil {
+		return err
+	}
+	if id != c.expectedChainID {
+		log.WithFields(log.Fields{
+			"expected": c.expectedChainID,
+			"actual":   id,
+			"url":      c.tezosURL,
+		}).Error("Chain ID mismatch")
+		c.chainCheck.err = fmt.Errorf("Chain ID mismatch: %s expects %s but the node at %s is on %s", c.chainIDSource, c.expectedChainID, c.tezosURL, id)
+		return c.chainCheck.err
+	}
+	c.chainCheck.verified = true
+	return nil
+}
+
+// chainIDGuard verifies the chain ID before the first request reaching the node,
+// so commands working offline never talk to it
+type chainIDGuard struct {
+	Transport http.RoundTripper
+	ctx       *RootContext
+}
+
+// RoundTrip implements http.RoundTripper
+func (g *chainIDGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := g.ctx.verifyChainID(); err != nil {
+		return nil, err
+	}
+	return g.Transport.RoundTrip(req)
+}
@@ -22,21 +22,28 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
-	"os"
-	"text/template"
+	"strconv"
 
 	tezos "github.com/ecadlabs/go-tezos"
 	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/crawler"
+	"github.com/ecadlabs/tez/protocol"
 	"github.com/spf13/cobra"
 )
 
-const operationsTemplateSrc = `   BLOCK TYPE         FROM                                 TO                                           AMOUNT            FEE HASH
-{{range . -}}
-{{printf "%8d" .Block.Header.Level}} {{or .Title .Kind | printf "%-12.12s"}} {{or .Source "--" | printf "%-36.36s"}} {{or .Destination "--" | printf "%-36.36s"}} {{if .Amount}}{{printf "%12.6f ꜩ" .Amount}}{{else}}            --{{end}} {{if .Fee}}{{printf "%12.6f ꜩ" .Fee}}{{else}}            --{{end}} {{.Hash}}
-{{end -}}
-`
+// Columns of the operations table. Hashes and addresses are ellipsized to fit the terminal
+var operationsColumns = []utils.TableColumn{
+	{Header: "BLOCK", Width: 8, Align: utils.AlignRight},
+	{Header: "TYPE", Width: 12, MinWidth: 8},
+	{Header: "FROM", Width: 36, MinWidth: 13},
+	{Header: "TO", Width: 36, MinWidth: 13},
+	{Header: "AMOUNT", Width: 14, Align: utils.AlignRight},
+	{Header: "FEE", Width: 14, Align: utils.AlignRight},
+	{Header: "HASH", Width: 51, MinWidth: 13},
+}
 
 // brief block info suitable for the template rendering
 type opInfo struct {
@@ -44,77 +51,200 @@ type opInfo struct {
 	Kind        string
 	Title       string
 	Destination string
+	AmountMutez *big.Int
+	FeeMutez    *big.Int
 	Amount      *big.Float
 	Fee         *big.Float
 	Hash        string
+	Counter     string
 	Block       *xblockInfo
+	// Undecoded element for kinds not supported by the client library or the block's protocol
+	Raw map[string]interface{}
 }
 
 func newBlockOperationsCommand(ctx *BlockCommandContext) *cobra.Command {
-	var opKinds []string
+	var (
+		opKinds []string
+		groupBy string
+		trace   traceOptions
+	)
 
 	operationsCmd := &cobra.Command{
 		Use:     "operations",
 		Aliases: []string{"op"},
 		Short:   "Inspect block operations",
 
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 {
-				args = []string{"head"}
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			defer ctx.closeOutput(&err)
+			args, err = ctx.blockArgs(args)
+			if err != nil {
+				return err
+			}
+
+			if ctx.dot {
+				if ctx.watch {
+					return errors.New("DOT output can't be used with --watch")
+				}
+				if groupBy != "" {
+					return errors.New("--group-by can't be used with DOT output")
+				}
+				trace.format = "dot"
+			}
+
+			if trace.address != "" {
+				return ctx.traceAddress(args, &trace)
+			}
+
+			var groupKey func(op *opInfo) string
+			if groupBy != "" {
+				if groupKey, err = opGroupKey(groupBy); err != nil {
+					return err
+				}
+				if ctx.watch {
+					return errors.New("--group-by can't be used with --watch")
+				}
+				if ctx.tabular && !ctx.report {
+					return errors.New("Grouped operations can't be written in a tabular encoding, use csv instead")
+				}
+			} else if ctx.csv {
+				return errCSVGroupOnly
 			}
 
 			var kinds map[string]struct{}
 			if len(opKinds) != 0 {
 				kinds = make(map[string]struct{}, len(opKinds))
 				for _, kind := range opKinds {
-					if k, ok := knownKinds[kind]; ok {
-						kinds[k] = struct{}{}
-					} else {
-						return fmt.Errorf("Unknown operation kind: `%s'", k)
+					if kind == "all" {
+						kinds = nil
+						break
+					}
+					if k, ok := kindAliases[kind]; ok {
+						kind = k
 					}
+					if !protocol.IsKnownKind(kind) {
+						return fmt.Errorf("Unknown operation kind: `%s'", kind)
+					}
+					kinds[kind] = struct{}{}
 				}
 			}
 
-			var enc utils.Encoder
-			if ctx.newEncoder != nil {
-				enc = ctx.newEncoder(os.Stdout)
+			expr, err := ctx.eventFilter(opEnv(nil))
+			if err != nil {
+				return err
 			}
 
-			// Standard template
-			tpl, err := template.New("operation").Funcs(ctx.templateFuncMap).Parse(operationsTemplateSrc)
+			hook, err := ctx.execHook()
 			if err != nil {
 				return err
 			}
 
+			sinks, err := ctx.eventSinks(operationSinkKey)
+			if err != nil {
+				return err
+			}
+			if sinks != nil {
+				defer sinks.close()
+			}
+
+			var enc utils.Encoder
+			if ctx.newEncoder != nil {
+				enc = ctx.newEncoder(ctx.output)
+				defer closeEncoder(enc, &err)
+			}
+
+			// Block operations matching the kind filter and the expression
+			selectOps := func(b *xblock) ([]*opInfo, error) {
+				ops, err := filterOperations(expr, getBlockOperations(getBlockInfo(b), kinds))
+				if err != nil || sinks == nil {
+					return ops, err
+				}
+				for _, op := range ops {
+					if err := sinks.publish(op, newOpEvent(op)); err != nil {
+						return nil, err
+					}
+				}
+				return ops, nil
+			}
+
+			// Undecoded counterpart of selected operations
+			rawOps := func(b *xblock, ops []*opInfo) []interface{} {
+				if expr == nil {
+					return getRawBlockOperations(b, kinds, nil)
+				}
+				hashes := make(map[string]struct{}, len(ops))
+				for _, op := range ops {
+					hashes[op.Hash] = struct{}{}
+				}
+				return getRawBlockOperations(b, kinds, hashes)
+			}
+
+			// Standard table
+			var table *utils.Table
+			if enc == nil && ctx.userTemplate == nil && groupKey == nil && !ctx.dot {
+				table = utils.NewTable(ctx.output, operationsColumns, ctx.maxWidth)
+				if err := table.WriteHeader(); err != nil {
+					return err
+				}
+			}
+
 			if ctx.watch {
+				if hook != nil {
+					defer hook.wait()
+				}
+
+				state, err := ctx.watchState()
+				if err != nil {
+					return err
+				}
+
 				var monErr error
 				ch := make(chan *tezos.BlockInfo, 10)
 				go func() {
-					monErr = ctx.monitorHeads(ch)
+					monErr = ctx.monitorHeadsFrom(state.last(), ch)
 					close(ch)
 				}()
 
-				var (
-					tplErr error
-					tplCh  chan *opInfo
-					tplSem chan struct{}
-				)
+				process := func(block *xblock) error {
+					if !ctx.window.contains(block.Header.Timestamp) {
+						return nil
+					}
+					ops, err := selectOps(block)
+					if err != nil {
+						return err
+					}
+
+					if hook != nil {
+						for _, op := range ops {
+							if err := hook.run(op); err != nil {
+								return err
+							}
+						}
+					}
+
+					if enc != nil {
+						if ctx.tabular {
+							return enc.Encode(newOpRows(ops))
+						}
+						if len(ops) == 0 && expr != nil {
+							return nil
+						}
+						return enc.Encode(rawOps(block, ops))
+					}
 
-				if enc == nil && ctx.userTemplate == nil {
-					tplCh = make(chan *opInfo, 100)
-					tplSem = make(chan struct{})
+					if ctx.userTemplate != nil {
+						for _, op := range ops {
+							if err := ctx.userTemplate.Execute(ctx.output, op); err != nil {
+								return err
+							}
+						}
+						return nil
+					}
 
-					// Run template engine in background
-					go func() {
-						tplErr = tpl.Execute(os.Stdout, tplCh)
-						close(tplSem)
-					}()
+					return ctx.writeOperations(table, ops)
 				}
 
-				var (
-					lastLevel          int
-					firstBlockReceived bool
-				)
+				var ended bool
+				lastLevel, firstBlockReceived := state.Level, state.Hash != ""
 				for bi := range ch {
 					if firstBlockReceived && bi.Level <= lastLevel {
 						continue
@@ -129,92 +259,143 @@ func newBlockOperationsCommand(ctx *BlockCommandContext) *cobra.Command {
 						}
 						return nil
 					}
-
-					if enc != nil {
-						ops := getRawBlockOperations(block.Block, kinds)
-						if err := enc.Encode(ops); err != nil {
-							return err
-						}
-						continue
+					if ctx.window.ended(block.Header.Timestamp) {
+						ended = true
+						break
 					}
 
-					ops := getBlockOperations(getBlockInfo(block), kinds)
-					if ctx.userTemplate != nil {
-						for _, op := range ops {
-							if err := ctx.userTemplate.Execute(os.Stdout, op); err != nil {
-								return err
-							}
-						}
-						continue
+					if err := process(block); err != nil {
+						return err
 					}
-
-					// Send to the template
-					for _, op := range ops {
-						tplCh <- op
+					if err := ctx.flushOutput(); err != nil {
+						return err
 					}
-				}
-
-				if tplCh != nil {
-					close(tplCh)
-					<-tplSem
-					if tplErr != nil {
-						return tplErr
+					if err := state.save(bi); err != nil {
+						return err
 					}
 				}
 
-				if monErr != nil && monErr != context.Canceled {
+				if !ended && monErr != nil && monErr != context.Canceled {
 					return monErr
 				}
 				return nil
 			}
 
 			// Get all at once
-			blocks := make([]*xblock, len(args))
-			for i, blockID := range args {
+			blocks := make([]*xblock, 0, len(args))
+			for _, blockID := range args {
 				block, err := ctx.getBlock(blockID, enc == nil)
 				if err != nil {
 					return err
 				}
-				blocks[i] = block
+				if ctx.window.contains(block.Header.Timestamp) {
+					blocks = append(blocks, block)
+				}
 			}
 
-			if enc != nil {
-				var data []*tezos.Operation
+			if enc != nil && groupKey == nil {
+				var (
+					data []interface{}
+					rows []*opRow
+				)
 				for _, b := range blocks {
-					ops := getRawBlockOperations(b.Block, kinds)
-					data = append(data, ops...)
+					ops, err := selectOps(b)
+					if err != nil {
+						return err
+					}
+					if ctx.tabular {
+						rows = append(rows, newOpRows(ops)...)
+						continue
+					}
+					data = append(data, rawOps(b, ops)...)
+				}
+				if ctx.tabular {
+					return enc.Encode(rows)
 				}
 				return enc.Encode(data)
 			}
 
 			var info []*opInfo
 			for _, b := range blocks {
-				info = append(info, getBlockOperations(getBlockInfo(b), kinds)...)
+				ops, err := selectOps(b)
+				if err != nil {
+					return err
+				}
+				info = append(info, ops...)
+			}
+
+			if groupKey != nil {
+				return ctx.writeOperationGroups(enc, groupOperations(info, groupKey, ctx.amountFormat))
+			}
+
+			if ctx.dot {
+				return ctx.writeDOT(ctx.output, flowGraph(info))
 			}
 
 			if ctx.userTemplate != nil {
 				for _, op := range info {
-					if err := ctx.userTemplate.Execute(os.Stdout, op); err != nil {
+					if err := ctx.userTemplate.Execute(ctx.output, op); err != nil {
 						return err
 					}
 				}
 				return nil
 			}
 
-			// Standard template expects a slice or a channel
-			return tpl.Execute(os.Stdout, info)
+			return ctx.writeOperations(table, info)
 		},
 	}
 
-	operationsCmd.Flags().StringSliceVarP(&opKinds, "kind", "k", nil, "Operation kinds: either comma separated list of [end[orsement], act[ivate_account], prop[osals], bal[lot], rev[eal], transaction|tx, orig[ination], del[egation], seed_nonce_revelation, double_endorsement_evidence, double_baking_evidence] or `all'")
+	operationsCmd.Flags().StringSliceVarP(&opKinds, "kind", "k", nil, "Operation kinds: either comma separated list of [end[orsement], act[ivate_account], prop[osals], bal[lot], rev[eal], transaction|tx, orig[ination], del[egation], att[estation]] or any other kind known to the supported protocols, or `all'")
+
+	operationsCmd.Flags().StringVar(&groupBy, "group-by", "", "Aggregate operations into count, total amount and total fee per key: one of [source, destination, kind, baker]")
+
+	operationsCmd.Flags().StringVar(&trace.address, "follow-address", "", "Trace transfers from and to the address across the given blocks and output the transaction graph")
+	operationsCmd.Flags().IntVar(&trace.hops, "hops", 1, "Maximum number of hops to follow with --follow-address")
+	operationsCmd.Flags().StringVar(&trace.direction, "direction", traceBoth, "Trace direction: one of [forward, backward, both]")
+	operationsCmd.Flags().StringVar(&trace.format, "graph-format", "dot", "Transaction graph format: one of [dot, json], `-o dot' implies dot")
+	operationsCmd.Flags().IntVar(&trace.crawl.concurrency, "concurrency", 4, "Number of blocks fetched concurrently with --follow-address")
+	operationsCmd.Flags().IntVar(&trace.crawl.retries, "retries", crawler.DefaultRetries, "Number of retries of a failed block fetch with --follow-address")
 
 	return operationsCmd
 }
 
+// writeOperations writes operations to the standard table
+func (c *BlockCommandContext) writeOperations(table *utils.Table, ops []*opInfo) error {
+	for _, op := range ops {
+		amount, fee := "--", "--"
+		if op.AmountMutez != nil {
+			amount = c.amountFormat.Format(op.AmountMutez)
+		}
+		if op.FeeMutez != nil {
+			fee = c.amountFormat.Format(op.FeeMutez)
+		}
+		err := table.WriteRow(
+			strconv.Itoa(op.Block.Header.Level),
+			or(op.Title, op.Kind),
+			or(op.Source, "--"),
+			or(op.Destination, "--"),
+			amount,
+			fee,
+			op.Hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func or(s, def string) string {
+	if s != "" {
+		return s
+	}
+	return def
+}
+
 func getBlockOperations(b *xblockInfo, opsFilter map[string]struct{}) (info []*opInfo) {
-	for _, ol := range b.Operations {
-		for _, o := range ol {
-			for _, c := range o.Contents {
+	for i, ol := range b.Operations {
+		for j, o := range ol {
+			for k, c := range o.Contents {
 				if _, ok := opsFilter[c.OperationElemKind()]; !ok && opsFilter != nil {
 					// Skip
 					continue
@@ -223,87 +404,132 @@ func getBlockOperations(b *xblockInfo, opsFilter map[string]struct{}) (info []*o
 				oi := &opInfo{
 					Kind:  c.OperationElemKind(),
 					Hash:  o.Hash,
-					Title: operationTitles[c.OperationElemKind()],
+					Title: protocol.Title(c.OperationElemKind()),
 					Block: b,
 				}
 
-				if el, ok := c.(tezos.OperationWithFee); ok {
-					if f := el.OperationFee(); f != nil {
-						oi.Fee = big.NewFloat(0)
-						oi.Fee.SetInt(f)
-						oi.Fee.Mul(oi.Fee, big.NewFloat(1e-6))
-					}
+				if b.isGeneric(c) {
+					oi.Raw = b.rawContent(i, j, k)
+					fillGenericOpInfo(oi, oi.Raw)
+				} else {
+					fillOpInfo(oi, c)
 				}
 
-				switch el := c.(type) {
-				case *tezos.EndorsementOperationElem:
-					oi.Source = el.Metadata.Delegate
-
-				case *tezos.TransactionOperationElem:
-					oi.Source = el.Source
-					oi.Destination = el.Destination
-					if el.Amount != nil {
-						oi.Amount = big.NewFloat(0)
-						oi.Amount.SetInt(&el.Amount.Int)
-						oi.Amount.Mul(oi.Amount, big.NewFloat(1e-6))
-					}
+				oi.Amount = b.amountFormat.Tez(oi.AmountMutez)
+				oi.Fee = b.amountFormat.Tez(oi.FeeMutez)
 
-				case *tezos.BallotOperationElem:
-					oi.Source = el.Source
+				info = append(info, oi)
+			}
+		}
+	}
 
-				case *tezos.ProposalOperationElem:
-					oi.Source = el.Source
+	return
+}
 
-				case *tezos.ActivateAccountOperationElem:
-					oi.Source = el.PKH
-					oi.Amount = big.NewFloat(0)
-					for _, b := range el.Metadata.BalanceUpdates {
-						if bu, ok := b.(*tezos.ContractBalanceUpdate); ok {
-							var amount big.Float
-							amount.SetInt64(int64(bu.Change))
-							oi.Amount.Add(oi.Amount, &amount)
-						}
-					}
-					oi.Amount.Mul(oi.Amount, big.NewFloat(1e-6))
-
-				case *tezos.RevealOperationElem:
-					oi.Source = el.Source
-
-				case *tezos.OriginationOperationElem:
-					oi.Source = el.Source
-					oi.Destination = el.Delegate
-					if el.Balance != nil {
-						oi.Amount = big.NewFloat(0)
-						oi.Amount.SetInt(&el.Balance.Int)
-						oi.Amount.Mul(oi.Amount, big.NewFloat(1e-6))
-					}
+func fillOpInfo(oi *opInfo, c tezos.OperationElem) {
+	if el, ok := c.(tezos.OperationWithFee); ok {
+		if f := el.OperationFee(); f != nil {
+			oi.FeeMutez = new(big.Int).Set(f)
+		}
+	}
 
-				case *tezos.DelegationOperationElem:
-					oi.Source = el.Source
-					oi.Destination = el.Delegate
-					if el.Balance != nil {
-						oi.Amount = big.NewFloat(0)
-						oi.Amount.SetInt(&el.Balance.Int)
-						oi.Amount.Mul(oi.Amount, big.NewFloat(1e-6))
-					}
-				}
+	switch el := c.(type) {
+	case *tezos.EndorsementOperationElem:
+		oi.Source = el.Metadata.Delegate
 
-				info = append(info, oi)
+	case *tezos.TransactionOperationElem:
+		oi.Source = el.Source
+		oi.Destination = el.Destination
+		if el.Amount != nil {
+			oi.AmountMutez = new(big.Int).Set(&el.Amount.Int)
+		}
+
+	case *tezos.BallotOperationElem:
+		oi.Source = el.Source
+
+	case *tezos.ProposalOperationElem:
+		oi.Source = el.Source
+
+	case *tezos.ActivateAccountOperationElem:
+		oi.Source = el.PKH
+		oi.AmountMutez = big.NewInt(0)
+		for _, b := range el.Metadata.BalanceUpdates {
+			if bu, ok := b.(*tezos.ContractBalanceUpdate); ok {
+				oi.AmountMutez.Add(oi.AmountMutez, big.NewInt(int64(bu.Change)))
 			}
 		}
+
+	case *tezos.RevealOperationElem:
+		oi.Source = el.Source
+
+	case *tezos.OriginationOperationElem:
+		oi.Source = el.Source
+		oi.Destination = el.Delegate
+		if el.Balance != nil {
+			oi.AmountMutez = new(big.Int).Set(&el.Balance.Int)
+		}
+
+	case *tezos.DelegationOperationElem:
+		oi.Source = el.Source
+		oi.Destination = el.Delegate
+		if el.Balance != nil {
+			oi.AmountMutez = new(big.Int).Set(&el.Balance.Int)
+		}
+	}
+}
+
+// fillGenericOpInfo extracts fields common to the most of operation kinds from the undecoded element
+func fillGenericOpInfo(oi *opInfo, raw map[string]interface{}) {
+	if raw == nil {
+		return
 	}
 
-	return
+	if s, ok := raw["source"].(string); ok {
+		oi.Source = s
+	} else if md, ok := raw["metadata"].(map[string]interface{}); ok {
+		// Consensus operations
+		if s, ok := md["delegate"].(string); ok {
+			oi.Source = s
+		}
+	}
+
+	if s, ok := raw["fee"].(string); ok {
+		if v, ok := new(big.Int).SetString(s, 10); ok {
+			oi.FeeMutez = v
+		}
+	}
+
+	if s, ok := raw["counter"].(string); ok {
+		oi.Counter = s
+	}
 }
 
-func getRawBlockOperations(b *tezos.Block, opsFilter map[string]struct{}) (ops []*tezos.Operation) {
-	for _, ol := range b.Operations {
-		for _, o := range ol {
+// getRawBlockOperations returns operations matching the filter and, if not nil, the set of hashes.
+// Operations containing elements not supported by the client library are returned undecoded so no data is lost
+func getRawBlockOperations(b *xblock, opsFilter map[string]struct{}, hashes map[string]struct{}) (ops []interface{}) {
+	for i, ol := range b.Operations {
+		for j, o := range ol {
+			if _, ok := hashes[o.Hash]; !ok && hashes != nil {
+				continue
+			}
+
+			var match, generic bool
 			for _, c := range o.Contents {
 				if _, ok := opsFilter[c.OperationElemKind()]; ok || opsFilter == nil {
-					ops = append(ops, o)
-					break
+					match = true
 				}
+				generic = generic || b.isGeneric(c)
+			}
+			if !match {
+				continue
+			}
+
+			if raw := b.rawOperation(i, j); b.apiVersion >= 1 && raw != nil {
+				ops = append(ops, newOperationV1(raw))
+			} else if generic && raw != nil {
+				ops = append(ops, raw)
+			} else {
+				ops = append(ops, o)
 			}
 		}
 	}
@@ -0,0 +1,376 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/michelson"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the contract list table
+var contractColumns = []utils.TableColumn{
+	{Header: "ALIAS", Width: 16, MinWidth: 8},
+	{Header: "ADDRESS", Width: 36, MinWidth: 13},
+	{Header: "CODE HASH", Width: 54, MinWidth: 13},
+	{Header: "BALANCE", Width: 20, Align: utils.AlignRight},
+	{Header: "LAST ACTIVITY", Width: 20},
+}
+
+// contractBookEntry is a named originated contract
+type contractBookEntry struct {
+	Alias      string    `json:"alias"`
+	Address    string    `json:"address"`
+	Operation  string    `json:"operation,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Originated time.Time `json:"originated,omitempty"`
+}
+
+// contractBook is the local address book of originated contracts
+type contractBook struct {
+	path    string
+	Entries []*contractBookEntry
+}
+
+func (c *RootContext) contractBook() (*contractBook, error) {
+	path, err := utils.ExpandHome(c.contractsPath)
+	if err != nil {
+		return nil, err
+	}
+	b := contractBook{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &b, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &b.Entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &b, nil
+}
+
+// lookup returns an entry by its alias or address
+func (b *contractBook) lookup(name string) *contractBookEntry {
+	for _, e := range b.Entries {
+		if e.Alias == name || e.Address == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func (b *contractBook) save() error {
+	data, err := json.MarshalIndent(b.Entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(b.path, append(data, '\n'), 0600)
+}
+
+type originateOptions struct {
+	from     string
+	code     string
+	storage  string
+	balance  string
+	delegate string
+	inject   injectOptions
+}
+
+func newContractOriginateCommand(ctx *RootContext) *cobra.Command {
+	var opt originateOptions
+
+	originateCmd := &cobra.Command{
+		Use:   "originate <alias>",
+		Short: "Deploy a smart contract",
+		Long: `Originate the contract with the code and the initial storage given as Michelson source or Micheline JSON.
+On success the new KT1 address is recorded under the alias in the contract book (see --contracts-file).`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.originateContract(args[0], &opt)
+		},
+	}
+
+	f := originateCmd.Flags()
+	f.StringVar(&opt.from, "from", "", "Source account: keystore alias or address")
+	f.StringVar(&opt.code, "code", "", "Contract code given inline, as - (read from stdin) or @path")
+	f.StringVar(&opt.storage, "init", "", "Initial storage given inline, as - (read from stdin) or @path")
+	f.StringVar(&opt.balance, "balance", "0", "Initial balance in tez")
+	f.StringVar(&opt.delegate, "delegate", "", "Delegate of the contract: keystore alias or address")
+	originateCmd.MarkFlagRequired("from")
+	originateCmd.MarkFlagRequired("code")
+	originateCmd.MarkFlagRequired("init")
+	addInjectFlags(originateCmd, &opt.inject)
+
+	return originateCmd
+}
+
+func (c *RootContext) originateContract(alias string, opt *originateOptions) error {
+	book, err := c.contractBook()
+	if err != nil {
+		return err
+	}
+	if e := book.lookup(alias); e != nil {
+		return fmt.Errorf("Contract alias `%s' already exists: %s", alias, e.Address)
+	}
+
+	acc, err := c.account(opt.from)
+	if err != nil {
+		return err
+	}
+	op := forge.Origination{}
+	if op.Balance, err = utils.ParseTez(opt.balance); err != nil {
+		return err
+	}
+	if opt.delegate != "" {
+		if op.Delegate, err = c.resolveAddress(opt.delegate); err != nil {
+			return err
+		}
+	}
+	for _, v := range []struct {
+		src string
+		dst **michelson.Node
+	}{
+		{opt.code, &op.Code},
+		{opt.storage, &op.Storage},
+	} {
+		src, err := utils.ReadInputString(v.src)
+		if err != nil {
+			return err
+		}
+		if *v.dst, err = michelson.ParseAny(src); err != nil {
+			return err
+		}
+	}
+
+	hash, err := c.sendOperations(acc, []forge.ManagerOperation{&op}, &opt.inject)
+	if err != nil || hash == "" {
+		return err
+	}
+	fmt.Println(hash)
+
+	// A reveal may be prepended but it doesn't originate anything so the contract is the first one
+	address, err := forge.ContractAddress(hash, 0)
+	if err != nil {
+		return err
+	}
+	book.Entries = append(book.Entries, &contractBookEntry{
+		Alias:      alias,
+		Address:    address,
+		Operation:  hash,
+		Source:     acc.Address,
+		Originated: time.Now().UTC().Truncate(time.Second),
+	})
+	if err := book.save(); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"alias":   alias,
+		"address": address,
+	}).Info("Contract added to the contract book")
+	fmt.Println(address)
+	return nil
+}
+
+// knownContract is a row of `contract list'. Balance is in mutez
+type knownContract struct {
+	Alias        string     `json:"alias" yaml:"alias"`
+	Address      string     `json:"address" yaml:"address"`
+	CodeHash     string     `json:"code_hash,omitempty" yaml:"code_hash,omitempty"`
+	Balance      string     `json:"balance,omitempty" yaml:"balance,omitempty"`
+	LastActivity *time.Time `json:"last_activity,omitempty" yaml:"last_activity,omitempty"`
+	Source       string     `json:"source,omitempty" yaml:"source,omitempty"`
+	// Set if the contract doesn't exist on the current chain
+	Missing bool `json:"missing,omitempty" yaml:"missing,omitempty"`
+}
+
+// indexerContract is the part of TzKT's contract used here
+type indexerContract struct {
+	LastActivityTime *time.Time `json:"lastActivityTime"`
+}
+
+func newContractListCommand(ctx *RootContext) *cobra.Command {
+	var (
+		format  string
+		indexer string
+	)
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List known contracts",
+		Long: `List contracts of the contract book and the tezos-client wallet (see --client-dir) with their code hash and balance.
+The last activity time is taken from a TzKT compatible indexer given with --indexer.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEnc utils.NewEncoderFunc
+			if format != "text" {
+				if newEnc = ctx.encoderFunc(format); newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", format)
+				}
+			}
+			list, err := ctx.knownContracts(indexer)
+			if err != nil {
+				return err
+			}
+			if newEnc != nil {
+				return newEnc(os.Stdout).Encode(list)
+			}
+			return ctx.writeKnownContracts(list)
+		},
+	}
+
+	listCmd.Flags().StringVarP(&format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	listCmd.Flags().StringVar(&indexer, "indexer", "", "Indexer API URL to get the last activity from, e.g. https://api.tzkt.io")
+
+	return listCmd
+}
+
+func (c *RootContext) knownContracts(indexer string) ([]*knownContract, error) {
+	book, err := c.contractBook()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*knownContract, 0, len(book.Entries))
+	for _, e := range book.Entries {
+		list = append(list, &knownContract{Alias: e.Alias, Address: e.Address})
+	}
+	if c.clientDir != "" {
+		w, err := c.clientWallet()
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(w.Contracts))
+		for name := range w.Contracts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			address := w.Contracts[name]
+			if book.lookup(name) == nil && book.lookup(address) == nil {
+				list = append(list, &knownContract{Alias: name, Address: address, Source: keySourceClient})
+			}
+		}
+	}
+
+	for _, k := range list {
+		script, err := c.contractScript(k.Address)
+		if isNotFound(err) {
+			k.Missing = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if k.CodeHash, err = script.codeHash(); err != nil {
+			return nil, err
+		}
+		balance, err := c.contractBalance(c.blockID, k.Address)
+		if err != nil {
+			return nil, err
+		}
+		k.Balance = balance.String()
+
+		if indexer != "" {
+			var ic indexerContract
+			ok, err := c.getIndexer(indexer, "/v1/contracts/"+k.Address, &ic)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				k.LastActivity = ic.LastActivityTime
+			}
+		}
+	}
+	return list, nil
+}
+
+func (c *RootContext) writeKnownContracts(list []*knownContract) error {
+	table := utils.NewTable(os.Stdout, contractColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, k := range list {
+		alias := k.Alias
+		if k.Source != "" {
+			alias += " (" + k.Source + ")"
+		}
+		hash, balance, activity := "not found", "-", "-"
+		if !k.Missing {
+			hash = k.CodeHash
+			b, _ := new(big.Int).SetString(k.Balance, 10)
+			balance = c.amountFormat.Format(b)
+		}
+		if k.LastActivity != nil {
+			activity = c.utcTime(*k.LastActivity).Format("2006-01-02 15:04:05")
+		}
+		if err := table.WriteRow(alias, k.Address, hash, balance, activity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// codeHash returns the script expression hash of the contract's code, same as the node's script_hash
+func (s *contractScript) codeHash() (string, error) {
+	code, err := s.Code.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return michelson.ExprHash(code), nil
+}
+
+// getIndexer decodes the indexer's response. False is returned if the indexer responds with 404
+func (c *RootContext) getIndexer(indexer, path string, v interface{}) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(indexer, "/")+path, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(c.context))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	case res.StatusCode/100 != 2:
+		return false, fmt.Errorf("Indexer: %s", res.Status)
+	}
+	return true, json.NewDecoder(res.Body).Decode(v)
+}
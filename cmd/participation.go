@@ -0,0 +1,279 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the participation summary table
+var participationColumns = []utils.TableColumn{
+	{Header: "DELEGATE", Width: 36, MinWidth: 13},
+	{Header: "LEVELS", Width: 6, Align: utils.AlignRight},
+	{Header: "MISSED", Width: 6, Align: utils.AlignRight},
+	{Header: "EXPECTED", Width: 9, Align: utils.AlignRight},
+	{Header: "REALIZED", Width: 9, Align: utils.AlignRight},
+	{Header: "RATE", Width: 7, Align: utils.AlignRight},
+}
+
+type participationOptions struct {
+	cycle  int
+	crawl  crawlOptions
+	format string
+}
+
+// participationCell is the delegate's attestation power at a level it had rights for. Realized is zero if the level wasn't attested
+type participationCell struct {
+	Level    int `json:"level" yaml:"level"`
+	Expected int `json:"expected" yaml:"expected"`
+	Realized int `json:"realized" yaml:"realized"`
+}
+
+type delegateParticipation struct {
+	Delegate string `json:"delegate" yaml:"delegate"`
+	// Levels with rights and missed ones
+	Levels   int `json:"levels" yaml:"levels"`
+	Missed   int `json:"missed" yaml:"missed"`
+	Expected int `json:"expected" yaml:"expected"`
+	Realized int `json:"realized" yaml:"realized"`
+	// Percentage of the realized attestation power
+	Rate  float64              `json:"rate" yaml:"rate"`
+	Cells []*participationCell `json:"cells" yaml:"cells"`
+}
+
+type cycleParticipation struct {
+	Cycle      int                      `json:"cycle" yaml:"cycle"`
+	FirstLevel int                      `json:"first_level" yaml:"first_level"`
+	LastLevel  int                      `json:"last_level" yaml:"last_level"`
+	Delegates  []*delegateParticipation `json:"delegates" yaml:"delegates"`
+}
+
+func newParticipationCommand(ctx *BlockCommandContext) *cobra.Command {
+	var opt participationOptions
+
+	participationCmd := &cobra.Command{
+		Use:   "participation",
+		Short: "Expected and realized attestations of every delegate at every level of a cycle",
+		Long: `Compare the attestation rights of every delegate at every level of the cycle with the attestations included in the next block.
+Expected and realized amounts are attestation power (slots before Tenderbake). The current cycle is scanned up to the head.
+The CSV output has a row per delegate and level with rights, ready to be pivoted into a heatmap. The node must keep the metadata
+of the scanned blocks.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.showParticipation(&opt)
+		},
+	}
+
+	f := participationCmd.Flags()
+	f.IntVar(&opt.cycle, "cycle", -1, "Cycle to scan (default is the current one)")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, csv, yaml, json]")
+	f.BoolVar(&ctx.wide, "wide", false, "Don't truncate table columns to fit the terminal")
+	addCrawlFlags(participationCmd, &opt.crawl, 8, "blocks")
+
+	return participationCmd
+}
+
+func (c *BlockCommandContext) showParticipation(opt *participationOptions) error {
+	var newEncoder utils.NewEncoderFunc
+	switch opt.format {
+	case "text", "csv":
+	default:
+		if newEncoder = c.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+
+	var cur currentLevel
+	if err := c.getRPC(c.blockPath(c.blockID)+"/helpers/current_level", &cur); err != nil {
+		return err
+	}
+	if opt.cycle < 0 {
+		opt.cycle = cur.Cycle
+	}
+	if opt.cycle > cur.Cycle {
+		return fmt.Errorf("Cycle %d hasn't started yet, current cycle is %d", opt.cycle, cur.Cycle)
+	}
+
+	var lv cycleLevels
+	if err := c.getRPC(fmt.Sprintf("%s/helpers/levels_in_current_cycle?offset=%d", c.blockPath(c.blockID), opt.cycle-cur.Cycle), &lv); err != nil {
+		return err
+	}
+	// Attestations of the level L are included into the block L+1
+	last := lv.Last
+	if last >= cur.Level {
+		last = cur.Level - 1
+	}
+	if last < lv.First {
+		return fmt.Errorf("No attested levels in cycle %d yet", opt.cycle)
+	}
+	args := make([]string, 0, last-lv.First+1)
+	for level := lv.First + 1; level <= last+1; level++ {
+		args = append(args, strconv.Itoa(level))
+	}
+
+	if err := c.primeCache(); err != nil {
+		return err
+	}
+	fetch := func(ctx context.Context, query string) (interface{}, error) {
+		block, err := c.getBlock(query, false)
+		if err != nil {
+			return nil, crawlError(err)
+		}
+		m, err := c.getSlotMap(block)
+		if err != nil {
+			return nil, crawlError(err)
+		}
+		return m, nil
+	}
+
+	delegates := make(map[string]*delegateParticipation)
+	err := c.newCrawler(&opt.crawl, "blocks").Run(c.context, args, fetch, func(i int, query string, v interface{}) error {
+		m := v.(*slotMap)
+		// Slots of the same delegate are merged into a single cell
+		cells := make(map[string]*participationCell)
+		for _, s := range m.Cells {
+			cell, ok := cells[s.Delegate]
+			if !ok {
+				cell = &participationCell{Level: m.Level}
+				cells[s.Delegate] = cell
+				d, ok := delegates[s.Delegate]
+				if !ok {
+					d = &delegateParticipation{Delegate: s.Delegate}
+					delegates[s.Delegate] = d
+				}
+				d.Cells = append(d.Cells, cell)
+			}
+			cell.Expected += s.Power
+			if s.Attested {
+				cell.Realized += s.Power
+			}
+		}
+		log.WithField("block_level", m.Level).Debug("Level scanned")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	res := cycleParticipation{
+		Cycle:      opt.cycle,
+		FirstLevel: lv.First,
+		LastLevel:  last,
+		Delegates:  make([]*delegateParticipation, 0, len(delegates)),
+	}
+	for _, d := range delegates {
+		sort.Slice(d.Cells, func(i, j int) bool { return d.Cells[i].Level < d.Cells[j].Level })
+		for _, cell := range d.Cells {
+			d.Levels++
+			if cell.Realized == 0 {
+				d.Missed++
+			}
+			d.Expected += cell.Expected
+			d.Realized += cell.Realized
+		}
+		if d.Expected != 0 {
+			d.Rate = float64(d.Realized) / float64(d.Expected) * 100
+		}
+		res.Delegates = append(res.Delegates, d)
+	}
+	// Worst participants first
+	sort.Slice(res.Delegates, func(i, j int) bool {
+		di, dj := res.Delegates[i], res.Delegates[j]
+		if di.Rate != dj.Rate {
+			return di.Rate < dj.Rate
+		}
+		return di.Delegate < dj.Delegate
+	})
+
+	switch opt.format {
+	case "text":
+		if !c.wide {
+			c.maxWidth = utils.TerminalWidth(os.Stdout)
+		}
+		return c.writeParticipation(os.Stdout, &res)
+	case "csv":
+		return writeParticipationCSV(os.Stdout, &res)
+	default:
+		enc := newEncoder(os.Stdout)
+		err := enc.Encode(&res)
+		closeEncoder(enc, &err)
+		return err
+	}
+}
+
+func (c *BlockCommandContext) writeParticipation(w io.Writer, p *cycleParticipation) error {
+	if _, err := fmt.Fprintf(w, "Cycle %d, levels %d to %d\n\n", p.Cycle, p.FirstLevel, p.LastLevel); err != nil {
+		return err
+	}
+	table := utils.NewTable(w, participationColumns, c.maxWidth)
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, d := range p.Delegates {
+		err := table.WriteRow(
+			d.Delegate,
+			strconv.Itoa(d.Levels),
+			strconv.Itoa(d.Missed),
+			strconv.Itoa(d.Expected),
+			strconv.Itoa(d.Realized),
+			fmt.Sprintf("%.1f%%", d.Rate),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSV is a sparse matrix with a row per delegate and level with rights
+func writeParticipationCSV(w io.Writer, p *cycleParticipation) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"cycle", "delegate", "level", "expected", "realized"}); err != nil {
+		return err
+	}
+	cycle := strconv.Itoa(p.Cycle)
+	for _, d := range p.Delegates {
+		for _, cell := range d.Cells {
+			err := cw.Write([]string{
+				cycle,
+				d.Delegate,
+				strconv.Itoa(cell.Level),
+				strconv.Itoa(cell.Expected),
+				strconv.Itoa(cell.Realized),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
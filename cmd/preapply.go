@@ -0,0 +1,273 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/forge"
+	"github.com/ecadlabs/tez/keys"
+)
+
+// Hints for node errors which have an obvious remedy, keyed by the error ID without the protocol part
+var operationErrorHints = map[string]string{
+	"contract.counter_in_the_past":             "the counter was already used, re-forge the operation with the current counter",
+	"contract.counter_in_the_future":           "an operation with a lower counter is still pending, wait for its inclusion or re-forge with the current counter",
+	"outdated_operation":                       "the branch is too old, re-forge the operation with a fresh branch",
+	"contract.balance_too_low":                 "the source balance doesn't cover the amount",
+	"contract.empty_implicit_contract":         "the source account is empty",
+	"implicit.empty_implicit_contract":         "the source account is empty",
+	"contract.unrevealed_key":                  "the source public key is not revealed, prepend a reveal operation",
+	"operation.invalid_signature":              "the signature doesn't match the forged bytes or the source key",
+	"gas_exhausted.operation":                  "the gas limit is too low",
+	"storage_exhausted.operation":              "the storage limit is too low",
+	"prefilter.fees_too_low":                   "the fee is below the mempool minimum",
+	"michelson_v1.script_rejected":             "the contract rejected the call",
+	"operation.cannot_pay_storage_fee":         "the balance doesn't cover the storage burn",
+	"tez.subtraction_underflow":                "the balance doesn't cover the fees",
+	"contract.manager.inconsistent_public_key": "the revealed public key doesn't match the source",
+	"prevalidation.operation_conflict":         "another operation of the source is already in the mempool",
+}
+
+// operationErrorHint returns the remedy of the node error or an empty string
+func operationErrorHint(id string) string {
+	// proto.021-PsQuebec.contract.counter_in_the_past
+	if strings.HasPrefix(id, "proto.") {
+		if i := strings.IndexByte(id[len("proto."):], '.'); i >= 0 {
+			id = id[len("proto.")+i+1:]
+		}
+	}
+	for suffix, hint := range operationErrorHints {
+		if id == suffix || strings.HasSuffix(id, "."+suffix) {
+			return hint
+		}
+	}
+	return ""
+}
+
+// describeErrorIDs returns the error IDs along with their remedies
+func describeErrorIDs(ids []string) string {
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = id
+		if hint := operationErrorHint(id); hint != "" {
+			list[i] += " (" + hint + ")"
+		}
+	}
+	return strings.Join(list, ", ")
+}
+
+// explainRPCError replaces the node's error with its IDs and remedies. Other errors are returned as is
+func explainRPCError(err error) error {
+	rpcErr, ok := err.(tezos.RPCError)
+	if !ok {
+		return err
+	}
+	var ids []string
+	for _, e := range rpcErr.Errors() {
+		ids = append(ids, e.ErrorID())
+	}
+	if len(ids) == 0 {
+		return err
+	}
+	return fmt.Errorf("Operation rejected by the node: %s", describeErrorIDs(ids))
+}
+
+// signedOperation is an operation in the node's JSON format
+type signedOperation struct {
+	Protocol  string            `json:"protocol,omitempty"`
+	Branch    string            `json:"branch"`
+	Contents  []json.RawMessage `json:"contents"`
+	Signature string            `json:"signature,omitempty"`
+}
+
+// Number of times the operation is forged with a fresh branch under --auto-rebranch before giving up
+const maxRebranchAttempts = 3
+
+// operationCheckError is a problem of the operation found by the checks, as opposed to a failure to run them
+type operationCheckError string
+
+func (e operationCheckError) Error() string {
+	return string(e)
+}
+
+// branchExpiredError is returned when the operation's branch is too old to be included
+type branchExpiredError struct {
+	branch string
+	age    int
+	ttl    int
+}
+
+func (e *branchExpiredError) Error() string {
+	return fmt.Sprintf("Branch %s is %d blocks old, older than the %d blocks operations TTL: re-forge the operation with a fresh branch and sign it again", e.branch, e.age, e.ttl)
+}
+
+// newSignedOperation returns the JSON form of the signed manager operations
+func newSignedOperation(branch string, ops []forge.ManagerOperation, sig *keys.Signature) (*signedOperation, error) {
+	op := signedOperation{Branch: branch, Signature: sig.String()}
+	for _, o := range ops {
+		raw, err := json.Marshal(o)
+		if err != nil {
+			return nil, err
+		}
+		op.Contents = append(op.Contents, raw)
+	}
+	return &op, nil
+}
+
+// parseOperation decodes signed operation bytes using the node
+func (c *RootContext) parseOperation(signed []byte) (*signedOperation, error) {
+	if len(signed) <= 32 {
+		return nil, fmt.Errorf("Signed operation is too short: %d bytes", len(signed))
+	}
+	branch, err := base58.PrefixBlockHash.Encode(signed[:32])
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]interface{}{
+		"operations": []interface{}{
+			map[string]interface{}{
+				"branch": branch,
+				"data":   hex.EncodeToString(signed[32:]),
+			},
+		},
+	}
+	var res []*signedOperation
+	if err := c.postRPC(c.blockPath("head")+"/helpers/parse/operations", req, &res); err != nil {
+		return nil, fmt.Errorf("Can't parse the operation: %v", explainRPCError(err))
+	}
+	if len(res) != 1 {
+		return nil, fmt.Errorf("Can't parse the operation: %d operations returned", len(res))
+	}
+	return res[0], nil
+}
+
+// checkOperation validates the signed operation before the injection: the branch must be within the operations TTL,
+// the counters must follow the current ones and the operation must pass preapplication
+func (c *RootContext) checkOperation(op *signedOperation) error {
+	if err := c.checkBranch(op.Branch); err != nil {
+		return err
+	}
+	if err := c.checkCounters(op.Contents); err != nil {
+		return err
+	}
+	return c.preapplyOperation(op)
+}
+
+func (c *RootContext) checkBranch(branch string) error {
+	var header struct {
+		Level int `json:"level"`
+	}
+	err := c.getRPC(c.blockPath(branch)+"/header", &header)
+	if isNotFound(err) {
+		return operationCheckError(fmt.Sprintf("Branch %s is unknown to the node: re-forge the operation with a fresh branch", branch))
+	}
+	if err != nil {
+		return err
+	}
+
+	var metadata struct {
+		MaxOperationsTTL int `json:"max_operations_ttl"`
+		LevelInfo        struct {
+			Level int `json:"level"`
+		} `json:"level_info"`
+	}
+	if err := c.getRPC(c.blockPath("head")+"/metadata", &metadata); err != nil {
+		return err
+	}
+	age := metadata.LevelInfo.Level - header.Level
+	if metadata.MaxOperationsTTL > 0 && age >= metadata.MaxOperationsTTL {
+		return &branchExpiredError{branch: branch, age: age, ttl: metadata.MaxOperationsTTL}
+	}
+	return nil
+}
+
+// checkCounters makes sure the first manager operation of each source uses the next counter
+func (c *RootContext) checkCounters(contents []json.RawMessage) error {
+	next := make(map[string]*big.Int)
+	for _, raw := range contents {
+		var el struct {
+			Kind    string        `json:"kind"`
+			Source  string        `json:"source"`
+			Counter *tezos.BigInt `json:"counter"`
+		}
+		if err := json.Unmarshal(raw, &el); err != nil {
+			return err
+		}
+		if el.Counter == nil || el.Source == "" {
+			continue
+		}
+
+		expect, ok := next[el.Source]
+		if !ok {
+			var current tezos.BigInt
+			if err := c.getRPC(c.blockPath("head")+"/context/contracts/"+el.Source+"/counter", &current); err != nil {
+				return err
+			}
+			expect = new(big.Int).Add(&current.Int, big.NewInt(1))
+		}
+		switch el.Counter.Cmp(expect) {
+		case -1:
+			return operationCheckError(fmt.Sprintf("Counter %s of the %s operation from %s was already used, the next one is %s: re-forge the operation with the current counter", el.Counter, el.Kind, el.Source, expect))
+		case 1:
+			return operationCheckError(fmt.Sprintf("Counter %s of the %s operation from %s is ahead of the next one %s: wait for the pending operations of the source or re-forge with the current counter", el.Counter, el.Kind, el.Source, expect))
+		}
+		next[el.Source] = expect.Add(expect, big.NewInt(1))
+	}
+	return nil
+}
+
+// preapplyOperation applies the signed operation on top of the head and fails unless all contents are applied
+func (c *RootContext) preapplyOperation(op *signedOperation) error {
+	if op.Protocol == "" {
+		_, hash, err := c.nextProtocol()
+		if err != nil {
+			return err
+		}
+		p := *op
+		p.Protocol = hash
+		op = &p
+	}
+
+	var res []struct {
+		Contents []*simulatedContent `json:"contents"`
+	}
+	if err := c.postRPC(c.blockPath("head")+"/helpers/preapply/operations", []*signedOperation{op}, &res); err != nil {
+		return fmt.Errorf("Preapplication failed: %v", explainRPCError(err))
+	}
+	for _, r := range res {
+		for _, content := range r.Contents {
+			if content.Metadata.OperationResult == nil {
+				continue
+			}
+			if msg := contentError(content); msg != "" {
+				return fmt.Errorf("Preapplication of %s failed: %s", content.Kind, msg)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the protocol history table
+var protocolsColumns = []utils.TableColumn{
+	{Header: "#", Width: 3, Align: utils.AlignRight},
+	{Header: "NAME", Width: 14, MinWidth: 8},
+	{Header: "HASH", Width: 51, MinWidth: 13},
+	{Header: "FIRST LEVEL", Width: 11, Align: utils.AlignRight},
+	{Header: "LAST LEVEL", Width: 10, Align: utils.AlignRight},
+	{Header: "ACTIVATED", Width: 20},
+}
+
+// protocolPeriod is a range of levels validated by the same protocol
+type protocolPeriod struct {
+	Number     int       `json:"number" yaml:"number"`
+	Hash       string    `json:"hash" yaml:"hash"`
+	Name       string    `json:"name,omitempty" yaml:"name,omitempty"`
+	FirstLevel int       `json:"first_level" yaml:"first_level"`
+	LastLevel  int       `json:"last_level" yaml:"last_level"`
+	Activated  time.Time `json:"activated" yaml:"activated"`
+	// Timestamp of the last block
+	Ended   time.Time `json:"ended" yaml:"ended"`
+	Current bool      `json:"current" yaml:"current"`
+}
+
+type protocolDetails struct {
+	*protocolPeriod `yaml:",inline"`
+	Constants       map[string]interface{} `json:"constants" yaml:"constants"`
+}
+
+type levelHeader struct {
+	Hash      string    `json:"hash"`
+	Level     int       `json:"level"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewProtocolsCommand returns new `protocols' command
+func NewProtocolsCommand(rootCtx *RootContext) *cobra.Command {
+	var outputFormat string
+
+	protocolsCmd := &cobra.Command{
+		Use:   "protocols",
+		Short: "List protocols activated on the chain",
+		Long: `List protocols activated on the chain since genesis with their activation levels and timestamps.
+Protocol changes are located by bisecting the chain, so only a few dozen blocks are requested. The node must keep the metadata of old blocks (archive or full mode).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			periods, err := rootCtx.protocolHistory()
+			if err != nil {
+				return err
+			}
+
+			if outputFormat != "text" {
+				newEnc := rootCtx.encoderFunc(outputFormat)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+				enc := newEnc(os.Stdout)
+				err := enc.Encode(periods)
+				closeEncoder(enc, &err)
+				return err
+			}
+
+			table := utils.NewTable(os.Stdout, protocolsColumns, utils.TerminalWidth(os.Stdout))
+			if err := table.WriteHeader(); err != nil {
+				return err
+			}
+			for _, p := range periods {
+				last := strconv.Itoa(p.LastLevel)
+				if p.Current {
+					last = "--"
+				}
+				err := table.WriteRow(
+					strconv.Itoa(p.Number),
+					or(p.Name, "--"),
+					p.Hash,
+					strconv.Itoa(p.FirstLevel),
+					last,
+					rootCtx.utcTime(p.Activated).Format("2006-01-02 15:04:05"),
+				)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	protocolsCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json, markdown, html]")
+
+	return protocolsCmd
+}
+
+// NewProtocolCommand returns new `protocol' command
+func NewProtocolCommand(rootCtx *RootContext) *cobra.Command {
+	var outputFormat string
+
+	protocolCmd := &cobra.Command{
+		Use:   "protocol",
+		Short: "Protocol details",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show <hash|name>",
+		Short: "Show protocol constants and lifespan",
+		Long: `Show constants and lifespan of a protocol activated on the chain.
+The protocol can be given by its hash, an unambiguous hash prefix or its name, e.g. 022-PsRiotum.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d, err := rootCtx.protocolDetails(args[0])
+			if err != nil {
+				return err
+			}
+
+			if outputFormat != "text" {
+				newEnc := rootCtx.encoderFunc(outputFormat)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+				return newEnc(os.Stdout).Encode(d)
+			}
+
+			rootCtx.printProtocolDetails(d)
+			return nil
+		},
+	}
+
+	showCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	protocolCmd.AddCommand(showCmd)
+
+	return protocolCmd
+}
+
+func (c *RootContext) levelProtocol(level int) (string, error) {
+	var p blockProtocols
+	if err := c.getRPC(c.blockPath(strconv.Itoa(level))+"/protocols", &p); err != nil {
+		return "", err
+	}
+	return p.Protocol, nil
+}
+
+func (c *RootContext) levelHeader(level string) (*levelHeader, error) {
+	var h levelHeader
+	if err := c.getRPC(c.blockPath(level)+"/header", &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// protocolHistory returns protocols which validated the chain blocks in activation order. Levels where the protocol
+// changes are found by bisection assuming a protocol is never activated twice
+func (c *RootContext) protocolHistory() ([]*protocolPeriod, error) {
+	head, err := c.levelHeader("head")
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := c.levelProtocol(0)
+	if err != nil {
+		return nil, err
+	}
+	last, err := c.levelProtocol(head.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	// Levels at which a new protocol validated its first block
+	starts := map[int]string{0: first}
+	var bisect func(lo, hi int, pLo, pHi string) error
+	bisect = func(lo, hi int, pLo, pHi string) error {
+		if pLo == pHi {
+			return nil
+		}
+		if hi-lo == 1 {
+			starts[hi] = pHi
+			return nil
+		}
+		mid := lo + (hi-lo)/2
+		pMid, err := c.levelProtocol(mid)
+		if err != nil {
+			return err
+		}
+		if err := bisect(lo, mid, pLo, pMid); err != nil {
+			return err
+		}
+		return bisect(mid, hi, pMid, pHi)
+	}
+	if err := bisect(0, head.Level, first, last); err != nil {
+		return nil, err
+	}
+
+	levels := make([]int, 0, len(starts))
+	for l := range starts {
+		levels = append(levels, l)
+	}
+	sort.Ints(levels)
+
+	log.WithField("protocols", len(levels)).Debug("Protocol changes located")
+
+	periods := make([]*protocolPeriod, len(levels))
+	for i, l := range levels {
+		p := protocolPeriod{
+			Number:     i,
+			Hash:       starts[l],
+			FirstLevel: l,
+		}
+		if proto := protocol.Lookup(p.Hash); proto != nil {
+			p.Name = proto.Name
+		}
+
+		h, err := c.levelHeader(strconv.Itoa(l))
+		if err != nil {
+			return nil, err
+		}
+		p.Activated = h.Timestamp
+
+		if i+1 < len(levels) {
+			p.LastLevel = levels[i+1] - 1
+			if h, err = c.levelHeader(strconv.Itoa(p.LastLevel)); err != nil {
+				return nil, err
+			}
+			p.Ended = h.Timestamp
+		} else {
+			p.LastLevel = head.Level
+			p.Ended = head.Timestamp
+			p.Current = true
+		}
+		periods[i] = &p
+	}
+	return periods, nil
+}
+
+// protocolDetails returns the protocol lifespan and constants taken at its last block
+func (c *RootContext) protocolDetails(name string) (*protocolDetails, error) {
+	periods, err := c.protocolHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	var match []*protocolPeriod
+	for _, p := range periods {
+		if p.Hash == name || p.Name == name {
+			match = []*protocolPeriod{p}
+			break
+		}
+		if strings.HasPrefix(p.Hash, name) {
+			match = append(match, p)
+		}
+	}
+	switch len(match) {
+	case 0:
+		return nil, fmt.Errorf("Protocol `%s' wasn't activated on the chain", name)
+	case 1:
+	default:
+		return nil, fmt.Errorf("Ambiguous protocol `%s': %d candidates", name, len(match))
+	}
+
+	d := protocolDetails{protocolPeriod: match[0]}
+	if err := c.getRPC(c.blockPath(strconv.Itoa(d.LastLevel))+"/context/constants", &d.Constants); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (c *RootContext) printProtocolDetails(d *protocolDetails) {
+	fmt.Printf("Protocol:    %s\n", d.Hash)
+	if d.Name != "" {
+		fmt.Printf("Name:        %s\n", d.Name)
+	}
+	fmt.Printf("Activated:   level %d at %s\n", d.FirstLevel, c.utcTime(d.Activated).Format(time.RFC3339))
+	if d.Current {
+		fmt.Printf("Current:     level %d at %s\n", d.LastLevel, c.utcTime(d.Ended).Format(time.RFC3339))
+	} else {
+		fmt.Printf("Last block:  level %d at %s\n", d.LastLevel, c.utcTime(d.Ended).Format(time.RFC3339))
+	}
+	fmt.Printf("Lifespan:    %d levels, %s\n", d.LastLevel-d.FirstLevel+1, d.Ended.Sub(d.Activated).Round(time.Second))
+
+	keys := make([]string, 0, len(d.Constants))
+	for k := range d.Constants {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Println("Constants:")
+	for _, k := range keys {
+		v, err := json.Marshal(d.Constants[k])
+		if err != nil {
+			continue
+		}
+		fmt.Printf("  %s: %s\n", k, v)
+	}
+}
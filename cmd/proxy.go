@@ -0,0 +1,259 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Interval of head polling which lets the cache treat blocks addressed by a level as final
+const proxyHeadInterval = 10 * time.Second
+
+// Idle client buckets are looked for at most this often
+const clientSweepInterval = time.Minute
+
+type proxyOptions struct {
+	listen      string
+	upstream    string
+	cacheDir    string
+	clientRate  float64
+	clientBurst int
+}
+
+// clientBucket is a token bucket of a single client address
+type clientBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// clientLimiter limits the request rate of every client address separately
+type clientLimiter struct {
+	rate  float64
+	burst float64
+
+	mtx     sync.Mutex
+	buckets map[string]*clientBucket
+	swept   time.Time
+}
+
+// sweep drops the buckets refilled since their last request, they are the same as the ones of new clients
+func (l *clientLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	interval := refill
+	if interval < clientSweepInterval {
+		interval = clientSweepInterval
+	}
+	if now.Sub(l.swept) < interval {
+		return
+	}
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+	l.swept = now
+}
+
+// allow takes a token of the client or returns the time to wait for one
+func (l *clientLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &clientBucket{tokens: l.burst}
+		l.buckets[client] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// NewProxyCommand returns new `proxy' command
+func NewProxyCommand(rootCtx *RootContext) *cobra.Command {
+	var opt proxyOptions
+
+	proxyCmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "Serve a caching read-only RPC proxy",
+		Long: `Serve the node's RPC to many consumers without hammering it. Only GET and HEAD requests are forwarded.
+Immutable block data (addressed by a hash or by a level at least --finality-depth levels behind the head) is cached
+in memory (--rpc-cache-size) and on disk (--cache-dir), other responses are revalidated if the node allows it.
+Requests to the node are limited by --rpc-rate and --rpc-burst, and every client address by --client-rate.
+The node's credentials (--rpc-user, --rpc-bearer-token) are added to the forwarded requests.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.serveProxy(&opt)
+		},
+	}
+
+	f := proxyCmd.Flags()
+	f.StringVar(&opt.listen, "listen", "127.0.0.1:8732", "Address to listen on")
+	f.StringVar(&opt.upstream, "upstream", "", "Node RPC end-point URL (default: --url)")
+	f.StringVar(&opt.cacheDir, "cache-dir", "~/.tez/proxy-cache", "Directory for immutable responses (overrides --rpc-cache-dir)")
+	f.Float64Var(&opt.clientRate, "client-rate", 0, "Maximum number of requests per second of a single client address, 0 means no limit")
+	f.IntVar(&opt.clientBurst, "client-burst", 20, "Maximum number of requests a client can send at once when --client-rate is set")
+
+	return proxyCmd
+}
+
+func (c *RootContext) serveProxy(opt *proxyOptions) error {
+	if c.cache == nil {
+		return errors.New("The proxy requires the RPC cache, remove --rpc-cache=false")
+	}
+	if opt.cacheDir != "" {
+		dir, err := utils.ExpandHome(opt.cacheDir)
+		if err != nil {
+			return err
+		}
+		c.cache.Dir = dir
+	}
+	if opt.upstream == "" {
+		opt.upstream = c.tezosURL
+	}
+	upstream, err := url.Parse(opt.upstream)
+	if err != nil {
+		return err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	proxy.Transport = c.transport
+	// Streamed responses like /monitor/heads are passed as they come
+	proxy.FlushInterval = 100 * time.Millisecond
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = upstream.Host
+	}
+
+	var limiter *clientLimiter
+	if opt.clientRate > 0 {
+		limiter = &clientLimiter{
+			rate:    opt.clientRate,
+			burst:   float64(opt.clientBurst),
+			buckets: make(map[string]*clientBucket),
+		}
+		if limiter.burst < 1 {
+			limiter.burst = 1
+		}
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "The proxy is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		if limiter != nil {
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+			if ok, wait := limiter.allow(client, time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		log.WithFields(log.Fields{
+			"remote": r.RemoteAddr,
+			"method": r.Method,
+			"url":    r.URL.String(),
+		}).Debug("Proxy request")
+		proxy.ServeHTTP(w, r)
+	})
+
+	l, err := net.Listen("tcp", opt.listen)
+	if err != nil {
+		return err
+	}
+	srv := http.Server{Handler: handler}
+
+	go func() {
+		<-c.context.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	go c.pollProxyHead(upstream)
+
+	log.WithFields(log.Fields{
+		"listen":    l.Addr().String(),
+		"upstream":  upstream.String(),
+		"cache_dir": c.cache.Dir,
+	}).Info("Proxy started")
+
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// pollProxyHead requests the head header through the cache which tracks the head level
+func (c *RootContext) pollProxyHead(upstream *url.URL) {
+	client := http.Client{Transport: c.transport}
+	u := *upstream
+	u.Path = fmt.Sprintf("%s/chains/%s/blocks/head/header", upstream.Path, c.chainID)
+
+	ticker := time.NewTicker(proxyHeadInterval)
+	defer ticker.Stop()
+	for {
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			log.WithError(err).Error("Can't poll the head")
+			return
+		}
+		resp, err := client.Do(req.WithContext(c.context))
+		if err != nil {
+			log.WithError(err).Warn("Can't poll the head")
+		} else {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.context.Done():
+			return
+		}
+	}
+}
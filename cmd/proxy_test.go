@@ -0,0 +1,63 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientLimiter(t *testing.T) {
+	l := &clientLimiter{
+		rate:    1,
+		burst:   2,
+		buckets: make(map[string]*clientBucket),
+	}
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d refused within the burst", i)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok {
+		t.Fatal("request allowed over the burst")
+	}
+	if wait != time.Second {
+		t.Errorf("wait = %v, want 1s", wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("clients share buckets")
+	}
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Error("bucket isn't refilled")
+	}
+
+	// Idle buckets are dropped once refilled
+	later := now.Add(clientSweepInterval)
+	if ok, _ := l.allow("c", later); !ok {
+		t.Fatal("request of a new client refused")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets kept, want 1", len(l.buckets))
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/spf13/cobra"
+)
+
+// Operation statuses
+const (
+	statusApplied     = "applied"
+	statusFailed      = "failed"
+	statusBacktracked = "backtracked"
+	statusSkipped     = "skipped"
+)
+
+// Manager operation fields shared by top level contents and internal operations
+type rawManagerOp struct {
+	Kind        string        `json:"kind"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Delegate    string        `json:"delegate"`
+	Amount      *tezos.BigInt `json:"amount"`
+	Balance     *tezos.BigInt `json:"balance"`
+	Parameters  *struct {
+		Entrypoint string `json:"entrypoint"`
+	} `json:"parameters"`
+}
+
+type rawInternalOp struct {
+	rawManagerOp
+	Result *operationResult `json:"result"`
+}
+
+type rawReceiptContent struct {
+	rawManagerOp
+	Fee      *tezos.BigInt `json:"fee"`
+	Metadata struct {
+		OperationResult          *operationResult `json:"operation_result"`
+		InternalOperationResults []*rawInternalOp `json:"internal_operation_results"`
+	} `json:"metadata"`
+}
+
+type rawReceiptOperation struct {
+	Hash     string               `json:"hash"`
+	Contents []*rawReceiptContent `json:"contents"`
+}
+
+type receiptItem struct {
+	Kind        string     `json:"kind" yaml:"kind"`
+	Source      string     `json:"source,omitempty" yaml:"source,omitempty"`
+	Destination string     `json:"destination,omitempty" yaml:"destination,omitempty"`
+	Entrypoint  string     `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
+	AmountMutez *big.Int   `json:"amount_mutez,omitempty" yaml:"amount_mutez,omitempty"`
+	Amount      *big.Float `json:"amount,omitempty" yaml:"amount,omitempty"`
+	Status      string     `json:"status,omitempty" yaml:"status,omitempty"`
+	Errors      []string   `json:"errors,omitempty" yaml:"errors,omitempty"`
+	Internal    bool       `json:"internal" yaml:"internal"`
+}
+
+type receipt struct {
+	Hash          string         `json:"hash" yaml:"hash"`
+	Status        string         `json:"status" yaml:"status"`
+	Block         string         `json:"block" yaml:"block"`
+	Level         int            `json:"level" yaml:"level"`
+	Timestamp     time.Time      `json:"timestamp" yaml:"timestamp"`
+	Confirmations int            `json:"confirmations" yaml:"confirmations"`
+	Link          string         `json:"link" yaml:"link"`
+	Items         []*receiptItem `json:"items" yaml:"items"`
+	FeeMutez      *big.Int       `json:"fee_mutez" yaml:"fee_mutez"`
+	BurnMutez     *big.Int       `json:"burn_mutez" yaml:"burn_mutez"`
+	Fee           *big.Float     `json:"fee" yaml:"fee"`
+	Burn          *big.Float     `json:"burn" yaml:"burn"`
+	Gas           *big.Int       `json:"gas" yaml:"gas"`
+	StorageBytes  *big.Int       `json:"storage_bytes" yaml:"storage_bytes"`
+}
+
+type receiptOptions struct {
+	depth  int
+	format string
+}
+
+// NewReceiptCommand returns new `receipt' command
+func NewReceiptCommand(rootCtx *RootContext) *cobra.Command {
+	var opt receiptOptions
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	receiptCmd := &cobra.Command{
+		Use:   "receipt <operation hash>",
+		Short: "Print a human-readable receipt of an included operation",
+		Long: `Print a wallet-style receipt of an included operation: transfers, contract calls with their entry points,
+internal operations, fees, burned storage costs, consumed gas, the final status and the number of confirmations.
+The operation is looked up the same way as with find, i.e. in the persistent RPC cache and then in the last --depth blocks.
+Confirmations are counted up to --block.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.showReceipt(args[0], &opt)
+		},
+	}
+
+	f := receiptCmd.Flags()
+	f.IntVar(&opt.depth, "depth", 120, "Number of recent blocks to search if the operation isn't found in the cache")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, markdown, yaml, json]")
+
+	return receiptCmd
+}
+
+// operationPosition returns the validation pass and the index of the operation within the block
+func (c *RootContext) operationPosition(blockHash, opHash string) (int, int, error) {
+	var passes [][]string
+	if err := c.getRPC(c.blockPath(blockHash)+"/operation_hashes", &passes); err != nil {
+		return 0, 0, err
+	}
+	for i, p := range passes {
+		for j, h := range p {
+			if h == opHash {
+				return i, j, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("Operation %s isn't included in %s", opHash, blockHash)
+}
+
+// explorerLink returns the block explorer link of the network or the RPC URL of the operation
+func (c *RootContext) explorerLink(blockHash string, pass, index int, opHash string) string {
+	if n, ok := networks[c.network]; ok && n.ExplorerURL != "" {
+		return n.ExplorerURL + "/" + opHash
+	}
+	return fmt.Sprintf("%s%s/operations/%d/%d", strings.TrimSuffix(c.tezosURL, "/"), c.blockPath(blockHash), pass, index)
+}
+
+func newReceiptItem(op *rawManagerOp, res *operationResult, internal bool, af *utils.AmountFormat) *receiptItem {
+	item := receiptItem{
+		Kind:        op.Kind,
+		Source:      op.Source,
+		Destination: op.Destination,
+		Internal:    internal,
+	}
+	switch {
+	case op.Amount != nil:
+		item.AmountMutez = &op.Amount.Int
+	case op.Balance != nil:
+		item.AmountMutez = &op.Balance.Int
+	}
+	if item.AmountMutez != nil {
+		item.Amount = af.Tez(item.AmountMutez)
+	}
+	if op.Destination == "" && op.Delegate != "" {
+		item.Destination = op.Delegate
+	}
+	if op.Parameters != nil && op.Parameters.Entrypoint != "default" {
+		item.Entrypoint = op.Parameters.Entrypoint
+	}
+	if res != nil {
+		item.Status = res.Status
+		if item.Destination == "" && len(res.OriginatedContracts) != 0 {
+			item.Destination = res.OriginatedContracts[0]
+		}
+		for _, e := range res.Errors {
+			item.Errors = append(item.Errors, e.ID)
+		}
+	}
+	return &item
+}
+
+// addResult accumulates costs of the applied result
+func (r *receipt) addResult(res *operationResult, originationSize int64) {
+	if res == nil || res.Status != statusApplied {
+		return
+	}
+	r.Gas.Add(r.Gas, res.gas())
+	r.StorageBytes.Add(r.StorageBytes, res.storage(originationSize))
+	for _, u := range res.BalanceUpdates {
+		if u.Kind == "burned" {
+			r.BurnMutez.Add(r.BurnMutez, &u.Change.Int)
+		}
+	}
+}
+
+func (c *BlockCommandContext) getReceipt(prefix string, depth int) (*receipt, error) {
+	if prefix == "" || prefix[0] != 'o' {
+		return nil, fmt.Errorf("`%s' isn't an operation hash", prefix)
+	}
+	m, err := c.locate(prefix, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	pass, index, err := c.operationPosition(m.Block, m.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var op rawReceiptOperation
+	if err := c.getRPC(fmt.Sprintf("%s/operations/%d/%d", c.blockPath(m.Block), pass, index), &op); err != nil {
+		return nil, err
+	}
+
+	var block, at blockTime
+	if err := c.getRPC(c.blockPath(m.Block)+"/header", &block); err != nil {
+		return nil, err
+	}
+	if err := c.getRPC(c.blockPath(c.blockID)+"/header", &at); err != nil {
+		return nil, err
+	}
+
+	// Storage is paid for by bytes, the size of a new contract isn't reported explicitly
+	var constants managerConstants
+	if err := c.getRPC(c.blockPath(m.Block)+"/context/constants", &constants); err != nil {
+		return nil, err
+	}
+
+	r := receipt{
+		Hash:          m.Hash,
+		Status:        statusApplied,
+		Block:         m.Block,
+		Level:         block.Level,
+		Timestamp:     block.Timestamp,
+		Confirmations: at.Level - block.Level,
+		Link:          c.explorerLink(m.Block, pass, index, m.Hash),
+		FeeMutez:      new(big.Int),
+		BurnMutez:     new(big.Int),
+		Gas:           new(big.Int),
+		StorageBytes:  new(big.Int),
+	}
+
+	for _, content := range op.Contents {
+		res := content.Metadata.OperationResult
+		r.Items = append(r.Items, newReceiptItem(&content.rawManagerOp, res, false, c.amountFormat))
+		if content.Fee != nil {
+			r.FeeMutez.Add(r.FeeMutez, &content.Fee.Int)
+		}
+		r.addResult(res, constants.OriginationSize)
+		if res != nil && res.Status != statusApplied && r.Status == statusApplied {
+			r.Status = res.Status
+		}
+
+		for _, iop := range content.Metadata.InternalOperationResults {
+			r.Items = append(r.Items, newReceiptItem(&iop.rawManagerOp, iop.Result, true, c.amountFormat))
+			r.addResult(iop.Result, constants.OriginationSize)
+		}
+	}
+
+	// Whole batch is reverted if any of its contents fails
+	if r.Status == statusBacktracked || r.Status == statusSkipped {
+		r.Status = statusFailed
+	}
+	r.Fee = c.amountFormat.Tez(r.FeeMutez)
+	r.Burn = c.amountFormat.Tez(r.BurnMutez)
+	return &r, nil
+}
+
+func (c *BlockCommandContext) showReceipt(prefix string, opt *receiptOptions) error {
+	var newEncoder utils.NewEncoderFunc
+	switch opt.format {
+	case "text", "markdown", "md":
+	default:
+		if newEncoder = c.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+
+	r, err := c.getReceipt(prefix, opt.depth)
+	if err != nil {
+		return err
+	}
+
+	switch opt.format {
+	case "text":
+		return c.writeReceipt(os.Stdout, r)
+	case "markdown", "md":
+		return c.writeReceiptMarkdown(os.Stdout, r)
+	default:
+		return newEncoder(os.Stdout).Encode(r)
+	}
+}
+
+// describe returns the item's summary line without the amount
+func (i *receiptItem) describe() string {
+	var b strings.Builder
+	b.WriteString(i.Source)
+	if i.Destination != "" {
+		b.WriteString(" → " + i.Destination)
+	}
+	if i.Entrypoint != "" {
+		b.WriteString(" %" + i.Entrypoint)
+	}
+	return b.String()
+}
+
+func (c *BlockCommandContext) statusText(status string) string {
+	switch status {
+	case statusApplied:
+		return c.colorizer.Green(status).String()
+	case "":
+		return ""
+	default:
+		return c.colorizer.Red(status).String()
+	}
+}
+
+func (c *BlockCommandContext) writeReceipt(w io.Writer, r *receipt) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Operation:     %s\n", r.Hash)
+	fmt.Fprintf(&b, "Status:        %s\n", c.statusText(r.Status))
+	fmt.Fprintf(&b, "Block:         %s (level %d, %s)\n", r.Block, r.Level, c.utcTime(r.Timestamp).Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "Confirmations: %d\n", r.Confirmations)
+	fmt.Fprintf(&b, "Link:          %s\n\n", r.Link)
+
+	for _, i := range r.Items {
+		prefix := "  "
+		if i.Internal {
+			prefix = "    ↳ "
+		}
+		line := fmt.Sprintf("%s%-12s %s", prefix, i.Kind, i.describe())
+		if i.AmountMutez != nil {
+			line += "  " + c.amountFormat.Format(i.AmountMutez)
+		}
+		if i.Status != "" && i.Status != statusApplied {
+			line += "  " + c.statusText(i.Status)
+		}
+		fmt.Fprintln(&b, line)
+		if len(i.Errors) != 0 {
+			fmt.Fprintf(&b, "%s  %s\n", strings.Repeat(" ", len(prefix)), c.colorizer.Red(strings.Join(i.Errors, ", ")))
+		}
+	}
+
+	fmt.Fprintf(&b, "\nFee:           %s\n", c.amountFormat.Format(r.FeeMutez))
+	fmt.Fprintf(&b, "Burn:          %s\n", c.amountFormat.Format(r.BurnMutez))
+	fmt.Fprintf(&b, "Gas:           %s\n", r.Gas)
+	fmt.Fprintf(&b, "Storage:       %s bytes\n", r.StorageBytes)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (c *BlockCommandContext) writeReceiptMarkdown(w io.Writer, r *receipt) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Operation `%s`\n\n", r.Hash)
+	fmt.Fprintf(&b, "| | |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Status | **%s** |\n", r.Status)
+	fmt.Fprintf(&b, "| Block | [`%s`](%s) (level %d, %s) |\n", r.Block, r.Link, r.Level, c.utcTime(r.Timestamp).Format(time.RFC3339))
+	fmt.Fprintf(&b, "| Confirmations | %d |\n", r.Confirmations)
+	fmt.Fprintf(&b, "| Fee | %s |\n", c.amountFormat.Format(r.FeeMutez))
+	fmt.Fprintf(&b, "| Burn | %s |\n", c.amountFormat.Format(r.BurnMutez))
+	fmt.Fprintf(&b, "| Gas | %s |\n", r.Gas)
+	fmt.Fprintf(&b, "| Storage | %s bytes |\n\n", r.StorageBytes)
+
+	fmt.Fprintf(&b, "| Kind | Transfer | Amount | Status |\n|---|---|--:|---|\n")
+	for _, i := range r.Items {
+		kind := i.Kind
+		if i.Internal {
+			kind = "↳ " + kind
+		}
+		var amount string
+		if i.AmountMutez != nil {
+			amount = c.amountFormat.Format(i.AmountMutez)
+		}
+		status := i.Status
+		if len(i.Errors) != 0 {
+			status += " (" + strings.Join(i.Errors, ", ") + ")"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", kind, strings.Replace(i.describe(), "|", "\\|", -1), amount, status)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,283 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the reorg list table
+var reorgColumns = []utils.TableColumn{
+	{Header: "TIME", Width: 20},
+	{Header: "LEVEL", Width: 9, Align: utils.AlignRight},
+	{Header: "DEPTH", Width: 5, Align: utils.AlignRight},
+	{Header: "ANCESTOR", Width: 9, Align: utils.AlignRight},
+	{Header: "ORPHANED", Width: 51, MinWidth: 13},
+	{Header: "NEW HEAD", Width: 51, MinWidth: 13},
+}
+
+// orphanedBlock is a block of the abandoned branch
+type orphanedBlock struct {
+	Hash      string    `json:"hash" yaml:"hash"`
+	Level     int       `json:"level" yaml:"level"`
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+}
+
+// reorgRecord is a record of the reorg log
+type reorgRecord struct {
+	ChainID string `json:"chain_id" yaml:"chain_id"`
+	// Time the reorg was observed at
+	Time          time.Time `json:"time" yaml:"time"`
+	Level         int       `json:"level" yaml:"level"`
+	Head          string    `json:"head" yaml:"head"`
+	HeadTimestamp time.Time `json:"head_timestamp" yaml:"head_timestamp"`
+	// Number of orphaned blocks
+	Depth int `json:"depth" yaml:"depth"`
+	// Common ancestor of both branches, empty if it's older than the blocks seen by the monitor
+	Ancestor      string           `json:"ancestor,omitempty" yaml:"ancestor,omitempty"`
+	AncestorLevel int              `json:"ancestor_level,omitempty" yaml:"ancestor_level,omitempty"`
+	Orphaned      []*orphanedBlock `json:"orphaned" yaml:"orphaned"`
+}
+
+// reorg reports the switch to a head which doesn't extend the last delivered one and drops the orphaned blocks
+func (m *headsMonitor) reorg(bi *tezos.BlockInfo) {
+	rec := reorgRecord{
+		Time:          time.Now().UTC(),
+		Level:         bi.Level,
+		Head:          bi.Hash,
+		HeadTimestamp: bi.Timestamp,
+	}
+
+	// Walk the new branch back until it meets the delivered chain
+	level, hash := bi.Level-1, bi.Predecessor
+	for i := 0; i < monitorDedupDepth; i++ {
+		known, ok := m.chain[level]
+		if !ok {
+			break
+		}
+		if known.Hash == hash {
+			rec.Ancestor, rec.AncestorLevel = hash, level
+			break
+		}
+		var header struct {
+			Predecessor string `json:"predecessor"`
+		}
+		if err := m.ctx.getRPC(m.ctx.blockPath(hash)+"/header", &header); err != nil {
+			log.WithError(err).Warn("Can't find the common ancestor of the reorganized chain")
+			break
+		}
+		level, hash = level-1, header.Predecessor
+	}
+
+	for l, b := range m.chain {
+		if l > level {
+			rec.Orphaned = append(rec.Orphaned, &orphanedBlock{Hash: b.Hash, Level: b.Level, Timestamp: b.Timestamp})
+			delete(m.chain, l)
+		}
+	}
+	if len(rec.Orphaned) == 0 {
+		return
+	}
+	sort.Slice(rec.Orphaned, func(i, j int) bool { return rec.Orphaned[i].Level < rec.Orphaned[j].Level })
+	rec.Depth = len(rec.Orphaned)
+
+	hashes := make([]string, len(rec.Orphaned))
+	for i, o := range rec.Orphaned {
+		hashes[i] = o.Hash
+	}
+	log.WithFields(log.Fields{
+		"block_level": bi.Level,
+		"head":        bi.Hash,
+		"depth":       rec.Depth,
+		"orphaned":    strings.Join(hashes, ","),
+	}).Info("Chain reorganization observed")
+
+	if !m.ctx.recordReorgs {
+		return
+	}
+	if m.chainID == "" {
+		if err := m.ctx.getRPC("/chains/"+m.ctx.chainID+"/chain_id", &m.chainID); err != nil {
+			log.WithError(err).Error("Can't update the reorg log")
+			return
+		}
+	}
+	rec.ChainID = m.chainID
+	if err := m.ctx.recordReorg(&rec); err != nil {
+		log.WithError(err).Error("Can't update the reorg log")
+	}
+}
+
+func (c *RootContext) recordReorg(rec *reorgRecord) error {
+	path, err := utils.ExpandHome(c.reorgLogPath)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return appendLine(path, buf)
+}
+
+// reorgLog loads the reorg log. Missing file is treated as an empty log
+func (c *RootContext) reorgLog() ([]*reorgRecord, error) {
+	path, err := utils.ExpandHome(c.reorgLogPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+
+	var list []*reorgRecord
+	s := bufio.NewScanner(fd)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+		var r reorgRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			// Last line may be torn by a crash
+			log.WithError(err).WithField("line", n).Warn("Skipping corrupted reorg log record")
+			continue
+		}
+		list = append(list, &r)
+	}
+	return list, s.Err()
+}
+
+// NewReorgsCommand returns new `reorgs' command
+func NewReorgsCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		minDepth     int
+		allChains    bool
+		outputFormat string
+		wide         bool
+		windowOpt    windowOptions
+	)
+
+	reorgsCmd := &cobra.Command{
+		Use:   "reorgs",
+		Short: "Review chain reorganizations observed by watch commands",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List recorded chain reorganizations",
+		Long: `List chain reorganizations recorded in the reorg log (--reorg-log) on the node's chain.
+Reorgs are recorded by any command watching new heads when --record-reorgs is set (or record_reorgs in the profile).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEncoder utils.NewEncoderFunc
+			if outputFormat != "text" {
+				if newEncoder = rootCtx.encoderFunc(outputFormat); newEncoder == nil || utils.IsBinary(outputFormat) || utils.IsTabular(outputFormat) {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+			}
+
+			window, err := windowOpt.window()
+			if err != nil {
+				return err
+			}
+
+			records, err := rootCtx.reorgLog()
+			if err != nil {
+				return err
+			}
+
+			var chainID string
+			if !allChains {
+				if err := rootCtx.getRPC("/chains/"+rootCtx.chainID+"/chain_id", &chainID); err != nil {
+					return err
+				}
+			}
+
+			list := make([]*reorgRecord, 0, len(records))
+			for _, r := range records {
+				if (allChains || r.ChainID == chainID) && r.Depth >= minDepth && window.contains(r.Time) {
+					list = append(list, r)
+				}
+			}
+			sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+
+			if newEncoder != nil {
+				return newEncoder(os.Stdout).Encode(list)
+			}
+			return rootCtx.writeReorgs(os.Stdout, list, wide)
+		},
+	}
+
+	listCmd.Flags().IntVar(&minDepth, "min-depth", 1, "List only reorgs orphaning at least this number of blocks")
+	listCmd.Flags().BoolVar(&allChains, "all-chains", false, "List reorgs on all chains, not only the node's one")
+	listCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	listCmd.Flags().BoolVar(&wide, "wide", false, "Show absolute times and don't truncate table columns to fit the terminal")
+	addWindowFlags(listCmd.Flags(), &windowOpt, "reorgs")
+
+	reorgsCmd.AddCommand(listCmd)
+
+	return reorgsCmd
+}
+
+// writeReorgs writes a row per orphaned block, the reorg itself is described by the first one
+func (c *RootContext) writeReorgs(w io.Writer, list []*reorgRecord, wide bool) error {
+	table := utils.NewTable(w, reorgColumns, listWidth(wide))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, r := range list {
+		ancestor := "unknown"
+		if r.Ancestor != "" {
+			ancestor = strconv.Itoa(r.AncestorLevel)
+		}
+		for i, o := range r.Orphaned {
+			var err error
+			if i == 0 {
+				err = table.WriteRow(c.listTime(r.Time, wide), strconv.Itoa(r.Level), strconv.Itoa(r.Depth), ancestor, o.Hash, r.Head)
+			} else {
+				err = table.WriteRow("", "", "", "", o.Hash, "")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the `operation replay' table
+var replayColumns = []utils.TableColumn{
+	{Header: "#", Width: 3, Align: utils.AlignRight},
+	{Header: "KIND", Width: 14},
+	{Header: "STATUS", Width: 26},
+	{Header: "GAS", Width: 22, Align: utils.AlignRight},
+	{Header: "STORAGE", Width: 14, Align: utils.AlignRight},
+	{Header: "INTERNAL", Width: 8, Align: utils.AlignRight},
+}
+
+type replayOptions struct {
+	at         string
+	depth      int
+	keepLimits bool
+	format     string
+}
+
+// replayResult is the outcome of an operation content. Gas is in milligas to show small differences
+type replayResult struct {
+	Status   string   `json:"status" yaml:"status"`
+	Milligas *big.Int `json:"milligas" yaml:"milligas"`
+	Storage  *big.Int `json:"storage_bytes" yaml:"storage_bytes"`
+	Internal int      `json:"internal" yaml:"internal"`
+	Errors   []string `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+type replayContent struct {
+	Kind     string        `json:"kind" yaml:"kind"`
+	Original *replayResult `json:"original" yaml:"original"`
+	Replay   *replayResult `json:"replay" yaml:"replay"`
+	Changed  bool          `json:"changed" yaml:"changed"`
+}
+
+type replayReport struct {
+	Hash     string           `json:"hash" yaml:"hash"`
+	Block    string           `json:"block" yaml:"block"`
+	Level    int              `json:"level" yaml:"level"`
+	At       string           `json:"at" yaml:"at"`
+	AtLevel  int              `json:"at_level" yaml:"at_level"`
+	Contents []*replayContent `json:"contents" yaml:"contents"`
+	Changed  bool             `json:"changed" yaml:"changed"`
+}
+
+// NewOperationCommand returns new `operation' command
+func NewOperationCommand(rootCtx *RootContext) *cobra.Command {
+	operationCmd := &cobra.Command{
+		Use:   "operation",
+		Short: "Included operation tools",
+	}
+
+	operationCmd.AddCommand(newOperationReplayCommand(rootCtx))
+
+	return operationCmd
+}
+
+func newOperationReplayCommand(rootCtx *RootContext) *cobra.Command {
+	var opt replayOptions
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "replay <operation hash>",
+		Short: "Re-simulate an included operation and compare the results",
+		Long: `Re-simulate an included manager operation with run_operation against the context of another block (the --block by default)
+and compare the status, gas, storage and internal operations with the original receipt, e.g. to debug regressions after a protocol upgrade.
+Counters are set to the next ones of the source at that block and reveals of already revealed keys are dropped.
+Gas and storage limits are raised to the maximum unless --keep-limits is given, so the actual consumption is seen.
+The operation is looked up the same way as with find, i.e. in the persistent RPC cache and then in the last --depth blocks.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEnc utils.NewEncoderFunc
+			if opt.format != "text" {
+				if newEnc = rootCtx.encoderFunc(opt.format); newEnc == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+					return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+				}
+			}
+			if opt.at == "" {
+				opt.at = rootCtx.blockID
+			}
+			r, err := ctx.replayOperation(args[0], &opt)
+			if err != nil {
+				return err
+			}
+			if newEnc != nil {
+				return newEnc(os.Stdout).Encode(r)
+			}
+			return ctx.writeReplayReport(os.Stdout, r)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&opt.at, "at", "", "Block whose context the operation is run against (default is the --block)")
+	f.IntVar(&opt.depth, "depth", 120, "Number of recent blocks to search if the operation isn't found in the cache")
+	f.BoolVar(&opt.keepLimits, "keep-limits", false, "Keep the original gas and storage limits")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+
+	return cmd
+}
+
+func newReplayResult(r *simulatedContent) *replayResult {
+	res := replayResult{
+		Milligas: new(big.Int),
+		Storage:  new(big.Int),
+		Internal: len(r.Metadata.InternalOperationResults),
+	}
+	add := func(or *operationResult) {
+		if v, ok := new(big.Int).SetString(or.ConsumedMilligas, 10); ok {
+			res.Milligas.Add(res.Milligas, v)
+		} else if v, ok := new(big.Int).SetString(or.ConsumedGas, 10); ok {
+			res.Milligas.Add(res.Milligas, v.Mul(v, big.NewInt(1000)))
+		}
+		if v, ok := new(big.Int).SetString(or.PaidStorageSizeDiff, 10); ok {
+			res.Storage.Add(res.Storage, v)
+		}
+		for _, e := range or.Errors {
+			res.Errors = append(res.Errors, e.ID)
+		}
+	}
+	if or := r.Metadata.OperationResult; or != nil {
+		res.Status = or.Status
+		add(or)
+	}
+	for _, ir := range r.Metadata.InternalOperationResults {
+		if ir.Result != nil {
+			add(ir.Result)
+		}
+	}
+	return &res
+}
+
+func (c *BlockCommandContext) replayOperation(hash string, opt *replayOptions) (*replayReport, error) {
+	if hash == "" || hash[0] != 'o' {
+		return nil, fmt.Errorf("`%s' isn't an operation hash", hash)
+	}
+	m, err := c.locate(hash, opt.depth)
+	if err != nil {
+		return nil, err
+	}
+	pass, index, err := c.operationPosition(m.Block, m.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if pass != managerPass {
+		return nil, fmt.Errorf("Operation %s isn't a manager operation", m.Hash)
+	}
+
+	var op struct {
+		Contents []json.RawMessage `json:"contents"`
+	}
+	if err := c.getRPC(fmt.Sprintf("%s/operations/%d/%d", c.blockPath(m.Block), pass, index), &op); err != nil {
+		return nil, err
+	}
+
+	var at struct {
+		Hash  string `json:"hash"`
+		Level int    `json:"level"`
+	}
+	if err := c.getRPC(c.blockPath(opt.at)+"/header", &at); err != nil {
+		return nil, err
+	}
+	atPath := c.blockPath(at.Hash)
+
+	var constants managerConstants
+	if err := c.getRPC(atPath+"/context/constants", &constants); err != nil {
+		return nil, err
+	}
+
+	r := replayReport{
+		Hash:    m.Hash,
+		Block:   m.Block,
+		Level:   m.Level,
+		At:      at.Hash,
+		AtLevel: at.Level,
+	}
+
+	// Counters are assigned per source as contents of a batch share it
+	counters := make(map[string]*big.Int)
+	var contents []map[string]interface{}
+	for _, raw := range op.Contents {
+		var orig simulatedContent
+		if err := json.Unmarshal(raw, &orig); err != nil {
+			return nil, err
+		}
+		var content map[string]interface{}
+		if err := json.Unmarshal(raw, &content); err != nil {
+			return nil, err
+		}
+		delete(content, "metadata")
+		source, _ := content["source"].(string)
+
+		counter, ok := counters[source]
+		if !ok {
+			var v tezos.BigInt
+			if err := c.getRPC(atPath+"/context/contracts/"+source+"/counter", &v); err != nil {
+				return nil, err
+			}
+			counter = &v.Int
+			counters[source] = counter
+		}
+
+		if orig.Kind == protocol.KindReveal {
+			var managerKey *string
+			if err := c.getRPC(atPath+"/context/contracts/"+source+"/manager_key", &managerKey); err != nil {
+				return nil, err
+			}
+			if managerKey != nil {
+				log.WithField("address", source).Info("Public key is already revealed, skipping reveal")
+				continue
+			}
+		}
+
+		counter.Add(counter, big.NewInt(1))
+		content["counter"] = counter.String()
+		if !opt.keepLimits {
+			content["gas_limit"] = constants.HardGasLimitPerOperation.String()
+			content["storage_limit"] = constants.HardStorageLimitPerOperation.String()
+		}
+		contents = append(contents, content)
+		r.Contents = append(r.Contents, &replayContent{
+			Kind:     orig.Kind,
+			Original: newReplayResult(&orig),
+		})
+	}
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("Operation %s has nothing to replay", m.Hash)
+	}
+
+	log.WithFields(log.Fields{
+		"operation": m.Hash,
+		"at":        at.Hash,
+		"level":     at.Level,
+	}).Debug("Replaying")
+
+	results, err := c.runContents(at.Hash, at.Hash, contents, len(contents))
+	if err != nil {
+		return nil, err
+	}
+	for i, res := range results {
+		rc := r.Contents[i]
+		rc.Replay = newReplayResult(res)
+		rc.Changed = rc.Original.Status != rc.Replay.Status ||
+			rc.Original.Milligas.Cmp(rc.Replay.Milligas) != 0 ||
+			rc.Original.Storage.Cmp(rc.Replay.Storage) != 0 ||
+			rc.Original.Internal != rc.Replay.Internal
+		if rc.Changed {
+			r.Changed = true
+		}
+	}
+	return &r, nil
+}
+
+// gasChange formats the gas change rounded up to gas units with the relative difference
+func gasChange(a, b *big.Int) string {
+	units := func(v *big.Int) string {
+		n := new(big.Int).Add(v, big.NewInt(999))
+		return n.Quo(n, big.NewInt(1000)).String()
+	}
+	if a.Cmp(b) == 0 {
+		return units(a)
+	}
+	s := units(a) + " -> " + units(b)
+	if a.Sign() != 0 {
+		d, _ := new(big.Float).Quo(new(big.Float).SetInt(new(big.Int).Sub(b, a)), new(big.Float).SetInt(a)).Float64()
+		s += fmt.Sprintf(" (%+.1f%%)", d*100)
+	}
+	return s
+}
+
+func (c *BlockCommandContext) writeReplayReport(w io.Writer, r *replayReport) error {
+	_, err := fmt.Fprintf(w, "Operation:  %s\nIncluded:   %d %s\nReplayed:   %d %s\n\n", r.Hash, r.Level, r.Block, r.AtLevel, r.At)
+	if err != nil {
+		return err
+	}
+
+	table := utils.NewTable(w, replayColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for i, rc := range r.Contents {
+		o, p := rc.Original, rc.Replay
+		status := o.Status
+		if o.Status != p.Status {
+			status = c.colorizer.Red(o.Status + " -> " + p.Status).String()
+		}
+		storage := o.Storage.String()
+		if o.Storage.Cmp(p.Storage) != 0 {
+			storage += " -> " + p.Storage.String()
+		}
+		internal := strconv.Itoa(o.Internal)
+		if o.Internal != p.Internal {
+			internal += " -> " + strconv.Itoa(p.Internal)
+		}
+		if err := table.WriteRow(strconv.Itoa(i+1), rc.Kind, status, gasChange(o.Milligas, p.Milligas), storage, internal); err != nil {
+			return err
+		}
+	}
+
+	for i, rc := range r.Contents {
+		if len(rc.Replay.Errors) != 0 {
+			if _, err := fmt.Fprintf(w, "\n#%d replay errors: %s", i+1, describeErrorIDs(rc.Replay.Errors)); err != nil {
+				return err
+			}
+		}
+	}
+
+	summary := c.colorizer.Green("Replay matches the original receipt").String()
+	if r.Changed {
+		summary = c.colorizer.Yellow("Replay differs from the original receipt").String()
+	}
+	_, err = fmt.Fprintf(w, "\n%s\n", summary)
+	return err
+}
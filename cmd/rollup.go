@@ -0,0 +1,365 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/spf13/cobra"
+)
+
+const rollupTemplate = `Address:                  {{.Address | au.Bold}}
+Kind:                     {{.Kind}}
+Genesis level:            {{.Genesis.Level}}
+Genesis commitment:       {{.Genesis.CommitmentHash}}
+Last cemented commitment: {{.LastCemented.Hash}}
+Last cemented level:      {{.LastCemented.Level | au.Green}}
+{{- if .Stakers}}
+Stakers:
+{{- range .Stakers}}
+  {{.Staker | au.Yellow}}{{with .Commitment}} {{.Hash}}{{with .Commitment}} (inbox level {{.InboxLevel}}){{end}}{{else}} -{{end}}
+{{- end}}
+{{- end}}
+`
+
+const rollupOpsTemplate = `{{range .}}{{.Level}} {{.Hash | au.Bold}} {{.Kind | au.Yellow}} {{.Source}}{{if .Rollup}} {{.Rollup}}{{end}}{{if .Status}} {{.Status}}{{end}}
+{{- range .Messages}}
+  {{.}}
+{{- end}}
+{{end}}`
+
+type rollupGenesisInfo struct {
+	Level          int    `json:"level" yaml:"level"`
+	CommitmentHash string `json:"commitment_hash" yaml:"commitment_hash"`
+}
+
+type rollupCementedInfo struct {
+	Hash  string `json:"hash" yaml:"hash"`
+	Level int    `json:"level" yaml:"level"`
+}
+
+type rollupCommitment struct {
+	CompressedState string `json:"compressed_state" yaml:"compressed_state"`
+	InboxLevel      int    `json:"inbox_level" yaml:"inbox_level"`
+	Predecessor     string `json:"predecessor" yaml:"predecessor"`
+	NumberOfTicks   string `json:"number_of_ticks" yaml:"number_of_ticks"`
+}
+
+type rollupStakedCommitment struct {
+	Hash       string            `json:"hash" yaml:"hash"`
+	Commitment *rollupCommitment `json:"commitment,omitempty" yaml:"commitment,omitempty"`
+}
+
+type rollupStaker struct {
+	Staker     string                  `json:"staker" yaml:"staker"`
+	Commitment *rollupStakedCommitment `json:"commitment,omitempty" yaml:"commitment,omitempty"`
+}
+
+type rollupInfo struct {
+	Address      string              `json:"address" yaml:"address"`
+	Kind         string              `json:"kind" yaml:"kind"`
+	Genesis      *rollupGenesisInfo  `json:"genesis_info" yaml:"genesis_info"`
+	LastCemented *rollupCementedInfo `json:"last_cemented_commitment" yaml:"last_cemented_commitment"`
+	Stakers      []*rollupStaker     `json:"stakers,omitempty" yaml:"stakers,omitempty"`
+}
+
+// Smart rollup related operation extracted from the raw block operations
+type rollupOpInfo struct {
+	Level    int      `json:"level" yaml:"level"`
+	Hash     string   `json:"hash" yaml:"hash"`
+	Kind     string   `json:"kind" yaml:"kind"`
+	Source   string   `json:"source" yaml:"source"`
+	Rollup   string   `json:"rollup,omitempty" yaml:"rollup,omitempty"`
+	Messages []string `json:"messages,omitempty" yaml:"messages,omitempty"`
+	Status   string   `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+type rawOperation struct {
+	Hash     string            `json:"hash"`
+	Contents []json.RawMessage `json:"contents"`
+}
+
+type rawRollupOpContents struct {
+	Kind     string   `json:"kind"`
+	Source   string   `json:"source"`
+	Rollup   string   `json:"rollup"`
+	Message  []string `json:"message"`
+	Metadata struct {
+		OperationResult struct {
+			Status string `json:"status"`
+		} `json:"operation_result"`
+	} `json:"metadata"`
+}
+
+// RollupCommandContext represents `rollup' command context
+type RollupCommandContext struct {
+	*RootContext
+	watch      bool
+	newEncoder utils.NewEncoderFunc
+	funcMap    template.FuncMap
+}
+
+// NewRollupCommand returns new `rollup' command
+func NewRollupCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		outputFormat string
+		rollupCmd    *cobra.Command // Forward declaration, see PersistentPreRunE below
+	)
+
+	ctx := RollupCommandContext{
+		RootContext: rootCtx,
+	}
+
+	rollupCmd = &cobra.Command{
+		Use:   "rollup",
+		Short: "Smart rollup inspection",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if p := rollupCmd.Parent(); p != nil {
+				if pr := p.PersistentPreRunE; pr != nil {
+					if err := pr(cmd, args); err != nil {
+						return err
+					}
+				}
+			}
+
+			if outputFormat != "text" {
+				if ctx.newEncoder = rootCtx.encoderFunc(outputFormat); ctx.newEncoder == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+			}
+			ctx.funcMap = template.FuncMap{
+				"au": func() interface{} { return ctx.colorizer },
+			}
+			return nil
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List originated smart rollups",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var addrs []string
+			if err := ctx.getRPC(ctx.blockPath(ctx.blockID)+"/context/smart_rollups/all", &addrs); err != nil {
+				return err
+			}
+			if ctx.newEncoder != nil {
+				return ctx.newEncoder(os.Stdout).Encode(addrs)
+			}
+			for _, a := range addrs {
+				fmt.Println(a)
+			}
+			return nil
+		},
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show <address>",
+		Short: "Show smart rollup genesis info, cemented commitment and stakers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info, err := ctx.getRollupInfo(args[0])
+			if err != nil {
+				return err
+			}
+			return ctx.print(rollupTemplate, info)
+		},
+	}
+
+	inboxCmd := &cobra.Command{
+		Use:   "inbox [block]",
+		Short: "Show messages added to the smart rollups inbox in a block",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			blockID := ctx.blockID
+			if len(args) != 0 {
+				blockID = args[0]
+			}
+			return ctx.showOperations(blockID, func(op *rollupOpInfo) bool {
+				return op.Kind == "smart_rollup_add_messages"
+			})
+		},
+	}
+
+	opsCmd := &cobra.Command{
+		Use:   "operations [block]",
+		Short: "Show smart rollup operations in a block",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			blockID := ctx.blockID
+			if len(args) != 0 {
+				blockID = args[0]
+			}
+			return ctx.showOperations(blockID, nil)
+		},
+	}
+
+	rollupCmd.PersistentFlags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json, msgpack, cbor]")
+	inboxCmd.Flags().BoolVar(&ctx.watch, "watch", false, "Watch for new blocks in a chain")
+	opsCmd.Flags().BoolVar(&ctx.watch, "watch", false, "Watch for new blocks in a chain")
+
+	rollupCmd.AddCommand(listCmd)
+	rollupCmd.AddCommand(showCmd)
+	rollupCmd.AddCommand(inboxCmd)
+	rollupCmd.AddCommand(opsCmd)
+
+	return rollupCmd
+}
+
+func (c *RollupCommandContext) print(tpl string, data interface{}) error {
+	if c.newEncoder != nil {
+		return c.newEncoder(os.Stdout).Encode(data)
+	}
+
+	t, err := template.New("rollup").Funcs(c.funcMap).Parse(tpl)
+	if err != nil {
+		return err
+	}
+	return t.Execute(os.Stdout, data)
+}
+
+func (c *RollupCommandContext) getRollupInfo(address string) (*rollupInfo, error) {
+	prefix := c.blockPath(c.blockID) + "/context/smart_rollups/smart_rollup/" + address
+
+	info := rollupInfo{
+		Address:      address,
+		Genesis:      &rollupGenesisInfo{},
+		LastCemented: &rollupCementedInfo{},
+	}
+
+	if err := c.getRPC(prefix+"/kind", &info.Kind); err != nil {
+		return nil, err
+	}
+	if err := c.getRPC(prefix+"/genesis_info", info.Genesis); err != nil {
+		return nil, err
+	}
+	if err := c.getRPC(prefix+"/last_cemented_commitment_hash_with_level", info.LastCemented); err != nil {
+		return nil, err
+	}
+
+	var stakers []string
+	if err := c.getRPC(prefix+"/stakers", &stakers); err != nil {
+		return nil, err
+	}
+
+	for _, s := range stakers {
+		staker := rollupStaker{Staker: s}
+		// null if the staker has no commitment yet
+		if err := c.getRPC(prefix+"/staker/"+s+"/staked_on_commitment", &staker.Commitment); err != nil {
+			return nil, err
+		}
+		info.Stakers = append(info.Stakers, &staker)
+	}
+
+	return &info, nil
+}
+
+// getRollupOperations returns smart rollup related operations of the block
+func (c *RollupCommandContext) getRollupOperations(blockID string) ([]*rollupOpInfo, error) {
+	var header struct {
+		Level int `json:"level"`
+	}
+	if err := c.getRPC(c.blockPath(blockID)+"/header", &header); err != nil {
+		return nil, err
+	}
+
+	var passes [][]*rawOperation
+	if err := c.getRPC(c.blockPath(blockID)+"/operations", &passes); err != nil {
+		return nil, err
+	}
+
+	var res []*rollupOpInfo
+	for _, pass := range passes {
+		for _, op := range pass {
+			for _, raw := range op.Contents {
+				var el rawRollupOpContents
+				if err := json.Unmarshal(raw, &el); err != nil {
+					return nil, err
+				}
+				if !strings.HasPrefix(el.Kind, "smart_rollup_") {
+					continue
+				}
+
+				res = append(res, &rollupOpInfo{
+					Level:    header.Level,
+					Hash:     op.Hash,
+					Kind:     el.Kind,
+					Source:   el.Source,
+					Rollup:   el.Rollup,
+					Messages: el.Message,
+					Status:   el.Metadata.OperationResult.Status,
+				})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func (c *RollupCommandContext) showOperations(blockID string, filter func(op *rollupOpInfo) bool) error {
+	show := func(blockID string) error {
+		ops, err := c.getRollupOperations(blockID)
+		if err != nil {
+			return err
+		}
+
+		filtered := make([]*rollupOpInfo, 0, len(ops))
+		for _, op := range ops {
+			if filter == nil || filter(op) {
+				filtered = append(filtered, op)
+			}
+		}
+
+		if len(filtered) == 0 && c.watch {
+			return nil
+		}
+		return c.print(rollupOpsTemplate, filtered)
+	}
+
+	if !c.watch {
+		return show(blockID)
+	}
+
+	var monErr error
+	ch := make(chan *tezos.BlockInfo, 10)
+	go func() {
+		monErr = c.monitorHeads(ch)
+		close(ch)
+	}()
+
+	for bi := range ch {
+		if err := show(bi.Hash); err != nil {
+			return err
+		}
+	}
+
+	if monErr != nil && monErr != context.Canceled {
+		return monErr
+	}
+	return nil
+}
@@ -22,23 +22,143 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/rpc"
 	"github.com/logrusorgru/aurora"
 	"github.com/mattn/go-isatty"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+// Environment variables holding RPC credentials so they don't end up in the shell history
+const (
+	RPCPasswordEnv    = "TEZ_RPC_PASSWORD"
+	RPCBearerTokenEnv = "TEZ_RPC_BEARER_TOKEN"
+)
+
 // RootContext represents root command context shared with its children
 type RootContext struct {
-	tezosURL  string
-	chainID   string
-	service   *tezos.Service
-	colorizer aurora.Aurora
-	context   context.Context
+	tezosURL     string
+	chainID      string
+	service      *tezos.Service
+	colorizer    aurora.Aurora
+	context      context.Context
+	amountFormat *utils.AmountFormat
+	cache        *rpc.Cache
+	transport    http.RoundTripper
+	// Block used as a context for state queries
+	blockID string
+	// Secondary endpoint used for verification
+	verifyService *tezos.Service
+	keystorePath  string
+	// tezos-client base directory used along with the keystore, see `--client-dir'
+	clientDir   string
+	clientWrite bool
+	// Address book of originated contracts
+	contractsPath string
+	// Network preset and the chain ID the node is expected to be on
+	network         string
+	expectedChainID string
+	chainIDSource   string
+	chainCheck      *chainCheck
+	// Transport to the node without the chain ID check
+	nodeTransport http.RoundTripper
+	configPath    string
+	profileName   string
+	// Secrets are kept in the file instead of the OS keychain if noKeyring is set
+	noKeyring   bool
+	secretsPath string
+	// Local log of injected operations, see `tez injections'
+	journalPath string
+	// Last blocks and consensus operations signed by baker keys
+	highWatermarksPath string
+	// Reorgs observed by heads monitors are appended to reorgLogPath if recordReorgs is set, see `tez reorgs'
+	recordReorgs bool
+	reorgLogPath string
+	// Output compatibility version of machine-readable encodings, 0 is the latest format
+	apiVersion int
+	// Time zone of displayed timestamps given with --timezone, nil if it's not set
+	location *time.Location
+}
+
+// localTime returns t in the --timezone time zone, the system one by default. Used by live and watch outputs
+func (c *RootContext) localTime(t time.Time) time.Time {
+	if c.location != nil {
+		return t.In(c.location)
+	}
+	return t.Local()
+}
+
+// utcTime returns t in the --timezone time zone, UTC by default
+func (c *RootContext) utcTime(t time.Time) time.Time {
+	if c.location != nil {
+		return t.In(c.location)
+	}
+	return t.UTC()
+}
+
+// encoderFunc is like utils.GetEncoderFunc but markdown and HTML reports render timestamps in the --timezone time zone
+func (c *RootContext) encoderFunc(format string) utils.NewEncoderFunc {
+	if c.location != nil {
+		return utils.GetEncoderFuncIn(format, c.location)
+	}
+	return c.encoderFunc(format)
+}
+
+// Build information, set with -ldflags "-X github.com/ecadlabs/tez/cmd.Version=v1.2.3 -X github.com/ecadlabs/tez/cmd.ReleasePublicKey=edpk..."
+var (
+	Version = "dev"
+	// ReleasePublicKey verifies signatures of release checksums, see `tez self-update'
+	ReleasePublicKey string
+)
+
+// Commands annotated with offlineAnnotation, or whose parent is, skip the configuration and RPC setup.
+// They don't talk to the node and some of them must work with a broken configuration
+const offlineAnnotation = "offline"
+
+// Commands annotated with headAnnotation, or whose parent is, work on the current head: they inject operations or watch
+// the mempool and new blocks. An explicit --block is rejected instead of being ignored
+const headAnnotation = "head"
+
+func annotated(cmd *cobra.Command, annotation string) bool {
+	for ; cmd != nil; cmd = cmd.Parent() {
+		if _, ok := cmd.Annotations[annotation]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func offline(cmd *cobra.Command) bool {
+	return annotated(cmd, offlineAnnotation)
+}
+
+// setupLog applies --log and --log-format, it is the only setup every command gets
+func setupLog(level, format string) error {
+	switch format {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	default:
+		return fmt.Errorf("Unknown log format: `%s'", format)
+	}
+	lv, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lv)
+	return nil
 }
 
 // NewRootCommand returns new root command
@@ -46,32 +166,213 @@ func NewRootCommand(ctx context.Context) *cobra.Command {
 	var (
 		useColors bool
 		level     string
+		logFormat string
+		debugHTTP bool
+		httpDump  string
+		unit      string
+		precision int
+		rounding  string
+		locale    string
+		timezone  string
+		useCache  bool
+		cacheDir  string
+		cacheSize int
+		depth     int
+		verify    bool
+		verifyURL string
+		auth      rpc.Auth
+		limiter   rpc.RateLimiter
 	)
 
 	c := RootContext{
-		context: ctx,
+		context:    ctx,
+		chainCheck: &chainCheck{},
 	}
 
-	rootCmd := &cobra.Command{
-		Use:   "tez",
-		Short: "An alternative CLI utility for Tezos",
-		Long:  `This utility allows you to inspect and manipulate a running Tezos instance`,
+	var rootCmd *cobra.Command // Forward declaration, see PersistentPreRunE below
+	rootCmd = &cobra.Command{
+		Use:     "tez",
+		Short:   "An alternative CLI utility for Tezos",
+		Long:    `This utility allows you to inspect and manipulate a running Tezos instance`,
+		Version: Version,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
-			// cmd always points to the top level command!!!
 			c.colorizer = aurora.NewAurora(useColors && isatty.IsTerminal(os.Stdout.Fd()))
-			client, err := tezos.NewRPCClient(nil, c.tezosURL)
+
+			if err := setupLog(level, logFormat); err != nil {
+				return err
+			}
+			if offline(cmd) {
+				return nil
+			}
+
+			conf, err := loadConfig(c.configPath)
+			if err != nil {
+				return err
+			}
+			profile, err := conf.profile(c.profileName)
 			if err != nil {
-				err = fmt.Errorf("Failed to initilize tezos RPC client: %v", err)
+				return err
 			}
 
-			c.service = &tezos.Service{Client: client}
+			// Explicit flags take precedence over the profile
+			flags := rootCmd.PersistentFlags()
+			if profile != nil {
+				for _, v := range []struct {
+					flag  string
+					dst   *string
+					value string
+				}{
+					{"network", &c.network, profile.Network},
+					{"url", &c.tezosURL, profile.URL},
+					{"chain", &c.chainID, profile.Chain},
+					{"keystore", &c.keystorePath, profile.Keystore},
+					{"client-dir", &c.clientDir, profile.ClientDir},
+					{"contracts-file", &c.contractsPath, profile.ContractsFile},
+					{"injection-journal", &c.journalPath, profile.InjectionJournal},
+					{"high-watermarks", &c.highWatermarksPath, profile.HighWatermarks},
+					{"reorg-log", &c.reorgLogPath, profile.ReorgLog},
+					{"rounding", &rounding, profile.Rounding},
+					{"timezone", &timezone, profile.Timezone},
+					{"rpc-user", &auth.User, profile.RPCUser},
+					{"rpc-password", &auth.Password, profile.RPCPassword},
+					{"rpc-bearer-token", &auth.BearerToken, profile.RPCBearerToken},
+				} {
+					if v.value != "" && !flags.Changed(v.flag) {
+						*v.dst = v.value
+					}
+				}
+			}
+
+			net, ok := networks[c.network]
+			if !ok {
+				return fmt.Errorf("Unknown network: `%s'", c.network)
+			}
+			if net.URL != "" && !flags.Changed("url") && (profile == nil || profile.URL == "") {
+				c.tezosURL = net.URL
+				// Be gentle with public end-points
+				if !flags.Changed("rpc-rate") {
+					limiter.Rate = net.RateLimit
+				}
+			}
+			if profile != nil {
+				if profile.RPCRate != 0 && !flags.Changed("rpc-rate") {
+					limiter.Rate = profile.RPCRate
+				}
+				if profile.RPCBurst != 0 && !flags.Changed("rpc-burst") {
+					limiter.Burst = profile.RPCBurst
+				}
+				if profile.APIVersion != 0 && !flags.Changed("api-version") {
+					c.apiVersion = profile.APIVersion
+				}
+				if profile.Precision != nil && !flags.Changed("precision") {
+					precision = *profile.Precision
+				}
+				if profile.RecordReorgs && !flags.Changed("record-reorgs") {
+					c.recordReorgs = true
+				}
+			}
+			if c.apiVersion < 0 || c.apiVersion > latestAPIVersion {
+				return fmt.Errorf("Unsupported API version: %d", c.apiVersion)
+			}
+			c.expectedChainID, c.chainIDSource = net.ChainID, fmt.Sprintf("network `%s'", c.network)
+			if profile != nil && profile.ChainID != "" {
+				if c.expectedChainID != "" && c.expectedChainID != profile.ChainID {
+					return fmt.Errorf("Profile expects chain %s but network `%s' is %s", profile.ChainID, c.network, c.expectedChainID)
+				}
+				name := c.profileName
+				if name == "" {
+					name = conf.DefaultProfile
+				}
+				c.expectedChainID, c.chainIDSource = profile.ChainID, fmt.Sprintf("profile `%s'", name)
+			}
+
+			// Explicit flags take precedence over the environment and the environment over the profile
+			for _, v := range []struct {
+				flag string
+				dst  *string
+				env  string
+			}{
+				{"rpc-password", &auth.Password, RPCPasswordEnv},
+				{"rpc-bearer-token", &auth.BearerToken, RPCBearerTokenEnv},
+			} {
+				if e := os.Getenv(v.env); e != "" && !flags.Changed(v.flag) {
+					*v.dst = e
+				}
+			}
+			for _, p := range []*string{&auth.Password, &auth.BearerToken} {
+				if *p, err = c.resolveSecret(*p); err != nil {
+					return err
+				}
+			}
+			if auth.User != "" && auth.BearerToken != "" {
+				return errors.New("RPC basic authentication and bearer token are mutually exclusive")
+			}
+			if (auth.User != "" || auth.BearerToken != "") && isPlainRemoteURL(c.tezosURL) {
+				log.WithField("url", c.tezosURL).Warn("RPC credentials are sent over plain HTTP")
+			}
+
+			// Credentials are added after logging so they never appear in dumps
+			auth.Transport = http.DefaultTransport
+			logger := rpc.Logger{
+				Transport: &auth,
+				Trace:     debugHTTP,
+			}
+			if httpDump != "" {
+				fd, err := os.OpenFile(httpDump, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+				if err != nil {
+					return err
+				}
+				logger.Dump = fd
+			}
+
+			limiter.Transport = &logger
+			var transport http.RoundTripper = &limiter
+			if useCache {
+				c.cache = &rpc.Cache{
+					Transport:     transport,
+					FinalityDepth: depth,
+					MaxEntries:    cacheSize,
+					Dir:           cacheDir,
+				}
+				transport = c.cache
+			}
 
-			lv, err := log.ParseLevel(level)
+			c.nodeTransport = transport
+			c.transport = &chainIDGuard{Transport: transport, ctx: &c}
+			client, err := tezos.NewRPCClient(&http.Client{Transport: c.transport}, c.tezosURL)
 			if err != nil {
+				return fmt.Errorf("Failed to initilize tezos RPC client: %v", err)
+			}
+
+			c.service = &tezos.Service{Client: client}
+
+			if verify {
+				if verifyURL == "" {
+					return errors.New("--verify requires a secondary endpoint URL (--verify-url)")
+				}
+				vc, err := tezos.NewRPCClient(&http.Client{Transport: &rpc.Logger{Transport: http.DefaultTransport, Trace: debugHTTP}}, verifyURL)
+				if err != nil {
+					return fmt.Errorf("Failed to initilize tezos RPC client: %v", err)
+				}
+				c.verifyService = &tezos.Service{Client: vc}
+			}
+
+			if c.blockID == "" || strings.ContainsAny(c.blockID, "/?#") {
+				return fmt.Errorf("Invalid block ID: `%s'", c.blockID)
+			}
+			if annotated(cmd, headAnnotation) && cmd.Flags().Changed("block") {
+				return fmt.Errorf("`%s' works on the current head and doesn't accept --block", cmd.CommandPath())
+			}
+
+			if c.amountFormat, err = utils.NewAmountFormat(unit, precision, rounding, locale); err != nil {
 				return err
 			}
 
-			log.SetLevel(lv)
+			if timezone != "" {
+				if c.location, err = utils.LoadTimezone(timezone); err != nil {
+					return err
+				}
+			}
 
 			return
 		},
@@ -81,14 +382,97 @@ func NewRootCommand(ctx context.Context) *cobra.Command {
 
 	f.StringVarP(&c.tezosURL, "url", "u", "https://api.tez.ie/", "Tezos RPC end-point URL")
 	f.StringVar(&c.chainID, "chain", "main", "Chain ID")
+	f.StringVar(&c.network, "network", NetworkCustom, "Network preset selecting the default end-point and the expected chain ID: one of ["+strings.Join(networkNames(), ", ")+"]")
+	f.StringVar(&c.blockID, "block", "head", "Block to evaluate state queries at: hash, level, head or head~N (rejected by commands injecting operations or watching the mempool and new blocks)")
 	f.BoolVar(&useColors, "colors", true, "Use colors")
 	f.StringVar(&level, "log", "info", "Log level: [error, warn, info, debug, trace]")
+	f.StringVar(&logFormat, "log-format", "text", "Log format: one of [text, json]")
+	f.BoolVar(&debugHTTP, "debug-http", false, "Log every RPC call with its URL, status, response size and latency")
+	f.StringVar(&httpDump, "debug-http-dump", "", "Append full RPC requests and responses to the file")
+	f.StringVar(&unit, "unit", "tez", "Amount unit: one of [tez, mutez]")
+	f.IntVar(&precision, "precision", 6, "Number of decimal places for amounts in tez")
+	f.StringVar(&rounding, "rounding", utils.RoundHalfUp, "Rounding mode of amounts in tez: one of ["+strings.Join(utils.RoundingModes, ", ")+"]")
+	f.BoolVar(&useCache, "rpc-cache", true, "Cache immutable RPC responses")
+	f.StringVar(&cacheDir, "rpc-cache-dir", "", "Directory for persistent RPC cache (in-memory only if empty)")
+	f.IntVar(&cacheSize, "rpc-cache-size", rpc.DefaultCacheEntries, "Maximum number of in-memory RPC cache entries")
+	f.IntVar(&depth, "finality-depth", 60, "Number of levels behind the head after which blocks are considered final")
+	f.BoolVar(&verify, "verify", false, "Compare blocks and balances with a second endpoint (--verify-url) and fail on discrepancies. No Merkle proofs are checked")
+	f.StringVar(&verifyURL, "verify-url", "", "Secondary Tezos RPC end-point URL used by --verify")
+	f.StringVar(&auth.User, "rpc-user", "", "User name for HTTP basic authentication on the RPC end-point")
+	f.StringVar(&auth.Password, "rpc-password", "", "Password for HTTP basic authentication on the RPC end-point (also "+RPCPasswordEnv+")")
+	f.StringVar(&auth.BearerToken, "rpc-bearer-token", "", "Bearer token for the RPC end-point (also "+RPCBearerTokenEnv+")")
+	f.Float64Var(&limiter.Rate, "rpc-rate", 0, "Maximum number of RPC requests per second, 0 means no limit (default is set by public --network presets)")
+	f.IntVar(&limiter.Burst, "rpc-burst", 5, "Maximum number of RPC requests sent at once when --rpc-rate is set")
+	f.IntVar(&limiter.MaxRetries, "rpc-retries", rpc.DefaultMaxRetries, "Number of retries of GET requests rejected with 429 (Too Many Requests)")
+	f.BoolVar(&c.noKeyring, "no-keyring", false, "Keep secrets in --secrets-file instead of the OS keychain")
+	f.StringVar(&c.secretsPath, "secrets-file", "~/.tez/secrets.json", "Secrets file used with --no-keyring")
+	f.StringVar(&c.keystorePath, "keystore", "~/.tez/keys.json", "Keystore file")
+	f.StringVar(&c.clientDir, "client-dir", "", "tezos-client (octez-client) base directory, e.g. ~/.tezos-client, whose aliases are used along with the keystore")
+	f.BoolVar(&c.clientWrite, "client-write", false, "Also add new keys to the --client-dir wallet")
+	f.StringVar(&c.contractsPath, "contracts-file", "~/.tez/contracts.json", "Address book of originated contracts")
+	f.StringVar(&c.journalPath, "injection-journal", "~/.tez/injections.jsonl", "Journal of injected operations used to skip repeated injections")
+	f.StringVar(&c.highWatermarksPath, "high-watermarks", "~/.tez/high_watermarks.json", "Levels and rounds of the last blocks and consensus operations signed by baker keys and the signer")
+	f.BoolVar(&c.recordReorgs, "record-reorgs", false, "Append chain reorganizations observed by watch commands to --reorg-log")
+	f.StringVar(&c.reorgLogPath, "reorg-log", "~/.tez/reorgs.jsonl", "Log of observed chain reorganizations, see `tez reorgs'")
+	f.StringVar(&c.configPath, "config", "~/.tez/config.yaml", "Configuration file")
+	f.StringVar(&c.profileName, "profile", "", "Configuration profile (default is the file's default_profile)")
+	f.StringVar(&timezone, "timezone", "", "Time zone of displayed timestamps: local, UTC or an IANA name like Europe/Paris (default is local for live and watch output, UTC elsewhere)")
+	f.StringVar(&locale, "locale", "", "Number formatting locale (default is taken from LC_ALL, LC_NUMERIC or LANG)")
+	f.IntVar(&c.apiVersion, "api-version", 0, "Output compatibility version of machine-readable encodings: 1 keeps field names and types stable, 0 is the latest format which may change between releases")
 
 	rootCmd.AddCommand(NewBlockCommand(&c))
+	rootCmd.AddCommand(NewHeadCommand(&c))
+	rootCmd.AddCommand(NewFindCommand(&c))
+	rootCmd.AddCommand(NewReceiptCommand(&c))
+	rootCmd.AddCommand(NewOperationCommand(&c))
+	rootCmd.AddCommand(NewMempoolCommand(&c))
+	rootCmd.AddCommand(NewProtocolsCommand(&c))
+	rootCmd.AddCommand(NewProtocolCommand(&c))
+	rootCmd.AddCommand(NewMichelsonCommand(&c))
+	rootCmd.AddCommand(NewCodecCommand(&c))
+	rootCmd.AddCommand(NewTimelockCommand(&c))
+	rootCmd.AddCommand(NewRollupCommand(&c))
+	rootCmd.AddCommand(NewDALCommand(&c))
+	rootCmd.AddCommand(NewContractCommand(&c))
+	rootCmd.AddCommand(NewEventsCommand(&c))
+	rootCmd.AddCommand(NewExportCommand(&c))
+	rootCmd.AddCommand(NewStatsCommand(&c))
+	rootCmd.AddCommand(NewSchemaCommand(&c))
+	rootCmd.AddCommand(NewBenchCommand(&c))
+	rootCmd.AddCommand(NewMonitorCommand(&c))
+	rootCmd.AddCommand(NewKeyCommand(&c))
+	rootCmd.AddCommand(NewAccountCommand(&c))
+	rootCmd.AddCommand(NewDelegateCommand(&c))
+	rootCmd.AddCommand(NewBakerCommand(&c))
+	rootCmd.AddCommand(NewActivateCommand(&c))
+	rootCmd.AddCommand(NewBatchCommand(&c))
+	rootCmd.AddCommand(NewInjectCommand(&c))
+	rootCmd.AddCommand(NewInjectionsCommand(&c))
+	rootCmd.AddCommand(NewSignCommand(&c))
+	rootCmd.AddCommand(NewSignerCommand(&c))
+	rootCmd.AddCommand(NewProxyCommand(&c))
+	rootCmd.AddCommand(NewReorgsCommand(&c))
+	rootCmd.AddCommand(NewDebugCommand(&c))
+	rootCmd.AddCommand(NewSandboxCommand(&c))
+	rootCmd.AddCommand(NewFaucetCommand(&c))
+	rootCmd.AddCommand(NewConfigCommand(&c))
+	rootCmd.AddCommand(NewSecretCommand(&c))
+	rootCmd.AddCommand(NewScriptCommand(&c))
+	rootCmd.AddCommand(NewSelfUpdateCommand(&c))
 
 	return rootCmd
 }
 
+// isPlainRemoteURL returns true if the URL is unencrypted and points to other host than the loopback one
+func isPlainRemoteURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	h := u.Hostname()
+	return h != "localhost" && !net.ParseIP(h).IsLoopback()
+}
+
 // Execute executes root command
 func Execute(ctx context.Context) error {
 	return NewRootCommand(ctx).Execute()
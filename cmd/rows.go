@@ -0,0 +1,99 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math/big"
+	"time"
+)
+
+// blockRow is the flat block representation used by tabular encodings. Columns are only ever appended
+// so files written by different versions can be read together. Amounts are in mutez
+type blockRow struct {
+	Hash        string    `parquet:"hash"`
+	Level       int       `parquet:"level"`
+	Cycle       int       `parquet:"cycle"`
+	Predecessor string    `parquet:"predecessor"`
+	Timestamp   time.Time `parquet:"timestamp"`
+	Protocol    string    `parquet:"protocol"`
+	Baker       string    `parquet:"baker"`
+	Priority    int       `parquet:"priority"`
+	ConsumedGas *big.Int  `parquet:"consumed_gas"`
+	Volume      *big.Int  `parquet:"volume"`
+	Fees        *big.Int  `parquet:"fees"`
+	Operations  int       `parquet:"operations"`
+}
+
+func newBlockRow(b *xblockInfo) *blockRow {
+	r := blockRow{
+		Hash:        b.Hash,
+		Level:       b.Header.Level,
+		Cycle:       b.Metadata.Level.Cycle,
+		Predecessor: b.Header.Predecessor,
+		Timestamp:   b.Header.Timestamp,
+		Protocol:    b.Protocol,
+		Baker:       b.Metadata.Baker,
+		Priority:    b.Header.Priority,
+		Volume:      b.VolumeMutez,
+		Fees:        b.FeesMutez,
+		Operations:  b.OperationsNum,
+	}
+	if b.Metadata.ConsumedGas != nil {
+		r.ConsumedGas = &b.Metadata.ConsumedGas.Int
+	}
+	return &r
+}
+
+// opRow is the flat operation representation used by tabular encodings
+type opRow struct {
+	Block       string    `parquet:"block"`
+	Level       int       `parquet:"level"`
+	Timestamp   time.Time `parquet:"timestamp"`
+	Hash        string    `parquet:"hash"`
+	Kind        string    `parquet:"kind"`
+	Source      string    `parquet:"source"`
+	Destination string    `parquet:"destination"`
+	Amount      *big.Int  `parquet:"amount"`
+	Fee         *big.Int  `parquet:"fee"`
+	Counter     string    `parquet:"counter"`
+}
+
+func newOpRows(ops []*opInfo) []*opRow {
+	rows := make([]*opRow, len(ops))
+	for i, op := range ops {
+		r := opRow{
+			Hash:        op.Hash,
+			Kind:        op.Kind,
+			Source:      op.Source,
+			Destination: op.Destination,
+			Amount:      op.AmountMutez,
+			Fee:         op.FeeMutez,
+			Counter:     op.Counter,
+		}
+		if op.Block != nil {
+			r.Block = op.Block.Hash
+			r.Level = op.Block.Header.Level
+			r.Timestamp = op.Block.Header.Timestamp
+		}
+		rows[i] = &r
+	}
+	return rows
+}
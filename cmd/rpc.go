@@ -0,0 +1,57 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net/http"
+
+	tezos "github.com/ecadlabs/go-tezos"
+)
+
+// getRPC performs GET request to an arbitrary RPC path and decodes JSON response into v.
+// Used for endpoints not covered by go-tezos
+func (c *RootContext) getRPC(path string, v interface{}) error {
+	req, err := c.service.Client.NewRequest(c.context, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return c.service.Client.Do(req, v)
+}
+
+// postRPC performs POST request with JSON encoded body and decodes JSON response into v
+func (c *RootContext) postRPC(path string, body, v interface{}) error {
+	req, err := c.service.Client.NewRequest(c.context, http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	return c.service.Client.Do(req, v)
+}
+
+// isNotFound returns true if the RPC returned 404, i.e. the requested context entry doesn't exist
+func isNotFound(err error) bool {
+	st, ok := err.(tezos.HTTPStatus)
+	return ok && st.StatusCode() == http.StatusNotFound
+}
+
+// blockPath returns RPC path prefix of the block
+func (c *RootContext) blockPath(blockID string) string {
+	return "/chains/" + c.chainID + "/blocks/" + blockID
+}
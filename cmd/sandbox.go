@@ -0,0 +1,623 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/internal/atomicfile"
+	"github.com/ecadlabs/tez/keys"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Sandbox back-ends
+const (
+	sandboxOctez  = "octez"
+	sandboxDocker = "docker"
+)
+
+// Name of the sandbox state file in the sandbox directory
+const sandboxStateFile = "sandbox.json"
+
+// Flextesa box scripts by protocol hash, see --box
+var flextesaBoxes = map[string]string{
+	"PtParisBxoLz5gzMmn3d9WBQNoPSZakgnkMC2VNuQ3KXfUtUQeZ": "parisbox",
+	"PsQuebecnLByd3JwTiGadoG4nGWi3HYiLXUjkibeFV8dCFeVMUg": "quebecbox",
+	"PsRiotumaAMotcRoDWW1bysEhQy2n1M5fy8JgRp8jjRfHGmfeA7": "riobox",
+}
+
+// sandboxState is saved by `sandbox start' so `sandbox stop' can tear the sandbox down
+type sandboxState struct {
+	Mode     string   `json:"mode"`
+	URL      string   `json:"url"`
+	Protocol string   `json:"protocol"`
+	Accounts []string `json:"accounts"`
+	// Node and baker processes of the octez back-end
+	PIDs      []int  `json:"pids,omitempty"`
+	Container string `json:"container,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+	// Default profile to restore on stop
+	PreviousProfile string `json:"previous_profile,omitempty"`
+}
+
+// bootstrapKey is a funded account of the sandbox
+type bootstrapKey struct {
+	alias  string
+	key    keys.PrivateKey
+	public keys.PublicKey
+}
+
+type sandboxOptions struct {
+	mode       string
+	dir        string
+	protocol   string
+	port       int
+	accounts   int
+	balance    string
+	parameters string
+	image      string
+	box        string
+	container  string
+	blockTime  int
+	profile    string
+	timeout    time.Duration
+}
+
+// NewSandboxCommand returns new `sandbox' command
+func NewSandboxCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		opt   sandboxOptions
+		purge bool
+	)
+
+	sandboxCmd := &cobra.Command{
+		Use:   "sandbox",
+		Short: "Run a local sandbox chain",
+	}
+
+	startCmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start a sandbox node, activate the protocol and fund bootstrap accounts",
+		Long: `Start a local sandbox using octez-node and octez-client found in PATH or a Flextesa docker image,
+activate the protocol, fund bootstrap accounts kept in the keystore as bootstrap1..N and point the configuration profile at the node.
+Every start creates a fresh chain.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.startSandbox(&opt)
+		},
+	}
+
+	stopCmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the sandbox and restore the default profile",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.stopSandbox(opt.dir, purge)
+		},
+	}
+
+	for _, c := range []*cobra.Command{startCmd, stopCmd} {
+		c.Flags().StringVar(&opt.dir, "dir", "~/.tez/sandbox", "Sandbox data directory")
+	}
+	f := startCmd.Flags()
+	f.StringVar(&opt.mode, "mode", "auto", "Back-end: one of [auto, octez, docker]")
+	f.StringVar(&opt.protocol, "protocol", "", "Protocol hash or name (default is the latest known protocol)")
+	f.IntVar(&opt.port, "port", 18731, "RPC port")
+	f.IntVar(&opt.accounts, "accounts", 3, "Number of bootstrap accounts")
+	f.StringVar(&opt.balance, "balance", "4000000", "Balance of each bootstrap account in tez")
+	f.StringVar(&opt.parameters, "parameters", "", "Protocol parameters JSON file used with octez, e.g. sandbox-parameters.json from the protocol sources")
+	f.StringVar(&opt.image, "image", "oxheadalpha/flextesa:latest", "Docker image")
+	f.StringVar(&opt.box, "box", "", "Flextesa box script (default is derived from the protocol)")
+	f.StringVar(&opt.container, "container", "tez-sandbox", "Docker container name")
+	f.IntVar(&opt.blockTime, "block-time", 5, "Block time in seconds used with docker")
+	f.StringVar(&opt.profile, "profile-name", "sandbox", "Configuration profile pointed at the sandbox, empty to leave the configuration intact")
+	f.DurationVar(&opt.timeout, "timeout", 2*time.Minute, "Time to wait for the protocol activation")
+	stopCmd.Flags().BoolVar(&purge, "purge", false, "Remove the sandbox directory")
+
+	sandboxCmd.AddCommand(startCmd)
+	sandboxCmd.AddCommand(stopCmd)
+
+	return sandboxCmd
+}
+
+// resolveProtocol returns the known protocol by its hash, full name or name without the number, e.g. PsRiotum
+func resolveProtocol(name string) (*protocol.Protocol, error) {
+	list := protocol.Protocols()
+	if name == "" {
+		return list[len(list)-1], nil
+	}
+	for _, p := range list {
+		short := p.Name
+		if i := strings.IndexByte(short, '-'); i >= 0 {
+			short = short[i+1:]
+		}
+		if p.Hash == name || strings.EqualFold(p.Name, name) || strings.EqualFold(short, name) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown protocol: `%s'", name)
+}
+
+func sandboxMode(mode string) (string, error) {
+	switch mode {
+	case sandboxOctez, sandboxDocker:
+		return mode, nil
+	case "auto":
+		if _, err := exec.LookPath("octez-node"); err == nil {
+			if _, err := exec.LookPath("octez-client"); err == nil {
+				return sandboxOctez, nil
+			}
+		}
+		if _, err := exec.LookPath("docker"); err == nil {
+			return sandboxDocker, nil
+		}
+		return "", errors.New("Neither octez-node and octez-client nor docker are found in PATH")
+	default:
+		return "", fmt.Errorf("Unknown sandbox mode: `%s'", mode)
+	}
+}
+
+func (c *RootContext) startSandbox(opt *sandboxOptions) error {
+	dir, err := utils.ExpandHome(opt.dir)
+	if err != nil {
+		return err
+	}
+	statePath := filepath.Join(dir, sandboxStateFile)
+	if _, err := os.Stat(statePath); err == nil {
+		return fmt.Errorf("Sandbox is already running, see %s or run `tez sandbox stop'", statePath)
+	}
+
+	proto, err := resolveProtocol(opt.protocol)
+	if err != nil {
+		return err
+	}
+	mode, err := sandboxMode(opt.mode)
+	if err != nil {
+		return err
+	}
+	balance, err := utils.ParseTez(opt.balance)
+	if err != nil {
+		return err
+	}
+	if mode == sandboxOctez && opt.parameters == "" {
+		return errors.New("Protocol parameters are required with octez binaries (--parameters)")
+	}
+	box := opt.box
+	if mode == sandboxDocker && box == "" {
+		if box = flextesaBoxes[proto.Hash]; box == "" {
+			return fmt.Errorf("No Flextesa box is known for %s, set --box", proto.Name)
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	accounts, err := c.bootstrapKeys(opt.accounts)
+	if err != nil {
+		return err
+	}
+
+	state := sandboxState{
+		Mode:     mode,
+		URL:      fmt.Sprintf("http://127.0.0.1:%d/", opt.port),
+		Protocol: proto.Hash,
+	}
+	for _, a := range accounts {
+		state.Accounts = append(state.Accounts, a.alias)
+	}
+
+	ctx, cancel := context.WithTimeout(c.context, opt.timeout)
+	defer cancel()
+
+	log.WithFields(log.Fields{
+		"mode":     mode,
+		"protocol": proto.Name,
+		"url":      state.URL,
+	}).Info("Starting sandbox")
+
+	if mode == sandboxDocker {
+		err = startFlextesa(ctx, &state, opt, box, accounts, balance)
+	} else {
+		err = startOctezSandbox(ctx, &state, opt, dir, accounts, balance)
+	}
+	// Saved even on failure so the started processes can be stopped
+	if serr := saveSandboxState(statePath, &state); serr != nil && err == nil {
+		err = serr
+	}
+	if err != nil {
+		return err
+	}
+	if err := waitForProtocol(ctx, state.URL, proto.Hash); err != nil {
+		return err
+	}
+
+	if opt.profile != "" {
+		f, err := readConfigFile(c.configPath)
+		if err != nil {
+			return err
+		}
+		state.Profile = opt.profile
+		state.PreviousProfile, _ = f.values["default_profile"].(string)
+		if err := f.set("profiles."+opt.profile+".url", state.URL); err != nil {
+			return err
+		}
+		if err := f.set("default_profile", opt.profile); err != nil {
+			return err
+		}
+		if err := f.save(); err != nil {
+			return err
+		}
+		if err := saveSandboxState(statePath, &state); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Sandbox is running at %s (%s)\n", state.URL, proto.Name)
+	if state.Profile != "" {
+		fmt.Printf("Default profile: %s\n", state.Profile)
+	}
+	for _, a := range accounts {
+		fmt.Printf("%-12s %s %s\n", a.alias, a.public.Hash(), c.amountFormat.Format(balance))
+	}
+	return nil
+}
+
+// bootstrapKeys returns keys of the bootstrap accounts adding missing ones to the keystore unencrypted
+func (c *RootContext) bootstrapKeys(n int) ([]*bootstrapKey, error) {
+	store, err := c.keyStore()
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := c.secretStore()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		list  []*bootstrapKey
+		added bool
+	)
+	for i := 1; i <= n; i++ {
+		alias := "bootstrap" + strconv.Itoa(i)
+		var k keys.PrivateKey
+		if e := store.Lookup(alias); e != nil {
+			acc := account{Entry: e, secrets: secrets}
+			if k, err = acc.privateKey(); err != nil {
+				return nil, err
+			}
+		} else {
+			if k, err = keys.GeneratePrivateKey(keys.TypeEd25519); err != nil {
+				return nil, err
+			}
+			e, err := keys.NewEntry(alias, k, nil)
+			if err != nil {
+				return nil, err
+			}
+			if err := store.Add(e); err != nil {
+				return nil, err
+			}
+			added = true
+		}
+		list = append(list, &bootstrapKey{alias: alias, key: k, public: k.Public()})
+	}
+	if added {
+		if err := store.Save(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func saveSandboxState(path string, s *sandboxState) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data, 0600)
+}
+
+// startFlextesa runs the box script in a detached container
+func startFlextesa(ctx context.Context, state *sandboxState, opt *sandboxOptions, box string, accounts []*bootstrapKey, balance *big.Int) error {
+	args := []string{
+		"run", "-d", "--rm",
+		"--name", opt.container,
+		"-p", fmt.Sprintf("127.0.0.1:%d:20000", opt.port),
+		"-e", fmt.Sprintf("block_time=%d", opt.blockTime),
+		opt.image, box, "start",
+	}
+	for _, a := range accounts {
+		args = append(args,
+			fmt.Sprintf("--add-bootstrap-account=%s,%s,%s,unencrypted:%s@%s", a.alias, a.public, a.public.Hash(), a.key, balance),
+			"--no-daemons-for="+a.alias,
+		)
+	}
+	if out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("docker: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	state.Container = opt.container
+	return nil
+}
+
+// startOctezSandbox starts a sandboxed node, activates the protocol with octez-client and starts the baker if one is found in PATH
+func startOctezSandbox(ctx context.Context, state *sandboxState, opt *sandboxOptions, dir string, accounts []*bootstrapKey, balance *big.Int) error {
+	nodeDir, clientDir := filepath.Join(dir, "node"), filepath.Join(dir, "client")
+	for _, d := range []string{nodeDir, clientDir} {
+		if err := os.RemoveAll(d); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return err
+		}
+	}
+
+	// Protocol activation is signed by the genesis key
+	activator, err := keys.GeneratePrivateKey(keys.TypeEd25519)
+	if err != nil {
+		return err
+	}
+	sandboxFile := filepath.Join(dir, "sandbox-genesis.json")
+	data, _ := json.Marshal(map[string]string{"genesis_pubkey": activator.Public().String()})
+	if err := ioutil.WriteFile(sandboxFile, data, 0600); err != nil {
+		return err
+	}
+
+	params, err := sandboxParameters(opt.parameters, accounts, balance)
+	if err != nil {
+		return err
+	}
+	paramsFile := filepath.Join(dir, "parameters.json")
+	if err := ioutil.WriteFile(paramsFile, params, 0600); err != nil {
+		return err
+	}
+
+	rpcAddr := fmt.Sprintf("127.0.0.1:%d", opt.port)
+	if err := runTool(ctx, "octez-node", "identity", "generate", "0", "--data-dir", nodeDir); err != nil {
+		return err
+	}
+	err = runTool(ctx, "octez-node", "config", "init", "--data-dir", nodeDir,
+		"--network", "sandbox",
+		"--expected-pow", "0",
+		"--rpc-addr", rpcAddr,
+		"--net-addr", fmt.Sprintf("127.0.0.1:%d", opt.port+1),
+		"--no-bootstrap-peers",
+		"--synchronisation-threshold", "0",
+		"--connections", "0",
+		"--private-mode",
+	)
+	if err != nil {
+		return err
+	}
+	pid, err := spawnTool(filepath.Join(dir, "node.log"), "octez-node", "run", "--data-dir", nodeDir, "--sandbox", sandboxFile, "--singleprocess")
+	if err != nil {
+		return err
+	}
+	state.PIDs = append(state.PIDs, pid)
+	if err := waitForRPC(ctx, state.URL); err != nil {
+		return err
+	}
+
+	client := []string{"--base-dir", clientDir, "--endpoint", state.URL}
+	keyList := append([]*bootstrapKey{{alias: "activator", key: activator}}, accounts...)
+	for _, k := range keyList {
+		if err := runTool(ctx, "octez-client", append(client, "import", "secret", "key", k.alias, "unencrypted:"+k.key.String(), "--force")...); err != nil {
+			return err
+		}
+	}
+	err = runTool(ctx, "octez-client", append(client, "--block", "genesis", "activate", "protocol", state.Protocol,
+		"with", "fitness", "1", "and", "key", "activator", "and", "parameters", paramsFile)...)
+	if err != nil {
+		return err
+	}
+
+	baker := octezBaker(state.Protocol)
+	if baker == "" {
+		log.Warn("No octez baker is found in PATH, the sandbox won't produce blocks")
+		return nil
+	}
+	args := append(client, "run", "with", "local", "node", nodeDir)
+	for _, a := range accounts {
+		args = append(args, a.alias)
+	}
+	args = append(args, "--liquidity-baking-toggle-vote", "pass")
+	if pid, err = spawnTool(filepath.Join(dir, "baker.log"), baker, args...); err != nil {
+		return err
+	}
+	state.PIDs = append(state.PIDs, pid)
+	return nil
+}
+
+// sandboxParameters replaces bootstrap accounts in the protocol parameters file
+func sandboxParameters(path string, accounts []*bootstrapKey, balance *big.Int) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	list := make([][]string, len(accounts))
+	for i, a := range accounts {
+		list[i] = []string{a.public.String(), balance.String()}
+	}
+	params["bootstrap_accounts"] = list
+	return json.MarshalIndent(params, "", "  ")
+}
+
+// octezBaker returns the baker executable for the protocol preferring the protocol specific one
+func octezBaker(proto string) string {
+	for _, name := range []string{"octez-baker-" + proto[:8], "octez-baker"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// runTool runs the command to completion including its output into the error
+func runTool(ctx context.Context, name string, args ...string) error {
+	log.WithField("command", name+" "+strings.Join(args, " ")).Debug("Running")
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// spawnTool starts the command in background with the output redirected to the log file and returns its PID
+func spawnTool(logPath, name string, args ...string) (int, error) {
+	fd, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = fd, fd
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	log.WithFields(log.Fields{
+		"command": name,
+		"pid":     pid,
+		"log":     logPath,
+	}).Info("Started")
+	return pid, cmd.Process.Release()
+}
+
+// pollRPC decodes the URL response into v until done returns true or the context expires
+func pollRPC(ctx context.Context, url string, v interface{}, done func() bool) error {
+	for {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err == nil {
+			if res.StatusCode/100 == 2 && json.NewDecoder(res.Body).Decode(v) == nil && done() {
+				res.Body.Close()
+				return nil
+			}
+			res.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Sandbox at %s isn't ready: %v", url, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func waitForRPC(ctx context.Context, url string) error {
+	var v interface{}
+	return pollRPC(ctx, url+"version", &v, func() bool { return true })
+}
+
+// waitForProtocol waits for the first block of the protocol
+func waitForProtocol(ctx context.Context, url, proto string) error {
+	var v struct {
+		Protocol string `json:"protocol"`
+	}
+	return pollRPC(ctx, url+"chains/main/blocks/head/protocols", &v, func() bool { return v.Protocol == proto })
+}
+
+func (c *RootContext) stopSandbox(dir string, purge bool) error {
+	dir, err := utils.ExpandHome(dir)
+	if err != nil {
+		return err
+	}
+	statePath := filepath.Join(dir, sandboxStateFile)
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("Sandbox is not running")
+		}
+		return err
+	}
+	var state sandboxState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%s: %v", statePath, err)
+	}
+
+	if state.Container != "" {
+		if err := runTool(c.context, "docker", "rm", "-f", state.Container); err != nil {
+			log.WithError(err).Warn("Can't remove the container")
+		}
+	}
+	// Baker first
+	for i := len(state.PIDs) - 1; i >= 0; i-- {
+		p, err := os.FindProcess(state.PIDs[i])
+		if err == nil {
+			err = p.Signal(syscall.SIGTERM)
+		}
+		if err != nil {
+			log.WithError(err).WithField("pid", state.PIDs[i]).Warn("Can't stop the process")
+		}
+	}
+
+	// The default profile is restored only if it wasn't changed since
+	if state.Profile != "" {
+		f, err := readConfigFile(c.configPath)
+		if err != nil {
+			return err
+		}
+		if def, _ := f.values["default_profile"].(string); def == state.Profile {
+			if state.PreviousProfile != "" {
+				err = f.set("default_profile", state.PreviousProfile)
+			} else {
+				f.unset("default_profile")
+			}
+			if err != nil {
+				return err
+			}
+			if err := f.save(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if purge {
+		err = os.RemoveAll(dir)
+	} else {
+		err = os.Remove(statePath)
+	}
+	if err != nil {
+		return err
+	}
+	log.WithField("url", state.URL).Info("Sandbox stopped")
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/spf13/cobra"
+)
+
+const schemaBaseURL = "https://github.com/ecadlabs/tez/schema/"
+
+// Columns of the schema list table
+var schemaColumns = []utils.TableColumn{
+	{Header: "NAME", Width: 16, MinWidth: 8},
+	{Header: "EMITTED BY", Width: 60, MinWidth: 16},
+}
+
+// outputSchema describes a structure emitted by `-o json'
+type outputSchema struct {
+	name        string
+	emittedBy   string
+	description string
+	typ         reflect.Type
+	// Representation used with --api-version 1 and above if it differs from the default one
+	stable reflect.Type
+}
+
+var outputSchemas = []*outputSchema{
+	{
+		name:        "block",
+		emittedBy:   "block -o json, block --sink",
+		description: "Block with its header, metadata and operations",
+		typ:         reflect.TypeOf(xblock{}),
+		stable:      reflect.TypeOf(blockV1{}),
+	},
+	{
+		name:        "operation",
+		emittedBy:   "block operations -o json",
+		description: "Operation as returned by the node. Operations of kinds unknown to the client are passed through undecoded",
+		typ:         reflect.TypeOf(tezos.Operation{}),
+		stable:      reflect.TypeOf(operationV1{}),
+	},
+	{
+		name:        "operation-event",
+		emittedBy:   "block operations --sink",
+		description: "Operation event published to sinks, amounts are in mutez",
+		typ:         reflect.TypeOf(opEvent{}),
+	},
+	{
+		name:        "receipt",
+		emittedBy:   "receipt -o json",
+		description: "Operation receipt",
+		typ:         reflect.TypeOf(receipt{}),
+	},
+	{
+		name:        "balance-event",
+		emittedBy:   "account watch -o json, account watch --sink",
+		description: "Balance threshold event, amounts are in mutez",
+		typ:         reflect.TypeOf(balanceEvent{}),
+	},
+	{
+		name:        "protocol",
+		emittedBy:   "protocols -o json",
+		description: "Protocol activation period",
+		typ:         reflect.TypeOf(protocolPeriod{}),
+	},
+}
+
+func lookupOutputSchema(name string) *outputSchema {
+	for _, s := range outputSchemas {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *outputSchema) document(apiVersion int) map[string]interface{} {
+	typ := s.typ
+	if apiVersion >= 1 && s.stable != nil {
+		typ = s.stable
+	}
+	doc := utils.JSONSchema(typ)
+	doc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	doc["$id"] = fmt.Sprintf("%s%s/%s.json", schemaBaseURL, apiVersionName(apiVersion), s.name)
+	doc["title"] = s.name
+	doc["description"] = s.description
+	return doc
+}
+
+// NewSchemaCommand returns new `schema' command
+func NewSchemaCommand(rootCtx *RootContext) *cobra.Command {
+	schemaCmd := &cobra.Command{
+		Use:   "schema [name]",
+		Short: "Print JSON Schema of the machine-readable output",
+		Long: `Print JSON Schema of the objects emitted with -o json, e.g. for code generation or payload validation.
+Without arguments the known schemas are listed. The schema follows --api-version and its ID carries the version.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				table := utils.NewTable(os.Stdout, schemaColumns, utils.TerminalWidth(os.Stdout))
+				if err := table.WriteHeader(); err != nil {
+					return err
+				}
+				for _, s := range outputSchemas {
+					if err := table.WriteRow(s.name, s.emittedBy); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			s := lookupOutputSchema(args[0])
+			if s == nil {
+				return fmt.Errorf("Unknown schema: `%s'", args[0])
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(s.document(rootCtx.apiVersion))
+		},
+	}
+
+	return schemaCmd
+}
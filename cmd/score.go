@@ -0,0 +1,320 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the baker scorecard table
+var scoreColumns = []utils.TableColumn{
+	{Header: "CYCLE", Width: 6, Align: utils.AlignRight},
+	{Header: "BLOCKS", Width: 9, Align: utils.AlignRight},
+	{Header: "MISSED", Width: 6, Align: utils.AlignRight},
+	{Header: "ATTESTED", Width: 11, Align: utils.AlignRight},
+	{Header: "MISSED", Width: 6, Align: utils.AlignRight},
+	{Header: "REWARDS", Width: 16, Align: utils.AlignRight},
+	{Header: "RELIABILITY", Width: 11, Align: utils.AlignRight},
+}
+
+// Categories of minted rewards
+var rewardCategories = map[string]struct{}{
+	"baking rewards":    {},
+	"baking bonuses":    {},
+	"attesting rewards": {},
+	"endorsing rewards": {},
+}
+
+type scoreOptions struct {
+	cycles int
+	format string
+	crawl  crawlOptions
+}
+
+// bakerDuties counts consensus duties. Block rights are round 0 slots, attestation rights are levels
+type bakerDuties struct {
+	BlockRights       int        `json:"block_rights" yaml:"block_rights"`
+	Baked             int        `json:"baked" yaml:"baked"`
+	MissedBlocks      int        `json:"missed_blocks" yaml:"missed_blocks"`
+	AttestationRights int        `json:"attestation_rights" yaml:"attestation_rights"`
+	Attested          int        `json:"attested" yaml:"attested"`
+	MissedAttestation int        `json:"missed_attestations" yaml:"missed_attestations"`
+	RewardsMutez      *big.Int   `json:"rewards_mutez" yaml:"rewards_mutez"`
+	Rewards           *big.Float `json:"rewards" yaml:"rewards"`
+	// Percentage of fulfilled duties
+	Reliability float64 `json:"reliability" yaml:"reliability"`
+}
+
+func (d *bakerDuties) add(x *bakerDuties) {
+	d.BlockRights += x.BlockRights
+	d.Baked += x.Baked
+	d.MissedBlocks += x.MissedBlocks
+	d.AttestationRights += x.AttestationRights
+	d.Attested += x.Attested
+	d.MissedAttestation += x.MissedAttestation
+	d.RewardsMutez.Add(d.RewardsMutez, x.RewardsMutez)
+}
+
+func (d *bakerDuties) finish(af *utils.AmountFormat) {
+	d.MissedBlocks = d.BlockRights - d.Baked
+	d.MissedAttestation = d.AttestationRights - d.Attested
+	d.Rewards = af.Tez(d.RewardsMutez)
+	if total := d.BlockRights + d.AttestationRights; total != 0 {
+		d.Reliability = float64(d.Baked+d.Attested) / float64(total) * 100
+	}
+}
+
+type bakerCycleScore struct {
+	Cycle       int `json:"cycle" yaml:"cycle"`
+	bakerDuties `yaml:",inline"`
+}
+
+type bakerScore struct {
+	Delegate string             `json:"delegate" yaml:"delegate"`
+	Cycles   []*bakerCycleScore `json:"cycles" yaml:"cycles"`
+	Total    *bakerDuties       `json:"total" yaml:"total"`
+}
+
+type blockRewards struct {
+	BalanceUpdates []*balanceUpdate `json:"balance_updates"`
+}
+
+// creditedTo returns true if the update credits the delegate's own balance or frozen stake
+func (u *balanceUpdate) creditedTo(delegate string) bool {
+	if u.Change.Sign() <= 0 {
+		return false
+	}
+	if u.Contract == delegate {
+		return true
+	}
+	for _, k := range []string{"baker_own_stake", "baker_edge", "baker"} {
+		if v, _ := u.Staker[k].(string); v == delegate {
+			return true
+		}
+	}
+	return false
+}
+
+// delegateRewards sums up minted rewards credited to the delegate. Every minted debit is followed by its credit
+func delegateRewards(updates []*balanceUpdate, delegate string) *big.Int {
+	sum := new(big.Int)
+	for i, u := range updates {
+		if _, ok := rewardCategories[u.Category]; !ok || u.Kind != "minted" || i+1 == len(updates) {
+			continue
+		}
+		if next := updates[i+1]; next.creditedTo(delegate) {
+			sum.Add(sum, &next.Change.Int)
+		}
+	}
+	return sum
+}
+
+func newBakerScoreCommand(ctx *RootContext) *cobra.Command {
+	var opt scoreOptions
+
+	scoreCmd := &cobra.Command{
+		Use:   "score <baker>",
+		Short: "Performance scorecard over the last completed cycles",
+		Long: `Compare the baker's rights with the blocks it actually baked and the levels it attested over the last completed cycles.
+Block rights are round 0 slots, a slot counts as missed if the block was baked by someone else. Rewards are the minted
+baking rewards, bonuses and attesting rewards credited to the baker's own balance and stake. Reliability is the share of
+fulfilled duties (blocks and attested levels). The node must keep the metadata of the scanned blocks.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := ctx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			bc := BlockCommandContext{RootContext: ctx}
+			return bc.showBakerScore(address, &opt)
+		},
+	}
+
+	f := scoreCmd.Flags()
+	f.IntVar(&opt.cycles, "cycles", 10, "Number of completed cycles")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	addCrawlFlags(scoreCmd, &opt.crawl, 8, "blocks")
+
+	return scoreCmd
+}
+
+func (c *BlockCommandContext) showBakerScore(delegate string, opt *scoreOptions) error {
+	var newEncoder utils.NewEncoderFunc
+	if opt.format != "text" {
+		if newEncoder = c.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+	if opt.cycles <= 0 {
+		return fmt.Errorf("Invalid number of cycles: %d", opt.cycles)
+	}
+
+	var cur currentLevel
+	if err := c.getRPC(c.blockPath(c.blockID)+"/helpers/current_level", &cur); err != nil {
+		return err
+	}
+	first := cur.Cycle - opt.cycles
+	if first < 0 {
+		first = 0
+	}
+
+	score := bakerScore{
+		Delegate: delegate,
+		Total:    &bakerDuties{RewardsMutez: new(big.Int)},
+	}
+	for cycle := first; cycle < cur.Cycle; cycle++ {
+		s, err := c.bakerCycleScore(delegate, cycle, cur.Cycle, opt)
+		if err != nil {
+			return err
+		}
+		score.Cycles = append(score.Cycles, s)
+		score.Total.add(&s.bakerDuties)
+	}
+	score.Total.finish(c.amountFormat)
+
+	if newEncoder != nil {
+		return newEncoder(os.Stdout).Encode(&score)
+	}
+
+	fmt.Printf("Delegate: %s\n\n", score.Delegate)
+	table := utils.NewTable(os.Stdout, scoreColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	row := func(name string, d *bakerDuties) error {
+		return table.WriteRow(
+			name,
+			fmt.Sprintf("%d/%d", d.Baked, d.BlockRights),
+			strconv.Itoa(d.MissedBlocks),
+			fmt.Sprintf("%d/%d", d.Attested, d.AttestationRights),
+			strconv.Itoa(d.MissedAttestation),
+			c.amountFormat.Format(d.RewardsMutez),
+			fmt.Sprintf("%.2f%%", d.Reliability),
+		)
+	}
+	for _, s := range score.Cycles {
+		if err := row(strconv.Itoa(s.Cycle), &s.bakerDuties); err != nil {
+			return err
+		}
+	}
+	return row("total", score.Total)
+}
+
+// bakerCycleScore scans the blocks the delegate had to bake and the ones including attestations of the levels it had to attest
+func (c *BlockCommandContext) bakerCycleScore(delegate string, cycle, current int, opt *scoreOptions) (*bakerCycleScore, error) {
+	var lv cycleLevels
+	if err := c.getRPC(fmt.Sprintf("%s/helpers/levels_in_current_cycle?offset=%d", c.blockPath(c.blockID), cycle-current), &lv); err != nil {
+		return nil, err
+	}
+	// Rights are taken at the last block of the cycle so the responses are final and cached
+	at := c.blockPath(strconv.Itoa(lv.Last))
+
+	var bakingRights []*bakingRight
+	if err := c.getRPC(fmt.Sprintf("%s/helpers/baking_rights?cycle=%d&delegate=%s&max_round=0", at, cycle, delegate), &bakingRights); err != nil {
+		return nil, err
+	}
+	var attestationRights []*consensusRights
+	err := c.getRPC(fmt.Sprintf("%s/helpers/attestation_rights?cycle=%d&delegate=%s", at, cycle, delegate), &attestationRights)
+	if isNotFound(err) {
+		err = c.getRPC(fmt.Sprintf("%s/helpers/endorsing_rights?cycle=%d&delegate=%s", at, cycle, delegate), &attestationRights)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := bakerCycleScore{
+		Cycle:       cycle,
+		bakerDuties: bakerDuties{RewardsMutez: new(big.Int)},
+	}
+	bakeLevels := make(map[int]struct{})
+	for _, r := range bakingRights {
+		bakeLevels[int(r.Level)] = struct{}{}
+	}
+	attestLevels := make(map[int]struct{})
+	for _, r := range attestationRights {
+		attestLevels[r.Level] = struct{}{}
+	}
+	s.BlockRights, s.AttestationRights = len(bakeLevels), len(attestLevels)
+
+	// Attestations of level L are included into the block L+1
+	scan := make(map[int]struct{})
+	for l := range bakeLevels {
+		scan[l] = struct{}{}
+	}
+	for l := range attestLevels {
+		scan[l+1] = struct{}{}
+	}
+	levels := make([]int, 0, len(scan))
+	for l := range scan {
+		levels = append(levels, l)
+	}
+	sort.Ints(levels)
+	args := make([]string, len(levels))
+	for i, l := range levels {
+		args[i] = strconv.Itoa(l)
+	}
+
+	var baked []string
+	err = c.scanBlocks(args, &opt.crawl, func(b *xblock) {
+		level := b.Header.Level
+		if _, ok := bakeLevels[level]; ok && b.Metadata.Baker == delegate {
+			s.Baked++
+			baked = append(baked, b.Hash)
+		}
+		if _, ok := attestLevels[level-1]; ok {
+			for _, op := range getBlockOperations(getBlockInfo(b), consensusKinds) {
+				if op.Source == delegate {
+					s.Attested++
+					break
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Attesting rewards are distributed at the end of the cycle
+	for _, id := range append(baked, strconv.Itoa(lv.Last)) {
+		var m blockRewards
+		if err := c.getRPC(c.blockPath(id)+"/metadata", &m); err != nil {
+			return nil, err
+		}
+		s.RewardsMutez.Add(s.RewardsMutez, delegateRewards(m.BalanceUpdates, delegate))
+	}
+
+	s.finish(c.amountFormat)
+	log.WithFields(log.Fields{
+		"cycle":       cycle,
+		"baked":       s.Baked,
+		"attested":    s.Attested,
+		"reliability": fmt.Sprintf("%.2f%%", s.Reliability),
+	}).Debug("Cycle scanned")
+	return &s, nil
+}
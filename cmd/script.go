@@ -0,0 +1,331 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/spf13/cobra"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+)
+
+// NewScriptCommand returns new `script' command
+func NewScriptCommand(rootCtx *RootContext) *cobra.Command {
+	scriptCmd := &cobra.Command{
+		Use:   "script",
+		Short: "Run Starlark scripts",
+	}
+
+	runCmd := &cobra.Command{
+		Use:   "run <file.star> [args...]",
+		Short: "Run a Starlark script",
+		Long: `Run a Starlark script (a Python dialect, see https://github.com/bazelbuild/starlark). Predeclared names:
+
+  args                  tuple of the command line arguments following the script name
+  rpc(path)             result of GET request to an arbitrary RPC path
+  block(id="head")      block by hash, level or head~N
+  blocks(range, ...)    lazy iterator over blocks, accepts the same ranges as 'block' command
+  encode(value, format) value encoded as json or yaml
+  tez(mutez)            amount formatted according to --unit, --precision and --rounding
+  json                  Starlark json module (encode, decode, indent)`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.runScript(args[0], args[1:])
+		},
+	}
+
+	scriptCmd.AddCommand(runCmd)
+
+	return scriptCmd
+}
+
+func (c *RootContext) runScript(filename string, args []string) error {
+	// Allow top level loops and the usual Python features in scripts
+	resolve.AllowGlobalReassign = true
+	resolve.AllowRecursion = true
+	resolve.AllowSet = true
+
+	thread := &starlark.Thread{
+		Name:  filename,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Println(msg) },
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.context.Done():
+			thread.Cancel(c.context.Err().Error())
+		case <-done:
+		}
+	}()
+
+	argv := make(starlark.Tuple, len(args))
+	for i, a := range args {
+		argv[i] = starlark.String(a)
+	}
+
+	predeclared := starlark.StringDict{
+		"args":   argv,
+		"rpc":    starlark.NewBuiltin("rpc", c.scriptRPC),
+		"block":  starlark.NewBuiltin("block", c.scriptBlock),
+		"blocks": starlark.NewBuiltin("blocks", c.scriptBlocks),
+		"encode": starlark.NewBuiltin("encode", scriptEncode),
+		"tez":    starlark.NewBuiltin("tez", c.scriptTez),
+		"json":   starlarkjson.Module,
+	}
+
+	_, err := starlark.ExecFile(thread, filename, nil, predeclared)
+	if e, ok := err.(*starlark.EvalError); ok {
+		return fmt.Errorf("%s", e.Backtrace())
+	}
+	return err
+}
+
+// getRPCValue performs GET request and converts JSON response to a Starlark value
+func (c *RootContext) getRPCValue(path string) (starlark.Value, error) {
+	var raw json.RawMessage
+	if err := c.getRPC(path, &raw); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return toStarlark(v)
+}
+
+func (c *RootContext) scriptRPC(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "path", &path); err != nil {
+		return nil, err
+	}
+	return c.getRPCValue(path)
+}
+
+func (c *RootContext) scriptBlock(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	id := "head"
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "id?", &id); err != nil {
+		return nil, err
+	}
+	return c.getRPCValue(c.blockPath(id))
+}
+
+func (c *RootContext) scriptBlocks(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) != 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", fn.Name())
+	}
+	ranges := make([]string, len(args))
+	for i, a := range args {
+		s, ok := starlark.AsString(a)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s, want string", fn.Name(), a.Type())
+		}
+		ranges[i] = s
+	}
+	ids, err := c.expandBlockArgs(ranges)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptBlockList{ctx: c, thread: thread, ids: ids}, nil
+}
+
+func scriptEncode(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		value  starlark.Value
+		format = "json"
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &value, "format?", &format); err != nil {
+		return nil, err
+	}
+	newEnc := utils.GetEncoderFunc(format)
+	if newEnc == nil || utils.IsBinary(format) {
+		return nil, fmt.Errorf("%s: unknown text encoding: `%s'", fn.Name(), format)
+	}
+	v, err := fromStarlark(value)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := newEnc(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return starlark.String(buf.String()), nil
+}
+
+func (c *RootContext) scriptTez(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "mutez", &value); err != nil {
+		return nil, err
+	}
+	var v big.Int
+	switch x := value.(type) {
+	case starlark.Int:
+		v.Set(x.BigInt())
+	case starlark.String:
+		if _, ok := v.SetString(string(x), 10); !ok {
+			return nil, fmt.Errorf("%s: invalid amount: %s", fn.Name(), x)
+		}
+	default:
+		return nil, fmt.Errorf("%s: got %s, want int or string", fn.Name(), value.Type())
+	}
+	return starlark.String(c.amountFormat.Format(&v)), nil
+}
+
+// scriptBlockList is an iterable fetching blocks on demand
+type scriptBlockList struct {
+	ctx    *RootContext
+	thread *starlark.Thread
+	ids    []string
+}
+
+func (l *scriptBlockList) String() string        { return fmt.Sprintf("blocks(%d)", len(l.ids)) }
+func (l *scriptBlockList) Type() string          { return "blocks" }
+func (l *scriptBlockList) Freeze()               {}
+func (l *scriptBlockList) Truth() starlark.Bool  { return len(l.ids) != 0 }
+func (l *scriptBlockList) Len() int              { return len(l.ids) }
+func (l *scriptBlockList) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: blocks") }
+
+func (l *scriptBlockList) Iterate() starlark.Iterator {
+	return &scriptBlockIterator{list: l}
+}
+
+type scriptBlockIterator struct {
+	list *scriptBlockList
+	i    int
+}
+
+// Next fetches the next block. Iterators can't return errors so the failure cancels the script
+func (it *scriptBlockIterator) Next(p *starlark.Value) bool {
+	if it.i >= len(it.list.ids) {
+		return false
+	}
+	v, err := it.list.ctx.getRPCValue(it.list.ctx.blockPath(it.list.ids[it.i]))
+	if err != nil {
+		it.list.thread.Cancel(fmt.Sprintf("block %s: %v", it.list.ids[it.i], err))
+		return false
+	}
+	it.i++
+	*p = v
+	return true
+}
+
+func (it *scriptBlockIterator) Done() {}
+
+// toStarlark converts decoded JSON to a Starlark value
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch x := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(x), nil
+	case string:
+		return starlark.String(x), nil
+	case json.Number:
+		if i, ok := new(big.Int).SetString(string(x), 10); ok {
+			return starlark.MakeBigInt(i), nil
+		}
+		f, err := x.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(f), nil
+	case []interface{}:
+		list := make([]starlark.Value, len(x))
+		for i, e := range x {
+			var err error
+			if list[i], err = toStarlark(e); err != nil {
+				return nil, err
+			}
+		}
+		return starlark.NewList(list), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(x))
+		for _, k := range keys {
+			e, err := toStarlark(x[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := d.SetKey(starlark.String(k), e); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("Unexpected JSON value of type %T", v)
+}
+
+// fromStarlark converts a Starlark value to a Go value suitable for the encoders
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch x := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(x), nil
+	case starlark.String:
+		return string(x), nil
+	case starlark.Int:
+		if i, ok := x.Int64(); ok {
+			return i, nil
+		}
+		return x.BigInt(), nil
+	case starlark.Float:
+		return float64(x), nil
+	case *starlark.Dict:
+		m := make(map[string]interface{}, x.Len())
+		for _, item := range x.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				k = item[0].String()
+			}
+			e, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[k] = e
+		}
+		return m, nil
+	case starlark.Indexable:
+		list := make([]interface{}, x.Len())
+		for i := range list {
+			var err error
+			if list[i], err = fromStarlark(x.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("Can't encode value of type %s", v.Type())
+}
@@ -0,0 +1,142 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/keyring"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewSecretCommand returns new `secret' command
+func NewSecretCommand(rootCtx *RootContext) *cobra.Command {
+	secretCmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Manage secrets in the OS keychain",
+		Long: `Manage secrets in the OS keychain (macOS Keychain, Secret Service, Windows Credential Manager) or in --secrets-file with --no-keyring.
+Configuration values of the form keyring:<name> are replaced with the stored secret, e.g. rpc_bearer_token: keyring:mainnet-token.
+The passphrase of an encrypted key stored as key/<alias> is used instead of prompting for it.`,
+		// Secrets don't need the RPC connection
+		Annotations: map[string]string{offlineAnnotation: ""},
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set <name> <value|-|@file>",
+		Short: "Store a secret",
+		Long:  "Store a secret. Use - to read the value from stdin or @path to read it from a file so it doesn't end up in the shell history.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value, err := utils.ReadInputString(args[1])
+			if err != nil {
+				return err
+			}
+			store, err := rootCtx.secretStore()
+			if err != nil {
+				return err
+			}
+			if err := store.Set(args[0], value); err != nil {
+				return err
+			}
+			log.WithField("name", args[0]).Info("Secret stored")
+			return nil
+		},
+	}
+
+	getCmd := &cobra.Command{
+		Use:   "get <name>",
+		Short: "Print a secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := rootCtx.secretStore()
+			if err != nil {
+				return err
+			}
+			v, err := store.Get(args[0])
+			if err == keyring.ErrNotFound {
+				return fmt.Errorf("Unknown secret: `%s'", args[0])
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Println(v)
+			return nil
+		},
+	}
+
+	deleteCmd := &cobra.Command{
+		Use:     "delete <name>",
+		Aliases: []string{"rm"},
+		Short:   "Delete a secret",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := rootCtx.secretStore()
+			if err != nil {
+				return err
+			}
+			err = store.Delete(args[0])
+			if err == keyring.ErrNotFound {
+				return fmt.Errorf("Unknown secret: `%s'", args[0])
+			}
+			return err
+		},
+	}
+
+	secretCmd.AddCommand(setCmd)
+	secretCmd.AddCommand(getCmd)
+	secretCmd.AddCommand(deleteCmd)
+
+	return secretCmd
+}
+
+// secretStore returns the OS keychain or the secrets file if --no-keyring is set
+func (c *RootContext) secretStore() (keyring.Store, error) {
+	if c.noKeyring {
+		path, err := utils.ExpandHome(c.secretsPath)
+		if err != nil {
+			return nil, err
+		}
+		return keyring.File(path), nil
+	}
+	return keyring.OS(keyring.Service), nil
+}
+
+// resolveSecret replaces a keyring:<name> reference with the stored secret
+func (c *RootContext) resolveSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, keyring.RefPrefix) {
+		return value, nil
+	}
+	store, err := c.secretStore()
+	if err != nil {
+		return "", err
+	}
+	v, err := keyring.Resolve(store, value)
+	if err != nil {
+		if c.noKeyring {
+			return "", err
+		}
+		return "", fmt.Errorf("%v (use --no-keyring if the OS keychain is unavailable)", err)
+	}
+	return v, nil
+}
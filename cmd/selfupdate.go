@@ -0,0 +1,442 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Release channels
+const (
+	channelStable = "stable"
+	channelEdge   = "edge"
+)
+
+const (
+	// ReleaseRepository is the GitHub repository the releases are published in, the same as the module path
+	ReleaseRepository = "ecadlabs/tez"
+	// DefaultReleaseFeedURL lists the project releases
+	DefaultReleaseFeedURL = "https://api.github.com/repos/" + ReleaseRepository + "/releases"
+	checksumsAsset        = "checksums.txt"
+	signatureAsset        = "checksums.txt.sig"
+	// Release binaries are at most this big
+	maxReleaseAssetSize = 256 << 20
+)
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+type release struct {
+	Tag        string          `json:"tag_name"`
+	Draft      bool            `json:"draft"`
+	Prerelease bool            `json:"prerelease"`
+	Assets     []*releaseAsset `json:"assets"`
+}
+
+func (r *release) asset(name string) *releaseAsset {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+type selfUpdateOptions struct {
+	channel       string
+	feedURL       string
+	publicKey     string
+	checkOnly     bool
+	force         bool
+	skipSignature bool
+}
+
+// NewSelfUpdateCommand returns new `self-update' command
+func NewSelfUpdateCommand(rootCtx *RootContext) *cobra.Command {
+	var opt selfUpdateOptions
+
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Update tez to the latest release",
+		Long: `Check the release feed and replace the running binary with the latest release of the channel.
+The stable channel follows final releases, the edge channel includes pre-releases.
+The downloaded binary is checked against the release's SHA-256 checksums, and the checksums file against its signature
+made with the release key (the Base58Check encoded signature of the release tag line followed by the file's bytes under the dedicated
+release watermark, as produced by tez sign bytes --watermark release, see .goreleaser.yml), so checksums of another release are refused. The new binary is started once to make sure it works before it atomically replaces the current one.`,
+		Args: cobra.NoArgs,
+		// Updates don't need the RPC connection
+		Annotations: map[string]string{offlineAnnotation: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCtx.selfUpdate(&opt)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&opt.channel, "channel", channelStable, "Release channel: one of [stable, edge]")
+	f.StringVar(&opt.feedURL, "feed-url", DefaultReleaseFeedURL, "Release feed URL (GitHub releases API compatible)")
+	f.StringVar(&opt.publicKey, "public-key", ReleasePublicKey, "Public key the release checksums are signed with")
+	f.BoolVar(&opt.checkOnly, "check", false, "Only report if an update is available")
+	f.BoolVar(&opt.force, "force", false, "Install the latest release even if it isn't newer than the running version")
+	f.BoolVar(&opt.skipSignature, "skip-signature", false, "Verify checksums only. Insecure, use with mirrors you control")
+
+	return cmd
+}
+
+// parseVersion splits v1.2.3-rc.1 into numeric and pre-release parts
+func parseVersion(s string) ([3]int, []string, bool) {
+	var num [3]int
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	var pre []string
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return num, nil, false
+	}
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return num, nil, false
+		}
+		num[i] = v
+	}
+	return num, pre, true
+}
+
+// compareVersions compares semantic versions. Invalid versions (like development builds) are older than anything
+func compareVersions(a, b string) int {
+	an, ap, aok := parseVersion(a)
+	bn, bp, bok := parseVersion(b)
+	switch {
+	case !aok && !bok:
+		return 0
+	case !aok:
+		return -1
+	case !bok:
+		return 1
+	}
+
+	for i := range an {
+		if an[i] != bn[i] {
+			if an[i] < bn[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A release is newer than its pre-releases
+	switch {
+	case len(ap) == 0 && len(bp) == 0:
+		return 0
+	case len(ap) == 0:
+		return 1
+	case len(bp) == 0:
+		return -1
+	}
+	for i := 0; i < len(ap) && i < len(bp); i++ {
+		x, xerr := strconv.Atoi(ap[i])
+		y, yerr := strconv.Atoi(bp[i])
+		var c int
+		switch {
+		case xerr == nil && yerr == nil:
+			c = x - y
+		case xerr == nil:
+			c = -1
+		case yerr == nil:
+			c = 1
+		default:
+			c = strings.Compare(ap[i], bp[i])
+		}
+		if c != 0 {
+			if c < 0 {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(ap) - len(bp)
+}
+
+// releaseAssetName returns the binary name for the platform
+func releaseAssetName() string {
+	name := fmt.Sprintf("tez-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func download(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s: response is too big", url)
+	}
+	return data, nil
+}
+
+// latestRelease returns the newest release of the channel. The feed lists releases newest first
+func latestRelease(ctx context.Context, feedURL, channel string) (*release, error) {
+	data, err := download(ctx, feedURL, 16<<20)
+	if err != nil {
+		return nil, err
+	}
+	var releases []*release
+	if err := json.Unmarshal(data, &releases); err != nil {
+		return nil, fmt.Errorf("%s: %v", feedURL, err)
+	}
+	for _, r := range releases {
+		if !r.Draft && (channel == channelEdge || !r.Prerelease) {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("No releases in the %s channel", channel)
+}
+
+// releaseChecksum looks up the file name in sha256sum formatted list
+func releaseChecksum(checksums []byte, name string) ([]byte, error) {
+	s := bufio.NewScanner(bytes.NewReader(checksums))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return hex.DecodeString(fields[0])
+		}
+	}
+	return nil, fmt.Errorf("No checksum for `%s'", name)
+}
+
+// releaseSignedPayload returns the bytes signed by the release key: the release tag on its own line followed by the checksums,
+// so the checksums of one release can't be passed off as another one's
+func releaseSignedPayload(tag string, checksums []byte) []byte {
+	payload := make([]byte, 0, len(tag)+1+len(checksums))
+	payload = append(payload, tag...)
+	payload = append(payload, '\n')
+	return append(payload, checksums...)
+}
+
+func verifyChecksumsSignature(tag string, checksums, sig []byte, publicKey string) error {
+	pub, err := keys.ParsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	s, err := keys.ParseSignature(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return err
+	}
+	if !keys.VerifyWatermarked(pub, keys.LookupWatermark(keys.WatermarkRelease), "", releaseSignedPayload(tag, checksums), s) {
+		return fmt.Errorf("Invalid signature of the %s release checksums", tag)
+	}
+	return nil
+}
+
+// parseVersionOutput extracts the version from the `tez version v1.2.3' line printed by --version
+func parseVersionOutput(out string) (string, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 3 || fields[0] != "tez" || fields[1] != "version" {
+		return "", fmt.Errorf("Unexpected version output: %s", strings.TrimSpace(out))
+	}
+	return fields[2], nil
+}
+
+// replaceExecutable writes the new binary next to the current one, checks that it starts and renames it over the current one
+func replaceExecutable(data []byte, version string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	fd, err := ioutil.TempFile(filepath.Dir(exe), ".tez-update")
+	if err != nil {
+		return err
+	}
+	tmp := fd.Name()
+	defer os.Remove(tmp)
+
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, fi.Mode().Perm()|0100); err != nil {
+		return err
+	}
+
+	out, err := exec.Command(tmp, "--version").Output()
+	if err != nil {
+		return fmt.Errorf("New binary doesn't start: %v", err)
+	}
+	v, err := parseVersionOutput(string(out))
+	if err != nil {
+		return fmt.Errorf("New binary: %v", err)
+	}
+	if v != version {
+		return fmt.Errorf("New binary reports version %s instead of %s", v, version)
+	}
+
+	if runtime.GOOS == "windows" {
+		// Running executable can't be replaced but can be renamed
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, exe)
+}
+
+func (c *RootContext) selfUpdate(opt *selfUpdateOptions) error {
+	switch opt.channel {
+	case channelStable, channelEdge:
+	default:
+		return fmt.Errorf("Unknown release channel: `%s'", opt.channel)
+	}
+	if opt.publicKey == "" && !opt.skipSignature {
+		return errors.New("Release public key is unknown, use --public-key")
+	}
+
+	rel, err := latestRelease(c.context, opt.feedURL, opt.channel)
+	if err != nil {
+		return err
+	}
+
+	l := log.WithFields(log.Fields{
+		"current": Version,
+		"latest":  rel.Tag,
+		"channel": opt.channel,
+	})
+	if compareVersions(rel.Tag, Version) <= 0 && !opt.force {
+		l.Info("Already up to date")
+		return nil
+	}
+	if opt.checkOnly {
+		l.Info("Update available")
+		fmt.Println(rel.Tag)
+		return nil
+	}
+
+	name := releaseAssetName()
+	bin := rel.asset(name)
+	if bin == nil {
+		return fmt.Errorf("Release %s has no binary for %s/%s", rel.Tag, runtime.GOOS, runtime.GOARCH)
+	}
+	sums := rel.asset(checksumsAsset)
+	if sums == nil {
+		return fmt.Errorf("Release %s has no checksums", rel.Tag)
+	}
+
+	checksums, err := download(c.context, sums.URL, 1<<20)
+	if err != nil {
+		return err
+	}
+	if opt.skipSignature {
+		log.Warn("Release signature isn't verified")
+	} else {
+		sa := rel.asset(signatureAsset)
+		if sa == nil {
+			return fmt.Errorf("Release %s is unsigned", rel.Tag)
+		}
+		sig, err := download(c.context, sa.URL, 1<<10)
+		if err != nil {
+			return err
+		}
+		if err := verifyChecksumsSignature(rel.Tag, checksums, sig, opt.publicKey); err != nil {
+			return err
+		}
+	}
+
+	expected, err := releaseChecksum(checksums, name)
+	if err != nil {
+		return err
+	}
+
+	l.WithField("size", bin.Size).Info("Downloading")
+	data, err := download(c.context, bin.URL, maxReleaseAssetSize)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(sum[:], expected) {
+		return fmt.Errorf("Checksum mismatch of `%s'", name)
+	}
+
+	if err := replaceExecutable(data, rel.Tag); err != nil {
+		return err
+	}
+	l.Info("Updated")
+	return nil
+}
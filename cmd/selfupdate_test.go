@@ -0,0 +1,122 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ecadlabs/tez/keys"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"v1.2.4", "v1.2.3", 1},
+		{"v1.10.0", "v1.9.9", 1},
+		{"v2.0.0", "v10.0.0", -1},
+		{"v1.2.3", "v1.2.3-rc.1", 1},
+		{"v1.2.3-rc.2", "v1.2.3-rc.10", -1},
+		{"v1.2.3-rc.1", "v1.2.3-beta.1", 1},
+		{"v1.2.3-rc", "v1.2.3-rc.1", -1},
+		{"v1.2.3+build", "v1.2.3", 0},
+		{"dev", "v0.0.1", -1},
+		{"dev", "unknown", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestReleaseChecksum(t *testing.T) {
+	checksums := []byte("00ff  tez-linux-amd64\nabcd *tez-windows-amd64.exe\n")
+	sum, err := releaseChecksum(checksums, "tez-windows-amd64.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sum) != 2 || sum[0] != 0xab || sum[1] != 0xcd {
+		t.Errorf("releaseChecksum() = %x", sum)
+	}
+	if _, err := releaseChecksum(checksums, "tez-darwin-arm64"); err == nil {
+		t.Error("releaseChecksum() succeeded for a missing asset")
+	}
+}
+
+func TestVerifyChecksumsSignature(t *testing.T) {
+	key, err := keys.GeneratePrivateKey(keys.TypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.Public().String()
+	checksums := []byte("00ff  tez-linux-amd64\n")
+
+	sig, err := keys.SignWatermarked(key, keys.LookupWatermark(keys.WatermarkRelease), "", []byte("v1.2.3\n00ff  tez-linux-amd64\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyChecksumsSignature("v1.2.3", checksums, []byte(sig.String()+"\n"), pub); err != nil {
+		t.Errorf("verifyChecksumsSignature() = %v", err)
+	}
+	if err := verifyChecksumsSignature("v1.2.3", []byte("00fe  tez-linux-amd64\n"), []byte(sig.String()), pub); err == nil {
+		t.Error("verifyChecksumsSignature() accepted modified checksums")
+	}
+	// Checksums of one release can't be replayed as another one's
+	if err := verifyChecksumsSignature("v1.2.4", checksums, []byte(sig.String()), pub); err == nil {
+		t.Error("verifyChecksumsSignature() accepted checksums signed for another release")
+	}
+
+	// Signatures made in other domains, e.g. of packed data, are refused
+	for _, w := range []byte{keys.WatermarkMichelson, keys.WatermarkGenericOperation} {
+		sig, err := keys.Sign(key, w, releaseSignedPayload("v1.2.3", checksums))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyChecksumsSignature("v1.2.3", checksums, []byte(sig.String()), pub); err == nil {
+			t.Errorf("verifyChecksumsSignature() accepted a signature under watermark 0x%02x", w)
+		}
+	}
+}
+
+func TestParseVersionOutput(t *testing.T) {
+	cases := []struct {
+		out     string
+		version string
+		ok      bool
+	}{
+		{"tez version v1.2.3\n", "v1.2.3", true},
+		{"tez version v1.2.3-rc.1\n", "v1.2.3-rc.1", true},
+		{"tez version v1.2.30\n", "v1.2.30", true},
+		{"v1.2.3\n", "", false},
+		{"tez version v1.2.3 (dirty)\n", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		v, err := parseVersionOutput(c.out)
+		if (err == nil) != c.ok || v != c.version {
+			t.Errorf("parseVersionOutput(%q) = %q, %v", c.out, v, err)
+		}
+	}
+}
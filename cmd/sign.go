@@ -0,0 +1,170 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// signedBytes is the result of signing raw bytes
+type signedBytes struct {
+	Signer    string `json:"signer" yaml:"signer"`
+	PublicKey string `json:"public_key" yaml:"public_key"`
+	Watermark string `json:"watermark" yaml:"watermark"`
+	ChainID   string `json:"chain_id,omitempty" yaml:"chain_id,omitempty"`
+	Signature string `json:"signature" yaml:"signature"`
+}
+
+// NewSignCommand returns new `sign' command
+func NewSignCommand(rootCtx *RootContext) *cobra.Command {
+	signCmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Sign arbitrary data with a keystore key",
+	}
+
+	signCmd.AddCommand(newSignBytesCommand(rootCtx))
+
+	return signCmd
+}
+
+func newSignBytesCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		from      string
+		watermark string
+		chainID   string
+		confirmed bool
+		format    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bytes <hex|-|@file>",
+		Short: "Sign raw bytes in the watermark's domain",
+		Long: fmt.Sprintf(`Sign hex encoded bytes prefixed with the watermark, one of [%s] or a hex byte like 0x03.
+Block and consensus watermarks are followed by the chain ID which is taken from the node unless --chain-id is given.
+Signing them by hand can get a baker slashed so they are refused without --i-know-what-i-am-doing.`, strings.Join(keys.WatermarkNames(), ", ")),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && rootCtx.encoderFunc(format) == nil {
+				return fmt.Errorf("Unknown output encoding: `%s'", format)
+			}
+			w, err := keys.ParseWatermark(watermark)
+			if err != nil {
+				return err
+			}
+			if w.Consensus() && !confirmed {
+				return errNotConfirmed
+			}
+
+			src, err := utils.ReadInputString(args[0])
+			if err != nil {
+				return err
+			}
+			data, err := hex.DecodeString(strings.TrimPrefix(src, "0x"))
+			if err != nil {
+				return err
+			}
+
+			acc, err := rootCtx.account(from)
+			if err != nil {
+				return err
+			}
+			if acc.Baker && w.Consensus() {
+				return fmt.Errorf("`%s' is a baker key and doesn't sign blocks or consensus operations by hand", acc.Alias)
+			}
+			if w.Consensus() && chainID == "" {
+				if err := rootCtx.getRPC("/chains/"+rootCtx.chainID+"/chain_id", &chainID); err != nil {
+					return err
+				}
+			}
+			sig, err := acc.signBytes(w, chainID, data)
+			if err != nil {
+				return err
+			}
+
+			log.WithFields(log.Fields{
+				"signer":    acc.Address,
+				"watermark": w.Name,
+				"length":    len(data),
+			}).Debug("Bytes signed")
+
+			if format == "text" {
+				fmt.Println(sig)
+				return nil
+			}
+			res := signedBytes{
+				Signer:    acc.Address,
+				PublicKey: acc.PublicKey,
+				Watermark: w.Name,
+				Signature: sig.String(),
+			}
+			if w.Consensus() {
+				res.ChainID = chainID
+			}
+			return rootCtx.encoderFunc(format)(os.Stdout).Encode(&res)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&from, "key", "", "Signing key alias")
+	f.StringVar(&watermark, "watermark", "generic", "Watermark name or hex byte")
+	f.StringVar(&chainID, "chain-id", "", "Chain ID of consensus watermarks (default: chain ID of the node)")
+	f.BoolVar(&confirmed, "i-know-what-i-am-doing", false, "Confirm signing blocks and consensus operations by hand")
+	f.StringVarP(&format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	cmd.MarkFlagRequired("key")
+
+	return cmd
+}
+
+// signBytes signs the data in the watermark's domain. Blocks and consensus operations signed by baker keys are checked against the high watermarks
+func (a *account) signBytes(w *keys.Watermark, chainID string, data []byte) (*keys.Signature, error) {
+	return a.sign(w, chainID, data, a.Baker && w.Consensus())
+}
+
+// signBytesUnchecked signs the data in the watermark's domain without the high watermark check.
+// Only debug commands producing conflicting consensus operations on purpose use it
+func (a *account) signBytesUnchecked(w *keys.Watermark, chainID string, data []byte) (*keys.Signature, error) {
+	return a.sign(w, chainID, data, false)
+}
+
+func (a *account) sign(w *keys.Watermark, chainID string, data []byte, checkHighWatermark bool) (*keys.Signature, error) {
+	if a.WatchOnly() {
+		return nil, fmt.Errorf("`%s' is a watch-only key and can't sign", a.Alias)
+	}
+	key := a.unlocked
+	if key == nil {
+		var err error
+		if key, err = a.privateKey(); err != nil {
+			return nil, err
+		}
+	}
+	if checkHighWatermark {
+		return a.signAboveHighWatermark(key, w, chainID, data)
+	}
+	return keys.SignWatermarked(key, w, chainID, data)
+}
@@ -0,0 +1,364 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Prepended to the key hash and the signed bytes in authentication signatures of the remote signer protocol
+const signerAuthenticationTag = 0x04
+
+// Maximum size of a signing request body
+const signerMaxRequestSize = 1 << 20
+
+// Signing is fast, slow clients are cut off rather than left holding connections to the keys
+const (
+	signerReadHeaderTimeout = 5 * time.Second
+	signerReadTimeout       = 10 * time.Second
+	signerWriteTimeout      = 10 * time.Second
+	signerIdleTimeout       = 60 * time.Second
+)
+
+type signerOptions struct {
+	listen     string
+	keys       []string
+	authorized []string
+}
+
+// servedKey is a keystore key exposed by the signer. Nil watermarks means the default policy,
+// consensus watermarks are only allowed for baker keys
+type servedKey struct {
+	acc        *account
+	pub        keys.PublicKey
+	watermarks map[byte]bool
+}
+
+func (k *servedKey) allowed(w *keys.Watermark) bool {
+	if k.watermarks != nil {
+		return k.watermarks[w.Tag]
+	}
+	return k.acc.Baker || !w.Consensus()
+}
+
+// signerServer implements the remote signer HTTP API of octez-signer
+type signerServer struct {
+	keys map[string]*servedKey
+	// Requests must be authenticated by one of the keys if the list is not empty
+	authorized []keys.PublicKey
+}
+
+// NewSignerCommand returns new `signer' command
+func NewSignerCommand(rootCtx *RootContext) *cobra.Command {
+	signerCmd := &cobra.Command{
+		Use:   "signer",
+		Short: "Remote signer backed by the keystore",
+	}
+
+	signerCmd.AddCommand(newSignerServeCommand(rootCtx))
+
+	return signerCmd
+}
+
+func newSignerServeCommand(rootCtx *RootContext) *cobra.Command {
+	var opt signerOptions
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the remote signer HTTP API",
+		Long: `Expose the keystore keys given with --key over the remote signer HTTP API used by octez-client and bakers
+(GET /keys/<address>, POST /keys/<address>, GET /authorized_keys). Keys are unlocked once at start.
+
+A key may be followed by the watermarks it is allowed to sign, e.g. --key baker:block,preattestation,attestation.
+By default baker keys (see key set-baker) sign anything and other keys sign everything but blocks and consensus
+operations. Blocks and consensus operations are always checked against the high watermark file (see --high-watermarks),
+which stays locked while signing, so other tez processes using the file, e.g. another signer or sign bytes, can't sign
+at the same level and round. The high watermarks of octez-signer or other signers are not consulted.
+With --authorized-key, requests must be authenticated by one of the keys like with octez-signer.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := rootCtx.newSignerServer(&opt)
+			if err != nil {
+				return err
+			}
+			return rootCtx.serveSigner(s, opt.listen)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&opt.listen, "listen", "127.0.0.1:6732", "Address to listen on")
+	f.StringArrayVar(&opt.keys, "key", nil, "Key alias optionally followed by the allowed watermarks: <alias>[:<watermark>,...] (repeatable)")
+	f.StringArrayVar(&opt.authorized, "authorized-key", nil, "Public key or alias of a key allowed to send requests (repeatable)")
+	cmd.MarkFlagRequired("key")
+
+	return cmd
+}
+
+func (c *RootContext) newSignerServer(opt *signerOptions) (*signerServer, error) {
+	s := signerServer{keys: make(map[string]*servedKey)}
+	for _, arg := range opt.keys {
+		name, list := arg, ""
+		if i := strings.IndexByte(arg, ':'); i >= 0 {
+			name, list = arg[:i], arg[i+1:]
+		}
+		acc, err := c.account(name)
+		if err != nil {
+			return nil, err
+		}
+		if acc.WatchOnly() {
+			return nil, fmt.Errorf("`%s' is a watch-only key and can't sign", acc.Alias)
+		}
+		k := servedKey{acc: acc}
+		if list != "" {
+			k.watermarks = make(map[byte]bool)
+			for _, n := range strings.Split(list, ",") {
+				w, err := keys.ParseWatermark(strings.TrimSpace(n))
+				if err != nil {
+					return nil, err
+				}
+				k.watermarks[w.Tag] = true
+			}
+		}
+
+		if acc.unlocked, err = acc.privateKey(); err != nil {
+			return nil, err
+		}
+		k.pub = acc.unlocked.Public()
+		s.keys[k.pub.Hash()] = &k
+	}
+
+	for _, name := range opt.authorized {
+		src := name
+		if acc, err := c.account(name); err == nil && acc.PublicKey != "" {
+			src = acc.PublicKey
+		}
+		pub, err := keys.ParsePublicKey(src)
+		if err != nil {
+			return nil, fmt.Errorf("Authorized key `%s': %v", name, err)
+		}
+		s.authorized = append(s.authorized, pub)
+	}
+	return &s, nil
+}
+
+func (c *RootContext) serveSigner(s *signerServer, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: signerReadHeaderTimeout,
+		ReadTimeout:       signerReadTimeout,
+		WriteTimeout:      signerWriteTimeout,
+		IdleTimeout:       signerIdleTimeout,
+	}
+
+	go func() {
+		<-c.context.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	addresses := make([]string, 0, len(s.keys))
+	for a := range s.keys {
+		addresses = append(addresses, a)
+	}
+	log.WithFields(log.Fields{
+		"listen":        l.Addr().String(),
+		"keys":          strings.Join(addresses, ","),
+		"authenticated": len(s.authorized) != 0,
+	}).Info("Signer started")
+
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// signerError is returned to the client with the status code
+type signerError struct {
+	status int
+	msg    string
+}
+
+func (e *signerError) Error() string {
+	return e.msg
+}
+
+func newSignerError(status int, format string, a ...interface{}) *signerError {
+	return &signerError{status: status, msg: fmt.Sprintf(format, a...)}
+}
+
+func (s *signerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fields := log.Fields{
+		"remote": r.RemoteAddr,
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, signerMaxRequestSize)
+	res, err := s.handle(r, fields)
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+		if e, ok := err.(*signerError); ok {
+			status = e.status
+		}
+		res = []map[string]string{{"kind": "permanent", "id": "signer.request_failed", "msg": err.Error()}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+
+	fields["status"] = status
+	fields["duration"] = time.Since(start)
+	entry := log.WithFields(fields)
+	if err != nil {
+		entry.WithError(err).Warn("Request refused")
+	} else {
+		entry.Info("Request served")
+	}
+}
+
+func (s *signerServer) handle(r *http.Request, fields log.Fields) (interface{}, error) {
+	if r.URL.Path == "/authorized_keys" {
+		if r.Method != http.MethodGet {
+			return nil, newSignerError(http.StatusMethodNotAllowed, "Method %s is not allowed", r.Method)
+		}
+		if len(s.authorized) == 0 {
+			return struct{}{}, nil
+		}
+		list := make([]string, len(s.authorized))
+		for i, pub := range s.authorized {
+			list[i] = pub.Hash()
+		}
+		return map[string][]string{"authorized_keys": list}, nil
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/keys/") {
+		return nil, newSignerError(http.StatusNotFound, "Not found")
+	}
+	address := strings.TrimPrefix(r.URL.Path, "/keys/")
+	k, ok := s.keys[address]
+	if !ok {
+		return nil, newSignerError(http.StatusNotFound, "Key %s is not served", address)
+	}
+	fields["key"] = k.acc.Alias
+
+	switch r.Method {
+	case http.MethodGet:
+		return map[string]string{"public_key": k.pub.String()}, nil
+	case http.MethodPost:
+	default:
+		return nil, newSignerError(http.StatusMethodNotAllowed, "Method %s is not allowed", r.Method)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, newSignerError(http.StatusBadRequest, "%v", err)
+	}
+	var src string
+	if err := json.Unmarshal(body, &src); err != nil {
+		return nil, newSignerError(http.StatusBadRequest, "Request body must be a hex encoded JSON string")
+	}
+	data, err := hex.DecodeString(src)
+	if err != nil || len(data) == 0 {
+		return nil, newSignerError(http.StatusBadRequest, "Request body must be a hex encoded JSON string")
+	}
+
+	if err := s.authenticate(k, data, r.URL.Query().Get("authentication")); err != nil {
+		return nil, err
+	}
+
+	wm := keys.LookupWatermark(data[0])
+	fields["watermark"] = wm.Name
+	if !k.allowed(wm) {
+		return nil, newSignerError(http.StatusForbidden, "Key `%s' is not allowed to sign %s", k.acc.Alias, wm.Name)
+	}
+
+	// Consensus payloads carry the chain ID after the watermark
+	var chainID string
+	payload := data[1:]
+	if wm.Consensus() {
+		if len(payload) < base58.PrefixChainID.PayloadLen {
+			return nil, newSignerError(http.StatusBadRequest, "Payload is too short")
+		}
+		if chainID, err = base58.PrefixChainID.Encode(payload[:base58.PrefixChainID.PayloadLen]); err != nil {
+			return nil, err
+		}
+		payload = payload[base58.PrefixChainID.PayloadLen:]
+		fields["chain_id"] = chainID
+		if level, round, err := wm.LevelRound(payload); err == nil {
+			fields["block_level"] = level
+			fields["round"] = round
+		}
+	}
+
+	// Blocks and consensus operations leaving the process are checked against the high watermarks whatever the key
+	sig, err := k.acc.sign(wm, chainID, payload, wm.Consensus())
+	if err != nil {
+		return nil, newSignerError(http.StatusForbidden, "%v", err)
+	}
+	return map[string]string{"signature": sig.String()}, nil
+}
+
+// authenticate checks the request signature made over the key hash and the data by one of the authorized keys
+func (s *signerServer) authenticate(k *servedKey, data []byte, auth string) error {
+	if len(s.authorized) == 0 {
+		return nil
+	}
+	if auth == "" {
+		return newSignerError(http.StatusUnauthorized, "Authentication is required")
+	}
+	sig, err := keys.ParseSignature(auth)
+	if err != nil {
+		return newSignerError(http.StatusUnauthorized, "%v", err)
+	}
+	_, hash, err := base58.DecodeAny(k.pub.Hash())
+	if err != nil {
+		return err
+	}
+	// Tagged key hash, the tag is the same as the public key's one
+	msg := append(append([]byte{k.pub.Bytes()[0]}, hash...), data...)
+	for _, pub := range s.authorized {
+		if keys.Verify(pub, signerAuthenticationTag, msg, sig) {
+			return nil
+		}
+	}
+	return newSignerError(http.StatusUnauthorized, "Request is not signed by an authorized key")
+}
@@ -0,0 +1,282 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/keys"
+)
+
+const testChainID = "NetXdQprcVkpaWU"
+
+// testAttestation returns forged attestation bytes at the level and round. Payloads at the same level and round
+// differ by the last byte of the block payload hash
+func testAttestation(level, round int32, payload byte) []byte {
+	buf := make([]byte, 32, 32+1+2+8+32)
+	buf = append(buf, 21, 0, 0)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(level))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(round))
+	return append(buf, append(make([]byte, 31), payload)...)
+}
+
+func testAccount(t *testing.T, dir, alias string, baker bool) *account {
+	k, err := keys.GeneratePrivateKey(keys.TypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := keys.NewEntry(alias, k, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Baker = baker
+	return &account{
+		Entry:              e,
+		highWatermarksPath: filepath.Join(dir, "high_watermarks.json"),
+		unlocked:           k,
+	}
+}
+
+func testTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestSignBytesHighWatermark(t *testing.T) {
+	dir := testTempDir(t)
+	w := keys.LookupWatermark(keys.WatermarkAttestation)
+
+	baker := testAccount(t, dir, "baker", true)
+	first, err := baker.signBytes(w, testChainID, testAttestation(10, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The identical payload gets the recorded signature, a conflicting one is refused
+	again, err := baker.signBytes(w, testChainID, testAttestation(10, 0, 1))
+	if err != nil || again.String() != first.String() {
+		t.Errorf("identical payload: %v, %v", again, err)
+	}
+	if _, err := baker.signBytes(w, testChainID, testAttestation(10, 0, 2)); err == nil {
+		t.Error("conflicting attestation signed")
+	}
+	if _, err := baker.signBytes(w, testChainID, testAttestation(9, 5, 1)); err == nil {
+		t.Error("attestation below the high watermark signed")
+	}
+	if _, err := baker.signBytes(w, testChainID, testAttestation(10, 1, 2)); err != nil {
+		t.Errorf("next round: %v", err)
+	}
+
+	// debug attest produces conflicting operations on purpose
+	if _, err := baker.signBytesUnchecked(w, testChainID, testAttestation(10, 0, 3)); err != nil {
+		t.Errorf("unchecked: %v", err)
+	}
+
+	// Other keys are only checked when served by the signer
+	other := testAccount(t, dir, "other", false)
+	for i := byte(1); i <= 2; i++ {
+		if _, err := other.signBytes(w, testChainID, testAttestation(10, 0, i)); err != nil {
+			t.Errorf("non-baker key: %v", err)
+		}
+	}
+}
+
+type testSigner struct {
+	t   *testing.T
+	srv *httptest.Server
+}
+
+func (s *testSigner) post(path string, data []byte) (int, map[string]string) {
+	body, _ := json.Marshal(hex.EncodeToString(data))
+	resp, err := http.Post(s.srv.URL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res map[string]string
+	json.NewDecoder(resp.Body).Decode(&res)
+	return resp.StatusCode, res
+}
+
+// consensusRequest returns the bytes sent by a baker: the watermark, the chain ID and the payload
+func consensusRequest(t *testing.T, tag byte, payload []byte) []byte {
+	id, err := base58.PrefixChainID.Decode(testChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append([]byte{tag}, id...), payload...)
+}
+
+func TestSignerServer(t *testing.T) {
+	dir := testTempDir(t)
+	plain := testAccount(t, dir, "plain", false)
+	consensus := testAccount(t, dir, "consensus", false)
+
+	s := signerServer{keys: map[string]*servedKey{
+		plain.Address: {acc: plain, pub: plain.unlocked.Public()},
+		consensus.Address: {
+			acc:        consensus,
+			pub:        consensus.unlocked.Public(),
+			watermarks: map[byte]bool{keys.WatermarkAttestation: true},
+		},
+	}}
+	ts := testSigner{t: t, srv: httptest.NewServer(&s)}
+	defer ts.srv.Close()
+
+	resp, err := http.Get(ts.srv.URL + "/keys/" + plain.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk map[string]string
+	json.NewDecoder(resp.Body).Decode(&pk)
+	resp.Body.Close()
+	if pk["public_key"] != plain.PublicKey {
+		t.Errorf("public key = %v", pk)
+	}
+
+	op := append([]byte{keys.WatermarkGenericOperation}, make([]byte, 40)...)
+	status, res := ts.post("/keys/"+plain.Address, op)
+	if status != http.StatusOK {
+		t.Fatalf("generic operation: %d %v", status, res)
+	}
+	sig, err := keys.ParseSignature(res["signature"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keys.Verify(plain.unlocked.Public(), keys.WatermarkGenericOperation, op[1:], sig) {
+		t.Error("signature doesn't verify")
+	}
+
+	// Non-baker keys don't sign consensus operations by default
+	att := consensusRequest(t, keys.WatermarkAttestation, testAttestation(10, 0, 1))
+	if status, _ := ts.post("/keys/"+plain.Address, att); status != http.StatusForbidden {
+		t.Errorf("consensus operation of a plain key: %d", status)
+	}
+	// Keys allowed to sign them are checked against the high watermarks
+	if status, res := ts.post("/keys/"+consensus.Address, att); status != http.StatusOK {
+		t.Errorf("attestation: %d %v", status, res)
+	}
+	conflict := consensusRequest(t, keys.WatermarkAttestation, testAttestation(10, 0, 2))
+	if status, _ := ts.post("/keys/"+consensus.Address, conflict); status != http.StatusForbidden {
+		t.Errorf("conflicting attestation: %d", status)
+	}
+	if status, _ := ts.post("/keys/"+consensus.Address, op); status != http.StatusForbidden {
+		t.Errorf("watermark outside of the list: %d", status)
+	}
+
+	if status, _ := ts.post("/keys/tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx", op); status != http.StatusNotFound {
+		t.Errorf("unknown key: %d", status)
+	}
+	big := strings.NewReader(`"` + strings.Repeat("00", signerMaxRequestSize) + `"`)
+	resp, err = http.Post(ts.srv.URL+"/keys/"+plain.Address, "application/json", big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("oversized request: %d", resp.StatusCode)
+	}
+}
+
+func TestSignerHighWatermarkProcesses(t *testing.T) {
+	dir := testTempDir(t)
+	baker := testAccount(t, dir, "baker", true)
+
+	s := signerServer{keys: map[string]*servedKey{
+		baker.Address: {acc: baker, pub: baker.unlocked.Public()},
+	}}
+	ts := testSigner{t: t, srv: httptest.NewServer(&s)}
+	defer ts.srv.Close()
+
+	// The signer races with other processes signing conflicting attestations with the same file
+	cmds := make([]*exec.Cmd, 3)
+	for i := range cmds {
+		cmds[i] = startHighWatermarkProcess(t, baker.highWatermarksPath, baker.unlocked, byte(i+2))
+	}
+	var signed int
+	if status, _ := ts.post("/keys/"+baker.Address, consensusRequest(t, keys.WatermarkAttestation, testAttestation(10, 0, 1))); status == http.StatusOK {
+		signed++
+	}
+	for _, cmd := range cmds {
+		if waitHighWatermarkProcess(t, cmd) {
+			signed++
+		}
+	}
+	if signed != 1 {
+		t.Errorf("%d conflicting attestations signed, want 1", signed)
+	}
+}
+
+func TestSignerAuthentication(t *testing.T) {
+	dir := testTempDir(t)
+	served := testAccount(t, dir, "served", false)
+	client := testAccount(t, dir, "client", false)
+
+	s := signerServer{
+		keys:       map[string]*servedKey{served.Address: {acc: served, pub: served.unlocked.Public()}},
+		authorized: []keys.PublicKey{client.unlocked.Public()},
+	}
+	ts := testSigner{t: t, srv: httptest.NewServer(&s)}
+	defer ts.srv.Close()
+
+	op := append([]byte{keys.WatermarkGenericOperation}, make([]byte, 40)...)
+	path := "/keys/" + served.Address
+	if status, _ := ts.post(path, op); status != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request: %d", status)
+	}
+
+	// Signed over the tagged hash of the served key and the data
+	_, hash, err := base58.DecodeAny(served.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := append(append([]byte{served.unlocked.Public().Bytes()[0]}, hash...), op...)
+	auth, err := keys.Sign(client.unlocked, signerAuthenticationTag, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, res := ts.post(path+"?authentication="+auth.String(), op); status != http.StatusOK {
+		t.Errorf("authenticated request: %d %v", status, res)
+	}
+
+	other, err := keys.Sign(served.unlocked, signerAuthenticationTag, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := ts.post(path+"?authentication="+other.String(), op); status != http.StatusUnauthorized {
+		t.Errorf("request signed by an unauthorized key: %d", status)
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/michelson"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the `contract find-similar' table
+var similarColumns = []utils.TableColumn{
+	{Header: "LEVEL", Width: 9, Align: utils.AlignRight},
+	{Header: "ADDRESS", Width: 36},
+	{Header: "ORIGINATOR", Width: 36, MinWidth: 12},
+	{Header: "OPERATION", Width: 51, MinWidth: 12},
+}
+
+type similarOptions struct {
+	last    int
+	limit   int
+	indexer string
+	format  string
+	crawl   crawlOptions
+}
+
+// similarContract is a contract having the searched code hash
+type similarContract struct {
+	Address    string     `json:"address" yaml:"address"`
+	Level      int        `json:"level" yaml:"level"`
+	Originator string     `json:"originator,omitempty" yaml:"originator,omitempty"`
+	Operation  string     `json:"operation,omitempty" yaml:"operation,omitempty"`
+	Time       *time.Time `json:"time,omitempty" yaml:"time,omitempty"`
+}
+
+// originationOperation is the part of a manager operation holding originations, including internal ones
+type originationOperation struct {
+	Hash     string `json:"hash"`
+	Contents []struct {
+		originationResult
+		Metadata struct {
+			OperationResult          *originationStatus   `json:"operation_result"`
+			InternalOperationResults []*originationResult `json:"internal_operation_results"`
+		} `json:"metadata"`
+	} `json:"contents"`
+}
+
+type originationResult struct {
+	Kind   string             `json:"kind"`
+	Source string             `json:"source"`
+	Script *contractScript    `json:"script"`
+	Result *originationStatus `json:"result"`
+}
+
+type originationStatus struct {
+	Status              string   `json:"status"`
+	OriginatedContracts []string `json:"originated_contracts"`
+}
+
+// indexerSameContract is the part of TzKT's contract returned by /v1/contracts/{address}/same
+type indexerSameContract struct {
+	Address       string     `json:"address"`
+	FirstActivity int        `json:"firstActivity"`
+	FirstTime     *time.Time `json:"firstActivityTime"`
+	Creator       *struct {
+		Address string `json:"address"`
+	} `json:"creator"`
+}
+
+func newContractHashCommand(ctx *RootContext) *cobra.Command {
+	var code string
+
+	cmd := &cobra.Command{
+		Use:   "hash [KT1...|alias]",
+		Short: "Print the contract's code hash",
+		Long: `Print the script expression hash of the contract's code, the same as the node's script_hash.
+Contracts deployed from the same code share the hash regardless of their storage.
+With --code the hash of the local Michelson source or Micheline JSON is printed instead, e.g. to compare it with deployed contracts.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (len(args) == 0) == (code == "") {
+				return fmt.Errorf("Either a contract or --code is required")
+			}
+
+			var script contractScript
+			if code != "" {
+				src, err := utils.ReadInputString(code)
+				if err != nil {
+					return err
+				}
+				if script.Code, err = michelson.ParseAny(src); err != nil {
+					return err
+				}
+			} else {
+				address, err := ctx.contractAddress(args[0])
+				if err != nil {
+					return err
+				}
+				s, err := ctx.contractScript(address)
+				if err != nil {
+					return err
+				}
+				script = *s
+			}
+
+			hash, err := script.codeHash()
+			if err != nil {
+				return err
+			}
+			fmt.Println(hash)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&code, "code", "", "Contract code given inline, as - (read from stdin) or @path")
+
+	return cmd
+}
+
+func newContractFindSimilarCommand(rootCtx *RootContext) *cobra.Command {
+	var opt similarOptions
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "find-similar <expr...|KT1...|alias>",
+		Short: "Find contracts with the same code hash",
+		Long: `Find contracts originated from the same code, given its hash (see ` + "`contract hash'" + `) or a reference contract.
+Originations, including internal ones, of the last N blocks up to the head (or --block) are scanned.
+With --indexer the whole chain history is searched using a TzKT compatible indexer instead, which requires a reference contract.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEnc utils.NewEncoderFunc
+			if opt.format != "text" {
+				if newEnc = rootCtx.encoderFunc(opt.format); newEnc == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+					return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+				}
+			}
+
+			var (
+				list []*similarContract
+				err  error
+			)
+			if opt.indexer != "" {
+				list, err = ctx.findSimilarIndexed(args[0], &opt)
+			} else {
+				list, err = ctx.findSimilar(args[0], &opt)
+			}
+			if err != nil {
+				return err
+			}
+			if newEnc != nil {
+				return newEnc(os.Stdout).Encode(list)
+			}
+			return writeSimilarContracts(list)
+		},
+	}
+
+	f := cmd.Flags()
+	f.IntVar(&opt.last, "last", 1000, "Number of blocks up to the head (or --block) to scan")
+	f.IntVar(&opt.limit, "limit", 100, "Maximum number of contracts returned by the indexer")
+	f.StringVar(&opt.indexer, "indexer", "", "Indexer API URL to search the whole history with, e.g. https://api.tzkt.io")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	addCrawlFlags(cmd, &opt.crawl, 8, "blocks")
+
+	return cmd
+}
+
+// referenceCodeHash returns the code hash given as is or the one of the reference contract
+func (c *RootContext) referenceCodeHash(s string) (string, error) {
+	if strings.HasPrefix(s, base58.PrefixScriptExprHash.Tag) {
+		if p, _, err := base58.DecodeAny(s); err == nil && p == base58.PrefixScriptExprHash {
+			return s, nil
+		}
+		return "", fmt.Errorf("Invalid script expression hash: `%s'", s)
+	}
+	address, err := c.contractAddress(s)
+	if err != nil {
+		return "", err
+	}
+	script, err := c.contractScript(address)
+	if err != nil {
+		return "", err
+	}
+	return script.codeHash()
+}
+
+// findSimilar scans originations of the last blocks for the code hash
+func (c *BlockCommandContext) findSimilar(ref string, opt *similarOptions) ([]*similarContract, error) {
+	hash, err := c.referenceCodeHash(ref)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := c.windowArgs(opt.last)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.primeCache(); err != nil {
+		return nil, err
+	}
+
+	fetch := func(ctx context.Context, query string) (interface{}, error) {
+		var ops []*originationOperation
+		if err := c.getRPC(fmt.Sprintf("%s/operations/%d", c.blockPath(query), managerPass), &ops); err != nil {
+			return nil, crawlError(err)
+		}
+		return ops, nil
+	}
+
+	list := make([]*similarContract, 0)
+	match := func(level int, op string, o *originationResult, st *originationStatus) {
+		if o.Kind != "origination" || o.Script == nil || o.Script.Code == nil || st == nil || st.Status != "applied" {
+			return
+		}
+		h, err := o.Script.codeHash()
+		if err != nil {
+			log.WithError(err).WithField("operation", op).Debug("Can't hash the contract code")
+			return
+		}
+		if h != hash {
+			return
+		}
+		for _, address := range st.OriginatedContracts {
+			list = append(list, &similarContract{
+				Address:    address,
+				Level:      level,
+				Originator: o.Source,
+				Operation:  op,
+			})
+		}
+	}
+
+	err = c.newCrawler(&opt.crawl, "blocks").Run(c.context, levels, fetch, func(i int, query string, v interface{}) error {
+		level, err := strconv.Atoi(query)
+		if err != nil {
+			return err
+		}
+		for _, op := range v.([]*originationOperation) {
+			for _, el := range op.Contents {
+				match(level, op.Hash, &el.originationResult, el.Metadata.OperationResult)
+				for _, ir := range el.Metadata.InternalOperationResults {
+					match(level, op.Hash, ir, ir.Result)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"hash":   hash,
+		"blocks": len(levels),
+		"found":  len(list),
+	}).Debug("Originations scanned")
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Level < list[j].Level })
+	return list, nil
+}
+
+// findSimilarIndexed asks the indexer for contracts having the same code as the reference contract.
+// TzKT's own code hashes aren't script expression hashes so a bare hash can't be searched for
+func (c *RootContext) findSimilarIndexed(ref string, opt *similarOptions) ([]*similarContract, error) {
+	if strings.HasPrefix(ref, base58.PrefixScriptExprHash.Tag) {
+		return nil, fmt.Errorf("A reference contract is required with --indexer")
+	}
+	address, err := c.contractAddress(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*indexerSameContract
+	ok, err := c.getIndexer(opt.indexer, fmt.Sprintf("/v1/contracts/%s/same?limit=%d", address, opt.limit), &res)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("Contract %s is not found", address)
+	}
+
+	list := make([]*similarContract, len(res))
+	for i, r := range res {
+		list[i] = &similarContract{
+			Address: r.Address,
+			Level:   r.FirstActivity,
+			Time:    r.FirstTime,
+		}
+		if r.Creator != nil {
+			list[i].Originator = r.Creator.Address
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Level < list[j].Level })
+	return list, nil
+}
+
+func writeSimilarContracts(list []*similarContract) error {
+	table := utils.NewTable(os.Stdout, similarColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, s := range list {
+		originator, op := s.Originator, s.Operation
+		if originator == "" {
+			originator = "-"
+		}
+		if op == "" {
+			op = "-"
+		}
+		if err := table.WriteRow(strconv.Itoa(s.Level), s.Address, originator, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
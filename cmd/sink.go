@@ -0,0 +1,165 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/ecadlabs/tez/keyring"
+	"github.com/ecadlabs/tez/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Default --sink-key templates
+const (
+	blockSinkKey     = "{{.Hash}}"
+	operationSinkKey = "{{.Source}}"
+)
+
+// eventSinks publishes every event as JSON to --sink destinations
+type eventSinks struct {
+	ctx   context.Context
+	sinks []sink.Sink
+	key   *template.Template
+}
+
+// opEvent is the operation representation published to sinks. Amounts are in mutez
+type opEvent struct {
+	Block       string                 `json:"block"`
+	Level       int                    `json:"level"`
+	Hash        string                 `json:"hash"`
+	Kind        string                 `json:"kind"`
+	Source      string                 `json:"source,omitempty"`
+	Destination string                 `json:"destination,omitempty"`
+	Amount      string                 `json:"amount,omitempty"`
+	Fee         string                 `json:"fee,omitempty"`
+	Counter     string                 `json:"counter,omitempty"`
+	Raw         map[string]interface{} `json:"raw,omitempty"`
+}
+
+func newOpEvent(op *opInfo) *opEvent {
+	ev := opEvent{
+		Hash:        op.Hash,
+		Kind:        op.Kind,
+		Source:      op.Source,
+		Destination: op.Destination,
+		Counter:     op.Counter,
+		Raw:         op.Raw,
+	}
+	if op.Block != nil {
+		ev.Block = op.Block.Hash
+		ev.Level = op.Block.Header.Level
+	}
+	if op.AmountMutez != nil {
+		ev.Amount = op.AmountMutez.String()
+	}
+	if op.FeeMutez != nil {
+		ev.Fee = op.FeeMutez.String()
+	}
+	return &ev
+}
+
+// resolveSinkURL replaces a keyring:<name> reference given as the whole URL or as its password with the stored secret
+func (c *RootContext) resolveSinkURL(rawurl string) (string, error) {
+	rawurl, err := c.resolveSecret(rawurl)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User == nil {
+		// Malformed URLs are reported by sink.New
+		return rawurl, nil
+	}
+	pass, ok := u.User.Password()
+	if !ok || !strings.HasPrefix(pass, keyring.RefPrefix) {
+		return rawurl, nil
+	}
+	if pass, err = c.resolveSecret(pass); err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword(u.User.Username(), pass)
+	return u.String(), nil
+}
+
+func (c *RootContext) newEventSinks(urls []string, keySrc string, funcs template.FuncMap) (*eventSinks, error) {
+	key, err := template.New("key").Funcs(funcs).Parse(keySrc)
+	if err != nil {
+		return nil, err
+	}
+
+	s := eventSinks{
+		ctx: c.context,
+		key: key,
+	}
+
+	for _, u := range urls {
+		resolved, err := c.resolveSinkURL(u)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		snk, err := sink.New(c.context, resolved)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		// The unresolved URL is logged so secrets don't leak
+		log.WithField("sink", u).Debug("Sink connected")
+		s.sinks = append(s.sinks, snk)
+	}
+
+	return &s, nil
+}
+
+// publish sends the value keyed by the template executed against data
+func (s *eventSinks) publish(data, value interface{}) error {
+	var key strings.Builder
+	if err := s.key.Execute(&key, data); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	msg := sink.Message{Value: buf}
+	if key.Len() != 0 {
+		msg.Key = []byte(key.String())
+	}
+
+	for _, snk := range s.sinks {
+		if err := snk.Publish(s.ctx, &msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *eventSinks) close() {
+	for _, snk := range s.sinks {
+		snk.Close()
+	}
+}
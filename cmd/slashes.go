@@ -0,0 +1,386 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/protocol"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the denunciation table
+var slashColumns = []utils.TableColumn{
+	{Header: "LEVEL", Width: 9, Align: utils.AlignRight},
+	{Header: "KIND", Width: 30, MinWidth: 12},
+	{Header: "MISBEHAVIOUR", Width: 12, Align: utils.AlignRight},
+	{Header: "LOST", Width: 20, Align: utils.AlignRight},
+	{Header: "OPERATION", Width: 51, MinWidth: 12},
+}
+
+// Denunciation kinds
+var evidenceKinds = map[string]struct{}{
+	protocol.KindDoubleBakingEvidence:         {},
+	protocol.KindDoubleEndorsementEvidence:    {},
+	protocol.KindDoublePreendorsementEvidence: {},
+	protocol.KindDoubleAttestationEvidence:    {},
+	protocol.KindDoublePreattestationEvidence: {},
+}
+
+// TzKT operation types listing denunciations by offender
+var indexerEvidenceTypes = []string{"double_baking", "double_endorsing", "double_preendorsing"}
+
+// Anonymous operations validation pass
+const anonymousPass = 2
+
+type slashesOptions struct {
+	indexer string
+	format  string
+	crawl   crawlOptions
+}
+
+// evidenceContent holds the fields of denunciations of all protocols. Since Paris the offender is given explicitly
+// and the slashing is applied at the end of the cycle, before it's taken from the frozen deposits balance updates
+type evidenceContent struct {
+	Kind string `json:"kind"`
+	Bh1  *struct {
+		Level int `json:"level"`
+	} `json:"bh1"`
+	Op1 *struct {
+		Operations struct {
+			Level int `json:"level"`
+		} `json:"operations"`
+	} `json:"op1"`
+	Metadata struct {
+		PunishedDelegate string `json:"punished_delegate"`
+		RewardedDelegate string `json:"rewarded_delegate"`
+		Misbehaviour     *struct {
+			Level int `json:"level"`
+		} `json:"misbehaviour"`
+		BalanceUpdates []*balanceUpdate `json:"balance_updates"`
+	} `json:"metadata"`
+}
+
+type evidenceOperation struct {
+	Hash     string             `json:"hash"`
+	Contents []*evidenceContent `json:"contents"`
+}
+
+type slashEvent struct {
+	Level             int        `json:"level" yaml:"level"`
+	Operation         string     `json:"operation" yaml:"operation"`
+	Kind              string     `json:"kind" yaml:"kind"`
+	MisbehaviourLevel int        `json:"misbehaviour_level,omitempty" yaml:"misbehaviour_level,omitempty"`
+	Accuser           string     `json:"accuser,omitempty" yaml:"accuser,omitempty"`
+	LostMutez         *big.Int   `json:"lost_mutez" yaml:"lost_mutez"`
+	Lost              *big.Float `json:"lost" yaml:"lost"`
+	// Slashing is scheduled for the end of the cycle
+	Pending bool `json:"pending,omitempty" yaml:"pending,omitempty"`
+}
+
+type delegateSlashes struct {
+	Delegate  string        `json:"delegate" yaml:"delegate"`
+	LostMutez *big.Int      `json:"lost_mutez" yaml:"lost_mutez"`
+	Lost      *big.Float    `json:"lost" yaml:"lost"`
+	Slashes   []*slashEvent `json:"slashes" yaml:"slashes"`
+}
+
+func newDelegateSlashesCommand(ctx *RootContext) *cobra.Command {
+	var opt slashesOptions
+
+	slashesCmd := &cobra.Command{
+		Use:   "slashes <delegate> [block ranges]",
+		Short: "Double baking and double attestation denunciations against a delegate",
+		Long: `List denunciations against the delegate with the levels of the misbehaviour and the amounts it lost.
+Evidence operations are looked up in the given blocks or ranges, e.g. 5000000..head, or at the levels reported by
+a TzKT compatible indexer with --indexer. Blocks are fetched from the node either way so the figures are the node's ones.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, err := ctx.resolveAddress(args[0])
+			if err != nil {
+				return err
+			}
+			return ctx.showSlashes(address, args[1:], &opt)
+		},
+	}
+
+	f := slashesCmd.Flags()
+	f.StringVar(&opt.indexer, "indexer", "", "Indexer API URL to get denunciation levels from instead of scanning blocks, e.g. https://api.tzkt.io")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	addCrawlFlags(slashesCmd, &opt.crawl, 8, "blocks")
+
+	return slashesCmd
+}
+
+// indexerSlashLevels returns levels of the blocks including denunciations against the delegate
+func (c *RootContext) indexerSlashLevels(indexer, delegate string) ([]string, error) {
+	seen := make(map[int]struct{})
+	for _, t := range indexerEvidenceTypes {
+		var levels []int
+		ok, err := c.getIndexer(indexer, fmt.Sprintf("/v1/operations/%s?offender=%s&select=level&limit=10000", t, url.QueryEscape(delegate)), &levels)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// Operation type unknown to the indexer version
+			log.WithField("type", t).Debug("Indexer doesn't support the operation type")
+		}
+		for _, l := range levels {
+			seen[l] = struct{}{}
+		}
+	}
+
+	levels := make([]int, 0, len(seen))
+	for l := range seen {
+		levels = append(levels, l)
+	}
+	sort.Ints(levels)
+	res := make([]string, len(levels))
+	for i, l := range levels {
+		res[i] = strconv.Itoa(l)
+	}
+	return res, nil
+}
+
+// ownedBy returns true if the balance update belongs to the delegate or its stake
+func (u *balanceUpdate) ownedBy(delegate string) bool {
+	if u.Contract == delegate || u.Delegate == delegate {
+		return true
+	}
+	for _, v := range u.Staker {
+		if s, _ := v.(string); s == delegate {
+			return true
+		}
+	}
+	return false
+}
+
+// lostBy sums up negative updates of the delegate's balances
+func lostBy(updates []*balanceUpdate, delegate string) *big.Int {
+	sum := new(big.Int)
+	for _, u := range updates {
+		if u.Change.Sign() < 0 && u.ownedBy(delegate) {
+			sum.Sub(sum, &u.Change.Int)
+		}
+	}
+	return sum
+}
+
+// newSlashEvent returns nil if the denunciation isn't against the delegate
+func newSlashEvent(level int, hash string, e *evidenceContent, delegate string) *slashEvent {
+	md := &e.Metadata
+	offender := md.PunishedDelegate
+	if offender == "" {
+		// Frozen deposits of the offender are debited by the operation
+		for _, u := range md.BalanceUpdates {
+			if u.Change.Sign() < 0 && u.Delegate != "" {
+				offender = u.Delegate
+				break
+			}
+		}
+	}
+	if offender != delegate {
+		return nil
+	}
+
+	ev := slashEvent{
+		Level:     level,
+		Operation: hash,
+		Kind:      e.Kind,
+		Accuser:   md.RewardedDelegate,
+		LostMutez: lostBy(md.BalanceUpdates, delegate),
+	}
+	switch {
+	case md.Misbehaviour != nil:
+		ev.MisbehaviourLevel = md.Misbehaviour.Level
+	case e.Bh1 != nil:
+		ev.MisbehaviourLevel = e.Bh1.Level
+	case e.Op1 != nil:
+		ev.MisbehaviourLevel = e.Op1.Operations.Level
+	}
+	if ev.Accuser == "" {
+		for _, u := range md.BalanceUpdates {
+			if u.Change.Sign() > 0 && !u.ownedBy(delegate) {
+				if ev.Accuser = u.Contract; ev.Accuser == "" {
+					ev.Accuser = u.Delegate
+				}
+				break
+			}
+		}
+	}
+	// Deferred slashing
+	ev.Pending = md.PunishedDelegate != "" && ev.LostMutez.Sign() == 0
+	return &ev
+}
+
+// resolveDelayedSlash looks for the slashing in the last blocks of the denunciation's cycle and the following one
+func (c *RootContext) resolveDelayedSlash(ev *slashEvent, delegate string, head int) error {
+	for offset := 0; offset < 2; offset++ {
+		var lv cycleLevels
+		if err := c.getRPC(fmt.Sprintf("%s/helpers/levels_in_current_cycle?offset=%d", c.blockPath(strconv.Itoa(ev.Level)), offset), &lv); err != nil {
+			return err
+		}
+		if lv.Last > head {
+			return nil
+		}
+
+		var m blockRewards
+		if err := c.getRPC(c.blockPath(strconv.Itoa(lv.Last))+"/metadata", &m); err != nil {
+			return err
+		}
+		var updates []*balanceUpdate
+		for _, u := range m.BalanceUpdates {
+			if u.DelayedOperationHash == ev.Operation {
+				updates = append(updates, u)
+			}
+		}
+		if len(updates) != 0 {
+			ev.LostMutez, ev.Pending = lostBy(updates, delegate), false
+			return nil
+		}
+	}
+	return nil
+}
+
+func (c *RootContext) delegateSlashes(delegate string, args []string, opt *slashesOptions) (*delegateSlashes, error) {
+	var (
+		queries []string
+		err     error
+	)
+	switch {
+	case opt.indexer != "" && len(args) != 0:
+		return nil, errors.New("Block ranges and --indexer are mutually exclusive")
+	case opt.indexer != "":
+		queries, err = c.indexerSlashLevels(opt.indexer, delegate)
+	case len(args) != 0:
+		queries, err = c.expandBlockArgs(args)
+	default:
+		return nil, errors.New("Block ranges or --indexer are required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.primeCache(); err != nil {
+		return nil, err
+	}
+
+	fetch := func(ctx context.Context, query string) (interface{}, error) {
+		var ops []*evidenceOperation
+		if err := c.getRPC(fmt.Sprintf("%s/operations/%d", c.blockPath(query), anonymousPass), &ops); err != nil {
+			return nil, crawlError(err)
+		}
+		return ops, nil
+	}
+
+	res := delegateSlashes{
+		Delegate:  delegate,
+		LostMutez: new(big.Int),
+	}
+	err = c.newCrawler(&opt.crawl, "blocks").Run(c.context, queries, fetch, func(i int, query string, v interface{}) error {
+		level, err := c.resolveLevel(query)
+		if err != nil {
+			return err
+		}
+		for _, op := range v.([]*evidenceOperation) {
+			for _, e := range op.Contents {
+				if _, ok := evidenceKinds[e.Kind]; !ok {
+					continue
+				}
+				if ev := newSlashEvent(level, op.Hash, e, delegate); ev != nil {
+					res.Slashes = append(res.Slashes, ev)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var head *currentLevel
+	for _, ev := range res.Slashes {
+		if ev.Pending {
+			if head == nil {
+				head = new(currentLevel)
+				if err := c.getRPC(c.blockPath(c.blockID)+"/helpers/current_level", head); err != nil {
+					return nil, err
+				}
+			}
+			if err := c.resolveDelayedSlash(ev, delegate, head.Level); err != nil {
+				return nil, err
+			}
+		}
+		ev.Lost = c.amountFormat.Tez(ev.LostMutez)
+		res.LostMutez.Add(res.LostMutez, ev.LostMutez)
+	}
+	res.Lost = c.amountFormat.Tez(res.LostMutez)
+	sort.SliceStable(res.Slashes, func(i, j int) bool { return res.Slashes[i].Level < res.Slashes[j].Level })
+	return &res, nil
+}
+
+func (c *RootContext) showSlashes(delegate string, args []string, opt *slashesOptions) error {
+	var newEncoder utils.NewEncoderFunc
+	if opt.format != "text" {
+		if newEncoder = c.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+
+	res, err := c.delegateSlashes(delegate, args, opt)
+	if err != nil {
+		return err
+	}
+	if newEncoder != nil {
+		return newEncoder(os.Stdout).Encode(res)
+	}
+
+	fmt.Printf("Delegate:      %s\nDenunciations: %d\nTotal lost:    %s\n\n", res.Delegate, len(res.Slashes), c.amountFormat.Format(res.LostMutez))
+	if len(res.Slashes) == 0 {
+		return nil
+	}
+	table := utils.NewTable(os.Stdout, slashColumns, utils.TerminalWidth(os.Stdout))
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, ev := range res.Slashes {
+		lost := c.amountFormat.Format(ev.LostMutez)
+		if ev.Pending {
+			lost = "pending"
+		}
+		var misbehaviour string
+		if ev.MisbehaviourLevel != 0 {
+			misbehaviour = strconv.Itoa(ev.MisbehaviourLevel)
+		}
+		if err := table.WriteRow(strconv.Itoa(ev.Level), protocol.Title(ev.Kind), misbehaviour, lost, ev.Operation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ecadlabs/tez/protocol"
+)
+
+// Operation kinds counted as the consensus participation
+var consensusKinds = map[string]struct{}{
+	protocol.KindEndorsement:         {},
+	protocol.KindEndorsementWithSlot: {},
+	protocol.KindAttestation:         {},
+	protocol.KindAttestationWithDAL:  {},
+}
+
+// slotCell is a single slot before Tenderbake or a delegate's share of the committee after it
+type slotCell struct {
+	Slot     int
+	Delegate string
+	Power    int
+	Attested bool
+}
+
+type slotMap struct {
+	Level int
+	Cells []*slotCell
+	Total int
+	// Attested slots
+	Filled int
+	// Tenderbake rights are given per delegate and not per slot
+	PerDelegate bool
+}
+
+// consensusRights is the union of pre-Tenderbake endorsing_rights and later endorsing_rights/attestation_rights entries
+type consensusRights struct {
+	Level     int    `json:"level"`
+	Delegate  string `json:"delegate"`
+	Slots     []int  `json:"slots"`
+	Delegates []struct {
+		Delegate         string `json:"delegate"`
+		ConsensusKey     string `json:"consensus_key"`
+		FirstSlot        int    `json:"first_slot"`
+		EndorsingPower   int    `json:"endorsing_power"`
+		AttestationPower int    `json:"attestation_power"`
+	} `json:"delegates"`
+}
+
+// getSlotMap returns the consensus committee of the block's predecessor level, which is attested by operations included in the block
+func (c *BlockCommandContext) getSlotMap(b *xblock) (*slotMap, error) {
+	m := slotMap{Level: b.Header.Level - 1}
+	if m.Level < 1 {
+		return &m, nil
+	}
+
+	var rights []*consensusRights
+	err := c.getRPC(fmt.Sprintf("%s/helpers/attestation_rights?level=%d", c.blockPath(b.Hash), m.Level), &rights)
+	if isNotFound(err) {
+		err = c.getRPC(fmt.Sprintf("%s/helpers/endorsing_rights?level=%d", c.blockPath(b.Hash), m.Level), &rights)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attested := make(map[string]struct{})
+	for _, op := range getBlockOperations(getBlockInfo(b), consensusKinds) {
+		attested[op.Source] = struct{}{}
+	}
+
+	for _, r := range rights {
+		for _, s := range r.Slots {
+			m.Cells = append(m.Cells, &slotCell{Slot: s, Delegate: r.Delegate, Power: 1})
+		}
+		for _, d := range r.Delegates {
+			power := d.AttestationPower
+			if power == 0 {
+				power = d.EndorsingPower
+			}
+			m.Cells = append(m.Cells, &slotCell{Slot: d.FirstSlot, Delegate: d.Delegate, Power: power})
+			m.PerDelegate = true
+		}
+	}
+	sort.Slice(m.Cells, func(i, j int) bool { return m.Cells[i].Slot < m.Cells[j].Slot })
+
+	for _, cell := range m.Cells {
+		_, cell.Attested = attested[cell.Delegate]
+		m.Total += cell.Power
+		if cell.Attested {
+			m.Filled += cell.Power
+		}
+	}
+	return &m, nil
+}
+
+// initials returns two characters of the address following its prefix
+func initials(address string) string {
+	if len(address) < 5 {
+		return address
+	}
+	return address[3:5]
+}
+
+// writeSlotMap renders the committee as a grid of delegate initials, green for attested and red for missing slots.
+// Missing delegates are also listed so the map is readable without colors
+func (c *BlockCommandContext) writeSlotMap(w io.Writer, m *slotMap) error {
+	if len(m.Cells) == 0 {
+		_, err := fmt.Fprintf(w, "Slots:        no consensus committee at level %d\n\n", m.Level)
+		return err
+	}
+
+	width := c.maxWidth
+	if width == 0 {
+		width = 80
+	}
+	perRow := width / 3
+	if perRow < 1 {
+		perRow = 1
+	}
+
+	unit := "one cell per slot"
+	if m.PerDelegate {
+		unit = "one cell per delegate"
+	}
+	_, err := fmt.Fprintf(w, "Slots:        %d/%d attested at level %d (%.1f%%, %s)\n", m.Filled, m.Total, m.Level, float64(m.Filled)*100/float64(m.Total), unit)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	missingPower := make(map[string]int)
+	for i, cell := range m.Cells {
+		var s interface{}
+		if cell.Attested {
+			s = c.colorizer.Green(initials(cell.Delegate))
+		} else {
+			s = c.colorizer.Red(initials(cell.Delegate))
+			if _, ok := missingPower[cell.Delegate]; !ok {
+				missing = append(missing, cell.Delegate)
+			}
+			missingPower[cell.Delegate] += cell.Power
+		}
+		sep := " "
+		if (i+1)%perRow == 0 || i == len(m.Cells)-1 {
+			sep = "\n"
+		}
+		if _, err := fmt.Fprintf(w, "%v%s", s, sep); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) != 0 {
+		list := make([]string, len(missing))
+		for i, d := range missing {
+			list[i] = fmt.Sprintf("%s (%d)", d, missingPower[d])
+		}
+		if _, err := fmt.Fprintf(w, "Missing:      %s\n", strings.Join(list, ", ")); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,364 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Columns of the stake distribution table
+var stakeColumns = []utils.TableColumn{
+	{Header: "RANK", Width: 5, Align: utils.AlignRight},
+	{Header: "DELEGATE", Width: 36, MinWidth: 13},
+	{Header: "STAKING BALANCE", Width: 24, Align: utils.AlignRight},
+	{Header: "ROLLS", Width: 7, Align: utils.AlignRight},
+	{Header: "SHARE", Width: 7, Align: utils.AlignRight},
+}
+
+type stakeOptions struct {
+	cycle  int
+	top    int
+	limit  int
+	crawl  crawlOptions
+	format string
+}
+
+type delegateStake struct {
+	Rank                int        `json:"rank" yaml:"rank"`
+	Delegate            string     `json:"delegate" yaml:"delegate"`
+	StakingBalanceMutez *big.Int   `json:"staking_balance_mutez" yaml:"staking_balance_mutez"`
+	StakingBalance      *big.Float `json:"staking_balance" yaml:"staking_balance"`
+	Rolls               *big.Int   `json:"rolls,omitempty" yaml:"rolls,omitempty"`
+	Share               float64    `json:"share" yaml:"share"`
+}
+
+type stakeDistribution struct {
+	Block             string           `json:"block" yaml:"block"`
+	Cycle             int              `json:"cycle" yaml:"cycle"`
+	DelegatesNum      int              `json:"delegates" yaml:"delegates"`
+	TotalMutez        *big.Int         `json:"total_mutez" yaml:"total_mutez"`
+	Total             *big.Float       `json:"total" yaml:"total"`
+	RollSizeMutez     *big.Int         `json:"roll_size_mutez,omitempty" yaml:"roll_size_mutez,omitempty"`
+	Gini              float64          `json:"gini" yaml:"gini"`
+	TopN              int              `json:"top_n" yaml:"top_n"`
+	TopNConcentration float64          `json:"top_n_concentration" yaml:"top_n_concentration"`
+	Delegates         []*delegateStake `json:"delegate_list" yaml:"delegate_list"`
+}
+
+type currentLevel struct {
+	Level int `json:"level"`
+	Cycle int `json:"cycle"`
+}
+
+type cycleLevels struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+// Only one of the fields is present depending on the protocol
+type stakeConstants struct {
+	TokensPerRoll *tezos.BigInt `json:"tokens_per_roll"`
+	MinimalStake  *tezos.BigInt `json:"minimal_stake"`
+}
+
+func newStakeCommand(ctx *BlockCommandContext) *cobra.Command {
+	var opt stakeOptions
+
+	stakeCmd := &cobra.Command{
+		Use:   "stake",
+		Short: "Distribution of staking balances across active delegates",
+		Long: `Distribution of staking balances across active delegates including the Gini coefficient and the share of the top N delegates.
+By default the distribution is taken at --block, with --cycle it is taken at the last block of the cycle.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.showStake(&opt)
+		},
+	}
+
+	f := stakeCmd.Flags()
+	f.IntVar(&opt.cycle, "cycle", -1, "Cycle to take the distribution at")
+	f.IntVar(&opt.top, "top", 10, "Number of the largest delegates used for the concentration figure")
+	f.IntVar(&opt.limit, "limit", 20, "Number of delegates to list (0 means all)")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, csv, yaml, json, markdown, html]")
+	f.BoolVar(&ctx.wide, "wide", false, "Don't truncate table columns to fit the terminal")
+	addCrawlFlags(stakeCmd, &opt.crawl, 8, "delegates")
+
+	return stakeCmd
+}
+
+// stakeBlock returns the block to take the distribution at
+func (c *BlockCommandContext) stakeBlock(cycle int) (string, int, error) {
+	var cur currentLevel
+	if err := c.getRPC(c.blockPath(c.blockID)+"/helpers/current_level", &cur); err != nil {
+		return "", 0, err
+	}
+
+	if cycle < 0 || cycle == cur.Cycle {
+		return c.blockID, cur.Cycle, nil
+	}
+	if cycle > cur.Cycle {
+		return "", 0, fmt.Errorf("Cycle %d hasn't started yet, current cycle is %d", cycle, cur.Cycle)
+	}
+
+	var lv cycleLevels
+	if err := c.getRPC(fmt.Sprintf("%s/helpers/levels_in_current_cycle?offset=%d", c.blockPath(c.blockID), cycle-cur.Cycle), &lv); err != nil {
+		return "", 0, err
+	}
+	return strconv.Itoa(lv.Last), cycle, nil
+}
+
+// getStakingBalances fetches staking balances of all active delegates concurrently
+func (c *BlockCommandContext) getStakingBalances(blockID string, opt *crawlOptions) ([]*delegateStake, error) {
+	var delegates []string
+	if err := c.getRPC(c.blockPath(blockID)+"/context/delegates?active=true", &delegates); err != nil {
+		return nil, err
+	}
+
+	fetch := func(ctx context.Context, pkh string) (interface{}, error) {
+		var balance tezos.BigInt
+		if err := c.getRPC(c.blockPath(blockID)+"/context/delegates/"+pkh+"/staking_balance", &balance); err != nil {
+			return nil, crawlError(err)
+		}
+		return &balance.Int, nil
+	}
+
+	res := make([]*delegateStake, 0, len(delegates))
+	err := c.newCrawler(opt, "delegates").Run(c.context, delegates, fetch, func(i int, pkh string, v interface{}) error {
+		res = append(res, &delegateStake{
+			Delegate:            pkh,
+			StakingBalanceMutez: v.(*big.Int),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// gini returns the Gini coefficient of the values sorted in descending order
+func gini(values []*big.Int, total *big.Int) float64 {
+	n := len(values)
+	if n == 0 || total.Sign() == 0 {
+		return 0
+	}
+
+	// G = 2*sum(i*x_i)/(n*sum(x)) - (n+1)/n with x sorted ascending and i starting from 1
+	var sum, tmp big.Int
+	for i, v := range values {
+		tmp.Mul(big.NewInt(int64(n-i)), v)
+		sum.Add(&sum, &tmp)
+	}
+
+	var num, den big.Float
+	num.SetInt(&sum)
+	num.Mul(&num, big.NewFloat(2))
+	den.SetInt(total)
+	den.Mul(&den, big.NewFloat(float64(n)))
+	g, _ := num.Quo(&num, &den).Float64()
+	return g - float64(n+1)/float64(n)
+}
+
+func share(v, total *big.Int) float64 {
+	if total.Sign() == 0 {
+		return 0
+	}
+	var x, y big.Float
+	x.SetInt(v)
+	y.SetInt(total)
+	f, _ := x.Quo(&x, &y).Float64()
+	return f
+}
+
+func (c *BlockCommandContext) stakeDistribution(opt *stakeOptions) (*stakeDistribution, error) {
+	blockID, cycle, err := c.stakeBlock(opt.cycle)
+	if err != nil {
+		return nil, err
+	}
+
+	var constants stakeConstants
+	if err := c.getRPC(c.blockPath(blockID)+"/context/constants", &constants); err != nil {
+		return nil, err
+	}
+	var rollSize *big.Int
+	if constants.TokensPerRoll != nil {
+		rollSize = &constants.TokensPerRoll.Int
+	} else if constants.MinimalStake != nil {
+		rollSize = &constants.MinimalStake.Int
+	}
+
+	stakes, err := c.getStakingBalances(blockID, &opt.crawl)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(stakes, func(i, j int) bool {
+		if r := stakes[i].StakingBalanceMutez.Cmp(stakes[j].StakingBalanceMutez); r != 0 {
+			return r > 0
+		}
+		return stakes[i].Delegate < stakes[j].Delegate
+	})
+
+	d := stakeDistribution{
+		Block:         blockID,
+		Cycle:         cycle,
+		DelegatesNum:  len(stakes),
+		TotalMutez:    big.NewInt(0),
+		RollSizeMutez: rollSize,
+		TopN:          opt.top,
+	}
+
+	values := make([]*big.Int, len(stakes))
+	for i, s := range stakes {
+		values[i] = s.StakingBalanceMutez
+		d.TotalMutez.Add(d.TotalMutez, s.StakingBalanceMutez)
+	}
+	d.Total = c.amountFormat.Tez(d.TotalMutez)
+	d.Gini = gini(values, d.TotalMutez)
+
+	topSum := big.NewInt(0)
+	for i, s := range stakes {
+		s.Rank = i + 1
+		s.StakingBalance = c.amountFormat.Tez(s.StakingBalanceMutez)
+		s.Share = share(s.StakingBalanceMutez, d.TotalMutez)
+		if rollSize != nil && rollSize.Sign() > 0 {
+			s.Rolls = new(big.Int).Quo(s.StakingBalanceMutez, rollSize)
+		}
+		if i < opt.top {
+			topSum.Add(topSum, s.StakingBalanceMutez)
+		}
+	}
+	d.TopNConcentration = share(topSum, d.TotalMutez)
+
+	if opt.limit > 0 && len(stakes) > opt.limit {
+		stakes = stakes[:opt.limit]
+	}
+	d.Delegates = stakes
+
+	log.WithFields(log.Fields{
+		"block":     blockID,
+		"delegates": d.DelegatesNum,
+	}).Debug("Stake distribution collected")
+
+	return &d, nil
+}
+
+func (c *BlockCommandContext) showStake(opt *stakeOptions) error {
+	var newEncoder utils.NewEncoderFunc
+	switch opt.format {
+	case "text", "csv":
+	default:
+		if newEncoder = c.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+
+	d, err := c.stakeDistribution(opt)
+	if err != nil {
+		return err
+	}
+
+	switch opt.format {
+	case "text":
+		if !c.wide {
+			c.maxWidth = utils.TerminalWidth(os.Stdout)
+		}
+		return c.writeStake(os.Stdout, d)
+	case "csv":
+		return writeStakeCSV(os.Stdout, d)
+	case "markdown", "md", "html":
+		// Tables hold the list only
+		enc := newEncoder(os.Stdout)
+		err := enc.Encode(d.Delegates)
+		closeEncoder(enc, &err)
+		return err
+	default:
+		return newEncoder(os.Stdout).Encode(d)
+	}
+}
+
+func (c *BlockCommandContext) writeStake(w io.Writer, d *stakeDistribution) error {
+	_, err := fmt.Fprintf(w, "Block:         %s\nCycle:         %d\nDelegates:     %d\nTotal stake:   %s\nGini:          %.4f\nTop %-3d share: %.2f%%\n\n",
+		d.Block, d.Cycle, d.DelegatesNum, c.amountFormat.Format(d.TotalMutez), d.Gini, d.TopN, d.TopNConcentration*100)
+	if err != nil {
+		return err
+	}
+
+	table := utils.NewTable(w, stakeColumns, c.maxWidth)
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, s := range d.Delegates {
+		var rolls string
+		if s.Rolls != nil {
+			rolls = s.Rolls.String()
+		}
+		err := table.WriteRow(
+			strconv.Itoa(s.Rank),
+			s.Delegate,
+			c.amountFormat.Format(s.StakingBalanceMutez),
+			rolls,
+			fmt.Sprintf("%.2f%%", s.Share*100),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSV holds one row per delegate, summary figures can be derived from them
+func writeStakeCSV(w io.Writer, d *stakeDistribution) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"rank", "delegate", "staking_balance_mutez", "rolls", "share"}); err != nil {
+		return err
+	}
+	for _, s := range d.Delegates {
+		var rolls string
+		if s.Rolls != nil {
+			rolls = s.Rolls.String()
+		}
+		err := cw.Write([]string{
+			strconv.Itoa(s.Rank),
+			s.Delegate,
+			s.StakingBalanceMutez.String(),
+			rolls,
+			strconv.FormatFloat(s.Share, 'f', -1, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,106 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/internal/atomicfile"
+)
+
+// watchState is the last block fully processed in watch mode
+type watchState struct {
+	path string
+
+	Level     int       `json:"level"`
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+	Updated   time.Time `json:"updated"`
+}
+
+// watchState returns the --resume-from-state checkpoint. Without the flag the state is kept in memory only
+func (c *BlockCommandContext) watchState() (*watchState, error) {
+	if c.statePath == "" {
+		return &watchState{}, nil
+	}
+	if !c.watch {
+		return nil, errors.New("--resume-from-state requires --watch")
+	}
+	return loadWatchState(c.statePath)
+}
+
+// loadWatchState reads the state file. Empty state is returned if the file doesn't exist yet
+func loadWatchState(path string) (*watchState, error) {
+	path, err := utils.ExpandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := watchState{path: path}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &s, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// last returns the stored block or nil
+func (s *watchState) last() *tezos.BlockInfo {
+	if s.Hash == "" {
+		return nil
+	}
+	return &tezos.BlockInfo{
+		Hash:      s.Hash,
+		Level:     s.Level,
+		Timestamp: s.Timestamp,
+	}
+}
+
+// save atomically replaces the state file
+func (s *watchState) save(bi *tezos.BlockInfo) error {
+	s.Level = bi.Level
+	s.Hash = bi.Hash
+	s.Timestamp = bi.Timestamp
+	s.Updated = time.Now().UTC()
+
+	if s.path == "" {
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.path, buf, 0600)
+}
@@ -0,0 +1,328 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Leaderboard ranking keys
+const (
+	rankByVolume = "volume"
+	rankByFees   = "fees"
+	rankByCount  = "count"
+)
+
+// Leaderboard subjects
+const (
+	rankSources      = "sources"
+	rankDestinations = "destinations"
+	rankBakers       = "bakers"
+)
+
+// Columns of the leaderboard table
+var leaderboardColumns = []utils.TableColumn{
+	{Header: "RANK", Width: 5, Align: utils.AlignRight},
+	{Header: "ADDRESS", Width: 36, MinWidth: 13},
+	{Header: "COUNT", Width: 8, Align: utils.AlignRight},
+	{Header: "VOLUME", Width: 22, Align: utils.AlignRight},
+	{Header: "FEES", Width: 16, Align: utils.AlignRight},
+}
+
+type topOptions struct {
+	last   int
+	by     string
+	of     string
+	limit  int
+	crawl  crawlOptions
+	format string
+}
+
+type leaderboardEntry struct {
+	Rank        int        `json:"rank" yaml:"rank"`
+	Address     string     `json:"address" yaml:"address"`
+	Count       int        `json:"count" yaml:"count"`
+	VolumeMutez *big.Int   `json:"volume_mutez" yaml:"volume_mutez"`
+	FeesMutez   *big.Int   `json:"fees_mutez" yaml:"fees_mutez"`
+	Volume      *big.Float `json:"volume" yaml:"volume"`
+	Fees        *big.Float `json:"fees" yaml:"fees"`
+}
+
+type leaderboard struct {
+	entries map[string]*leaderboardEntry
+}
+
+func (l *leaderboard) add(address string, amount, fee *big.Int) {
+	if address == "" {
+		return
+	}
+	e, ok := l.entries[address]
+	if !ok {
+		e = &leaderboardEntry{
+			Address:     address,
+			VolumeMutez: big.NewInt(0),
+			FeesMutez:   big.NewInt(0),
+		}
+		l.entries[address] = e
+	}
+	e.Count++
+	if amount != nil {
+		e.VolumeMutez.Add(e.VolumeMutez, amount)
+	}
+	if fee != nil {
+		e.FeesMutez.Add(e.FeesMutez, fee)
+	}
+}
+
+// ranked returns top entries sorted by the key. Ties are broken by the address to keep the output stable
+func (l *leaderboard) ranked(by string, limit int, af *utils.AmountFormat) []*leaderboardEntry {
+	res := make([]*leaderboardEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		res = append(res, e)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		var c int
+		switch by {
+		case rankByVolume:
+			c = res[i].VolumeMutez.Cmp(res[j].VolumeMutez)
+		case rankByFees:
+			c = res[i].FeesMutez.Cmp(res[j].FeesMutez)
+		default:
+			c = res[i].Count - res[j].Count
+		}
+		if c != 0 {
+			return c > 0
+		}
+		return res[i].Address < res[j].Address
+	})
+
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	for i, e := range res {
+		e.Rank = i + 1
+		e.Volume = af.Tez(e.VolumeMutez)
+		e.Fees = af.Tez(e.FeesMutez)
+	}
+	return res
+}
+
+// NewStatsCommand returns new `stats' command
+func NewStatsCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		top      topOptions
+		statsCmd *cobra.Command // Forward declaration, see PersistentPreRunE below
+	)
+
+	ctx := BlockCommandContext{
+		RootContext: rootCtx,
+	}
+
+	statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Chain statistics",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if p := statsCmd.Parent(); p != nil {
+				if pr := p.PersistentPreRunE; pr != nil {
+					return pr(cmd, args)
+				}
+			}
+			return nil
+		},
+	}
+
+	topCmd := &cobra.Command{
+		Use:   "top",
+		Short: "Rank addresses or bakers by volume, fees or number of operations",
+		Long: `Rank addresses or bakers by volume, fees or number of operations over the last N blocks.
+Sources are ranked by the operations they sent, destinations by the transfers they received and bakers by the blocks they produced.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.showTop(&top)
+		},
+	}
+
+	f := topCmd.Flags()
+	f.IntVar(&top.last, "last", 1000, "Number of blocks up to the head (or --block) to scan")
+	f.StringVar(&top.by, "by", rankByVolume, "Ranking key: one of [volume, fees, count]")
+	f.StringVar(&top.of, "of", rankSources, "What to rank: one of [sources, destinations, bakers]")
+	f.IntVar(&top.limit, "limit", 20, "Number of entries to show (0 means all)")
+	f.StringVarP(&top.format, "output-encoding", "o", "text", "Output encoding: one of [text, csv, yaml, json, markdown, html]")
+	f.BoolVar(&ctx.wide, "wide", false, "Don't truncate table columns to fit the terminal")
+	addCrawlFlags(topCmd, &top.crawl, 8, "blocks")
+
+	statsCmd.AddCommand(topCmd)
+	statsCmd.AddCommand(newStakeCommand(&ctx))
+	statsCmd.AddCommand(newFeesCommand(&ctx))
+	statsCmd.AddCommand(newParticipationCommand(&ctx))
+
+	return statsCmd
+}
+
+// windowArgs returns queries for the last n blocks ending at the --block
+func (c *BlockCommandContext) windowArgs(n int) ([]string, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("Invalid number of blocks: %d", n)
+	}
+
+	bt, err := c.getBlockTime(c.blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	from := bt.Level - n + 1
+	if from < 1 {
+		from = 1
+	}
+
+	args := make([]string, 0, bt.Level-from+1)
+	for level := from; level <= bt.Level; level++ {
+		args = append(args, strconv.Itoa(level))
+	}
+	return args, nil
+}
+
+func (c *BlockCommandContext) showTop(opt *topOptions) error {
+	switch opt.by {
+	case rankByVolume, rankByFees, rankByCount:
+	default:
+		return fmt.Errorf("Unknown ranking key: `%s'", opt.by)
+	}
+
+	var add func(l *leaderboard, block *xblock)
+	switch opt.of {
+	case rankSources:
+		add = func(l *leaderboard, block *xblock) {
+			for _, op := range getBlockOperations(getBlockInfo(block), nil) {
+				l.add(op.Source, op.AmountMutez, op.FeeMutez)
+			}
+		}
+	case rankDestinations:
+		add = func(l *leaderboard, block *xblock) {
+			for _, op := range getBlockOperations(getBlockInfo(block), nil) {
+				if op.Destination != "" {
+					l.add(op.Destination, op.AmountMutez, op.FeeMutez)
+				}
+			}
+		}
+	case rankBakers:
+		add = func(l *leaderboard, block *xblock) {
+			bi := getBlockInfo(block)
+			l.add(block.Metadata.Baker, bi.VolumeMutez, bi.FeesMutez)
+		}
+	default:
+		return fmt.Errorf("Unknown leaderboard subject: `%s'", opt.of)
+	}
+
+	var newEncoder utils.NewEncoderFunc
+	switch opt.format {
+	case "text", "csv":
+	default:
+		if newEncoder = c.encoderFunc(opt.format); newEncoder == nil || utils.IsBinary(opt.format) {
+			return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+		}
+	}
+
+	args, err := c.windowArgs(opt.last)
+	if err != nil {
+		return err
+	}
+
+	l := leaderboard{entries: make(map[string]*leaderboardEntry)}
+	if err := c.scanBlocks(args, &opt.crawl, func(block *xblock) { add(&l, block) }); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"blocks":  len(args),
+		"entries": len(l.entries),
+	}).Debug("Leaderboard collected")
+
+	entries := l.ranked(opt.by, opt.limit, c.amountFormat)
+
+	switch opt.format {
+	case "text":
+		if !c.wide {
+			c.maxWidth = utils.TerminalWidth(os.Stdout)
+		}
+		return c.writeLeaderboard(os.Stdout, entries)
+	case "csv":
+		return writeLeaderboardCSV(os.Stdout, entries)
+	default:
+		enc := newEncoder(os.Stdout)
+		err := enc.Encode(entries)
+		closeEncoder(enc, &err)
+		return err
+	}
+}
+
+func (c *BlockCommandContext) writeLeaderboard(w io.Writer, entries []*leaderboardEntry) error {
+	table := utils.NewTable(w, leaderboardColumns, c.maxWidth)
+	if err := table.WriteHeader(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err := table.WriteRow(
+			strconv.Itoa(e.Rank),
+			e.Address,
+			strconv.Itoa(e.Count),
+			c.amountFormat.Format(e.VolumeMutez),
+			c.amountFormat.Format(e.FeesMutez),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSV holds exact amounts in mutez so it can be imported without precision loss
+func writeLeaderboardCSV(w io.Writer, entries []*leaderboardEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"rank", "address", "count", "volume_mutez", "fees_mutez"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err := cw.Write([]string{
+			strconv.Itoa(e.Rank),
+			e.Address,
+			strconv.Itoa(e.Count),
+			e.VolumeMutez.String(),
+			e.FeesMutez.String(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,331 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/michelson"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type storageDiffOptions struct {
+	maxBlocks int
+	format    string
+	crawl     crawlOptions
+}
+
+type diffBlock struct {
+	Hash  string `json:"hash" yaml:"hash"`
+	Level int    `json:"level" yaml:"level"`
+}
+
+type storageDiff struct {
+	Contract string              `json:"contract" yaml:"contract"`
+	From     *diffBlock          `json:"from" yaml:"from"`
+	To       *diffBlock          `json:"to" yaml:"to"`
+	Changes  []*michelson.Change `json:"changes" yaml:"changes"`
+	// Set if big map contents weren't compared because of --max-blocks
+	BigMapsSkipped bool `json:"big_maps_skipped,omitempty" yaml:"big_maps_skipped,omitempty"`
+}
+
+// lazyStorageDiff is a big map update found in operation receipts
+type lazyStorageDiff struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	Diff struct {
+		Action  string `json:"action"`
+		Updates []struct {
+			KeyHash string          `json:"key_hash"`
+			Key     *michelson.Node `json:"key"`
+		} `json:"updates"`
+	} `json:"diff"`
+}
+
+type lazyStorageResult struct {
+	LazyStorageDiff []*lazyStorageDiff `json:"lazy_storage_diff"`
+}
+
+type lazyStorageOperation struct {
+	Contents []struct {
+		Metadata struct {
+			OperationResult          *lazyStorageResult `json:"operation_result"`
+			InternalOperationResults []struct {
+				Result *lazyStorageResult `json:"result"`
+			} `json:"internal_operation_results"`
+		} `json:"metadata"`
+	} `json:"contents"`
+}
+
+func newContractStorageDiffCommand(ctx *RootContext) *cobra.Command {
+	var opt storageDiffOptions
+
+	cmd := &cobra.Command{
+		Use:   "storage-diff <KT1...|alias> <block> <block>",
+		Short: "Compare the contract's storage at two blocks",
+		Long: `Print a structural diff of the contract's storage between two blocks given as hashes, levels or head~N.
+Paths are made of field annotations (or indices of unannotated fields), list indices and map keys.
+Big map keys updated by operations between the blocks are compared too, provided there are at most --max-blocks of them
+as their receipts have to be scanned to learn the keys.`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var newEnc utils.NewEncoderFunc
+			if opt.format != "text" {
+				if newEnc = ctx.encoderFunc(opt.format); newEnc == nil || utils.IsBinary(opt.format) || utils.IsTabular(opt.format) {
+					return fmt.Errorf("Unknown output encoding: `%s'", opt.format)
+				}
+			}
+			address, err := ctx.contractAddress(args[0])
+			if err != nil {
+				return err
+			}
+			d, err := ctx.storageDiff(address, args[1], args[2], &opt)
+			if err != nil {
+				return err
+			}
+			if newEnc != nil {
+				return newEnc(os.Stdout).Encode(d)
+			}
+			return ctx.writeStorageDiff(os.Stdout, d)
+		},
+	}
+
+	f := cmd.Flags()
+	f.IntVar(&opt.maxBlocks, "max-blocks", 100, "Maximum number of blocks scanned for big map updates (0 disables big map comparison)")
+	f.StringVarP(&opt.format, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	addCrawlFlags(cmd, &opt.crawl, 8, "blocks")
+
+	return cmd
+}
+
+func (c *RootContext) diffBlock(blockID string) (*diffBlock, error) {
+	var b diffBlock
+	if err := c.getRPC(c.blockPath(blockID)+"/header", &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (c *RootContext) storageDiff(address, fromID, toID string, opt *storageDiffOptions) (*storageDiff, error) {
+	d := storageDiff{Contract: address}
+	var err error
+	if d.From, err = c.diffBlock(fromID); err != nil {
+		return nil, err
+	}
+	if d.To, err = c.diffBlock(toID); err != nil {
+		return nil, err
+	}
+
+	fromScript, err := c.contractScriptAt(address, d.From.Hash)
+	if err != nil {
+		return nil, err
+	}
+	toScript, err := c.contractScriptAt(address, d.To.Hash)
+	if err != nil {
+		return nil, err
+	}
+	typ := toScript.section("storage")
+	if typ == nil {
+		return nil, fmt.Errorf("Contract %s has no storage type", address)
+	}
+	from, to := fromScript.Storage, toScript.Storage
+
+	if d.Changes, err = michelson.Diff(from, to, typ, "storage"); err != nil {
+		return nil, err
+	}
+
+	// Big maps kept at the same path with the same ID
+	fromMaps, err := michelson.BigMaps(from, typ, "storage")
+	if err != nil {
+		return nil, err
+	}
+	toMaps, err := michelson.BigMaps(to, typ, "storage")
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(fromMaps))
+	for _, m := range fromMaps {
+		ids[m.Path+"#"+m.ID] = true
+	}
+	var maps []*michelson.BigMapRef
+	for _, m := range toMaps {
+		if ids[m.Path+"#"+m.ID] {
+			maps = append(maps, m)
+		}
+	}
+	if len(maps) == 0 || d.From.Level == d.To.Level {
+		return &d, nil
+	}
+
+	lo, hi := d.From.Level, d.To.Level
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	if hi-lo > opt.maxBlocks {
+		log.WithFields(log.Fields{
+			"blocks":     hi - lo,
+			"max_blocks": opt.maxBlocks,
+		}).Warn("Big map contents aren't compared, too many blocks in between")
+		d.BigMapsSkipped = true
+		return &d, nil
+	}
+
+	changes, err := c.bigMapChanges(maps, lo, hi, &d, opt)
+	if err != nil {
+		return nil, err
+	}
+	d.Changes = append(d.Changes, changes...)
+	return &d, nil
+}
+
+// bigMapChanges compares values of big map keys updated in the blocks after lo up to hi
+func (c *RootContext) bigMapChanges(maps []*michelson.BigMapRef, lo, hi int, d *storageDiff, opt *storageDiffOptions) ([]*michelson.Change, error) {
+	wanted := make(map[string]bool, len(maps))
+	for _, m := range maps {
+		wanted[m.ID] = true
+	}
+
+	levels := make([]string, 0, hi-lo)
+	for level := lo + 1; level <= hi; level++ {
+		levels = append(levels, strconv.Itoa(level))
+	}
+
+	fetch := func(ctx context.Context, query string) (interface{}, error) {
+		var ops []*lazyStorageOperation
+		if err := c.getRPC(fmt.Sprintf("%s/operations/%d", c.blockPath(query), managerPass), &ops); err != nil {
+			return nil, crawlError(err)
+		}
+		var diffs []*lazyStorageDiff
+		for _, op := range ops {
+			for _, el := range op.Contents {
+				if r := el.Metadata.OperationResult; r != nil {
+					diffs = append(diffs, r.LazyStorageDiff...)
+				}
+				for _, ir := range el.Metadata.InternalOperationResults {
+					if ir.Result != nil {
+						diffs = append(diffs, ir.Result.LazyStorageDiff...)
+					}
+				}
+			}
+		}
+		return diffs, nil
+	}
+
+	// Updated keys by big map ID and key hash
+	keys := make(map[string]map[string]*michelson.Node)
+	err := c.newCrawler(&opt.crawl, "blocks").Run(c.context, levels, fetch, func(i int, query string, v interface{}) error {
+		for _, ld := range v.([]*lazyStorageDiff) {
+			if ld.Kind != "big_map" || !wanted[ld.ID] {
+				continue
+			}
+			if keys[ld.ID] == nil {
+				keys[ld.ID] = make(map[string]*michelson.Node)
+			}
+			for _, u := range ld.Diff.Updates {
+				keys[ld.ID][u.KeyHash] = u.Key
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []*michelson.Change
+	for _, m := range maps {
+		hashes := make([]string, 0, len(keys[m.ID]))
+		for h := range keys[m.ID] {
+			hashes = append(hashes, h)
+		}
+		sort.Strings(hashes)
+
+		log.WithFields(log.Fields{
+			"big_map": m.ID,
+			"keys":    len(hashes),
+		}).Debug("Comparing big map keys")
+
+		for _, h := range hashes {
+			var values [2]*michelson.Node
+			for i, block := range []string{d.From.Hash, d.To.Hash} {
+				var v michelson.Node
+				if err := c.getRPC(c.blockPath(block)+"/context/big_maps/"+m.ID+"/"+h, &v); err != nil {
+					if isNotFound(err) {
+						continue
+					}
+					return nil, err
+				}
+				values[i] = &v
+			}
+			if values[0] == nil && values[1] == nil || values[0] != nil && values[1] != nil && values[0].String() == values[1].String() {
+				continue
+			}
+
+			path := m.Path + "[" + h + "]"
+			if key := keys[m.ID][h]; key != nil {
+				if k, err := michelson.Readable(key, m.KeyType); err == nil {
+					path = m.Path + "[" + k.String() + "]"
+				}
+			}
+			ch, err := michelson.NewChange(path, values[0], values[1], m.ValueType)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, ch)
+		}
+	}
+	return changes, nil
+}
+
+func (c *RootContext) writeStorageDiff(w io.Writer, d *storageDiff) error {
+	_, err := fmt.Fprintf(w, "Contract:  %s\nFrom:      %d %s\nTo:        %d %s\n\n", d.Contract, d.From.Level, d.From.Hash, d.To.Level, d.To.Hash)
+	if err != nil {
+		return err
+	}
+	if len(d.Changes) == 0 {
+		_, err := fmt.Fprintln(w, "No changes")
+		return err
+	}
+	for _, ch := range d.Changes {
+		var line string
+		switch ch.Kind {
+		case michelson.ChangeAdded:
+			line = c.colorizer.Green(fmt.Sprintf("+ %s: %v", ch.Path, ch.New)).String()
+		case michelson.ChangeRemoved:
+			line = c.colorizer.Red(fmt.Sprintf("- %s: %v", ch.Path, ch.Old)).String()
+		default:
+			line = c.colorizer.Yellow(fmt.Sprintf("~ %s: %v -> %v", ch.Path, ch.Old, ch.New)).String()
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	if d.BigMapsSkipped {
+		_, err = fmt.Fprintln(w, "\nBig map contents weren't compared, see --max-blocks")
+	}
+	return err
+}
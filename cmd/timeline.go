@@ -0,0 +1,97 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type blockTime struct {
+	Level     int       `json:"level"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (c *RootContext) getBlockTime(blockID string) (*blockTime, error) {
+	var h blockTime
+	if err := c.getRPC(c.blockPath(blockID)+"/header", &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// blockLevelAt returns the level of the last block produced at or before t. Block times differ between protocols
+// so the search interpolates using the actual rate within the current interval and falls back to bisection
+func (c *RootContext) blockLevelAt(t time.Time) (int, error) {
+	hi, err := c.getBlockTime("head")
+	if err != nil {
+		return 0, err
+	}
+	if !t.Before(hi.Timestamp) {
+		return hi.Level, nil
+	}
+
+	lo, err := c.getBlockTime("1")
+	if err != nil {
+		return 0, err
+	}
+	if t.Before(lo.Timestamp) {
+		return 0, fmt.Errorf("No blocks before %s", t.Format(time.RFC3339))
+	}
+
+	// Invariant: lo.Timestamp <= t < hi.Timestamp
+	for i := 0; hi.Level-lo.Level > 1; i++ {
+		var level int
+		if i%2 == 0 && hi.Timestamp.After(lo.Timestamp) {
+			frac := float64(t.Sub(lo.Timestamp)) / float64(hi.Timestamp.Sub(lo.Timestamp))
+			level = lo.Level + int(frac*float64(hi.Level-lo.Level))
+		} else {
+			level = lo.Level + (hi.Level-lo.Level)/2
+		}
+
+		if level <= lo.Level {
+			level = lo.Level + 1
+		} else if level >= hi.Level {
+			level = hi.Level - 1
+		}
+
+		bt, err := c.getBlockTime(strconv.Itoa(level))
+		if err != nil {
+			return 0, err
+		}
+
+		log.WithFields(log.Fields{
+			"level":     bt.Level,
+			"timestamp": bt.Timestamp,
+		}).Debug("Block time lookup")
+
+		if bt.Timestamp.After(t) {
+			hi = bt
+		} else {
+			lo = bt
+		}
+	}
+
+	return lo.Level, nil
+}
@@ -0,0 +1,250 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/michelson"
+	"github.com/ecadlabs/tez/timelock"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// timelockResult is the output of `timelock create' and `timelock open'
+type timelockResult struct {
+	Time     int    `json:"time" yaml:"time"`
+	Chest    string `json:"chest,omitempty" yaml:"chest,omitempty"`
+	ChestKey string `json:"chest_key" yaml:"chest_key"`
+	Payload  string `json:"payload,omitempty" yaml:"payload,omitempty"`
+}
+
+// NewTimelockCommand returns new `timelock' command
+func NewTimelockCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		time         int
+		hexPayload   bool
+		keySrc       string
+		createCheck  bool
+		openCheck    bool
+		outputFormat string
+	)
+
+	timelockCmd := &cobra.Command{
+		Use:   "timelock",
+		Short: "Create and open timelock chests",
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create <payload|-|@file>",
+		Short: "Lock the payload in a chest",
+		Long: `Lock the payload in a chest which can be opened after --time sequential squarings, and print the chest and the chest key as hex bytes.
+Creating a chest takes about as long as opening it. The chest is checked by running OPEN_CHEST on the node unless --node-check=false is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			payload, err := readPayload(args[0], hexPayload)
+			if err != nil {
+				return err
+			}
+			chest, key, err := timelock.Create(payload, time)
+			if err != nil {
+				return err
+			}
+			chestBytes, _ := chest.MarshalBinary()
+			keyBytes, _ := key.MarshalBinary()
+
+			if createCheck {
+				out, err := rootCtx.openChestOnChain(chestBytes, keyBytes, time)
+				if err != nil {
+					return err
+				}
+				if string(out) != string(payload) {
+					return fmt.Errorf("The node opened the chest with a different payload")
+				}
+				log.Info("Chest is verified by the node")
+			}
+
+			return writeTimelockResult(outputFormat, &timelockResult{
+				Time:     time,
+				Chest:    hex.EncodeToString(chestBytes),
+				ChestKey: hex.EncodeToString(keyBytes),
+			})
+		},
+	}
+
+	openCmd := &cobra.Command{
+		Use:   "open <chest|-|@file>",
+		Short: "Open the chest",
+		Long: `Open the chest given as hex bytes and print the chest key and the payload.
+Without --key the key is computed, which takes --time sequential squarings. With --node-check the opening is also verified by running OPEN_CHEST on the node.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chestBytes, err := readHexInput(args[0])
+			if err != nil {
+				return err
+			}
+			var chest timelock.Chest
+			if err := chest.UnmarshalBinary(chestBytes); err != nil {
+				return err
+			}
+
+			var key *timelock.Key
+			if keySrc != "" {
+				data, err := readHexInput(keySrc)
+				if err != nil {
+					return err
+				}
+				key = new(timelock.Key)
+				if err := key.UnmarshalBinary(data); err != nil {
+					return err
+				}
+			} else {
+				if time <= 0 {
+					return fmt.Errorf("Time must be positive")
+				}
+				key = chest.Open(time)
+			}
+			keyBytes, _ := key.MarshalBinary()
+
+			payload, err := chest.Decrypt(key, time)
+			if err != nil {
+				return err
+			}
+			if openCheck {
+				out, err := rootCtx.openChestOnChain(chestBytes, keyBytes, time)
+				if err != nil {
+					return err
+				}
+				if string(out) != string(payload) {
+					return fmt.Errorf("The node opened the chest with a different payload")
+				}
+				log.Info("Opening is verified by the node")
+			}
+
+			res := timelockResult{
+				Time:     time,
+				ChestKey: hex.EncodeToString(keyBytes),
+				Payload:  formatPayload(payload, hexPayload),
+			}
+			return writeTimelockResult(outputFormat, &res)
+		},
+	}
+
+	timelockCmd.PersistentFlags().IntVar(&time, "time", 0, "Number of sequential squarings needed to open the chest")
+	timelockCmd.MarkPersistentFlagRequired("time")
+	timelockCmd.PersistentFlags().BoolVar(&hexPayload, "hex", false, "Payload is hex encoded bytes instead of text")
+	timelockCmd.PersistentFlags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+	createCmd.Flags().BoolVar(&createCheck, "node-check", true, "Verify the chest with the node's OPEN_CHEST")
+	openCmd.Flags().BoolVar(&openCheck, "node-check", false, "Verify the opening with the node's OPEN_CHEST")
+	openCmd.Flags().StringVar(&keySrc, "key", "", "Chest key as hex bytes; - reads it from stdin and @path from the file")
+
+	timelockCmd.AddCommand(createCmd)
+	timelockCmd.AddCommand(openCmd)
+
+	return timelockCmd
+}
+
+func readHexInput(src string) ([]byte, error) {
+	s, err := utils.ReadInputString(src)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+}
+
+func readPayload(src string, isHex bool) ([]byte, error) {
+	if isHex {
+		return readHexInput(src)
+	}
+	return utils.ReadInput(src)
+}
+
+// formatPayload returns the payload as text if it's printable and hex encoded otherwise
+func formatPayload(payload []byte, isHex bool) string {
+	if !isHex && utf8.Valid(payload) && !strings.ContainsAny(string(payload), "\x00") {
+		return string(payload)
+	}
+	return "0x" + hex.EncodeToString(payload)
+}
+
+func writeTimelockResult(outputFormat string, res *timelockResult) error {
+	if outputFormat != "text" {
+		newEnc := utils.GetEncoderFunc(outputFormat)
+		if newEnc == nil {
+			return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+		}
+		return newEnc(os.Stdout).Encode(res)
+	}
+	if res.Chest != "" {
+		fmt.Printf("Chest:     0x%s\n", res.Chest)
+	}
+	fmt.Printf("Chest key: 0x%s\n", res.ChestKey)
+	if res.Payload != "" {
+		fmt.Printf("Payload:   %s\n", res.Payload)
+	}
+	return nil
+}
+
+// openChestOnChain runs OPEN_CHEST on the node and returns the payload
+func (c *RootContext) openChestOnChain(chest, key []byte, time int) ([]byte, error) {
+	prim := michelson.NewPrim
+	script := michelson.NewSeq(
+		prim("parameter", prim("pair", prim("chest_key"), prim("pair", prim("chest"), prim("nat")))),
+		prim("storage", prim("option", prim("bytes"))),
+		prim("code", michelson.NewSeq(
+			prim("CAR"),
+			prim("UNPAIR"),
+			prim("DIP", michelson.NewSeq(prim("UNPAIR"))),
+			prim("OPEN_CHEST"),
+			prim("NIL", prim("operation")),
+			prim("PAIR"),
+		)),
+	)
+	input := prim("Pair", michelson.NewBytes(key), prim("Pair", michelson.NewBytes(chest), michelson.NewInt(big.NewInt(int64(time)))))
+
+	chainID, err := c.viewChainID()
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]interface{}{
+		"script":   script,
+		"storage":  prim("None"),
+		"input":    input,
+		"amount":   "0",
+		"chain_id": chainID,
+	}
+	var res struct {
+		Storage *michelson.Node `json:"storage"`
+	}
+	if err := c.postRPC(c.blockPath("head")+"/helpers/scripts/run_code", req, &res); err != nil {
+		return nil, err
+	}
+	if res.Storage == nil || !res.Storage.IsPrim("Some") || len(res.Storage.Args) != 1 || res.Storage.Args[0].Kind != michelson.KindBytes {
+		return nil, fmt.Errorf("The node couldn't open the chest: %v", res.Storage)
+	}
+	return res.Storage.Args[0].Bytes, nil
+}
@@ -0,0 +1,284 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ecadlabs/tez/protocol"
+)
+
+// Trace directions
+const (
+	traceForward  = "forward"
+	traceBackward = "backward"
+	traceBoth     = "both"
+)
+
+type traceOptions struct {
+	address   string
+	hops      int
+	direction string
+	format    string
+	crawl     crawlOptions
+}
+
+var errDOTOperationsOnly = errors.New("DOT output is only supported by `block operations'")
+
+// Edge colors by operation kind
+var dotKindColors = map[string]string{
+	protocol.KindTransaction: "#1f77b4",
+	protocol.KindOrigination: "#2ca02c",
+	protocol.KindDelegation:  "#ff7f0e",
+}
+
+const dotDefaultColor = "#7f7f7f"
+
+type traceEdge struct {
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Kind        string     `json:"kind"`
+	AmountMutez *big.Int   `json:"amount_mutez"`
+	Amount      *big.Float `json:"amount"`
+	Hash        string     `json:"hash"`
+	Level       int        `json:"level"`
+	Hop         int        `json:"hop"`
+}
+
+type traceGraph struct {
+	Root  string       `json:"root"`
+	Nodes []string     `json:"nodes"`
+	Edges []*traceEdge `json:"edges"`
+}
+
+// scanTransfers fetches blocks concurrently and collects all transactions
+func (c *BlockCommandContext) scanTransfers(args []string, opt *crawlOptions) ([]*traceEdge, error) {
+	var edges []*traceEdge
+	err := c.scanBlocks(args, opt, func(block *xblock) {
+		for _, op := range getBlockOperations(getBlockInfo(block), map[string]struct{}{protocol.KindTransaction: {}}) {
+			if op.Source == "" || op.Destination == "" {
+				continue
+			}
+			edges = append(edges, &traceEdge{
+				From:        op.Source,
+				To:          op.Destination,
+				Kind:        op.Kind,
+				AmountMutez: op.AmountMutez,
+				Amount:      op.Amount,
+				Hash:        op.Hash,
+				Level:       block.Header.Level,
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(edges, func(i, j int) bool { return edges[i].Level < edges[j].Level })
+	return edges, nil
+}
+
+// flowGraph returns the graph of all operations having both a source and a destination
+func flowGraph(ops []*opInfo) *traceGraph {
+	var g traceGraph
+	nodes := make(map[string]struct{})
+	for _, op := range ops {
+		if op.Source == "" || op.Destination == "" {
+			continue
+		}
+		e := traceEdge{
+			From:        op.Source,
+			To:          op.Destination,
+			Kind:        op.Kind,
+			AmountMutez: op.AmountMutez,
+			Amount:      op.Amount,
+			Hash:        op.Hash,
+		}
+		if op.Block != nil {
+			e.Level = op.Block.Header.Level
+		}
+		g.Edges = append(g.Edges, &e)
+		nodes[op.Source] = struct{}{}
+		nodes[op.Destination] = struct{}{}
+	}
+	for n := range nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	sort.Strings(g.Nodes)
+	return &g
+}
+
+// traceTransfers follows transfer edges starting from the address. Funds can only move forward in time so an edge
+// is followed only if it happened after (before, for the backward direction) the moment the address was reached
+func traceTransfers(edges []*traceEdge, opt *traceOptions) *traceGraph {
+	g := traceGraph{Root: opt.address}
+	nodes := map[string]struct{}{opt.address: {}}
+	selected := make(map[*traceEdge]struct{})
+
+	follow := func(forward bool) {
+		// address -> level at which it was reached
+		reached := make(map[string]int)
+		if forward {
+			reached[opt.address] = -1
+		} else {
+			reached[opt.address] = int(^uint(0) >> 1)
+		}
+
+		for hop := 1; hop <= opt.hops; hop++ {
+			next := make(map[string]int)
+			for _, e := range edges {
+				from, to := e.From, e.To
+				if !forward {
+					from, to = to, from
+				}
+				lvl, ok := reached[from]
+				if !ok || forward && e.Level < lvl || !forward && e.Level > lvl {
+					continue
+				}
+
+				if _, ok := selected[e]; !ok {
+					e.Hop = hop
+					selected[e] = struct{}{}
+				}
+				nodes[to] = struct{}{}
+
+				if _, ok := reached[to]; ok {
+					continue
+				}
+				if l, ok := next[to]; !ok || forward && e.Level < l || !forward && e.Level > l {
+					next[to] = e.Level
+				}
+			}
+
+			if len(next) == 0 {
+				break
+			}
+			for a, l := range next {
+				reached[a] = l
+			}
+		}
+	}
+
+	if opt.direction == traceForward || opt.direction == traceBoth {
+		follow(true)
+	}
+	if opt.direction == traceBackward || opt.direction == traceBoth {
+		follow(false)
+	}
+
+	for _, e := range edges {
+		if _, ok := selected[e]; ok {
+			g.Edges = append(g.Edges, e)
+		}
+	}
+	for n := range nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	sort.Strings(g.Nodes)
+
+	return &g
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// dotPenWidth returns the edge width growing logarithmically with the amount so large transfers stand out
+// without dwarfing the rest of the graph
+func dotPenWidth(mutez *big.Int) float64 {
+	if mutez == nil || mutez.Sign() <= 0 {
+		return 1
+	}
+	tez, _ := new(big.Float).Quo(new(big.Float).SetInt(mutez), big.NewFloat(1e6)).Float64()
+	return math.Min(1+math.Log10(1+tez), 8)
+}
+
+func (c *BlockCommandContext) writeDOT(w io.Writer, g *traceGraph) error {
+	var b strings.Builder
+	b.WriteString("digraph transfers {\n")
+	if g.Root != "" {
+		fmt.Fprintf(&b, "  %s [shape=doublecircle];\n", dotQuote(g.Root))
+	}
+	for _, n := range g.Nodes {
+		if n != g.Root {
+			fmt.Fprintf(&b, "  %s;\n", dotQuote(n))
+		}
+	}
+	for _, e := range g.Edges {
+		var label string
+		if e.AmountMutez != nil {
+			label = c.amountFormat.Format(e.AmountMutez)
+		} else {
+			label = e.Kind
+		}
+		if e.Level != 0 {
+			label += fmt.Sprintf(" @%d", e.Level)
+		}
+		color, ok := dotKindColors[e.Kind]
+		if !ok {
+			color = dotDefaultColor
+		}
+		pw := dotPenWidth(e.AmountMutez)
+		fmt.Fprintf(&b, "  %s -> %s [label=%s, tooltip=%s, color=%s, fontcolor=%s, penwidth=%.2f, weight=%d];\n",
+			dotQuote(e.From), dotQuote(e.To), dotQuote(label), dotQuote(e.Hash), dotQuote(color), dotQuote(color), pw, int(pw))
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (c *BlockCommandContext) traceAddress(args []string, opt *traceOptions) error {
+	switch opt.direction {
+	case traceForward, traceBackward, traceBoth:
+	default:
+		return fmt.Errorf("Unknown trace direction: `%s'", opt.direction)
+	}
+
+	if opt.hops < 1 {
+		return fmt.Errorf("Number of hops must be positive")
+	}
+
+	edges, err := c.scanTransfers(args, &opt.crawl)
+	if err != nil {
+		return err
+	}
+
+	g := traceTransfers(edges, opt)
+	if g.Edges == nil {
+		g.Edges = []*traceEdge{}
+	}
+
+	switch opt.format {
+	case "dot":
+		return c.writeDOT(c.output, g)
+	case "json":
+		return json.NewEncoder(c.output).Encode(g)
+	}
+	return fmt.Errorf("Unknown graph format: `%s'", opt.format)
+}
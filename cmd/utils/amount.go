@@ -0,0 +1,310 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// Amount units
+const (
+	UnitTez   = "tez"
+	UnitMutez = "mutez"
+)
+
+// Rounding modes of amounts with more decimal places than the precision
+const (
+	// Round towards the nearest neighbour, ties away from zero
+	RoundHalfUp = "half-up"
+	// Round towards the nearest neighbour, ties towards zero
+	RoundHalfDown = "half-down"
+	// Round towards the nearest neighbour, ties towards the even neighbour (banker's rounding)
+	RoundHalfEven = "half-even"
+	// Round away from zero
+	RoundUp = "up"
+	// Truncate
+	RoundDown = "down"
+	// Round towards positive infinity
+	RoundCeiling = "ceiling"
+	// Round towards negative infinity
+	RoundFloor = "floor"
+)
+
+// RoundingModes lists supported rounding modes
+var RoundingModes = []string{RoundHalfUp, RoundHalfDown, RoundHalfEven, RoundUp, RoundDown, RoundCeiling, RoundFloor}
+
+var unitSymbols = map[string]string{
+	UnitTez:   "ꜩ",
+	UnitMutez: "µꜩ",
+}
+
+type numberFormat struct {
+	group   string
+	decimal string
+}
+
+// Keyed by a language part of POSIX locale name
+var localeFormats = map[string]numberFormat{
+	"C":  {"", "."},
+	"en": {",", "."},
+	"ja": {",", "."},
+	"zh": {",", "."},
+	"ko": {",", "."},
+	"de": {".", ","},
+	"es": {".", ","},
+	"it": {".", ","},
+	"nl": {".", ","},
+	"pt": {".", ","},
+	"tr": {".", ","},
+	"fr": {" ", ","},
+	"ru": {" ", ","},
+	"uk": {" ", ","},
+	"pl": {" ", ","},
+	"cs": {" ", ","},
+	"sv": {" ", ","},
+	"fi": {" ", ","},
+	"nb": {" ", ","},
+}
+
+// AmountFormat renders token amounts according to the selected unit and locale
+type AmountFormat struct {
+	Unit      string
+	Precision int
+	Rounding  string
+	format    numberFormat
+}
+
+// NewAmountFormat returns new amount formatter. Empty locale means the one taken from the environment
+func NewAmountFormat(unit string, precision int, rounding string, locale string) (*AmountFormat, error) {
+	unit = strings.ToLower(unit)
+	if _, ok := unitSymbols[unit]; !ok {
+		return nil, fmt.Errorf("Unknown unit: `%s'", unit)
+	}
+
+	if precision < 0 {
+		return nil, fmt.Errorf("Invalid precision: %d", precision)
+	}
+
+	rounding = strings.ToLower(rounding)
+	var known bool
+	for _, m := range RoundingModes {
+		known = known || m == rounding
+	}
+	if !known {
+		return nil, fmt.Errorf("Unknown rounding mode: `%s'", rounding)
+	}
+
+	if locale == "" {
+		locale = EnvLocale()
+	}
+
+	f, ok := localeFormats[localeLanguage(locale)]
+	if !ok {
+		f = localeFormats["C"]
+	}
+
+	return &AmountFormat{
+		Unit:      unit,
+		Precision: precision,
+		Rounding:  rounding,
+		format:    f,
+	}, nil
+}
+
+// EnvLocale returns the numeric locale name using POSIX rules
+func EnvLocale() string {
+	for _, env := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return "C"
+}
+
+// en_US.UTF-8@euro -> en
+func localeLanguage(locale string) string {
+	if i := strings.IndexAny(locale, "_.@-"); i >= 0 {
+		locale = locale[:i]
+	}
+	if locale == "POSIX" {
+		return "C"
+	}
+	return strings.ToLower(locale)
+}
+
+// MutezToTez converts an exact amount in mutez to tez. Supposed to be used for display purposes only
+func MutezToTez(v *big.Int) *big.Float {
+	if v == nil {
+		return nil
+	}
+	var x big.Float
+	x.SetPrec(128).SetInt(v)
+	return x.Quo(&x, big.NewFloat(1e6))
+}
+
+// Tez converts an amount in mutez to tez rounded to the precision. It's meant for encoded output, which carries
+// the exact amount in mutez alongside. Nil formatter returns the exact amount
+func (a *AmountFormat) Tez(v *big.Int) *big.Float {
+	if a == nil || v == nil {
+		return MutezToTez(v)
+	}
+	x, decimals := roundDecimal(v, 6, a.Precision, a.Rounding)
+	var scale big.Int
+	scale.Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+
+	var f, d big.Float
+	f.SetPrec(128).SetInt(x)
+	d.SetPrec(128).SetInt(&scale)
+	return f.Quo(&f, &d)
+}
+
+// Format formats an amount given in mutez
+func (a *AmountFormat) Format(v *big.Int) string {
+	return a.FormatNumber(v) + " " + unitSymbols[a.Unit]
+}
+
+// FormatNumber formats an amount given in mutez without a unit symbol
+func (a *AmountFormat) FormatNumber(v *big.Int) string {
+	if v == nil {
+		return ""
+	}
+
+	var s string
+	if a.Unit == UnitMutez {
+		s = v.String()
+	} else {
+		s = formatDecimal(v, 6, a.Precision, a.Rounding)
+	}
+
+	return a.localize(s)
+}
+
+// roundDecimal rounds the fixed point value with given number of decimal places to the precision.
+// Returns the rounded value and its number of decimal places
+func roundDecimal(v *big.Int, decimals, precision int, rounding string) (*big.Int, int) {
+	if precision >= decimals {
+		return v, decimals
+	}
+
+	var q, r, scale big.Int
+	scale.Exp(big.NewInt(10), big.NewInt(int64(decimals-precision)), nil)
+	q.QuoRem(new(big.Int).Abs(v), &scale, &r)
+
+	var away bool
+	if r.Sign() != 0 {
+		half := r.Lsh(&r, 1).Cmp(&scale)
+		switch rounding {
+		case RoundUp:
+			away = true
+		case RoundCeiling:
+			away = v.Sign() > 0
+		case RoundFloor:
+			away = v.Sign() < 0
+		case RoundHalfDown:
+			away = half > 0
+		case RoundHalfEven:
+			away = half > 0 || half == 0 && q.Bit(0) == 1
+		case RoundDown:
+		default:
+			away = half >= 0
+		}
+	}
+	if away {
+		q.Add(&q, big.NewInt(1))
+	}
+	if v.Sign() < 0 {
+		q.Neg(&q)
+	}
+	return &q, precision
+}
+
+// Format fixed point value with given number of decimal places rounded to the precision
+func formatDecimal(v *big.Int, decimals, precision int, rounding string) string {
+	x, decimals := roundDecimal(v, decimals, precision, rounding)
+
+	var abs big.Int
+	s := abs.Abs(x).String()
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+
+	intPart, frac := s[:len(s)-decimals], s[len(s)-decimals:]
+	if precision > decimals {
+		frac += strings.Repeat("0", precision-decimals)
+	}
+
+	if x.Sign() < 0 {
+		intPart = "-" + intPart
+	}
+	if frac == "" {
+		return intPart
+	}
+	return intPart + "." + frac
+}
+
+// Convert "-1234.5" to a localized form
+func (a *AmountFormat) localize(s string) string {
+	var sign string
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, c := range intPart {
+		if i != 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(a.format.group)
+		}
+		b.WriteRune(c)
+	}
+
+	if frac != "" {
+		b.WriteString(a.format.decimal)
+		b.WriteString(frac)
+	}
+
+	return b.String()
+}
+
+// ParseTez parses a decimal amount in tez and returns it in mutez
+func ParseTez(s string) (*big.Int, error) {
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	if intPart == "" && frac == "" || len(frac) > 6 || strings.HasPrefix(intPart, "-") || strings.HasPrefix(intPart, "+") {
+		return nil, fmt.Errorf("Invalid amount: `%s'", s)
+	}
+
+	v, ok := new(big.Int).SetString("0"+intPart+frac+strings.Repeat("0", 6-len(frac)), 10)
+	if !ok {
+		return nil, fmt.Errorf("Invalid amount: `%s'", s)
+	}
+	return v, nil
+}
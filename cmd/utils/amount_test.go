@@ -0,0 +1,105 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestAmountFormat(t *testing.T) {
+	tests := []struct {
+		unit   string
+		locale string
+		value  int64
+		want   string
+	}{
+		{UnitTez, "C", 1234567890, "1234.567890 ꜩ"},
+		{UnitTez, "en_US.UTF-8", 1234567890, "1,234.567890 ꜩ"},
+		{UnitTez, "de_DE", 1234567890, "1.234,567890 ꜩ"},
+		{UnitTez, "fr_FR.UTF-8", -1234567890, "-1\u202f234,567890 ꜩ"},
+		{UnitTez, "xx_XX", 1234567890, "1234.567890 ꜩ"},
+		{UnitTez, "en", 1, "0.000001 ꜩ"},
+		{UnitTez, "en", 0, "0.000000 ꜩ"},
+		{UnitMutez, "en", 1234567890, "1,234,567,890 µꜩ"},
+		{UnitMutez, "de", -1000, "-1.000 µꜩ"},
+	}
+
+	for _, tt := range tests {
+		f, err := NewAmountFormat(tt.unit, 6, RoundHalfUp, tt.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := f.Format(big.NewInt(tt.value)); s != tt.want {
+			t.Errorf("%s %s %d: got %q, want %q", tt.unit, tt.locale, tt.value, s, tt.want)
+		}
+	}
+
+	if _, err := NewAmountFormat("btc", 6, RoundHalfUp, "C"); err == nil {
+		t.Error("unknown unit accepted")
+	}
+}
+
+func TestParseTez(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"1", "1000000"},
+		{"0.000001", "1"},
+		{"1.5", "1500000"},
+		{".5", "500000"},
+		{"2.", "2000000"},
+		{"0.1", "100000"},
+		// Beyond float64 precision
+		{"9007199254.740993", "9007199254740993"},
+		{"123456789012345678.123456", "123456789012345678123456"},
+	}
+	for _, tt := range tests {
+		v, err := ParseTez(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if v.String() != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.src, v, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", ".", "0.0000001", "-1", "+1", "1e6", "1,5", "abc"} {
+		if _, err := ParseTez(s); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
+
+func TestAmountSum(t *testing.T) {
+	// 0.1 + 0.2 tez is exactly 0.3 tez in mutez, unlike with floats
+	a, _ := ParseTez("0.1")
+	b, _ := ParseTez("0.2")
+	f, err := NewAmountFormat(UnitTez, 6, RoundHalfUp, "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := f.FormatNumber(new(big.Int).Add(a, b)); s != "0.300000" {
+		t.Errorf("got %s, want 0.300000", s)
+	}
+}
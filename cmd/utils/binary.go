@@ -0,0 +1,113 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Binary encoders (MessagePack, CBOR) take the JSON representation of the value
+// so field names and formats are the same as with JSON output
+
+// toGeneric converts the value to its JSON data model. Integers are kept as json.Number
+func toGeneric(v interface{}) (interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var res interface{}
+	if err := dec.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// binaryEncoder writes values of the JSON data model using the encode function
+type binaryEncoder struct {
+	w      io.Writer
+	encode func(buf *bytes.Buffer, v interface{}) error
+}
+
+func (e *binaryEncoder) Encode(v interface{}) error {
+	g, err := toGeneric(v)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := e.encode(&buf, g); err != nil {
+		return err
+	}
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// numberValue returns int64, uint64 or float64
+func numberValue(n json.Number) (interface{}, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return u, nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid number: %s", n)
+	}
+	return f, nil
+}
+
+// DecodeToJSON converts the input in the given encoding (json, msgpack or cbor) to JSON
+func DecodeToJSON(format string, data []byte) ([]byte, error) {
+	var (
+		v   interface{}
+		err error
+	)
+	switch format {
+	case "json":
+		return data, nil
+	case "msgpack":
+		v, err = decodeMsgpack(data)
+	case "cbor":
+		v, err = decodeCBOR(data)
+	default:
+		return nil, fmt.Errorf("Unknown input encoding: `%s'", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,234 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+func cborHeader(buf *bytes.Buffer, major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		buf.WriteByte(m | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{m | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(m | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(m | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(m | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func encodeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if x {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case json.Number:
+		n, err := numberValue(x)
+		if err != nil {
+			return err
+		}
+		switch n := n.(type) {
+		case int64:
+			if n >= 0 {
+				cborHeader(buf, cborUint, uint64(n))
+			} else {
+				cborHeader(buf, cborNegInt, uint64(-1-n))
+			}
+		case uint64:
+			cborHeader(buf, cborUint, n)
+		case float64:
+			buf.WriteByte(cborSimple<<5 | 27)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(n))
+		}
+	case string:
+		cborHeader(buf, cborText, uint64(len(x)))
+		buf.WriteString(x)
+	case []interface{}:
+		cborHeader(buf, cborArray, uint64(len(x)))
+		for _, e := range x {
+			if err := encodeCBOR(buf, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		cborHeader(buf, cborMap, uint64(len(x)))
+		for _, k := range sortedKeys(x) {
+			encodeCBOR(buf, k)
+			if err := encodeCBOR(buf, x[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unsupported CBOR value type %T", v)
+	}
+	return nil
+}
+
+// cborDecoder reuses byte reading helpers of the MessagePack decoder
+type cborDecoder struct {
+	msgpackDecoder
+}
+
+// halfFloat converts IEEE 754 half precision value
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
+
+func (d *cborDecoder) decode() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		if n, err = d.uint(1 << (info - 24)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported CBOR item 0x%02x (indefinite length items are not supported)", b[0])
+	}
+
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, errors.New("CBOR integer overflow")
+		}
+		return -1 - int64(n), nil
+	case cborBytes:
+		return d.next(int(n))
+	case cborText:
+		s, err := d.next(int(n))
+		return string(s), err
+	case cborArray:
+		res := make([]interface{}, n)
+		for i := range res {
+			if res[i], err = d.decode(); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	case cborMap:
+		res := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := k.(string); ok {
+				res[s] = v
+			} else {
+				res[fmt.Sprint(k)] = v
+			}
+		}
+		return res, nil
+	case cborTag:
+		// Tags are ignored
+		return d.decode()
+	}
+
+	// Simple values and floats
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return halfFloat(uint16(n)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 27:
+		return math.Float64frombits(n), nil
+	}
+	return nil, fmt.Errorf("Unsupported CBOR simple value %d", n)
+}
+
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := cborDecoder{msgpackDecoder{buf: data}}
+	v, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buf) != 0 {
+		return nil, errors.New("Trailing data after CBOR value")
+	}
+	return v, nil
+}
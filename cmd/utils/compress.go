@@ -0,0 +1,113 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// CompressWriter is a compressed stream. Flush writes out pending data so a reader sees every complete record,
+// Close must be called to finish the stream
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// CompressionFromExt guesses the algorithm by the file name extension
+func CompressionFromExt(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return CompressGzip
+	case strings.HasSuffix(name, ".zst"):
+		return CompressZstd
+	}
+	return CompressNone
+}
+
+// CompressionExt returns the file name extension of the algorithm
+func CompressionExt(algorithm string) string {
+	switch algorithm {
+	case CompressGzip:
+		return ".gz"
+	case CompressZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// syncCompressWriter allows flushing the stream while another goroutine writes to it
+type syncCompressWriter struct {
+	mtx sync.Mutex
+	w   CompressWriter
+}
+
+func (s *syncCompressWriter) Write(p []byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.w.Write(p)
+}
+
+func (s *syncCompressWriter) Flush() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.w.Flush()
+}
+
+func (s *syncCompressWriter) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.w.Close()
+}
+
+// NewCompressWriter returns a compressed stream writing to w, nil for CompressNone. The stream is safe for concurrent use
+func NewCompressWriter(w io.Writer, algorithm string) (CompressWriter, error) {
+	var (
+		cw  CompressWriter
+		err error
+	)
+	switch algorithm {
+	case CompressNone:
+		return nil, nil
+	case CompressGzip:
+		cw = gzip.NewWriter(w)
+	case CompressZstd:
+		cw, err = zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("Unknown compression algorithm: `%s'", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &syncCompressWriter{w: cw}, nil
+}
@@ -24,6 +24,7 @@ import (
 	"encoding/json"
 	"io"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -34,7 +35,13 @@ type Encoder interface {
 
 type NewEncoderFunc func(w io.Writer) Encoder
 
+// GetEncoderFunc returns the encoder of the format. Markdown and HTML reports render timestamps in UTC
 func GetEncoderFunc(format string) NewEncoderFunc {
+	return GetEncoderFuncIn(format, time.UTC)
+}
+
+// GetEncoderFuncIn is like GetEncoderFunc but markdown and HTML reports render timestamps in the time zone
+func GetEncoderFuncIn(format string, loc *time.Location) NewEncoderFunc {
 	switch strings.ToLower(format) {
 	case "json":
 		return func(w io.Writer) Encoder {
@@ -45,7 +52,59 @@ func GetEncoderFunc(format string) NewEncoderFunc {
 		return func(w io.Writer) Encoder {
 			return yaml.NewEncoder(w)
 		}
+
+	case "msgpack":
+		return func(w io.Writer) Encoder {
+			return &binaryEncoder{w: w, encode: encodeMsgpack}
+		}
+
+	case "cbor":
+		return func(w io.Writer) Encoder {
+			return &binaryEncoder{w: w, encode: encodeCBOR}
+		}
+
+	case "parquet":
+		return func(w io.Writer) Encoder {
+			return &parquetEncoder{out: w}
+		}
+
+	case "markdown", "md":
+		return func(w io.Writer) Encoder {
+			return &tableEncoder{w: w, loc: loc}
+		}
+
+	case "html":
+		return func(w io.Writer) Encoder {
+			return &tableEncoder{w: w, html: true, loc: loc}
+		}
 	}
 
 	return nil
 }
+
+// IsBinary returns true if the format is not suitable for printing to a terminal
+func IsBinary(format string) bool {
+	switch strings.ToLower(format) {
+	case "msgpack", "cbor", "parquet":
+		return true
+	}
+	return false
+}
+
+// IsReport returns true if the format is a human-readable table meant for documents
+func IsReport(format string) bool {
+	switch strings.ToLower(format) {
+	case "markdown", "md", "html":
+		return true
+	}
+	return false
+}
+
+// IsTabular returns true if the format can only hold flat records
+func IsTabular(format string) bool {
+	switch strings.ToLower(format) {
+	case "parquet", "markdown", "md", "html":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// fieldTree is a set of dot separated paths merged by their common prefixes. An empty tree selects the whole value
+type fieldTree map[string]fieldTree
+
+func newFieldTree(paths []string) fieldTree {
+	root := make(fieldTree)
+	for _, p := range paths {
+		t := root
+		for _, name := range strings.Split(p, ".") {
+			sub, ok := t[name]
+			if !ok {
+				sub = make(fieldTree)
+				t[name] = sub
+			}
+			t = sub
+		}
+	}
+	return root
+}
+
+// project returns the selected part of a value decoded from JSON. Arrays are transparent, i.e. paths are applied to each element
+func (t fieldTree) project(v interface{}) (interface{}, bool) {
+	if len(t) == 0 {
+		return v, true
+	}
+	switch x := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, 0, len(x))
+		for _, el := range x {
+			if p, ok := t.project(el); ok {
+				out = append(out, p)
+			}
+		}
+		return out, true
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for name, sub := range t {
+			if el, ok := x[name]; ok {
+				if p, ok := sub.project(el); ok {
+					out[name] = p
+				}
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+type fieldsEncoder struct {
+	enc    Encoder
+	fields fieldTree
+}
+
+// NewFieldsEncoder returns an encoder which projects values down to the dot separated paths
+// (e.g. hash,header.level,metadata.baker) before passing them to the underlying encoder
+func NewFieldsEncoder(enc Encoder, paths []string) Encoder {
+	return &fieldsEncoder{enc: enc, fields: newFieldTree(paths)}
+}
+
+func (f *fieldsEncoder) Encode(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var tmp interface{}
+	if err := dec.Decode(&tmp); err != nil {
+		return err
+	}
+	out, _ := f.fields.project(tmp)
+	return f.enc.Encode(fromJSONNumbers(out))
+}
+
+// fromJSONNumbers converts numbers to int64 or float64 where it doesn't lose precision so
+// that YAML doesn't render them as strings
+func fromJSONNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		if !strings.ContainsAny(string(x), ".eE") {
+			// Big integer
+			return x
+		}
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+	case []interface{}:
+		for i, el := range x {
+			x[i] = fromJSONNumbers(el)
+		}
+	case map[string]interface{}:
+		for k, el := range x {
+			x[k] = fromJSONNumbers(el)
+		}
+	}
+	return v
+}
+
+// Close closes the underlying encoder if it buffers its output
+func (f *fieldsEncoder) Close() error {
+	if cl, ok := f.enc.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var stdinConsumed bool
+
+// ReadInput returns the data given either as `-' (read from stdin), `@path' (read from the file) or inline
+func ReadInput(src string) ([]byte, error) {
+	switch {
+	case src == "-":
+		if stdinConsumed {
+			return nil, errors.New("Only one input can be read from stdin")
+		}
+		stdinConsumed = true
+		return ioutil.ReadAll(os.Stdin)
+
+	case strings.HasPrefix(src, "@"):
+		path, err := ExpandHome(src[1:])
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(path)
+	}
+	return []byte(src), nil
+}
+
+// ReadInputString is like ReadInput but trims surrounding white space which is usually added by shell pipelines
+func ReadInputString(src string) (string, error) {
+	buf, err := ReadInput(src)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
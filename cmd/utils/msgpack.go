@@ -0,0 +1,274 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+func msgpackUint(buf *bytes.Buffer, u uint64) {
+	switch {
+	case u < 0x80:
+		buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(u)})
+	case u <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(u))
+	case u <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(u))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, u)
+	}
+}
+
+func msgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		msgpackUint(buf, uint64(i))
+	case i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(i)})
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// msgpackHeader writes a length prefixed type header. fix is the fixed size variant base, or 0 if there is none
+func msgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, b8, b16, b32 byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{b8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func encodeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if x {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		n, err := numberValue(x)
+		if err != nil {
+			return err
+		}
+		switch n := n.(type) {
+		case int64:
+			msgpackInt(buf, n)
+		case uint64:
+			msgpackUint(buf, n)
+		case float64:
+			buf.WriteByte(0xcb)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(n))
+		}
+	case string:
+		msgpackHeader(buf, len(x), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(x)
+	case []interface{}:
+		msgpackHeader(buf, len(x), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range x {
+			if err := encodeMsgpack(buf, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		msgpackHeader(buf, len(x), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range sortedKeys(x) {
+			encodeMsgpack(buf, k)
+			if err := encodeMsgpack(buf, x[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unsupported MessagePack value type %T", v)
+	}
+	return nil
+}
+
+type msgpackDecoder struct {
+	buf []byte
+}
+
+var errShortInput = errors.New("Unexpected end of input")
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if len(d.buf) < n {
+		return nil, errShortInput
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) array(n int) (interface{}, error) {
+	res := make([]interface{}, n)
+	for i := range res {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
+
+func (d *msgpackDecoder) object(n int) (interface{}, error) {
+	res := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := k.(string); ok {
+			res[s] = v
+		} else {
+			res[fmt.Sprint(k)] = v
+		}
+	}
+	return res, nil
+}
+
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	t := b[0]
+
+	// Sizes of length prefixes and fixed width values
+	size := func(base byte) int { return 1 << (t - base) }
+
+	switch {
+	case t <= 0x7f:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t&0xf0 == 0x80:
+		return d.object(int(t & 0x0f))
+	case t&0xf0 == 0x90:
+		return d.array(int(t & 0x0f))
+	case t&0xe0 == 0xa0:
+		s, err := d.next(int(t & 0x1f))
+		return string(s), err
+	case t == 0xc0:
+		return nil, nil
+	case t == 0xc2:
+		return false, nil
+	case t == 0xc3:
+		return true, nil
+	case t >= 0xc4 && t <= 0xc6: // bin
+		n, err := d.uint(size(0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.next(int(n))
+	case t == 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case t == 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case t >= 0xcc && t <= 0xcf:
+		return d.uint(size(0xcc))
+	case t >= 0xd0 && t <= 0xd3:
+		sz := size(0xd0)
+		u, err := d.uint(sz)
+		// Sign extend
+		shift := uint(64 - 8*sz)
+		return int64(u<<shift) >> shift, err
+	case t >= 0xd9 && t <= 0xdb: // str
+		n, err := d.uint(size(0xd9))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(int(n))
+		return string(s), err
+	case t == 0xdc || t == 0xdd:
+		n, err := d.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n))
+	case t == 0xde || t == 0xdf:
+		n, err := d.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(int(n))
+	}
+	return nil, fmt.Errorf("Unsupported MessagePack type 0x%02x", t)
+}
+
+func decodeMsgpack(data []byte) (interface{}, error) {
+	d := msgpackDecoder{buf: data}
+	v, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buf) != 0 {
+		return nil, errors.New("Trailing data after MessagePack value")
+	}
+	return v, nil
+}
@@ -0,0 +1,186 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ecadlabs/tez/parquet"
+)
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	bigIntType = reflect.TypeOf(big.Int{})
+)
+
+type parquetField struct {
+	index  int
+	column parquet.Column
+}
+
+// parquetEncoder writes structs tagged with `parquet:"name"' as rows. The schema is taken from the first encoded value.
+// Close must be called to write the file footer
+type parquetEncoder struct {
+	w      *parquet.Writer
+	out    io.Writer
+	typ    reflect.Type
+	fields []*parquetField
+}
+
+func parquetColumn(name string, t reflect.Type) (parquet.Column, error) {
+	c := parquet.Column{Name: name, Converted: parquet.None}
+	if t.Kind() == reflect.Ptr {
+		c.Optional = true
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		c.Type, c.Converted = parquet.Int64, parquet.TimestampMillis
+	case t == bigIntType:
+		// Mutez amounts fit into int64
+		c.Type = parquet.Int64
+	case t.Kind() == reflect.String:
+		c.Type, c.Converted = parquet.ByteArray, parquet.UTF8
+	case t.Kind() == reflect.Bool:
+		c.Type = parquet.Boolean
+	case t.Kind() == reflect.Int32:
+		c.Type = parquet.Int32
+	case t.Kind() == reflect.Int || t.Kind() == reflect.Int64:
+		c.Type = parquet.Int64
+	case t.Kind() == reflect.Float64:
+		c.Type = parquet.Double
+	default:
+		return c, fmt.Errorf("Unsupported column type %v of `%s'", t, name)
+	}
+	return c, nil
+}
+
+func (e *parquetEncoder) init(t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("Value of type %v can't be encoded as a Parquet row", t)
+	}
+
+	var schema []parquet.Column
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("parquet"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		c, err := parquetColumn(name, f.Type)
+		if err != nil {
+			return err
+		}
+		e.fields = append(e.fields, &parquetField{index: i, column: c})
+		schema = append(schema, c)
+	}
+
+	e.typ = t
+	e.w = parquet.NewWriter(e.out, schema)
+	return nil
+}
+
+func (e *parquetEncoder) encodeRow(v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	if e.w == nil {
+		if err := e.init(v.Type()); err != nil {
+			return err
+		}
+	}
+	if v.Type() != e.typ {
+		return fmt.Errorf("Value of type %v doesn't match Parquet schema of %v", v.Type(), e.typ)
+	}
+
+	row := make([]interface{}, len(e.fields))
+	for i, f := range e.fields {
+		fv := v.Field(f.index)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+
+		switch x := fv.Interface().(type) {
+		case time.Time:
+			row[i] = x
+		case big.Int:
+			row[i] = x.Int64()
+		default:
+			switch fv.Kind() {
+			case reflect.String:
+				row[i] = fv.String()
+			case reflect.Bool:
+				row[i] = fv.Bool()
+			case reflect.Int32:
+				row[i] = int32(fv.Int())
+			case reflect.Int, reflect.Int64:
+				row[i] = fv.Int()
+			case reflect.Float64:
+				row[i] = fv.Float()
+			}
+		}
+	}
+
+	return e.w.Write(row)
+}
+
+// Encode writes a struct or a slice of structs
+func (e *parquetEncoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		if e.w == nil {
+			// Take the schema from the element type so even an empty file has one
+			t := rv.Type().Elem()
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if err := e.init(t); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := e.encodeRow(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return e.encodeRow(rv)
+}
+
+// Close writes the file footer
+func (e *parquetEncoder) Close() error {
+	if e.w == nil {
+		// Nothing was written so the schema is unknown
+		e.w = parquet.NewWriter(e.out, nil)
+	}
+	return e.w.Close()
+}
@@ -0,0 +1,264 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Styling and click-to-sort script inlined into HTML reports so the file is self-contained
+const htmlReportHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 14px; margin: 1em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d0d7de; padding: 4px 8px; white-space: nowrap; }
+th { background: #f6f8fa; cursor: pointer; user-select: none; }
+tr:nth-child(even) td { background: #fafbfc; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+</style>
+<script>
+document.addEventListener("DOMContentLoaded", function () {
+  document.querySelectorAll("table").forEach(function (table) {
+    table.querySelectorAll("th").forEach(function (th, col) {
+      th.addEventListener("click", function () {
+        var asc = th.dataset.order !== "asc";
+        table.querySelectorAll("th").forEach(function (x) { delete x.dataset.order; });
+        th.dataset.order = asc ? "asc" : "desc";
+        var body = table.tBodies[0];
+        var rows = Array.prototype.slice.call(body.rows);
+        var key = function (r) { return r.cells[col] ? r.cells[col].textContent : ""; };
+        rows.sort(function (a, b) {
+          var x = key(a), y = key(b), nx = parseFloat(x), ny = parseFloat(y);
+          var c = !isNaN(nx) && !isNaN(ny) ? nx - ny : x.localeCompare(y);
+          return asc ? c : -c;
+        });
+        rows.forEach(function (r) { body.appendChild(r); });
+      });
+    });
+  });
+});
+</script>
+</head>
+<body>
+`
+
+type tableColumn struct {
+	index   int
+	name    string
+	numeric bool
+}
+
+// tableEncoder writes structs or slices of structs as a table with a column per exported field.
+// Column names are taken from json or parquet tags. Nested values are written as JSON
+type tableEncoder struct {
+	w       io.Writer
+	typ     reflect.Type
+	columns []*tableColumn
+	html    bool
+	loc     *time.Location
+	err     error
+}
+
+func isNumeric(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return t == bigIntType || t == reflect.TypeOf(big.Float{})
+}
+
+func columnName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "parquet"} {
+		if name := strings.Split(f.Tag.Get(tag), ",")[0]; name == "-" {
+			return ""
+		} else if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func (e *tableEncoder) write(s string) {
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
+}
+
+func (e *tableEncoder) escape(s string) string {
+	if e.html {
+		return html.EscapeString(s)
+	}
+	s = strings.Replace(s, "|", "\\|", -1)
+	return strings.Replace(s, "\n", " ", -1)
+}
+
+func (e *tableEncoder) init(t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("Value of type %v can't be encoded as a table", t)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if name := columnName(f); name != "" {
+			e.columns = append(e.columns, &tableColumn{index: i, name: name, numeric: isNumeric(f.Type)})
+		}
+	}
+	e.typ = t
+
+	if e.html {
+		e.write(htmlReportHead + "<table>\n<thead><tr>")
+		for _, c := range e.columns {
+			e.write("<th>" + e.escape(c.name) + "</th>")
+		}
+		e.write("</tr></thead>\n<tbody>\n")
+	} else {
+		var head, sep strings.Builder
+		for _, c := range e.columns {
+			head.WriteString("| " + e.escape(c.name) + " ")
+			if c.numeric {
+				sep.WriteString("|--:")
+			} else {
+				sep.WriteString("|---")
+			}
+		}
+		e.write(head.String() + "|\n" + sep.String() + "|\n")
+	}
+	return e.err
+}
+
+func formatCell(v reflect.Value, loc *time.Location) string {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		if f, ok := v.Interface().(*big.Float); ok {
+			// Avoid the exponent notation
+			return f.Text('f', -1)
+		}
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+		v = v.Elem()
+	}
+
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.In(loc).Format(time.RFC3339)
+	case fmt.Stringer:
+		return x.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+		buf, err := json.Marshal(v.Interface())
+		if err != nil {
+			return ""
+		}
+		return string(buf)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func (e *tableEncoder) encodeRow(v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	if e.typ == nil {
+		if err := e.init(v.Type()); err != nil {
+			return err
+		}
+	}
+	if v.Type() != e.typ {
+		return fmt.Errorf("Value of type %v doesn't match the table of %v", v.Type(), e.typ)
+	}
+
+	var row strings.Builder
+	if e.html {
+		row.WriteString("<tr>")
+	}
+	for _, c := range e.columns {
+		s := e.escape(formatCell(v.Field(c.index), e.loc))
+		switch {
+		case !e.html:
+			row.WriteString("| " + s + " ")
+		case c.numeric:
+			row.WriteString(`<td class="num">` + s + "</td>")
+		default:
+			row.WriteString("<td>" + s + "</td>")
+		}
+	}
+	if e.html {
+		row.WriteString("</tr>\n")
+	} else {
+		row.WriteString("|\n")
+	}
+	e.write(row.String())
+	return e.err
+}
+
+// Encode writes a struct or a slice of structs. The header is written once so the stream of values forms a single table
+func (e *tableEncoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		if e.typ == nil {
+			t := rv.Type().Elem()
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if err := e.init(t); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := e.encodeRow(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return e.encodeRow(rv)
+}
+
+// Close completes the HTML document
+func (e *tableEncoder) Close() error {
+	if e.html && e.typ != nil {
+		e.write("</tbody>\n</table>\n</body>\n</html>\n")
+	}
+	return e.err
+}
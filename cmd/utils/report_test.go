@@ -0,0 +1,56 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportTimezone(t *testing.T) {
+	type row struct {
+		Time time.Time `json:"time"`
+	}
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	paris, err := LoadTimezone("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		newEncoder NewEncoderFunc
+		want       string
+	}{
+		{GetEncoderFunc("markdown"), "| 2024-03-01T12:00:00Z |"},
+		{GetEncoderFuncIn("markdown", paris), "| 2024-03-01T13:00:00+01:00 |"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.newEncoder(&buf).Encode([]*row{{Time: ts}}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("got %q, want %q", buf.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestRoundDecimal(t *testing.T) {
+	// One decimal place rounded to integers, see java.math.RoundingMode
+	modes := []string{RoundUp, RoundDown, RoundCeiling, RoundFloor, RoundHalfUp, RoundHalfDown, RoundHalfEven}
+	tests := []struct {
+		value int64
+		want  [7]int64
+	}{
+		{55, [7]int64{6, 5, 6, 5, 6, 5, 6}},
+		{25, [7]int64{3, 2, 3, 2, 3, 2, 2}},
+		{16, [7]int64{2, 1, 2, 1, 2, 2, 2}},
+		{11, [7]int64{2, 1, 2, 1, 1, 1, 1}},
+		{10, [7]int64{1, 1, 1, 1, 1, 1, 1}},
+		{4, [7]int64{1, 0, 1, 0, 0, 0, 0}},
+		{0, [7]int64{0, 0, 0, 0, 0, 0, 0}},
+		{-4, [7]int64{-1, 0, 0, -1, 0, 0, 0}},
+		{-10, [7]int64{-1, -1, -1, -1, -1, -1, -1}},
+		{-11, [7]int64{-2, -1, -1, -2, -1, -1, -1}},
+		{-16, [7]int64{-2, -1, -1, -2, -2, -2, -2}},
+		{-25, [7]int64{-3, -2, -2, -3, -3, -2, -2}},
+		{-55, [7]int64{-6, -5, -5, -6, -6, -5, -6}},
+	}
+
+	for _, tt := range tests {
+		for i, mode := range modes {
+			got, decimals := roundDecimal(big.NewInt(tt.value), 1, 0, mode)
+			if got.Int64() != tt.want[i] || decimals != 0 {
+				t.Errorf("%d/10 %s: got %v (%d decimals), want %d", tt.value, mode, got, decimals, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRoundDecimalPrecision(t *testing.T) {
+	tests := []struct {
+		value     int64
+		decimals  int
+		precision int
+		mode      string
+		want      int64
+		wantDec   int
+	}{
+		{1234567, 6, 2, RoundHalfUp, 123, 2},
+		{1235000, 6, 2, RoundHalfEven, 124, 2},
+		{1225000, 6, 2, RoundHalfEven, 122, 2},
+		{1225001, 6, 2, RoundHalfEven, 123, 2},
+		{1225000, 6, 2, RoundHalfDown, 122, 2},
+		{-1234567, 6, 3, RoundFloor, -1235, 3},
+		{-1234567, 6, 3, RoundCeiling, -1234, 3},
+		// Nothing to round
+		{1234567, 6, 6, RoundUp, 1234567, 6},
+		{1234567, 6, 8, RoundUp, 1234567, 6},
+	}
+
+	for _, tt := range tests {
+		got, decimals := roundDecimal(big.NewInt(tt.value), tt.decimals, tt.precision, tt.mode)
+		if got.Int64() != tt.want || decimals != tt.wantDec {
+			t.Errorf("%d/10^%d to %d %s: got %v (%d decimals), want %d (%d decimals)", tt.value, tt.decimals, tt.precision, tt.mode, got, decimals, tt.want, tt.wantDec)
+		}
+	}
+}
+
+func TestFormatPrecision(t *testing.T) {
+	tests := []struct {
+		precision int
+		rounding  string
+		value     int64
+		want      string
+	}{
+		{2, RoundHalfUp, 1234567, "1.23"},
+		{2, RoundUp, 1230001, "1.24"},
+		{0, RoundHalfEven, 2500000, "2"},
+		{0, RoundHalfEven, 3500000, "4"},
+		{3, RoundFloor, -1234567, "-1.235"},
+		{8, RoundHalfUp, 1, "0.00000100"},
+	}
+	for _, tt := range tests {
+		f, err := NewAmountFormat(UnitTez, tt.precision, tt.rounding, "C")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := f.FormatNumber(big.NewInt(tt.value)); s != tt.want {
+			t.Errorf("%d to %d %s: got %s, want %s", tt.value, tt.precision, tt.rounding, s, tt.want)
+		}
+	}
+
+	if _, err := NewAmountFormat(UnitTez, -1, RoundHalfUp, "C"); err == nil {
+		t.Error("negative precision accepted")
+	}
+	if _, err := NewAmountFormat(UnitTez, 6, "sideways", "C"); err == nil {
+		t.Error("unknown rounding mode accepted")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// JSONSchema returns the JSON Schema of values of the type as encoded by encoding/json. Interfaces
+// and recursive types are described as any value
+func JSONSchema(t reflect.Type) map[string]interface{} {
+	return jsonSchema(t, make(map[reflect.Type]bool))
+}
+
+func jsonSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		return nullable(jsonSchema(t.Elem(), seen))
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == bigIntType:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Struct && embedsType(t, bigIntType):
+		// Wrappers like tezos.BigInt
+		return map[string]interface{}{"type": "integer"}
+	case implements(t, jsonMarshalerType):
+		return map[string]interface{}{}
+	case implements(t, textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Base64, nil slices are encoded as null
+			return map[string]interface{}{"type": []string{"string", "null"}}
+		}
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": jsonSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": jsonSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		props := make(map[string]interface{})
+		var required []string
+		structSchemaFields(t, props, &required, seen)
+		s := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) != 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	// Interfaces
+	return map[string]interface{}{}
+}
+
+// structSchemaFields collects properties of the struct including ones promoted from embedded structs
+func structSchemaFields(t reflect.Type, props map[string]interface{}, required *[]string, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			structSchemaFields(ft, props, required, seen)
+			continue
+		}
+		if f.PkgPath != "" {
+			// Unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = jsonSchema(f.Type, seen)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// nullable allows null in addition to the schema's type
+func nullable(s map[string]interface{}) map[string]interface{} {
+	if typ, ok := s["type"].(string); ok {
+		s["type"] = []string{typ, "null"}
+	}
+	return s
+}
+
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
+
+func embedsType(t, e reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Anonymous && f.Type == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ellipsis is used to mark truncated values
+const Ellipsis = "…"
+
+// Align is a column alignment
+type Align int
+
+// Column alignments
+const (
+	AlignLeft Align = iota
+	AlignRight
+)
+
+// TableColumn describes a column of the table layout
+type TableColumn struct {
+	Header string
+	// Natural width of the column
+	Width int
+	// Width the column can be shrunk to, 0 means the column can't be truncated
+	MinWidth int
+	Align    Align
+}
+
+// Table renders rows as fixed width columns. Widths are calculated in advance so rows can be streamed.
+// If the total width exceeds the limit the truncatable columns are shrunk and their values are ellipsized.
+// Otherwise values are never truncated
+type Table struct {
+	w       io.Writer
+	columns []TableColumn
+	widths  []int
+}
+
+// NewTable returns new Table. Zero maxWidth means no limit
+func NewTable(w io.Writer, columns []TableColumn, maxWidth int) *Table {
+	widths := make([]int, len(columns))
+	total := len(columns) - 1 // Separators
+	for i, c := range columns {
+		widths[i] = c.Width
+		total += c.Width
+	}
+
+	for maxWidth > 0 && total > maxWidth {
+		// Shrink the widest truncatable column
+		idx := -1
+		for i, c := range columns {
+			if c.MinWidth != 0 && widths[i] > c.MinWidth && (idx < 0 || widths[i] > widths[idx]) {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			break
+		}
+		widths[idx]--
+		total--
+	}
+
+	return &Table{
+		w:       w,
+		columns: columns,
+		widths:  widths,
+	}
+}
+
+// Ellipsize shortens the string to the width keeping its beginning and end, which is more useful for hashes than
+// the beginning only
+func Ellipsize(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n <= width {
+		return s
+	}
+	if width <= 1 {
+		return Ellipsis
+	}
+	r := []rune(s)
+	head := (width - 1) - (width-1)/2
+	tail := (width - 1) / 2
+	return string(r[:head]) + Ellipsis + string(r[n-tail:])
+}
+
+func pad(s string, width int, align Align) string {
+	n := utf8.RuneCountInString(s)
+	if n >= width {
+		return s
+	}
+	if align == AlignRight {
+		return strings.Repeat(" ", width-n) + s
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+// WriteRow writes a single row
+func (t *Table) WriteRow(cells ...string) error {
+	var b strings.Builder
+	for i, c := range t.columns {
+		var s string
+		if i < len(cells) {
+			s = cells[i]
+		}
+		if t.widths[i] < c.Width {
+			s = Ellipsize(s, t.widths[i])
+		}
+		if i == len(t.columns)-1 && c.Align == AlignLeft {
+			// Don't pad the last column
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(pad(s, t.widths[i], c.Align))
+		if i != len(t.columns)-1 {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
+
+// WriteHeader writes column headers
+func (t *Table) WriteHeader() error {
+	cells := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		cells[i] = c.Header
+	}
+	return t.WriteRow(cells...)
+}
@@ -0,0 +1,301 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Interval between vanity search progress messages
+const vanityProgressInterval = 10 * time.Second
+
+// Address prefixes of key types
+var addressPrefixes = map[string]*base58.Prefix{
+	keys.TypeEd25519: base58.PrefixEd25519PublicKeyHash,
+	keys.TypeP256:    base58.PrefixP256PublicKeyHash,
+	keys.TypeBLS:     base58.PrefixBLS12381PublicKeyHash,
+}
+
+type vanityOptions struct {
+	prefix      string
+	keyType     string
+	ignoreCase  bool
+	workers     int
+	unencrypted bool
+}
+
+// Forms of the same public key
+type publicKeyForms struct {
+	Type      string `json:"type" yaml:"type"`
+	PublicKey string `json:"public_key" yaml:"public_key"`
+	Address   string `json:"address" yaml:"address"`
+	// Tagged binary form used in operations
+	Bytes string `json:"bytes" yaml:"bytes"`
+	// Untagged key, compressed for P-256
+	Raw          string `json:"raw" yaml:"raw"`
+	Uncompressed string `json:"uncompressed,omitempty" yaml:"uncompressed,omitempty"`
+}
+
+func newKeyVanityCommand(ctx *RootContext) *cobra.Command {
+	var opt vanityOptions
+
+	vanityCmd := &cobra.Command{
+		Use:   "vanity <alias>",
+		Short: "Generate a key with an address starting with the prefix",
+		Long: `Generate random keys using all CPU cores until the address starts with --prefix, then store the key under the alias.
+Every additional character takes about 58 times longer. The secret key is only written to the keystore.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			k, err := ctx.vanitySearch(&opt)
+			if err != nil {
+				return err
+			}
+			return ctx.addKey(args[0], k, opt.unencrypted)
+		},
+	}
+
+	f := vanityCmd.Flags()
+	f.StringVar(&opt.prefix, "prefix", "", "Address prefix including the type prefix, e.g. tz1Tez")
+	f.StringVar(&opt.keyType, "type", keys.TypeEd25519, "Key type: one of [ed25519, p256, bls]")
+	f.BoolVar(&opt.ignoreCase, "ignore-case", false, "Match the prefix case-insensitively")
+	f.IntVar(&opt.workers, "workers", runtime.NumCPU(), "Number of concurrent workers")
+	f.BoolVar(&opt.unencrypted, "unencrypted", false, "Store the secret key without a passphrase")
+	vanityCmd.MarkFlagRequired("prefix")
+
+	return vanityCmd
+}
+
+// vanityAttempts returns the expected number of keys to generate or zero if the suffix can't be matched
+func vanityAttempts(suffix string, ignoreCase bool) float64 {
+	n := 1.0
+	for _, ch := range suffix {
+		if !ignoreCase {
+			if !base58.IsAlphabet(string(ch)) {
+				return 0
+			}
+			n *= 58
+			continue
+		}
+		variants := 0
+		for _, v := range []string{strings.ToLower(string(ch)), strings.ToUpper(string(ch))} {
+			if base58.IsAlphabet(v) {
+				variants++
+			}
+		}
+		if strings.ToLower(string(ch)) == strings.ToUpper(string(ch)) {
+			variants /= 2
+		}
+		if variants == 0 {
+			return 0
+		}
+		n *= 58 / float64(variants)
+	}
+	return n
+}
+
+func (c *RootContext) vanitySearch(opt *vanityOptions) (keys.PrivateKey, error) {
+	p, ok := addressPrefixes[opt.keyType]
+	if !ok {
+		return nil, fmt.Errorf("Unknown key type: `%s'", opt.keyType)
+	}
+	if !strings.HasPrefix(opt.prefix, p.Tag) {
+		return nil, fmt.Errorf("Addresses of %s keys start with %s", opt.keyType, p.Tag)
+	}
+	suffix := opt.prefix[len(p.Tag):]
+	if suffix == "" {
+		return nil, errors.New("Prefix is too short")
+	}
+	expected := vanityAttempts(suffix, opt.ignoreCase)
+	if expected == 0 {
+		return nil, fmt.Errorf("Prefix contains characters outside of the Base58 alphabet which excludes 0, O, I and l: `%s'", opt.prefix)
+	}
+	if opt.workers < 1 {
+		return nil, fmt.Errorf("Invalid number of workers: %d", opt.workers)
+	}
+
+	match := func(addr string) bool { return strings.HasPrefix(addr, opt.prefix) }
+	if opt.ignoreCase {
+		prefix := strings.ToLower(opt.prefix)
+		match = func(addr string) bool {
+			return len(addr) >= len(prefix) && strings.ToLower(addr[:len(prefix)]) == prefix
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"prefix":   opt.prefix,
+		"workers":  opt.workers,
+		"expected": fmt.Sprintf("%.0f", expected),
+	}).Info("Searching for the vanity address")
+
+	var (
+		attempts uint64
+		found    = make(chan keys.PrivateKey, 1)
+		errCh    = make(chan error, 1)
+		done     = make(chan struct{})
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < opt.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				k, err := keys.GeneratePrivateKey(opt.keyType)
+				if err != nil {
+					// Only the first error is reported, the channels are never closed by workers
+					select {
+					case errCh <- err:
+					default:
+					}
+					return
+				}
+				atomic.AddUint64(&attempts, 1)
+				if match(k.Public().Hash()) {
+					select {
+					case found <- k:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(vanityProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errCh:
+			return nil, err
+		case k := <-found:
+			log.WithFields(log.Fields{
+				"attempts": atomic.LoadUint64(&attempts),
+				"elapsed":  time.Since(start).Round(time.Second),
+			}).Info("Vanity address found")
+			return k, nil
+		case <-ticker.C:
+			n := atomic.LoadUint64(&attempts)
+			rate := float64(n) / time.Since(start).Seconds()
+			fields := log.Fields{
+				"attempts": n,
+				"rate":     fmt.Sprintf("%.0f/s", rate),
+			}
+			// Expected time of the remaining search, the process is memoryless
+			if rate > 0 && expected/rate < math.MaxInt64/float64(time.Second) {
+				fields["eta"] = time.Duration(expected / rate * float64(time.Second)).Round(time.Second)
+			}
+			log.WithFields(fields).Info("Searching")
+		case <-c.context.Done():
+			return nil, c.context.Err()
+		}
+	}
+}
+
+func newKeyConvertCommand(ctx *RootContext) *cobra.Command {
+	var outputFormat string
+
+	convertCmd := &cobra.Command{
+		Use:   "convert <public key|hex>",
+		Short: "Convert a public key between its encoded forms",
+		Long: `Print the Base58Check encoded public key, its address, the tagged binary form used in operations and the raw key.
+The input is a Base58Check public key, a keystore alias or a hex encoded key: tagged, raw Ed25519 or BLS12-381, or a
+compressed or uncompressed SEC 1 P-256 point.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pub, err := ctx.parsePublicKeyAny(args[0])
+			if err != nil {
+				return err
+			}
+			f := publicKeyForms{
+				Type:      keys.KeyType(pub),
+				PublicKey: pub.String(),
+				Address:   pub.Hash(),
+				Bytes:     hex.EncodeToString(pub.Bytes()),
+				Raw:       hex.EncodeToString(pub.Bytes()[1:]),
+			}
+			if u := keys.UncompressedP256(pub); u != nil {
+				f.Uncompressed = hex.EncodeToString(u)
+			}
+
+			if outputFormat != "text" {
+				newEnc := ctx.encoderFunc(outputFormat)
+				if newEnc == nil {
+					return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+				}
+				return newEnc(os.Stdout).Encode(&f)
+			}
+			fmt.Printf("Type:         %s\nPublic key:   %s\nAddress:      %s\nBytes:        %s\nRaw:          %s\n", f.Type, f.PublicKey, f.Address, f.Bytes, f.Raw)
+			if f.Uncompressed != "" {
+				fmt.Printf("Uncompressed: %s\n", f.Uncompressed)
+			}
+			return nil
+		},
+	}
+
+	convertCmd.Flags().StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, yaml, json]")
+
+	return convertCmd
+}
+
+// parsePublicKeyAny accepts the forms listed by `key convert'
+func (c *RootContext) parsePublicKeyAny(s string) (keys.PublicKey, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		src, err := c.resolvePublicKey(s)
+		if err != nil {
+			return nil, err
+		}
+		return keys.ParsePublicKey(src)
+	}
+
+	switch {
+	case len(b) == 32:
+		return keys.RawPublicKey(keys.TypeEd25519, b)
+	case len(b) == 33 && (b[0] == 2 || b[0] == 3), len(b) == 65 && b[0] == 4:
+		return keys.RawPublicKey(keys.TypeP256, b)
+	case len(b) == 48:
+		return keys.RawPublicKey(keys.TypeBLS, b)
+	}
+	return keys.PublicKeyFromBytes(b)
+}
@@ -0,0 +1,170 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	tezos "github.com/ecadlabs/go-tezos"
+	log "github.com/sirupsen/logrus"
+)
+
+// VerificationError is returned when responses of the primary and the secondary endpoints differ
+type VerificationError struct {
+	Object string
+	Fields []string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("Verification failed: %s differs from the secondary endpoint in: %s", e.Object, strings.Join(e.Fields, ", "))
+}
+
+// verifyBlock cross-checks block contents against the secondary endpoint
+func (c *RootContext) verifyBlock(block *tezos.Block) error {
+	if c.verifyService == nil {
+		return nil
+	}
+
+	// Always address by hash as the secondary node may be at a different head
+	other, err := c.verifyService.GetBlock(c.context, c.chainID, block.Hash)
+	if err != nil {
+		return fmt.Errorf("Verification failed: can't get block %s from the secondary endpoint: %v", block.Hash, err)
+	}
+
+	fields := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"hash", block.Hash, other.Hash},
+		{"chain_id", block.ChainID, other.ChainID},
+		{"protocol", block.Protocol, other.Protocol},
+		{"header", &block.Header, &other.Header},
+		{"metadata", &block.Metadata, &other.Metadata},
+		{"operations", block.Operations, other.Operations},
+	}
+
+	var diff []string
+	for _, f := range fields {
+		eq, err := jsonEqual(f.a, f.b)
+		if err != nil {
+			return err
+		}
+		if !eq {
+			diff = append(diff, f.name)
+		}
+	}
+
+	if len(diff) != 0 {
+		return &VerificationError{
+			Object: "block " + block.Hash,
+			Fields: diff,
+		}
+	}
+
+	log.WithField("block", block.Hash).Debug("Block verified")
+
+	return nil
+}
+
+// verifyRPC compares a raw JSON response of an arbitrary GET RPC call. Supposed to be used with immutable paths
+// (i.e. addressed by block hash) only
+func (c *RootContext) verifyRPC(path string, v interface{}) error {
+	if c.verifyService == nil {
+		return nil
+	}
+
+	var other json.RawMessage
+	req, err := c.verifyService.Client.NewRequest(c.context, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	if err := c.verifyService.Client.Do(req, &other); err != nil {
+		return fmt.Errorf("Verification failed: can't get %s from the secondary endpoint: %v", path, err)
+	}
+
+	eq, err := jsonEqual(v, other)
+	if err != nil {
+		return err
+	}
+	if !eq {
+		return &VerificationError{
+			Object: path,
+			Fields: []string{"response"},
+		}
+	}
+
+	return nil
+}
+
+// getVerifiedRPC gets the block's context entry and cross-checks the response against the secondary endpoint
+// with --verify. The block is addressed by hash so both endpoints read the same state. It is a plain comparison,
+// no Merkle proofs are checked, so both endpoints serving the same wrong data goes unnoticed
+func (c *RootContext) getVerifiedRPC(blockID, path string, v interface{}) error {
+	if c.verifyService == nil {
+		return c.getRPC(c.blockPath(blockID)+path, v)
+	}
+
+	var hash string
+	if err := c.getRPC(c.blockPath(blockID)+"/hash", &hash); err != nil {
+		return err
+	}
+	path = c.blockPath(hash) + path
+	// Compare the raw response as decoded values may not marshal back to the same JSON
+	var raw json.RawMessage
+	if err := c.getRPC(path, &raw); err != nil {
+		return err
+	}
+	if err := c.verifyRPC(path, raw); err != nil {
+		return err
+	}
+	log.WithField("path", path).Debug("Response verified")
+	return json.Unmarshal(raw, v)
+}
+
+// Compare canonical JSON representations
+func jsonEqual(a, b interface{}) (bool, error) {
+	ca, err := canonicalJSON(a)
+	if err != nil {
+		return false, err
+	}
+	cb, err := canonicalJSON(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ca, cb), nil
+}
+
+func canonicalJSON(v interface{}) ([]byte, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// Round trip through a generic value to get sorted keys
+	var tmp interface{}
+	if err := json.Unmarshal(buf, &tmp); err != nil {
+		return nil, err
+	}
+	return json.Marshal(tmp)
+}
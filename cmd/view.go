@@ -0,0 +1,358 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ecadlabs/tez/michelson"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Maximum size of TZIP-16 metadata fetched from off-chain storage
+const maxMetadataSize = 4 << 20
+
+// tzip16Metadata is the part of TZIP-16 contract metadata describing off-chain views
+type tzip16Metadata struct {
+	Views []*tzip16View `json:"views"`
+}
+
+type tzip16View struct {
+	Name            string `json:"name"`
+	Implementations []struct {
+		MichelsonStorageView *michelsonStorageView `json:"michelsonStorageView"`
+	} `json:"implementations"`
+}
+
+// michelsonStorageView is an off-chain view executed against the contract's storage
+type michelsonStorageView struct {
+	Parameter  *michelson.Node `json:"parameter"`
+	ReturnType *michelson.Node `json:"returnType"`
+	Code       *michelson.Node `json:"code"`
+}
+
+// section returns the argument of the script's toplevel section, e.g. storage
+func (s *contractScript) section(prim string) *michelson.Node {
+	if s.Code == nil {
+		return nil
+	}
+	for _, n := range s.Code.Args {
+		if n.IsPrim(prim) && len(n.Args) == 1 {
+			return n.Args[0]
+		}
+	}
+	return nil
+}
+
+// hasView returns true if the script defines the on-chain view
+func (s *contractScript) hasView(name string) bool {
+	if s.Code == nil {
+		return false
+	}
+	for _, n := range s.Code.Args {
+		if n.IsPrim("view") && len(n.Args) == 4 && n.Args[0].Kind == michelson.KindString && n.Args[0].Str == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newContractViewCommand(rootCtx *RootContext) *cobra.Command {
+	var (
+		argSrc       string
+		source       string
+		gateway      string
+		offChain     bool
+		outputFormat string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "view <KT1...|alias> <view-name>",
+		Short: "Execute the contract's view",
+		Long: `Execute an on-chain view defined in the contract's code or, if there is none with the name, an off-chain view
+from the contract's TZIP-16 metadata. Off-chain views are run by the node against the current storage of the contract.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if outputFormat != "text" && outputFormat != "json" {
+				return fmt.Errorf("Unknown output encoding: `%s'", outputFormat)
+			}
+			address, err := rootCtx.contractAddress(args[0])
+			if err != nil {
+				return err
+			}
+			name := args[1]
+
+			var arg *michelson.Node
+			if argSrc != "" {
+				if arg, err = parseMichelsonInput(argSrc); err != nil {
+					return err
+				}
+			}
+			if source != "" {
+				if source, err = rootCtx.resolveAddress(source); err != nil {
+					return err
+				}
+			}
+
+			script, err := rootCtx.contractScript(address)
+			if err != nil {
+				return err
+			}
+
+			var result *michelson.Node
+			if !offChain && script.hasView(name) {
+				if arg == nil {
+					arg = michelson.NewPrim("Unit")
+				}
+				result, err = rootCtx.runOnChainView(address, name, arg, source)
+			} else {
+				var view *michelsonStorageView
+				if view, err = rootCtx.offChainView(address, script, name, gateway); err != nil {
+					return err
+				}
+				result, err = rootCtx.runOffChainView(address, script, view, arg, source)
+			}
+			if err != nil {
+				return err
+			}
+
+			if outputFormat == "text" {
+				fmt.Println(result)
+				return nil
+			}
+			return rootCtx.encoderFunc(outputFormat)(os.Stdout).Encode(result)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&argSrc, "arg", "", "View argument (Michelson text or Micheline JSON); - reads it from stdin and @path from the file")
+	f.StringVar(&source, "source", "", "Address seen by the view as SOURCE and SENDER: keystore alias or address")
+	f.BoolVar(&offChain, "off-chain", false, "Use the TZIP-16 metadata view even if an on-chain view has the same name")
+	f.StringVar(&gateway, "ipfs-gateway", "https://ipfs.io/ipfs/", "IPFS gateway used to fetch ipfs:// metadata")
+	f.StringVarP(&outputFormat, "output-encoding", "o", "text", "Output encoding: one of [text, json]")
+
+	return cmd
+}
+
+func (c *RootContext) viewChainID() (string, error) {
+	var chainID string
+	err := c.getRPC("/chains/"+c.chainID+"/chain_id", &chainID)
+	return chainID, err
+}
+
+// runOnChainView executes the view with the run_script_view RPC
+func (c *RootContext) runOnChainView(address, name string, arg *michelson.Node, source string) (*michelson.Node, error) {
+	chainID, err := c.viewChainID()
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]interface{}{
+		"contract":       address,
+		"view":           name,
+		"input":          arg,
+		"chain_id":       chainID,
+		"unlimited_gas":  true,
+		"unparsing_mode": "Readable",
+	}
+	if source != "" {
+		req["source"], req["payer"] = source, source
+	}
+	var res struct {
+		Data *michelson.Node `json:"data"`
+	}
+	if err := c.postRPC(c.blockPath(c.blockID)+"/helpers/scripts/run_script_view", req, &res); err != nil {
+		return nil, err
+	}
+	if res.Data == nil {
+		return nil, fmt.Errorf("View `%s' returned no data", name)
+	}
+	return res.Data, nil
+}
+
+// runOffChainView wraps the view code into a script storing the result in an option and runs it with the run_code RPC
+// in the context of the contract
+func (c *RootContext) runOffChainView(address string, script *contractScript, view *michelsonStorageView, arg *michelson.Node, source string) (*michelson.Node, error) {
+	storageType := script.section("storage")
+	if storageType == nil || view.ReturnType == nil || view.Code == nil {
+		return nil, errors.New("Incomplete view definition or contract script")
+	}
+
+	paramType, input := storageType, script.Storage
+	switch {
+	case view.Parameter != nil && arg == nil:
+		return nil, fmt.Errorf("The view takes an argument of type %v, use --arg", view.Parameter)
+	case view.Parameter == nil && arg != nil:
+		return nil, errors.New("The view takes no argument")
+	case view.Parameter != nil:
+		paramType = michelson.NewPrim("pair", view.Parameter, storageType)
+		input = michelson.NewPrim("Pair", arg, script.Storage)
+	}
+
+	code := michelson.NewSeq(
+		michelson.NewPrim("CAR"),
+		view.Code,
+		michelson.NewPrim("SOME"),
+		michelson.NewPrim("NIL", michelson.NewPrim("operation")),
+		michelson.NewPrim("PAIR"),
+	)
+	wrapper := michelson.NewSeq(
+		michelson.NewPrim("parameter", paramType),
+		michelson.NewPrim("storage", michelson.NewPrim("option", view.ReturnType)),
+		michelson.NewPrim("code", code),
+	)
+
+	chainID, err := c.viewChainID()
+	if err != nil {
+		return nil, err
+	}
+	balance, err := c.contractBalance(c.blockID, address)
+	if err != nil {
+		return nil, err
+	}
+
+	req := map[string]interface{}{
+		"script":         wrapper,
+		"storage":        michelson.NewPrim("None"),
+		"input":          input,
+		"amount":         "0",
+		"balance":        balance.String(),
+		"chain_id":       chainID,
+		"self":           address,
+		"unparsing_mode": "Readable",
+	}
+	if source != "" {
+		req["source"], req["payer"] = source, source
+	}
+	var res struct {
+		Storage *michelson.Node `json:"storage"`
+	}
+	if err := c.postRPC(c.blockPath(c.blockID)+"/helpers/scripts/run_code", req, &res); err != nil {
+		return nil, err
+	}
+	if res.Storage == nil || !res.Storage.IsPrim("Some") || len(res.Storage.Args) != 1 {
+		return nil, fmt.Errorf("Unexpected view result: %v", res.Storage)
+	}
+	return res.Storage.Args[0], nil
+}
+
+// offChainView returns the view from the contract's TZIP-16 metadata
+func (c *RootContext) offChainView(address string, script *contractScript, name, gateway string) (*michelsonStorageView, error) {
+	meta, err := c.contractMetadata(address, script, gateway)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range meta.Views {
+		if v.Name != name {
+			continue
+		}
+		for _, impl := range v.Implementations {
+			if impl.MichelsonStorageView != nil {
+				return impl.MichelsonStorageView, nil
+			}
+		}
+		return nil, fmt.Errorf("View `%s' has no Michelson storage implementation", name)
+	}
+	return nil, fmt.Errorf("Unknown view: `%s'", name)
+}
+
+// bigMapString returns the value of the big_map(string, bytes) entry as a string
+func (c *RootContext) bigMapString(id, key string) (string, error) {
+	packed, err := michelson.PackTyped(michelson.NewString(key), michelson.NewPrim("string"))
+	if err != nil {
+		return "", err
+	}
+	var v michelson.Node
+	if err := c.getRPC(c.blockPath(c.blockID)+"/context/big_maps/"+id+"/"+michelson.ExprHash(packed), &v); err != nil {
+		if isNotFound(err) {
+			return "", fmt.Errorf("Big map %s has no key `%s'", id, key)
+		}
+		return "", err
+	}
+	if v.Kind != michelson.KindBytes {
+		return "", fmt.Errorf("Big map %s value of `%s' is not bytes", id, key)
+	}
+	return string(v.Bytes), nil
+}
+
+// contractMetadata fetches TZIP-16 metadata referenced by the empty key of the contract's %metadata big map
+func (c *RootContext) contractMetadata(address string, script *contractScript, gateway string) (*tzip16Metadata, error) {
+	var bigMap *michelson.Node
+	if typ := script.section("storage"); typ != nil && script.Storage != nil {
+		bigMap = michelson.Field(script.Storage, typ, "%metadata")
+	}
+	if bigMap == nil || bigMap.Kind != michelson.KindInt {
+		return nil, fmt.Errorf("%s has no on-chain views or TZIP-16 metadata", address)
+	}
+	id := bigMap.Int.String()
+
+	uri, err := c.bigMapString(id, "")
+	if err != nil {
+		return nil, err
+	}
+	log.WithField("uri", uri).Debug("Fetching contract metadata")
+
+	var data []byte
+	switch {
+	case strings.HasPrefix(uri, "tezos-storage:"):
+		loc := strings.TrimPrefix(uri, "tezos-storage:")
+		if strings.HasPrefix(loc, "//") {
+			host := strings.TrimPrefix(loc, "//")
+			i := strings.IndexByte(host, '/')
+			if i < 0 || host[:i] != address {
+				return nil, fmt.Errorf("Metadata stored in another contract is not supported: `%s'", uri)
+			}
+			loc = host[i+1:]
+		}
+		key, err := url.PathUnescape(loc)
+		if err != nil {
+			return nil, err
+		}
+		s, err := c.bigMapString(id, key)
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(s)
+
+	case strings.HasPrefix(uri, "http://"), strings.HasPrefix(uri, "https://"):
+		data, err = download(c.context, uri, maxMetadataSize)
+
+	case strings.HasPrefix(uri, "ipfs://"):
+		data, err = download(c.context, strings.TrimSuffix(gateway, "/")+"/"+strings.TrimPrefix(uri, "ipfs://"), maxMetadataSize)
+
+	default:
+		return nil, fmt.Errorf("Unsupported metadata URI: `%s'", uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta tzip16Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("%s: invalid metadata: %v", uri, err)
+	}
+	return &meta, nil
+}
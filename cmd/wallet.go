@@ -0,0 +1,243 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/ecadlabs/tez/keys"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Key export formats
+const (
+	exportEncryptedJSON = "encrypted-json"
+	exportMnemonic      = "mnemonic"
+	exportSecret        = "secret"
+)
+
+type keyExportOptions struct {
+	format string
+	output string
+	force  bool
+}
+
+func newKeyExportCommand(ctx *RootContext) *cobra.Command {
+	var opt keyExportOptions
+
+	exportCmd := &cobra.Command{
+		Use:   "export <alias>",
+		Short: "Export a secret key",
+		Long: `Export the secret key for use in another wallet.
+
+encrypted-json  keystore entry with the secret key encrypted with a new passphrase, see "key import"
+mnemonic        mnemonic and derivation path of keys imported from a mnemonic
+secret          unencrypted Base58Check secret key as used by tezos-client
+
+Anyone who obtains the exported secret controls the account. Plain text formats require --force.
+The passphrase of an encrypted key is always asked for, the OS keychain is not used.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ctx.exportKey(args[0], &opt)
+		},
+	}
+
+	f := exportCmd.Flags()
+	f.StringVar(&opt.format, "format", exportEncryptedJSON, "Export format: one of [encrypted-json, mnemonic, secret]")
+	f.StringVar(&opt.output, "output", "", "Write to the file readable by the owner only instead of stdout")
+	f.BoolVar(&opt.force, "force", false, "Allow plain text formats")
+
+	return exportCmd
+}
+
+func (c *RootContext) exportKey(alias string, opt *keyExportOptions) error {
+	switch opt.format {
+	case exportEncryptedJSON:
+	case exportMnemonic, exportSecret:
+		if !opt.force {
+			return fmt.Errorf("`%s' format exposes the secret in plain text, use --force if you really want it", opt.format)
+		}
+	default:
+		return fmt.Errorf("Unknown export format: `%s'", opt.format)
+	}
+
+	acc, err := c.account(alias)
+	if err != nil {
+		return err
+	}
+	if acc.WatchOnly() {
+		return fmt.Errorf("`%s' is a watch-only key and has no secret to export", acc.Alias)
+	}
+	passphrase := func() ([]byte, error) {
+		return utils.ReadPassphrase(fmt.Sprintf("Enter passphrase for `%s': ", acc.Alias), false)
+	}
+
+	var out []byte
+	switch opt.format {
+	case exportEncryptedJSON:
+		k, err := acc.Entry.PrivateKey(passphrase)
+		if err != nil {
+			return err
+		}
+		pass, err := utils.ReadPassphrase("Enter export passphrase: ", true)
+		if err != nil {
+			return err
+		}
+		if len(pass) == 0 {
+			return errors.New("Export passphrase is required")
+		}
+		e, err := keys.NewEntry(acc.Alias, k, pass)
+		if err != nil {
+			return err
+		}
+		if out, err = json.MarshalIndent(e, "", "  "); err != nil {
+			return err
+		}
+
+	case exportMnemonic:
+		m, err := acc.RevealMnemonic(passphrase)
+		if err != nil {
+			return err
+		}
+		out = []byte(m + "\n" + acc.DerivationPath)
+		log.Warn("Keys derived with a BIP39 passphrase need it as well")
+
+	case exportSecret:
+		k, err := acc.Entry.PrivateKey(passphrase)
+		if err != nil {
+			return err
+		}
+		out = []byte(k.String())
+	}
+	out = append(out, '\n')
+
+	if opt.format != exportEncryptedJSON {
+		log.WithField("alias", acc.Alias).Warn("Exporting the secret in plain text, anyone who sees it can spend the account's funds")
+	}
+
+	var w io.Writer = os.Stdout
+	if opt.output != "" {
+		path, err := utils.ExpandHome(opt.output)
+		if err != nil {
+			return err
+		}
+		fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		w = fd
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// importKeyJSON decrypts the key exported in encrypted-json format
+func importKeyJSON(src string) (keys.PrivateKey, error) {
+	var e keys.Entry
+	if err := json.Unmarshal([]byte(src), &e); err != nil {
+		return nil, fmt.Errorf("Invalid encrypted-json key: %v", err)
+	}
+	return e.PrivateKey(func() ([]byte, error) {
+		return utils.ReadPassphrase("Enter export passphrase: ", false)
+	})
+}
+
+func newKeyImportClientCommand(ctx *RootContext) *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import-client <base dir|file>...",
+		Short: "Import aliases from tezos-client wallet files",
+		Long: `Import keys from the tezos-client (octez-client) base directory or its secret_keys, public_keys and public_key_hashs files.
+Secret keys are imported as is, so encrypted keys keep their tezos-client passphrase. Aliases without a secret key
+or held by Ledger and remote signers are imported as watch-only keys. Aliases and addresses already in the keystore are skipped.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			w := keys.NewClientWallet()
+			for _, arg := range args {
+				path, err := utils.ExpandHome(arg)
+				if err != nil {
+					return err
+				}
+				st, err := os.Stat(path)
+				if err != nil {
+					return err
+				}
+				if st.IsDir() {
+					dw, err := keys.LoadClientWallet(path)
+					if err != nil {
+						return err
+					}
+					w.Merge(dw)
+				} else if err := w.ReadFile(path); err != nil {
+					return err
+				}
+			}
+			return ctx.importClientWallet(w)
+		},
+	}
+
+	return importCmd
+}
+
+func (c *RootContext) importClientWallet(w *keys.ClientWallet) error {
+	store, err := c.keyStore()
+	if err != nil {
+		return err
+	}
+
+	var added int
+	for _, name := range w.Names {
+		e, err := w.Entry(name)
+		if err != nil {
+			log.WithError(err).WithField("alias", name).Warn("Skipping the alias")
+			continue
+		}
+		if x := store.Lookup(e.Address); x != nil {
+			log.WithFields(log.Fields{
+				"alias":   name,
+				"address": e.Address,
+				"as":      x.Alias,
+			}).Info("Address is already in the keystore")
+			continue
+		}
+		if err := store.Add(e); err != nil {
+			log.WithError(err).Warn("Skipping the alias")
+			continue
+		}
+		log.WithFields(log.Fields{
+			"alias":      e.Alias,
+			"address":    e.Address,
+			"watch_only": e.WatchOnly(),
+		}).Info("Key added")
+		added++
+	}
+	if added == 0 {
+		log.Info("Nothing to import")
+		return nil
+	}
+	return store.Save()
+}
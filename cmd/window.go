@@ -0,0 +1,139 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/ecadlabs/tez/cmd/utils"
+	"github.com/spf13/pflag"
+)
+
+// windowOptions holds --since and --until values
+type windowOptions struct {
+	since string
+	until string
+}
+
+// addWindowFlags registers --since and --until. what names the bounded items, e.g. "blocks produced"
+func addWindowFlags(f *pflag.FlagSet, opt *windowOptions, what string) {
+	f.StringVar(&opt.since, "since", "", "Only output "+what+" at or after the time, given as a timestamp or a duration ago like 30m, 2h or 7d")
+	f.StringVar(&opt.until, "until", "", "Only output "+what+" at or before the time, given as a timestamp or a duration ago like 30m, 2h or 7d")
+}
+
+// timeWindow bounds displayed and exported items by their time, see --since and --until. Nil window is open
+type timeWindow struct {
+	since time.Time
+	until time.Time
+}
+
+// window parses the values, nil means both are empty
+func (o *windowOptions) window() (*timeWindow, error) {
+	if o.since == "" && o.until == "" {
+		return nil, nil
+	}
+	var (
+		w   timeWindow
+		err error
+		now = time.Now()
+	)
+	if o.since != "" {
+		if w.since, err = utils.ParseTimeOrAgo(o.since, now); err != nil {
+			return nil, err
+		}
+	}
+	if o.until != "" {
+		if w.until, err = utils.ParseTimeOrAgo(o.until, now); err != nil {
+			return nil, err
+		}
+	}
+	return &w, nil
+}
+
+// blockRange returns the range of blocks produced in the window, e.g. `@2024-01-01T00:00:00Z..head'.
+// The range starts at the last block produced before --since and ends at to if --until isn't given
+func (w *timeWindow) blockRange(to string) string {
+	from := "1"
+	if !w.since.IsZero() {
+		from = "@" + w.since.Format(time.RFC3339)
+	}
+	if !w.until.IsZero() {
+		to = "@" + w.until.Format(time.RFC3339)
+	}
+	return from + ".." + to
+}
+
+// contains returns true if t is within the window
+func (w *timeWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	return (w.since.IsZero() || !t.Before(w.since)) && !w.ended(t)
+}
+
+// ended returns true if t is past the end of the window
+func (w *timeWindow) ended(t time.Time) bool {
+	return w != nil && !w.until.IsZero() && t.After(w.until)
+}
+
+// listTime formats the time of a list row, relative to now unless wide is set
+func (c *RootContext) listTime(t time.Time, wide bool) string {
+	if wide {
+		return c.localTime(t).Format("2006-01-02 15:04:05")
+	}
+	return utils.FormatAgo(t, time.Now())
+}
+
+// listWidth returns the maximum width of list tables, --wide disables truncation
+func listWidth(wide bool) int {
+	if wide {
+		return 0
+	}
+	return utils.TerminalWidth(os.Stdout)
+}
+
+// windowLevels narrows the level range to the blocks produced in the window. The range starts at the last block
+// produced before --since so blocks still have to be filtered by their timestamps
+func (c *RootContext) windowLevels(w *timeWindow, from, to int) (int, int, error) {
+	if w == nil {
+		return from, to, nil
+	}
+	if !w.since.IsZero() {
+		level, err := c.blockLevelAt(w.since)
+		if err != nil {
+			return 0, 0, err
+		}
+		if level > from {
+			from = level
+		}
+	}
+	if !w.until.IsZero() {
+		level, err := c.blockLevelAt(w.until)
+		if err != nil {
+			return 0, 0, err
+		}
+		if level < to {
+			to = level
+		}
+	}
+	return from, to, nil
+}
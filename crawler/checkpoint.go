@@ -0,0 +1,96 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package crawler
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoint is an append-only file of completed keys, one per line. Appending makes it cheap
+// for long crawls and keeps it consistent if the process is killed
+type Checkpoint struct {
+	mtx  sync.Mutex
+	fd   *os.File
+	done map[string]struct{}
+}
+
+// OpenCheckpoint reads the keys completed so far and opens the file for appending
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Checkpoint{
+		fd:   fd,
+		done: make(map[string]struct{}),
+	}
+	s := bufio.NewScanner(fd)
+	for s.Scan() {
+		if key := s.Text(); key != "" {
+			c.done[key] = struct{}{}
+		}
+	}
+	if err := s.Err(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Len returns the number of completed keys
+func (c *Checkpoint) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.done)
+}
+
+// Done returns true if the key was completed
+func (c *Checkpoint) Done(key string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	_, ok := c.done[key]
+	return ok
+}
+
+func (c *Checkpoint) add(key string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.done[key]; ok {
+		return nil
+	}
+	if _, err := c.fd.WriteString(key + "\n"); err != nil {
+		return err
+	}
+	c.done[key] = struct{}{}
+	return nil
+}
+
+// Close closes the file
+func (c *Checkpoint) Close() error {
+	return c.fd.Close()
+}
@@ -0,0 +1,251 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package crawler runs bulk fetches of chain data (block ranges, per-delegate queries etc.) with bounded concurrency,
+// retries and optional checkpointing so analytical commands don't have to reimplement the fetching logic
+package crawler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults used when the corresponding Crawler field is zero
+const (
+	DefaultRetries    = 3
+	DefaultRetryDelay = time.Second
+)
+
+// Maximum delay between retries of a single item
+const maxRetryDelay = 30 * time.Second
+
+// FetchFunc fetches the item identified by the key. It's called concurrently
+type FetchFunc func(ctx context.Context, key string) (interface{}, error)
+
+// CollectFunc receives the fetched item and its index in the key list. Calls are serialized but not ordered
+type CollectFunc func(i int, key string, v interface{}) error
+
+type permanentError struct {
+	error
+}
+
+// Permanent marks the error as not worth retrying, e.g. a missing block
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// Progress is passed to the progress callback after every processed item
+type Progress struct {
+	Total   int
+	Done    int
+	Skipped int
+	Retries int
+	Elapsed time.Duration
+}
+
+// ETA returns the estimated time left based on the average rate so far
+func (p *Progress) ETA() time.Duration {
+	n := p.Done - p.Skipped
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Elapsed) / float64(n) * float64(p.Total-p.Done))
+}
+
+// Crawler processes a list of keys concurrently. Failed fetches are retried with an exponential backoff,
+// the first error which can't be recovered stops the crawl
+type Crawler struct {
+	// Number of concurrent fetches, at least one
+	Concurrency int
+	// Retries is a number of retries of a failed fetch, negative value disables retries
+	Retries    int
+	RetryDelay time.Duration
+	// Checkpoint is optional. Keys completed in previous runs are skipped and successfully collected keys are recorded
+	Checkpoint *Checkpoint
+	// Progress is an optional callback. Calls are serialized
+	Progress func(p *Progress)
+	Logger   log.FieldLogger
+}
+
+func (c *Crawler) logger() log.FieldLogger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return log.StandardLogger()
+}
+
+func (c *Crawler) retries() int {
+	switch {
+	case c.Retries < 0:
+		return 0
+	case c.Retries == 0:
+		return DefaultRetries
+	default:
+		return c.Retries
+	}
+}
+
+// fetch calls fn retrying temporary errors
+func (c *Crawler) fetch(ctx context.Context, key string, fn FetchFunc, retried func()) (interface{}, error) {
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		v, err := fn(ctx, key)
+		if err == nil {
+			return v, nil
+		}
+		if p, ok := err.(permanentError); ok {
+			return nil, p.error
+		}
+		if attempt >= c.retries() || ctx.Err() != nil {
+			return nil, err
+		}
+
+		c.logger().WithFields(log.Fields{
+			"key":     key,
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).WithError(err).Warn("Fetch failed, retrying")
+		retried()
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// Run fetches all keys and passes results to collect. It returns the first fetch or collect error
+func (c *Crawler) Run(ctx context.Context, keys []string, fetch FetchFunc, collect CollectFunc) error {
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		firstErr error
+		start    = time.Now()
+		progress = Progress{Total: len(keys)}
+	)
+
+	// Must be called with mtx held
+	report := func() {
+		if c.Progress != nil {
+			progress.Elapsed = time.Since(start)
+			p := progress
+			c.Progress(&p)
+		}
+	}
+
+	fail := func(err error) {
+		mtx.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mtx.Unlock()
+	}
+
+	type item struct {
+		i   int
+		key string
+	}
+
+	queue := make(chan item)
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range queue {
+				v, err := c.fetch(ctx, it.key, fetch, func() {
+					mtx.Lock()
+					progress.Retries++
+					mtx.Unlock()
+				})
+				if err != nil {
+					fail(err)
+					continue
+				}
+
+				mtx.Lock()
+				if firstErr == nil {
+					if err = collect(it.i, it.key, v); err == nil && c.Checkpoint != nil {
+						err = c.Checkpoint.add(it.key)
+					}
+					if err != nil {
+						firstErr = err
+						cancel()
+					} else {
+						progress.Done++
+						report()
+					}
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for i, key := range keys {
+		if c.Checkpoint != nil && c.Checkpoint.Done(key) {
+			mtx.Lock()
+			progress.Done++
+			progress.Skipped++
+			report()
+			mtx.Unlock()
+			continue
+		}
+
+		select {
+		case queue <- item{i: i, key: key}:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		// Parent context is done
+		return ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,299 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Env maps identifiers to their values. Supported value types are nil, bool, string, time.Time and numbers
+// (Go integer and float types, *big.Int, *big.Float and *big.Rat)
+type Env map[string]interface{}
+
+// Func is a function callable from the expression
+type Func func(args ...interface{}) (interface{}, error)
+
+// Functions available to every expression
+var builtins = map[string]Func{
+	"contains":   stringPredicate("contains", strings.Contains),
+	"startsWith": stringPredicate("startsWith", strings.HasPrefix),
+	"endsWith":   stringPredicate("endsWith", strings.HasSuffix),
+	"lower": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("filter: lower() takes exactly one argument")
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("filter: lower() expects a string, got %s", typeName(args[0]))
+		}
+		return strings.ToLower(s), nil
+	},
+}
+
+func stringPredicate(name string, fn func(s, x string) bool) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("filter: %s() takes exactly two arguments", name)
+		}
+		s, ok1 := args[0].(string)
+		x, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("filter: %s() expects strings", name)
+		}
+		return fn(s, x), nil
+	}
+}
+
+// normalize converts the value to one of nil, bool, string or *big.Rat
+func normalize(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil, bool, string:
+		return x, nil
+	case *big.Rat:
+		if x == nil {
+			return nil, nil
+		}
+		return x, nil
+	case *big.Int:
+		if x == nil {
+			return nil, nil
+		}
+		return new(big.Rat).SetInt(x), nil
+	case *big.Float:
+		if x == nil {
+			return nil, nil
+		}
+		r, _ := x.Rat(nil)
+		return r, nil
+	case int:
+		return new(big.Rat).SetInt64(int64(x)), nil
+	case int64:
+		return new(big.Rat).SetInt64(x), nil
+	case uint64:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(x)), nil
+	case float64:
+		r := new(big.Rat)
+		if r.SetFloat64(x) == nil {
+			return nil, fmt.Errorf("filter: invalid number %v", x)
+		}
+		return r, nil
+	case time.Time:
+		if x.IsZero() {
+			return nil, nil
+		}
+		return x.UTC().Format(time.RFC3339), nil
+	}
+	return nil, fmt.Errorf("filter: unsupported value type %T", v)
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case *big.Rat:
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// Eval evaluates the expression
+func (e *Expr) Eval(env Env) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+// Match evaluates the expression which must produce a boolean
+func (e *Expr) Match(env Env) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter: expression must evaluate to bool, got %s", typeName(v))
+	}
+	return b, nil
+}
+
+func (n *literal) eval(env Env) (interface{}, error) {
+	return n.val, nil
+}
+
+func (n *ident) eval(env Env) (interface{}, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("filter: unknown identifier `%s'", n.name)
+	}
+	return normalize(v)
+}
+
+func (n *call) eval(env Env) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	v, err := n.fn(args...)
+	if err != nil {
+		return nil, err
+	}
+	return normalize(v)
+}
+
+func (n *unary) eval(env Env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "!":
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case "-":
+		if r, ok := x.(*big.Rat); ok {
+			return new(big.Rat).Neg(r), nil
+		}
+	}
+	return nil, fmt.Errorf("filter: invalid operand type for `%s': %s", n.op, typeName(x))
+}
+
+func (n *binary) eval(env Env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit
+	if n.op == "&&" || n.op == "||" {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("filter: invalid operand type for `%s': %s", n.op, typeName(x))
+		}
+		if b == (n.op == "||") {
+			return b, nil
+		}
+		y, err := n.y.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := y.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("filter: invalid operand type for `%s': %s", n.op, typeName(y))
+	}
+
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(x, y), nil
+	case "!=":
+		return !equal(x, y), nil
+	case "<", "<=", ">", ">=":
+		// Missing values never match
+		if x == nil || y == nil {
+			return false, nil
+		}
+		c, err := compare(x, y, n.op)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+
+	xr, ok1 := x.(*big.Rat)
+	yr, ok2 := y.(*big.Rat)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("filter: invalid operand types for `%s': %s and %s", n.op, typeName(x), typeName(y))
+	}
+
+	switch n.op {
+	case "+":
+		return new(big.Rat).Add(xr, yr), nil
+	case "-":
+		return new(big.Rat).Sub(xr, yr), nil
+	case "*":
+		return new(big.Rat).Mul(xr, yr), nil
+	case "/":
+		if yr.Sign() == 0 {
+			return nil, errors.New("filter: division by zero")
+		}
+		return new(big.Rat).Quo(xr, yr), nil
+	}
+
+	return nil, fmt.Errorf("filter: unknown operator `%s'", n.op)
+}
+
+func equal(x, y interface{}) bool {
+	switch a := x.(type) {
+	case nil:
+		return y == nil
+	case bool:
+		b, ok := y.(bool)
+		return ok && a == b
+	case string:
+		b, ok := y.(string)
+		return ok && a == b
+	case *big.Rat:
+		b, ok := y.(*big.Rat)
+		return ok && a.Cmp(b) == 0
+	}
+	return false
+}
+
+func compare(x, y interface{}, op string) (int, error) {
+	switch a := x.(type) {
+	case string:
+		if b, ok := y.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case *big.Rat:
+		if b, ok := y.(*big.Rat); ok {
+			return a.Cmp(b), nil
+		}
+	}
+	return 0, fmt.Errorf("filter: invalid operand types for `%s': %s and %s", op, typeName(x), typeName(y))
+}
@@ -0,0 +1,377 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package filter
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+// SyntaxError is returned by Compile
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("filter: syntax error at %d: %s", e.Pos, e.Msg)
+}
+
+// Longer operators go first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "(", ")", ","}
+
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' }
+
+func scan(src string) ([]*token, error) {
+	var (
+		tokens []*token
+		pos    int
+	)
+
+next:
+	for {
+		for pos < len(src) && strings.IndexByte(" \t\r\n", src[pos]) >= 0 {
+			pos++
+		}
+		if pos == len(src) {
+			return append(tokens, &token{kind: tokEOF, pos: pos}), nil
+		}
+
+		start := pos
+		c := src[pos]
+
+		switch {
+		case isDigit(c):
+			for pos < len(src) && (isDigit(src[pos]) || src[pos] == '.') {
+				pos++
+			}
+			tokens = append(tokens, &token{kind: tokNumber, val: src[start:pos], pos: start})
+			continue next
+
+		case isLetter(c):
+			for pos < len(src) && (isLetter(src[pos]) || isDigit(src[pos])) {
+				pos++
+			}
+			tokens = append(tokens, &token{kind: tokIdent, val: src[start:pos], pos: start})
+			continue next
+
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			pos++
+			for {
+				if pos == len(src) {
+					return nil, &SyntaxError{start, "unterminated string"}
+				}
+				ch := src[pos]
+				pos++
+				if ch == c {
+					break
+				}
+				if ch == '\\' {
+					if pos == len(src) {
+						return nil, &SyntaxError{start, "unterminated string"}
+					}
+					e := src[pos]
+					pos++
+					switch e {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					case '\\', '"', '\'':
+						b.WriteByte(e)
+					default:
+						return nil, &SyntaxError{pos - 2, "invalid escape sequence"}
+					}
+					continue
+				}
+				b.WriteByte(ch)
+			}
+			tokens = append(tokens, &token{kind: tokString, val: b.String(), pos: start})
+			continue next
+		}
+
+		for _, op := range operators {
+			if strings.HasPrefix(src[pos:], op) {
+				pos += len(op)
+				tokens = append(tokens, &token{kind: tokOp, val: op, pos: start})
+				continue next
+			}
+		}
+
+		return nil, &SyntaxError{start, fmt.Sprintf("unexpected character `%c'", c)}
+	}
+}
+
+// Expression tree
+type node interface {
+	eval(env Env) (interface{}, error)
+}
+
+type literal struct{ val interface{} }
+
+type ident struct {
+	name string
+}
+
+type call struct {
+	name string
+	fn   Func
+	args []node
+}
+
+type unary struct {
+	op string
+	x  node
+}
+
+type binary struct {
+	op   string
+	x, y node
+}
+
+type parser struct {
+	tokens []*token
+	pos    int
+	funcs  map[string]Func
+}
+
+func (p *parser) peek() *token { return p.tokens[p.pos] }
+
+func (p *parser) advance() *token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if t.val == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		return &SyntaxError{p.peek().pos, fmt.Sprintf("`%s' expected", op)}
+	}
+	p.advance()
+	return nil
+}
+
+// Binary operators by precedence, lowest first
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp(precedence[level]...) {
+		op := p.advance().val
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{op: op, x: x, y: y}
+	}
+
+	return x, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!", "-") {
+		op := p.advance().val
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokNumber:
+		v, ok := new(big.Rat).SetString(t.val)
+		if !ok {
+			return nil, &SyntaxError{t.pos, "invalid number"}
+		}
+		return &literal{v}, nil
+
+	case tokString:
+		return &literal{t.val}, nil
+
+	case tokIdent:
+		switch t.val {
+		case "true":
+			return &literal{true}, nil
+		case "false":
+			return &literal{false}, nil
+		case "null":
+			return &literal{nil}, nil
+		}
+
+		if !p.isOp("(") {
+			return &ident{name: t.val}, nil
+		}
+		p.advance()
+
+		fn, ok := p.funcs[t.val]
+		if !ok {
+			return nil, &SyntaxError{t.pos, fmt.Sprintf("unknown function `%s'", t.val)}
+		}
+
+		c := call{name: t.val, fn: fn}
+		for !p.isOp(")") {
+			if len(c.args) != 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+		}
+		p.advance()
+		return &c, nil
+
+	case tokOp:
+		if t.val == "(" {
+			x, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	}
+
+	return nil, &SyntaxError{t.pos, "unexpected token"}
+}
+
+// Expr is a compiled filter expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses the expression. Functions available to the expression are taken from funcs in addition to the built-in ones
+func Compile(src string, funcs map[string]Func) (*Expr, error) {
+	tokens, err := scan(src)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]Func, len(builtins)+len(funcs))
+	for name, fn := range builtins {
+		all[name] = fn
+	}
+	for name, fn := range funcs {
+		all[name] = fn
+	}
+
+	p := parser{tokens: tokens, funcs: all}
+	root, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &SyntaxError{t.pos, "unexpected token"}
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Identifiers returns names of all variables referenced by the expression
+func (e *Expr) Identifiers() []string {
+	var (
+		names []string
+		seen  = make(map[string]struct{})
+		walk  func(n node)
+	)
+
+	walk = func(n node) {
+		switch x := n.(type) {
+		case *ident:
+			if _, ok := seen[x.name]; !ok {
+				seen[x.name] = struct{}{}
+				names = append(names, x.name)
+			}
+		case *call:
+			for _, a := range x.args {
+				walk(a)
+			}
+		case *unary:
+			walk(x.x)
+		case *binary:
+			walk(x.x)
+			walk(x.y)
+		}
+	}
+	walk(e.root)
+
+	return names
+}
@@ -0,0 +1,64 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package forge
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/protocol"
+)
+
+// ActivationSecretSize is the length of the fundraiser activation code
+const ActivationSecretSize = 20
+
+// ActivateAccount claims the fundraiser commitment of an Ed25519 account
+type ActivateAccount struct {
+	PublicKeyHash string
+	Secret        []byte
+}
+
+// Kind returns the operation kind
+func (a *ActivateAccount) Kind() string { return protocol.KindActivateAccount }
+
+// AppendBinary appends forged operation to the buffer
+func (a *ActivateAccount) AppendBinary(buf []byte) ([]byte, error) {
+	pkh, err := base58.PrefixEd25519PublicKeyHash.Decode(a.PublicKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(a.Secret) != ActivationSecretSize {
+		return nil, fmt.Errorf("forge: activation secret must be %d bytes long", ActivationSecretSize)
+	}
+	buf = append(append(buf, tagActivateAccount), pkh...)
+	return append(buf, a.Secret...), nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (a *ActivateAccount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"kind":   a.Kind(),
+		"pkh":    a.PublicKeyHash,
+		"secret": hex.EncodeToString(a.Secret),
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package forge
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/protocol"
+)
+
+// Attestation is a Tenderbake (pre)attestation of a block payload by a member of the consensus committee.
+// Bakers produce these on their own, the type exists for testing consensus on sandboxes
+type Attestation struct {
+	Preattestation   bool
+	Slot             uint16
+	Level            int32
+	Round            int32
+	BlockPayloadHash string
+}
+
+// Kind returns the operation kind
+func (a *Attestation) Kind() string {
+	if a.Preattestation {
+		return protocol.KindPreattestation
+	}
+	return protocol.KindAttestation
+}
+
+// AppendBinary appends forged operation to the buffer
+func (a *Attestation) AppendBinary(buf []byte) ([]byte, error) {
+	payload, err := base58.PrefixBlockPayloadHash.Decode(a.BlockPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	tag := byte(tagAttestation)
+	if a.Preattestation {
+		tag = tagPreattestation
+	}
+	var tmp [10]byte
+	binary.BigEndian.PutUint16(tmp[0:], a.Slot)
+	binary.BigEndian.PutUint32(tmp[2:], uint32(a.Level))
+	binary.BigEndian.PutUint32(tmp[6:], uint32(a.Round))
+	buf = append(append(buf, tag), tmp[:]...)
+	return append(buf, payload...), nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (a *Attestation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"kind":               a.Kind(),
+		"slot":               a.Slot,
+		"level":              a.Level,
+		"round":              a.Round,
+		"block_payload_hash": a.BlockPayloadHash,
+	})
+}
@@ -0,0 +1,202 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package forge implements the binary encoding of operations used for signing and injection.
+// JSON encoding of the same types matches the node's RPC format so they can be simulated before signing
+package forge
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ecadlabs/tez/base58"
+)
+
+// Operation tags used since Babylon
+const (
+	tagActivateAccount    = 4
+	tagPreattestation     = 20
+	tagAttestation        = 21
+	tagReveal             = 107
+	tagTransaction        = 108
+	tagOrigination        = 109
+	tagDelegation         = 110
+	tagSetDepositsLimit   = 112
+	tagUpdateConsensusKey = 114
+)
+
+var (
+	implicitPrefixes = []*base58.Prefix{
+		base58.PrefixEd25519PublicKeyHash,
+		base58.PrefixSecp256k1PublicKeyHash,
+		base58.PrefixP256PublicKeyHash,
+		base58.PrefixBLS12381PublicKeyHash,
+	}
+
+	publicKeyPrefixes = []*base58.Prefix{
+		base58.PrefixEd25519PublicKey,
+		base58.PrefixSecp256k1PublicKey,
+		base58.PrefixP256PublicKey,
+		base58.PrefixBLS12381PublicKey,
+	}
+)
+
+func prefixIndex(list []*base58.Prefix, p *base58.Prefix) int {
+	for i, x := range list {
+		if x == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// Operation is a single element of the operation contents
+type Operation interface {
+	Kind() string
+	AppendBinary(buf []byte) ([]byte, error)
+}
+
+// ManagerOperation is an operation paid by an implicit account
+type ManagerOperation interface {
+	Operation
+	ManagerFields() *Manager
+}
+
+func appendNat(buf []byte, v *big.Int) ([]byte, error) {
+	if v == nil {
+		v = new(big.Int)
+	}
+	if v.Sign() < 0 {
+		return nil, fmt.Errorf("forge: negative value %v", v)
+	}
+	var x big.Int
+	x.Set(v)
+	for {
+		b := byte(x.Uint64() & 0x7f)
+		x.Rsh(&x, 7)
+		if x.Sign() == 0 {
+			return append(buf, b), nil
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func appendPublicKeyHash(buf []byte, s string) ([]byte, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	i := prefixIndex(implicitPrefixes, p)
+	if i < 0 {
+		return nil, fmt.Errorf("forge: `%s' is not an implicit account address", s)
+	}
+	return append(append(buf, byte(i)), payload...), nil
+}
+
+func appendPublicKey(buf []byte, s string) ([]byte, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	i := prefixIndex(publicKeyPrefixes, p)
+	if i < 0 {
+		return nil, fmt.Errorf("forge: `%s' is not a public key", s)
+	}
+	return append(append(buf, byte(i)), payload...), nil
+}
+
+func appendContractID(buf []byte, s string) ([]byte, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	if p == base58.PrefixContractHash {
+		return append(append(append(buf, 1), payload...), 0), nil
+	}
+	if prefixIndex(implicitPrefixes, p) < 0 {
+		return nil, fmt.Errorf("forge: `%s' is not a contract address", s)
+	}
+	return appendPublicKeyHash(append(buf, 0), s)
+}
+
+func mutez(v *big.Int) string {
+	if v == nil {
+		return "0"
+	}
+	return v.String()
+}
+
+// Manager contains fields common to all manager operations
+type Manager struct {
+	Source       string
+	Fee          *big.Int
+	Counter      *big.Int
+	GasLimit     *big.Int
+	StorageLimit *big.Int
+}
+
+// ManagerFields returns a pointer to the common fields
+func (m *Manager) ManagerFields() *Manager {
+	return m
+}
+
+func (m *Manager) appendBinary(buf []byte) ([]byte, error) {
+	buf, err := appendPublicKeyHash(buf, m.Source)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range []*big.Int{m.Fee, m.Counter, m.GasLimit, m.StorageLimit} {
+		if buf, err = appendNat(buf, v); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (m *Manager) fields(kind string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":          kind,
+		"source":        m.Source,
+		"fee":           mutez(m.Fee),
+		"counter":       mutez(m.Counter),
+		"gas_limit":     mutez(m.GasLimit),
+		"storage_limit": mutez(m.StorageLimit),
+	}
+}
+
+// Encode returns forged operation bytes ready for signing
+func Encode(branch string, ops []Operation) ([]byte, error) {
+	buf, err := base58.PrefixBlockHash.Decode(branch)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if buf, err = op.AppendBinary(buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Size returns the size of a single forged operation content
+func Size(op Operation) (int, error) {
+	buf, err := op.AppendBinary(nil)
+	return len(buf), err
+}
@@ -0,0 +1,127 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package forge
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+const (
+	testBranch    = "BLockGenesisGenesisGenesisGenesisGenesisf79b5d1CoW2"
+	testSource    = "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"
+	testPublicKey = "edpkuBknW28nW72KG6RoHtYW7p12T6GKc7nAbwYX5m8Wd9sDVC9yav"
+
+	// Payloads of the above
+	testBranchHex    = "8fcf233671b6a04fcf679d2a381c2544ea6c1ea29ba6157776ed8424c7ccd00b"
+	testSourceHex    = "02298c03ed7d454a101eb7022bc95f7e5f41ac78"
+	testPublicKeyHex = "4798d2cc98473d7e250c898885718afd2e4efbcb1a1595ab9730761ed830de0f"
+)
+
+func testManager() Manager {
+	return Manager{
+		Source:       testSource,
+		Fee:          big.NewInt(1420),
+		Counter:      big.NewInt(2),
+		GasLimit:     big.NewInt(1100),
+		StorageLimit: big.NewInt(0),
+	}
+}
+
+// Source, fee 1420, counter 2, gas limit 1100 and storage limit 0 as zarith numbers
+const testManagerHex = "00" + testSourceHex + "8c0b" + "02" + "cc08" + "00"
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []Operation
+		want string
+	}{
+		{
+			name: "delegation",
+			ops:  []Operation{&Delegation{Manager: testManager(), Delegate: testSource}},
+			want: testBranchHex + "6e" + testManagerHex + "ff" + "00" + testSourceHex,
+		},
+		{
+			name: "withdrawal",
+			ops:  []Operation{&Delegation{Manager: testManager()}},
+			want: testBranchHex + "6e" + testManagerHex + "00",
+		},
+		{
+			name: "reveal and delegation",
+			ops: []Operation{
+				&Reveal{Manager: testManager(), PublicKey: testPublicKey},
+				&Delegation{Manager: testManager(), Delegate: testSource},
+			},
+			want: testBranchHex + "6b" + testManagerHex + "00" + testPublicKeyHex + "6e" + testManagerHex + "ff" + "00" + testSourceHex,
+		},
+	}
+
+	for _, tt := range tests {
+		buf, err := Encode(testBranch, tt.ops)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if s := hex.EncodeToString(buf); s != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, s, tt.want)
+		}
+	}
+
+	bad := []Operation{&Delegation{Manager: testManager(), Delegate: "KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn"}}
+	if _, err := Encode(testBranch, bad); err == nil {
+		t.Error("contract delegate accepted")
+	}
+	m := testManager()
+	m.Fee = big.NewInt(-1)
+	if _, err := Encode(testBranch, []Operation{&Delegation{Manager: m}}); err == nil {
+		t.Error("negative fee accepted")
+	}
+}
+
+func TestDelegationJSON(t *testing.T) {
+	buf, err := json.Marshal(&Delegation{Manager: testManager()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"kind":          "delegation",
+		"source":        testSource,
+		"fee":           "1420",
+		"counter":       "2",
+		"gas_limit":     "1100",
+		"storage_limit": "0",
+	}
+	if len(v) != len(want) {
+		t.Errorf("got %s", buf)
+	}
+	for k, x := range want {
+		if v[k] != x {
+			t.Errorf("%s: got %v, want %v", k, v[k], x)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package forge
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ecadlabs/tez/base58"
+	"github.com/ecadlabs/tez/michelson"
+	"github.com/ecadlabs/tez/protocol"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Reveal publishes the public key of an implicit account
+type Reveal struct {
+	Manager
+	PublicKey string
+}
+
+// Kind returns the operation kind
+func (r *Reveal) Kind() string { return protocol.KindReveal }
+
+// AppendBinary appends forged operation to the buffer
+func (r *Reveal) AppendBinary(buf []byte) ([]byte, error) {
+	buf, err := r.Manager.appendBinary(append(buf, tagReveal))
+	if err != nil {
+		return nil, err
+	}
+	return appendPublicKey(buf, r.PublicKey)
+}
+
+// MarshalJSON implements json.Marshaler
+func (r *Reveal) MarshalJSON() ([]byte, error) {
+	f := r.fields(r.Kind())
+	f["public_key"] = r.PublicKey
+	return json.Marshal(f)
+}
+
+// Delegation sets the delegate of the source account. Empty delegate withdraws the delegation
+type Delegation struct {
+	Manager
+	Delegate string
+}
+
+// Kind returns the operation kind
+func (d *Delegation) Kind() string { return protocol.KindDelegation }
+
+// AppendBinary appends forged operation to the buffer
+func (d *Delegation) AppendBinary(buf []byte) ([]byte, error) {
+	buf, err := d.Manager.appendBinary(append(buf, tagDelegation))
+	if err != nil {
+		return nil, err
+	}
+	if d.Delegate == "" {
+		return append(buf, 0), nil
+	}
+	return appendPublicKeyHash(append(buf, 0xff), d.Delegate)
+}
+
+// MarshalJSON implements json.Marshaler
+func (d *Delegation) MarshalJSON() ([]byte, error) {
+	f := d.fields(d.Kind())
+	if d.Delegate != "" {
+		f["delegate"] = d.Delegate
+	}
+	return json.Marshal(f)
+}
+
+// UpdateConsensusKey sets the key used by the delegate to sign blocks and attestations
+type UpdateConsensusKey struct {
+	Manager
+	PublicKey string
+}
+
+// Kind returns the operation kind
+func (u *UpdateConsensusKey) Kind() string { return protocol.KindUpdateConsensusKey }
+
+// AppendBinary appends forged operation to the buffer
+func (u *UpdateConsensusKey) AppendBinary(buf []byte) ([]byte, error) {
+	buf, err := u.Manager.appendBinary(append(buf, tagUpdateConsensusKey))
+	if err != nil {
+		return nil, err
+	}
+	return appendPublicKey(buf, u.PublicKey)
+}
+
+// MarshalJSON implements json.Marshaler
+func (u *UpdateConsensusKey) MarshalJSON() ([]byte, error) {
+	f := u.fields(u.Kind())
+	f["pk"] = u.PublicKey
+	return json.Marshal(f)
+}
+
+// Entrypoints with the short binary encoding
+var entrypointTags = map[string]byte{
+	"default":                 0,
+	"root":                    1,
+	"do":                      2,
+	"set_delegate":            3,
+	"remove_delegate":         4,
+	"deposit":                 5,
+	"stake":                   6,
+	"unstake":                 7,
+	"finalize_unstake":        8,
+	"set_delegate_parameters": 9,
+}
+
+// Transaction transfers tokens and optionally calls an entrypoint of the destination
+type Transaction struct {
+	Manager
+	Amount      *big.Int
+	Destination string
+	// Empty entrypoint means default
+	Entrypoint string
+	Parameters *michelson.Node
+}
+
+// Kind returns the operation kind
+func (t *Transaction) Kind() string { return protocol.KindTransaction }
+
+func (t *Transaction) entrypoint() string {
+	if t.Entrypoint == "" {
+		return "default"
+	}
+	return t.Entrypoint
+}
+
+// AppendBinary appends forged operation to the buffer
+func (t *Transaction) AppendBinary(buf []byte) ([]byte, error) {
+	buf, err := t.Manager.appendBinary(append(buf, tagTransaction))
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = appendNat(buf, t.Amount); err != nil {
+		return nil, err
+	}
+	if buf, err = appendContractID(buf, t.Destination); err != nil {
+		return nil, err
+	}
+
+	if t.Parameters == nil {
+		return append(buf, 0), nil
+	}
+	buf = append(buf, 0xff)
+	ep := t.entrypoint()
+	if tag, ok := entrypointTags[ep]; ok {
+		buf = append(buf, tag)
+	} else {
+		if len(ep) > 31 {
+			return nil, fmt.Errorf("forge: entrypoint name is too long: `%s'", ep)
+		}
+		buf = append(append(buf, 0xff, byte(len(ep))), ep...)
+	}
+	value, err := t.Parameters.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(value)))
+	return append(append(buf, l[:]...), value...), nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	f := t.fields(t.Kind())
+	f["amount"] = mutez(t.Amount)
+	f["destination"] = t.Destination
+	if t.Parameters != nil {
+		f["parameters"] = map[string]interface{}{
+			"entrypoint": t.entrypoint(),
+			"value":      t.Parameters,
+		}
+	}
+	return json.Marshal(f)
+}
+
+// Origination deploys a smart contract
+type Origination struct {
+	Manager
+	Balance *big.Int
+	// Empty delegate means none
+	Delegate string
+	Code     *michelson.Node
+	Storage  *michelson.Node
+}
+
+// Kind returns the operation kind
+func (o *Origination) Kind() string { return protocol.KindOrigination }
+
+// AppendBinary appends forged operation to the buffer
+func (o *Origination) AppendBinary(buf []byte) ([]byte, error) {
+	buf, err := o.Manager.appendBinary(append(buf, tagOrigination))
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = appendNat(buf, o.Balance); err != nil {
+		return nil, err
+	}
+	if o.Delegate == "" {
+		buf = append(buf, 0)
+	} else if buf, err = appendPublicKeyHash(append(buf, 0xff), o.Delegate); err != nil {
+		return nil, err
+	}
+	for _, n := range []*michelson.Node{o.Code, o.Storage} {
+		value, err := n.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(value)))
+		buf = append(append(buf, l[:]...), value...)
+	}
+	return buf, nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (o *Origination) MarshalJSON() ([]byte, error) {
+	f := o.fields(o.Kind())
+	f["balance"] = mutez(o.Balance)
+	if o.Delegate != "" {
+		f["delegate"] = o.Delegate
+	}
+	f["script"] = map[string]interface{}{
+		"code":    o.Code,
+		"storage": o.Storage,
+	}
+	return json.Marshal(f)
+}
+
+// ContractAddress returns the address of the contract originated by the operation.
+// Index counts originations within the operation including internal ones, starting from 0
+func ContractAddress(opHash string, index uint32) (string, error) {
+	h, err := base58.PrefixOperationHash.Decode(opHash)
+	if err != nil {
+		return "", err
+	}
+	var idx [4]byte
+	binary.BigEndian.PutUint32(idx[:], index)
+	d, _ := blake2b.New(20, nil)
+	d.Write(h)
+	d.Write(idx[:])
+	return base58.PrefixContractHash.Encode(d.Sum(nil))
+}
+
+// SetDepositsLimit caps the delegate's frozen deposits. Nil limit removes the cap
+type SetDepositsLimit struct {
+	Manager
+	Limit *big.Int
+}
+
+// Kind returns the operation kind
+func (s *SetDepositsLimit) Kind() string { return protocol.KindSetDepositsLimit }
+
+// AppendBinary appends forged operation to the buffer
+func (s *SetDepositsLimit) AppendBinary(buf []byte) ([]byte, error) {
+	buf, err := s.Manager.appendBinary(append(buf, tagSetDepositsLimit))
+	if err != nil {
+		return nil, err
+	}
+	if s.Limit == nil {
+		return append(buf, 0), nil
+	}
+	return appendNat(append(buf, 0xff), s.Limit)
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *SetDepositsLimit) MarshalJSON() ([]byte, error) {
+	f := s.fields(s.Kind())
+	if s.Limit != nil {
+		f["limit"] = s.Limit.String()
+	}
+	return json.Marshal(f)
+}
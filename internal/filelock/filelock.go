@@ -0,0 +1,58 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package filelock takes advisory locks shared by concurrent tez processes
+package filelock
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Lock is an exclusive lock of a file
+type Lock struct {
+	fd *os.File
+}
+
+// Acquire blocks until the exclusive lock of the file is taken. The file and its directory are created if missing.
+// Locks are held per open file, so a process must not acquire the same lock twice
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock(fd); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &Lock{fd: fd}, nil
+}
+
+// Release releases the lock
+func (l *Lock) Release() error {
+	if err := unlock(l.fd); err != nil {
+		l.fd.Close()
+		return err
+	}
+	return l.fd.Close()
+}
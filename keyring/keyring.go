@@ -0,0 +1,88 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package keyring stores secrets in the OS keychain (macOS Keychain, Secret Service, Windows Credential Manager) or in a file
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	oskeyring "github.com/zalando/go-keyring"
+)
+
+// Service is the name secrets are stored under in the OS keychain
+const Service = "tez"
+
+// RefPrefix marks configuration values which are references to stored secrets, e.g. `keyring:mainnet-token'
+const RefPrefix = "keyring:"
+
+// ErrNotFound is returned if the secret doesn't exist
+var ErrNotFound = errors.New("keyring: secret not found")
+
+// Store is a named secrets storage
+type Store interface {
+	Get(name string) (string, error)
+	Set(name, value string) error
+	Delete(name string) error
+}
+
+type osStore struct {
+	service string
+}
+
+// OS returns the store backed by the OS keychain
+func OS(service string) Store {
+	return &osStore{service: service}
+}
+
+func (s *osStore) Get(name string) (string, error) {
+	v, err := oskeyring.Get(s.service, name)
+	if err == oskeyring.ErrNotFound {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+
+func (s *osStore) Set(name, value string) error {
+	return oskeyring.Set(s.service, name, value)
+}
+
+func (s *osStore) Delete(name string) error {
+	err := oskeyring.Delete(s.service, name)
+	if err == oskeyring.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Resolve returns the stored secret if the value is a reference or the value itself otherwise
+func Resolve(s Store, value string) (string, error) {
+	if !strings.HasPrefix(value, RefPrefix) {
+		return value, nil
+	}
+	name := strings.TrimPrefix(value, RefPrefix)
+	v, err := s.Get(name)
+	if err == ErrNotFound {
+		return "", fmt.Errorf("keyring: secret `%s' not found", name)
+	}
+	return v, err
+}
@@ -0,0 +1,176 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+
+	"github.com/ecadlabs/tez/base58"
+	bls12381 "github.com/kilic/bls12-381"
+)
+
+// tz4 keys use the min-pk variant (G1 public keys, G2 signatures) of the message augmentation scheme
+var blsDST = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_AUG_")
+
+const (
+	blsPublicKeySize = 48
+	blsSecretKeySize = 32
+	blsSignatureSize = 96
+)
+
+type blsPublicKey []byte
+
+func (k blsPublicKey) String() string {
+	s, _ := base58.PrefixBLS12381PublicKey.Encode(k)
+	return s
+}
+
+func (k blsPublicKey) Hash() string {
+	return publicKeyHash(base58.PrefixBLS12381PublicKeyHash, k)
+}
+
+func (k blsPublicKey) Bytes() []byte {
+	return append([]byte{tagBLS12381}, k...)
+}
+
+// Verify checks the signature of the message. BLS keys sign the message itself rather than its digest
+func (k blsPublicKey) Verify(msg []byte, sig *Signature) bool {
+	return VerifyAggregate([]PublicKey{k}, [][]byte{msg}, sig)
+}
+
+func (k blsPublicKey) signsMessage() {}
+
+// blsHash maps the message augmented by the signer's public key to G2
+func blsHash(pk blsPublicKey, msg []byte) (*bls12381.PointG2, error) {
+	return bls12381.NewG2().HashToCurve(append(append([]byte{}, pk...), msg...), blsDST)
+}
+
+type blsPrivateKey struct {
+	scalar *big.Int
+	public blsPublicKey
+}
+
+func newBLSPrivateKey(d []byte) (PrivateKey, error) {
+	if len(d) != blsSecretKeySize {
+		return nil, errors.New("keys: invalid BLS12-381 secret key")
+	}
+	// Secret scalars are serialized in little endian order
+	x := new(big.Int).SetBytes(reversed(d))
+	g1 := bls12381.NewG1()
+	if x.Sign() == 0 || x.Cmp(g1.Q()) >= 0 {
+		return nil, errors.New("keys: invalid BLS12-381 secret key")
+	}
+	p := g1.MulScalarBig(g1.New(), g1.One(), x)
+	return &blsPrivateKey{scalar: x, public: g1.ToCompressed(p)}, nil
+}
+
+func generateBLSPrivateKey() (PrivateKey, error) {
+	x, err := rand.Int(rand.Reader, new(big.Int).Sub(bls12381.NewG1().Q(), big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	x.Add(x, big.NewInt(1))
+	return newBLSPrivateKey(reversed(x.FillBytes(make([]byte, blsSecretKeySize))))
+}
+
+func reversed(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, x := range b {
+		out[len(b)-1-i] = x
+	}
+	return out
+}
+
+func (k *blsPrivateKey) String() string {
+	s, _ := base58.PrefixBLS12381SecretKey.Encode(reversed(k.scalar.FillBytes(make([]byte, blsSecretKeySize))))
+	return s
+}
+
+func (k *blsPrivateKey) Public() PublicKey {
+	return k.public
+}
+
+// Sign signs the message. BLS keys sign the message itself rather than its digest
+func (k *blsPrivateKey) Sign(msg []byte) (*Signature, error) {
+	h, err := blsHash(k.public, msg)
+	if err != nil {
+		return nil, err
+	}
+	g2 := bls12381.NewG2()
+	s := g2.MulScalarBig(g2.New(), h, k.scalar)
+	return &Signature{Prefix: base58.PrefixBLS12381Signature, Bytes: g2.ToCompressed(s)}, nil
+}
+
+func (k *blsPrivateKey) signsMessage() {}
+
+// AggregateSignatures combines BLS signatures into one which is verified against all signers at once
+func AggregateSignatures(sigs []*Signature) (*Signature, error) {
+	if len(sigs) == 0 {
+		return nil, errors.New("keys: nothing to aggregate")
+	}
+	g2 := bls12381.NewG2()
+	acc := g2.Zero()
+	for _, sig := range sigs {
+		if sig.Prefix != base58.PrefixBLS12381Signature && sig.Prefix != base58.PrefixGenericSignature {
+			return nil, errors.New("keys: not a BLS12-381 signature")
+		}
+		p, err := g2.FromCompressed(sig.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		g2.Add(acc, acc, p)
+	}
+	return &Signature{Prefix: base58.PrefixBLS12381Signature, Bytes: g2.ToCompressed(acc)}, nil
+}
+
+// VerifyAggregate checks the aggregated signature of the messages, one per tz4 public key
+func VerifyAggregate(pubs []PublicKey, msgs [][]byte, sig *Signature) bool {
+	if len(pubs) == 0 || len(pubs) != len(msgs) || len(sig.Bytes) != blsSignatureSize ||
+		(sig.Prefix != base58.PrefixBLS12381Signature && sig.Prefix != base58.PrefixGenericSignature) {
+		return false
+	}
+	g1, g2 := bls12381.NewG1(), bls12381.NewG2()
+	s, err := g2.FromCompressed(sig.Bytes)
+	if err != nil {
+		return false
+	}
+	// e(g1, sig) == Π e(pk_i, H(pk_i || msg_i))
+	e := bls12381.NewEngine()
+	for i, pub := range pubs {
+		pk, ok := pub.(blsPublicKey)
+		if !ok {
+			return false
+		}
+		p, err := g1.FromCompressed(pk)
+		if err != nil || g1.IsZero(p) {
+			return false
+		}
+		h, err := blsHash(pk, msgs[i])
+		if err != nil {
+			return false
+		}
+		e.AddPair(p, h)
+	}
+	e.AddPairInv(g1.One(), s)
+	return e.Check()
+}
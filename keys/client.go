@@ -0,0 +1,272 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ecadlabs/tez/internal/atomicfile"
+)
+
+// File names of the tezos-client (octez-client) wallet
+const (
+	ClientSecretKeys      = "secret_keys"
+	ClientPublicKeys      = "public_keys"
+	ClientPublicKeyHashes = "public_key_hashs"
+	ClientContracts       = "contracts"
+)
+
+// clientAlias is an element of the tezos-client wallet files
+type clientAlias struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+// clientPublicKey is the public_keys value used by newer clients. Older ones store the locator string only
+type clientPublicKey struct {
+	Locator string `json:"locator"`
+	Key     string `json:"key"`
+}
+
+// ClientWallet is a set of tezos-client aliases
+type ClientWallet struct {
+	SecretKeys      map[string]string
+	PublicKeys      map[string]string
+	PublicKeyHashes map[string]string
+	// Names of keys in the order of first appearance
+	Names []string
+	// Originated contract aliases
+	Contracts map[string]string
+	// public_keys values as read, kept intact as their locators may point to Ledger or remote signers
+	rawPublicKeys map[string]json.RawMessage
+}
+
+// NewClientWallet returns an empty wallet
+func NewClientWallet() *ClientWallet {
+	return &ClientWallet{
+		SecretKeys:      make(map[string]string),
+		PublicKeys:      make(map[string]string),
+		PublicKeyHashes: make(map[string]string),
+		Contracts:       make(map[string]string),
+		rawPublicKeys:   make(map[string]json.RawMessage),
+	}
+}
+
+func (w *ClientWallet) add(m map[string]string, name, value string) {
+	if _, ok := w.SecretKeys[name]; !ok {
+		if _, ok := w.PublicKeys[name]; !ok {
+			if _, ok := w.PublicKeyHashes[name]; !ok {
+				w.Names = append(w.Names, name)
+			}
+		}
+	}
+	m[name] = value
+}
+
+// ReadFile reads one of the wallet files. The file kind is taken from its name
+func (w *ClientWallet) ReadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var list []*clientAlias
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("keys: %s: %v", path, err)
+	}
+
+	kind := filepath.Base(path)
+	for _, a := range list {
+		var s string
+		switch kind {
+		case ClientPublicKeys:
+			var pk clientPublicKey
+			if err := json.Unmarshal(a.Value, &pk); err == nil {
+				s = pk.Key
+			} else if err := json.Unmarshal(a.Value, &pk.Locator); err == nil {
+				// Older clients store the locator only which includes the key for local keys
+				if i := strings.Index(pk.Locator, ":"); i >= 0 && (pk.Locator[:i+1] == schemeUnencrypted || pk.Locator[:i+1] == schemeEncrypted) {
+					s = pk.Locator[i+1:]
+				}
+			} else {
+				return fmt.Errorf("keys: %s: %s: %v", path, a.Name, err)
+			}
+			if s != "" {
+				w.add(w.PublicKeys, a.Name, s)
+			}
+			w.rawPublicKeys[a.Name] = a.Value
+		case ClientSecretKeys, ClientPublicKeyHashes, ClientContracts:
+			if err := json.Unmarshal(a.Value, &s); err != nil {
+				return fmt.Errorf("keys: %s: %s: %v", path, a.Name, err)
+			}
+			switch kind {
+			case ClientSecretKeys:
+				w.add(w.SecretKeys, a.Name, s)
+			case ClientPublicKeyHashes:
+				w.add(w.PublicKeyHashes, a.Name, s)
+			default:
+				w.Contracts[a.Name] = s
+			}
+		default:
+			return fmt.Errorf("keys: unknown tezos-client wallet file `%s'", path)
+		}
+	}
+	return nil
+}
+
+// LoadClientWallet reads the wallet files from the tezos-client base directory. Missing files are skipped
+func LoadClientWallet(dir string) (*ClientWallet, error) {
+	w := NewClientWallet()
+	for _, name := range []string{ClientSecretKeys, ClientPublicKeys, ClientPublicKeyHashes, ClientContracts} {
+		if err := w.ReadFile(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Entry converts the alias to a keystore entry. Keys held by signers other than the wallet itself
+// (Ledger, remote signers) become watch-only entries
+func (w *ClientWallet) Entry(name string) (*Entry, error) {
+	e := Entry{
+		Alias:   name,
+		Address: w.PublicKeyHashes[name],
+	}
+	if pk, ok := w.PublicKeys[name]; ok {
+		pub, err := ParsePublicKey(pk)
+		if err != nil {
+			return nil, fmt.Errorf("keys: public key of `%s': %v", name, err)
+		}
+		e.PublicKey = pub.String()
+		if e.Address == "" {
+			e.Address = pub.Hash()
+		}
+	}
+
+	sk := w.SecretKeys[name]
+	switch {
+	case strings.HasPrefix(sk, schemeUnencrypted):
+		k, err := ParsePrivateKey(strings.TrimPrefix(sk, schemeUnencrypted))
+		if err != nil {
+			return nil, fmt.Errorf("keys: secret key of `%s': %v", name, err)
+		}
+		e.PublicKey = k.Public().String()
+		e.Address = k.Public().Hash()
+		e.SecretKey = schemeUnencrypted + k.String()
+	case strings.HasPrefix(sk, schemeEncrypted):
+		e.SecretKey = sk
+	}
+
+	if e.Address == "" {
+		return nil, fmt.Errorf("keys: address of `%s' is unknown", name)
+	}
+	return &e, nil
+}
+
+// AddEntry adds the keystore entry to the wallet
+func (w *ClientWallet) AddEntry(e *Entry) error {
+	if _, ok := w.PublicKeyHashes[e.Alias]; ok {
+		return fmt.Errorf("keys: tezos-client alias `%s' already exists", e.Alias)
+	}
+	if e.SecretKey != "" {
+		w.add(w.SecretKeys, e.Alias, e.SecretKey)
+	}
+	if e.PublicKey != "" {
+		w.add(w.PublicKeys, e.Alias, e.PublicKey)
+	}
+	w.add(w.PublicKeyHashes, e.Alias, e.Address)
+	return nil
+}
+
+// Save writes the key files to the tezos-client base directory
+func (w *ClientWallet) Save(dir string) error {
+	// Keep the public key entries of the keys which aren't known to the wallet
+	publicKeys := make(map[string]string, len(w.rawPublicKeys))
+	for name := range w.rawPublicKeys {
+		publicKeys[name] = ""
+	}
+	for name, v := range w.PublicKeys {
+		publicKeys[name] = v
+	}
+
+	for _, file := range []struct {
+		name   string
+		values map[string]string
+	}{
+		{ClientSecretKeys, w.SecretKeys},
+		{ClientPublicKeys, publicKeys},
+		{ClientPublicKeyHashes, w.PublicKeyHashes},
+	} {
+		list := make([]*clientAlias, 0, len(file.values))
+		for _, name := range w.Names {
+			v, ok := file.values[name]
+			if !ok {
+				continue
+			}
+			var value interface{} = v
+			if file.name == ClientPublicKeys {
+				if raw, ok := w.rawPublicKeys[name]; ok {
+					list = append(list, &clientAlias{Name: name, Value: raw})
+					continue
+				}
+				value = &clientPublicKey{Locator: schemeUnencrypted + v, Key: v}
+			}
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			list = append(list, &clientAlias{Name: name, Value: raw})
+		}
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := atomicfile.WriteFile(filepath.Join(dir, file.name), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge adds the aliases of another wallet
+func (w *ClientWallet) Merge(x *ClientWallet) {
+	for name, addr := range x.Contracts {
+		w.Contracts[name] = addr
+	}
+	for _, name := range x.Names {
+		for _, m := range []struct{ dst, src map[string]string }{
+			{w.SecretKeys, x.SecretKeys},
+			{w.PublicKeys, x.PublicKeys},
+			{w.PublicKeyHashes, x.PublicKeyHashes},
+		} {
+			if v, ok := m.src[name]; ok {
+				w.add(m.dst, name, v)
+			}
+		}
+		if raw, ok := x.rawPublicKeys[name]; ok {
+			w.rawPublicKeys[name] = raw
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/ecadlabs/tez/base58"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encrypted secret keys use the same scheme as tezos-client: the secret is sealed with NaCl secretbox
+// using a key derived from the passphrase with PBKDF2-SHA512 and an all-zero nonce
+const (
+	saltLen          = 8
+	pbkdf2Iterations = 32768
+)
+
+// ErrPassphrase is returned when the encrypted key can't be opened
+var ErrPassphrase = errors.New("keys: wrong passphrase")
+
+// Encrypted prefixes mapped to the corresponding plain ones
+var encryptedPrefixes = map[*base58.Prefix]*base58.Prefix{
+	base58.PrefixEd25519EncryptedSeed:        base58.PrefixEd25519Seed,
+	base58.PrefixSecp256k1EncryptedSecretKey: base58.PrefixSecp256k1SecretKey,
+	base58.PrefixP256EncryptedSecretKey:      base58.PrefixP256SecretKey,
+	base58.PrefixBLS12381EncryptedSecretKey:  base58.PrefixBLS12381SecretKey,
+}
+
+func deriveKey(passphrase, salt []byte) *[32]byte {
+	var key [32]byte
+	copy(key[:], pbkdf2.Key(passphrase, salt, pbkdf2Iterations, len(key), sha512.New))
+	return &key
+}
+
+// EncryptPrivateKey returns Base58Check encoded encrypted secret key
+func EncryptPrivateKey(k PrivateKey, passphrase []byte) (string, error) {
+	p, payload, err := base58.DecodeAny(k.String())
+	if err != nil {
+		return "", err
+	}
+
+	var ep *base58.Prefix
+	for e, plain := range encryptedPrefixes {
+		if plain == p {
+			ep = e
+		}
+	}
+	if ep == nil {
+		return "", fmt.Errorf("keys: %s can't be encrypted", p.Name)
+	}
+
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	var nonce [24]byte
+	box := secretbox.Seal(salt, payload, &nonce, deriveKey(passphrase, salt))
+	return ep.Encode(box)
+}
+
+// IsEncrypted returns true if the Base58Check encoded secret key is encrypted
+func IsEncrypted(s string) bool {
+	p, _, err := base58.DecodeAny(s)
+	if err != nil {
+		return false
+	}
+	_, ok := encryptedPrefixes[p]
+	return ok
+}
+
+// DecryptPrivateKey opens an encrypted secret key
+func DecryptPrivateKey(s string, passphrase []byte) (PrivateKey, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, ok := encryptedPrefixes[p]
+	if !ok {
+		return nil, fmt.Errorf("keys: %s is not an encrypted secret key", p.Name)
+	}
+
+	var nonce [24]byte
+	data, ok := secretbox.Open(nil, payload[saltLen:], &nonce, deriveKey(passphrase, payload[:saltLen]))
+	if !ok {
+		return nil, ErrPassphrase
+	}
+	return newPrivateKey(plain, data)
+}
+
+// EncryptData seals arbitrary data like a mnemonic using the same scheme as secret keys. The result is Base64 encoded
+func EncryptData(data, passphrase []byte) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	var nonce [24]byte
+	return base64.StdEncoding.EncodeToString(secretbox.Seal(salt, data, &nonce, deriveKey(passphrase, salt))), nil
+}
+
+// DecryptData opens data sealed by EncryptData
+func DecryptData(s string, passphrase []byte) ([]byte, error) {
+	box, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(box) < saltLen {
+		return nil, errors.New("keys: encrypted data is too short")
+	}
+	var nonce [24]byte
+	data, ok := secretbox.Open(nil, box[saltLen:], &nonce, deriveKey(passphrase, box[:saltLen]))
+	if !ok {
+		return nil, ErrPassphrase
+	}
+	return data, nil
+}
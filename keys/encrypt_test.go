@@ -0,0 +1,79 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"testing"
+)
+
+func TestEncryptPrivateKey(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	for _, typ := range []string{TypeEd25519, TypeP256, TypeBLS} {
+		k, err := GeneratePrivateKey(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := EncryptPrivateKey(k, passphrase)
+		if err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		if !IsEncrypted(enc) || IsEncrypted(k.String()) {
+			t.Errorf("%s: IsEncrypted() is wrong", typ)
+		}
+		dec, err := DecryptPrivateKey(enc, passphrase)
+		if err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		if dec.String() != k.String() {
+			t.Errorf("%s: decrypted key differs", typ)
+		}
+		if _, err := DecryptPrivateKey(enc, []byte("wrong")); err != ErrPassphrase {
+			t.Errorf("%s: wrong passphrase: got %v", typ, err)
+		}
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	for _, typ := range []string{TypeEd25519, TypeP256, TypeBLS} {
+		k, err := GeneratePrivateKey(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParsePrivateKey(k.String())
+		if err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		if p.Public().Hash() != k.Public().Hash() {
+			t.Errorf("%s: parsed key has another address", typ)
+		}
+		pub, err := ParsePublicKey(k.Public().String())
+		if err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		if pub.Hash() != k.Public().Hash() {
+			t.Errorf("%s: parsed public key has another address", typ)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// HardenedIndex is the first hardened child index
+const HardenedIndex = 0x80000000
+
+// DefaultDerivationPath is used by Tezos wallets for the first account
+const DefaultDerivationPath = "m/44'/1729'/0'/0'"
+
+// BIP39 seed derivation parameters
+const (
+	mnemonicIterations = 2048
+	mnemonicSeedLen    = 64
+)
+
+// MnemonicSeed returns the BIP39 seed of the mnemonic. The words aren't checked against the wordlist
+func MnemonicSeed(mnemonic string, passphrase []byte) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	switch len(words) {
+	case 12, 15, 18, 21, 24:
+	default:
+		return nil, fmt.Errorf("keys: mnemonic must have 12, 15, 18, 21 or 24 words, got %d", len(words))
+	}
+	salt := append([]byte("mnemonic"), passphrase...)
+	return pbkdf2.Key([]byte(strings.Join(words, " ")), salt, mnemonicIterations, mnemonicSeedLen, sha512.New), nil
+}
+
+// IsMnemonic returns true if the string looks like a mnemonic rather than an encoded key
+func IsMnemonic(s string) bool {
+	return len(strings.Fields(s)) > 1
+}
+
+// DerivationPath is a list of child indices. Hardened indices have the HardenedIndex bit set
+type DerivationPath []uint32
+
+// ParseDerivationPath parses a path like m/44'/1729'/0'/0'. Both ' and h mark hardened indices
+func ParseDerivationPath(s string) (DerivationPath, error) {
+	parts := strings.Split(s, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("keys: derivation path must start with m/: `%s'", s)
+	}
+	path := make(DerivationPath, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		var hardened uint32
+		if strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h") || strings.HasSuffix(p, "H") {
+			hardened = HardenedIndex
+			p = p[:len(p)-1]
+		}
+		i, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || i >= HardenedIndex {
+			return nil, fmt.Errorf("keys: invalid derivation path index `%s'", p)
+		}
+		path = append(path, uint32(i)|hardened)
+	}
+	return path, nil
+}
+
+func (p DerivationPath) String() string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, i := range p {
+		b.WriteString("/")
+		b.WriteString(strconv.FormatUint(uint64(i&^HardenedIndex), 10))
+		if i&HardenedIndex != 0 {
+			b.WriteString("'")
+		}
+	}
+	return b.String()
+}
+
+// SLIP-10 master key HMAC keys
+var slip10Curves = map[string]string{
+	TypeEd25519: "ed25519 seed",
+	TypeP256:    "Nist256p1 seed",
+}
+
+// DeriveKey derives the key of the given type from the seed using SLIP-10.
+// Ed25519 supports hardened derivation only, BLS12-381 keys can't be derived
+func DeriveKey(typ string, seed []byte, path DerivationPath) (PrivateKey, error) {
+	curve, ok := slip10Curves[typ]
+	if !ok {
+		if typ == TypeBLS {
+			return nil, errors.New("keys: SLIP-10 derivation of BLS12-381 keys is not supported")
+		}
+		return nil, fmt.Errorf("keys: unknown key type `%s'", typ)
+	}
+
+	h := hmac.New(sha512.New, []byte(curve))
+	h.Write(seed)
+	sum := h.Sum(nil)
+	if typ == TypeP256 {
+		for !validP256Scalar(sum[:32]) {
+			h.Reset()
+			h.Write(sum)
+			sum = h.Sum(nil)
+		}
+	}
+	k, chain := sum[:32], sum[32:]
+
+	for _, i := range path {
+		if typ == TypeEd25519 {
+			if i&HardenedIndex == 0 {
+				return nil, fmt.Errorf("keys: Ed25519 keys support hardened derivation only: %s", path)
+			}
+			k, chain = slip10Child(chain, k, nil, i)
+		} else {
+			k, chain = p256Child(k, chain, i)
+		}
+	}
+
+	if typ == TypeEd25519 {
+		return ed25519PrivateKey(ed25519.NewKeyFromSeed(k)), nil
+	}
+	return newP256PrivateKey(k)
+}
+
+// slip10Child returns HMAC-SHA512 halves of the child derivation data
+func slip10Child(chain, k, pub []byte, i uint32) ([]byte, []byte) {
+	h := hmac.New(sha512.New, chain)
+	if i&HardenedIndex != 0 {
+		h.Write([]byte{0})
+		h.Write(k)
+	} else {
+		h.Write(pub)
+	}
+	var idx [4]byte
+	binary.BigEndian.PutUint32(idx[:], i)
+	h.Write(idx[:])
+	sum := h.Sum(nil)
+	return sum[:32], sum[32:]
+}
+
+func validP256Scalar(b []byte) bool {
+	d := new(big.Int).SetBytes(b)
+	return d.Sign() != 0 && d.Cmp(elliptic.P256().Params().N) < 0
+}
+
+func p256Child(k, chain []byte, i uint32) ([]byte, []byte) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	x, y := curve.ScalarBaseMult(k)
+	pub := elliptic.MarshalCompressed(curve, x, y)
+
+	il, ir := slip10Child(chain, k, pub, i)
+	for {
+		d := new(big.Int).SetBytes(il)
+		if d.Cmp(n) < 0 {
+			d.Add(d, new(big.Int).SetBytes(k))
+			d.Mod(d, n)
+			if d.Sign() != 0 {
+				return d.FillBytes(make([]byte, 32)), ir
+			}
+		}
+		// Invalid child, retry with 0x01 || IR || index
+		h := hmac.New(sha512.New, chain)
+		h.Write([]byte{1})
+		h.Write(ir)
+		var idx [4]byte
+		binary.BigEndian.PutUint32(idx[:], i)
+		h.Write(idx[:])
+		sum := h.Sum(nil)
+		il, ir = sum[:32], sum[32:]
+	}
+}
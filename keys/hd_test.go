@@ -0,0 +1,167 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+// SLIP-10 test vectors, see https://github.com/satoshilabs/slips/blob/master/slip-0010.md
+const (
+	slip10Seed1 = "000102030405060708090a0b0c0d0e0f"
+	slip10Seed2 = "fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542"
+)
+
+func TestDeriveKey(t *testing.T) {
+	tests := []struct {
+		typ  string
+		seed string
+		path string
+		key  string
+	}{
+		// Test vector 1 for ed25519
+		{TypeEd25519, slip10Seed1, "m", "2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7"},
+		{TypeEd25519, slip10Seed1, "m/0'", "68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3"},
+		{TypeEd25519, slip10Seed1, "m/0'/1'", "b1d0bad404bf35da785a64ca1ac54b2617211d2777696fbffaf208f746ae84f2"},
+		{TypeEd25519, slip10Seed1, "m/0'/1'/2'", "92a5b23c0b8a99e37d07df3fb9966917f5d06e02ddbd909c7e184371463e9fc9"},
+		{TypeEd25519, slip10Seed1, "m/0'/1'/2'/2'", "30d1dc7e5fc04c31219ab25a27ae00b50f6fd66622f6e9c913253d6511d1e662"},
+		{TypeEd25519, slip10Seed1, "m/0'/1'/2'/2'/1000000000'", "8f94d394a8e8fd6b1bc2f3f49f5c47e385281d5c17e65324b0f62483e37e8793"},
+		// Test vector 2 for ed25519
+		{TypeEd25519, slip10Seed2, "m", "171cb88b1b3c1db25add599712e36245d75bc65a1a5c9e18d76f9f2b1eab4012"},
+		{TypeEd25519, slip10Seed2, "m/0'", "1559eb2bbec5790b0c65d8693e4d0875b1747f4970ae8b650486ed7470845635"},
+		{TypeEd25519, slip10Seed2, "m/0'/2147483647'", "ea4f5bfe8694d8bb74b7b59404632fd5968b774ed545e810de9c32a4fb4192f4"},
+		{TypeEd25519, slip10Seed2, "m/0'/2147483647'/1'", "3757c7577170179c7868353ada796c839135b3d30554bbb74a4b1e4a5a58505c"},
+		{TypeEd25519, slip10Seed2, "m/0'/2147483647'/1'/2147483646'", "5837736c89570de861ebc173b1086da4f505d4adb387c6a1b1342d5e4ac9ec72"},
+		{TypeEd25519, slip10Seed2, "m/0'/2147483647'/1'/2147483646'/2'", "551d333177df541ad876a60ea71f00447931c0a9da16f227c11ea080d7391b8d"},
+		// Test vector 1 for nist256p1
+		{TypeP256, slip10Seed1, "m", "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{TypeP256, slip10Seed1, "m/0'", "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+		{TypeP256, slip10Seed1, "m/0'/1", "284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+		{TypeP256, slip10Seed1, "m/0'/1/2'", "694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7"},
+		{TypeP256, slip10Seed1, "m/0'/1/2'/2", "5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa"},
+		{TypeP256, slip10Seed1, "m/0'/1/2'/2/1000000000", "21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119"},
+		// Test vector 2 for nist256p1
+		{TypeP256, slip10Seed2, "m", "eaa31c2e46ca2962227cf21d73a7ef0ce8b31c756897521eb6c7b39796633357"},
+		{TypeP256, slip10Seed2, "m/0", "d7d065f63a62624888500cdb4f88b6d59c2927fee9e6d0cdff9cad555884df6e"},
+		{TypeP256, slip10Seed2, "m/0/2147483647'", "96d2ec9316746a75e7793684ed01e3d51194d81a42a3276858a5b7376d4b94b9"},
+		{TypeP256, slip10Seed2, "m/0/2147483647'/1", "974f9096ea6873a915910e82b29d7c338542ccde39d2064d1cc228f371542bbc"},
+		{TypeP256, slip10Seed2, "m/0/2147483647'/1/2147483646'", "da29649bbfaff095cd43819eda9a7be74236539a29094cd8336b07ed8d4eff63"},
+		{TypeP256, slip10Seed2, "m/0/2147483647'/1/2147483646'/2", "bb0a77ba01cc31d77205d51d08bd313b979a71ef4de9b062f8958297e746bd67"},
+		// Derivation retry for nist256p1
+		{TypeP256, slip10Seed1, "m/28578'", "06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669"},
+		{TypeP256, slip10Seed1, "m/28578'/33941", "092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a"},
+		// Seed retry for nist256p1
+		{TypeP256, "a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", "m", "3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f"},
+	}
+
+	for _, tt := range tests {
+		seed, err := hex.DecodeString(tt.seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := ParseDerivationPath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := DeriveKey(tt.typ, seed, path)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.typ, tt.path, err)
+			continue
+		}
+		var got []byte
+		switch k := k.(type) {
+		case ed25519PrivateKey:
+			got = ed25519.PrivateKey(k).Seed()
+		case *p256PrivateKey:
+			got = k.D.FillBytes(make([]byte, 32))
+		default:
+			t.Fatalf("%s %s: unexpected key type %T", tt.typ, tt.path, k)
+		}
+		if s := hex.EncodeToString(got); s != tt.key {
+			t.Errorf("%s %s: got %s, want %s", tt.typ, tt.path, s, tt.key)
+		}
+	}
+}
+
+func TestDeriveKeyErrors(t *testing.T) {
+	seed, _ := hex.DecodeString(slip10Seed1)
+	tests := []struct {
+		typ  string
+		path string
+	}{
+		{TypeEd25519, "m/0"},
+		{TypeEd25519, "m/44'/1729'/0'/0"},
+		{TypeBLS, "m/0'"},
+		{"rsa", "m"},
+	}
+
+	for _, tt := range tests {
+		path, err := ParseDerivationPath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DeriveKey(tt.typ, seed, path); err == nil {
+			t.Errorf("%s %s: error expected", tt.typ, tt.path)
+		}
+	}
+}
+
+func TestParseDerivationPath(t *testing.T) {
+	tests := []struct {
+		src  string
+		path DerivationPath
+		str  string
+	}{
+		{"m", DerivationPath{}, "m"},
+		{"m/44'/1729'/0'/0'", DerivationPath{44 | HardenedIndex, 1729 | HardenedIndex, HardenedIndex, HardenedIndex}, "m/44'/1729'/0'/0'"},
+		{"m/44h/1729H/1/2", DerivationPath{44 | HardenedIndex, 1729 | HardenedIndex, 1, 2}, "m/44'/1729'/1/2"},
+		{"m/2147483647'", DerivationPath{0xffffffff}, "m/2147483647'"},
+	}
+
+	for _, tt := range tests {
+		path, err := ParseDerivationPath(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if len(path) != len(tt.path) {
+			t.Errorf("%s: got %v, want %v", tt.src, path, tt.path)
+			continue
+		}
+		for i := range path {
+			if path[i] != tt.path[i] {
+				t.Errorf("%s: got %v, want %v", tt.src, path, tt.path)
+				break
+			}
+		}
+		if s := path.String(); s != tt.str {
+			t.Errorf("%s: got %s, want %s", tt.src, s, tt.str)
+		}
+	}
+
+	for _, src := range []string{"", "44'/1729'", "m/", "m/x", "m/-1", "m/2147483648", "m/1''"} {
+		if _, err := ParseDerivationPath(src); err == nil {
+			t.Errorf("%q: error expected", src)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package keys implements Tezos key types, signing and the local keystore
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ecadlabs/tez/base58"
+	bls12381 "github.com/kilic/bls12-381"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Key type tags used in the binary encoding of public keys and hashes
+const (
+	tagEd25519 = iota
+	tagSecp256k1
+	tagP256
+	tagBLS12381
+)
+
+// ErrUnsupported is returned for key types which can't be used for signing yet
+var ErrUnsupported = errors.New("keys: unsupported key type")
+
+// PublicKey is a public key of any supported type
+type PublicKey interface {
+	// String returns Base58Check encoded key
+	String() string
+	// Hash returns the implicit account address
+	Hash() string
+	// Bytes returns the tagged binary form used in operations
+	Bytes() []byte
+	// Verify checks the signature of the digest (of the message for BLS keys)
+	Verify(digest []byte, sig *Signature) bool
+}
+
+// PrivateKey is a private key of any supported type
+type PrivateKey interface {
+	// String returns Base58Check encoded key
+	String() string
+	Public() PublicKey
+	// Sign signs the digest (the message for BLS keys)
+	Sign(digest []byte) (*Signature, error)
+}
+
+// Signature is a raw signature along with its type
+type Signature struct {
+	Prefix *base58.Prefix
+	Bytes  []byte
+}
+
+func (s *Signature) String() string {
+	v, _ := s.Prefix.Encode(s.Bytes)
+	return v
+}
+
+// ParseSignature parses Base58Check encoded signature
+func ParseSignature(s string) (*Signature, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	switch p {
+	case base58.PrefixEd25519Signature, base58.PrefixSecp256k1Signature, base58.PrefixP256Signature,
+		base58.PrefixGenericSignature, base58.PrefixBLS12381Signature:
+		return &Signature{Prefix: p, Bytes: payload}, nil
+	}
+	return nil, fmt.Errorf("keys: %s is not a signature", p.Name)
+}
+
+func publicKeyHash(p *base58.Prefix, payload []byte) string {
+	h, _ := blake2b.New(20, nil)
+	h.Write(payload)
+	s, _ := p.Encode(h.Sum(nil))
+	return s
+}
+
+type ed25519PublicKey ed25519.PublicKey
+
+func (k ed25519PublicKey) String() string {
+	s, _ := base58.PrefixEd25519PublicKey.Encode(k)
+	return s
+}
+
+func (k ed25519PublicKey) Hash() string {
+	return publicKeyHash(base58.PrefixEd25519PublicKeyHash, k)
+}
+
+func (k ed25519PublicKey) Bytes() []byte {
+	return append([]byte{tagEd25519}, k...)
+}
+
+func (k ed25519PublicKey) Verify(digest []byte, sig *Signature) bool {
+	return len(sig.Bytes) == ed25519.SignatureSize && ed25519.Verify(ed25519.PublicKey(k), digest, sig.Bytes)
+}
+
+type ed25519PrivateKey ed25519.PrivateKey
+
+func (k ed25519PrivateKey) String() string {
+	s, _ := base58.PrefixEd25519Seed.Encode(ed25519.PrivateKey(k).Seed())
+	return s
+}
+
+func (k ed25519PrivateKey) Public() PublicKey {
+	return ed25519PublicKey(ed25519.PrivateKey(k).Public().(ed25519.PublicKey))
+}
+
+func (k ed25519PrivateKey) Sign(digest []byte) (*Signature, error) {
+	return &Signature{
+		Prefix: base58.PrefixEd25519Signature,
+		Bytes:  ed25519.Sign(ed25519.PrivateKey(k), digest),
+	}, nil
+}
+
+type p256PublicKey ecdsa.PublicKey
+
+func (k *p256PublicKey) compressed() []byte {
+	return elliptic.MarshalCompressed(elliptic.P256(), k.X, k.Y)
+}
+
+func (k *p256PublicKey) String() string {
+	s, _ := base58.PrefixP256PublicKey.Encode(k.compressed())
+	return s
+}
+
+func (k *p256PublicKey) Hash() string {
+	return publicKeyHash(base58.PrefixP256PublicKeyHash, k.compressed())
+}
+
+func (k *p256PublicKey) Bytes() []byte {
+	return append([]byte{tagP256}, k.compressed()...)
+}
+
+func (k *p256PublicKey) Verify(digest []byte, sig *Signature) bool {
+	if len(sig.Bytes) != 64 {
+		return false
+	}
+	var r, s big.Int
+	r.SetBytes(sig.Bytes[:32])
+	s.SetBytes(sig.Bytes[32:])
+	return ecdsa.Verify((*ecdsa.PublicKey)(k), digest, &r, &s)
+}
+
+type p256PrivateKey ecdsa.PrivateKey
+
+func (k *p256PrivateKey) String() string {
+	s, _ := base58.PrefixP256SecretKey.Encode(k.D.FillBytes(make([]byte, 32)))
+	return s
+}
+
+func (k *p256PrivateKey) Public() PublicKey {
+	return (*p256PublicKey)(&k.PublicKey)
+}
+
+func (k *p256PrivateKey) Sign(digest []byte) (*Signature, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, (*ecdsa.PrivateKey)(k), digest)
+	if err != nil {
+		return nil, err
+	}
+	// Use canonical low S form
+	n := elliptic.P256().Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+	buf := make([]byte, 64)
+	r.FillBytes(buf[:32])
+	s.FillBytes(buf[32:])
+	return &Signature{Prefix: base58.PrefixP256Signature, Bytes: buf}, nil
+}
+
+func newP256PrivateKey(d []byte) (PrivateKey, error) {
+	curve := elliptic.P256()
+	var k ecdsa.PrivateKey
+	k.Curve = curve
+	k.D = new(big.Int).SetBytes(d)
+	if k.D.Sign() == 0 || k.D.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("keys: invalid P-256 secret key")
+	}
+	k.X, k.Y = curve.ScalarBaseMult(d)
+	return (*p256PrivateKey)(&k), nil
+}
+
+// Key types accepted by GeneratePrivateKey
+const (
+	TypeEd25519 = "ed25519"
+	TypeP256    = "p256"
+	TypeBLS     = "bls"
+)
+
+// GeneratePrivateKey generates a new random key of the given type
+func GeneratePrivateKey(typ string) (PrivateKey, error) {
+	switch typ {
+	case TypeEd25519:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519PrivateKey(k), nil
+	case TypeP256:
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return (*p256PrivateKey)(k), nil
+	case TypeBLS:
+		return generateBLSPrivateKey()
+	}
+	return nil, fmt.Errorf("keys: unknown key type `%s'", typ)
+}
+
+func newPrivateKey(p *base58.Prefix, payload []byte) (PrivateKey, error) {
+	switch p {
+	case base58.PrefixEd25519Seed:
+		return ed25519PrivateKey(ed25519.NewKeyFromSeed(payload)), nil
+	case base58.PrefixEd25519SecretKey:
+		return ed25519PrivateKey(ed25519.NewKeyFromSeed(payload[:ed25519.SeedSize])), nil
+	case base58.PrefixP256SecretKey:
+		return newP256PrivateKey(payload)
+	case base58.PrefixBLS12381SecretKey:
+		return newBLSPrivateKey(payload)
+	case base58.PrefixSecp256k1SecretKey:
+		return nil, ErrUnsupported
+	}
+	return nil, fmt.Errorf("keys: %s is not a secret key", p.Name)
+}
+
+// ParsePrivateKey parses an unencrypted Base58Check encoded secret key
+func ParsePrivateKey(s string) (PrivateKey, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	return newPrivateKey(p, payload)
+}
+
+// ParsePublicKey parses Base58Check encoded public key
+func ParsePublicKey(s string) (PublicKey, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	switch p {
+	case base58.PrefixEd25519PublicKey:
+		return ed25519PublicKey(payload), nil
+	case base58.PrefixP256PublicKey:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), payload)
+		if x == nil {
+			return nil, errors.New("keys: invalid P-256 public key")
+		}
+		return &p256PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case base58.PrefixBLS12381PublicKey:
+		if len(payload) != blsPublicKeySize {
+			return nil, errors.New("keys: invalid BLS12-381 public key")
+		}
+		if _, err := bls12381.NewG1().FromCompressed(payload); err != nil {
+			return nil, errors.New("keys: invalid BLS12-381 public key")
+		}
+		return blsPublicKey(payload), nil
+	case base58.PrefixSecp256k1PublicKey:
+		return nil, ErrUnsupported
+	}
+	return nil, fmt.Errorf("keys: %s is not a public key", p.Name)
+}
+
+// RawPublicKey returns the public key of the given type from its untagged binary form.
+// P-256 keys may be compressed or uncompressed SEC 1 points
+func RawPublicKey(typ string, b []byte) (PublicKey, error) {
+	switch typ {
+	case TypeEd25519:
+		if len(b) != ed25519.PublicKeySize {
+			return nil, errors.New("keys: invalid Ed25519 public key")
+		}
+		return ed25519PublicKey(b), nil
+	case TypeP256:
+		var x, y *big.Int
+		if len(b) == 65 {
+			x, y = elliptic.Unmarshal(elliptic.P256(), b)
+		} else {
+			x, y = elliptic.UnmarshalCompressed(elliptic.P256(), b)
+		}
+		if x == nil {
+			return nil, errors.New("keys: invalid P-256 public key")
+		}
+		return &p256PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case TypeBLS:
+		if len(b) != blsPublicKeySize {
+			return nil, errors.New("keys: invalid BLS12-381 public key")
+		}
+		if _, err := bls12381.NewG1().FromCompressed(b); err != nil {
+			return nil, errors.New("keys: invalid BLS12-381 public key")
+		}
+		return blsPublicKey(b), nil
+	}
+	return nil, fmt.Errorf("keys: unknown key type `%s'", typ)
+}
+
+// PublicKeyFromBytes parses the tagged binary form of a public key used in operations
+func PublicKeyFromBytes(b []byte) (PublicKey, error) {
+	if len(b) == 0 {
+		return nil, errors.New("keys: empty public key")
+	}
+	switch b[0] {
+	case tagEd25519:
+		return RawPublicKey(TypeEd25519, b[1:])
+	case tagP256:
+		if len(b) != 34 {
+			return nil, errors.New("keys: invalid P-256 public key")
+		}
+		return RawPublicKey(TypeP256, b[1:])
+	case tagBLS12381:
+		return RawPublicKey(TypeBLS, b[1:])
+	case tagSecp256k1:
+		return nil, ErrUnsupported
+	}
+	return nil, fmt.Errorf("keys: unknown public key tag %d", b[0])
+}
+
+// UncompressedP256 returns the uncompressed SEC 1 point of a P-256 public key or nil for other key types
+func UncompressedP256(k PublicKey) []byte {
+	if p, ok := k.(*p256PublicKey); ok {
+		return elliptic.Marshal(elliptic.P256(), p.X, p.Y)
+	}
+	return nil
+}
+
+// KeyType returns the key type accepted by GeneratePrivateKey
+func KeyType(k PublicKey) string {
+	switch k.(type) {
+	case ed25519PublicKey:
+		return TypeEd25519
+	case *p256PublicKey:
+		return TypeP256
+	case blsPublicKey:
+		return TypeBLS
+	}
+	return ""
+}
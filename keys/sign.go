@@ -0,0 +1,240 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ecadlabs/tez/base58"
+	"golang.org/x/crypto/blake2b"
+)
+
+// WatermarkGenericOperation is prepended to manager and other non consensus operations before signing
+const WatermarkGenericOperation = 0x03
+
+// WatermarkMichelson is prepended to packed Michelson data signed off-chain, e.g. permits
+const WatermarkMichelson = 0x05
+
+// WatermarkRelease is prepended to the checksums of tez releases signed with the release key. The tag is none of the
+// protocol's and the data is further prefixed with releaseDomain, so the signature is never valid for operations or packed data
+const WatermarkRelease = 0x7f
+
+// Prepended to the data signed under WatermarkRelease
+const releaseDomain = "tez release checksums\x00"
+
+// Tenderbake consensus watermarks. These are followed by the chain ID so the signature can't be replayed on another chain
+const (
+	WatermarkBlock          = 0x11
+	WatermarkPreattestation = 0x12
+	WatermarkAttestation    = 0x13
+)
+
+// Emmy block and endorsement watermarks still recognized by signers
+const (
+	watermarkLegacyBlock       = 0x01
+	watermarkLegacyEndorsement = 0x02
+)
+
+// Watermark is the signing domain of the data
+type Watermark struct {
+	Name string
+	Tag  byte
+}
+
+var watermarks = []*Watermark{
+	{Name: "generic", Tag: WatermarkGenericOperation},
+	{Name: "michelson", Tag: WatermarkMichelson},
+	{Name: "release", Tag: WatermarkRelease},
+	{Name: "block", Tag: WatermarkBlock},
+	{Name: "preattestation", Tag: WatermarkPreattestation},
+	{Name: "attestation", Tag: WatermarkAttestation},
+	{Name: "legacy_block", Tag: watermarkLegacyBlock},
+	{Name: "legacy_endorsement", Tag: watermarkLegacyEndorsement},
+}
+
+// Names used before Oxford
+var watermarkAliases = map[string]string{
+	"preendorsement": "preattestation",
+	"endorsement":    "attestation",
+}
+
+// WatermarkNames returns the names accepted by ParseWatermark
+func WatermarkNames() []string {
+	names := make([]string, len(watermarks))
+	for i, w := range watermarks {
+		names[i] = w.Name
+	}
+	return names
+}
+
+// LookupWatermark returns the watermark with the tag. Unknown tags are named after their hex value
+func LookupWatermark(tag byte) *Watermark {
+	for _, w := range watermarks {
+		if w.Tag == tag {
+			return w
+		}
+	}
+	return &Watermark{Name: fmt.Sprintf("0x%02x", tag), Tag: tag}
+}
+
+// ParseWatermark accepts a watermark name or a hex byte like 0x03
+func ParseWatermark(s string) (*Watermark, error) {
+	if a, ok := watermarkAliases[s]; ok {
+		s = a
+	}
+	for _, w := range watermarks {
+		if w.Name == s {
+			return w, nil
+		}
+	}
+	if strings.HasPrefix(s, "0x") {
+		if v, err := strconv.ParseUint(s[2:], 16, 8); err == nil {
+			return LookupWatermark(byte(v)), nil
+		}
+	}
+	return nil, fmt.Errorf("keys: unknown watermark `%s'", s)
+}
+
+// Consensus returns true for blocks and consensus operations. Signing two of them at the same level and round gets the baker slashed
+func (w *Watermark) Consensus() bool {
+	switch w.Tag {
+	case WatermarkBlock, WatermarkPreattestation, WatermarkAttestation, watermarkLegacyBlock, watermarkLegacyEndorsement:
+		return true
+	}
+	return false
+}
+
+// Message returns the data to be watermarked. Consensus data is prefixed with the chain ID and release checksums with their domain
+func (w *Watermark) Message(chainID string, data []byte) ([]byte, error) {
+	if w.Tag == WatermarkRelease {
+		return append([]byte(releaseDomain), data...), nil
+	}
+	if !w.Consensus() {
+		return data, nil
+	}
+	id, err := base58.PrefixChainID.Decode(chainID)
+	if err != nil {
+		return nil, err
+	}
+	return append(id, data...), nil
+}
+
+// Offsets in the consensus payloads following the chain ID
+const (
+	// Shell header: level, proto, predecessor, timestamp, validation pass, operations hash, fitness
+	blockFitnessOffset = 4 + 1 + 32 + 8 + 1 + 32
+	// Branch, operation tag, slot, level, round
+	consensusLevelOffset = 32 + 1 + 2
+)
+
+// LevelRound returns the level and the round of the block header or the (pre)attestation signed in the consensus domain
+func (w *Watermark) LevelRound(data []byte) (level, round int32, err error) {
+	switch w.Tag {
+	case WatermarkBlock:
+		if len(data) < blockFitnessOffset+4 {
+			return 0, 0, errors.New("keys: block header is too short")
+		}
+		level = int32(binary.BigEndian.Uint32(data))
+		n := int(binary.BigEndian.Uint32(data[blockFitnessOffset:]))
+		fitness := data[blockFitnessOffset+4:]
+		if len(fitness) < n {
+			return 0, 0, errors.New("keys: block fitness is truncated")
+		}
+		fitness = fitness[:n]
+		// The round is the last fitness element
+		var last []byte
+		for len(fitness) != 0 {
+			if len(fitness) < 4 {
+				return 0, 0, errors.New("keys: malformed block fitness")
+			}
+			n := int(binary.BigEndian.Uint32(fitness))
+			if len(fitness) < 4+n {
+				return 0, 0, errors.New("keys: malformed block fitness")
+			}
+			last, fitness = fitness[4:4+n], fitness[4+n:]
+		}
+		if len(last) != 4 {
+			return 0, 0, errors.New("keys: block fitness has no round")
+		}
+		return level, int32(binary.BigEndian.Uint32(last)), nil
+
+	case WatermarkPreattestation, WatermarkAttestation:
+		if len(data) < consensusLevelOffset+8 {
+			return 0, 0, errors.New("keys: consensus operation is too short")
+		}
+		level = int32(binary.BigEndian.Uint32(data[consensusLevelOffset:]))
+		round = int32(binary.BigEndian.Uint32(data[consensusLevelOffset+4:]))
+		return level, round, nil
+	}
+	return 0, 0, fmt.Errorf("keys: level and round of the %s watermark are unknown", w.Name)
+}
+
+// Digest returns the hash signed by Tezos keys
+func Digest(watermark byte, data []byte) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte{watermark})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// messageSigner is implemented by keys (BLS) which sign the watermarked message itself instead of its Blake2b digest
+type messageSigner interface {
+	signsMessage()
+}
+
+func signedBytes(k interface{}, watermark byte, data []byte) []byte {
+	if _, ok := k.(messageSigner); ok {
+		return append([]byte{watermark}, data...)
+	}
+	return Digest(watermark, data)
+}
+
+// Sign signs the watermarked data using the scheme of the key type
+func Sign(k PrivateKey, watermark byte, data []byte) (*Signature, error) {
+	return k.Sign(signedBytes(k, watermark, data))
+}
+
+// Verify checks the signature of the watermarked data
+func Verify(pub PublicKey, watermark byte, data []byte, sig *Signature) bool {
+	return pub.Verify(signedBytes(pub, watermark, data), sig)
+}
+
+// SignWatermarked signs the data in the watermark's domain. The chain ID is only used by consensus watermarks
+func SignWatermarked(k PrivateKey, w *Watermark, chainID string, data []byte) (*Signature, error) {
+	msg, err := w.Message(chainID, data)
+	if err != nil {
+		return nil, err
+	}
+	return Sign(k, w.Tag, msg)
+}
+
+// VerifyWatermarked checks the signature of the data made in the watermark's domain, see SignWatermarked
+func VerifyWatermarked(pub PublicKey, w *Watermark, chainID string, data []byte, sig *Signature) bool {
+	msg, err := w.Message(chainID, data)
+	if err != nil {
+		return false
+	}
+	return Verify(pub, w.Tag, msg, sig)
+}
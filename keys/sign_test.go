@@ -0,0 +1,129 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ecadlabs/tez/base58"
+)
+
+func TestParseWatermark(t *testing.T) {
+	tests := []struct {
+		src  string
+		tag  byte
+		name string
+	}{
+		{"generic", WatermarkGenericOperation, "generic"},
+		{"michelson", WatermarkMichelson, "michelson"},
+		{"endorsement", WatermarkAttestation, "attestation"},
+		{"preendorsement", WatermarkPreattestation, "preattestation"},
+		{"0x11", WatermarkBlock, "block"},
+		{"0x07", 0x07, "0x07"},
+	}
+	for _, tt := range tests {
+		w, err := ParseWatermark(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if w.Tag != tt.tag || w.Name != tt.name {
+			t.Errorf("%s: got %s (0x%02x)", tt.src, w.Name, w.Tag)
+		}
+	}
+	for _, s := range []string{"", "bogus", "0x100", "0xzz"} {
+		if _, err := ParseWatermark(s); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
+
+// attestation returns forged attestation bytes following the chain ID
+func attestation(level, round int32) []byte {
+	buf := make([]byte, 32, 32+1+2+8+32)
+	buf = append(buf, 21, 0, 7)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(level))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(round))
+	return append(buf, make([]byte, 32)...)
+}
+
+func TestLevelRound(t *testing.T) {
+	w := LookupWatermark(WatermarkAttestation)
+	level, round, err := w.LevelRound(attestation(123456, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != 123456 || round != 2 {
+		t.Errorf("got level %d round %d", level, round)
+	}
+	if _, _, err := w.LevelRound(make([]byte, 10)); err == nil {
+		t.Error("short payload accepted")
+	}
+	if _, _, err := LookupWatermark(WatermarkGenericOperation).LevelRound(attestation(1, 0)); err == nil {
+		t.Error("level of a generic operation returned")
+	}
+}
+
+func TestSignWatermarked(t *testing.T) {
+	const chainID = "NetXdQprcVkpaWU"
+	data := attestation(100, 0)
+
+	w := LookupWatermark(WatermarkAttestation)
+	msg, err := w.Message(chainID, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := base58.PrefixChainID.Decode(chainID)
+	if !bytes.Equal(msg[:len(id)], id) || !bytes.Equal(msg[len(id):], data) {
+		t.Error("consensus message isn't prefixed with the chain ID")
+	}
+	if m, _ := LookupWatermark(WatermarkGenericOperation).Message(chainID, data); !bytes.Equal(m, data) {
+		t.Error("generic message is changed")
+	}
+
+	for _, typ := range []string{TypeEd25519, TypeP256, TypeBLS} {
+		k, err := GeneratePrivateKey(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := SignWatermarked(k, w, chainID, data)
+		if err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		pub := k.Public()
+		if !VerifyWatermarked(pub, w, chainID, data, sig) {
+			t.Errorf("%s: signature doesn't verify", typ)
+		}
+		// Neither another chain nor another domain accepts the signature
+		if VerifyWatermarked(pub, w, "NetXnHfVqm9iesp", data, sig) {
+			t.Errorf("%s: signature verifies on another chain", typ)
+		}
+		if VerifyWatermarked(pub, LookupWatermark(WatermarkPreattestation), chainID, data, sig) {
+			t.Errorf("%s: signature verifies under another watermark", typ)
+		}
+		if Verify(pub, WatermarkGenericOperation, data, sig) {
+			t.Errorf("%s: signature verifies as a generic operation", typ)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package keys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ecadlabs/tez/internal/atomicfile"
+)
+
+// Secret key URI schemes, same as used by tezos-client
+const (
+	schemeUnencrypted = "unencrypted:"
+	schemeEncrypted   = "encrypted:"
+)
+
+// Entry is a named key
+type Entry struct {
+	Alias     string `json:"alias"`
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key,omitempty"`
+	// Secret key URI: unencrypted:<key> or encrypted:<key>
+	SecretKey string `json:"secret_key,omitempty"`
+	// Mnemonic the key was derived from: unencrypted:<words> or encrypted:<Base64 sealed words>
+	Mnemonic       string `json:"mnemonic,omitempty"`
+	DerivationPath string `json:"derivation_path,omitempty"`
+	// Baker keys sign blocks and consensus operations only above the local high watermarks
+	Baker bool `json:"baker,omitempty"`
+}
+
+// NewEntry returns new keystore entry for the private key. The key is encrypted if the passphrase is not empty
+func NewEntry(alias string, k PrivateKey, passphrase []byte) (*Entry, error) {
+	e := Entry{
+		Alias:     alias,
+		Address:   k.Public().Hash(),
+		PublicKey: k.Public().String(),
+	}
+	if len(passphrase) != 0 {
+		s, err := EncryptPrivateKey(k, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		e.SecretKey = schemeEncrypted + s
+	} else {
+		e.SecretKey = schemeUnencrypted + k.String()
+	}
+	return &e, nil
+}
+
+// NewWatchEntry returns new keystore entry without a secret key. The public key is optional
+func NewWatchEntry(alias, address string, pub PublicKey) (*Entry, error) {
+	e := Entry{
+		Alias:   alias,
+		Address: address,
+	}
+	if pub != nil {
+		if pub.Hash() != address {
+			return nil, fmt.Errorf("keys: public key %s doesn't match the address %s", pub, address)
+		}
+		e.PublicKey = pub.String()
+	}
+	return &e, nil
+}
+
+// WatchOnly returns true if the entry has no secret key and can't sign
+func (e *Entry) WatchOnly() bool {
+	return e.SecretKey == ""
+}
+
+// SetMnemonic keeps the mnemonic and the derivation path of the key so they can be exported later.
+// The mnemonic is encrypted if the passphrase is not empty
+func (e *Entry) SetMnemonic(mnemonic, path string, passphrase []byte) error {
+	words := strings.Join(strings.Fields(mnemonic), " ")
+	if len(passphrase) != 0 {
+		s, err := EncryptData([]byte(words), passphrase)
+		if err != nil {
+			return err
+		}
+		e.Mnemonic = schemeEncrypted + s
+	} else {
+		e.Mnemonic = schemeUnencrypted + words
+	}
+	e.DerivationPath = path
+	return nil
+}
+
+// RevealMnemonic returns the mnemonic the key was derived from. The passphrase function is called for encrypted mnemonics only
+func (e *Entry) RevealMnemonic(passphrase func() ([]byte, error)) (string, error) {
+	switch {
+	case e.Mnemonic == "":
+		return "", fmt.Errorf("keys: `%s' wasn't imported from a mnemonic", e.Alias)
+	case strings.HasPrefix(e.Mnemonic, schemeUnencrypted):
+		return strings.TrimPrefix(e.Mnemonic, schemeUnencrypted), nil
+	case strings.HasPrefix(e.Mnemonic, schemeEncrypted):
+		pass, err := passphrase()
+		if err != nil {
+			return "", err
+		}
+		data, err := DecryptData(strings.TrimPrefix(e.Mnemonic, schemeEncrypted), pass)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("keys: unknown mnemonic scheme of `%s'", e.Alias)
+}
+
+// Encrypted returns true if the passphrase is required to use the key
+func (e *Entry) Encrypted() bool {
+	return strings.HasPrefix(e.SecretKey, schemeEncrypted)
+}
+
+// PrivateKey returns the entry's private key. The passphrase function is called for encrypted keys only
+func (e *Entry) PrivateKey(passphrase func() ([]byte, error)) (PrivateKey, error) {
+	var (
+		k   PrivateKey
+		err error
+	)
+	switch {
+	case e.SecretKey == "":
+		return nil, fmt.Errorf("keys: `%s' is a watch-only key and can't sign", e.Alias)
+	case strings.HasPrefix(e.SecretKey, schemeUnencrypted):
+		k, err = ParsePrivateKey(strings.TrimPrefix(e.SecretKey, schemeUnencrypted))
+	case strings.HasPrefix(e.SecretKey, schemeEncrypted):
+		var pass []byte
+		if pass, err = passphrase(); err != nil {
+			return nil, err
+		}
+		k, err = DecryptPrivateKey(strings.TrimPrefix(e.SecretKey, schemeEncrypted), pass)
+	default:
+		return nil, fmt.Errorf("keys: unknown secret key scheme: `%s'", e.SecretKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if k.Public().Hash() != e.Address {
+		return nil, fmt.Errorf("keys: `%s' secret key doesn't match the address %s", e.Alias, e.Address)
+	}
+	return k, nil
+}
+
+// Store is a file based keystore
+type Store struct {
+	path    string
+	Entries []*Entry
+	// Read-only entries of the tezos-client wallet, see AttachClient
+	Client []*Entry
+}
+
+// LoadStore reads the keystore file. Missing file is treated as an empty keystore
+func LoadStore(path string) (*Store, error) {
+	s := Store{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.Entries); err != nil {
+		return nil, fmt.Errorf("keys: %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// AttachClient adds aliases of the tezos-client wallet to lookups. Aliases of keys which are in the keystore already are skipped.
+// Aliases which can't be converted are skipped and returned as errors
+func (s *Store) AttachClient(w *ClientWallet) []error {
+	var errs []error
+	for _, name := range w.Names {
+		e, err := w.Entry(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if s.own(e) {
+			continue
+		}
+		s.Client = append(s.Client, e)
+	}
+	return errs
+}
+
+func (s *Store) own(e *Entry) bool {
+	for _, x := range s.Entries {
+		if x.Alias == e.Alias || x.Address == e.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// IsClient returns true if the entry belongs to the attached tezos-client wallet
+func (s *Store) IsClient(e *Entry) bool {
+	for _, x := range s.Client {
+		if x == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns an entry by its alias or address
+func (s *Store) Lookup(name string) *Entry {
+	for _, list := range [][]*Entry{s.Entries, s.Client} {
+		for _, e := range list {
+			if e.Alias == name {
+				return e
+			}
+		}
+	}
+	for _, list := range [][]*Entry{s.Entries, s.Client} {
+		for _, e := range list {
+			if e.Address == name {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Add adds new entry. Aliases must be unique
+func (s *Store) Add(e *Entry) error {
+	if e.Alias == "" {
+		return errors.New("keys: alias is required")
+	}
+	for _, list := range [][]*Entry{s.Entries, s.Client} {
+		for _, x := range list {
+			if x.Alias == e.Alias {
+				return fmt.Errorf("keys: alias `%s' already exists", e.Alias)
+			}
+		}
+	}
+	s.Entries = append(s.Entries, e)
+	return nil
+}
+
+// Remove removes the entry by its alias
+func (s *Store) Remove(alias string) bool {
+	for i, e := range s.Entries {
+		if e.Alias == alias {
+			s.Entries = append(s.Entries[:i], s.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes the keystore atomically. The file is readable by the owner only. Entries of the attached tezos-client wallet are not saved
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s.Entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.path, append(data, '\n'), 0600)
+}
@@ -0,0 +1,339 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Binary node tags
+const (
+	tagInt           = 0x00
+	tagString        = 0x01
+	tagSeq           = 0x02
+	tagPrim0         = 0x03
+	tagPrim0Annots   = 0x04
+	tagPrim1         = 0x05
+	tagPrim1Annots   = 0x06
+	tagPrim2         = 0x07
+	tagPrim2Annots   = 0x08
+	tagPrimN         = 0x09
+	tagBytes         = 0x0a
+	packedDataPrefix = 0x05
+)
+
+// ErrUnexpectedEOF is returned when the binary data is truncated
+var ErrUnexpectedEOF = errors.New("michelson: unexpected end of data")
+
+func appendZarith(buf []byte, v *big.Int) []byte {
+	var x big.Int
+	x.Abs(v)
+
+	b := byte(x.Uint64() & 0x3f)
+	if v.Sign() < 0 {
+		b |= 0x40
+	}
+	x.Rsh(&x, 6)
+	for x.Sign() != 0 {
+		buf = append(buf, b|0x80)
+		b = byte(x.Uint64() & 0x7f)
+		x.Rsh(&x, 7)
+	}
+	return append(buf, b)
+}
+
+func appendLen(buf []byte, n int) []byte {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(n))
+	return append(buf, l[:]...)
+}
+
+// MarshalBinary returns binary Micheline representation of the expression
+func (n *Node) MarshalBinary() ([]byte, error) {
+	return n.appendBinary(nil)
+}
+
+func (n *Node) appendBinary(buf []byte) ([]byte, error) {
+	switch n.Kind {
+	case KindInt:
+		return appendZarith(append(buf, tagInt), n.Int), nil
+
+	case KindString:
+		buf = appendLen(append(buf, tagString), len(n.Str))
+		return append(buf, n.Str...), nil
+
+	case KindBytes:
+		buf = appendLen(append(buf, tagBytes), len(n.Bytes))
+		return append(buf, n.Bytes...), nil
+
+	case KindSeq:
+		body, err := appendNodes(nil, n.Args)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendLen(append(buf, tagSeq), len(body))
+		return append(buf, body...), nil
+
+	case KindPrim:
+		code, ok := primitiveCodes[n.Prim]
+		if !ok {
+			return nil, fmt.Errorf("michelson: unknown primitive `%s'", n.Prim)
+		}
+
+		annots := len(n.Annots) != 0
+		if len(n.Args) <= 2 {
+			tag := byte(tagPrim0 + len(n.Args)*2)
+			if annots {
+				tag++
+			}
+			buf, err := appendNodes(append(buf, tag, code), n.Args)
+			if err != nil {
+				return nil, err
+			}
+			if annots {
+				buf = appendAnnots(buf, n.Annots)
+			}
+			return buf, nil
+		}
+
+		body, err := appendNodes(nil, n.Args)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendLen(append(buf, tagPrimN, code), len(body))
+		buf = append(buf, body...)
+		return appendAnnots(buf, n.Annots), nil
+	}
+
+	return nil, fmt.Errorf("michelson: unknown node kind %d", n.Kind)
+}
+
+func appendNodes(buf []byte, nodes []*Node) ([]byte, error) {
+	var err error
+	for _, a := range nodes {
+		if buf, err = a.appendBinary(buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendAnnots(buf []byte, annots []string) []byte {
+	s := strings.Join(annots, " ")
+	return append(appendLen(buf, len(s)), s...)
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) byte() (byte, error) {
+	if len(d.buf) == 0 {
+		return 0, ErrUnexpectedEOF
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	if len(d.buf) < 4 {
+		return nil, ErrUnexpectedEOF
+	}
+	l := binary.BigEndian.Uint32(d.buf)
+	if uint64(len(d.buf)-4) < uint64(l) {
+		return nil, ErrUnexpectedEOF
+	}
+	b := d.buf[4 : 4+l]
+	d.buf = d.buf[4+l:]
+	return b, nil
+}
+
+func (d *decoder) zarith() (*big.Int, error) {
+	var (
+		v     big.Int
+		shift uint
+		neg   bool
+	)
+	for i := 0; ; i++ {
+		b, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		var bits big.Int
+		if i == 0 {
+			neg = b&0x40 != 0
+			bits.SetUint64(uint64(b & 0x3f))
+			v.Or(&v, &bits)
+			shift = 6
+		} else {
+			bits.SetUint64(uint64(b & 0x7f))
+			v.Or(&v, bits.Lsh(&bits, shift))
+			shift += 7
+		}
+
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if neg {
+		v.Neg(&v)
+	}
+	return &v, nil
+}
+
+func (d *decoder) nodes() ([]*Node, error) {
+	body, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	sub := decoder{buf: body}
+	nodes := []*Node{}
+	for len(sub.buf) != 0 {
+		n, err := sub.node()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func (d *decoder) annots() ([]string, error) {
+	b, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(b), " "), nil
+}
+
+func (d *decoder) node() (*Node, error) {
+	tag, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case tagInt:
+		v, err := d.zarith()
+		if err != nil {
+			return nil, err
+		}
+		return NewInt(v), nil
+
+	case tagString:
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return NewString(string(b)), nil
+
+	case tagBytes:
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return NewBytes(append([]byte(nil), b...)), nil
+
+	case tagSeq:
+		args, err := d.nodes()
+		if err != nil {
+			return nil, err
+		}
+		return NewSeq(args...), nil
+
+	case tagPrim0, tagPrim0Annots, tagPrim1, tagPrim1Annots, tagPrim2, tagPrim2Annots, tagPrimN:
+		code, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if int(code) >= len(primitives) {
+			return nil, fmt.Errorf("michelson: unknown primitive code %d", code)
+		}
+		n := NewPrim(primitives[code])
+
+		if tag == tagPrimN {
+			if n.Args, err = d.nodes(); err != nil {
+				return nil, err
+			}
+			if n.Annots, err = d.annots(); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+
+		argc := int(tag-tagPrim0) / 2
+		for i := 0; i < argc; i++ {
+			arg, err := d.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Args = append(n.Args, arg)
+		}
+		if (tag-tagPrim0)%2 != 0 {
+			if n.Annots, err = d.annots(); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("michelson: unknown node tag 0x%02x", tag)
+}
+
+// UnmarshalBinary decodes binary Micheline representation
+func (n *Node) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	v, err := d.node()
+	if err != nil {
+		return err
+	}
+	if len(d.buf) != 0 {
+		return fmt.Errorf("michelson: %d trailing bytes", len(d.buf))
+	}
+	*n = *v
+	return nil
+}
+
+// Pack serializes the expression the same way PACK instruction does. Use PackTyped to normalize the value first
+func Pack(n *Node) ([]byte, error) {
+	return n.appendBinary([]byte{packedDataPrefix})
+}
+
+// Unpack deserializes PACK output
+func Unpack(data []byte) (*Node, error) {
+	if len(data) == 0 || data[0] != packedDataPrefix {
+		return nil, errors.New("michelson: packed data must start with 0x05")
+	}
+	var n Node
+	if err := n.UnmarshalBinary(data[1:]); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
@@ -0,0 +1,143 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPackTyped(t *testing.T) {
+	tests := []struct {
+		value  string
+		typ    string
+		packed string
+	}{
+		{`"hello"`, "string", "05010000000568656c6c6f"},
+		{"0", "nat", "050000"},
+		{"1", "int", "050001"},
+		{"-1", "int", "050041"},
+		{"1000000", "mutez", "050080897a"},
+		{"Unit", "unit", "05030b"},
+		{"True", "bool", "05030a"},
+		{"0x00ff", "bytes", "050a0000000200ff"},
+		{`Pair 1 "a"`, "pair int string", "0507070001010000000161"},
+		{"{ 1 ; 2 }", "list nat", "05020000000400010002"},
+		{`"tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"`, "address", "050a00000016000002298c03ed7d454a101eb7022bc95f7e5f41ac78"},
+		{`"KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn"`, "address", "050a0000001601a3d0f58d8964bd1b37fb0a0c197b38cf46608d4900"},
+		{"{ 1 ; 2 }", "set nat", "05020000000400010002"},
+		{`{ Elt "a" 1 ; Elt "b" 2 }`, "map string nat", "0502000000140704010000000161000107040100000001620002"},
+		{"{ None ; Some 0 }", "set (option nat)", "050200000006030605090000"},
+	}
+
+	for _, tt := range tests {
+		value, err := Parse(tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ, err := Parse(tt.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packed, err := PackTyped(value, typ)
+		if err != nil {
+			t.Errorf("%s: %v", tt.value, err)
+			continue
+		}
+		if s := hex.EncodeToString(packed); s != tt.packed {
+			t.Errorf("%s: got %s, want %s", tt.value, s, tt.packed)
+		}
+	}
+}
+
+func TestPackTypedInvalid(t *testing.T) {
+	tests := []struct {
+		value string
+		typ   string
+	}{
+		{"-1", "nat"},
+		{"-1", "mutez"},
+		{"9223372036854775808", "mutez"},
+		{"{ 2 ; 1 }", "set nat"},
+		{"{ 1 ; 1 }", "set nat"},
+		{`{ Elt "b" 1 ; Elt "a" 2 }`, "map string nat"},
+		{`{ Elt "a" 1 ; Elt "a" 2 }`, "big_map string nat"},
+		{"{ Some 0 ; None }", "set (option nat)"},
+	}
+
+	for _, tt := range tests {
+		value, err := Parse(tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ, err := Parse(tt.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := PackTyped(value, typ); err == nil {
+			t.Errorf("%s: %s accepted", tt.typ, tt.value)
+		}
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	tests := []struct {
+		packed string
+		value  string
+	}{
+		{"05010000000568656c6c6f", `"hello"`},
+		{"050041", "-1"},
+		{"050080897a", "1000000"},
+		{"05030b", "Unit"},
+		{"0507070001010000000161", `Pair 1 "a"`},
+		{"05020000000400010002", "{ 1 ; 2 }"},
+	}
+
+	for _, tt := range tests {
+		data, err := hex.DecodeString(tt.packed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := Unpack(data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.packed, err)
+			continue
+		}
+		if s := n.String(); s != tt.value {
+			t.Errorf("%s: got %s, want %s", tt.packed, s, tt.value)
+		}
+		repacked, err := Pack(n)
+		if err != nil {
+			t.Errorf("%s: %v", tt.packed, err)
+			continue
+		}
+		if s := hex.EncodeToString(repacked); s != tt.packed {
+			t.Errorf("%s: repacked as %s", tt.packed, s)
+		}
+	}
+
+	for _, s := range []string{"", "0001", "0501", "05010000000568656c6c"} {
+		data, _ := hex.DecodeString(s)
+		if _, err := Unpack(data); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// pairLeaf is a field of a flattened pair. The name is empty if the field has no annotation
+type pairLeaf struct {
+	name  string
+	value *Node
+	typ   *Node
+}
+
+// fieldName returns the field annotation of the type without the leading `%'
+func fieldName(typ *Node) string {
+	for _, a := range typ.Annots {
+		if strings.HasPrefix(a, "%") && len(a) > 1 {
+			return a[1:]
+		}
+	}
+	return ""
+}
+
+// Decode converts the value of the given type to plain values suitable for JSON encoding. Pairs whose leaves all have
+// field annotations become objects and other pairs become arrays, nested pairs without annotations are flattened.
+// Numbers are decimal strings, bytes are hex encoded and values of other types like lambdas are returned as is
+func Decode(value, typ *Node) (interface{}, error) {
+	mismatch := &TypeError{Type: typ, Value: value}
+	if typ.Kind != KindPrim {
+		return nil, mismatch
+	}
+
+	switch typ.Prim {
+	case "int", "nat", "mutez":
+		if value.Kind != KindInt {
+			return nil, mismatch
+		}
+		return value.Int.String(), nil
+
+	case "string":
+		if value.Kind != KindString {
+			return nil, mismatch
+		}
+		return value.Str, nil
+
+	case "bytes", "bls12_381_g1", "bls12_381_g2", "chest", "chest_key":
+		if value.Kind != KindBytes {
+			return nil, mismatch
+		}
+		return hex.EncodeToString(value.Bytes), nil
+
+	case "bool":
+		if !value.IsPrim("True") && !value.IsPrim("False") {
+			return nil, mismatch
+		}
+		return value.Prim == "True", nil
+
+	case "unit":
+		if !value.IsPrim("Unit") {
+			return nil, mismatch
+		}
+		return nil, nil
+
+	case "timestamp", "key_hash", "address", "contract", "key", "signature", "chain_id":
+		v, err := Readable(value, typ)
+		if err != nil {
+			return nil, err
+		}
+		switch v.Kind {
+		case KindString:
+			return v.Str, nil
+		case KindInt:
+			return v.Int.String(), nil
+		}
+		return nil, mismatch
+
+	case "option":
+		if len(typ.Args) != 1 {
+			break
+		}
+		if value.IsPrim("None") {
+			return nil, nil
+		}
+		if value.IsPrim("Some") && len(value.Args) == 1 {
+			return Decode(value.Args[0], typ.Args[0])
+		}
+		return nil, mismatch
+
+	case "or":
+		if len(typ.Args) != 2 || len(value.Args) != 1 {
+			return nil, mismatch
+		}
+		var (
+			t    *Node
+			name string
+		)
+		switch {
+		case value.IsPrim("Left"):
+			t, name = typ.Args[0], "left"
+		case value.IsPrim("Right"):
+			t, name = typ.Args[1], "right"
+		default:
+			return nil, mismatch
+		}
+		if n := fieldName(t); n != "" {
+			name = n
+		}
+		v, err := Decode(value.Args[0], t)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{name: v}, nil
+
+	case "pair":
+		leaves, err := pairLeaves(value, typ, nil)
+		if err != nil {
+			return nil, err
+		}
+		obj := make(map[string]interface{}, len(leaves))
+		list := make([]interface{}, len(leaves))
+		for i, l := range leaves {
+			v, err := Decode(l.value, l.typ)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+			if l.name != "" && obj != nil {
+				obj[l.name] = v
+			} else {
+				obj = nil
+			}
+		}
+		if obj != nil && len(obj) == len(leaves) {
+			return obj, nil
+		}
+		return list, nil
+
+	case "list", "set":
+		if len(typ.Args) != 1 {
+			break
+		}
+		if value.Kind != KindSeq {
+			return nil, mismatch
+		}
+		list := make([]interface{}, len(value.Args))
+		for i, e := range value.Args {
+			v, err := Decode(e, typ.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+
+	case "map", "big_map":
+		if len(typ.Args) != 2 {
+			break
+		}
+		if typ.Prim == "big_map" && value.Kind == KindInt {
+			return value.Int.String(), nil
+		}
+		if value.Kind != KindSeq {
+			return nil, mismatch
+		}
+		// Keys decoded to strings make an object, others a list of key/value pairs
+		obj := make(map[string]interface{}, len(value.Args))
+		list := make([]interface{}, len(value.Args))
+		for i, e := range value.Args {
+			if !e.IsPrim("Elt") || len(e.Args) != 2 {
+				return nil, mismatch
+			}
+			k, err := Decode(e.Args[0], typ.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			v, err := Decode(e.Args[1], typ.Args[1])
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := k.(string); ok && obj != nil {
+				obj[s] = v
+			} else {
+				obj = nil
+			}
+			list[i] = map[string]interface{}{"key": k, "value": v}
+		}
+		if obj != nil {
+			return obj, nil
+		}
+		return list, nil
+
+	default:
+		return value, nil
+	}
+
+	return nil, mismatch
+}
+
+// pairLeaves appends the pair's fields to leaves flattening nested pairs without field annotations
+func pairLeaves(value, typ *Node, leaves []pairLeaf) ([]pairLeaf, error) {
+	if !value.IsPrim("Pair") && value.Kind != KindSeq {
+		return nil, &TypeError{Type: typ, Value: value}
+	}
+	t, err := unfoldComb(typ, "pair")
+	if err != nil {
+		return nil, err
+	}
+	v, err := unfoldComb(value, "Pair")
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 2; i++ {
+		name := fieldName(t.Args[i])
+		if t.Args[i].IsPrim("pair") && name == "" {
+			if leaves, err = pairLeaves(v.Args[i], t.Args[i], leaves); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		leaves = append(leaves, pairLeaf{name: name, value: v.Args[i], typ: t.Args[i]})
+	}
+	return leaves, nil
+}
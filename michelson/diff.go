@@ -0,0 +1,340 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import (
+	"strconv"
+)
+
+// Change kinds
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeUpdated = "updated"
+)
+
+// Change is a difference between two values at the path. Old is nil for added values and New is nil for removed ones.
+// Values are in the human readable form
+type Change struct {
+	Path string `json:"path" yaml:"path"`
+	Kind string `json:"kind" yaml:"kind"`
+	Old  *Node  `json:"old,omitempty" yaml:"old,omitempty"`
+	New  *Node  `json:"new,omitempty" yaml:"new,omitempty"`
+}
+
+// BigMapRef is a big map found in a value
+type BigMapRef struct {
+	Path      string
+	ID        string
+	KeyType   *Node
+	ValueType *Node
+}
+
+// Diff compares two values of the given type. Paths start with the root and are made of field annotations or
+// indices of unannotated fields, list indices and map keys, e.g. storage.ledger["tz1..."].balance.
+// Big maps are compared by their IDs
+func Diff(a, b, typ *Node, root string) ([]*Change, error) {
+	changes := make([]*Change, 0)
+	if err := diff(root, a, b, typ, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func equal(a, b *Node) bool {
+	return a.String() == b.String()
+}
+
+// NewChange returns the change of the value at the path. Either of the values may be nil
+func NewChange(path string, a, b, typ *Node) (*Change, error) {
+	c := Change{Path: path, Kind: ChangeUpdated}
+	var err error
+	if a != nil {
+		if c.Old, err = Readable(a, typ); err != nil {
+			return nil, err
+		}
+	} else {
+		c.Kind = ChangeAdded
+	}
+	if b != nil {
+		if c.New, err = Readable(b, typ); err != nil {
+			return nil, err
+		}
+	} else {
+		c.Kind = ChangeRemoved
+	}
+	return &c, nil
+}
+
+func fieldPath(path, name string, i int) string {
+	if name == "" {
+		name = strconv.Itoa(i)
+	}
+	return path + "." + name
+}
+
+// keyPath returns the path of the map key or the set element
+func keyPath(path string, key, typ *Node) (string, error) {
+	k, err := Readable(key, typ)
+	if err != nil {
+		return "", err
+	}
+	return path + "[" + k.String() + "]", nil
+}
+
+func diff(path string, a, b, typ *Node, changes *[]*Change) error {
+	if equal(a, b) {
+		return nil
+	}
+
+	change := func(path string, a, b, typ *Node) error {
+		c, err := NewChange(path, a, b, typ)
+		if err != nil {
+			return err
+		}
+		*changes = append(*changes, c)
+		return nil
+	}
+
+	if typ.Kind != KindPrim {
+		return change(path, a, b, typ)
+	}
+
+	switch typ.Prim {
+	case "pair":
+		la, err := pairLeaves(a, typ, nil)
+		if err != nil {
+			return err
+		}
+		lb, err := pairLeaves(b, typ, nil)
+		if err != nil {
+			return err
+		}
+		for i := range la {
+			if err := diff(fieldPath(path, la[i].name, i), la[i].value, lb[i].value, la[i].typ, changes); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "option":
+		if a.IsPrim("Some") && b.IsPrim("Some") && len(a.Args) == 1 && len(b.Args) == 1 && len(typ.Args) == 1 {
+			return diff(path, a.Args[0], b.Args[0], typ.Args[0], changes)
+		}
+
+	case "or":
+		if a.Kind == KindPrim && a.Prim == b.Prim && len(a.Args) == 1 && len(b.Args) == 1 && len(typ.Args) == 2 {
+			t := typ.Args[0]
+			if a.Prim == "Right" {
+				t = typ.Args[1]
+			}
+			if name := fieldName(t); name != "" {
+				path += "." + name
+			}
+			return diff(path, a.Args[0], b.Args[0], t, changes)
+		}
+
+	case "list":
+		if a.Kind != KindSeq || b.Kind != KindSeq || len(typ.Args) != 1 {
+			break
+		}
+		for i := 0; i < len(a.Args) || i < len(b.Args); i++ {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			var err error
+			switch {
+			case i >= len(b.Args):
+				err = change(p, a.Args[i], nil, typ.Args[0])
+			case i >= len(a.Args):
+				err = change(p, nil, b.Args[i], typ.Args[0])
+			default:
+				err = diff(p, a.Args[i], b.Args[i], typ.Args[0], changes)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "set", "map", "big_map":
+		args := 2
+		if typ.Prim == "set" {
+			args = 1
+		}
+		if a.Kind != KindSeq || b.Kind != KindSeq || len(typ.Args) != args {
+			break
+		}
+		// Set elements are compared as keys without values
+		entry := func(e *Node) (key, value *Node) {
+			if typ.Prim == "set" {
+				return e, nil
+			}
+			if e.IsPrim("Elt") && len(e.Args) == 2 {
+				return e.Args[0], e.Args[1]
+			}
+			return nil, nil
+		}
+		// Keys are matched by their readable form as they may come optimized or not
+		id := func(k *Node) (string, error) {
+			r, err := Readable(k, typ.Args[0])
+			if err != nil {
+				return "", err
+			}
+			return r.String(), nil
+		}
+		old := make(map[string]*Node, len(a.Args))
+		for _, e := range a.Args {
+			k, v := entry(e)
+			if k == nil {
+				return &TypeError{Type: typ, Value: a}
+			}
+			s, err := id(k)
+			if err != nil {
+				return err
+			}
+			old[s] = v
+		}
+		seen := make(map[string]bool, len(b.Args))
+		for _, e := range b.Args {
+			k, v := entry(e)
+			if k == nil {
+				return &TypeError{Type: typ, Value: b}
+			}
+			s, err := id(k)
+			if err != nil {
+				return err
+			}
+			seen[s] = true
+			p := path + "[" + s + "]"
+			ov, ok := old[s]
+			switch {
+			case !ok && typ.Prim == "set":
+				err = change(p, nil, k, typ.Args[0])
+			case !ok:
+				err = change(p, nil, v, typ.Args[1])
+			case typ.Prim != "set":
+				err = diff(p, ov, v, typ.Args[1], changes)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, e := range a.Args {
+			k, v := entry(e)
+			s, err := id(k)
+			if err != nil {
+				return err
+			}
+			if seen[s] {
+				continue
+			}
+			p := path + "[" + s + "]"
+			if typ.Prim == "set" {
+				err = change(p, k, nil, typ.Args[0])
+			} else {
+				err = change(p, v, nil, typ.Args[1])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return change(path, a, b, typ)
+}
+
+// BigMaps returns big maps referenced by the value using the same paths as Diff
+func BigMaps(value, typ *Node, root string) ([]*BigMapRef, error) {
+	var refs []*BigMapRef
+	if err := bigMaps(root, value, typ, &refs); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func bigMaps(path string, value, typ *Node, refs *[]*BigMapRef) error {
+	if typ.Kind != KindPrim {
+		return nil
+	}
+	switch typ.Prim {
+	case "big_map":
+		if value.Kind == KindInt && len(typ.Args) == 2 {
+			*refs = append(*refs, &BigMapRef{Path: path, ID: value.Int.String(), KeyType: typ.Args[0], ValueType: typ.Args[1]})
+		}
+
+	case "pair":
+		leaves, err := pairLeaves(value, typ, nil)
+		if err != nil {
+			return err
+		}
+		for i, l := range leaves {
+			if err := bigMaps(fieldPath(path, l.name, i), l.value, l.typ, refs); err != nil {
+				return err
+			}
+		}
+
+	case "option":
+		if value.IsPrim("Some") && len(value.Args) == 1 && len(typ.Args) == 1 {
+			return bigMaps(path, value.Args[0], typ.Args[0], refs)
+		}
+
+	case "or":
+		if len(value.Args) != 1 || len(typ.Args) != 2 {
+			break
+		}
+		t := typ.Args[0]
+		if value.IsPrim("Right") {
+			t = typ.Args[1]
+		}
+		if name := fieldName(t); name != "" {
+			path += "." + name
+		}
+		return bigMaps(path, value.Args[0], t, refs)
+
+	case "list":
+		if value.Kind != KindSeq || len(typ.Args) != 1 {
+			break
+		}
+		for i, e := range value.Args {
+			if err := bigMaps(path+"["+strconv.Itoa(i)+"]", e, typ.Args[0], refs); err != nil {
+				return err
+			}
+		}
+
+	case "map":
+		if value.Kind != KindSeq || len(typ.Args) != 2 {
+			break
+		}
+		for _, e := range value.Args {
+			if !e.IsPrim("Elt") || len(e.Args) != 2 {
+				return &TypeError{Type: typ, Value: value}
+			}
+			p, err := keyPath(path, e.Args[0], typ.Args[0])
+			if err != nil {
+				return err
+			}
+			if err := bigMaps(p, e.Args[1], typ.Args[1], refs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
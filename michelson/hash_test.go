@@ -0,0 +1,56 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestExprHash(t *testing.T) {
+	tests := []struct {
+		packed string
+		hash   string
+	}{
+		{"05010000000568656c6c6f", "exprtsjEVVZk3Gm82U9wEs8kvwRiQwUT7zipJwvCeFMNsApe2tQ15s"},
+		{"050000", "exprtZBwZUeYYYfUs9B9Rg2ywHezVHnCCnmF9WsDQVrs582dSK63dC"},
+		{"050001", "expru2dKqDfZG8hu4wNGkiyunvq2hdSKuVYtcKta7BWP6Q18oNxKjS"},
+		{"050041", "expru57wdzZCHCeGnKwUzxCJjG1HjveGXp1CCusScXEMq9kbidSvDG"},
+		{"050080897a", "exprujt4hi1p1VvCnTsjo4H6iGSftNWsKzD12TndTS66vNM5vVsxmV"},
+		{"05030b", "expruaDPoTWXcTR6fiQPy4KZSW72U6Swc1rVmMiP1KdwmCceeEpVjd"},
+		{"05030a", "exprvMjTcyX8e8HskSUV3ipMhPzMLKTn92vsWZkiJTTYj7U9J8sAyP"},
+		{"050a0000000200ff", "expru4JXyv6hSZT5A6T6qXAgU76Xx86dhUiYfV33vTGKPCFipcDVWP"},
+		{"0507070001010000000161", "exprudzUxReEkPaznwAZsbfJJWREoJMHrygw3T6ksfTQKxQaiVwXeE"},
+		{"05020000000400010002", "exprtsWSowJobkYfrcddi8wiYtJXW1UfBiJpJ75ca3GME6knXczXLW"},
+		{"050a00000016000002298c03ed7d454a101eb7022bc95f7e5f41ac78", "expruH3qgknRBJVLVkwdzf6wfBxd7Y1uqNxr7zuMFxTC12e5PacLfv"},
+		{"050a0000001601a3d0f58d8964bd1b37fb0a0c197b38cf46608d4900", "exprvAHu1SyoiSzyh9w7GPfifvyrNiMb442y7Q2MA8tcPCGPajxRH6"},
+	}
+
+	for _, tt := range tests {
+		packed, err := hex.DecodeString(tt.packed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := ExprHash(packed); h != tt.hash {
+			t.Errorf("%s: got %s, want %s", tt.packed, h, tt.hash)
+		}
+	}
+}
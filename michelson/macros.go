@@ -0,0 +1,140 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import "strings"
+
+// Comparison instructions used by CMPxx, IFxx and ASSERT_xx macros
+var comparisons = map[string]bool{
+	"EQ":  true,
+	"NEQ": true,
+	"LT":  true,
+	"GT":  true,
+	"LE":  true,
+	"GE":  true,
+}
+
+// isBare returns true if the node is an application of the primitive without annotations
+func isBare(n *Node, prim string, args int) bool {
+	return n.IsPrim(prim) && len(n.Args) == args && len(n.Annots) == 0
+}
+
+func isEmptySeq(n *Node) bool {
+	return n.Kind == KindSeq && len(n.Args) == 0
+}
+
+// isFailBranch returns true for { FAIL } after the inner macro was collapsed
+func isFailBranch(n *Node) bool {
+	return n.Kind == KindSeq && len(n.Args) == 1 && isBare(n.Args[0], "FAIL", 0)
+}
+
+func comparison(n *Node) (string, bool) {
+	if n.Kind == KindPrim && len(n.Args) == 0 && len(n.Annots) == 0 && comparisons[n.Prim] {
+		return n.Prim, true
+	}
+	return "", false
+}
+
+// CollapseMacros returns a copy of the expression with expansions of common macros (FAIL, ASSERT*, CMPxx, IFxx, IFCMPxx, CxR)
+// replaced by the macros themselves. Expansions carrying annotations are left as is
+func CollapseMacros(n *Node) *Node {
+	if n.Kind != KindSeq && n.Kind != KindPrim {
+		return n
+	}
+	c := *n
+	if n.Args != nil {
+		c.Args = make([]*Node, len(n.Args))
+		for i, a := range n.Args {
+			c.Args[i] = CollapseMacros(a)
+		}
+	}
+	if c.Kind == KindSeq {
+		if m := collapseSeq(c.Args); m != nil {
+			return m
+		}
+	}
+	return &c
+}
+
+func collapseSeq(s []*Node) *Node {
+	switch len(s) {
+	case 1:
+		x := s[0]
+		switch {
+		case isBare(x, "IF", 2) && isEmptySeq(x.Args[0]) && isFailBranch(x.Args[1]):
+			return NewPrim("ASSERT")
+		case isBare(x, "IF_NONE", 2) && isEmptySeq(x.Args[0]) && isFailBranch(x.Args[1]):
+			return NewPrim("ASSERT_NONE")
+		case isBare(x, "IF_NONE", 2) && isFailBranch(x.Args[0]) && isEmptySeq(x.Args[1]):
+			return NewPrim("ASSERT_SOME")
+		case isBare(x, "IF_LEFT", 2) && isEmptySeq(x.Args[0]) && isFailBranch(x.Args[1]):
+			return NewPrim("ASSERT_LEFT")
+		case isBare(x, "IF_LEFT", 2) && isFailBranch(x.Args[0]) && isEmptySeq(x.Args[1]):
+			return NewPrim("ASSERT_RIGHT")
+		}
+
+	case 2:
+		x, y := s[0], s[1]
+		if isBare(x, "UNIT", 0) && isBare(y, "FAILWITH", 0) {
+			return NewPrim("FAIL")
+		}
+		if op, ok := comparison(y); ok && isBare(x, "COMPARE", 0) {
+			return NewPrim("CMP" + op)
+		}
+		if isBare(y, "IF", 2) {
+			assert := isEmptySeq(y.Args[0]) && isFailBranch(y.Args[1])
+			if op, ok := comparison(x); ok {
+				if assert {
+					return NewPrim("ASSERT_" + op)
+				}
+				return NewPrim("IF"+op, y.Args...)
+			}
+			if x.Kind == KindPrim && len(x.Args) == 0 && strings.HasPrefix(x.Prim, "CMP") && comparisons[x.Prim[3:]] && assert {
+				return NewPrim("ASSERT_" + x.Prim)
+			}
+		}
+
+	case 3:
+		if op, ok := comparison(s[1]); ok && isBare(s[0], "COMPARE", 0) && isBare(s[2], "IF", 2) {
+			if isEmptySeq(s[2].Args[0]) && isFailBranch(s[2].Args[1]) {
+				return NewPrim("ASSERT_CMP" + op)
+			}
+			return NewPrim("IFCMP"+op, s[2].Args...)
+		}
+	}
+
+	// CxR
+	if len(s) < 2 {
+		return nil
+	}
+	name := []byte{'C'}
+	for _, x := range s {
+		switch {
+		case isBare(x, "CAR", 0):
+			name = append(name, 'A')
+		case isBare(x, "CDR", 0):
+			name = append(name, 'D')
+		default:
+			return nil
+		}
+	}
+	return NewPrim(string(append(name, 'R')))
+}
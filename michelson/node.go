@@ -0,0 +1,167 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package michelson implements Micheline expressions, their text and binary representations
+package michelson
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Kind is a Micheline node kind
+type Kind int
+
+// Node kinds
+const (
+	KindInt Kind = iota
+	KindString
+	KindBytes
+	KindSeq
+	KindPrim
+)
+
+// Node is a Micheline expression node. Sequence elements and primitive arguments are stored in Args
+type Node struct {
+	Kind   Kind
+	Int    *big.Int
+	Str    string
+	Bytes  []byte
+	Prim   string
+	Args   []*Node
+	Annots []string
+}
+
+// NewInt returns new integer node
+func NewInt(v *big.Int) *Node {
+	return &Node{Kind: KindInt, Int: v}
+}
+
+// NewString returns new string node
+func NewString(s string) *Node {
+	return &Node{Kind: KindString, Str: s}
+}
+
+// NewBytes returns new bytes node
+func NewBytes(b []byte) *Node {
+	return &Node{Kind: KindBytes, Bytes: b}
+}
+
+// NewSeq returns new sequence node
+func NewSeq(args ...*Node) *Node {
+	return &Node{Kind: KindSeq, Args: args}
+}
+
+// NewPrim returns new primitive application node
+func NewPrim(prim string, args ...*Node) *Node {
+	return &Node{Kind: KindPrim, Prim: prim, Args: args}
+}
+
+// IsPrim returns true if the node is an application of the primitive
+func (n *Node) IsPrim(prim string) bool {
+	return n.Kind == KindPrim && n.Prim == prim
+}
+
+type jsonNode struct {
+	Int    *string           `json:"int,omitempty"`
+	String *string           `json:"string,omitempty"`
+	Bytes  *string           `json:"bytes,omitempty"`
+	Prim   *string           `json:"prim,omitempty"`
+	Args   []json.RawMessage `json:"args,omitempty"`
+	Annots []string          `json:"annots,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (n *Node) MarshalJSON() ([]byte, error) {
+	switch n.Kind {
+	case KindInt:
+		return json.Marshal(map[string]string{"int": n.Int.String()})
+	case KindString:
+		return json.Marshal(map[string]string{"string": n.Str})
+	case KindBytes:
+		return json.Marshal(map[string]string{"bytes": hex.EncodeToString(n.Bytes)})
+	case KindSeq:
+		args := n.Args
+		if args == nil {
+			args = []*Node{}
+		}
+		return json.Marshal(args)
+	case KindPrim:
+		v := struct {
+			Prim   string   `json:"prim"`
+			Args   []*Node  `json:"args,omitempty"`
+			Annots []string `json:"annots,omitempty"`
+		}{n.Prim, n.Args, n.Annots}
+		return json.Marshal(&v)
+	}
+	return nil, fmt.Errorf("michelson: unknown node kind %d", n.Kind)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *Node) UnmarshalJSON(data []byte) error {
+	var seq []*Node
+	if err := json.Unmarshal(data, &seq); err == nil {
+		*n = Node{Kind: KindSeq, Args: seq}
+		return nil
+	}
+
+	var v jsonNode
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch {
+	case v.Int != nil:
+		x, ok := new(big.Int).SetString(*v.Int, 10)
+		if !ok {
+			return fmt.Errorf("michelson: invalid integer: %s", *v.Int)
+		}
+		*n = Node{Kind: KindInt, Int: x}
+
+	case v.String != nil:
+		*n = Node{Kind: KindString, Str: *v.String}
+
+	case v.Bytes != nil:
+		b, err := hex.DecodeString(*v.Bytes)
+		if err != nil {
+			return err
+		}
+		*n = Node{Kind: KindBytes, Bytes: b}
+
+	case v.Prim != nil:
+		args := make([]*Node, len(v.Args))
+		for i, a := range v.Args {
+			var arg Node
+			if err := json.Unmarshal(a, &arg); err != nil {
+				return err
+			}
+			args[i] = &arg
+		}
+		*n = Node{Kind: KindPrim, Prim: *v.Prim, Args: args, Annots: v.Annots}
+
+	default:
+		return errors.New("michelson: invalid Micheline JSON node")
+	}
+
+	return nil
+}
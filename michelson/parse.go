@@ -0,0 +1,348 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokInt
+	tokString
+	tokBytes
+	tokIdent
+	tokAnnot
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+// SyntaxError is returned by the parser
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("michelson: syntax error at %d: %s", e.Pos, e.Msg)
+}
+
+type scanner struct {
+	src string
+	pos int
+}
+
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' }
+
+func (s *scanner) skip() error {
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			s.pos++
+		case c == '#':
+			for s.pos < len(s.src) && s.src[s.pos] != '\n' {
+				s.pos++
+			}
+		case strings.HasPrefix(s.src[s.pos:], "/*"):
+			i := strings.Index(s.src[s.pos+2:], "*/")
+			if i < 0 {
+				return &SyntaxError{s.pos, "unterminated comment"}
+			}
+			s.pos += i + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *scanner) next() (*token, error) {
+	if err := s.skip(); err != nil {
+		return nil, err
+	}
+	if s.pos == len(s.src) {
+		return &token{kind: tokEOF, pos: s.pos}, nil
+	}
+
+	start := s.pos
+	c := s.src[s.pos]
+
+	switch {
+	case c == '(' || c == ')' || c == '{' || c == '}' || c == ';':
+		s.pos++
+		return &token{kind: tokPunct, val: string(c), pos: start}, nil
+
+	case c == '"':
+		var b strings.Builder
+		s.pos++
+		for {
+			if s.pos == len(s.src) {
+				return nil, &SyntaxError{start, "unterminated string"}
+			}
+			c := s.src[s.pos]
+			s.pos++
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				if s.pos == len(s.src) {
+					return nil, &SyntaxError{start, "unterminated string"}
+				}
+				e := s.src[s.pos]
+				s.pos++
+				switch e {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case 'r':
+					b.WriteByte('\r')
+				case 'b':
+					b.WriteByte('\b')
+				case '\\', '"':
+					b.WriteByte(e)
+				default:
+					return nil, &SyntaxError{s.pos - 2, "invalid escape sequence"}
+				}
+				continue
+			}
+			b.WriteByte(c)
+		}
+		return &token{kind: tokString, val: b.String(), pos: start}, nil
+
+	case strings.HasPrefix(s.src[s.pos:], "0x"):
+		s.pos += 2
+		for s.pos < len(s.src) && strings.IndexByte("0123456789abcdefABCDEF", s.src[s.pos]) >= 0 {
+			s.pos++
+		}
+		return &token{kind: tokBytes, val: s.src[start+2 : s.pos], pos: start}, nil
+
+	case isDigit(c) || c == '-':
+		s.pos++
+		for s.pos < len(s.src) && isDigit(s.src[s.pos]) {
+			s.pos++
+		}
+		if s.src[start:s.pos] == "-" {
+			return nil, &SyntaxError{start, "invalid number"}
+		}
+		return &token{kind: tokInt, val: s.src[start:s.pos], pos: start}, nil
+
+	case isLetter(c):
+		for s.pos < len(s.src) && (isLetter(s.src[s.pos]) || isDigit(s.src[s.pos]) || s.src[s.pos] == '.') {
+			s.pos++
+		}
+		return &token{kind: tokIdent, val: s.src[start:s.pos], pos: start}, nil
+
+	case c == '@' || c == ':' || c == '%':
+		s.pos++
+		for s.pos < len(s.src) && (isLetter(s.src[s.pos]) || isDigit(s.src[s.pos]) || strings.IndexByte(".%@", s.src[s.pos]) >= 0) {
+			s.pos++
+		}
+		return &token{kind: tokAnnot, val: s.src[start:s.pos], pos: start}, nil
+	}
+
+	return nil, &SyntaxError{start, fmt.Sprintf("unexpected character `%c'", c)}
+}
+
+type parser struct {
+	tokens []*token
+	pos    int
+}
+
+func (p *parser) peek() *token { return p.tokens[p.pos] }
+
+func (p *parser) advance() *token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isPunct(v string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.val == v
+}
+
+func (p *parser) expect(v string) error {
+	if !p.isPunct(v) {
+		return &SyntaxError{p.peek().pos, fmt.Sprintf("`%s' expected", v)}
+	}
+	p.advance()
+	return nil
+}
+
+// Parse parses Michelson expression in the text notation
+func Parse(src string) (*Node, error) {
+	s := scanner{src: src}
+	var tokens []*token
+	for {
+		t, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.kind == tokEOF {
+			break
+		}
+	}
+
+	p := parser{tokens: tokens}
+
+	// Top level may be a sequence without braces (i.e. a script)
+	seq, semicolon, err := p.parseSeqBody()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &SyntaxError{t.pos, "unexpected token"}
+	}
+
+	if len(seq) == 1 && !semicolon {
+		return seq[0], nil
+	}
+	return NewSeq(seq...), nil
+}
+
+// ParseAny accepts either Micheline JSON or Michelson text notation
+func ParseAny(src string) (*Node, error) {
+	trimmed := strings.TrimSpace(src)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{\"") || strings.HasPrefix(trimmed, "{ \"") {
+		var n Node
+		if err := json.Unmarshal([]byte(trimmed), &n); err == nil {
+			return &n, nil
+		}
+	}
+	return Parse(src)
+}
+
+// Returns true if at least one separator was seen
+func (p *parser) parseSeqBody() ([]*Node, bool, error) {
+	var semicolon bool
+	seq := []*Node{}
+	for {
+		if t := p.peek(); t.kind == tokEOF || p.isPunct("}") {
+			return seq, semicolon, nil
+		}
+
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, false, err
+		}
+		seq = append(seq, n)
+
+		if !p.isPunct(";") {
+			return seq, semicolon, nil
+		}
+		p.advance()
+		semicolon = true
+	}
+}
+
+// Primitive application or a single term
+func (p *parser) parseExpr() (*Node, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return p.parseTerm()
+	}
+	p.advance()
+
+	n := NewPrim(t.val)
+	for p.peek().kind == tokAnnot {
+		n.Annots = append(n.Annots, p.advance().val)
+	}
+
+	for {
+		t := p.peek()
+		if t.kind == tokEOF || t.kind == tokAnnot || t.kind == tokPunct && t.val != "(" && t.val != "{" {
+			break
+		}
+		arg, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		n.Args = append(n.Args, arg)
+	}
+
+	return n, nil
+}
+
+func (p *parser) parseTerm() (*Node, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokInt:
+		v, ok := new(big.Int).SetString(t.val, 10)
+		if !ok {
+			return nil, &SyntaxError{t.pos, "invalid number"}
+		}
+		return NewInt(v), nil
+
+	case tokString:
+		return NewString(t.val), nil
+
+	case tokBytes:
+		b, err := hex.DecodeString(t.val)
+		if err != nil {
+			return nil, &SyntaxError{t.pos, "invalid bytes"}
+		}
+		return NewBytes(b), nil
+
+	case tokIdent:
+		// Bare primitive
+		n := NewPrim(t.val)
+		for p.peek().kind == tokAnnot {
+			n.Annots = append(n.Annots, p.advance().val)
+		}
+		return n, nil
+
+	case tokPunct:
+		switch t.val {
+		case "(":
+			n, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+
+		case "{":
+			seq, _, err := p.parseSeqBody()
+			if err != nil {
+				return nil, err
+			}
+			return NewSeq(seq...), p.expect("}")
+		}
+	}
+
+	return nil, &SyntaxError{t.pos, "unexpected token"}
+}
@@ -0,0 +1,160 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// String returns the expression in Michelson text notation
+func (n *Node) String() string {
+	var b strings.Builder
+	n.format(&b, false)
+	return b.String()
+}
+
+// Format returns the expression in Michelson text notation broken into indented lines to fit the width,
+// similar to the way octez-client prints scripts
+func (n *Node) Format(width int) string {
+	var b strings.Builder
+	n.formatIndented(&b, 0, width, false)
+	return b.String()
+}
+
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\b':
+			b.WriteString("\\b")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func (n *Node) format(b *strings.Builder, arg bool) {
+	switch n.Kind {
+	case KindInt:
+		b.WriteString(n.Int.String())
+
+	case KindString:
+		b.WriteString(quote(n.Str))
+
+	case KindBytes:
+		b.WriteString("0x")
+		b.WriteString(hex.EncodeToString(n.Bytes))
+
+	case KindSeq:
+		if len(n.Args) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		b.WriteString("{ ")
+		for i, a := range n.Args {
+			if i != 0 {
+				b.WriteString(" ; ")
+			}
+			a.format(b, false)
+		}
+		b.WriteString(" }")
+
+	case KindPrim:
+		paren := arg && (len(n.Args) != 0 || len(n.Annots) != 0)
+		if paren {
+			b.WriteByte('(')
+		}
+		b.WriteString(n.Prim)
+		for _, a := range n.Annots {
+			b.WriteByte(' ')
+			b.WriteString(a)
+		}
+		for _, a := range n.Args {
+			b.WriteByte(' ')
+			a.format(b, true)
+		}
+		if paren {
+			b.WriteByte(')')
+		}
+	}
+}
+
+// formatIndented writes the node starting at the column. Expressions which don't fit the width are broken into lines:
+// sequence elements are aligned after the opening brace and primitive arguments are indented by two columns
+func (n *Node) formatIndented(b *strings.Builder, col, width int, arg bool) {
+	var line strings.Builder
+	n.format(&line, arg)
+	if col+line.Len() <= width || len(n.Args) == 0 {
+		b.WriteString(line.String())
+		return
+	}
+
+	switch n.Kind {
+	case KindSeq:
+		b.WriteString("{ ")
+		for i, a := range n.Args {
+			if i != 0 {
+				b.WriteString(" ;\n")
+				b.WriteString(strings.Repeat(" ", col+2))
+			}
+			a.formatIndented(b, col+2, width, false)
+		}
+		b.WriteString(" }")
+
+	case KindPrim:
+		if arg {
+			b.WriteByte('(')
+			col++
+		}
+		head := n.Prim
+		for _, a := range n.Annots {
+			head += " " + a
+		}
+		b.WriteString(head)
+		if len(n.Args) == 1 && n.Args[0].Kind == KindSeq {
+			// code { ... }
+			b.WriteByte(' ')
+			n.Args[0].formatIndented(b, col+len(head)+1, width, true)
+		} else {
+			for _, a := range n.Args {
+				b.WriteByte('\n')
+				b.WriteString(strings.Repeat(" ", col+2))
+				a.formatIndented(b, col+2, width, true)
+			}
+		}
+		if arg {
+			b.WriteByte(')')
+		}
+	}
+}
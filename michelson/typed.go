@@ -0,0 +1,631 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package michelson
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ecadlabs/tez/base58"
+)
+
+// TypeError is returned when the value doesn't match the type
+type TypeError struct {
+	Type  *Node
+	Value *Node
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("michelson: value `%v' doesn't match type `%v'", e.Value, e.Type)
+}
+
+var (
+	implicitPrefixes = []*base58.Prefix{
+		base58.PrefixEd25519PublicKeyHash,
+		base58.PrefixSecp256k1PublicKeyHash,
+		base58.PrefixP256PublicKeyHash,
+		base58.PrefixBLS12381PublicKeyHash,
+	}
+
+	publicKeyPrefixes = []*base58.Prefix{
+		base58.PrefixEd25519PublicKey,
+		base58.PrefixSecp256k1PublicKey,
+		base58.PrefixP256PublicKey,
+		base58.PrefixBLS12381PublicKey,
+	}
+
+	// Originated address kinds indexed by the binary tag
+	originatedPrefixes = []*base58.Prefix{
+		1: base58.PrefixContractHash,
+		2: base58.PrefixTxRollupAddress,
+		3: base58.PrefixSmartRollupAddress,
+	}
+)
+
+// Largest mutez amount, amounts are signed 64 bit integers in the protocol
+var maxMutez = big.NewInt(1<<63 - 1)
+
+func prefixIndex(list []*base58.Prefix, p *base58.Prefix) int {
+	for i, x := range list {
+		if x == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// Right comb representation of the pair type or value. Sequence notation is accepted too
+func unfoldComb(n *Node, prim string) (*Node, error) {
+	args := n.Args
+	if len(args) < 2 {
+		return nil, fmt.Errorf("michelson: `%v': pair must have at least two elements", n)
+	}
+	if len(args) == 2 {
+		return NewPrim(prim, args...), nil
+	}
+	rest, err := unfoldComb(&Node{Kind: KindPrim, Prim: prim, Args: args[1:]}, prim)
+	if err != nil {
+		return nil, err
+	}
+	return NewPrim(prim, args[0], rest), nil
+}
+
+func encodeKeyHash(s string) ([]byte, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	i := prefixIndex(implicitPrefixes, p)
+	if i < 0 {
+		return nil, fmt.Errorf("michelson: `%s' is not a public key hash", s)
+	}
+	return append([]byte{byte(i)}, payload...), nil
+}
+
+func encodeAddress(s string) ([]byte, error) {
+	var entrypoint string
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s, entrypoint = s[:i], s[i+1:]
+	}
+
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if i := prefixIndex(implicitPrefixes, p); i >= 0 {
+		buf = append([]byte{0, byte(i)}, payload...)
+	} else if i := prefixIndex(originatedPrefixes, p); i > 0 {
+		buf = append(append([]byte{byte(i)}, payload...), 0)
+	} else {
+		return nil, fmt.Errorf("michelson: `%s' is not an address", s)
+	}
+
+	if entrypoint != "" && entrypoint != "default" {
+		buf = append(buf, entrypoint...)
+	}
+	return buf, nil
+}
+
+func encodeKey(s string) ([]byte, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	i := prefixIndex(publicKeyPrefixes, p)
+	if i < 0 {
+		return nil, fmt.Errorf("michelson: `%s' is not a public key", s)
+	}
+	return append([]byte{byte(i)}, payload...), nil
+}
+
+func encodeSignature(s string) ([]byte, error) {
+	p, payload, err := base58.DecodeAny(s)
+	if err != nil {
+		return nil, err
+	}
+	switch p {
+	case base58.PrefixEd25519Signature, base58.PrefixSecp256k1Signature, base58.PrefixP256Signature,
+		base58.PrefixGenericSignature, base58.PrefixBLS12381Signature:
+		return payload, nil
+	}
+	return nil, fmt.Errorf("michelson: `%s' is not a signature", s)
+}
+
+// Normalize converts the value of the given type to the optimized (binary friendly) form used by PACK
+func Normalize(value, typ *Node) (*Node, error) {
+	mismatch := &TypeError{Type: typ, Value: value}
+	if typ.Kind != KindPrim {
+		return nil, fmt.Errorf("michelson: invalid type `%v'", typ)
+	}
+
+	switch typ.Prim {
+	case "int", "nat", "mutez":
+		if value.Kind != KindInt {
+			return nil, mismatch
+		}
+		if typ.Prim != "int" && value.Int.Sign() < 0 {
+			return nil, fmt.Errorf("michelson: negative %s `%v'", typ.Prim, value)
+		}
+		if typ.Prim == "mutez" && value.Int.Cmp(maxMutez) > 0 {
+			return nil, fmt.Errorf("michelson: mutez `%v' overflows 64 bits", value)
+		}
+		return value, nil
+
+	case "string":
+		if value.Kind != KindString {
+			return nil, mismatch
+		}
+		return value, nil
+
+	case "bytes", "bls12_381_g1", "bls12_381_g2", "chest", "chest_key":
+		if value.Kind != KindBytes {
+			return nil, mismatch
+		}
+		return value, nil
+
+	case "bool":
+		if !value.IsPrim("True") && !value.IsPrim("False") {
+			return nil, mismatch
+		}
+		return value, nil
+
+	case "unit":
+		if !value.IsPrim("Unit") {
+			return nil, mismatch
+		}
+		return value, nil
+
+	case "timestamp":
+		switch value.Kind {
+		case KindInt:
+			return value, nil
+		case KindString:
+			t, err := time.Parse(time.RFC3339, value.Str)
+			if err != nil {
+				return nil, err
+			}
+			return NewInt(big.NewInt(t.Unix())), nil
+		}
+		return nil, mismatch
+
+	case "key_hash", "address", "contract", "key", "signature", "chain_id":
+		if value.Kind == KindBytes {
+			return value, nil
+		}
+		if value.Kind != KindString {
+			return nil, mismatch
+		}
+
+		var (
+			b   []byte
+			err error
+		)
+		switch typ.Prim {
+		case "key_hash":
+			b, err = encodeKeyHash(value.Str)
+		case "address", "contract":
+			b, err = encodeAddress(value.Str)
+		case "key":
+			b, err = encodeKey(value.Str)
+		case "signature":
+			b, err = encodeSignature(value.Str)
+		case "chain_id":
+			b, err = base58.PrefixChainID.Decode(value.Str)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return NewBytes(b), nil
+
+	case "option":
+		if len(typ.Args) != 1 {
+			break
+		}
+		if value.IsPrim("None") {
+			return value, nil
+		}
+		if value.IsPrim("Some") && len(value.Args) == 1 {
+			v, err := Normalize(value.Args[0], typ.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			return NewPrim("Some", v), nil
+		}
+		return nil, mismatch
+
+	case "or":
+		if len(typ.Args) != 2 {
+			break
+		}
+		var t *Node
+		switch {
+		case value.IsPrim("Left") && len(value.Args) == 1:
+			t = typ.Args[0]
+		case value.IsPrim("Right") && len(value.Args) == 1:
+			t = typ.Args[1]
+		default:
+			return nil, mismatch
+		}
+		v, err := Normalize(value.Args[0], t)
+		if err != nil {
+			return nil, err
+		}
+		return NewPrim(value.Prim, v), nil
+
+	case "pair":
+		if !value.IsPrim("Pair") && value.Kind != KindSeq {
+			return nil, mismatch
+		}
+		t, err := unfoldComb(typ, "pair")
+		if err != nil {
+			return nil, err
+		}
+		v, err := unfoldComb(value, "Pair")
+		if err != nil {
+			return nil, err
+		}
+		left, err := Normalize(v.Args[0], t.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := Normalize(v.Args[1], t.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		return NewPrim("Pair", left, right), nil
+
+	case "list", "set":
+		if len(typ.Args) != 1 {
+			break
+		}
+		if value.Kind != KindSeq {
+			return nil, mismatch
+		}
+		res := make([]*Node, len(value.Args))
+		for i, e := range value.Args {
+			v, err := Normalize(e, typ.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			if typ.Prim == "set" && i != 0 {
+				if err := checkOrder(typ.Prim, res[i-1], v); err != nil {
+					return nil, err
+				}
+			}
+			res[i] = v
+		}
+		return NewSeq(res...), nil
+
+	case "map", "big_map":
+		if len(typ.Args) != 2 {
+			break
+		}
+		if typ.Prim == "big_map" && value.Kind == KindInt {
+			return value, nil
+		}
+		if value.Kind != KindSeq {
+			return nil, mismatch
+		}
+		res := make([]*Node, len(value.Args))
+		for i, e := range value.Args {
+			if !e.IsPrim("Elt") || len(e.Args) != 2 {
+				return nil, mismatch
+			}
+			k, err := Normalize(e.Args[0], typ.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			v, err := Normalize(e.Args[1], typ.Args[1])
+			if err != nil {
+				return nil, err
+			}
+			if i != 0 {
+				if err := checkOrder(typ.Prim, res[i-1].Args[0], k); err != nil {
+					return nil, err
+				}
+			}
+			res[i] = NewPrim("Elt", k, v)
+		}
+		return NewSeq(res...), nil
+
+	default:
+		// Lambdas, tickets etc. are packed as is
+		return value, nil
+	}
+
+	return nil, fmt.Errorf("michelson: invalid type `%v'", typ)
+}
+
+// Order of the constructors of comparable types
+var primRanks = map[string]int{
+	"False": 0,
+	"True":  1,
+	"None":  0,
+	"Some":  1,
+	"Left":  0,
+	"Right": 1,
+	"Unit":  0,
+	"Pair":  0,
+}
+
+// compareValues compares normalized values of a comparable type like COMPARE does
+func compareValues(a, b *Node) (int, error) {
+	if a.Kind != b.Kind {
+		return 0, fmt.Errorf("michelson: `%v' and `%v' are not comparable", a, b)
+	}
+	switch a.Kind {
+	case KindInt:
+		return a.Int.Cmp(b.Int), nil
+	case KindString:
+		return strings.Compare(a.Str, b.Str), nil
+	case KindBytes:
+		return bytes.Compare(a.Bytes, b.Bytes), nil
+	case KindPrim:
+		ra, ok := primRanks[a.Prim]
+		rb, ok2 := primRanks[b.Prim]
+		if !ok || !ok2 {
+			break
+		}
+		if ra != rb {
+			return ra - rb, nil
+		}
+		if len(a.Args) != len(b.Args) {
+			break
+		}
+		for i := range a.Args {
+			if c, err := compareValues(a.Args[i], b.Args[i]); c != 0 || err != nil {
+				return c, err
+			}
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("michelson: `%v' and `%v' are not comparable", a, b)
+}
+
+// checkOrder returns an error unless set elements or map keys go in strictly increasing order as the typechecker requires
+func checkOrder(prim string, prev, next *Node) error {
+	c, err := compareValues(prev, next)
+	if err != nil {
+		return err
+	}
+	if c >= 0 {
+		return fmt.Errorf("michelson: %s elements must be sorted in strictly increasing order, `%v' goes after `%v'", prim, next, prev)
+	}
+	return nil
+}
+
+func decodeAddress(b []byte) (string, error) {
+	if len(b) < 22 {
+		return "", fmt.Errorf("michelson: invalid address length %d", len(b))
+	}
+
+	var (
+		s   string
+		err error
+	)
+	switch {
+	case b[0] == 0 && int(b[1]) < len(implicitPrefixes):
+		s, err = implicitPrefixes[b[1]].Encode(b[2:22])
+	case int(b[0]) < len(originatedPrefixes) && originatedPrefixes[b[0]] != nil:
+		s, err = originatedPrefixes[b[0]].Encode(b[1:21])
+	default:
+		return "", fmt.Errorf("michelson: unknown address tag %d", b[0])
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if len(b) > 22 {
+		s += "%" + string(b[22:])
+	}
+	return s, nil
+}
+
+func decodeTagged(b []byte, list []*base58.Prefix) (string, error) {
+	if len(b) == 0 || int(b[0]) >= len(list) {
+		return "", fmt.Errorf("michelson: invalid tagged value")
+	}
+	return list[b[0]].Encode(b[1:])
+}
+
+// Readable converts the value of the given type from the optimized form to the human readable one
+func Readable(value, typ *Node) (*Node, error) {
+	if typ.Kind != KindPrim {
+		return nil, fmt.Errorf("michelson: invalid type `%v'", typ)
+	}
+
+	switch typ.Prim {
+	case "timestamp":
+		if value.Kind == KindInt && value.Int.IsInt64() {
+			return NewString(time.Unix(value.Int.Int64(), 0).UTC().Format(time.RFC3339)), nil
+		}
+
+	case "key_hash", "address", "contract", "key", "signature", "chain_id":
+		if value.Kind != KindBytes {
+			break
+		}
+		var (
+			s   string
+			err error
+		)
+		switch typ.Prim {
+		case "key_hash":
+			s, err = decodeTagged(value.Bytes, implicitPrefixes)
+		case "address", "contract":
+			s, err = decodeAddress(value.Bytes)
+		case "key":
+			s, err = decodeTagged(value.Bytes, publicKeyPrefixes)
+		case "signature":
+			if len(value.Bytes) == base58.PrefixBLS12381Signature.PayloadLen {
+				s, err = base58.PrefixBLS12381Signature.Encode(value.Bytes)
+			} else {
+				s, err = base58.PrefixGenericSignature.Encode(value.Bytes)
+			}
+		case "chain_id":
+			s, err = base58.PrefixChainID.Encode(value.Bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return NewString(s), nil
+
+	case "option":
+		if value.IsPrim("Some") && len(value.Args) == 1 && len(typ.Args) == 1 {
+			v, err := Readable(value.Args[0], typ.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			return NewPrim("Some", v), nil
+		}
+
+	case "or":
+		if len(value.Args) != 1 || len(typ.Args) != 2 {
+			break
+		}
+		var t *Node
+		switch {
+		case value.IsPrim("Left"):
+			t = typ.Args[0]
+		case value.IsPrim("Right"):
+			t = typ.Args[1]
+		default:
+			return nil, &TypeError{Type: typ, Value: value}
+		}
+		v, err := Readable(value.Args[0], t)
+		if err != nil {
+			return nil, err
+		}
+		return NewPrim(value.Prim, v), nil
+
+	case "pair":
+		if !value.IsPrim("Pair") && value.Kind != KindSeq {
+			return nil, &TypeError{Type: typ, Value: value}
+		}
+		t, err := unfoldComb(typ, "pair")
+		if err != nil {
+			return nil, err
+		}
+		v, err := unfoldComb(value, "Pair")
+		if err != nil {
+			return nil, err
+		}
+		left, err := Readable(v.Args[0], t.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := Readable(v.Args[1], t.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		return NewPrim("Pair", left, right), nil
+
+	case "list", "set":
+		if value.Kind != KindSeq || len(typ.Args) != 1 {
+			break
+		}
+		res := make([]*Node, len(value.Args))
+		for i, e := range value.Args {
+			v, err := Readable(e, typ.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			res[i] = v
+		}
+		return NewSeq(res...), nil
+
+	case "map", "big_map":
+		if value.Kind != KindSeq || len(typ.Args) != 2 {
+			break
+		}
+		res := make([]*Node, len(value.Args))
+		for i, e := range value.Args {
+			if !e.IsPrim("Elt") || len(e.Args) != 2 {
+				return nil, &TypeError{Type: typ, Value: value}
+			}
+			k, err := Readable(e.Args[0], typ.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			v, err := Readable(e.Args[1], typ.Args[1])
+			if err != nil {
+				return nil, err
+			}
+			res[i] = NewPrim("Elt", k, v)
+		}
+		return NewSeq(res...), nil
+	}
+
+	return value, nil
+}
+
+// PackTyped normalizes the value according to its type and serializes it
+func PackTyped(value, typ *Node) ([]byte, error) {
+	v, err := Normalize(value, typ)
+	if err != nil {
+		return nil, err
+	}
+	return Pack(v)
+}
+
+// UnpackTyped deserializes PACK output and converts the result to the human readable form
+func UnpackTyped(data []byte, typ *Node) (*Node, error) {
+	v, err := Unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	return Readable(v, typ)
+}
+
+// Field returns the part of the value typed by the field with the annotation, e.g. %metadata, or nil if the type has no such field.
+// Pair types and values may be given in comb or sequence notation
+func Field(value, typ *Node, annot string) *Node {
+	for _, a := range typ.Annots {
+		if a == annot {
+			return value
+		}
+	}
+	if !typ.IsPrim("pair") || len(typ.Args) < 2 {
+		return nil
+	}
+	if value.Kind == KindSeq {
+		value = &Node{Kind: KindPrim, Prim: "Pair", Args: value.Args}
+	}
+	if !value.IsPrim("Pair") || len(value.Args) < 2 {
+		return nil
+	}
+	t, err := unfoldComb(typ, "pair")
+	if err != nil {
+		return nil
+	}
+	v, err := unfoldComb(value, "Pair")
+	if err != nil {
+		return nil
+	}
+	if x := Field(v.Args[0], t.Args[0], annot); x != nil {
+		return x
+	}
+	return Field(v.Args[1], t.Args[1], annot)
+}
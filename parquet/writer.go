@@ -0,0 +1,391 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package parquet implements a minimal writer of Apache Parquet files with flat schemas
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Type is a physical column type
+type Type int32
+
+// Supported physical types
+const (
+	Boolean   Type = 0
+	Int32     Type = 1
+	Int64     Type = 2
+	Double    Type = 5
+	ByteArray Type = 6
+)
+
+// ConvertedType is a logical type annotation
+type ConvertedType int32
+
+// Supported logical types
+const (
+	None            ConvertedType = -1
+	UTF8            ConvertedType = 0
+	TimestampMillis ConvertedType = 9
+)
+
+// Column describes a column of the flat schema
+type Column struct {
+	Name      string
+	Type      Type
+	Converted ConvertedType
+	Optional  bool
+}
+
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+	codecGzip     = 2
+	pageData      = 0
+	repRequired   = 0
+	repOptional   = 1
+)
+
+// DefaultRowGroupSize is the default number of rows buffered before a row group is written
+const DefaultRowGroupSize = 10000
+
+const createdBy = "tez"
+
+type columnChunk struct {
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type rowGroup struct {
+	columns   []columnChunk
+	numRows   int64
+	totalSize int64
+}
+
+// Writer writes rows to a Parquet file. Every row group is stored as a single GZIP compressed data page per column.
+// Values are given as nil (null), bool, int32, int64, float64, string, []byte or time.Time
+type Writer struct {
+	// RowGroupSize is the number of rows per row group
+	RowGroupSize int
+
+	w         io.Writer
+	schema    []Column
+	offset    int64
+	rows      [][]interface{}
+	rowGroups []*rowGroup
+	numRows   int64
+	started   bool
+}
+
+// NewWriter returns new Writer
+func NewWriter(w io.Writer, schema []Column) *Writer {
+	return &Writer{
+		RowGroupSize: DefaultRowGroupSize,
+		w:            w,
+		schema:       schema,
+	}
+}
+
+func (w *Writer) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	return err
+}
+
+// Write buffers the row
+func (w *Writer) Write(row []interface{}) error {
+	if len(row) != len(w.schema) {
+		return fmt.Errorf("parquet: %d values expected, got %d", len(w.schema), len(row))
+	}
+	for i, v := range row {
+		if err := checkValue(&w.schema[i], v); err != nil {
+			return err
+		}
+	}
+	w.rows = append(w.rows, row)
+	if len(w.rows) >= w.RowGroupSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+func checkValue(c *Column, v interface{}) error {
+	if v == nil {
+		if !c.Optional {
+			return fmt.Errorf("parquet: column `%s' is required", c.Name)
+		}
+		return nil
+	}
+
+	var ok bool
+	switch c.Type {
+	case Boolean:
+		_, ok = v.(bool)
+	case Int32:
+		_, ok = v.(int32)
+	case Int64:
+		switch v.(type) {
+		case int64, time.Time:
+			ok = true
+		}
+	case Double:
+		_, ok = v.(float64)
+	case ByteArray:
+		switch v.(type) {
+		case string, []byte:
+			ok = true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("parquet: invalid value type %T for column `%s'", v, c.Name)
+	}
+	return nil
+}
+
+// Flush writes buffered rows as a row group
+func (w *Writer) Flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+
+	if !w.started {
+		if err := w.write([]byte("PAR1")); err != nil {
+			return err
+		}
+		w.started = true
+	}
+
+	rg := rowGroup{numRows: int64(len(w.rows))}
+	for i := range w.schema {
+		chunk, err := w.writeColumn(i)
+		if err != nil {
+			return err
+		}
+		rg.columns = append(rg.columns, *chunk)
+		rg.totalSize += chunk.uncompressedSize
+	}
+
+	w.rowGroups = append(w.rowGroups, &rg)
+	w.numRows += rg.numRows
+	w.rows = w.rows[:0]
+	return nil
+}
+
+func (w *Writer) writeColumn(col int) (*columnChunk, error) {
+	c := &w.schema[col]
+
+	var page bytes.Buffer
+	if c.Optional {
+		var levels []byte
+		for _, row := range w.rows {
+			if row[col] == nil {
+				levels = append(levels, 0)
+			} else {
+				levels = append(levels, 1)
+			}
+		}
+		rle := encodeLevels(levels)
+		var n [4]byte
+		binary.LittleEndian.PutUint32(n[:], uint32(len(rle)))
+		page.Write(n[:])
+		page.Write(rle)
+	}
+
+	var bits []bool
+	for _, row := range w.rows {
+		v := row[col]
+		if v == nil {
+			continue
+		}
+		switch x := v.(type) {
+		case bool:
+			bits = append(bits, x)
+		case int32:
+			binary.Write(&page, binary.LittleEndian, x)
+		case int64:
+			binary.Write(&page, binary.LittleEndian, x)
+		case time.Time:
+			binary.Write(&page, binary.LittleEndian, x.UnixNano()/int64(time.Millisecond))
+		case float64:
+			binary.Write(&page, binary.LittleEndian, math.Float64bits(x))
+		case string:
+			binary.Write(&page, binary.LittleEndian, uint32(len(x)))
+			page.WriteString(x)
+		case []byte:
+			binary.Write(&page, binary.LittleEndian, uint32(len(x)))
+			page.Write(x)
+		}
+	}
+	if bits != nil {
+		packed := make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		page.Write(packed)
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(page.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	var hdr thriftWriter
+	hdr.beginStruct()
+	hdr.i32(1, pageData)
+	hdr.i32(2, int32(page.Len()))
+	hdr.i32(3, int32(compressed.Len()))
+	hdr.structField(5)
+	hdr.i32(1, int32(len(w.rows)))
+	hdr.i32(2, encodingPlain)
+	hdr.i32(3, encodingRLE)
+	hdr.i32(4, encodingRLE)
+	hdr.endStruct()
+	hdr.endStruct()
+
+	chunk := columnChunk{
+		offset:           w.offset,
+		numValues:        int64(len(w.rows)),
+		uncompressedSize: int64(hdr.Len() + page.Len()),
+		compressedSize:   int64(hdr.Len() + compressed.Len()),
+	}
+
+	if err := w.write(hdr.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.write(compressed.Bytes()); err != nil {
+		return nil, err
+	}
+	return &chunk, nil
+}
+
+// encodeLevels encodes definition levels of bit width 1 using RLE runs of the hybrid encoding
+func encodeLevels(levels []byte) []byte {
+	var (
+		buf bytes.Buffer
+		tmp [binary.MaxVarintLen64]byte
+	)
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(j-i)<<1)])
+		buf.WriteByte(levels[i])
+		i = j
+	}
+	return buf.Bytes()
+}
+
+// Close flushes buffered rows and writes the file footer. The underlying writer is not closed
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if !w.started {
+		// Empty file
+		if err := w.write([]byte("PAR1")); err != nil {
+			return err
+		}
+		w.started = true
+	}
+
+	var meta thriftWriter
+	meta.beginStruct()
+	meta.i32(1, 1) // version
+
+	meta.list(2, thriftStruct, len(w.schema)+1)
+	meta.beginStruct()
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(w.schema)))
+	meta.endStruct()
+	for _, c := range w.schema {
+		meta.beginStruct()
+		meta.i32(1, int32(c.Type))
+		if c.Optional {
+			meta.i32(3, repOptional)
+		} else {
+			meta.i32(3, repRequired)
+		}
+		meta.string(4, c.Name)
+		if c.Converted != None {
+			meta.i32(6, int32(c.Converted))
+		}
+		meta.endStruct()
+	}
+
+	meta.i64(3, w.numRows)
+
+	meta.list(4, thriftStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		meta.beginStruct()
+		meta.list(1, thriftStruct, len(rg.columns))
+		for i, cc := range rg.columns {
+			c := &w.schema[i]
+			meta.beginStruct()
+			meta.i64(2, cc.offset)
+			meta.structField(3)
+			meta.i32(1, int32(c.Type))
+			meta.list(2, thriftI32, 2)
+			meta.varint(encodingPlain)
+			meta.varint(encodingRLE)
+			meta.list(3, thriftBinary, 1)
+			meta.uvarint(uint64(len(c.Name)))
+			meta.WriteString(c.Name)
+			meta.i32(4, codecGzip)
+			meta.i64(5, cc.numValues)
+			meta.i64(6, cc.uncompressedSize)
+			meta.i64(7, cc.compressedSize)
+			meta.i64(9, cc.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, rg.totalSize)
+		meta.i64(3, rg.numRows)
+		meta.endStruct()
+	}
+
+	meta.string(6, createdBy)
+	meta.endStruct()
+
+	if err := w.write(meta.Bytes()); err != nil {
+		return err
+	}
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(meta.Len()))
+	if err := w.write(n[:]); err != nil {
+		return err
+	}
+	return w.write([]byte("PAR1"))
+}
@@ -0,0 +1,320 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package protocol contains the registry of known Tezos protocols and operation kinds they support
+package protocol
+
+import (
+	"sort"
+)
+
+// Protocol describes a Tezos economic protocol
+type Protocol struct {
+	Hash  string
+	Name  string
+	kinds map[string]struct{}
+}
+
+// Supports returns true if the operation kind is valid in the protocol
+func (p *Protocol) Supports(kind string) bool {
+	_, ok := p.kinds[kind]
+	return ok
+}
+
+// Kinds returns sorted list of operation kinds supported by the protocol
+func (p *Protocol) Kinds() []string {
+	res := make([]string, 0, len(p.kinds))
+	for k := range p.kinds {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Operation kinds
+const (
+	KindEndorsement                  = "endorsement"
+	KindSeedNonceRevelation          = "seed_nonce_revelation"
+	KindDoubleEndorsementEvidence    = "double_endorsement_evidence"
+	KindDoubleBakingEvidence         = "double_baking_evidence"
+	KindActivateAccount              = "activate_account"
+	KindProposals                    = "proposals"
+	KindBallot                       = "ballot"
+	KindReveal                       = "reveal"
+	KindTransaction                  = "transaction"
+	KindOrigination                  = "origination"
+	KindDelegation                   = "delegation"
+	KindFailingNoop                  = "failing_noop"
+	KindEndorsementWithSlot          = "endorsement_with_slot"
+	KindRegisterGlobalConstant       = "register_global_constant"
+	KindPreendorsement               = "preendorsement"
+	KindDoublePreendorsementEvidence = "double_preendorsement_evidence"
+	KindSetDepositsLimit             = "set_deposits_limit"
+	KindTxRollupOrigination          = "tx_rollup_origination"
+	KindTxRollupSubmitBatch          = "tx_rollup_submit_batch"
+	KindTxRollupCommit               = "tx_rollup_commit"
+	KindTxRollupReturnBond           = "tx_rollup_return_bond"
+	KindTxRollupFinalizeCommitment   = "tx_rollup_finalize_commitment"
+	KindTxRollupRemoveCommitment     = "tx_rollup_remove_commitment"
+	KindTxRollupRejection            = "tx_rollup_rejection"
+	KindTxRollupDispatchTickets      = "tx_rollup_dispatch_tickets"
+	KindTransferTicket               = "transfer_ticket"
+	KindIncreasePaidStorage          = "increase_paid_storage"
+	KindVDFRevelation                = "vdf_revelation"
+	KindUpdateConsensusKey           = "update_consensus_key"
+	KindDrainDelegate                = "drain_delegate"
+	KindSmartRollupOriginate         = "smart_rollup_originate"
+	KindSmartRollupAddMessages       = "smart_rollup_add_messages"
+	KindSmartRollupCement            = "smart_rollup_cement"
+	KindSmartRollupPublish           = "smart_rollup_publish"
+	KindSmartRollupRefute            = "smart_rollup_refute"
+	KindSmartRollupTimeout           = "smart_rollup_timeout"
+	KindSmartRollupExecuteOutboxMsg  = "smart_rollup_execute_outbox_message"
+	KindSmartRollupRecoverBond       = "smart_rollup_recover_bond"
+	KindZkRollupOrigination          = "zk_rollup_origination"
+	KindZkRollupPublish              = "zk_rollup_publish"
+	KindZkRollupUpdate               = "zk_rollup_update"
+	KindDALPublishSlotHeader         = "dal_publish_slot_header"
+	KindAttestation                  = "attestation"
+	KindPreattestation               = "preattestation"
+	KindDoubleAttestationEvidence    = "double_attestation_evidence"
+	KindDoublePreattestationEvidence = "double_preattestation_evidence"
+	KindAttestationWithDAL           = "attestation_with_dal"
+	KindDALPublishCommitment         = "dal_publish_commitment"
+)
+
+// Each protocol is defined by the difference with its predecessor
+type protocolDef struct {
+	hash    string
+	name    string
+	added   []string
+	removed []string
+}
+
+// Main network protocols in activation order
+var definitions = []*protocolDef{
+	{
+		hash: "Ps9mPmXaRzmzk35gbAYNCAw6UXdE2qoABTHbN2oEEc1qM7CwT9P",
+		name: "000-Ps9mPmXa",
+		added: []string{
+			KindEndorsement,
+			KindSeedNonceRevelation,
+			KindDoubleEndorsementEvidence,
+			KindDoubleBakingEvidence,
+			KindActivateAccount,
+			KindProposals,
+			KindBallot,
+			KindReveal,
+			KindTransaction,
+			KindOrigination,
+			KindDelegation,
+		},
+	},
+	{hash: "PtCJ7pwoxe8JasnHY8YonnLYjcVHmhiARPJvqcC6VfHT5s8k8sY", name: "001-PtCJ7pwo"},
+	{hash: "PsYLVpVvgbLhAhoqAkMFUo6gudkJ9weNXhUYCiLDzcUpFpkk8Wt", name: "002-PsYLVpVv"},
+	{hash: "PsddFKi32cMJ2qPjf43Qv5GDWLDPZb3T3bF6fLKiF5HtvHNU7aP", name: "003-PsddFKi3"},
+	{hash: "PsBabyM1eUXZseaJdmXFApDSBqj8YBfwELoxZHHW77EMcAbbwAS", name: "005-PsBabyM1"},
+	{hash: "PsCARTHAGazKbHtnKfLzQg3kms52kSRpgnDY982a9oYsSXRLQEb", name: "006-PsCARTHA"},
+	{hash: "PsDELPH1Kxsxt8f9eWbxQeRxkjfbxoqM52jvs5Y5fBxWWh4ifpo", name: "007-PsDELPH1"},
+	{
+		hash:  "PtEdo2ZkT9oKpimTah6x2embF25oss54njMuPzkJTEi5RqfdZFA",
+		name:  "008-PtEdo2Zk",
+		added: []string{KindFailingNoop, KindEndorsementWithSlot},
+	},
+	{hash: "PsFLorenaUUuikDWvMDr6fGBRG8kt3e3D3fHoXK1j1BFRxeSH4i", name: "009-PsFLoren"},
+	{hash: "PtGRANADsDU8R9daYKAgWnQYAJ64omN1o3KMGVCykShA97vQbvV", name: "010-PtGRANAD"},
+	{
+		hash:  "PtHangz2aRngywmSRGGvrcTyMbbdpWdpFKuS4uMWxg2RaH9i1qx",
+		name:  "011-PtHangz2",
+		added: []string{KindRegisterGlobalConstant},
+	},
+	{
+		hash:    "Psithaca2MLRFYargivpo7YvUr7wUDqyxrdhC5CQq78mRvimz6A",
+		name:    "012-Psithaca",
+		added:   []string{KindPreendorsement, KindDoublePreendorsementEvidence, KindSetDepositsLimit},
+		removed: []string{KindEndorsementWithSlot},
+	},
+	{
+		// Includes changes introduced by 013-PtJakart
+		hash: "PtKathmankSpLLDALzWw7CGD2j2MtyveTwboEYokqUCP4a1LxMg",
+		name: "014-PtKathma",
+		added: []string{
+			KindTxRollupOrigination,
+			KindTxRollupSubmitBatch,
+			KindTxRollupCommit,
+			KindTxRollupReturnBond,
+			KindTxRollupFinalizeCommitment,
+			KindTxRollupRemoveCommitment,
+			KindTxRollupRejection,
+			KindTxRollupDispatchTickets,
+			KindTransferTicket,
+			KindIncreasePaidStorage,
+			KindVDFRevelation,
+		},
+	},
+	{
+		hash:  "PtLimaPtLMwfNinJi9rCfDPWea8dFgTZ1MeJ9f1m2SRic6ayiwW",
+		name:  "015-PtLimaPt",
+		added: []string{KindUpdateConsensusKey, KindDrainDelegate},
+	},
+	{
+		hash: "PtMumbai2TmsJHNGRkD8v8YDbtao7BLUC3wjASn1inAKLFCjaH1",
+		name: "016-PtMumbai",
+		added: []string{
+			KindSmartRollupOriginate,
+			KindSmartRollupAddMessages,
+			KindSmartRollupCement,
+			KindSmartRollupPublish,
+			KindSmartRollupRefute,
+			KindSmartRollupTimeout,
+			KindSmartRollupExecuteOutboxMsg,
+			KindSmartRollupRecoverBond,
+			KindZkRollupOrigination,
+			KindZkRollupPublish,
+			KindZkRollupUpdate,
+			KindDALPublishSlotHeader,
+		},
+	},
+	{hash: "PtNairobiyssHuh87hEhfVBGCVrK3WnS8Z2FT4ymB5tAa4r1nQf", name: "017-PtNairob"},
+	{
+		hash: "ProxfordYmVfjWnRcgjWH36fW6PArwqykTFzotUxRs6gmTcZDuH",
+		name: "018-Proxford",
+		added: []string{
+			KindAttestation,
+			KindPreattestation,
+			KindDoubleAttestationEvidence,
+			KindDoublePreattestationEvidence,
+		},
+		removed: []string{
+			KindEndorsement,
+			KindPreendorsement,
+			KindDoubleEndorsementEvidence,
+			KindDoublePreendorsementEvidence,
+			KindTxRollupOrigination,
+			KindTxRollupSubmitBatch,
+			KindTxRollupCommit,
+			KindTxRollupReturnBond,
+			KindTxRollupFinalizeCommitment,
+			KindTxRollupRemoveCommitment,
+			KindTxRollupRejection,
+			KindTxRollupDispatchTickets,
+		},
+	},
+	{
+		hash:    "PtParisBxoLz5gzMmn3d9WBQNoPSZakgnkMC2VNuQ3KXfUtUQeZ",
+		name:    "019-PtParisB",
+		added:   []string{KindAttestationWithDAL, KindDALPublishCommitment},
+		removed: []string{KindDALPublishSlotHeader},
+	},
+	{hash: "PsQuebecnLByd3JwTiGadoG4nGWi3HYiLXUjkibeFV8dCFeVMUg", name: "021-PsQuebec"},
+	{hash: "PsRiotumaAMotcRoDWW1bysEhQy2n1M5fy8JgRp8jjRfHGmfeA7", name: "022-PsRiotum"},
+}
+
+var (
+	protocols  []*Protocol
+	byHash     map[string]*Protocol
+	knownKinds map[string]struct{}
+)
+
+func init() {
+	byHash = make(map[string]*Protocol, len(definitions))
+	knownKinds = make(map[string]struct{})
+
+	kinds := make(map[string]struct{})
+	for _, d := range definitions {
+		for _, k := range d.added {
+			kinds[k] = struct{}{}
+			knownKinds[k] = struct{}{}
+		}
+		for _, k := range d.removed {
+			delete(kinds, k)
+		}
+
+		p := Protocol{
+			Hash:  d.hash,
+			Name:  d.name,
+			kinds: make(map[string]struct{}, len(kinds)),
+		}
+		for k := range kinds {
+			p.kinds[k] = struct{}{}
+		}
+
+		protocols = append(protocols, &p)
+		byHash[p.Hash] = &p
+	}
+}
+
+// Lookup returns the protocol by its hash or nil if the protocol is unknown
+func Lookup(hash string) *Protocol {
+	return byHash[hash]
+}
+
+// Protocols returns all known protocols in activation order
+func Protocols() []*Protocol {
+	return protocols
+}
+
+var titles = map[string]string{
+	KindEndorsement:                 "Endorsement",
+	KindSeedNonceRevelation:         "Nonce",
+	KindDoubleEndorsementEvidence:   "Double Endorsement Evidence",
+	KindDoubleBakingEvidence:        "Double Baking Evidence",
+	KindActivateAccount:             "Activation",
+	KindProposals:                   "Proposals",
+	KindBallot:                      "Ballot",
+	KindReveal:                      "Reveal",
+	KindTransaction:                 "Transaction",
+	KindOrigination:                 "Origination",
+	KindDelegation:                  "Delegation",
+	KindFailingNoop:                 "Failing Noop",
+	KindEndorsementWithSlot:         "Endorsement",
+	KindRegisterGlobalConstant:      "Global Constant",
+	KindPreendorsement:              "Preendorsement",
+	KindSetDepositsLimit:            "Deposits Limit",
+	KindTransferTicket:              "Ticket Transfer",
+	KindIncreasePaidStorage:         "Paid Storage",
+	KindVDFRevelation:               "VDF Revelation",
+	KindUpdateConsensusKey:          "Consensus Key",
+	KindDrainDelegate:               "Drain Delegate",
+	KindSmartRollupOriginate:        "SR Origination",
+	KindSmartRollupAddMessages:      "SR Messages",
+	KindSmartRollupCement:           "SR Cement",
+	KindSmartRollupPublish:          "SR Publish",
+	KindSmartRollupRefute:           "SR Refute",
+	KindSmartRollupTimeout:          "SR Timeout",
+	KindSmartRollupExecuteOutboxMsg: "SR Outbox Message",
+	KindSmartRollupRecoverBond:      "SR Recover Bond",
+	KindAttestation:                 "Attestation",
+	KindPreattestation:              "Preattestation",
+	KindDoubleAttestationEvidence:   "Double Attestation Evidence",
+	KindAttestationWithDAL:          "Attestation",
+	KindDALPublishCommitment:        "DAL Commitment",
+}
+
+// Title returns human readable operation kind title or an empty string
+func Title(kind string) string {
+	return titles[kind]
+}
+
+// IsKnownKind returns true if the operation kind is supported by at least one known protocol
+func IsKnownKind(kind string) bool {
+	_, ok := knownKinds[kind]
+	return ok
+}
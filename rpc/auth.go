@@ -0,0 +1,61 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rpc
+
+import (
+	"net/http"
+)
+
+// Auth is a http.RoundTripper adding credentials to every request. Place it after Logger in the chain
+// so credentials don't end up in logs and dumps
+type Auth struct {
+	Transport http.RoundTripper
+	// HTTP basic authentication is used if User is not empty
+	User     string
+	Password string
+	// BearerToken is sent in the Authorization header if not empty
+	BearerToken string
+}
+
+func (a *Auth) transport() http.RoundTripper {
+	if a.Transport != nil {
+		return a.Transport
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper
+func (a *Auth) RoundTrip(req *http.Request) (*http.Response, error) {
+	if a.User == "" && a.BearerToken == "" {
+		return a.transport().RoundTrip(req)
+	}
+
+	// RoundTripper must not modify the request
+	r := new(http.Request)
+	*r = *req
+	r.Header = cloneHeader(req.Header)
+	if a.BearerToken != "" {
+		r.Header.Set("Authorization", "Bearer "+a.BearerToken)
+	} else {
+		r.SetBasicAuth(a.User, a.Password)
+	}
+	return a.transport().RoundTrip(r)
+}
@@ -0,0 +1,329 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package rpc contains http.RoundTripper middleware used by the Tezos RPC client
+package rpc
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// DefaultCacheEntries is a default in-memory cache size
+const DefaultCacheEntries = 1000
+
+// /chains/<chain_id>/blocks/<block_id>[/...]
+var blockPathRegexp = regexp.MustCompile(`^/chains/[^/]+/blocks/([^/]+)(/.*)?$`)
+
+type cacheEntry struct {
+	key       string
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body"`
+	immutable bool
+}
+
+// Cache is a caching http.RoundTripper aware of block data immutability. Block data addressed by a hash
+// never changes, block data addressed by a level is considered immutable if the level is
+// at least FinalityDepth levels behind the last seen head. Other successful GET responses are
+// kept only if they carry a validator (ETag or Last-Modified) and are revalidated using conditional requests.
+type Cache struct {
+	Transport     http.RoundTripper
+	FinalityDepth int
+	MaxEntries    int
+	// Dir is an optional directory for persistent storage of immutable responses
+	Dir string
+
+	mtx     sync.Mutex
+	head    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// SetHead updates the known head level
+func (c *Cache) SetHead(level int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if level > c.head {
+		c.head = level
+	}
+}
+
+// Head returns the last known head level or zero
+func (c *Cache) Head() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.head
+}
+
+func (c *Cache) transport() http.RoundTripper {
+	if c.Transport != nil {
+		return c.Transport
+	}
+	return http.DefaultTransport
+}
+
+// Returns true if the response is immutable and true if the request addresses the head block
+func (c *Cache) classify(req *http.Request) (immutable, head bool) {
+	m := blockPathRegexp.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		return false, false
+	}
+
+	id := m[1]
+	if id == "head" {
+		return false, m[2] == "" || m[2] == "/header"
+	}
+
+	// Block hash
+	if len(id) == 51 && id[0] == 'B' {
+		return true, false
+	}
+
+	level, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return false, false
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.head != 0 && int(level) <= c.head-c.FinalityDepth, false
+}
+
+func (c *Cache) get(key string) *cacheEntry {
+	c.mtx.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		c.mtx.Unlock()
+		return el.Value.(*cacheEntry)
+	}
+	c.mtx.Unlock()
+
+	if c.Dir == "" {
+		return nil
+	}
+
+	// Try persistent storage
+	buf, err := ioutil.ReadFile(c.fileName(key))
+	if err != nil {
+		return nil
+	}
+
+	var e cacheEntry
+	if err := json.Unmarshal(buf, &e); err != nil {
+		return nil
+	}
+	e.key = key
+	e.immutable = true
+	c.put(&e, false)
+
+	return &e
+}
+
+func (c *Cache) put(e *cacheEntry, persist bool) {
+	c.mtx.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[e.key] = c.lru.PushFront(e)
+	}
+
+	max := c.MaxEntries
+	if max <= 0 {
+		max = DefaultCacheEntries
+	}
+
+	for c.lru.Len() > max {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).key)
+	}
+	c.mtx.Unlock()
+
+	if persist && c.Dir != "" {
+		if buf, err := json.Marshal(e); err == nil {
+			if err := os.MkdirAll(c.Dir, 0700); err == nil {
+				// Write atomically
+				tmp := c.fileName(e.key) + ".tmp"
+				if err := ioutil.WriteFile(tmp, buf, 0600); err == nil {
+					os.Rename(tmp, c.fileName(e.key))
+				}
+			}
+		}
+	}
+}
+
+// Walk calls fn with the body of every successful response in the persistent storage. Iteration stops when fn returns false
+func (c *Cache) Walk(fn func(body []byte) bool) error {
+	if c.Dir == "" {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, fi := range files {
+		// Skip unfinished writes
+		if fi.IsDir() || filepath.Ext(fi.Name()) != "" {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(c.Dir, fi.Name()))
+		if err != nil {
+			continue
+		}
+		var e cacheEntry
+		if err := json.Unmarshal(buf, &e); err != nil || e.Status != http.StatusOK {
+			continue
+		}
+		if !fn(e.Body) {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *Cache) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cloneHeader(e.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func cloneHeader(h http.Header) http.Header {
+	res := make(http.Header, len(h))
+	for k, v := range h {
+		res[k] = append([]string(nil), v...)
+	}
+	return res
+}
+
+// RoundTrip implements http.RoundTripper
+func (c *Cache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return c.transport().RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	immutable, head := c.classify(req)
+
+	cached := c.get(key)
+	if cached != nil && cached.immutable {
+		return cached.response(req), nil
+	}
+
+	if cached != nil {
+		// Revalidate
+		r := new(http.Request)
+		*r = *req
+		r.Header = cloneHeader(req.Header)
+		if v := cached.Header.Get("ETag"); v != "" {
+			r.Header.Set("If-None-Match", v)
+		}
+		if v := cached.Header.Get("Last-Modified"); v != "" {
+			r.Header.Set("If-Modified-Since", v)
+		}
+		req = r
+	}
+
+	resp, err := c.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		return cached.response(req), nil
+	}
+
+	validated := resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+	if resp.StatusCode != http.StatusOK || !(immutable || validated || head) {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if head {
+		// Track the head level
+		var v struct {
+			Level  int `json:"level"`
+			Header struct {
+				Level int `json:"level"`
+			} `json:"header"`
+		}
+		if json.Unmarshal(body, &v) == nil {
+			if v.Header.Level != 0 {
+				c.SetHead(v.Header.Level)
+			} else {
+				c.SetHead(v.Level)
+			}
+		}
+	}
+
+	e := cacheEntry{
+		key:       key,
+		Status:    resp.StatusCode,
+		Header:    resp.Header,
+		Body:      body,
+		immutable: immutable,
+	}
+
+	if immutable || validated {
+		c.put(&e, immutable)
+	}
+
+	return e.response(req), nil
+}
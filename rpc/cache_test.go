@@ -0,0 +1,123 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testBlockHash = "BLockGenesisGenesisGenesisGenesisGenesisf79b5d1CoW2"
+
+func TestCache(t *testing.T) {
+	var (
+		hits        int64
+		headerLevel = `{"level": 100}`
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		switch {
+		case r.URL.Path == "/chains/main/blocks/head/header":
+			w.Write([]byte(headerLevel))
+		case strings.HasSuffix(r.URL.Path, "/etag"):
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(`"validated"`))
+		default:
+			w.Write([]byte(`"` + r.URL.Path + `"`))
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &Cache{FinalityDepth: 10, Dir: dir}
+	client := http.Client{Transport: c}
+	get := func(path string) string {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	expectHits := func(path string, want int64) {
+		t.Helper()
+		atomic.StoreInt64(&hits, 0)
+		body := get(path)
+		if n := atomic.LoadInt64(&hits); n != want {
+			t.Errorf("%s: %d requests to the node, want %d", path, n, want)
+		}
+		if body == "" {
+			t.Errorf("%s: empty body", path)
+		}
+	}
+
+	// Blocks addressed by a hash never change
+	expectHits("/chains/main/blocks/"+testBlockHash+"/header", 1)
+	expectHits("/chains/main/blocks/"+testBlockHash+"/header", 0)
+
+	// Levels are final only once the head is known to be far enough
+	expectHits("/chains/main/blocks/80/header", 1)
+	expectHits("/chains/main/blocks/head/header", 1)
+	if c.Head() != 100 {
+		t.Fatalf("head = %d, want 100", c.Head())
+	}
+	expectHits("/chains/main/blocks/80/header", 1)
+	expectHits("/chains/main/blocks/80/header", 0)
+	expectHits("/chains/main/blocks/95/header", 1)
+	expectHits("/chains/main/blocks/95/header", 1)
+
+	// Validated responses are revalidated with conditional requests
+	expectHits("/chains/main/blocks/head/etag", 1)
+	if body := get("/chains/main/blocks/head/etag"); body != `"validated"` {
+		t.Errorf("revalidated body = %s", body)
+	}
+
+	// Immutable responses survive in the persistent storage
+	c2 := &Cache{FinalityDepth: 10, Dir: dir}
+	client = http.Client{Transport: c2}
+	expectHits("/chains/main/blocks/"+testBlockHash+"/header", 0)
+
+	var bodies int
+	if err := c2.Walk(func(body []byte) bool { bodies++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	if bodies != 2 {
+		t.Errorf("%d persisted responses, want 2", bodies)
+	}
+}
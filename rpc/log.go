@@ -0,0 +1,188 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type attemptKey struct{}
+
+// WithAttempt returns a context carrying a retry attempt number for logging purposes
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// Attempt returns a retry attempt number stored in the context. Default is 1
+func Attempt(ctx context.Context) int {
+	if v, ok := ctx.Value(attemptKey{}).(int); ok {
+		return v
+	}
+	return 1
+}
+
+func newTraceID() string {
+	var buf [8]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// Logger is a http.RoundTripper which logs every request with request scoped fields
+type Logger struct {
+	Transport http.RoundTripper
+	Logger    log.FieldLogger
+	// Trace enables logging of every request at Info level including full URL, response size and latency
+	Trace bool
+	// Dump receives full requests and responses if not nil
+	Dump io.Writer
+
+	mtx sync.Mutex
+}
+
+func (l *Logger) transport() http.RoundTripper {
+	if l.Transport != nil {
+		return l.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (l *Logger) logger() log.FieldLogger {
+	if l.Logger != nil {
+		return l.Logger
+	}
+	return log.StandardLogger()
+}
+
+func (l *Logger) log(entry *log.Entry, msg string) {
+	if l.Trace {
+		entry.Info(msg)
+	} else {
+		entry.Debug(msg)
+	}
+}
+
+func (l *Logger) dump(id string, data []byte) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	fmt.Fprintf(l.Dump, "\n--- %s ---\n", id)
+	l.Dump.Write(data)
+}
+
+// RoundTrip implements http.RoundTripper
+func (l *Logger) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := newTraceID()
+	fields := log.Fields{
+		"trace_id": id,
+		"method":   req.Method,
+		"path":     req.URL.Path,
+		"attempt":  Attempt(req.Context()),
+	}
+	if l.Trace {
+		fields["url"] = req.URL.String()
+	}
+	entry := l.logger().WithFields(fields)
+
+	entry.Trace("RPC request")
+
+	if l.Dump != nil {
+		if buf, err := httputil.DumpRequestOut(req, true); err == nil {
+			l.dump(id+" request", buf)
+		}
+	}
+
+	start := time.Now()
+	resp, err := l.transport().RoundTrip(req)
+	entry = entry.WithField("duration", time.Since(start).String())
+
+	if err != nil {
+		l.log(entry.WithError(err), "RPC request failed")
+		return nil, err
+	}
+
+	entry = entry.WithField("status", resp.StatusCode)
+	l.log(entry, "RPC response")
+
+	if !l.Trace && l.Dump == nil {
+		return resp, nil
+	}
+
+	if l.Dump != nil {
+		if buf, err := httputil.DumpResponse(resp, false); err == nil {
+			l.dump(id+" response", buf)
+		}
+	}
+
+	body := &tracedBody{
+		ReadCloser: resp.Body,
+		done: func(size int64) {
+			l.log(entry.WithFields(log.Fields{
+				"size":     size,
+				"duration": time.Since(start).String(),
+			}), "RPC response body received")
+		},
+	}
+	if l.Dump != nil {
+		body.tee = func(p []byte) { l.dump(id+" response body", p) }
+	}
+	resp.Body = body
+
+	return resp, nil
+}
+
+// tracedBody counts response size and optionally copies the contents
+type tracedBody struct {
+	io.ReadCloser
+	size int64
+	tee  func(p []byte)
+	done func(size int64)
+	once sync.Once
+}
+
+func (b *tracedBody) finish() {
+	b.once.Do(func() { b.done(b.size) })
+}
+
+func (b *tracedBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if n != 0 && b.tee != nil {
+		b.tee(p[:n])
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return
+}
+
+func (b *tracedBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
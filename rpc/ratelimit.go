@@ -0,0 +1,216 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMaxRetries is a default number of retries of a rate limited request
+const DefaultMaxRetries = 5
+
+// Adaptive slowdown parameters
+const (
+	minRateFactor    = 1.0 / 64
+	rateRecoveryStep = 0.05
+	maxRetryDelay    = time.Minute
+)
+
+// RateLimiter is a http.RoundTripper limiting the request rate using a token bucket. Every 429 (Too Many Requests)
+// response halves the rate which then recovers gradually with successful requests. GET requests rejected
+// with 429 are retried after the delay requested by the server. Place it before Logger in the chain so every attempt is logged
+type RateLimiter struct {
+	Transport http.RoundTripper
+	// Rate is the number of requests per second, zero means no limit
+	Rate float64
+	// Burst is the maximum number of requests sent at once, at least one
+	Burst int
+	// MaxRetries is the maximum number of retries of a rejected request
+	MaxRetries int
+	Logger     log.FieldLogger
+
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+	// Fraction of the configured rate currently in use
+	factor float64
+	// No requests are sent until then
+	pause time.Time
+}
+
+func (r *RateLimiter) transport() http.RoundTripper {
+	if r.Transport != nil {
+		return r.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (r *RateLimiter) logger() log.FieldLogger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return log.StandardLogger()
+}
+
+// reserve takes a token and returns zero or returns the time to wait before trying again
+func (r *RateLimiter) reserve(now time.Time) time.Duration {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.factor == 0 {
+		r.factor = 1
+	}
+	if now.Before(r.pause) {
+		return r.pause.Sub(now)
+	}
+	if r.Rate <= 0 {
+		return 0
+	}
+
+	burst := float64(r.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	rate := r.Rate * r.factor
+	if r.last.IsZero() {
+		r.tokens = burst
+	} else {
+		r.tokens += now.Sub(r.last).Seconds() * rate
+		if r.tokens > burst {
+			r.tokens = burst
+		}
+	}
+	r.last = now
+
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0
+	}
+	return time.Duration((1 - r.tokens) / rate * float64(time.Second))
+}
+
+func (r *RateLimiter) wait(ctx context.Context) error {
+	for {
+		d := r.reserve(time.Now())
+		if d == 0 {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// slowDown halves the rate and pauses all requests for the given time
+func (r *RateLimiter) slowDown(delay time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.factor == 0 {
+		r.factor = 1
+	}
+	if r.factor /= 2; r.factor < minRateFactor {
+		r.factor = minRateFactor
+	}
+	if p := time.Now().Add(delay); p.After(r.pause) {
+		r.pause = p
+	}
+}
+
+func (r *RateLimiter) recover() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.factor != 0 && r.factor < 1 {
+		if r.factor += rateRecoveryStep; r.factor > 1 {
+			r.factor = 1
+		}
+	}
+}
+
+// retryDelay returns the delay requested by the server in the Retry-After header or an exponential backoff
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+			return 0
+		}
+	}
+	d := time.Second << uint(attempt-1)
+	if d > maxRetryDelay || d <= 0 {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *RateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	maxRetries := r.MaxRetries
+	if req.Method != http.MethodGet {
+		// Requests with a body can't be resent
+		maxRetries = 0
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := r.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		rq := req
+		if attempt > 1 {
+			rq = req.WithContext(WithAttempt(req.Context(), attempt))
+		}
+		resp, err := r.transport().RoundTrip(rq)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			r.recover()
+			return resp, nil
+		}
+
+		delay := retryDelay(resp, attempt)
+		r.slowDown(delay)
+		if attempt > maxRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		r.logger().WithFields(log.Fields{
+			"path":    req.URL.Path,
+			"attempt": attempt,
+			"delay":   delay.String(),
+		}).Warn("RPC rate limit exceeded, slowing down")
+	}
+}
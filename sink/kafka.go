@@ -0,0 +1,504 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	kafkaDefaultPort = "9092"
+	kafkaClientID    = "tez"
+	kafkaTimeout     = 10 * time.Second
+
+	kafkaAPIProduce  = 0
+	kafkaAPIMetadata = 3
+
+	kafkaErrNotLeader = 6
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Kafka produces messages to a topic. Keyed messages are assigned to partitions the same way
+// the default Java client partitioner does, unkeyed ones are distributed round robin
+type Kafka struct {
+	topic     string
+	bootstrap string
+
+	mtx     sync.Mutex
+	brokers map[int32]string // Node ID -> address
+	leaders []int32          // Partition -> leader node ID
+	conns   map[int32]*kafkaConn
+	next    int
+}
+
+// NewKafka connects to the bootstrap broker and fetches the topic metadata
+func NewKafka(ctx context.Context, addr, topic string) (*Kafka, error) {
+	k := Kafka{
+		topic:     topic,
+		bootstrap: withDefaultPort(addr, kafkaDefaultPort),
+		conns:     make(map[int32]*kafkaConn),
+	}
+	if err := k.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// refresh updates the list of partition leaders
+func (k *Kafka) refresh(ctx context.Context) error {
+	conn, err := dialKafka(ctx, k.bootstrap)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var req kafkaWriter
+	req.int32(1)
+	req.string(k.topic)
+
+	buf, err := conn.roundTrip(ctx, kafkaAPIMetadata, 1, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	r := kafkaReader{buf: buf}
+	brokers := make(map[int32]string)
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.int32() // controller
+
+	var leaders []int32
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		code := r.int16()
+		name := r.string()
+		r.int8() // is_internal
+		if code != 0 {
+			return fmt.Errorf("kafka: topic `%s': error code %d", name, code)
+		}
+		for j := r.int32(); j > 0 && r.err == nil; j-- {
+			r.int16() // partition error
+			index := r.int32()
+			leader := r.int32()
+			r.skipInt32Array() // replicas
+			r.skipInt32Array() // isr
+			for int(index) >= len(leaders) {
+				leaders = append(leaders, -1)
+			}
+			leaders[index] = leader
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(leaders) == 0 {
+		return fmt.Errorf("kafka: topic `%s' has no partitions", k.topic)
+	}
+
+	k.mtx.Lock()
+	k.brokers = brokers
+	k.leaders = leaders
+	k.mtx.Unlock()
+
+	return nil
+}
+
+// partition returns the partition for the key
+func (k *Kafka) partition(key []byte) int {
+	if len(key) == 0 {
+		p := k.next % len(k.leaders)
+		k.next++
+		return p
+	}
+	return int(uint32(murmur2(key))&0x7fffffff) % len(k.leaders)
+}
+
+// conn returns the connection to the partition leader
+func (k *Kafka) conn(ctx context.Context, partition int) (*kafkaConn, error) {
+	id := k.leaders[partition]
+	if c, ok := k.conns[id]; ok {
+		return c, nil
+	}
+	addr, ok := k.brokers[id]
+	if !ok {
+		return nil, fmt.Errorf("kafka: partition %d has no leader", partition)
+	}
+	c, err := dialKafka(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	k.conns[id] = c
+	return c, nil
+}
+
+// Publish produces messages waiting for acknowledgement from all in-sync replicas.
+// Leadership changes are handled by refreshing the metadata and retrying once
+func (k *Kafka) Publish(ctx context.Context, msgs ...*Message) error {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	// Keep the order within partitions
+	var order []int
+	batches := make(map[int][]*Message)
+	for _, m := range msgs {
+		p := k.partition(m.Key)
+		if _, ok := batches[p]; !ok {
+			order = append(order, p)
+		}
+		batches[p] = append(batches[p], m)
+	}
+
+	for _, p := range order {
+		err := k.produce(ctx, p, batches[p])
+		if err == errKafkaRetry {
+			k.mtx.Unlock()
+			err = k.refresh(ctx)
+			k.mtx.Lock()
+			if err == nil {
+				err = k.produce(ctx, p, batches[p])
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errKafkaRetry = errors.New("kafka: retry")
+
+func (k *Kafka) produce(ctx context.Context, partition int, msgs []*Message) error {
+	conn, err := k.conn(ctx, partition)
+	if err != nil {
+		return err
+	}
+
+	var req kafkaWriter
+	req.int16(-1) // transactional_id
+	req.int16(-1) // acks=all
+	req.int32(int32(kafkaTimeout / time.Millisecond))
+	req.int32(1)
+	req.string(k.topic)
+	req.int32(1)
+	req.int32(int32(partition))
+	batch := recordBatch(msgs, time.Now())
+	req.int32(int32(len(batch)))
+	req.Write(batch)
+
+	buf, err := conn.roundTrip(ctx, kafkaAPIProduce, 3, req.Bytes())
+	if err != nil {
+		// Drop the broken connection
+		conn.Close()
+		for id, c := range k.conns {
+			if c == conn {
+				delete(k.conns, id)
+			}
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		return errKafkaRetry
+	}
+
+	r := kafkaReader{buf: buf}
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		r.string()
+		for j := r.int32(); j > 0 && r.err == nil; j-- {
+			r.int32()
+			code := r.int16()
+			r.int64() // base_offset
+			r.int64() // log_append_time
+			if code == kafkaErrNotLeader {
+				return errKafkaRetry
+			}
+			if code != 0 && r.err == nil {
+				return fmt.Errorf("kafka: produce to `%s' partition %d: error code %d", k.topic, partition, code)
+			}
+		}
+	}
+	return r.err
+}
+
+// Close closes all broker connections
+func (k *Kafka) Close() error {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	for id, c := range k.conns {
+		c.Close()
+		delete(k.conns, id)
+	}
+	return nil
+}
+
+// recordBatch encodes messages using the v2 (magic 2) record batch format
+func recordBatch(msgs []*Message, now time.Time) []byte {
+	ts := now.UnixNano() / int64(time.Millisecond)
+
+	var records kafkaWriter
+	for i, m := range msgs {
+		var rec kafkaWriter
+		rec.int8(0)   // attributes
+		rec.varint(0) // timestamp delta
+		rec.varint(int64(i))
+		if m.Key == nil {
+			rec.varint(-1)
+		} else {
+			rec.varint(int64(len(m.Key)))
+			rec.Write(m.Key)
+		}
+		rec.varint(int64(len(m.Value)))
+		rec.Write(m.Value)
+		rec.varint(0) // headers
+
+		records.varint(int64(rec.Len()))
+		records.Write(rec.Bytes())
+	}
+
+	// Part covered by CRC
+	var body kafkaWriter
+	body.int16(0) // attributes
+	body.int32(int32(len(msgs) - 1))
+	body.int64(ts)
+	body.int64(ts)
+	body.int64(-1) // producer_id
+	body.int16(-1) // producer_epoch
+	body.int32(-1) // base_sequence
+	body.int32(int32(len(msgs)))
+	body.Write(records.Bytes())
+
+	var batch kafkaWriter
+	batch.int64(0) // base_offset
+	batch.int32(int32(4 + 1 + 4 + body.Len()))
+	batch.int32(-1) // partition_leader_epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(body.Bytes(), crc32c)))
+	batch.Write(body.Bytes())
+
+	return batch.Bytes()
+}
+
+// murmur2 is the hash used by the Java client's default partitioner
+func murmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+type kafkaConn struct {
+	net.Conn
+	r      *bufio.Reader
+	corrID int32
+}
+
+func dialKafka(ctx context.Context, addr string) (*kafkaConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// roundTrip sends the request and returns the response body following the correlation ID
+func (c *kafkaConn) roundTrip(ctx context.Context, apiKey, version int16, body []byte) ([]byte, error) {
+	deadline := time.Now().Add(kafkaTimeout + 5*time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.SetDeadline(deadline)
+
+	c.corrID++
+
+	var hdr kafkaWriter
+	hdr.int16(apiKey)
+	hdr.int16(version)
+	hdr.int32(c.corrID)
+	hdr.string(kafkaClientID)
+
+	var req kafkaWriter
+	req.int32(int32(hdr.Len() + len(body)))
+	req.Write(hdr.Bytes())
+	req.Write(body)
+
+	if _, err := c.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 {
+		return nil, errors.New("kafka: short response")
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(buf)); id != c.corrID {
+		return nil, fmt.Errorf("kafka: correlation ID mismatch: %d != %d", id, c.corrID)
+	}
+	return buf[4:], nil
+}
+
+type kafkaWriter struct {
+	bytes.Buffer
+}
+
+func (w *kafkaWriter) int8(v int8) { w.WriteByte(byte(v)) }
+
+func (w *kafkaWriter) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	w.Write(b[:])
+}
+
+func (w *kafkaWriter) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	w.Write(b[:])
+}
+
+func (w *kafkaWriter) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	w.Write(b[:])
+}
+
+func (w *kafkaWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.WriteString(s)
+}
+
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads both nullable and non-nullable strings
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) skipInt32Array() {
+	n := r.int32()
+	if n > 0 {
+		r.next(int(n) * 4)
+	}
+}
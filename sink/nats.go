@@ -0,0 +1,194 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+const natsDefaultPort = "4222"
+
+// Header carrying the message key
+const natsKeyHeader = "Tez-Key"
+
+// NATS publishes messages to a subject using the NATS text protocol
+type NATS struct {
+	subject string
+	conn    net.Conn
+	mtx     sync.Mutex // Guards writes
+	pongs   chan error
+	done    chan struct{}
+	err     error // Set by the reader before done is closed
+}
+
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Headers  bool   `json:"headers"`
+	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+}
+
+// NewNATS connects to the NATS server
+func NewNATS(ctx context.Context, addr, subject, user, pass string) (*NATS, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", withDefaultPort(addr, natsDefaultPort))
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("nats: unexpected greeting: %q", strings.TrimSpace(line))
+	}
+
+	connect, err := json.Marshal(&natsConnect{
+		Headers: true,
+		Name:    "tez",
+		User:    user,
+		Pass:    pass,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	n := NATS{
+		subject: subject,
+		conn:    conn,
+		pongs:   make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+
+	go n.read(r)
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Make sure the server has accepted credentials
+	if err := n.flush(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+// read handles server control messages
+func (n *NATS) read(r *bufio.Reader) {
+	defer close(n.done)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			n.err = err
+			return
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PING":
+			n.mtx.Lock()
+			_, err = n.conn.Write([]byte("PONG\r\n"))
+			n.mtx.Unlock()
+			if err != nil {
+				n.err = err
+				return
+			}
+
+		case line == "PONG":
+			select {
+			case n.pongs <- nil:
+			default:
+			}
+
+		case strings.HasPrefix(line, "-ERR"):
+			n.err = fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+			return
+		}
+	}
+}
+
+// flush waits for the server to process everything sent so far
+func (n *NATS) flush(ctx context.Context) error {
+	n.mtx.Lock()
+	_, err := n.conn.Write([]byte("PING\r\n"))
+	n.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-n.pongs:
+		return err
+	case <-n.done:
+		if n.err != nil {
+			return n.err
+		}
+		return errors.New("nats: connection closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Publish sends messages to the subject. The key is passed in the message header
+func (n *NATS) Publish(ctx context.Context, msgs ...*Message) error {
+	w := bufio.NewWriter(n.conn)
+
+	n.mtx.Lock()
+	for _, m := range msgs {
+		if len(m.Key) == 0 {
+			fmt.Fprintf(w, "PUB %s %d\r\n", n.subject, len(m.Value))
+		} else {
+			hdr := fmt.Sprintf("NATS/1.0\r\n%s: %s\r\n\r\n", natsKeyHeader, m.Key)
+			fmt.Fprintf(w, "HPUB %s %d %d\r\n%s", n.subject, len(hdr), len(hdr)+len(m.Value), hdr)
+		}
+		w.Write(m.Value)
+		w.WriteString("\r\n")
+	}
+	err := w.Flush()
+	n.mtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return n.flush(ctx)
+}
+
+// Close closes the connection
+func (n *NATS) Close() error {
+	return n.conn.Close()
+}
@@ -0,0 +1,85 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package sink publishes chain events to external message brokers and webhooks
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Message is a single event. Key is used for partitioning where supported by the broker
+type Message struct {
+	Key   []byte
+	Value []byte
+}
+
+// Sink delivers messages to a broker. Publish returns after the broker has acknowledged all messages
+type Sink interface {
+	Publish(ctx context.Context, msgs ...*Message) error
+	Close() error
+}
+
+// New connects to the sink given by URL like `kafka://broker:9092/topic', `nats://host:4222/subject' or
+// `https://host/path' for webhooks
+func New(ctx context.Context, rawurl string) (Sink, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		if u.Host == "" {
+			return nil, fmt.Errorf("Invalid webhook URL: `%s'", rawurl)
+		}
+		return NewWebhook(rawurl), nil
+	}
+
+	dest := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || dest == "" {
+		return nil, fmt.Errorf("Invalid sink URL: `%s' (scheme://host/destination expected)", rawurl)
+	}
+
+	switch u.Scheme {
+	case "kafka":
+		return NewKafka(ctx, u.Host, dest)
+
+	case "nats":
+		var user, pass string
+		if u.User != nil {
+			user = u.User.Username()
+			pass, _ = u.User.Password()
+		}
+		return NewNATS(ctx, u.Host, dest, user, pass)
+	}
+
+	return nil, fmt.Errorf("Unknown sink type: `%s'", u.Scheme)
+}
+
+// withDefaultPort adds the port to the address if it's missing
+func withDefaultPort(addr, port string) string {
+	if i := strings.LastIndexByte(addr, ':'); i < 0 || strings.HasSuffix(addr, "]") {
+		return addr + ":" + port
+	}
+	return addr
+}
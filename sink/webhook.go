@@ -0,0 +1,79 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Header carrying the message key
+const webhookKeyHeader = "Tez-Key"
+
+const webhookTimeout = 30 * time.Second
+
+// Webhook posts every message as a JSON request body to the URL
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a webhook sink posting to the URL
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// Publish posts messages one by one. Any status other than 2xx is an error
+func (w *Webhook) Publish(ctx context.Context, msgs ...*Message) error {
+	for _, m := range msgs {
+		req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(m.Value))
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		if len(m.Key) != 0 {
+			req.Header.Set(webhookKeyHeader, string(m.Key))
+		}
+
+		res, err := w.client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook: %s: %s", w.url, res.Status)
+		}
+	}
+	return nil
+}
+
+// Close does nothing, connections are reused by the HTTP client
+func (w *Webhook) Close() error { return nil }
@@ -0,0 +1,294 @@
+// Copyright © 2018 ECAD Labs <frontdesk@ecadlabs.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package timelock implements the protocol's timelock encryption used by the chest and chest_key Michelson types.
+// A chest can be opened by anyone after a given number of sequential squarings modulo the RSA-2048 challenge modulus,
+// the chest key carries the result with a Wesolowski proof so the protocol can verify the opening quickly
+package timelock
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// RSA-2048 challenge number with unknown factorization
+var rsa2048, _ = new(big.Int).SetString("25195908475657893494027183240048398571429282126204032027777137836043662020707595556264018525880784406918290641249515082189298559149176184502808489120072844992687392807287776735971418347270261896375014971824691165077613379859095700097330459748808428401797429100642458691817195118746121515172654632282216869987549182422433637259085141865462043576798423387184774447920739934236584823824281198163815010674810451660377306056201619676256133844143603833904414952634432190114657544454178424020924616515723350778707749817125772467962926386356373289912154831438167899885040445364023527381951378636564391212010397122822120720357", 10)
+
+const (
+	modulusSize = 2048
+	nonceSize   = 24
+	// Blake2b keys
+	primePersonalization = "\x20"
+	kdfKey               = "Tezoskdftimelockv1"
+)
+
+var (
+	// ErrBogusOpening is returned if the chest key doesn't match the chest or the time
+	ErrBogusOpening = errors.New("timelock: bogus opening")
+	// ErrBogusCiphertext is returned if the chest key is valid but the payload can't be decrypted
+	ErrBogusCiphertext = errors.New("timelock: bogus ciphertext")
+)
+
+// Chest is the encrypted payload with the locked value
+type Chest struct {
+	LockedValue *big.Int
+	Nonce       [nonceSize]byte
+	Payload     []byte
+}
+
+// Key is the result of the sequential computation with its proof, i.e. the chest key
+type Key struct {
+	LockedValue   *big.Int
+	UnlockedValue *big.Int
+	Proof         *big.Int
+	Nonce         *big.Int
+}
+
+// toBits returns the little endian representation of the absolute value padded to 64 bit limbs, like Zarith's to_bits
+func toBits(v *big.Int) []byte {
+	be := v.Bytes()
+	n := (len(be) + 7) / 8 * 8
+	le := make([]byte, n)
+	for i, b := range be {
+		le[len(be)-1-i] = b
+	}
+	return le
+}
+
+func fromBits(le []byte) *big.Int {
+	be := make([]byte, len(le))
+	for i, b := range le {
+		be[len(le)-1-i] = b
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// hashToPrime returns the Wesolowski challenge prime
+func hashToPrime(time int, locked, unlocked *big.Int) *big.Int {
+	h, _ := blake2b.New256([]byte(primePersonalization))
+	h.Write(toBits(rsa2048))
+	h.Write([]byte(strconv.Itoa(time)))
+	h.Write(toBits(locked))
+	h.Write(toBits(unlocked))
+	return nextPrime(fromBits(h.Sum(nil)))
+}
+
+// nextPrime returns the smallest probable prime greater than n
+func nextPrime(n *big.Int) *big.Int {
+	p := new(big.Int).Add(n, big.NewInt(1))
+	if p.Bit(0) == 0 && p.Cmp(big.NewInt(2)) > 0 {
+		p.Add(p, big.NewInt(1))
+	}
+	for !p.ProbablyPrime(25) {
+		p.Add(p, big.NewInt(2))
+	}
+	return p
+}
+
+func randomValue(r io.Reader) (*big.Int, error) {
+	buf := make([]byte, modulusSize/8)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		v := new(big.Int).SetBytes(buf)
+		v.Mod(v, rsa2048)
+		if v.Cmp(big.NewInt(1)) > 0 {
+			return v, nil
+		}
+	}
+}
+
+// unlock performs the sequential squarings
+func unlock(locked *big.Int, time int) *big.Int {
+	v := new(big.Int).Set(locked)
+	for i := 0; i < time; i++ {
+		v.Mul(v, v)
+		v.Mod(v, rsa2048)
+	}
+	return v
+}
+
+func prove(time int, locked, unlocked *big.Int) *big.Int {
+	l := hashToPrime(time, locked, unlocked)
+	e := new(big.Int).Lsh(big.NewInt(1), uint(time))
+	e.Quo(e, l)
+	return new(big.Int).Exp(locked, e, rsa2048)
+}
+
+func verifyProof(time int, locked, unlocked, proof *big.Int) bool {
+	if locked.Cmp(big.NewInt(1)) <= 0 || unlocked.Sign() <= 0 || proof.Sign() <= 0 ||
+		locked.Cmp(rsa2048) >= 0 || unlocked.Cmp(rsa2048) >= 0 || proof.Cmp(rsa2048) >= 0 {
+		return false
+	}
+	l := hashToPrime(time, locked, unlocked)
+	r := new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(time)), l)
+	v := new(big.Int).Exp(proof, l, rsa2048)
+	v.Mul(v, new(big.Int).Exp(locked, r, rsa2048))
+	v.Mod(v, rsa2048)
+	return v.Cmp(unlocked) == 0
+}
+
+// symmetricKey derives the secretbox key from the opening
+func (k *Key) symmetricKey() *[32]byte {
+	updated := new(big.Int).Exp(k.UnlockedValue, k.Nonce, rsa2048)
+	h, _ := blake2b.New256([]byte(kdfKey))
+	h.Write([]byte(updated.String()))
+	var key [32]byte
+	copy(key[:], h.Sum(nil))
+	return &key
+}
+
+// Create locks the payload for the given number of sequential squarings and returns the chest with its key.
+// Creating a chest takes about as long as opening it since the modulus factorization is unknown
+func Create(payload []byte, time int) (*Chest, *Key, error) {
+	if time <= 0 {
+		return nil, nil, fmt.Errorf("timelock: time must be positive")
+	}
+	locked, err := randomValue(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlocked := unlock(locked, time)
+	nonce, err := randomValue(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := Key{
+		LockedValue:   locked,
+		UnlockedValue: unlocked,
+		Proof:         prove(time, locked, unlocked),
+		Nonce:         nonce,
+	}
+
+	chest := Chest{LockedValue: new(big.Int).Exp(locked, nonce, rsa2048)}
+	if _, err := io.ReadFull(rand.Reader, chest.Nonce[:]); err != nil {
+		return nil, nil, err
+	}
+	chest.Payload = secretbox.Seal(nil, payload, &chest.Nonce, key.symmetricKey())
+	return &chest, &key, nil
+}
+
+// Open performs the sequential computation on the chest's locked value and returns the chest key.
+// The creator's nonce isn't needed, the resulting key opens the chest with the unit nonce
+func (c *Chest) Open(time int) *Key {
+	unlocked := unlock(c.LockedValue, time)
+	return &Key{
+		LockedValue:   c.LockedValue,
+		UnlockedValue: unlocked,
+		Proof:         prove(time, c.LockedValue, unlocked),
+		Nonce:         big.NewInt(1),
+	}
+}
+
+// Decrypt verifies the chest key and returns the payload
+func (c *Chest) Decrypt(k *Key, time int) ([]byte, error) {
+	if !verifyProof(time, k.LockedValue, k.UnlockedValue, k.Proof) ||
+		new(big.Int).Exp(k.LockedValue, k.Nonce, rsa2048).Cmp(c.LockedValue) != 0 {
+		return nil, ErrBogusOpening
+	}
+	out, ok := secretbox.Open(nil, c.Payload, &c.Nonce, k.symmetricKey())
+	if !ok {
+		return nil, ErrBogusCiphertext
+	}
+	return out, nil
+}
+
+func appendN(buf []byte, v *big.Int) []byte {
+	x := new(big.Int).Set(v)
+	for {
+		b := byte(new(big.Int).And(x, big.NewInt(0x7f)).Uint64())
+		x.Rsh(x, 7)
+		if x.Sign() == 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func readN(r *bytes.Reader) (*big.Int, error) {
+	v := new(big.Int)
+	for shift := uint(0); ; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("timelock: truncated number")
+		}
+		v.Or(v, new(big.Int).Lsh(big.NewInt(int64(b&0x7f)), shift))
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+}
+
+// MarshalBinary returns the chest bytes used as a Michelson chest value
+func (c *Chest) MarshalBinary() ([]byte, error) {
+	buf := appendN(nil, c.LockedValue)
+	buf = append(buf, c.Nonce[:]...)
+	return append(buf, c.Payload...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (c *Chest) UnmarshalBinary(data []byte) (err error) {
+	r := bytes.NewReader(data)
+	if c.LockedValue, err = readN(r); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, c.Nonce[:]); err != nil {
+		return fmt.Errorf("timelock: truncated chest")
+	}
+	c.Payload = data[len(data)-r.Len():]
+	if len(c.Payload) < secretbox.Overhead {
+		return fmt.Errorf("timelock: truncated chest")
+	}
+	return nil
+}
+
+// MarshalBinary returns the chest key bytes used as a Michelson chest_key value
+func (k *Key) MarshalBinary() ([]byte, error) {
+	var buf []byte
+	for _, v := range []*big.Int{k.LockedValue, k.UnlockedValue, k.Proof, k.Nonce} {
+		buf = appendN(buf, v)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (k *Key) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	for _, v := range []**big.Int{&k.LockedValue, &k.UnlockedValue, &k.Proof, &k.Nonce} {
+		x, err := readN(r)
+		if err != nil {
+			return err
+		}
+		*v = x
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("timelock: trailing bytes after the chest key")
+	}
+	return nil
+}